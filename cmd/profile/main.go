@@ -1,125 +1,213 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"runtime"
 	"runtime/pprof"
+	"runtime/trace"
+	"strings"
+	"time"
 
+	"github.com/bioharz/mcp-terminal-tester/internal/bench"
 	"github.com/bioharz/mcp-terminal-tester/internal/session"
-	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+	"github.com/bioharz/mcp-terminal-tester/internal/stress"
+	"github.com/bioharz/mcp-terminal-tester/internal/tools"
 	"github.com/bioharz/mcp-terminal-tester/internal/utils"
 )
 
-// Simple profiling program to identify hot paths
+// stressScenarioName is the scenario that runs internal/stress rather than
+// a bench workload
+const stressScenarioName = "stress"
+
+var (
+	scenario   = flag.String("scenario", "", "Scenario to run: "+strings.Join(append(bench.ScenarioNames(), stressScenarioName), ", "))
+	duration   = flag.Duration("duration", 10*time.Second, "How long the scenario runs")
+	width      = flag.Int("width", 80, "Terminal columns")
+	height     = flag.Int("height", 24, "Terminal rows")
+	fixtures   = flag.String("fixtures", bench.DefaultFixtureDir, "Directory of recorded output (*.bin) for the parse scenario")
+	cpuProfile = flag.String("cpuprofile", "", "Write a CPU profile to this file")
+	memProfile = flag.String("memprofile", "", "Write a heap profile to this file when the scenario ends")
+	traceFile  = flag.String("trace", "", "Write an execution trace to this file")
+
+	stressWorkers = flag.Int("stress.workers", 8, "Concurrent workers for the stress scenario")
+	stressSeed    = flag.Int64("stress.seed", 0, "Seed for the stress scenario; 0 picks one from the clock")
+)
+
+// Runs one profiling scenario and prints its summary as JSON on stdout.
+// Logs and progress go to stderr.
 func main() {
-	// Initialize logger
-	utils.InitLogger()
-	
-	// Create CPU profile
-	cpuFile, err := os.Create("cpu.prof")
-	if err != nil {
-		log.Fatal(err)
+	flag.Parse()
+	if *scenario == "" {
+		flag.Usage()
+		os.Exit(2)
 	}
-	defer cpuFile.Close()
 
-	if err := pprof.StartCPUProfile(cpuFile); err != nil {
-		log.Fatal(err)
-	}
-	defer pprof.StopCPUProfile()
+	utils.InitLogger()
 
-	// Test scenarios that represent common usage patterns
-	testScenario()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
-	// Create memory profile
-	memFile, err := os.Create("mem.prof")
+	stopProfiles, err := startProfiles()
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer memFile.Close()
-
-	if err := pprof.WriteHeapProfile(memFile); err != nil {
+	result, runErr := run(ctx)
+	if err := stopProfiles(); err != nil {
 		log.Fatal(err)
 	}
 
-	log.Println("Profiling completed. Run:")
-	log.Println("go tool pprof cpu.prof")
-	log.Println("go tool pprof mem.prof")
+	// A scenario that failed part way still reports what it got through
+	if result.Scenario != "" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(result); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if runErr != nil {
+		log.Fatal(runErr)
+	}
 }
 
-func testScenario() {
-	// Test 1: Session creation and management
-	manager := session.NewManager()
+func run(ctx context.Context) (bench.Result, error) {
+	if *scenario == stressScenarioName {
+		return stressScenario(ctx)
+	}
+	s, err := bench.Lookup(*scenario)
+	if err != nil {
+		return bench.Result{}, err
+	}
+	log.Printf("Running %s for %s: %s", s.Name, *duration, s.Description)
+	return s.Run(ctx, bench.Config{
+		Duration:   *duration,
+		Width:      *width,
+		Height:     *height,
+		FixtureDir: *fixtures,
+	})
+}
 
-	// Create multiple sessions
-	sessions := make([]*session.Session, 10)
-	for i := 0; i < 10; i++ {
-		sess, err := manager.CreateSession("echo", []string{"test"}, nil)
+// startProfiles starts the CPU profile and trace that were asked for, and
+// returns a function that stops them and writes the heap profile
+func startProfiles() (func() error, error) {
+	var stops []func() error
+	stopAll := func() error {
+		var first error
+		for i := len(stops) - 1; i >= 0; i-- {
+			if err := stops[i](); err != nil && first == nil {
+				first = err
+			}
+		}
+		return first
+	}
+
+	if *cpuProfile != "" {
+		f, err := os.Create(*cpuProfile)
 		if err != nil {
-			log.Printf("Failed to create session %d: %v", i, err)
-			continue
+			return nil, err
 		}
-		sessions[i] = sess
-	}
-
-	// Test 2: Screen buffer operations (hot path)
-	buffer := terminal.NewScreenBuffer(80, 24)
-	
-	// Simulate heavy buffer usage
-	for i := 0; i < 1000; i++ {
-		// Write data to buffer (common operation)
-		data := []byte("This is test data with ANSI sequences \033[31mRed\033[0m\n")
-		buffer.Write(data)
-		
-		// Render buffer (very common operation)
-		buffer.Render("plain")
-		buffer.Render("raw")
-		
-		// Move cursor around
-		buffer.MoveCursor(i%80, i%24)
-		
-		// Clear operations
-		if i%100 == 0 {
-			buffer.Clear()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, err
 		}
+		stops = append(stops, func() error {
+			pprof.StopCPUProfile()
+			return f.Close()
+		})
 	}
-
-	// Test 3: ANSI parsing (hot path)
-	parser := terminal.NewANSIParser(buffer)
-	
-	// Test various ANSI sequences
-	sequences := []string{
-		"\033[31mRed text\033[0m",
-		"\033[1;32mBold green\033[0m",
-		"\033[38;5;196mBright red\033[0m",
-		"\033[2J\033[H",
-		"\033[10;20H",
-		"\033[K",
-		"\033[s\033[u",
-	}
-	
-	for i := 0; i < 1000; i++ {
-		for _, seq := range sequences {
-			parser.Parse([]byte(seq))
+	if *traceFile != "" {
+		f, err := os.Create(*traceFile)
+		if err != nil {
+			stopAll()
+			return nil, err
 		}
+		if err := trace.Start(f); err != nil {
+			f.Close()
+			stopAll()
+			return nil, err
+		}
+		stops = append(stops, func() error {
+			trace.Stop()
+			return f.Close()
+		})
 	}
-
-	// Test 4: Session operations
-	for i := 0; i < 100; i++ {
-		// List sessions (common operation)
-		manager.ListSessions()
-		
-		// Get sessions (very common)
-		for _, sess := range sessions {
-			if sess != nil {
-				manager.GetSession(sess.ID)
+	if *memProfile != "" {
+		path := *memProfile
+		stops = append(stops, func() error {
+			f, err := os.Create(path)
+			if err != nil {
+				return err
 			}
-		}
+			defer f.Close()
+			runtime.GC()
+			return pprof.WriteHeapProfile(f)
+		})
 	}
+	return stopAll, nil
+}
 
-	// Cleanup
-	for _, sess := range sessions {
-		if sess != nil {
-			manager.RemoveSession(sess.ID)
-		}
+// stressScenario churns sessions through the tool handlers from many
+// workers, then checks that no sessions, processes or goroutines leaked.
+// Build with -race to catch data races as well.
+func stressScenario(ctx context.Context) (bench.Result, error) {
+	seed := *stressSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
 	}
-}
\ No newline at end of file
+	log.Printf("Stress run with seed %d, %d workers for %s", seed, *stressWorkers, *duration)
+
+	manager := session.NewManager()
+	call := stress.HandlerCaller(tools.NewHandlers(manager))
+	baseline := runtime.NumGoroutine()
+
+	start := time.Now()
+	report := stress.Run(ctx, stress.Config{
+		Workers:  *stressWorkers,
+		Duration: *duration,
+		Seed:     seed,
+	}, call)
+	elapsed := time.Since(start)
+	log.Printf("Stress run: %s", report)
+
+	var ops int64
+	for _, n := range report.Calls {
+		ops += n
+	}
+	result := bench.Result{
+		Scenario:   stressScenarioName,
+		DurationMs: float64(elapsed) / float64(time.Millisecond),
+		Ops:        ops,
+		OpsPerSec:  float64(ops) / elapsed.Seconds(),
+		Extra: map[string]interface{}{
+			"seed":    seed,
+			"workers": *stressWorkers,
+			"calls":   report.Calls,
+			"errors":  report.Errors,
+		},
+	}
+
+	var failures []error
+	if err := stress.CheckSessions(context.Background(), manager, call); err != nil {
+		failures = append(failures, err)
+	}
+	manager.StopAllSessions()
+	if err := stress.CheckProcesses(report.PIDs, 5*time.Second); err != nil {
+		failures = append(failures, err)
+	}
+	if err := stress.CheckGoroutines(baseline, 5*time.Second); err != nil {
+		failures = append(failures, err)
+	}
+	for _, err := range failures {
+		log.Printf("Stress invariant failed: %v", err)
+	}
+	if len(failures) > 0 {
+		return result, fmt.Errorf("stress run failed %d invariants; reproduce with -scenario=stress -stress.seed=%d -stress.workers=%d -duration=%s",
+			len(failures), seed, *stressWorkers, *duration)
+	}
+	return result, nil
+}
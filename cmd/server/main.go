@@ -29,6 +29,9 @@ func main() {
 		cancel()
 	}()
 
+	// SIGUSR1 toggles debug logging without losing sessions
+	handleDebugToggle()
+
 	// Create and configure MCP server
 	srv, err := mcp.NewServer()
 	if err != nil {
@@ -43,8 +46,10 @@ func main() {
 
 	slog.Info("Starting MCP Terminal Tester", slog.String("mode", "stdio"))
 
-	// Run the server
-	if err := srv.Run(ctx); err != nil {
+	// Run the server, then clean up sessions however it stopped
+	err = srv.Run(ctx)
+	srv.Shutdown()
+	if err != nil {
 		slog.Error("Server error", slog.String("error", err.Error()))
 		os.Exit(1)
 	}
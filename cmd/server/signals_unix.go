@@ -0,0 +1,25 @@
+//go:build !windows
+
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+)
+
+// handleDebugToggle toggles debug logging on each SIGUSR1
+func handleDebugToggle() {
+	usr1Chan := make(chan os.Signal, 1)
+	signal.Notify(usr1Chan, syscall.SIGUSR1)
+
+	go func() {
+		for range usr1Chan {
+			level := utils.ToggleDebug()
+			slog.Warn("Log level toggled", slog.String("log_level", level.String()))
+		}
+	}()
+}
@@ -0,0 +1,7 @@
+//go:build windows
+
+package main
+
+// handleDebugToggle does nothing on Windows, which has no SIGUSR1; use the
+// set_log_level tool instead
+func handleDebugToggle() {}
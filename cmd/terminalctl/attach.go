@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+// detachKey ends an attach session: Ctrl+\
+const detachKey = 0x1c
+
+// errDetached reports that the user pressed the detach key
+var errDetached = errors.New("detached")
+
+// rawChunk is an export_raw_output response
+type rawChunk struct {
+	Data       string `json:"data"`
+	Offset     int64  `json:"offset"`
+	NextOffset int64  `json:"next_offset"`
+	EndOffset  int64  `json:"end_offset"`
+	Truncated  bool   `json:"truncated"`
+	State      string `json:"state"`
+}
+
+func runAttach(ctx context.Context, b *bridge, args []string, out io.Writer) error {
+	fs := newFlagSet("attach", out)
+	interval := fs.Duration("interval", 30*time.Millisecond, "Polling interval for new output")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("attach: expected exactly one session")
+	}
+	return attachTerminal(ctx, b, fs.Arg(0), *interval, out)
+}
+
+// attachTerminal attaches the local terminal to a session until detached
+func attachTerminal(ctx context.Context, b *bridge, sessionID string, interval time.Duration, out io.Writer) error {
+	restore, err := makeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return fmt.Errorf("attach: %w", err)
+	}
+	defer restore()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Size the session to this terminal before painting, and keep it in step
+	syncSize(ctx, b, sessionID)
+	winch := make(chan os.Signal, 1)
+	notifyResize(winch)
+	defer signal.Stop(winch)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-winch:
+				syncSize(ctx, b, sessionID)
+			}
+		}
+	}()
+
+	err = attach(ctx, b, sessionID, os.Stdin, out, interval)
+	restore()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "\n[detached]")
+	return nil
+}
+
+// syncSize resizes the session to match the local terminal. Failures are
+// ignored; the session just keeps its current size.
+func syncSize(ctx context.Context, b *bridge, sessionID string) {
+	rows, cols, err := pty.Getsize(os.Stdin)
+	if err != nil || rows == 0 || cols == 0 {
+		return
+	}
+	b.call(ctx, "resize_terminal", map[string]interface{}{
+		"session_id": sessionID,
+		"width":      cols,
+		"height":     rows,
+	})
+}
+
+// attach mirrors a session's output to out and forwards in to the session,
+// until the detach key is read, in is exhausted or the process exits. The
+// screen is painted once, then only new output is streamed.
+func attach(ctx context.Context, b *bridge, sessionID string, in io.Reader, out io.Writer, interval time.Duration) error {
+	offset, err := paintScreen(ctx, b, sessionID, out)
+	if err != nil {
+		return err
+	}
+
+	inputDone := make(chan error, 1)
+	go func() {
+		inputDone <- forwardInput(ctx, b, sessionID, in)
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		chunk, err := readRaw(ctx, b, sessionID, offset)
+		if err != nil {
+			return err
+		}
+
+		if chunk.Truncated {
+			// Output we never saw was dropped; start again from the screen
+			if offset, err = paintScreen(ctx, b, sessionID, out); err != nil {
+				return err
+			}
+		} else {
+			data, err := base64.StdEncoding.DecodeString(chunk.Data)
+			if err != nil {
+				return fmt.Errorf("unexpected export_raw_output data: %w", err)
+			}
+			out.Write(data)
+			offset = chunk.NextOffset
+		}
+
+		if offset < chunk.EndOffset {
+			// More is waiting; fetch it without sleeping
+			continue
+		}
+		if chunk.State != "active" {
+			fmt.Fprintf(out, "\r\n[session %s]\r\n", chunk.State)
+			return nil
+		}
+
+		select {
+		case err := <-inputDone:
+			if err == errDetached || err == nil {
+				return nil
+			}
+			return err
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// paintScreen clears out and draws the session's current screen and cursor,
+// returning the raw output offset the picture corresponds to
+func paintScreen(ctx context.Context, b *bridge, sessionID string, out io.Writer) (int64, error) {
+	screen, err := viewScreen(ctx, b, sessionID, "raw")
+	if err != nil {
+		return 0, err
+	}
+
+	// The local terminal is in raw mode, so line feeds need carriage returns
+	content := strings.ReplaceAll(screen.Content, "\n", "\r\n")
+	fmt.Fprintf(out, "\x1b[0m\x1b[H\x1b[2J%s\x1b[%d;%dH", content, screen.Cursor.Row+1, screen.Cursor.Col+1)
+	return screen.RawOffset, nil
+}
+
+func readRaw(ctx context.Context, b *bridge, sessionID string, offset int64) (*rawChunk, error) {
+	text, err := b.call(ctx, "export_raw_output", map[string]interface{}{
+		"session_id": sessionID,
+		"since":      offset,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var chunk rawChunk
+	if err := json.Unmarshal([]byte(text), &chunk); err != nil {
+		return nil, fmt.Errorf("unexpected export_raw_output response: %w", err)
+	}
+	return &chunk, nil
+}
+
+// forwardInput sends everything read from in to the session as raw bytes.
+// It returns errDetached when the detach key is read and nil at end of input;
+// anything typed after the detach key is not sent.
+func forwardInput(ctx context.Context, b *bridge, sessionID string, in io.Reader) error {
+	buf := make([]byte, 1024)
+	for {
+		n, err := in.Read(buf)
+		data := buf[:n]
+		detach := false
+		if i := bytes.IndexByte(data, detachKey); i >= 0 {
+			data, detach = data[:i], true
+		}
+
+		if len(data) > 0 {
+			if _, sendErr := b.call(ctx, "send_raw_bytes", map[string]interface{}{
+				"session_id": sessionID,
+				"data":       base64.StdEncoding.EncodeToString(data),
+			}); sendErr != nil {
+				return sendErr
+			}
+		}
+		if detach {
+			return errDetached
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
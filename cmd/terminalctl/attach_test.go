@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dialTestBridge starts a bridge for a test and launches an application in it
+func dialTestBridge(t *testing.T, command string, args ...string) (*bridge, string) {
+	t.Helper()
+	t.Setenv("STATE_DIR", t.TempDir())
+
+	ctx := context.Background()
+	b, err := dialBridge(ctx, bridgeBinary, false, io.Discard)
+	if err != nil {
+		t.Fatalf("Failed to start bridge: %v", err)
+	}
+	t.Cleanup(func() { b.Close() })
+
+	text, err := b.call(ctx, "launch_app", map[string]interface{}{
+		"command": command,
+		"args":    args,
+	})
+	if err != nil {
+		t.Fatalf("Failed to launch %s: %v", command, err)
+	}
+	var resp struct {
+		SessionID string `json:"session_id"`
+	}
+	if err := json.Unmarshal([]byte(text), &resp); err != nil {
+		t.Fatalf("Unexpected launch response %q: %v", text, err)
+	}
+	return b, resp.SessionID
+}
+
+func waitForOutput(t *testing.T, out *syncBuffer, text string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(out.String(), text) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %q, got %q", text, out.String())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestAttach_ForwardsKeysAndDetaches(t *testing.T) {
+	b, sessionID := dialTestBridge(t, "cat")
+	ctx := context.Background()
+
+	inR, inW := io.Pipe()
+	var out syncBuffer
+	done := make(chan error, 1)
+	go func() {
+		done <- attach(ctx, b, sessionID, inR, &out, 10*time.Millisecond)
+	}()
+
+	// The screen is painted before anything is streamed
+	waitForOutput(t, &out, "\x1b[H\x1b[2J")
+
+	// Typed bytes reach the process; its output streams back
+	io.WriteString(inW, "hello\r")
+	waitForOutput(t, &out, "hello\r\nhello\r\n")
+
+	// Bytes before the detach key are sent, bytes after it are not
+	io.WriteString(inW, "last\x1cignored")
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Expected clean detach, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("attach did not return after the detach key")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		screen, err := viewScreen(ctx, b, sessionID, "plain")
+		if err != nil {
+			t.Fatalf("Session should survive detaching: %v", err)
+		}
+		if strings.Contains(screen.Content, "ignored") {
+			t.Fatalf("Bytes after the detach key were sent: %q", screen.Content)
+		}
+		if strings.Contains(screen.Content, "last") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Bytes before the detach key never arrived: %q", screen.Content)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestAttach_StreamsOnlyNewOutput(t *testing.T) {
+	b, sessionID := dialTestBridge(t, "sh", "-c", "echo before; read x; echo after-$x; sleep 10")
+	ctx := context.Background()
+	time.Sleep(200 * time.Millisecond)
+
+	inR, inW := io.Pipe()
+	defer inW.Close()
+	var out syncBuffer
+	go attach(ctx, b, sessionID, inR, &out, 10*time.Millisecond)
+
+	waitForOutput(t, &out, "before")
+	io.WriteString(inW, "go\r")
+	waitForOutput(t, &out, "after-go")
+
+	// Output that was already on screen is painted once, not replayed
+	if n := strings.Count(out.String(), "before"); n != 1 {
+		t.Errorf("Expected existing output once, got %d times in %q", n, out.String())
+	}
+}
+
+func TestAttach_EndsWithSession(t *testing.T) {
+	b, sessionID := dialTestBridge(t, "sh", "-c", "read x; echo bye")
+	ctx := context.Background()
+
+	inR, inW := io.Pipe()
+	defer inW.Close()
+	var out syncBuffer
+	done := make(chan error, 1)
+	go func() {
+		done <- attach(ctx, b, sessionID, inR, &out, 10*time.Millisecond)
+	}()
+
+	waitForOutput(t, &out, "\x1b[H\x1b[2J")
+	io.WriteString(inW, "x\r")
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("attach did not return after the process exited")
+	}
+	if !strings.Contains(out.String(), "bye") || !strings.Contains(out.String(), "[session ") {
+		t.Errorf("Expected final output and an end notice, got %q", out.String())
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// bridge is a connection to a TerminalBridge server
+type bridge struct {
+	client *client.Client
+}
+
+// dialBridge starts the bridge as a subprocess and talks MCP to it over
+// stdio. Sessions live in that subprocess, so they end when the connection
+// is closed. The bridge's log goes to logOut.
+func dialBridge(ctx context.Context, server string, verbose bool, logOut io.Writer) (*bridge, error) {
+	fields := strings.Fields(server)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no server command given")
+	}
+
+	// Keep the bridge quiet unless asked otherwise
+	env := []string{"LOG_LEVEL=warn"}
+	if verbose {
+		env = []string{"LOG_LEVEL=debug"}
+	}
+
+	stdio := transport.NewStdio(fields[0], env, fields[1:]...)
+	c := client.NewClient(stdio)
+	// The bridge must outlive a cancelled command so it can stop its
+	// sessions cleanly when the connection is closed
+	if err := c.Start(context.WithoutCancel(ctx)); err != nil {
+		return nil, fmt.Errorf("failed to start bridge %q: %w", server, err)
+	}
+	go io.Copy(logOut, stdio.Stderr())
+
+	initRequest := mcp.InitializeRequest{}
+	initRequest.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+	initRequest.Params.ClientInfo = mcp.Implementation{
+		Name:    "terminalctl",
+		Version: "1.0.0",
+	}
+	if _, err := c.Initialize(ctx, initRequest); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("failed to initialize bridge: %w", err)
+	}
+
+	return &bridge{client: c}, nil
+}
+
+// call invokes a tool and returns the text of its result
+func (b *bridge) call(ctx context.Context, tool string, args map[string]interface{}) (string, error) {
+	request := mcp.CallToolRequest{}
+	request.Params.Name = tool
+	request.Params.Arguments = args
+
+	result, err := b.client.CallTool(ctx, request)
+	if err != nil {
+		return "", err
+	}
+
+	var text strings.Builder
+	for _, content := range result.Content {
+		if tc, ok := content.(mcp.TextContent); ok {
+			text.WriteString(tc.Text)
+		}
+	}
+	if result.IsError {
+		return "", fmt.Errorf("%s", text.String())
+	}
+	return text.String(), nil
+}
+
+func (b *bridge) Close() error {
+	return b.client.Close()
+}
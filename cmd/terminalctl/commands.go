@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/session"
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+)
+
+// command is a terminalctl subcommand mirroring one of the bridge's tools
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(ctx context.Context, b *bridge, args []string, out io.Writer) error
+}
+
+var commands = []command{
+	{"launch", "launch [-label L] [-group G] [-env K=V]... [-width W] [-height H] [-follow | -attach] command [args...]",
+		"Start an application and print its session ID", runLaunch},
+	{"ls", "ls [-group G]", "List sessions", runList},
+	{"view", "view [-format F] [-follow] [-interval D] session", "Print a session's screen", runView},
+	{"keys", "keys session keys...", "Send keys to a session, one send per argument (e.g. keys s1 ls Enter)", runKeys},
+	{"resize", "resize session width height", "Resize a session's terminal", runResize},
+	{"stop", "stop [-force] session", "Stop a session", runStop},
+	{"attach", "attach [-interval D] session", "Take over a session from this terminal; Ctrl+\\ detaches", runAttach},
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// newFlagSet returns a flag set whose errors are returned rather than
+// exiting, so a bad line in the shell doesn't end it
+func newFlagSet(name string, out io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(out)
+	return fs
+}
+
+// envFlag collects repeated -env KEY=VALUE flags
+type envFlag map[string]interface{}
+
+func (e envFlag) String() string {
+	pairs := make([]string, 0, len(e))
+	for k, v := range e {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", k, v))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (e envFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected KEY=VALUE, got %q", value)
+	}
+	e[key] = val
+	return nil
+}
+
+func runLaunch(ctx context.Context, b *bridge, args []string, out io.Writer) error {
+	fs := newFlagSet("launch", out)
+	label := fs.String("label", "", "Session label")
+	group := fs.String("group", "", "Session group")
+	width := fs.Int("width", 0, "Terminal width in columns")
+	height := fs.Int("height", 0, "Terminal height in rows")
+	follow := fs.Bool("follow", false, "Watch the screen after launching")
+	attach := fs.Bool("attach", false, "Attach this terminal after launching")
+	env := envFlag{}
+	fs.Var(env, "env", "Environment variable as KEY=VALUE (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("launch: command is required")
+	}
+
+	params := map[string]interface{}{
+		"command": fs.Arg(0),
+		"args":    fs.Args()[1:],
+	}
+	if len(env) > 0 {
+		params["env"] = map[string]interface{}(env)
+	}
+	if *label != "" {
+		params["label"] = *label
+	}
+	if *group != "" {
+		params["group"] = *group
+	}
+	if *width > 0 {
+		params["width"] = *width
+	}
+	if *height > 0 {
+		params["height"] = *height
+	}
+
+	text, err := b.call(ctx, "launch_app", params)
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		SessionID string `json:"session_id"`
+		PID       int    `json:"pid"`
+	}
+	if err := json.Unmarshal([]byte(text), &resp); err != nil {
+		return fmt.Errorf("unexpected launch_app response: %w", err)
+	}
+	fmt.Fprintf(out, "%s\tpid %d\n", resp.SessionID, resp.PID)
+
+	if *attach {
+		return attachTerminal(ctx, b, resp.SessionID, 30*time.Millisecond, out)
+	}
+	if *follow {
+		return followScreen(ctx, b, resp.SessionID, "plain", 250*time.Millisecond, out)
+	}
+	return nil
+}
+
+func runList(ctx context.Context, b *bridge, args []string, out io.Writer) error {
+	fs := newFlagSet("ls", out)
+	group := fs.String("group", "", "Only list sessions in this group")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	params := map[string]interface{}{}
+	if *group != "" {
+		params["group"] = *group
+	}
+	text, err := b.call(ctx, "list_sessions", params)
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Sessions []session.SessionInfo `json:"sessions"`
+	}
+	if err := json.Unmarshal([]byte(text), &resp); err != nil {
+		return fmt.Errorf("unexpected list_sessions response: %w", err)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATE\tPID\tGROUP\tCOMMAND")
+	for _, s := range resp.Sessions {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", s.ID, s.State, s.PID, s.Group, s.Command)
+	}
+	return tw.Flush()
+}
+
+func runView(ctx context.Context, b *bridge, args []string, out io.Writer) error {
+	fs := newFlagSet("view", out)
+	format := fs.String("format", "", "Output format: "+strings.Join(terminal.RenderFormats, ", "))
+	follow := fs.Bool("follow", false, "Keep repainting the screen until interrupted")
+	interval := fs.Duration("interval", 250*time.Millisecond, "Polling interval for -follow")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("view: expected exactly one session")
+	}
+	if *format != "" && !validFormat(*format) {
+		return fmt.Errorf("view: format must be one of: %s", strings.Join(terminal.RenderFormats, ", "))
+	}
+
+	if *follow {
+		return followScreen(ctx, b, fs.Arg(0), *format, *interval, out)
+	}
+	screen, err := viewScreen(ctx, b, fs.Arg(0), *format)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, screen.Content)
+	return nil
+}
+
+func validFormat(format string) bool {
+	for _, f := range terminal.RenderFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// screen is a view_screen response. The lines format comes back as Lines
+// rather than Content.
+type screen struct {
+	Content string          `json:"content"`
+	Lines   json.RawMessage `json:"lines"`
+	Cursor  struct {
+		Row int `json:"row"`
+		Col int `json:"col"`
+	} `json:"cursor"`
+	RawOffset int64 `json:"raw_offset"`
+}
+
+func viewScreen(ctx context.Context, b *bridge, sessionID, format string) (*screen, error) {
+	params := map[string]interface{}{"session_id": sessionID}
+	if format != "" {
+		params["format"] = format
+	}
+	text, err := b.call(ctx, "view_screen", params)
+	if err != nil {
+		return nil, err
+	}
+	var resp screen
+	if err := json.Unmarshal([]byte(text), &resp); err != nil {
+		return nil, fmt.Errorf("unexpected view_screen response: %w", err)
+	}
+	if resp.Lines != nil {
+		resp.Content = string(resp.Lines)
+	}
+	return &resp, nil
+}
+
+// followScreen repaints the session's screen whenever it changes, until
+// interrupted or the session goes away
+func followScreen(ctx context.Context, b *bridge, sessionID, format string, interval time.Duration, out io.Writer) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := ""
+	for {
+		screen, err := viewScreen(ctx, b, sessionID, format)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if screen.Content != last {
+			// Clear the screen and home the cursor, then reset attributes
+			// after the content so colors don't leak into the prompt
+			fmt.Fprintf(out, "\x1b[H\x1b[2J%s\x1b[0m\n", screen.Content)
+			last = screen.Content
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func runKeys(ctx context.Context, b *bridge, args []string, out io.Writer) error {
+	if len(args) < 2 {
+		return fmt.Errorf("keys: expected a session and keys")
+	}
+	// Key names such as Enter are only recognised as a whole send
+	for _, keys := range args[1:] {
+		if _, err := b.call(ctx, "send_keys", map[string]interface{}{
+			"session_id": args[0],
+			"keys":       keys,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runResize(ctx context.Context, b *bridge, args []string, out io.Writer) error {
+	if len(args) != 3 {
+		return fmt.Errorf("resize: expected a session, width and height")
+	}
+	width, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("resize: invalid width %q", args[1])
+	}
+	height, err := strconv.Atoi(args[2])
+	if err != nil {
+		return fmt.Errorf("resize: invalid height %q", args[2])
+	}
+	_, err = b.call(ctx, "resize_terminal", map[string]interface{}{
+		"session_id": args[0],
+		"width":      width,
+		"height":     height,
+	})
+	return err
+}
+
+func runStop(ctx context.Context, b *bridge, args []string, out io.Writer) error {
+	fs := newFlagSet("stop", out)
+	force := fs.Bool("force", false, "Kill immediately instead of sending SIGTERM first")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("stop: expected exactly one session")
+	}
+
+	text, err := b.call(ctx, "stop_app", map[string]interface{}{
+		"session_id": fs.Arg(0),
+		"force":      *force,
+	})
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Result string `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(text), &resp); err != nil {
+		return fmt.Errorf("unexpected stop_app response: %w", err)
+	}
+	fmt.Fprintln(out, resp.Result)
+	return nil
+}
@@ -0,0 +1,164 @@
+// Command terminalctl drives a TerminalBridge server from the command line.
+// It starts the bridge as a subprocess and speaks MCP to it over stdio, so
+// sessions last only as long as terminalctl runs; use the shell subcommand to
+// run several commands against the same sessions.
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+func main() {
+	os.Exit(run(context.Background(), os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("terminalctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	server := fs.String("server", defaultServer(), "Command that starts the bridge (env TERMINALCTL_SERVER)")
+	verbose := fs.Bool("v", false, "Show the bridge's debug log")
+	fs.Usage = func() { usage(stderr, fs) }
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	name, cmdArgs := fs.Arg(0), fs.Args()[1:]
+	if name != "shell" {
+		if _, ok := findCommand(name); !ok {
+			fmt.Fprintf(stderr, "terminalctl: unknown command %q\n", name)
+			fs.Usage()
+			return 2
+		}
+	}
+
+	logOut := io.Discard
+	if *verbose {
+		logOut = stderr
+	}
+	b, err := dialBridge(ctx, *server, *verbose, logOut)
+	if err != nil {
+		fmt.Fprintf(stderr, "terminalctl: %v\n", err)
+		return 1
+	}
+	defer b.Close()
+
+	if name == "shell" {
+		return runShell(ctx, b, stdin, stdout, stderr)
+	}
+	if err := dispatch(ctx, b, name, cmdArgs, stdout); err != nil {
+		fmt.Fprintf(stderr, "terminalctl: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func defaultServer() string {
+	if server := os.Getenv("TERMINALCTL_SERVER"); server != "" {
+		return server
+	}
+	return "terminalbridge"
+}
+
+func usage(out io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintln(out, "Usage: terminalctl [-server CMD] [-v] <command> [args]")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-8s %s\n", cmd.name, cmd.summary)
+		fmt.Fprintf(out, "           %s\n", cmd.usage)
+	}
+	fmt.Fprintf(out, "  %-8s %s\n", "shell", "Read commands from stdin, one per line, sharing one bridge")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Flags:")
+	fs.PrintDefaults()
+}
+
+func dispatch(ctx context.Context, b *bridge, name string, args []string, out io.Writer) error {
+	cmd, ok := findCommand(name)
+	if !ok {
+		return fmt.Errorf("unknown command %q", name)
+	}
+	return cmd.run(ctx, b, args, out)
+}
+
+// runShell runs commands read from stdin against one bridge, so sessions
+// launched by one line can be used by the next. Errors are reported and the
+// shell carries on; the exit status is 1 if any command failed.
+func runShell(ctx context.Context, b *bridge, stdin io.Reader, stdout, stderr io.Writer) int {
+	status := 0
+	scanner := bufio.NewScanner(stdin)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words, err := splitWords(line)
+		if err == nil && len(words) > 0 {
+			err = dispatch(ctx, b, words[0], words[1:], stdout)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "terminalctl: %v\n", err)
+			status = 1
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(stderr, "terminalctl: %v\n", err)
+		return 1
+	}
+	return status
+}
+
+// splitWords splits a shell line into words, honouring single and double
+// quotes and backslash escapes
+func splitWords(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == '\'':
+			word.WriteRune(r)
+		case r == '\\' && i+1 < len(runes):
+			i++
+			word.WriteRune(runes[i])
+			inWord = true
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// bridgeBinary is the TerminalBridge server built for these tests
+var bridgeBinary string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "terminalctl-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	bridgeBinary = filepath.Join(dir, "terminalbridge")
+	build := exec.Command("go", "build", "-o", bridgeBinary, "../server")
+	build.Stderr = os.Stderr
+	if err := build.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to build bridge: %v\n", err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// syncBuffer is a bytes.Buffer safe to read while a command writes to it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func runCtl(t *testing.T, ctx context.Context, stdin io.Reader, args ...string) (int, string, string) {
+	t.Helper()
+	t.Setenv("STATE_DIR", t.TempDir())
+	var stdout, stderr syncBuffer
+	code := run(ctx, append([]string{"-server", bridgeBinary}, args...), stdin, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{"ls", []string{"ls"}, false},
+		{"  keys  s1   hello ", []string{"keys", "s1", "hello"}, false},
+		{`launch sh -c 'echo hi; sleep 1'`, []string{"launch", "sh", "-c", "echo hi; sleep 1"}, false},
+		{`keys s1 "say \"hi\""`, []string{"keys", "s1", `say "hi"`}, false},
+		{`keys s1 a\ b`, []string{"keys", "s1", "a b"}, false},
+		{`keys s1 ''`, []string{"keys", "s1", ""}, false},
+		{`keys s1 'open`, nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := splitWords(tt.line)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected error", tt.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	code, _, stderr := runCtl(t, context.Background(), nil)
+	if code != 2 || !strings.Contains(stderr, "Usage:") {
+		t.Errorf("Expected usage with status 2, got %d: %s", code, stderr)
+	}
+
+	code, _, stderr = runCtl(t, context.Background(), nil, "frobnicate")
+	if code != 2 || !strings.Contains(stderr, `unknown command "frobnicate"`) {
+		t.Errorf("Expected unknown command error, got %d: %s", code, stderr)
+	}
+
+	var stdout, errOut syncBuffer
+	code = run(context.Background(), []string{"-server", "/nonexistent/bridge", "ls"}, nil, &stdout, &errOut)
+	if code != 1 || !strings.Contains(errOut.String(), "failed to start bridge") {
+		t.Errorf("Expected bridge start failure, got %d: %s", code, errOut.String())
+	}
+}
+
+func TestList(t *testing.T) {
+	code, stdout, stderr := runCtl(t, context.Background(), nil, "ls")
+	if code != 0 {
+		t.Fatalf("ls failed with %d: %s", code, stderr)
+	}
+	if !strings.HasPrefix(stdout, "ID") || !strings.Contains(stdout, "COMMAND") {
+		t.Errorf("Expected table header, got %q", stdout)
+	}
+}
+
+func TestShell(t *testing.T) {
+	stdinR, stdinW := io.Pipe()
+	var stdout, stderr syncBuffer
+	t.Setenv("STATE_DIR", t.TempDir())
+
+	done := make(chan int, 1)
+	go func() {
+		done <- run(context.Background(), []string{"-server", bridgeBinary, "shell"}, stdinR, &stdout, &stderr)
+	}()
+
+	send := func(line string) {
+		t.Helper()
+		if _, err := io.WriteString(stdinW, line+"\n"); err != nil {
+			t.Fatalf("Failed to write %q: %v", line, err)
+		}
+	}
+	waitFor := func(text string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !strings.Contains(stdout.String(), text) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %q; stdout: %q stderr: %q", text, stdout.String(), stderr.String())
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	send(`launch -label ctl -width 60 -height 10 sh -c 'read line; echo "got $line"; sleep 10'`)
+	waitFor("pid ")
+	send("ls")
+	waitFor("STATE")
+	send("resize ctl 70 12")
+	send("keys ctl hello Enter")
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(stdout.String(), "got hello") {
+		if time.Now().After(deadline) || strings.Contains(stderr.String(), "terminalctl:") {
+			t.Fatalf("Keys never echoed; stdout: %q stderr: %q", stdout.String(), stderr.String())
+		}
+		send("view -format plain ctl")
+		time.Sleep(100 * time.Millisecond)
+	}
+	send("stop -force ctl")
+	waitFor("killed")
+	send("view ctl")
+	stdinW.Close()
+
+	select {
+	case code := <-done:
+		// The final view of a stopped session fails
+		if code != 1 || !strings.Contains(stderr.String(), "session not found") {
+			t.Errorf("Expected the last command's error, got %d: %s", code, stderr.String())
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Shell did not exit after stdin closed")
+	}
+}
+
+func TestViewFollow(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	code, stdout, stderr := runCtl(t, ctx, nil,
+		"launch", "-follow", "sh", "-c", "echo first; sleep 0.5; echo second; sleep 10")
+	if code != 0 {
+		t.Fatalf("launch -follow failed with %d: %s", code, stderr)
+	}
+
+	// Each change is repainted from a cleared screen
+	if n := strings.Count(stdout, "\x1b[H\x1b[2J"); n < 2 {
+		t.Errorf("Expected at least 2 repaints, got %d: %q", n, stdout)
+	}
+	if !strings.Contains(stdout, "second") {
+		t.Errorf("Expected later output in follow mode, got %q", stdout)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// makeRaw is not supported without termios
+func makeRaw(fd int) (func() error, error) {
+	return nil, fmt.Errorf("attach is not supported on this platform")
+}
+
+// notifyResize does nothing without SIGWINCH
+func notifyResize(c chan<- os.Signal) {}
@@ -0,0 +1,318 @@
+// Package analyzer finds the parts of a terminal screen an agent acts on:
+// a shell prompt waiting for a command, highlighted menu selections, boxed
+// dialogs and panes, and input fields. It works from the cell grid alone,
+// so its findings are heuristic guesses, not what the application meant.
+package analyzer
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+)
+
+// Element kinds
+const (
+	KindPrompt    = "prompt"    // A shell prompt with the cursor right after it
+	KindHighlight = "highlight" // A run of reverse video, such as a menu selection
+	KindBox       = "box"       // A rectangle drawn with line or ASCII box characters
+	KindInput     = "input"     // An input field at the cursor
+)
+
+// Screen is what the analyzer looks at
+type Screen struct {
+	Cells         [][]terminal.Cell // By row
+	CursorRow     int
+	CursorCol     int
+	CursorVisible bool // Prompts and input fields are only looked for at a visible cursor
+}
+
+// Element is something found on the screen. Coordinates are 0-based cells.
+type Element struct {
+	Kind   string `json:"kind"`
+	Row    int    `json:"row"`
+	Col    int    `json:"col"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Text   string `json:"text"`            // The element's text; a box's inner lines, joined with newlines
+	Label  string `json:"label,omitempty"` // For an input field, the text before it
+}
+
+// Analyze runs every heuristic over the screen and returns what they found,
+// top to bottom and left to right
+func Analyze(screen Screen) []Element {
+	elements := []Element{}
+	elements = append(elements, findHighlights(screen)...)
+	elements = append(elements, findBoxes(screen)...)
+	if screen.CursorVisible {
+		if prompt, ok := findPrompt(screen); ok {
+			elements = append(elements, prompt)
+		} else if input, ok := findInput(screen); ok {
+			elements = append(elements, input)
+		}
+	}
+	sort.SliceStable(elements, func(i, j int) bool {
+		if elements[i].Row != elements[j].Row {
+			return elements[i].Row < elements[j].Row
+		}
+		return elements[i].Col < elements[j].Col
+	})
+	return elements
+}
+
+// rowText returns the text of cells from through to (exclusive) on row y
+func (s Screen) rowText(y, from, to int) string {
+	var b strings.Builder
+	for x := from; x < to; x++ {
+		r := s.Cells[y][x].Rune
+		if r == 0 {
+			r = ' '
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// rune returns the character at row y, column x, or 0 off the screen
+func (s Screen) rune(y, x int) rune {
+	if y < 0 || y >= len(s.Cells) || x < 0 || x >= len(s.Cells[y]) {
+		return 0
+	}
+	return s.Cells[y][x].Rune
+}
+
+// promptEnds are the characters shell prompts commonly end with
+const promptEnds = "$#>%"
+
+// findPrompt looks for a shell prompt: the last line with text ends with
+// one of promptEnds, perhaps followed by a space, and the cursor sits
+// right after it
+func findPrompt(s Screen) (Element, bool) {
+	last := -1
+	for y := len(s.Cells) - 1; y >= 0; y-- {
+		if strings.TrimSpace(s.rowText(y, 0, len(s.Cells[y]))) != "" {
+			last = y
+			break
+		}
+	}
+	if last < 0 || last != s.CursorRow {
+		return Element{}, false
+	}
+	text := []rune(strings.TrimRight(s.rowText(last, 0, len(s.Cells[last])), " "))
+	if !strings.ContainsRune(promptEnds, text[len(text)-1]) {
+		return Element{}, false
+	}
+	// The cursor is right of the prompt's last character, or one space
+	// further
+	width := len(text)
+	if s.CursorCol != width && s.CursorCol != width+1 {
+		return Element{}, false
+	}
+	start := 0
+	for text[start] == ' ' {
+		start++
+	}
+	return Element{Kind: KindPrompt, Row: last, Col: start, Width: width - start, Height: 1, Text: string(text[start:])}, true
+}
+
+// findHighlights reports each run of reverse video cells on a row
+func findHighlights(s Screen) []Element {
+	var found []Element
+	for y, row := range s.Cells {
+		for x := 0; x < len(row); {
+			if !row[x].Attributes.Reverse {
+				x++
+				continue
+			}
+			start := x
+			for x < len(row) && row[x].Attributes.Reverse {
+				x++
+			}
+			found = append(found, Element{
+				Kind:   KindHighlight,
+				Row:    y,
+				Col:    start,
+				Width:  x - start,
+				Height: 1,
+				Text:   strings.TrimSpace(s.rowText(y, start, x)),
+			})
+		}
+	}
+	return found
+}
+
+// Box drawing characters by the part of a box they can be. ASCII boxes are
+// drawn with + corners, - edges and | sides.
+const (
+	topLeft     = "┌╔╭┏╒╓+"
+	topRight    = "┐╗╮┓╕╖+"
+	bottomLeft  = "└╚╰┗╘╙+"
+	bottomRight = "┘╝╯┛╛╜+"
+	horizontal  = "─═━-┬┴╦╩┼╬╤╧╥╨+"
+	vertical    = "│║┃|├┤╠╣╟╢╞╡┼╬+"
+)
+
+func is(set string, r rune) bool {
+	return r != 0 && strings.ContainsRune(set, r)
+}
+
+// findBoxes looks for rectangles with a corner at each end of a horizontal
+// edge, sides down both ends and a matching bottom edge. Nested boxes are
+// each reported.
+func findBoxes(s Screen) []Element {
+	var found []Element
+	for y := range s.Cells {
+		for x := range s.Cells[y] {
+			if box, ok := boxAt(s, y, x); ok {
+				found = append(found, box)
+			}
+		}
+	}
+	return found
+}
+
+// boxAt reports the box whose top left corner is at row y, column x, if
+// there is one
+func boxAt(s Screen, y, x int) (Element, bool) {
+	if !is(topLeft, s.rune(y, x)) {
+		return Element{}, false
+	}
+	// The top edge runs to the first top right corner. An ASCII + could be
+	// either, so the edge needs at least one - between corners.
+	right := x + 1
+	for is(horizontal, s.rune(y, right)) && !(is(topRight, s.rune(y, right)) && right > x+1) {
+		right++
+	}
+	if right == x+1 || !is(topRight, s.rune(y, right)) {
+		return Element{}, false
+	}
+
+	bottom := y + 1
+	for is(vertical, s.rune(bottom, x)) && is(vertical, s.rune(bottom, right)) &&
+		!(is(bottomLeft, s.rune(bottom, x)) && is(bottomRight, s.rune(bottom, right)) && edge(s, bottom, x, right)) {
+		bottom++
+	}
+	if !is(bottomLeft, s.rune(bottom, x)) || !is(bottomRight, s.rune(bottom, right)) || !edge(s, bottom, x, right) {
+		return Element{}, false
+	}
+
+	var inner []string
+	for row := y + 1; row < bottom; row++ {
+		inner = append(inner, strings.TrimSpace(s.rowText(row, x+1, right)))
+	}
+	return Element{
+		Kind:   KindBox,
+		Row:    y,
+		Col:    x,
+		Width:  right - x + 1,
+		Height: bottom - y + 1,
+		Text:   strings.Trim(strings.Join(inner, "\n"), "\n"),
+	}, true
+}
+
+// edge reports whether row y is a horizontal edge between columns from and
+// to (exclusive at both ends)
+func edge(s Screen, y, from, to int) bool {
+	if to-from < 2 {
+		return false
+	}
+	for x := from + 1; x < to; x++ {
+		if !is(horizontal, s.rune(y, x)) {
+			return false
+		}
+	}
+	return true
+}
+
+// findInput looks for an input field at the cursor: a run of underlined or
+// underscore cells, a bracketed area, or blank space after a label ending
+// with a colon
+func findInput(s Screen) (Element, bool) {
+	y, x := s.CursorRow, s.CursorCol
+	if y < 0 || y >= len(s.Cells) || x < 0 || x >= len(s.Cells[y]) {
+		return Element{}, false
+	}
+	row := s.Cells[y]
+
+	var start, end int // The field is columns start through end, exclusive
+	fieldCell := func(c terminal.Cell) bool { return c.Attributes.Underline || c.Rune == '_' }
+	switch {
+	case fieldCell(row[x]) || x > 0 && fieldCell(row[x-1]):
+		// The cursor is in the field, or just past what was typed into it
+		start, end = x, x
+		if !fieldCell(row[x]) {
+			start--
+		}
+		for start > 0 && fieldCell(row[start-1]) {
+			start--
+		}
+		for end < len(row) && fieldCell(row[end]) {
+			end++
+		}
+		end = max(end, x+1)
+	default:
+		open, close := bracketsAround(row, x)
+		label := []rune(strings.TrimRight(s.rowText(y, 0, x), " "))
+		switch {
+		case open >= 0 && close >= 0:
+			start, end = open+1, close
+		case len(label) > 0 && label[len(label)-1] == ':' && strings.TrimSpace(s.rowText(y, x, len(row))) == "":
+			// The field runs from a space after the label to the end of
+			// the row
+			start, end = min(len(label)+1, x), len(row)
+		default:
+			return Element{}, false
+		}
+	}
+
+	return Element{
+		Kind:   KindInput,
+		Row:    y,
+		Col:    start,
+		Width:  end - start,
+		Height: 1,
+		Text:   strings.TrimSpace(strings.Trim(s.rowText(y, start, end), "_ ")),
+		Label:  fieldLabel(s.rowText(y, 0, start)),
+	}, true
+}
+
+// bracketsAround returns the columns of the [ and ] around column x, or -1
+// for both if x isn't between brackets
+func bracketsAround(row []terminal.Cell, x int) (int, int) {
+	open := x
+	for open >= 0 && row[open].Rune != '[' {
+		if row[open].Rune == ']' && open != x {
+			return -1, -1
+		}
+		open--
+	}
+	close := x
+	for close < len(row) && row[close].Rune != ']' {
+		if row[close].Rune == '[' {
+			return -1, -1
+		}
+		close++
+	}
+	if open < 0 || close == len(row) {
+		return -1, -1
+	}
+	return open, close
+}
+
+// sides are the characters a box's sides are drawn with
+const sides = "│║┃|"
+
+// fieldLabel picks the label out of the text before a field: the last
+// stretch of text, after any box side or run of two spaces
+func fieldLabel(before string) string {
+	runes := []rune(strings.TrimRight(before, " ["))
+	start := len(runes)
+	for start > 0 {
+		r := runes[start-1]
+		if is(sides, r) || r == ' ' && start > 1 && runes[start-2] == ' ' {
+			break
+		}
+		start--
+	}
+	return strings.TrimSpace(string(runes[start:]))
+}
@@ -0,0 +1,167 @@
+package analyzer
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+)
+
+// fixtureDir holds output recorded from the test apps
+var fixtureDir = filepath.Join("..", "..", "test", "fixtures", "analyzer")
+
+// written returns the screen output leaves on an 80x24 terminal
+func written(t *testing.T, output []byte) Screen {
+	t.Helper()
+	sb := terminal.NewScreenBuffer(80, 24)
+	defer sb.Close()
+	sb.Write(output)
+	cells, x, y := sb.Snapshot()
+	return Screen{Cells: cells, CursorRow: y, CursorCol: x, CursorVisible: sb.Modes().CursorVisible}
+}
+
+// drawn builds a screen from lines directly, with the cursor at row, col
+func drawn(lines []string, row, col int) Screen {
+	cells := make([][]terminal.Cell, len(lines))
+	for y, line := range lines {
+		cells[y] = make([]terminal.Cell, 20)
+		for x := range cells[y] {
+			cells[y][x].Rune = ' '
+		}
+		for x, r := range []rune(line) {
+			cells[y][x].Rune = r
+		}
+	}
+	return Screen{Cells: cells, CursorRow: row, CursorCol: col, CursorVisible: true}
+}
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string // Recorded output in fixtureDir
+		output  string // Or output to write
+		screen  Screen // Or a screen drawn directly
+		want    []Element
+	}{
+		{
+			name:    "menu app selection",
+			fixture: "menu_main.bin",
+			want: []Element{
+				{Kind: KindHighlight, Row: 8, Col: 0, Width: 22, Height: 1, Text: "▶ Show System Info"},
+			},
+		},
+		{
+			name:    "vim app status line",
+			fixture: "vim_startup.bin",
+			want: []Element{
+				{Kind: KindHighlight, Row: 21, Col: 0, Width: 80, Height: 1, Text: "/tmp/anl/notes.txt"},
+			},
+		},
+		{
+			name:   "shell prompt",
+			output: "$ ls\r\nfile.txt\r\nuser@host:~$ ",
+			want: []Element{
+				{Kind: KindPrompt, Row: 2, Col: 0, Width: 12, Height: 1, Text: "user@host:~$"},
+			},
+		},
+		{
+			name:   "python prompt",
+			output: ">>> ",
+			want: []Element{
+				{Kind: KindPrompt, Row: 0, Col: 0, Width: 3, Height: 1, Text: ">>>"},
+			},
+		},
+		{
+			name:   "prompt character away from the cursor",
+			output: "cost: 5$\r\n",
+			want:   []Element{},
+		},
+		{
+			name:   "hidden cursor",
+			output: "\x1b[?25l$ ",
+			want:   []Element{},
+		},
+		{
+			name:   "ASCII box",
+			output: "+------+\r\n| OK   |\r\n+------+\x1b[?25l",
+			want: []Element{
+				{Kind: KindBox, Row: 0, Col: 0, Width: 8, Height: 3, Text: "OK"},
+			},
+		},
+		{
+			name: "nested line drawing boxes",
+			screen: drawn([]string{
+				"╔══════════╗",
+				"║ Settings ║",
+				"║ ┌──────┐ ║",
+				"║ │ Save │ ║",
+				"║ └──────┘ ║",
+				"╚══════════╝",
+			}, 3, 5),
+			want: []Element{
+				{Kind: KindBox, Row: 0, Col: 0, Width: 12, Height: 6, Text: "Settings\n┌──────┐\n│ Save │\n└──────┘"},
+				{Kind: KindBox, Row: 2, Col: 2, Width: 8, Height: 3, Text: "Save"},
+			},
+		},
+		{
+			name:   "unclosed box",
+			screen: drawn([]string{"┌────┐", "│    │", "│    "}, 2, 10),
+			want:   []Element{},
+		},
+		{
+			name:   "underscore field",
+			output: "Name: ____\x1b[1;7H",
+			want: []Element{
+				{Kind: KindInput, Row: 0, Col: 6, Width: 4, Height: 1, Text: "", Label: "Name:"},
+			},
+		},
+		{
+			name:   "underlined field with text typed",
+			output: "Menu  User: \x1b[4mbob     \x1b[0m\x1b[1;16H",
+			want: []Element{
+				{Kind: KindInput, Row: 0, Col: 12, Width: 8, Height: 1, Text: "bob", Label: "User:"},
+			},
+		},
+		{
+			name:   "bracketed field",
+			output: "Search: [foo     ]\x1b[1;13H",
+			want: []Element{
+				{Kind: KindInput, Row: 0, Col: 9, Width: 8, Height: 1, Text: "foo", Label: "Search:"},
+			},
+		},
+		{
+			name:   "label with blank space",
+			output: "Password: ",
+			want: []Element{
+				{Kind: KindInput, Row: 0, Col: 10, Width: 70, Height: 1, Text: "", Label: "Password:"},
+			},
+		},
+		{
+			name:   "plain text",
+			output: "hello world",
+			want:   []Element{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			screen := tt.screen
+			switch {
+			case tt.fixture != "":
+				data, err := os.ReadFile(filepath.Join(fixtureDir, tt.fixture))
+				if err != nil {
+					t.Fatal(err)
+				}
+				screen = written(t, data)
+			case tt.output != "":
+				screen = written(t, []byte(tt.output))
+			}
+
+			if got := Analyze(screen); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
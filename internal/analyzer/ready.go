@@ -0,0 +1,163 @@
+package analyzer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+)
+
+// DefaultPromptPattern matches the end of common shell and REPL prompts:
+// "$ ", "# ", "% ", "> ", ">>> ", "Password: ", "Continue? " and "(gdb) "
+var DefaultPromptPattern = regexp.MustCompile(`[$#%>:?] ?$|\([\w-]+\) ?$`)
+
+// Readiness signals and how much each adds to the confidence
+const (
+	SignalIdle          = "idle"           // No output for the minimum idle time
+	SignalCursorVisible = "cursor_visible" // The application shows the cursor
+	SignalPrompt        = "prompt"         // The cursor's line matches the prompt pattern
+	SignalProcess       = "process"        // No process is in uninterruptible sleep or stopped
+
+	weightIdle          = 0.3
+	weightCursorVisible = 0.2
+	weightPrompt        = 0.3
+	weightProcess       = 0.2
+)
+
+// ReadyConfidence is the confidence at which an idle application whose
+// processes can run is reported ready: with the cursor visible, a prompt or
+// both
+const ReadyConfidence = weightIdle + weightProcess + weightCursorVisible
+
+// ReadyInput is what Ready judges from
+type ReadyInput struct {
+	Screen    Screen
+	Idle      time.Duration           // Since the application last wrote output
+	MinIdle   time.Duration           // How long it must have been quiet
+	Prompt    *regexp.Regexp          // Matched against the cursor's line up to the cursor; nil for DefaultPromptPattern
+	Exited    bool                    // The process has exited
+	Processes []terminal.ProcessEntry // The process and its group; nil if their states are unknown
+}
+
+// Signal is one readiness signal and whether it matched
+type Signal struct {
+	Matched bool    `json:"matched"`
+	Weight  float64 `json:"weight"`
+	Detail  string  `json:"detail"`
+}
+
+// Readiness is Ready's verdict with the signals behind it
+type Readiness struct {
+	Ready      bool              `json:"ready"`
+	Confidence float64           `json:"confidence"` // Sum of the matched signals' weights
+	Signals    map[string]Signal `json:"signals"`
+}
+
+// Ready guesses whether the application is waiting for input. It must be
+// idle with no blocked process, and the cursor must be visible, after a
+// prompt, or both.
+func Ready(in ReadyInput) Readiness {
+	signals := map[string]Signal{
+		SignalIdle:          idleSignal(in.Idle, in.MinIdle),
+		SignalCursorVisible: cursorSignal(in.Screen.CursorVisible),
+		SignalPrompt:        promptSignal(in.Screen, in.Prompt),
+		SignalProcess:       processSignal(in.Exited, in.Processes),
+	}
+
+	var confidence float64
+	for _, signal := range signals {
+		if signal.Matched {
+			confidence += signal.Weight
+		}
+	}
+	// Keep sums like 0.3+0.2+0.2 from printing as 0.7000000000000001
+	confidence = float64(int(confidence*100+0.5)) / 100
+
+	return Readiness{
+		Ready:      signals[SignalIdle].Matched && signals[SignalProcess].Matched && confidence >= ReadyConfidence,
+		Confidence: confidence,
+		Signals:    signals,
+	}
+}
+
+func idleSignal(idle, minIdle time.Duration) Signal {
+	return Signal{
+		Matched: idle >= minIdle,
+		Weight:  weightIdle,
+		Detail:  fmt.Sprintf("no output for %d ms, %d ms needed", idle.Milliseconds(), minIdle.Milliseconds()),
+	}
+}
+
+func cursorSignal(visible bool) Signal {
+	detail := "cursor hidden"
+	if visible {
+		detail = "cursor visible"
+	}
+	return Signal{Matched: visible, Weight: weightCursorVisible, Detail: detail}
+}
+
+// promptSignal matches the cursor's line, up to the cursor, against the
+// prompt pattern
+func promptSignal(s Screen, prompt *regexp.Regexp) Signal {
+	if prompt == nil {
+		prompt = DefaultPromptPattern
+	}
+	signal := Signal{Weight: weightPrompt}
+	y := s.CursorRow
+	if y < 0 || y >= len(s.Cells) {
+		signal.Detail = "cursor is off the screen"
+		return signal
+	}
+	// A cursor past the last column is waiting to wrap
+	x := min(max(s.CursorCol, 0), len(s.Cells[y]))
+	line := s.rowText(y, 0, x)
+	signal.Matched = prompt.MatchString(line) && strings.TrimSpace(line) != ""
+	if signal.Matched {
+		signal.Detail = fmt.Sprintf("%q matches %s", line, prompt)
+	} else {
+		signal.Detail = fmt.Sprintf("%q doesn't match %s", line, prompt)
+	}
+	return signal
+}
+
+// processSignal checks that the process is running and that nothing in its
+// group is stuck in uninterruptible sleep or stopped, which no input can get
+// past. Unknown states count as matched, so a session can be ready on
+// platforms without process details.
+func processSignal(exited bool, processes []terminal.ProcessEntry) Signal {
+	signal := Signal{Weight: weightProcess}
+	switch {
+	case exited:
+		signal.Detail = "process has exited"
+		return signal
+	case len(processes) == 0:
+		signal.Matched = true
+		signal.Detail = "process states unknown"
+		return signal
+	}
+
+	states := make([]string, 0, len(processes))
+	var blocked []string
+	for _, p := range processes {
+		switch p.State {
+		case "":
+			continue
+		case "D", "T", "t":
+			blocked = append(blocked, fmt.Sprintf("%d (%s)", p.PID, p.State))
+		}
+		states = append(states, fmt.Sprintf("%d %s", p.PID, p.State))
+	}
+	switch {
+	case len(blocked) > 0:
+		signal.Detail = "blocked or stopped: " + strings.Join(blocked, ", ")
+	case len(states) == 0:
+		signal.Matched = true
+		signal.Detail = "process states unknown"
+	default:
+		signal.Matched = true
+		signal.Detail = "states: " + strings.Join(states, ", ")
+	}
+	return signal
+}
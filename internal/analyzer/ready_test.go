@@ -0,0 +1,156 @@
+package analyzer
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+)
+
+// echoStartup is what the echo test app prints before waiting at its "> "
+// prompt
+const echoStartup = "Echo Test Application\r\nType 'quit' or 'exit' to stop\r\n" +
+	"Type 'clear' to clear the screen\r\nType 'color' to test ANSI colors\r\n" +
+	"------------------------------\r\n> "
+
+func TestReady(t *testing.T) {
+	sleeping := []terminal.ProcessEntry{{PID: 10, State: "S"}}
+	tests := []struct {
+		name       string
+		output     string
+		idle       time.Duration
+		prompt     string // Pattern; empty for the default
+		exited     bool
+		processes  []terminal.ProcessEntry
+		ready      bool
+		confidence float64
+		matched    []string // Signals expected to match
+	}{
+		{
+			name:       "echo app at its prompt",
+			output:     echoStartup,
+			idle:       time.Second,
+			processes:  sleeping,
+			ready:      true,
+			confidence: 1,
+			matched:    []string{SignalIdle, SignalCursorVisible, SignalPrompt, SignalProcess},
+		},
+		{
+			name:       "echo app still writing",
+			output:     echoStartup,
+			idle:       50 * time.Millisecond,
+			processes:  sleeping,
+			confidence: 0.7,
+			matched:    []string{SignalCursorVisible, SignalPrompt, SignalProcess},
+		},
+		{
+			name:       "echo app after typing",
+			output:     echoStartup + "hello",
+			idle:       time.Second,
+			processes:  sleeping,
+			ready:      true,
+			confidence: 0.7,
+			matched:    []string{SignalIdle, SignalCursorVisible, SignalProcess},
+		},
+		{
+			name:       "echo app with a session pattern",
+			output:     echoStartup + "hello",
+			idle:       time.Second,
+			prompt:     `^> `,
+			processes:  sleeping,
+			ready:      true,
+			confidence: 1,
+			matched:    []string{SignalIdle, SignalCursorVisible, SignalPrompt, SignalProcess},
+		},
+		{
+			name:       "pattern that doesn't fit",
+			output:     echoStartup,
+			idle:       time.Second,
+			prompt:     `\$ $`,
+			processes:  sleeping,
+			ready:      true,
+			confidence: 0.7,
+			matched:    []string{SignalIdle, SignalCursorVisible, SignalProcess},
+		},
+		{
+			name:       "hidden cursor at a prompt",
+			output:     "\x1b[?25l" + echoStartup,
+			idle:       time.Second,
+			processes:  sleeping,
+			ready:      true,
+			confidence: 0.8,
+			matched:    []string{SignalIdle, SignalPrompt, SignalProcess},
+		},
+		{
+			name:       "hidden cursor without a prompt",
+			output:     "\x1b[?25lLoading",
+			idle:       time.Second,
+			processes:  sleeping,
+			confidence: 0.5,
+			matched:    []string{SignalIdle, SignalProcess},
+		},
+		{
+			name:       "child in uninterruptible sleep",
+			output:     echoStartup,
+			idle:       time.Second,
+			processes:  []terminal.ProcessEntry{{PID: 10, State: "S"}, {PID: 11, State: "D"}},
+			confidence: 0.8,
+			matched:    []string{SignalIdle, SignalCursorVisible, SignalPrompt},
+		},
+		{
+			name:       "stopped process",
+			output:     echoStartup,
+			idle:       time.Second,
+			processes:  []terminal.ProcessEntry{{PID: 10, State: "T"}},
+			confidence: 0.8,
+			matched:    []string{SignalIdle, SignalCursorVisible, SignalPrompt},
+		},
+		{
+			name:       "exited",
+			output:     echoStartup,
+			idle:       time.Second,
+			exited:     true,
+			confidence: 0.8,
+			matched:    []string{SignalIdle, SignalCursorVisible, SignalPrompt},
+		},
+		{
+			name:       "process states unknown",
+			output:     echoStartup,
+			idle:       time.Second,
+			ready:      true,
+			confidence: 1,
+			matched:    []string{SignalIdle, SignalCursorVisible, SignalPrompt, SignalProcess},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var prompt *regexp.Regexp
+			if tt.prompt != "" {
+				prompt = regexp.MustCompile(tt.prompt)
+			}
+			got := Ready(ReadyInput{
+				Screen:    written(t, []byte(tt.output)),
+				Idle:      tt.idle,
+				MinIdle:   200 * time.Millisecond,
+				Prompt:    prompt,
+				Exited:    tt.exited,
+				Processes: tt.processes,
+			})
+
+			if got.Ready != tt.ready || got.Confidence != tt.confidence {
+				t.Errorf("Expected ready %v with confidence %v, got %v with %v: %+v", tt.ready, tt.confidence, got.Ready, got.Confidence, got.Signals)
+			}
+			matched := map[string]bool{}
+			for _, name := range tt.matched {
+				matched[name] = true
+			}
+			for name, signal := range got.Signals {
+				if signal.Matched != matched[name] {
+					t.Errorf("Expected signal %s matched %v, got %+v", name, matched[name], signal)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+// Package bench holds the workloads behind cmd/profile's scenarios and the
+// go test benchmarks next to it, so a profile and a benchmark of the same
+// hot path measure the same thing. Each workload is set up once and then
+// repeats one operation: until a duration is up for a scenario, b.N times
+// for a benchmark.
+package bench
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultFixtureDir is where recorded program output lives, relative to
+// the repository root
+const DefaultFixtureDir = "test/fixtures"
+
+// Config sets the shape of a run
+type Config struct {
+	Duration   time.Duration // How long a scenario runs
+	Width      int           // Terminal columns, defaults to 80
+	Height     int           // Terminal rows, defaults to 24
+	FixtureDir string        // Recorded output for the parse scenario, defaults to DefaultFixtureDir
+}
+
+func (c Config) withDefaults() Config {
+	if c.Width <= 0 {
+		c.Width = 80
+	}
+	if c.Height <= 0 {
+		c.Height = 24
+	}
+	if c.FixtureDir == "" {
+		c.FixtureDir = DefaultFixtureDir
+	}
+	return c
+}
+
+// Result summarizes a scenario run. It is encoded as JSON so CI can track
+// trends across runs.
+type Result struct {
+	Scenario   string                 `json:"scenario"`
+	Width      int                    `json:"width"`
+	Height     int                    `json:"height"`
+	DurationMs float64                `json:"duration_ms"`
+	Ops        int64                  `json:"ops"`
+	Bytes      int64                  `json:"bytes"`
+	OpsPerSec  float64                `json:"ops_per_sec"`
+	MBPerSec   float64                `json:"mb_per_sec"`
+	Extra      map[string]interface{} `json:"extra,omitempty"`
+}
+
+// workload is a set-up scenario. op runs one operation and returns the
+// bytes it processed.
+type workload struct {
+	op    func() (int64, error)
+	close func()
+	extra func() map[string]interface{} // Scenario-specific figures for the result, if any
+}
+
+// Scenario is a named workload
+type Scenario struct {
+	Name        string
+	Description string
+	setup       func(cfg Config) (*workload, error)
+}
+
+// Scenarios lists every scenario by name
+var Scenarios = map[string]Scenario{
+	"parse": {
+		Name:        "parse",
+		Description: "Feed recorded program output through the screen buffer's parser",
+		setup:       setupParse,
+	},
+	"render": {
+		Name:        "render",
+		Description: "Render a full colored screen in the plain, raw and ansi formats",
+		setup:       setupRender,
+	},
+	"scrollback": {
+		Name:        "scrollback",
+		Description: "Scroll output through a screen with full scrollback and render the history",
+		setup:       setupScrollback,
+	},
+	"sessions": {
+		Name:        "sessions",
+		Description: "Launch, type into, read and stop sessions running cat",
+		setup:       setupSessions,
+	},
+	"pty-throughput": {
+		Name:        "pty-throughput",
+		Description: "Stream a real child's output through the PTY, read loop and screen buffer",
+		setup:       setupPTYThroughput,
+	},
+}
+
+// ScenarioNames returns the scenario names, sorted
+func ScenarioNames() []string {
+	names := make([]string, 0, len(Scenarios))
+	for name := range Scenarios {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lookup returns the named scenario
+func Lookup(name string) (Scenario, error) {
+	scenario, ok := Scenarios[name]
+	if !ok {
+		return Scenario{}, fmt.Errorf("unknown scenario %q, valid scenarios: %s", name, strings.Join(ScenarioNames(), ", "))
+	}
+	return scenario, nil
+}
+
+// Run repeats the scenario's operation until cfg.Duration is up or ctx
+// ends, and reports how much it got through
+func (s Scenario) Run(ctx context.Context, cfg Config) (Result, error) {
+	cfg = cfg.withDefaults()
+	w, err := s.setup(cfg)
+	if err != nil {
+		return Result{}, fmt.Errorf("%s: %w", s.Name, err)
+	}
+	defer w.close()
+
+	result := Result{Scenario: s.Name, Width: cfg.Width, Height: cfg.Height}
+	start := time.Now()
+	deadline := start.Add(cfg.Duration)
+	for ctx.Err() == nil && time.Now().Before(deadline) {
+		n, err := w.op()
+		if err != nil {
+			return result, fmt.Errorf("%s: operation %d: %w", s.Name, result.Ops+1, err)
+		}
+		result.Ops++
+		result.Bytes += n
+	}
+	elapsed := time.Since(start)
+
+	result.DurationMs = float64(elapsed) / float64(time.Millisecond)
+	if secs := elapsed.Seconds(); secs > 0 {
+		result.OpsPerSec = float64(result.Ops) / secs
+		result.MBPerSec = float64(result.Bytes) / 1e6 / secs
+	}
+	if w.extra != nil {
+		result.Extra = w.extra()
+	}
+	return result, nil
+}
@@ -0,0 +1,63 @@
+package bench
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+)
+
+// fixtureDir is DefaultFixtureDir as seen from this package
+const fixtureDir = "../../" + DefaultFixtureDir
+
+// benchmarkScenario runs the scenario's operation b.N times, the same
+// operation cmd/profile repeats
+func benchmarkScenario(b *testing.B, name string) {
+	utils.InitLogger()
+	w, err := Scenarios[name].setup(Config{FixtureDir: fixtureDir}.withDefaults())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer w.close()
+
+	var bytes int64
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		n, err := w.op()
+		if err != nil {
+			b.Fatal(err)
+		}
+		bytes += n
+	}
+	b.ReportMetric(float64(bytes)/1e6/b.Elapsed().Seconds(), "MB/s")
+}
+
+func BenchmarkParse(b *testing.B)         { benchmarkScenario(b, "parse") }
+func BenchmarkRender(b *testing.B)        { benchmarkScenario(b, "render") }
+func BenchmarkScrollback(b *testing.B)    { benchmarkScenario(b, "scrollback") }
+func BenchmarkSessions(b *testing.B)      { benchmarkScenario(b, "sessions") }
+func BenchmarkPTYThroughput(b *testing.B) { benchmarkScenario(b, "pty-throughput") }
+
+func TestScenarios(t *testing.T) {
+	utils.InitLogger()
+	for _, name := range ScenarioNames() {
+		t.Run(name, func(t *testing.T) {
+			result, err := Scenarios[name].Run(context.Background(), Config{
+				Duration:   50 * time.Millisecond,
+				FixtureDir: fixtureDir,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Scenario != name || result.Ops == 0 || result.Bytes == 0 || result.Width != 80 {
+				t.Errorf("Expected some work done at 80 columns, got %+v", result)
+			}
+		})
+	}
+
+	if _, err := Lookup("compile"); err == nil {
+		t.Error("Expected an unknown scenario to be rejected")
+	}
+}
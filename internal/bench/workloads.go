@@ -0,0 +1,217 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/session"
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+)
+
+// LoadFixtures reads every recorded output file (*.bin) under dir, in path
+// order
+func LoadFixtures(dir string) ([][]byte, error) {
+	var fixtures [][]byte
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".bin" {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		fixtures = append(fixtures, data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(fixtures) == 0 {
+		return nil, fmt.Errorf("no .bin fixtures in %s", dir)
+	}
+	return fixtures, nil
+}
+
+// ColoredLine returns a line of width columns cycling through the 16 colors
+// with attributes, the kind of output syntax highlighters and ls produce
+func ColoredLine(width, seed int) string {
+	var b strings.Builder
+	for x := 0; x < width; x += 8 {
+		n := min(8, width-x)
+		fmt.Fprintf(&b, "\x1b[%d;%dm%s", 30+(seed+x)%8, 1+(x/8)%4, strings.Repeat(string(rune('a'+(seed+x)%26)), n))
+	}
+	b.WriteString("\x1b[0m")
+	return b.String()
+}
+
+// FillScreen writes a full screen of colored lines into sb
+func FillScreen(sb *terminal.ScreenBuffer, width, height int) {
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for y := 0; y < height; y++ {
+		if y > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(ColoredLine(width, y))
+	}
+	sb.Write([]byte(b.String()))
+}
+
+func setupParse(cfg Config) (*workload, error) {
+	fixtures, err := LoadFixtures(cfg.FixtureDir)
+	if err != nil {
+		return nil, err
+	}
+	sb := terminal.NewScreenBuffer(cfg.Width, cfg.Height)
+	next := 0
+	return &workload{
+		op: func() (int64, error) {
+			data := fixtures[next%len(fixtures)]
+			next++
+			sb.Write(data)
+			return int64(len(data)), nil
+		},
+		close: sb.Close,
+		extra: func() map[string]interface{} {
+			return map[string]interface{}{"fixtures": len(fixtures)}
+		},
+	}, nil
+}
+
+// renderFormats are the screen formats the render scenario cycles through
+var renderFormats = []string{"plain", "raw", "ansi"}
+
+func setupRender(cfg Config) (*workload, error) {
+	sb := terminal.NewScreenBuffer(cfg.Width, cfg.Height)
+	FillScreen(sb, cfg.Width, cfg.Height)
+	next := 0
+	return &workload{
+		op: func() (int64, error) {
+			content, err := sb.Render(renderFormats[next%len(renderFormats)])
+			next++
+			return int64(len(content)), err
+		},
+		close: sb.Close,
+	}, nil
+}
+
+func setupScrollback(cfg Config) (*workload, error) {
+	sb := terminal.NewScreenBuffer(cfg.Width, cfg.Height)
+	// A screen's worth of new lines per operation, so every one scrolls
+	// the previous screen into the history
+	var b strings.Builder
+	for y := 0; y < cfg.Height; y++ {
+		b.WriteString("\r\n")
+		b.WriteString(ColoredLine(cfg.Width, y))
+	}
+	chunk := []byte(b.String())
+	rendered := 0
+	return &workload{
+		op: func() (int64, error) {
+			sb.Write(chunk)
+			content, err := sb.Render("scrollback")
+			rendered = len(content)
+			return int64(len(chunk)), err
+		},
+		close: sb.Close,
+		extra: func() map[string]interface{} {
+			info := sb.Info()
+			return map[string]interface{}{
+				"scrollback_lines":  info.ScrollbackLines,
+				"last_render_bytes": rendered,
+			}
+		},
+	}, nil
+}
+
+func setupSessions(cfg Config) (*workload, error) {
+	manager := session.NewManager()
+	ctx := context.Background()
+	return &workload{
+		op: func() (int64, error) {
+			sess, err := manager.CreateSessionWithConfig(session.SessionConfig{
+				Command: "cat",
+				Width:   cfg.Width,
+				Height:  cfg.Height,
+			})
+			if err != nil {
+				return 0, err
+			}
+			defer manager.RemoveSession(sess.ID)
+
+			const keys = "hello\r"
+			if _, err := sess.SendKeys(ctx, keys); err != nil {
+				return 0, err
+			}
+			// Wait for the echo so each operation is a full round trip
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				screen, err := sess.GetScreen(ctx, "plain")
+				if err != nil {
+					return 0, err
+				}
+				if strings.Count(screen, "hello") >= 2 {
+					return int64(len(keys)), nil
+				}
+				if time.Now().After(deadline) {
+					return 0, fmt.Errorf("cat didn't echo %q: %q", keys, screen)
+				}
+				select {
+				case <-sess.Buffer.Changed():
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+		},
+		close: manager.Shutdown,
+	}, nil
+}
+
+// throughputChunk is how much output each pty-throughput operation waits for
+const throughputChunk = 1 << 20
+
+func setupPTYThroughput(cfg Config) (*workload, error) {
+	sess, err := StartGenerator(cfg.Width, cfg.Height)
+	if err != nil {
+		return nil, err
+	}
+	received := func() int64 {
+		info := sess.Buffer.Info()
+		return info.RawDiscarded + int64(info.RawBytes)
+	}
+	last := received()
+	return &workload{
+		op: func() (int64, error) {
+			deadline := time.Now().Add(10 * time.Second)
+			for received()-last < throughputChunk {
+				if time.Now().After(deadline) {
+					return 0, fmt.Errorf("generator stalled after %d bytes", received())
+				}
+				select {
+				case <-sess.Buffer.Changed():
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+			now := received()
+			n := now - last
+			last = now
+			return n, nil
+		},
+		close: func() { sess.Close() },
+	}, nil
+}
+
+// StartGenerator launches a session whose child writes colored lines as
+// fast as the pipeline takes them
+func StartGenerator(width, height int) (*session.Session, error) {
+	return session.NewSessionWithConfig(session.SessionConfig{
+		Command: "yes",
+		Args:    []string{ColoredLine(width-1, 0)},
+		Width:   width,
+		Height:  height,
+	})
+}
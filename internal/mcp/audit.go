@@ -0,0 +1,326 @@
+package mcp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// defaultAuditMaxSize is the audit log size that triggers rotation when
+// MCP_AUDIT_LOG_MAX_SIZE is not set
+const defaultAuditMaxSize = 10 * 1024 * 1024
+
+// auditKeysPreview is how much of a keys parameter is kept in the audit log
+const auditKeysPreview = 32
+
+// AuditRecord is one line of the audit log
+type AuditRecord struct {
+	Time       time.Time              `json:"time"`
+	Tool       string                 `json:"tool"`
+	RequestID  string                 `json:"request_id,omitempty"`
+	SessionID  string                 `json:"session_id,omitempty"`
+	Params     map[string]interface{} `json:"params,omitempty"`
+	DurationMS float64                `json:"duration_ms"`
+	Success    bool                   `json:"success"`
+	ErrorCode  int                    `json:"error_code,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// auditLog records every tool call, either as JSON lines in a file or, when
+// no file is configured, through slog
+type auditLog struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File // nil when logging through slog
+	size    int64
+	maxSize int64
+}
+
+// newAuditLogFromEnv opens the file named by MCP_AUDIT_LOG, rotating it
+// when it grows past MCP_AUDIT_LOG_MAX_SIZE bytes
+func newAuditLogFromEnv() (*auditLog, error) {
+	a := &auditLog{
+		path:    os.Getenv("MCP_AUDIT_LOG"),
+		maxSize: defaultAuditMaxSize,
+	}
+	if value := os.Getenv("MCP_AUDIT_LOG_MAX_SIZE"); value != "" {
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil && n > 0 {
+			a.maxSize = n
+		} else {
+			slog.Warn("Ignoring invalid audit log size",
+				slog.String("variable", "MCP_AUDIT_LOG_MAX_SIZE"),
+				slog.String("value", value),
+			)
+		}
+	}
+	if a.path == "" {
+		return a, nil
+	}
+
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	slog.Info("Tool call audit log enabled",
+		slog.String("path", a.path),
+		slog.Int64("max_size", a.maxSize),
+	)
+	return a, nil
+}
+
+func (a *auditLog) open() error {
+	file, err := os.OpenFile(a.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	a.file = file
+	a.size = info.Size()
+	return nil
+}
+
+// rotateLocked moves the current file to path.1, replacing any previous
+// one, and starts a new file
+func (a *auditLog) rotateLocked() error {
+	a.file.Close()
+	a.file = nil
+	if err := os.Rename(a.path, a.path+".1"); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+	return a.open()
+}
+
+func (a *auditLog) write(record AuditRecord) {
+	if a.path == "" {
+		slog.Info("tool audit",
+			slog.String("tool", record.Tool),
+			slog.String("request_id", record.RequestID),
+			slog.String("session_id", record.SessionID),
+			slog.Any("params", record.Params),
+			slog.Float64("duration_ms", record.DurationMS),
+			slog.Bool("success", record.Success),
+			slog.String("error", record.Error),
+		)
+		return
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		slog.Error("Failed to encode audit record", slog.String("error", err.Error()))
+		return
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		// A failed rotation closed the file; try to reopen it
+		if err := a.open(); err != nil {
+			slog.Error("Failed to write audit record", slog.String("error", err.Error()))
+			return
+		}
+	}
+	if a.size > 0 && a.size+int64(len(line)) > a.maxSize {
+		if err := a.rotateLocked(); err != nil {
+			slog.Error("Failed to write audit record", slog.String("error", err.Error()))
+			return
+		}
+	}
+	n, err := a.file.Write(line)
+	a.size += int64(n)
+	if err != nil {
+		slog.Error("Failed to write audit record", slog.String("error", err.Error()))
+	}
+}
+
+// Close closes the audit log file, if any
+func (a *auditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
+
+// wrap returns a handler that records each call to handler
+func (a *auditLog) wrap(tool string, handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args := request.GetArguments()
+		start := time.Now()
+		result, err := handler(ctx, request)
+
+		record := AuditRecord{
+			Time:       start.UTC(),
+			Tool:       tool,
+			RequestID:  utils.RequestID(ctx),
+			Params:     sanitizeParams(args),
+			DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+			Success:    err == nil && (result == nil || !result.IsError),
+		}
+		if sessionID, ok := args["session_id"].(string); ok {
+			record.SessionID = sessionID
+		}
+		switch {
+		case err != nil:
+			// Handler errors reach the client as JSON-RPC internal errors
+			record.ErrorCode = mcp.INTERNAL_ERROR
+			record.Error = err.Error()
+		case result != nil && result.IsError:
+			record.Error = resultText(result)
+		}
+
+		a.write(record)
+		return result, err
+	}
+}
+
+// sanitizeParams is the audit log's redaction policy. Keys, including those
+// sent by script steps, are truncated, since they may contain typed
+// passwords; secrets, environment values and raw byte payloads are dropped
+// entirely. Other parameters are kept as given.
+func sanitizeParams(args map[string]interface{}) map[string]interface{} {
+	if len(args) == 0 {
+		return nil
+	}
+
+	sanitized := make(map[string]interface{}, len(args))
+	for name, value := range args {
+		switch name {
+		case "keys":
+			if keys, ok := value.(string); ok {
+				value = truncateKeys(keys)
+			}
+		case "steps":
+			value = sanitizeSteps(value)
+		case "calls":
+			value = sanitizeCalls(value)
+		case "secret":
+			value = redactSecret(value)
+		case "env":
+			if env, ok := value.(map[string]interface{}); ok {
+				redacted := make(map[string]interface{}, len(env))
+				for k := range env {
+					redacted[k] = "[redacted]"
+				}
+				value = redacted
+			} else {
+				value = "[redacted]"
+			}
+		case "data":
+			if data, ok := value.(string); ok {
+				value = fmt.Sprintf("[redacted %d chars]", len(data))
+			} else {
+				value = "[redacted]"
+			}
+		}
+		sanitized[name] = value
+	}
+	return sanitized
+}
+
+// redactSecret replaces a send_secret payload with its length and a
+// shortened SHA-256, enough to tell whether two calls sent the same secret
+func redactSecret(value interface{}) string {
+	secret, ok := value.(string)
+	if !ok {
+		return "[redacted]"
+	}
+	sum := sha256.Sum256([]byte(secret))
+	return fmt.Sprintf("[redacted %d chars, sha256 %s]", len(secret), hex.EncodeToString(sum[:8]))
+}
+
+// sanitizeSteps truncates the keys sent by run_expect_script steps. Steps
+// given as a JSON string are truncated whole.
+func sanitizeSteps(value interface{}) interface{} {
+	switch steps := value.(type) {
+	case string:
+		return truncateKeys(steps)
+	case []interface{}:
+		sanitized := make([]interface{}, len(steps))
+		for i, item := range steps {
+			step, ok := item.(map[string]interface{})
+			if !ok {
+				sanitized[i] = item
+				continue
+			}
+			copied := make(map[string]interface{}, len(step))
+			for k, v := range step {
+				copied[k] = v
+			}
+			if keys, ok := copied["send"].(string); ok {
+				copied["send"] = truncateKeys(keys)
+			}
+			sanitized[i] = copied
+		}
+		return sanitized
+	}
+	return value
+}
+
+// sanitizeCalls applies the policy to the arguments of each batch call. A
+// batch or arguments given as a JSON string can't be inspected, so they are
+// dropped.
+func sanitizeCalls(value interface{}) interface{} {
+	calls, ok := value.([]interface{})
+	if !ok {
+		return "[redacted]"
+	}
+	sanitized := make([]interface{}, len(calls))
+	for i, item := range calls {
+		call, ok := item.(map[string]interface{})
+		if !ok {
+			sanitized[i] = item
+			continue
+		}
+		copied := make(map[string]interface{}, len(call))
+		for k, v := range call {
+			copied[k] = v
+		}
+		if args, ok := copied["arguments"].(map[string]interface{}); ok {
+			copied["arguments"] = sanitizeParams(args)
+		} else if copied["arguments"] != nil {
+			copied["arguments"] = "[redacted]"
+		}
+		sanitized[i] = copied
+	}
+	return sanitized
+}
+
+func truncateKeys(keys string) string {
+	if utf8.RuneCountInString(keys) <= auditKeysPreview {
+		return keys
+	}
+	runes := []rune(keys)
+	return fmt.Sprintf("%s... [%d chars]", string(runes[:auditKeysPreview]), len(runes))
+}
+
+// resultText joins the text content of a tool result
+func resultText(result *mcp.CallToolResult) string {
+	var text strings.Builder
+	for _, content := range result.Content {
+		if tc, ok := content.(mcp.TextContent); ok {
+			text.WriteString(tc.Text)
+		}
+	}
+	return text.String()
+}
@@ -0,0 +1,298 @@
+package mcp
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func readAuditLog(t *testing.T, path string) []AuditRecord {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer file.Close()
+
+	var records []AuditRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Invalid audit line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestAuditLogRecordsToolCalls(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	t.Setenv("MCP_AUDIT_LOG", path)
+	s := newTestServer(t)
+
+	var launched struct {
+		SessionID string `json:"session_id"`
+	}
+	callTool(t, s, "launch_app", map[string]interface{}{
+		"command": "cat",
+		"env":     map[string]interface{}{"API_TOKEN": "hunter2"},
+	}, &launched)
+
+	secret := "correct horse battery staple and then some more"
+	if _, errMsg := callToolRaw(t, s, "send_keys", map[string]interface{}{
+		"session_id": launched.SessionID,
+		"keys":       secret,
+	}); errMsg != "" {
+		t.Fatalf("send_keys failed: %s", errMsg)
+	}
+	if _, errMsg := callToolRaw(t, s, "view_screen", map[string]interface{}{
+		"session_id": "no-such-session",
+	}); errMsg == "" {
+		t.Fatal("Expected view_screen of an unknown session to fail")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+	if strings.Contains(string(data), "hunter2") || strings.Contains(string(data), secret) {
+		t.Errorf("Audit log contains sensitive values: %s", data)
+	}
+
+	records := readAuditLog(t, path)
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %d: %s", len(records), data)
+	}
+
+	launch, keys, view := records[0], records[1], records[2]
+	if launch.Tool != "launch_app" || !launch.Success || launch.Time.IsZero() || launch.DurationMS <= 0 {
+		t.Errorf("Unexpected launch record: %+v", launch)
+	}
+	env, _ := launch.Params["env"].(map[string]interface{})
+	if env["API_TOKEN"] != "[redacted]" {
+		t.Errorf("Expected env value to be redacted, got %v", launch.Params["env"])
+	}
+
+	if keys.RequestID == "" || keys.RequestID == launch.RequestID {
+		t.Errorf("Expected a request ID per call, got %q and %q", launch.RequestID, keys.RequestID)
+	}
+	if keys.SessionID != launched.SessionID {
+		t.Errorf("Expected session %s, got %q", launched.SessionID, keys.SessionID)
+	}
+	if k, _ := keys.Params["keys"].(string); !strings.HasPrefix(k, secret[:auditKeysPreview]) || !strings.Contains(k, "chars]") {
+		t.Errorf("Expected truncated keys, got %q", k)
+	}
+
+	if view.Success || view.ErrorCode != mcp.INTERNAL_ERROR || !strings.Contains(view.Error, "not found") {
+		t.Errorf("Unexpected failure record: %+v", view)
+	}
+}
+
+func TestSendSecretStaysOutOfLogs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	t.Setenv("MCP_AUDIT_LOG", path)
+	s := newTestServer(t)
+	logs := captureLogs(t)
+
+	var launched struct {
+		SessionID string `json:"session_id"`
+	}
+	callTool(t, s, "launch_app", map[string]interface{}{"command": "cat"}, &launched)
+
+	marker := "s3cret-MARKER-7f1c"
+	var sent struct {
+		BytesWritten int `json:"bytes_written"`
+	}
+	callTool(t, s, "send_secret", map[string]interface{}{
+		"session_id": launched.SessionID,
+		"secret":     marker,
+	}, &sent)
+	if sent.BytesWritten != len(marker) {
+		t.Errorf("Expected %d bytes written, got %d", len(marker), sent.BytesWritten)
+	}
+
+	// The terminal echoes what cat is typed, so the secret does reach the
+	// screen; that's the application's doing
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var screen struct {
+			Content string `json:"content"`
+		}
+		callTool(t, s, "view_screen", map[string]interface{}{"session_id": launched.SessionID, "format": "plain"}, &screen)
+		if strings.Contains(screen.Content, marker) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the echoed secret on screen, got %q", screen.Content)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	logged, err := json.Marshal(logs.records(t))
+	if err != nil {
+		t.Fatalf("Failed to encode log records: %v", err)
+	}
+	if !strings.Contains(string(logged), `"tool":"send_secret"`) {
+		t.Errorf("Expected the send_secret call to be logged")
+	}
+	if strings.Contains(string(logged), marker) {
+		t.Errorf("Server log contains the secret: %s", logged)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+	if strings.Contains(string(data), marker) {
+		t.Errorf("Audit log contains the secret: %s", data)
+	}
+	records := readAuditLog(t, path)
+	if len(records) < 2 || records[1].Tool != "send_secret" || records[1].Params["secret"] != redactSecret(marker) {
+		t.Errorf("Expected the secret's length and hash in the audit log, got %s", data)
+	}
+}
+
+func TestAuditLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	t.Setenv("MCP_AUDIT_LOG", path)
+	t.Setenv("MCP_AUDIT_LOG_MAX_SIZE", "400")
+	s := newTestServer(t)
+
+	for i := 0; i < 10; i++ {
+		var resp struct{}
+		callTool(t, s, "ping", nil, &resp)
+	}
+
+	current := readAuditLog(t, path)
+	rotated := readAuditLog(t, path+".1")
+	if len(current) == 0 || len(rotated) == 0 {
+		t.Fatalf("Expected records in both files, got %d and %d", len(current), len(rotated))
+	}
+	if info, _ := os.Stat(path); info.Size() > 400 {
+		t.Errorf("Audit log grew to %d bytes past the 400 byte limit", info.Size())
+	}
+}
+
+func TestAuditLogBadPathFailsStartup(t *testing.T) {
+	utils.InitLogger()
+	t.Setenv("STATE_DIR", t.TempDir())
+	t.Setenv("MCP_AUDIT_LOG", filepath.Join(t.TempDir(), "missing", "audit.log"))
+
+	s, err := NewServer()
+	if err == nil {
+		s.Shutdown()
+		t.Fatal("Expected NewServer to fail when the audit log can't be opened")
+	}
+}
+
+func TestSanitizeParams(t *testing.T) {
+	params := sanitizeParams(map[string]interface{}{
+		"command": "vim",
+		"keys":    "short",
+		"data":    "c2VjcmV0",
+		"env":     "not-a-map",
+	})
+	if params["command"] != "vim" || params["keys"] != "short" {
+		t.Errorf("Expected harmless params unchanged, got %v", params)
+	}
+	if params["data"] != "[redacted 8 chars]" || params["env"] != "[redacted]" {
+		t.Errorf("Expected payloads redacted, got %v", params)
+	}
+	if secret := sanitizeParams(map[string]interface{}{"secret": "hunter2"})["secret"]; secret != "[redacted 7 chars, sha256 f52fbd32b2b3b86f]" {
+		t.Errorf("Expected the secret's length and hash, got %v", secret)
+	}
+	if sanitizeParams(nil) != nil {
+		t.Error("Expected no params for an empty call")
+	}
+
+	secret := strings.Repeat("hunter2 ", 8)
+	steps := []interface{}{
+		map[string]interface{}{"expect": "Password:"},
+		map[string]interface{}{"send": secret},
+	}
+	params = sanitizeParams(map[string]interface{}{"steps": steps})
+	sanitized, _ := params["steps"].([]interface{})
+	if len(sanitized) != 2 || sanitized[0].(map[string]interface{})["expect"] != "Password:" {
+		t.Fatalf("Expected the steps kept, got %v", params["steps"])
+	}
+	if sent := sanitized[1].(map[string]interface{})["send"]; sent != truncateKeys(secret) || sent == secret {
+		t.Errorf("Expected sent keys truncated, got %v", sent)
+	}
+	if steps[1].(map[string]interface{})["send"] != secret {
+		t.Error("Expected the call's own arguments untouched")
+	}
+
+	calls := []interface{}{
+		map[string]interface{}{"tool": "send_secret", "arguments": map[string]interface{}{"secret": "hunter2"}},
+		map[string]interface{}{"tool": "send_keys", "arguments": `{"keys": "hunter2"}`},
+	}
+	params = sanitizeParams(map[string]interface{}{"calls": calls})
+	sanitized, _ = params["calls"].([]interface{})
+	if len(sanitized) != 2 || sanitized[0].(map[string]interface{})["tool"] != "send_secret" {
+		t.Fatalf("Expected the calls kept, got %v", params["calls"])
+	}
+	if args := sanitized[0].(map[string]interface{})["arguments"].(map[string]interface{}); args["secret"] != redactSecret("hunter2") {
+		t.Errorf("Expected a batched secret redacted, got %v", args)
+	}
+	if args := sanitized[1].(map[string]interface{})["arguments"]; args != "[redacted]" {
+		t.Errorf("Expected arguments that aren't an object dropped, got %v", args)
+	}
+	if sanitizeParams(map[string]interface{}{"calls": "[]"})["calls"] != "[redacted]" {
+		t.Error("Expected calls that aren't an array dropped")
+	}
+}
+
+func TestBatchCallsAreAudited(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	t.Setenv("MCP_AUDIT_LOG", path)
+	s := newTestServer(t)
+
+	var launched struct {
+		SessionID string `json:"session_id"`
+	}
+	callTool(t, s, "launch_app", map[string]interface{}{"command": "cat"}, &launched)
+
+	marker := "s3cret-BATCH-91ad"
+	var batch struct {
+		Results []map[string]interface{} `json:"results"`
+		Failed  int                      `json:"failed"`
+	}
+	callTool(t, s, "batch", map[string]interface{}{
+		"calls": []interface{}{
+			map[string]interface{}{"tool": "send_secret", "arguments": map[string]interface{}{"session_id": launched.SessionID, "secret": marker}},
+			map[string]interface{}{"tool": "get_screen_size", "arguments": map[string]interface{}{"session_id": launched.SessionID}},
+		},
+	}, &batch)
+	if len(batch.Results) != 2 || batch.Failed != 0 {
+		t.Fatalf("Unexpected batch result: %+v", batch)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+	if strings.Contains(string(data), marker) {
+		t.Errorf("Audit log contains the secret: %s", data)
+	}
+
+	// Each call of the batch is recorded, then the batch itself
+	records := readAuditLog(t, path)
+	var tools []string
+	for _, record := range records {
+		tools = append(tools, record.Tool)
+	}
+	if strings.Join(tools, ",") != "launch_app,send_secret,get_screen_size,batch" {
+		t.Fatalf("Unexpected audit records: %v", tools)
+	}
+	if records[1].SessionID != launched.SessionID || records[1].Params["secret"] != redactSecret(marker) {
+		t.Errorf("Unexpected record for the batched send_secret: %+v", records[1])
+	}
+}
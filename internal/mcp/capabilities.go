@@ -0,0 +1,148 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+	"github.com/bioharz/mcp-terminal-tester/internal/tools"
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Version is the server version, set at build time with
+// -ldflags "-X github.com/bioharz/mcp-terminal-tester/internal/mcp.Version=..."
+var Version = "1.0.0"
+
+// Capabilities describes what this server supports. It is built from the
+// running server's configuration, so server_info can't drift from reality.
+type Capabilities struct {
+	Name          string           `json:"name"`
+	Version       string           `json:"version"`
+	Build         BuildInfo        `json:"build"`
+	Limits        CapabilityLimits `json:"limits"`
+	RenderFormats []string         `json:"render_formats"`
+	Transports    []string         `json:"transports"`
+	Features      []string         `json:"features"`
+	Tools         []string         `json:"tools"`
+	RateLimit     RateLimitInfo    `json:"rate_limit"`
+}
+
+// BuildInfo is what the Go toolchain recorded about the binary
+type BuildInfo struct {
+	GoVersion string `json:"go_version"`
+	Module    string `json:"module,omitempty"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+}
+
+// CapabilityLimits are the configured bounds enforced by the tools
+type CapabilityLimits struct {
+	MaxSessions    int `json:"max_sessions"`
+	MinDimension   int `json:"min_dimension"`
+	MaxWidth       int `json:"max_width"`
+	MaxHeight      int `json:"max_height"`
+	MaxInputBytes  int `json:"max_input_bytes"`  // Per send_keys or send_raw_bytes call
+	MaxOutputBytes int `json:"max_output_bytes"` // Per view_screen call without max_bytes
+}
+
+// readBuildInfo returns the embedded build information, if any
+func readBuildInfo() BuildInfo {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return BuildInfo{}
+	}
+
+	build := BuildInfo{
+		GoVersion: info.GoVersion,
+		Module:    info.Main.Path,
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			build.Revision = setting.Value
+		case "vcs.time":
+			build.Time = setting.Value
+		case "vcs.modified":
+			build.Modified = setting.Value == "true"
+		}
+	}
+	return build
+}
+
+// Capabilities returns the server's current capabilities
+func (s *Server) Capabilities() Capabilities {
+	limits := tools.DimensionLimitsFromEnv()
+
+	features := []string{"session_groups", "session_options", "raw_io", "orphan_recovery", "parser_diagnostics"}
+	if s.statePersistence {
+		features = append(features, "state_persistence")
+	}
+	if s.poolSize > 0 {
+		features = append(features, "session_pool")
+	}
+
+	return Capabilities{
+		Name:    serverName,
+		Version: Version,
+		Build:   readBuildInfo(),
+		Limits: CapabilityLimits{
+			MaxSessions:    s.sessionManager.MaxSessions(),
+			MinDimension:   tools.MinDimension,
+			MaxWidth:       limits.MaxWidth,
+			MaxHeight:      limits.MaxHeight,
+			MaxInputBytes:  tools.MaxInputBytesFromEnv(),
+			MaxOutputBytes: tools.MaxOutputBytesFromEnv(),
+		},
+		RenderFormats: append([]string(nil), terminal.RenderFormats...),
+		Transports:    []string{"stdio"},
+		Features:      features,
+		Tools:         s.registry.Names(),
+		RateLimit:     s.limiter.Info(),
+	}
+}
+
+func (s *Server) Ping(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	utils.LogToolCall(ctx, "ping", "")
+
+	now := time.Now()
+	respData, err := json.Marshal(map[string]interface{}{
+		"ok":        true,
+		"time":      now.UTC().Format(time.RFC3339Nano),
+		"uptime_ms": now.Sub(s.startTime).Milliseconds(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal response: %w", err)
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: string(respData),
+			},
+		},
+	}, nil
+}
+
+func (s *Server) ServerInfo(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	utils.LogToolCall(ctx, "server_info", "")
+
+	respData, err := json.Marshal(s.Capabilities())
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal response: %w", err)
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: string(respData),
+			},
+		},
+	}, nil
+}
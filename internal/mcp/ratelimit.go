@@ -0,0 +1,281 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/clock"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// Default rate limits, in calls per second. Each bucket holds a second's
+// worth of calls, so short bursts up to the rate pass untouched.
+const (
+	defaultToolRate    = 100
+	defaultSessionRate = 50
+)
+
+// rateLimitedCode marks a call refused by the rate limiter
+const rateLimitedCode = "rate_limited"
+
+// maxSessionBuckets is how many per-session buckets are kept before idle
+// ones are dropped; session IDs come from clients, so the map must not grow
+// without bound
+const maxSessionBuckets = 1000
+
+// rateLimitExempt lists tools that are cheap enough never to be limited
+var rateLimitExempt = map[string]bool{
+	"ping":        true,
+	"server_info": true,
+}
+
+// RateLimitInfo is the rate limiter's configuration and how often it has
+// refused calls, as reported by server_info
+type RateLimitInfo struct {
+	ToolRate      float64          `json:"tool_rate"`    // Calls per second per tool; 0 when disabled
+	SessionRate   float64          `json:"session_rate"` // Calls per second per session; 0 when disabled
+	Exempt        []string         `json:"exempt"`
+	Hits          int64            `json:"hits"`
+	HitsByTool    map[string]int64 `json:"hits_by_tool"`
+	HitsBySession map[string]int64 `json:"hits_by_session"`
+}
+
+// bucket is a token bucket that holds up to a second's worth of calls, and
+// at least one
+type bucket struct {
+	tokens  float64
+	last    time.Time
+	hits    int64
+	limited bool // The last call was refused; logged once per episode
+}
+
+// wait refills the bucket for the time since it was last used and returns
+// how long until it holds a token, 0 if it does now
+func (b *bucket) wait(now time.Time, rate float64) time.Duration {
+	b.tokens = math.Min(math.Max(rate, 1), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// refusal describes a call the rate limiter refused
+type refusal struct {
+	scope      string // "tool" or "session"
+	rate       float64
+	retryAfter time.Duration
+	first      bool // The first refusal since a call was let through
+}
+
+// rateLimiter refuses calls beyond a rate per tool and per session, so an
+// agent stuck in a loop can't starve PTY reads or flood the log. Refused
+// calls get an error telling the client when to retry; nothing is delayed.
+type rateLimiter struct {
+	mu          sync.Mutex
+	toolRate    float64
+	sessionRate float64
+	tools       map[string]*bucket
+	sessions    map[string]*bucket
+	hits        int64
+	clock       clock.Clock
+	// onLimited, if set, is told about the first refusal of each run, like
+	// the warning that is logged
+	onLimited func(tool, sessionID, scope string)
+	// resolve, if set, maps the session_id argument to the session's ID, so
+	// a label or a differently cased ID shares that session's bucket
+	resolve func(ref string) (string, bool)
+}
+
+// newRateLimiterFromEnv reads MCP_RATE_LIMIT_TOOL and MCP_RATE_LIMIT_SESSION
+// in calls per second; 0 disables that limit
+func newRateLimiterFromEnv() *rateLimiter {
+	return &rateLimiter{
+		toolRate:    rateFromEnv("MCP_RATE_LIMIT_TOOL", defaultToolRate),
+		sessionRate: rateFromEnv("MCP_RATE_LIMIT_SESSION", defaultSessionRate),
+		tools:       make(map[string]*bucket),
+		sessions:    make(map[string]*bucket),
+		clock:       clock.Real,
+	}
+}
+
+func rateFromEnv(name string, fallback float64) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate < 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
+		slog.Warn("Ignoring invalid rate limit",
+			slog.String("variable", name),
+			slog.String("value", value),
+			slog.Float64("default", fallback),
+		)
+		return fallback
+	}
+	return rate
+}
+
+// newBucket returns a full bucket
+func (l *rateLimiter) newBucket(rate float64) *bucket {
+	return &bucket{tokens: math.Max(rate, 1), last: l.clock.Now()}
+}
+
+// allow takes a call for tool on sessionID (empty for calls without one)
+// from both buckets. When either is empty it takes nothing and returns why
+// the call was refused.
+func (l *rateLimiter) allow(tool, sessionID string) *refusal {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	var toolBucket, sessionBucket *bucket
+	if l.toolRate > 0 {
+		if toolBucket = l.tools[tool]; toolBucket == nil {
+			toolBucket = l.newBucket(l.toolRate)
+			l.tools[tool] = toolBucket
+		}
+	}
+	if l.sessionRate > 0 && sessionID != "" {
+		if sessionBucket = l.sessions[sessionID]; sessionBucket == nil {
+			l.pruneSessions(now)
+			sessionBucket = l.newBucket(l.sessionRate)
+			l.sessions[sessionID] = sessionBucket
+		}
+	}
+
+	// Check both before taking from either, so a refused call costs nothing
+	if toolBucket != nil {
+		if wait := toolBucket.wait(now, l.toolRate); wait > 0 {
+			return l.refuse(toolBucket, "tool", l.toolRate, wait)
+		}
+	}
+	if sessionBucket != nil {
+		if wait := sessionBucket.wait(now, l.sessionRate); wait > 0 {
+			return l.refuse(sessionBucket, "session", l.sessionRate, wait)
+		}
+	}
+	for _, b := range []*bucket{toolBucket, sessionBucket} {
+		if b != nil {
+			b.tokens--
+			b.limited = false
+		}
+	}
+	return nil
+}
+
+// refuse counts a call refused by b. The caller must hold l.mu.
+func (l *rateLimiter) refuse(b *bucket, scope string, rate float64, wait time.Duration) *refusal {
+	b.hits++
+	l.hits++
+	first := !b.limited
+	b.limited = true
+	return &refusal{scope: scope, rate: rate, retryAfter: wait, first: first}
+}
+
+// pruneSessions drops the buckets of sessions that have been idle long
+// enough to refill, once there are too many. The caller must hold l.mu.
+func (l *rateLimiter) pruneSessions(now time.Time) {
+	if len(l.sessions) < maxSessionBuckets {
+		return
+	}
+	for id, b := range l.sessions {
+		if now.Sub(b.last) > time.Second {
+			delete(l.sessions, id)
+		}
+	}
+}
+
+// Info returns the configured rates and the refusals so far
+func (l *rateLimiter) Info() RateLimitInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	info := RateLimitInfo{
+		ToolRate:      l.toolRate,
+		SessionRate:   l.sessionRate,
+		Hits:          l.hits,
+		HitsByTool:    make(map[string]int64),
+		HitsBySession: make(map[string]int64),
+	}
+	for name := range rateLimitExempt {
+		info.Exempt = append(info.Exempt, name)
+	}
+	sort.Strings(info.Exempt)
+	for name, b := range l.tools {
+		if b.hits > 0 {
+			info.HitsByTool[name] = b.hits
+		}
+	}
+	for id, b := range l.sessions {
+		if b.hits > 0 {
+			info.HitsBySession[id] = b.hits
+		}
+	}
+	return info
+}
+
+// wrap returns a handler that refuses calls to tool over the limits
+func (l *rateLimiter) wrap(tool string, handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+	if rateLimitExempt[tool] {
+		return handler
+	}
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		sessionID, _ := request.GetArguments()["session_id"].(string)
+		if l.resolve != nil && sessionID != "" {
+			if id, ok := l.resolve(sessionID); ok {
+				sessionID = id
+			}
+		}
+		refused := l.allow(tool, sessionID)
+		if refused == nil {
+			return handler(ctx, request)
+		}
+
+		// Log the start of a run of refusals, not every one of them
+		retryAfter := int64(math.Ceil(float64(refused.retryAfter) / float64(time.Millisecond)))
+		level := slog.LevelDebug
+		if refused.first {
+			level = slog.LevelWarn
+			if l.onLimited != nil {
+				l.onLimited(tool, sessionID, refused.scope)
+			}
+		}
+		slog.Log(ctx, level, "Rate limited",
+			slog.String("tool", tool),
+			slog.String("session_id", sessionID),
+			slog.String("scope", refused.scope),
+			slog.Int64("retry_after_ms", retryAfter),
+		)
+		return rateLimitedResult(refused.scope, refused.rate, retryAfter), nil
+	}
+}
+
+// rateLimitedResult tells the client which limit it hit and when to retry
+func rateLimitedResult(scope string, rate float64, retryAfter int64) *mcp.CallToolResult {
+	jsonData, _ := json.Marshal(map[string]interface{}{
+		"error":            fmt.Sprintf("rate limited, retry after %d ms", retryAfter),
+		"code":             rateLimitedCode,
+		"scope":            scope,
+		"limit_per_second": rate,
+		"retry_after_ms":   retryAfter,
+	})
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: string(jsonData),
+			},
+		},
+		IsError: true,
+	}
+}
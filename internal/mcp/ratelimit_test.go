@@ -0,0 +1,191 @@
+package mcp
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/session"
+	"github.com/bioharz/mcp-terminal-tester/internal/testutil"
+)
+
+func TestRateLimiterBuckets(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Unix(1000, 0))
+	l := &rateLimiter{
+		toolRate:    10,
+		sessionRate: 2,
+		tools:       make(map[string]*bucket),
+		sessions:    make(map[string]*bucket),
+		clock:       clk,
+	}
+
+	// A second's worth of calls passes, then the tool bucket is empty
+	for i := 0; i < 10; i++ {
+		if refused := l.allow("view_screen", ""); refused != nil {
+			t.Fatalf("Call %d refused: %+v", i, refused)
+		}
+	}
+	refused := l.allow("view_screen", "")
+	if refused == nil || refused.scope != "tool" || !refused.first {
+		t.Fatalf("Expected the first tool refusal, got %+v", refused)
+	}
+	if refused.retryAfter != 100*time.Millisecond {
+		t.Errorf("Expected to retry after 100ms, got %v", refused.retryAfter)
+	}
+	if refused = l.allow("view_screen", ""); refused == nil || refused.first {
+		t.Errorf("Expected a repeated refusal, got %+v", refused)
+	}
+	if l.allow("send_keys", "") != nil {
+		t.Error("Other tools have their own bucket")
+	}
+
+	clk.Advance(100 * time.Millisecond)
+	if refused := l.allow("view_screen", ""); refused != nil {
+		t.Errorf("Expected a token after 100ms, got %+v", refused)
+	}
+
+	// Sessions are limited across tools, each on its own
+	for i := 0; i < 2; i++ {
+		if refused := l.allow("get_cursor_position", "a"); refused != nil {
+			t.Fatalf("Session call %d refused: %+v", i, refused)
+		}
+	}
+	if refused := l.allow("get_screen_size", "a"); refused == nil || refused.scope != "session" || refused.rate != 2 {
+		t.Errorf("Expected a session refusal, got %+v", refused)
+	}
+	if refused := l.allow("get_screen_size", "b"); refused != nil {
+		t.Errorf("Expected session b to be unaffected, got %+v", refused)
+	}
+
+	// A refused call takes nothing from the other bucket
+	if tokens := l.tools["get_screen_size"].tokens; tokens != 9 {
+		t.Errorf("Expected 9 tokens left for get_screen_size, got %v", tokens)
+	}
+
+	info := l.Info()
+	if info.Hits != 3 || info.HitsByTool["view_screen"] != 2 || info.HitsBySession["a"] != 1 {
+		t.Errorf("Unexpected hit counts %+v", info)
+	}
+}
+
+// rateLimitResponse is the body of a rate limited tool result
+type rateLimitResponse struct {
+	Code           string  `json:"code"`
+	Scope          string  `json:"scope"`
+	LimitPerSecond float64 `json:"limit_per_second"`
+	RetryAfterMS   int64   `json:"retry_after_ms"`
+}
+
+// decodeRateLimit decodes a tool error that must be a rate limit
+func decodeRateLimit(t *testing.T, errMsg string) rateLimitResponse {
+	t.Helper()
+	var resp rateLimitResponse
+	if err := json.Unmarshal([]byte(errMsg), &resp); err != nil || resp.Code != rateLimitedCode {
+		t.Fatalf("Expected a rate limit error, got %q", errMsg)
+	}
+	return resp
+}
+
+func TestRateLimitedToolCalls(t *testing.T) {
+	t.Setenv("MCP_RATE_LIMIT_TOOL", "5")
+	t.Setenv("MCP_RATE_LIMIT_SESSION", "0")
+	s := newTestServer(t)
+
+	limited := 0
+	for i := 0; i < 20; i++ {
+		if _, errMsg := callToolRaw(t, s, "list_sessions", nil); errMsg != "" {
+			resp := decodeRateLimit(t, errMsg)
+			if resp.Scope != "tool" || resp.LimitPerSecond != 5 || resp.RetryAfterMS <= 0 {
+				t.Errorf("Unexpected rate limit response %s", errMsg)
+			}
+			limited++
+		}
+	}
+	if limited < 10 {
+		t.Errorf("Expected most of 20 back-to-back calls to be limited, got %d", limited)
+	}
+
+	// ping is exempt
+	for i := 0; i < 20; i++ {
+		var resp struct {
+			OK bool `json:"ok"`
+		}
+		callTool(t, s, "ping", nil, &resp)
+		if !resp.OK {
+			t.Fatalf("ping %d was limited", i)
+		}
+	}
+
+	var info Capabilities
+	callTool(t, s, "server_info", nil, &info)
+	if info.RateLimit.ToolRate != 5 || info.RateLimit.HitsByTool["list_sessions"] != int64(limited) {
+		t.Errorf("Expected %d list_sessions hits in server_info, got %+v", limited, info.RateLimit)
+	}
+
+	// The start of the run of refusals is in the activity log, once
+	activity := s.sessionManager.RecentActivity(time.Time{}, "", 0)
+	if len(activity) != 1 || activity[0].Type != session.ActivityRateLimited || activity[0].Data["tool"] != "list_sessions" {
+		t.Errorf("Expected one rate limiting entry, got %+v", activity)
+	}
+}
+
+func TestRateLimitPerSession(t *testing.T) {
+	t.Setenv("MCP_RATE_LIMIT_TOOL", "0")
+	t.Setenv("MCP_RATE_LIMIT_SESSION", "3")
+	s := newTestServer(t)
+
+	// The calls fail since the session doesn't exist, but still count
+	for i := 0; i < 3; i++ {
+		if _, errMsg := callToolRaw(t, s, "get_session_info", map[string]interface{}{"session_id": "busy"}); strings.Contains(errMsg, rateLimitedCode) {
+			t.Fatalf("Call %d was limited: %s", i, errMsg)
+		}
+	}
+	_, errMsg := callToolRaw(t, s, "get_cursor_position", map[string]interface{}{"session_id": "busy"})
+	if resp := decodeRateLimit(t, errMsg); resp.Scope != "session" || resp.LimitPerSecond != 3 {
+		t.Errorf("Expected a session rate limit, got %s", errMsg)
+	}
+
+	if _, errMsg := callToolRaw(t, s, "get_cursor_position", map[string]interface{}{"session_id": "quiet"}); strings.Contains(errMsg, rateLimitedCode) {
+		t.Errorf("Expected another session to be unaffected, got %s", errMsg)
+	}
+}
+
+func TestRateLimitSessionAliasesShareBucket(t *testing.T) {
+	t.Setenv("MCP_RATE_LIMIT_TOOL", "0")
+	t.Setenv("MCP_RATE_LIMIT_SESSION", "3")
+	s := newTestServer(t)
+
+	sess, err := s.sessionManager.CreateSessionWithConfig(session.SessionConfig{Command: "sleep", Args: []string{"10"}, Label: "editor"})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	// The ID, its upper case form and the label all name one session
+	for i, ref := range []string{sess.ID, strings.ToUpper(sess.ID), "editor"} {
+		if _, errMsg := callToolRaw(t, s, "get_cursor_position", map[string]interface{}{"session_id": ref}); errMsg != "" {
+			t.Fatalf("Call %d via %q failed: %s", i, ref, errMsg)
+		}
+	}
+	_, errMsg := callToolRaw(t, s, "get_cursor_position", map[string]interface{}{"session_id": "editor"})
+	if resp := decodeRateLimit(t, errMsg); resp.Scope != "session" {
+		t.Errorf("Expected a session rate limit, got %s", errMsg)
+	}
+	if hits := s.limiter.Info().HitsBySession; hits[sess.ID] != 1 || len(hits) != 1 {
+		t.Errorf("Expected the hit counted against %s, got %v", sess.ID, hits)
+	}
+}
+
+func TestRateLimitLetsSlowTrafficThrough(t *testing.T) {
+	t.Setenv("MCP_RATE_LIMIT_TOOL", "20")
+	t.Setenv("MCP_RATE_LIMIT_SESSION", "")
+	s := newTestServer(t)
+
+	// Slightly slower than the limit, for longer than one bucket lasts
+	for i := 0; i < 30; i++ {
+		if _, errMsg := callToolRaw(t, s, "list_sessions", nil); errMsg != "" {
+			t.Fatalf("Call %d at 16 per second failed: %s", i, errMsg)
+		}
+		time.Sleep(60 * time.Millisecond)
+	}
+}
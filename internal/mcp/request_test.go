@@ -0,0 +1,134 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/session"
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+)
+
+// logCapture collects the server log for a test
+type logCapture struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (c *logCapture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.Write(p)
+}
+
+// records returns the captured JSON log records
+func (c *logCapture) records(t *testing.T) []map[string]interface{} {
+	t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var records []map[string]interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(c.buf.Bytes()))
+	for scanner.Scan() {
+		var record map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Invalid log line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func captureLogs(t *testing.T) *logCapture {
+	t.Helper()
+	capture := &logCapture{}
+	utils.SetLogOutput(capture)
+	previous := utils.SetLogLevel(slog.LevelDebug)
+	t.Cleanup(func() {
+		utils.SetLogLevel(previous)
+		utils.SetLogOutput(os.Stderr)
+	})
+	return capture
+}
+
+func TestRequestIDCorrelatesLogs(t *testing.T) {
+	s := newTestServer(t)
+	logs := captureLogs(t)
+
+	var launched struct {
+		SessionID string `json:"session_id"`
+	}
+	callTool(t, s, "launch_app", map[string]interface{}{"command": "cat"}, &launched)
+
+	// Break the PTY underneath a session that still looks active, so the
+	// next write fails downstream of the handler
+	sess, err := s.sessionManager.GetSession(launched.SessionID)
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+	sess.PTY.Stop()
+	deadline := time.Now().Add(2 * time.Second)
+	for sess.GetInfo().State == "active" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	sess.State = session.StateActive
+
+	_, errMsg := callToolRaw(t, s, "send_keys", map[string]interface{}{
+		"session_id": launched.SessionID,
+		"keys":       "x",
+	})
+	if errMsg == "" {
+		t.Fatal("Expected send_keys to fail on a stopped PTY")
+	}
+
+	var toolCallID, failureID string
+	for _, record := range logs.records(t) {
+		requestID, _ := record["request_id"].(string)
+		switch {
+		case record["msg"] == "tool call" && record["tool"] == "send_keys":
+			toolCallID = requestID
+		case record["msg"] == "Failed to send keys":
+			failureID = requestID
+		}
+	}
+
+	if toolCallID == "" {
+		t.Fatal("Tool call log line has no request_id")
+	}
+	if failureID != toolCallID {
+		t.Errorf("PTY error logged with request_id %q, tool call with %q", failureID, toolCallID)
+	}
+	if !strings.Contains(errMsg, "request_id: "+toolCallID) {
+		t.Errorf("Expected the error response to carry request_id %s, got %q", toolCallID, errMsg)
+	}
+}
+
+func TestRequestIDsAreUniquePerCall(t *testing.T) {
+	s := newTestServer(t)
+	logs := captureLogs(t)
+
+	for i := 0; i < 3; i++ {
+		var resp struct{}
+		callTool(t, s, "ping", nil, &resp)
+	}
+
+	seen := make(map[string]bool)
+	for _, record := range logs.records(t) {
+		if record["msg"] == "tool call" {
+			requestID, _ := record["request_id"].(string)
+			if requestID == "" || seen[requestID] {
+				t.Errorf("Expected a fresh request_id, got %q", requestID)
+			}
+			seen[requestID] = true
+		}
+	}
+	if len(seen) != 3 {
+		t.Errorf("Expected 3 tool calls, got %d", len(seen))
+	}
+}
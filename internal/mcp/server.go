@@ -2,180 +2,215 @@ package mcp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/bioharz/mcp-terminal-tester/internal/session"
 	"github.com/bioharz/mcp-terminal-tester/internal/tools"
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
 )
 
+// serverName is the name reported to MCP clients
+const serverName = "mcp-terminal-tester"
+
+// triggerNotification is the method of the notification sent when a trigger
+// with the notify action fires
+const triggerNotification = "notifications/terminal/trigger"
+
 type Server struct {
 	mcpServer       *server.MCPServer
 	sessionManager  *session.Manager
+	startTime       time.Time
+	registry        *tools.Registry // Registered tools, with their handlers as wrapped
+	audit           *auditLog
+	limiter         *rateLimiter
+	guard           *callGuard
+
+	// Optional features, as configured at startup
+	statePersistence bool
+	poolSize         int
 }
 
 func NewServer() (*Server, error) {
 	slog.Info("Creating MCP server")
 	
+	// Open the audit log first so a bad path fails before anything starts
+	audit, err := newAuditLogFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
 	// Create session manager
 	sm := session.NewManager()
 
 	// Create MCP server instance
 	mcpServer := server.NewMCPServer(
-		"mcp-terminal-tester",
-		"1.0.0",
+		serverName,
+		Version,
 		server.WithToolCapabilities(true),
 	)
 
 	s := &Server{
 		mcpServer:      mcpServer,
 		sessionManager: sm,
+		startTime:      time.Now(),
+		audit:          audit,
+		limiter:        newRateLimiterFromEnv(),
+		guard:          newCallGuardFromEnv(),
+	}
+	s.limiter.resolve = sm.SessionIDFor
+	s.limiter.onLimited = func(tool, sessionID, scope string) {
+		sm.RecordActivity(session.ActivityRateLimited, sessionID, map[string]interface{}{
+			"tool":  tool,
+			"scope": scope,
+		})
+	}
+	// Triggers with the notify action reach clients as notifications
+	sm.SetNotifier(func(activity session.Activity) {
+		mcpServer.SendNotificationToAllClients(triggerNotification, map[string]any{
+			"session_id": activity.SessionID,
+			"time":       activity.Time,
+			"data":       activity.Data,
+		})
+	})
+
+	// Record sessions on disk so processes orphaned by a crash can be found
+	if err := sm.EnableStatePersistence(stateDir()); err != nil {
+		slog.Warn("Session state persistence disabled", slog.String("error", err.Error()))
+	} else {
+		s.statePersistence = true
 	}
 
 	// Register tools
 	if err := s.registerTools(); err != nil {
 		slog.Error("Failed to register tools", slog.String("error", err.Error()))
+		audit.Close()
 		return nil, fmt.Errorf("failed to register tools: %w", err)
 	}
 
 	// Start session cleanup routine
 	sm.StartCleanupRoutine()
 
-	slog.Info("MCP server created successfully", slog.Int("tools_registered", 8))
+	// Optionally keep pre-warmed sessions ready for launch_app with pooled=true
+	if size, err := strconv.Atoi(os.Getenv("POOL_SIZE")); err == nil && size > 0 {
+		command := os.Getenv("POOL_COMMAND")
+		if command == "" {
+			command = "sh"
+		}
+		sm.ConfigurePool(size, command, nil)
+		s.poolSize = size
+	}
+
+	slog.Info("MCP server created successfully", slog.Int("tools_registered", len(s.registry.Names())))
 	return s, nil
 }
 
+// stateDir returns the directory for session state files, from STATE_DIR or
+// the user cache directory
+func stateDir() string {
+	if dir := os.Getenv("STATE_DIR"); dir != "" {
+		return dir
+	}
+	if cacheDir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(cacheDir, "terminalbridge")
+	}
+	return filepath.Join(os.TempDir(), "terminalbridge")
+}
+
+// specs describes the tools the server serves itself
+func (s *Server) specs() []tools.ToolSpec {
+	return []tools.ToolSpec{
+		{
+			Name:        "ping",
+			Description: "Check that the server is alive; returns the server time and uptime",
+			Handler:     s.Ping,
+		},
+		{
+			Name:        "server_info",
+			Description: "Get the server version, build, limits, render formats, transports, features and tools",
+			Handler:     s.ServerInfo,
+		},
+	}
+}
+
+// withRequestID gives each call a request ID that is attached to every
+// record logged with the call's context. Errors returned to the client
+// include it, so a reported failure can be found in the server log.
+func withRequestID(handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		requestID := utils.NewRequestID()
+		result, err := handler(utils.WithRequestID(ctx, requestID), request)
+		if err != nil {
+			return nil, fmt.Errorf("%w (request_id: %s)", err, requestID)
+		}
+		return result, nil
+	}
+}
+
 func (s *Server) registerTools() error {
 	slog.Debug("Registering MCP tools")
 	
 	// Create tool handlers with session manager
 	toolHandlers := tools.NewHandlers(s.sessionManager)
 
-	// Register launch_app tool
-	launchTool := mcp.NewTool("launch_app",
-		mcp.WithDescription("Launch a new terminal application"),
-		mcp.WithString("command",
-			mcp.Required(),
-			mcp.Description("The command to execute"),
-		),
-		mcp.WithArray("args",
-			mcp.Description("Command arguments"),
-			mcp.Items(map[string]any{"type": "string"}),
-		),
-		mcp.WithObject("env",
-			mcp.Description("Environment variables"),
-		),
-	)
-	s.mcpServer.AddTool(launchTool, toolHandlers.LaunchApp)
-
-	// Register view_screen tool
-	viewTool := mcp.NewTool("view_screen",
-		mcp.WithDescription("Get the current terminal screen content"),
-		mcp.WithString("session_id",
-			mcp.Required(),
-			mcp.Description("The session ID"),
-		),
-		mcp.WithString("format",
-			mcp.Description("Output format"),
-			mcp.Enum("plain", "raw", "ansi"),
-			mcp.DefaultString("plain"),
-		),
-	)
-	s.mcpServer.AddTool(viewTool, toolHandlers.ViewScreen)
-
-	// Register send_keys tool
-	sendKeysTool := mcp.NewTool("send_keys",
-		mcp.WithDescription("Send keyboard input to the terminal"),
-		mcp.WithString("session_id",
-			mcp.Required(),
-			mcp.Description("The session ID"),
-		),
-		mcp.WithString("keys",
-			mcp.Required(),
-			mcp.Description("The keys to send"),
-		),
-	)
-	s.mcpServer.AddTool(sendKeysTool, toolHandlers.SendKeys)
-
-	// Register get_cursor_position tool
-	cursorTool := mcp.NewTool("get_cursor_position",
-		mcp.WithDescription("Get the current cursor position"),
-		mcp.WithString("session_id",
-			mcp.Required(),
-			mcp.Description("The session ID"),
-		),
-	)
-	s.mcpServer.AddTool(cursorTool, toolHandlers.GetCursorPosition)
-
-	// Register get_screen_size tool
-	sizeTool := mcp.NewTool("get_screen_size",
-		mcp.WithDescription("Get the terminal screen dimensions"),
-		mcp.WithString("session_id",
-			mcp.Required(),
-			mcp.Description("The session ID"),
-		),
-	)
-	s.mcpServer.AddTool(sizeTool, toolHandlers.GetScreenSize)
-
-	// Register restart_app tool
-	restartTool := mcp.NewTool("restart_app",
-		mcp.WithDescription("Restart a terminal session"),
-		mcp.WithString("session_id",
-			mcp.Required(),
-			mcp.Description("The session ID"),
-		),
-	)
-	s.mcpServer.AddTool(restartTool, toolHandlers.RestartApp)
-
-	// Register stop_app tool
-	stopTool := mcp.NewTool("stop_app",
-		mcp.WithDescription("Stop a terminal session"),
-		mcp.WithString("session_id",
-			mcp.Required(),
-			mcp.Description("The session ID"),
-		),
-	)
-	s.mcpServer.AddTool(stopTool, toolHandlers.StopApp)
+	// A bad configured default should stop the server now, not fail the
+	// first view_screen call
+	if format := os.Getenv("MCP_DEFAULT_FORMAT"); format != "" {
+		if err := toolHandlers.SetDefaultFormat(format); err != nil {
+			return fmt.Errorf("invalid MCP_DEFAULT_FORMAT: %w", err)
+		}
+	}
+	if err := toolHandlers.LoadKeymapFile(); err != nil {
+		return fmt.Errorf("invalid MCP_KEYMAP_FILE: %w", err)
+	}
 
-	// Register list_sessions tool
-	listTool := mcp.NewTool("list_sessions",
-		mcp.WithDescription("List all active terminal sessions"),
-	)
-	s.mcpServer.AddTool(listTool, toolHandlers.ListSessions)
-
-	// Register resize_terminal tool
-	resizeTool := mcp.NewTool("resize_terminal",
-		mcp.WithDescription("Resize the terminal window"),
-		mcp.WithString("session_id",
-			mcp.Required(),
-			mcp.Description("The session ID"),
-		),
-		mcp.WithNumber("width",
-			mcp.Required(),
-			mcp.Description("Terminal width in columns"),
-			mcp.Min(1),
-			mcp.Max(500),
-		),
-		mcp.WithNumber("height",
-			mcp.Required(),
-			mcp.Description("Terminal height in rows"),
-			mcp.Min(1),
-			mcp.Max(200),
-		),
-	)
-	s.mcpServer.AddTool(resizeTool, toolHandlers.ResizeTerminal)
+	// Every call, including each call of a batch, gets rate limiting,
+	// auditing and a deadline. Calls refused by the rate limiter are not
+	// audited, so a runaway client can't flood the audit log.
+	registry := tools.NewRegistry(append(toolHandlers.Specs(), s.specs()...)...)
+	registry.Wrap(func(name string, handler tools.HandlerFunc) tools.HandlerFunc {
+		return tools.HandlerFunc(s.limiter.wrap(name, s.audit.wrap(name, s.guard.wrap(name, server.ToolHandlerFunc(handler)))))
+	})
+	for _, spec := range registry.Specs() {
+		s.mcpServer.AddTool(spec.Tool(), withRequestID(server.ToolHandlerFunc(spec.Handler)))
+	}
+	s.registry = registry
 
 	slog.Debug("All tools registered successfully")
 	return nil
 }
 
+// Run serves MCP over stdio until ctx is cancelled or stdin is closed
 func (s *Server) Run(ctx context.Context) error {
 	slog.Info("Starting MCP server in stdio mode")
-	err := server.ServeStdio(s.mcpServer)
+	err := serveStdio(ctx, s.mcpServer, os.Stdin, os.Stdout)
+	if errors.Is(err, context.Canceled) {
+		err = nil
+	}
 	if err != nil {
 		slog.Error("MCP server error", slog.String("error", err.Error()))
 	}
 	return err
+}
+
+// MCPServer returns the underlying MCP server, e.g. to serve it over another
+// transport or to an in-process client
+func (s *Server) MCPServer() *server.MCPServer {
+	return s.mcpServer
+}
+
+// Shutdown stops background routines and terminates all sessions
+func (s *Server) Shutdown() {
+	slog.Info("Shutting down session manager")
+	s.sessionManager.Shutdown()
+	if err := s.audit.Close(); err != nil {
+		slog.Warn("Failed to close audit log", slog.String("error", err.Error()))
+	}
 }
\ No newline at end of file
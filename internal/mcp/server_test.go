@@ -0,0 +1,274 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+	"github.com/bioharz/mcp-terminal-tester/internal/tools"
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// listTools returns the registered tools as advertised over tools/list
+func listTools(t *testing.T, s *Server) map[string]mcp.Tool {
+	t.Helper()
+	resp := s.mcpServer.HandleMessage(context.Background(),
+		json.RawMessage(`{"jsonrpc": "2.0", "id": 1, "method": "tools/list"}`))
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatalf("Failed to marshal tools/list response: %v", err)
+	}
+	var decoded struct {
+		Result mcp.ListToolsResult `json:"result"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to decode tools/list response: %v", err)
+	}
+
+	registered := make(map[string]mcp.Tool)
+	for _, tool := range decoded.Result.Tools {
+		registered[tool.Name] = tool
+	}
+	return registered
+}
+
+// callToolRaw invokes a tool over tools/call and returns its text result,
+// or the error message if the call failed
+func callToolRaw(t *testing.T, s *Server, name string, args map[string]interface{}) (string, string) {
+	t.Helper()
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+	request, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  "tools/call",
+		"params":  map[string]interface{}{"name": name, "arguments": args},
+	})
+	resp := s.mcpServer.HandleMessage(context.Background(), request)
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatalf("Failed to marshal %s response: %v", name, err)
+	}
+	var decoded struct {
+		Result struct {
+			Content []mcp.TextContent `json:"content"`
+			IsError bool              `json:"isError"`
+		} `json:"result"`
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to decode %s response: %v", name, err)
+	}
+	if decoded.Error != nil {
+		return "", decoded.Error.Message
+	}
+	var text strings.Builder
+	for _, content := range decoded.Result.Content {
+		text.WriteString(content.Text)
+	}
+	if decoded.Result.IsError {
+		return "", text.String()
+	}
+	return text.String(), ""
+}
+
+// callTool invokes a tool that must succeed and decodes its JSON result
+func callTool(t *testing.T, s *Server, name string, args map[string]interface{}, result interface{}) {
+	t.Helper()
+	text, errMsg := callToolRaw(t, s, name, args)
+	if errMsg != "" {
+		t.Fatalf("%s failed: %s", name, errMsg)
+	}
+	if err := json.Unmarshal([]byte(text), result); err != nil {
+		t.Fatalf("Failed to decode %s result %q: %v", name, text, err)
+	}
+}
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	utils.InitLogger()
+	t.Setenv("STATE_DIR", t.TempDir())
+	s, err := NewServer()
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func TestDimensionSchemaMatchesValidator(t *testing.T) {
+	t.Setenv("MAX_TERMINAL_WIDTH", "")
+	t.Setenv("MAX_TERMINAL_HEIGHT", "")
+	checkDimensionSchema(t, newTestServer(t), tools.DefaultMaxWidth, tools.DefaultMaxHeight)
+}
+
+func TestDimensionLimitsEnvOverride(t *testing.T) {
+	t.Setenv("MAX_TERMINAL_WIDTH", "2000")
+	t.Setenv("MAX_TERMINAL_HEIGHT", "800")
+	checkDimensionSchema(t, newTestServer(t), 2000, 800)
+}
+
+func TestInvalidDefaultFormatFailsStartup(t *testing.T) {
+	utils.InitLogger()
+	t.Setenv("STATE_DIR", t.TempDir())
+	t.Setenv("MCP_DEFAULT_FORMAT", "fancy")
+
+	s, err := NewServer()
+	if err == nil {
+		s.Shutdown()
+		t.Fatal("Expected NewServer to reject an invalid MCP_DEFAULT_FORMAT")
+	}
+	if !strings.Contains(err.Error(), "MCP_DEFAULT_FORMAT") {
+		t.Errorf("Expected error to name the variable, got %v", err)
+	}
+}
+
+func TestValidDefaultFormat(t *testing.T) {
+	t.Setenv("MCP_DEFAULT_FORMAT", "ansi")
+	newTestServer(t)
+}
+
+func checkDimensionSchema(t *testing.T, s *Server, maxWidth, maxHeight int) {
+	t.Helper()
+	limits := tools.DimensionLimitsFromEnv()
+	if limits.MaxWidth != maxWidth || limits.MaxHeight != maxHeight {
+		t.Fatalf("Expected limits %dx%d, got %+v", maxWidth, maxHeight, limits)
+	}
+
+	// The validator accepts exactly the advertised range
+	if err := limits.Validate(maxWidth, maxHeight); err != nil {
+		t.Errorf("Validator rejected advertised maximum: %v", err)
+	}
+	if err := limits.Validate(tools.MinDimension, tools.MinDimension); err != nil {
+		t.Errorf("Validator rejected advertised minimum: %v", err)
+	}
+	if limits.Validate(maxWidth+1, maxHeight) == nil || limits.Validate(maxWidth, maxHeight+1) == nil {
+		t.Error("Validator accepted a size above the advertised maximum")
+	}
+	if limits.Validate(tools.MinDimension-1, maxHeight) == nil {
+		t.Error("Validator accepted a size below the advertised minimum")
+	}
+
+	registered := listTools(t, s)
+	expected := map[string]float64{"width": float64(maxWidth), "height": float64(maxHeight)}
+	for _, name := range []string{"launch_app", "resize_terminal", "duplicate_session"} {
+		tool, ok := registered[name]
+		if !ok {
+			t.Errorf("Tool %s not registered", name)
+			continue
+		}
+		for param, max := range expected {
+			prop, ok := tool.InputSchema.Properties[param].(map[string]interface{})
+			if !ok {
+				t.Errorf("%s: missing %s property", name, param)
+				continue
+			}
+			if prop["minimum"] != float64(tools.MinDimension) {
+				t.Errorf("%s.%s: schema minimum %v, validator minimum %d", name, param, prop["minimum"], tools.MinDimension)
+			}
+			if prop["maximum"] != max {
+				t.Errorf("%s.%s: schema maximum %v, validator maximum %v", name, param, prop["maximum"], max)
+			}
+		}
+	}
+}
+
+func TestPing(t *testing.T) {
+	s := newTestServer(t)
+
+	var resp struct {
+		OK       bool   `json:"ok"`
+		Time     string `json:"time"`
+		UptimeMS *int64 `json:"uptime_ms"`
+	}
+	callTool(t, s, "ping", nil, &resp)
+	if !resp.OK || resp.Time == "" || resp.UptimeMS == nil || *resp.UptimeMS < 0 {
+		t.Errorf("Unexpected ping response: %+v", resp)
+	}
+}
+
+func TestServerInfoMatchesConfiguration(t *testing.T) {
+	t.Setenv("MAX_TERMINAL_WIDTH", "300")
+	t.Setenv("MAX_TERMINAL_HEIGHT", "")
+	t.Setenv("MCP_MAX_INPUT_BYTES", "4096")
+	t.Setenv("MCP_MAX_OUTPUT_BYTES", "")
+	t.Setenv("POOL_SIZE", "")
+	s := newTestServer(t)
+
+	var info Capabilities
+	callTool(t, s, "server_info", nil, &info)
+
+	if !reflect.DeepEqual(info.RenderFormats, terminal.RenderFormats) {
+		t.Errorf("Render formats %v, want %v", info.RenderFormats, terminal.RenderFormats)
+	}
+	if info.Version != Version || info.Build.GoVersion == "" {
+		t.Errorf("Unexpected version info: %q %+v", info.Version, info.Build)
+	}
+
+	want := CapabilityLimits{
+		MaxSessions:    s.sessionManager.MaxSessions(),
+		MinDimension:   tools.MinDimension,
+		MaxWidth:       300,
+		MaxHeight:      tools.DefaultMaxHeight,
+		MaxInputBytes:  4096,
+		MaxOutputBytes: tools.DefaultMaxOutputBytes,
+	}
+	if info.Limits != want {
+		t.Errorf("Limits %+v, want %+v", info.Limits, want)
+	}
+
+	// Every advertised tool is registered, and every registered tool advertised
+	registered := listTools(t, s)
+	if len(info.Tools) != len(registered) {
+		t.Errorf("server_info lists %d tools, tools/list has %d", len(info.Tools), len(registered))
+	}
+	for _, name := range info.Tools {
+		if _, ok := registered[name]; !ok {
+			t.Errorf("server_info lists unregistered tool %s", name)
+		}
+	}
+
+	features := strings.Join(info.Features, ",")
+	if !strings.Contains(features, "state_persistence") || strings.Contains(features, "session_pool") {
+		t.Errorf("Unexpected features %v", info.Features)
+	}
+}
+
+func TestToolListsMatchSpecs(t *testing.T) {
+	s := newTestServer(t)
+
+	var info Capabilities
+	callTool(t, s, "server_info", nil, &info)
+	registered := listTools(t, s)
+	if len(info.Tools) != len(registered) {
+		t.Errorf("server_info lists %d tools, tools/list %d", len(info.Tools), len(registered))
+	}
+	for _, name := range info.Tools {
+		if _, ok := registered[name]; !ok {
+			t.Errorf("Tool %s in server_info is not registered", name)
+		}
+	}
+
+	// Every handler spec is served, followed by the server's own and batch
+	specs := tools.NewHandlers(s.sessionManager).Specs()
+	for i, spec := range specs {
+		if i >= len(info.Tools) || info.Tools[i] != spec.Name {
+			t.Fatalf("Expected %s at position %d, got %v", spec.Name, i, info.Tools)
+		}
+		if spec.Handler == nil || registered[spec.Name].Description != spec.Description {
+			t.Errorf("Tool %s doesn't match its spec", spec.Name)
+		}
+	}
+	if rest := info.Tools[len(specs):]; !reflect.DeepEqual(rest, []string{"ping", "server_info", tools.BatchTool}) {
+		t.Errorf("Unexpected server tools: %v", rest)
+	}
+}
@@ -0,0 +1,189 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// stdioDrainTimeout is how long requests still running when stdin closes
+// get to send their responses
+const stdioDrainTimeout = 5 * time.Second
+
+// stdioSession is the one client of the stdio transport
+type stdioSession struct {
+	notifications chan mcp.JSONRPCNotification
+	initialized   atomic.Bool
+	loggingLevel  atomic.Value
+	clientInfo    atomic.Value
+}
+
+var (
+	_ server.ClientSession         = (*stdioSession)(nil)
+	_ server.SessionWithLogging    = (*stdioSession)(nil)
+	_ server.SessionWithClientInfo = (*stdioSession)(nil)
+)
+
+func (s *stdioSession) SessionID() string { return "stdio" }
+
+func (s *stdioSession) NotificationChannel() chan<- mcp.JSONRPCNotification {
+	return s.notifications
+}
+
+func (s *stdioSession) Initialize() {
+	s.loggingLevel.Store(mcp.LoggingLevelError)
+	s.initialized.Store(true)
+}
+
+func (s *stdioSession) Initialized() bool { return s.initialized.Load() }
+
+func (s *stdioSession) GetClientInfo() mcp.Implementation {
+	info, _ := s.clientInfo.Load().(mcp.Implementation)
+	return info
+}
+
+func (s *stdioSession) SetClientInfo(info mcp.Implementation) { s.clientInfo.Store(info) }
+
+func (s *stdioSession) SetLogLevel(level mcp.LoggingLevel) { s.loggingLevel.Store(level) }
+
+func (s *stdioSession) GetLogLevel() mcp.LoggingLevel {
+	if level, ok := s.loggingLevel.Load().(mcp.LoggingLevel); ok {
+		return level
+	}
+	return mcp.LoggingLevelError
+}
+
+// stdioWriter writes whole JSON-RPC messages, one per line, for any number
+// of goroutines
+type stdioWriter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func (w *stdioWriter) write(message any) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err = w.out.Write(append(data, '\n'))
+	return err
+}
+
+// serveStdio serves srv over in and out until ctx is cancelled or in is
+// closed. Unlike the stdio server of mcp-go, which answers one message
+// before reading the next, each request is handled in a goroutine of its
+// own, so a tool call stuck on a wedged session doesn't hold up ping,
+// list_sessions or any other request behind it. Notifications from the
+// client are handled in order, as they arrive.
+func serveStdio(ctx context.Context, srv *server.MCPServer, in io.Reader, out io.Writer) error {
+	session := &stdioSession{notifications: make(chan mcp.JSONRPCNotification, 100)}
+	if err := srv.RegisterSession(ctx, session); err != nil {
+		return fmt.Errorf("register session: %w", err)
+	}
+	defer srv.UnregisterSession(ctx, session.SessionID())
+
+	ctx, cancel := context.WithCancel(srv.WithContext(ctx, session))
+	defer cancel()
+	w := &stdioWriter{out: out}
+
+	go func() {
+		for {
+			select {
+			case notification := <-session.notifications:
+				if err := w.write(notification); err != nil {
+					slog.Warn("Failed to write notification", slog.String("error", err.Error()))
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	lines := make(chan string)
+	readErr := make(chan error, 1)
+	go func() {
+		reader := bufio.NewReader(in)
+		for {
+			line, err := reader.ReadString('\n')
+			if len(line) > 0 {
+				select {
+				case lines <- line:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	var inflight sync.WaitGroup
+	var err error
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break loop
+		case err = <-readErr:
+			break loop
+		case line := <-lines:
+			if len(line) <= 1 {
+				continue // Blank line
+			}
+			var probe struct {
+				ID json.RawMessage `json:"id"`
+			}
+			if json.Unmarshal([]byte(line), &probe) != nil {
+				if err := w.write(mcp.NewJSONRPCError(mcp.NewRequestId(nil), mcp.PARSE_ERROR, "Parse error", nil)); err != nil {
+					return fmt.Errorf("failed to write response: %w", err)
+				}
+				continue
+			}
+			if probe.ID == nil {
+				srv.HandleMessage(ctx, json.RawMessage(line))
+				continue
+			}
+			inflight.Add(1)
+			go func() {
+				defer inflight.Done()
+				if response := srv.HandleMessage(ctx, json.RawMessage(line)); response != nil {
+					if err := w.write(response); err != nil {
+						slog.Warn("Failed to write response", slog.String("error", err.Error()))
+					}
+				}
+			}()
+		}
+	}
+
+	// Let requests already running answer, within reason
+	done := make(chan struct{})
+	go func() {
+		inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	case <-time.After(stdioDrainTimeout):
+	}
+
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,267 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// Default call limits, in milliseconds, on top of any wait the call asks
+// for with timeout_ms
+const (
+	defaultCallTimeoutMs = 60000
+	defaultSlowCallMs    = 10000
+)
+
+// callTimeoutCode marks a call that ran past its deadline
+const callTimeoutCode = "call_timeout"
+
+const (
+	// callTimeoutGrace is how long a call past its deadline gets to return
+	// its own answer to the cancellation before it is answered for
+	callTimeoutGrace = time.Second
+
+	// maxStackFrames bounds the functions a slow call warning lists
+	maxStackFrames = 20
+)
+
+// callGuard runs each tool call in a goroutine of its own under a deadline,
+// so a handler stuck on a wedged session is answered with a structured
+// timeout instead of never, and warns with the handler's stack when a call
+// is slow. Both limits are extended by the wait the call asks for.
+type callGuard struct {
+	timeout time.Duration // 0 disables the deadline
+	slow    time.Duration // 0 disables the warning
+}
+
+// newCallGuardFromEnv reads MCP_CALL_TIMEOUT_MS and MCP_SLOW_CALL_MS; 0
+// disables either
+func newCallGuardFromEnv() *callGuard {
+	return &callGuard{
+		timeout: msFromEnv("MCP_CALL_TIMEOUT_MS", defaultCallTimeoutMs),
+		slow:    msFromEnv("MCP_SLOW_CALL_MS", defaultSlowCallMs),
+	}
+}
+
+func msFromEnv(name string, fallback int) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return time.Duration(fallback) * time.Millisecond
+	}
+	ms, err := strconv.Atoi(value)
+	if err != nil || ms < 0 {
+		slog.Warn("Ignoring invalid call limit",
+			slog.String("variable", name),
+			slog.String("value", value),
+			slog.Int("default", fallback),
+		)
+		ms = fallback
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// requestedWait is how long a call asks to wait: its timeout_ms, for each
+// repetition, or for a batch the waits of its calls together
+func requestedWait(args map[string]interface{}) time.Duration {
+	if calls, ok := args["calls"].([]interface{}); ok {
+		var total time.Duration
+		for _, c := range calls {
+			if call, ok := c.(map[string]interface{}); ok {
+				inner, _ := call["arguments"].(map[string]interface{})
+				total += requestedWait(inner)
+			}
+		}
+		return total
+	}
+	ms := numberArg(args, "timeout_ms")
+	if repetitions := numberArg(args, "repetitions"); repetitions > 1 {
+		ms *= repetitions
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// numberArg returns a number argument, given as a number or a numeric
+// string, or 0
+func numberArg(args map[string]interface{}, name string) float64 {
+	switch v := args[name].(type) {
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	case string:
+		n, _ := strconv.ParseFloat(v, 64)
+		return n
+	}
+	return 0
+}
+
+// callResult is what a handler returned
+type callResult struct {
+	result *mcp.CallToolResult
+	err    error
+}
+
+// wrap returns a handler that runs calls to tool under the guard
+func (g *callGuard) wrap(tool string, handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+	if g.timeout == 0 && g.slow == 0 {
+		return handler
+	}
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		wait := requestedWait(request.GetArguments())
+		callCtx, cancel := ctx, context.CancelFunc(func() {})
+		if g.timeout > 0 {
+			callCtx, cancel = context.WithTimeout(ctx, g.timeout+wait)
+		}
+		defer cancel()
+
+		start := time.Now()
+		goroutine := make(chan uint64, 1)
+		done := make(chan callResult, 1)
+		go func() {
+			goroutine <- goroutineID()
+			result, err := handler(callCtx, request)
+			done <- callResult{result, err}
+		}()
+		id := <-goroutine
+
+		if g.slow > 0 {
+			warning := time.AfterFunc(g.slow+wait, func() {
+				g.warnSlow(ctx, tool, request, id, start)
+			})
+			defer warning.Stop()
+		}
+
+		select {
+		case r := <-done:
+			return r.result, r.err
+		case <-callCtx.Done():
+		}
+
+		// Cancelled, by the caller or the deadline: a handler that finishes
+		// or answers for itself soon after is left to. Past the deadline,
+		// anything else gets a timeout.
+		select {
+		case r := <-done:
+			if r.err == nil || ctx.Err() != nil {
+				return r.result, r.err
+			}
+		case <-time.After(callTimeoutGrace):
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+		}
+		elapsed := time.Since(start)
+		slog.WarnContext(ctx, "Tool call timed out",
+			slog.String("tool", tool),
+			slog.String("session_id", sessionArg(request)),
+			slog.Duration("elapsed", elapsed),
+		)
+		return callTimeoutResult(tool, g.timeout+wait, elapsed), nil
+	}
+}
+
+// warnSlow logs a call that is taking long, with where its handler is
+func (g *callGuard) warnSlow(ctx context.Context, tool string, request mcp.CallToolRequest, id uint64, start time.Time) {
+	state, stack := goroutineStack(id, maxStackFrames)
+	if state == "" {
+		return // Finished in the meantime
+	}
+	slog.WarnContext(ctx, "Slow tool call",
+		slog.String("tool", tool),
+		slog.String("session_id", sessionArg(request)),
+		slog.Duration("elapsed", time.Since(start)),
+		slog.String("state", state),
+		slog.Any("stack", stack),
+	)
+}
+
+// sessionArg returns the call's session_id argument, if any
+func sessionArg(request mcp.CallToolRequest) string {
+	id, _ := request.GetArguments()["session_id"].(string)
+	return id
+}
+
+// callTimeoutResult tells the client a call was cancelled for running past
+// its deadline
+func callTimeoutResult(tool string, limit, elapsed time.Duration) *mcp.CallToolResult {
+	jsonData, _ := json.Marshal(map[string]interface{}{
+		"error":      fmt.Sprintf("%s didn't finish within %d ms and was cancelled", tool, limit.Milliseconds()),
+		"code":       callTimeoutCode,
+		"tool":       tool,
+		"limit_ms":   limit.Milliseconds(),
+		"elapsed_ms": elapsed.Milliseconds(),
+	})
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: string(jsonData),
+			},
+		},
+		IsError: true,
+	}
+}
+
+// goroutineID returns the ID of the calling goroutine, from the header of
+// its stack trace ("goroutine 42 [running]:")
+func goroutineID() uint64 {
+	var buf [64]byte
+	header := buf[:runtime.Stack(buf[:], false)]
+	header = bytes.TrimPrefix(header, []byte("goroutine "))
+	if i := bytes.IndexByte(header, ' '); i > 0 {
+		header = header[:i]
+	}
+	id, _ := strconv.ParseUint(string(header), 10, 64)
+	return id
+}
+
+// goroutineStack returns the state of goroutine id, such as "select, 2
+// minutes", and the functions on its stack, innermost first and at most
+// max. The state is empty when there is no such goroutine.
+func goroutineStack(id uint64, max int) (string, []string) {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	prefix := fmt.Sprintf("goroutine %d [", id)
+	for _, trace := range strings.Split(string(buf), "\n\n") {
+		if !strings.HasPrefix(trace, prefix) {
+			continue
+		}
+		lines := strings.Split(trace, "\n")
+		state := strings.TrimSuffix(strings.TrimPrefix(lines[0], prefix), "]:")
+		var stack []string
+		// Each frame is a function line then a tab-indented file line
+		for _, line := range lines[1:] {
+			if strings.HasPrefix(line, "\t") || line == "" {
+				continue
+			}
+			if len(stack) == max {
+				stack = append(stack, "...")
+				break
+			}
+			if i := strings.LastIndexByte(line, '('); i > 0 {
+				line = line[:i]
+			}
+			stack = append(stack, line)
+		}
+		return state, stack
+	}
+	return "", nil
+}
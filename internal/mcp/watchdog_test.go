@@ -0,0 +1,217 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestRequestedWait(t *testing.T) {
+	tests := []struct {
+		name string
+		args map[string]interface{}
+		want time.Duration
+	}{
+		{"none", map[string]interface{}{"session_id": "s"}, 0},
+		{"timeout", map[string]interface{}{"timeout_ms": float64(2500)}, 2500 * time.Millisecond},
+		{"as string", map[string]interface{}{"timeout_ms": "300"}, 300 * time.Millisecond},
+		{"repetitions", map[string]interface{}{"timeout_ms": float64(100), "repetitions": float64(5)}, 500 * time.Millisecond},
+		{"batch", map[string]interface{}{"calls": []interface{}{
+			map[string]interface{}{"tool": "wait_for_stable_screen", "arguments": map[string]interface{}{"timeout_ms": float64(1000)}},
+			map[string]interface{}{"tool": "view_screen"},
+			map[string]interface{}{"tool": "wait_for_cursor", "arguments": map[string]interface{}{"timeout_ms": float64(2000)}},
+		}}, 3 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := requestedWait(tt.args); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestCallGuardAnswersForWedgedHandler(t *testing.T) {
+	utils.InitLogger()
+	logs := captureLogs(t)
+
+	// A handler that ignores its context, as one stuck in a blocking call
+	// would
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	wedged := func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		<-release
+		return mcp.NewToolResultText("late"), nil
+	}
+
+	guard := &callGuard{timeout: 100 * time.Millisecond, slow: 50 * time.Millisecond}
+	handler := guard.wrap("send_keys", wedged)
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{"session_id": "wedged"}
+
+	start := time.Now()
+	result, err := handler(context.Background(), request)
+	if err != nil {
+		t.Fatalf("Expected a result, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > callTimeoutGrace+time.Second {
+		t.Errorf("Expected an answer soon after the deadline, took %v", elapsed)
+	}
+	var response struct {
+		Code    string `json:"code"`
+		Tool    string `json:"tool"`
+		LimitMs int64  `json:"limit_ms"`
+	}
+	if !result.IsError {
+		t.Fatalf("Expected an error result, got %+v", result)
+	}
+	if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Code != callTimeoutCode || response.Tool != "send_keys" || response.LimitMs != 100 {
+		t.Errorf("Unexpected timeout response %+v", response)
+	}
+
+	var warning map[string]interface{}
+	for _, record := range logs.records(t) {
+		if record["msg"] == "Slow tool call" {
+			warning = record
+		}
+	}
+	if warning == nil {
+		t.Fatal("Expected a slow call warning")
+	}
+	if warning["tool"] != "send_keys" || warning["session_id"] != "wedged" || !strings.HasPrefix(warning["state"].(string), "chan receive") {
+		t.Errorf("Unexpected warning %v", warning)
+	}
+	stack, _ := warning["stack"].([]interface{})
+	if len(stack) == 0 || !strings.Contains(fmt.Sprint(stack[0]), "TestCallGuardAnswersForWedgedHandler") {
+		t.Errorf("Expected the stack to start in the wedged handler, got %v", stack)
+	}
+
+	// A handler that answers in time is passed through
+	quick := guard.wrap("ping", func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText("pong"), nil
+	})
+	if result, err := quick(context.Background(), request); err != nil || result.IsError {
+		t.Errorf("Expected the quick call to pass, got %+v, %v", result, err)
+	}
+}
+
+// stdioClient talks JSON-RPC to serveStdio over pipes
+type stdioClient struct {
+	in        *io.PipeWriter
+	responses chan map[string]interface{}
+}
+
+func newStdioClient(t *testing.T, s *Server) *stdioClient {
+	t.Helper()
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serveStdio(ctx, s.mcpServer, inR, outW) }()
+	t.Cleanup(func() {
+		cancel()
+		inW.Close()
+		outR.Close()
+		<-served
+	})
+
+	c := &stdioClient{in: inW, responses: make(chan map[string]interface{}, 16)}
+	go func() {
+		scanner := bufio.NewScanner(outR)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			var message map[string]interface{}
+			if json.Unmarshal(scanner.Bytes(), &message) == nil && message["id"] != nil {
+				c.responses <- message
+			}
+		}
+	}()
+	return c
+}
+
+func (c *stdioClient) send(t *testing.T, id int, method string, params interface{}) {
+	t.Helper()
+	message, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method, "params": params})
+	go c.in.Write(append(message, '\n'))
+}
+
+// await returns the response to request id, setting aside others that
+// arrive first
+func (c *stdioClient) await(t *testing.T, id int, pending map[int]map[string]interface{}, timeout time.Duration) map[string]interface{} {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		if response, ok := pending[id]; ok {
+			delete(pending, id)
+			return response
+		}
+		select {
+		case response := <-c.responses:
+			pending[int(response["id"].(float64))] = response
+		case <-deadline:
+			t.Fatalf("No response to request %d within %v", id, timeout)
+		}
+	}
+}
+
+func TestStdioAnswersWhileASessionIsWedged(t *testing.T) {
+	s := newTestServer(t)
+
+	// An application that never reads its input, so a large paste into it
+	// blocks until the write deadline
+	var launched struct {
+		SessionID string `json:"session_id"`
+	}
+	callTool(t, s, "launch_app", map[string]interface{}{
+		"command": "sh",
+		"args":    []interface{}{"-c", "stty raw -echo; echo ready; exec sleep 600"},
+	}, &launched)
+	var waited map[string]interface{}
+	callTool(t, s, "wait_for_stable_screen", map[string]interface{}{"session_id": launched.SessionID, "stable_ms": 300, "timeout_ms": 5000}, &waited)
+
+	client := newStdioClient(t, s)
+	pending := make(map[int]map[string]interface{})
+	started := time.Now()
+	client.send(t, 1, "tools/call", map[string]interface{}{
+		"name":      "send_keys",
+		"arguments": map[string]interface{}{"session_id": launched.SessionID, "keys": strings.Repeat("x", 256*1024)},
+	})
+	time.Sleep(200 * time.Millisecond)
+
+	const bound = 500 * time.Millisecond
+	for i := 0; i < 5; i++ {
+		id := 10 + 2*i
+		start := time.Now()
+		client.send(t, id, "ping", nil)
+		client.await(t, id, pending, bound)
+		pingLatency := time.Since(start)
+
+		start = time.Now()
+		client.send(t, id+1, "tools/call", map[string]interface{}{"name": "list_sessions", "arguments": map[string]interface{}{}})
+		response := client.await(t, id+1, pending, bound)
+		if result, _ := response["result"].(map[string]interface{}); result == nil || result["isError"] == true {
+			t.Errorf("list_sessions failed: %v", response)
+		}
+		t.Logf("ping %v, list_sessions %v", pingLatency, time.Since(start))
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	// The wedged call still gets its answer once its write gives up
+	response := client.await(t, 1, pending, 15*time.Second)
+	result, _ := response["result"].(map[string]interface{})
+	if result == nil || result["isError"] != true || !strings.Contains(fmt.Sprint(result["content"]), "not consuming input") {
+		t.Errorf("Expected send_keys to report blocked input, got %v", response)
+	}
+	if elapsed := time.Since(started); elapsed < 2*time.Second {
+		t.Errorf("Expected send_keys to have been blocked, it answered after %v", elapsed)
+	}
+}
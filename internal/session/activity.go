@@ -0,0 +1,133 @@
+package session
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultActivityRecords is how many entries the manager's activity log
+// keeps before the oldest are dropped
+const defaultActivityRecords = 1000
+
+// Activity types that only the manager's log has; the rest are the session
+// event types
+const (
+	ActivityCleanup     = "cleanup"      // An idle session cleanup run closed sessions
+	ActivityRateLimited = "rate_limited" // The server started refusing calls
+)
+
+// activityEvents are the session event types that also go to the manager's
+// activity log. Terminal events such as bells are left to each session.
+var activityEvents = map[string]bool{
+	EventCreated:     true,
+	EventRestarted:   true,
+	EventExited:      true,
+	EventIdleCleanup: true,
+	EventClosed:      true,
+}
+
+// Activity is an entry in the manager's log of what happened across all
+// sessions
+type Activity struct {
+	Time      time.Time              `json:"time"`
+	Type      string                 `json:"type"`
+	SessionID string                 `json:"session_id,omitempty"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+}
+
+// activityLog keeps the manager's most recent activity. It has its own lock,
+// so sessions append to it without taking the manager's.
+type activityLog struct {
+	mu      sync.Mutex
+	entries []Activity
+	max     int
+	notify  func(Activity) // Receives the firings of triggers with notify; see SetNotifier
+}
+
+func newActivityLog(max int) *activityLog {
+	return &activityLog{max: max}
+}
+
+func (l *activityLog) add(entry Activity) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, entry)
+	if len(l.entries) > l.max {
+		l.entries = append([]Activity(nil), l.entries[len(l.entries)-l.max:]...)
+	}
+}
+
+// addNotify adds entry and passes it to the notifier, if one is set
+func (l *activityLog) addNotify(entry Activity) {
+	l.add(entry)
+	l.mu.Lock()
+	notify := l.notify
+	l.mu.Unlock()
+	if notify != nil {
+		notify(entry)
+	}
+}
+
+// since returns up to limit of the newest entries after t, for sessionID
+// if it isn't empty, oldest first. A limit of 0 or less returns all of them.
+func (l *activityLog) since(t time.Time, sessionID string, limit int) []Activity {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	matched := []Activity{}
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		if limit > 0 && len(matched) == limit {
+			break
+		}
+		// Sessions record concurrently, so times can be slightly out of
+		// order; every entry is checked
+		entry := l.entries[i]
+		if entry.Time.After(t) && (sessionID == "" || entry.SessionID == sessionID) {
+			matched = append(matched, entry)
+		}
+	}
+	for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+		matched[i], matched[j] = matched[j], matched[i]
+	}
+	return matched
+}
+
+// trackActivity copies the session's lifecycle events, past and future, to
+// the manager's activity log, along with the firings of triggers that have
+// the notify action
+func (m *Manager) trackActivity(session *Session) {
+	id := session.ID
+	session.events.forwardTo(func(event Event) {
+		entry := Activity{Time: event.Time, Type: event.Type, SessionID: id, Data: event.Data}
+		switch {
+		case activityEvents[event.Type]:
+			m.activity.add(entry)
+		case event.Type == EventTrigger && event.Data["notify"] == true:
+			m.activity.addNotify(entry)
+		}
+	})
+}
+
+// SetNotifier sets a function that receives the firings of triggers with
+// the notify action as they are added to the activity log, such as to
+// pass them on to clients. It must not block.
+func (m *Manager) SetNotifier(fn func(Activity)) {
+	m.activity.mu.Lock()
+	defer m.activity.mu.Unlock()
+	m.activity.notify = fn
+}
+
+// RecordActivity adds an entry to the activity log, for things that happen
+// outside the session package, such as rate limiting. sessionID may be
+// empty.
+func (m *Manager) RecordActivity(activityType, sessionID string, data map[string]interface{}) {
+	m.activity.add(Activity{Time: m.clock.Now(), Type: activityType, SessionID: sessionID, Data: data})
+}
+
+// RecentActivity returns up to limit of the newest activity log entries
+// after since, oldest first. A non-empty sessionID keeps only that session's
+// entries, including those of a removed session.
+func (m *Manager) RecentActivity(since time.Time, sessionID string, limit int) []Activity {
+	return m.activity.since(since, strings.ToLower(sessionID), limit)
+}
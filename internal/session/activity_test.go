@@ -0,0 +1,95 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+)
+
+func TestActivityLog(t *testing.T) {
+	log := newActivityLog(3)
+	start := time.Now()
+	for i, id := range []string{"a", "b", "a", "b", "a"} {
+		log.add(Activity{Time: start.Add(time.Duration(i) * time.Second), Type: EventCreated, SessionID: id})
+	}
+
+	ids := func(entries []Activity) string {
+		var s string
+		for _, e := range entries {
+			s += e.SessionID
+		}
+		return s
+	}
+	if got := ids(log.since(time.Time{}, "", 0)); got != "aba" {
+		t.Errorf("Expected the newest 3 entries, got %q", got)
+	}
+	if got := ids(log.since(time.Time{}, "a", 0)); got != "aa" {
+		t.Errorf("Expected session a's entries, got %q", got)
+	}
+	if got := ids(log.since(start.Add(3*time.Second), "", 0)); got != "a" {
+		t.Errorf("Expected entries after the timestamp, got %q", got)
+	}
+	if got := ids(log.since(time.Time{}, "", 1)); got != "a" {
+		t.Errorf("Expected the newest entry, got %q", got)
+	}
+}
+
+func TestRecentActivity(t *testing.T) {
+	utils.InitLogger()
+	m := NewManager()
+	defer m.Shutdown()
+	start := time.Now()
+
+	a, err := m.CreateSession("cat", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	b, err := m.CreateSession("sh", []string{"-c", "exit 2"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	if err := m.RestartSession(a.ID, false); err != nil {
+		t.Fatalf("Failed to restart session: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for b.LastEventSeq() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := m.RemoveSession(a.ID); err != nil {
+		t.Fatalf("Failed to remove session: %v", err)
+	}
+
+	type entry struct{ session, kind string }
+	var got []entry
+	for _, activity := range m.RecentActivity(start, "", 0) {
+		got = append(got, entry{activity.SessionID, activity.Type})
+	}
+	// b exits at some point after it is created
+	want := []entry{{a.ID, EventCreated}, {b.ID, EventCreated}, {a.ID, EventRestarted}, {a.ID, EventClosed}}
+	var exited int
+	var filtered []entry
+	for _, e := range got {
+		if e == (entry{b.ID, EventExited}) {
+			exited++
+			continue
+		}
+		filtered = append(filtered, e)
+	}
+	if exited != 1 || len(filtered) != len(want) {
+		t.Fatalf("Expected %v plus b exiting, got %v", want, got)
+	}
+	for i := range want {
+		if filtered[i] != want[i] {
+			t.Errorf("Entry %d: expected %v, got %v", i, want[i], filtered[i])
+		}
+	}
+
+	// A removed session's activity can still be picked out
+	if removed := m.RecentActivity(start, a.ID, 0); len(removed) != 3 {
+		t.Errorf("Expected a's 3 entries, got %+v", removed)
+	}
+	if none := m.RecentActivity(time.Now(), "", 0); len(none) != 0 {
+		t.Errorf("Expected nothing after now, got %+v", none)
+	}
+}
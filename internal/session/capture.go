@@ -0,0 +1,179 @@
+package session
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/clock"
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+)
+
+// Limits on frame captures. The byte limit bounds memory however large the
+// frames are; frames past either limit are counted but not kept.
+const (
+	MinCaptureInterval = 10 * time.Millisecond
+	MaxCaptureFrames   = 10000
+	MaxCaptureBytes    = 64 << 20
+)
+
+// Errors from starting and stopping frame captures
+var (
+	ErrCaptureRunning    = errors.New("a frame capture is already running for this session")
+	ErrCaptureNotRunning = errors.New("no frame capture is running for this session")
+)
+
+// Frame is one screen captured during a frame capture
+type Frame struct {
+	Time    time.Time // When the frame was rendered
+	Version uint64    // Screen generation the frame shows
+	Content string
+}
+
+// CaptureResult is what a frame capture recorded
+type CaptureResult struct {
+	Format   string
+	Interval time.Duration
+	Started  time.Time
+	Frames   []Frame
+	Dropped  int // Frames seen after the frame or byte limit was reached
+}
+
+// frameCapture renders the screen each time it changes, at most once per
+// interval, until stopped
+type frameCapture struct {
+	format    string
+	interval  time.Duration
+	maxFrames int
+	started   time.Time
+	clock     clock.Clock
+	stop      chan struct{}
+	done      chan struct{}
+
+	mu      sync.Mutex
+	frames  []Frame
+	bytes   int
+	dropped int
+}
+
+// StartFrameCapture starts recording the screen in format every time its
+// content changes, at most once per interval and keeping up to maxFrames
+// frames. The first frame is the screen as it is now.
+func (s *Session) StartFrameCapture(format string, interval time.Duration, maxFrames int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrSessionClosed
+	}
+	if s.capture != nil {
+		return ErrCaptureRunning
+	}
+
+	c := &frameCapture{
+		format:    format,
+		interval:  max(interval, MinCaptureInterval),
+		maxFrames: min(maxFrames, MaxCaptureFrames),
+		started:   s.clock.Now(),
+		clock:     s.clock,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	s.capture = c
+	go c.run(s.Buffer)
+
+	slog.Debug("Frame capture started",
+		slog.String("session_id", s.ID),
+		slog.String("format", format),
+		slog.Duration("interval", c.interval),
+		slog.Int("max_frames", c.maxFrames),
+	)
+	return nil
+}
+
+// StopFrameCapture stops the session's frame capture and returns its frames
+func (s *Session) StopFrameCapture() (*CaptureResult, error) {
+	s.mu.Lock()
+	c := s.capture
+	s.capture = nil
+	s.mu.Unlock()
+	if c == nil {
+		return nil, ErrCaptureNotRunning
+	}
+
+	result := c.finish()
+	slog.Debug("Frame capture stopped",
+		slog.String("session_id", s.ID),
+		slog.Int("frames", len(result.Frames)),
+		slog.Int("dropped", result.Dropped),
+	)
+	return result, nil
+}
+
+// stopCapture ends a running capture when the session closes
+func (s *Session) stopCapture() {
+	s.mu.Lock()
+	c := s.capture
+	s.capture = nil
+	s.mu.Unlock()
+	if c != nil {
+		c.finish()
+	}
+}
+
+func (c *frameCapture) run(sb *terminal.ScreenBuffer) {
+	defer close(c.done)
+
+	timer := time.NewTimer(c.interval)
+	defer timer.Stop()
+
+	var last uint64
+	for first := true; ; first = false {
+		// Listen before rendering so a change made meanwhile isn't missed
+		changed := sb.Changed()
+		content, version, err := sb.RenderGeneration(c.format)
+		if err == nil && (first || version != last) {
+			c.add(Frame{Time: c.clock.Now(), Version: version, Content: content})
+			last = version
+		}
+
+		timer.Reset(c.interval)
+		select {
+		case <-c.stop:
+			return
+		case <-timer.C:
+		}
+		select {
+		case <-c.stop:
+			return
+		case <-changed:
+		}
+	}
+}
+
+func (c *frameCapture) add(frame Frame) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.frames) >= c.maxFrames || c.bytes+len(frame.Content) > MaxCaptureBytes {
+		c.dropped++
+		return
+	}
+	c.frames = append(c.frames, frame)
+	c.bytes += len(frame.Content)
+}
+
+// finish stops the capture, waits for it to exit and returns its frames
+func (c *frameCapture) finish() *CaptureResult {
+	close(c.stop)
+	<-c.done
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &CaptureResult{
+		Format:   c.format,
+		Interval: c.interval,
+		Started:  c.started,
+		Frames:   c.frames,
+		Dropped:  c.dropped,
+	}
+}
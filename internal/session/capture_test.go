@@ -0,0 +1,100 @@
+//go:build !windows
+
+package session
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+)
+
+func TestSession_FrameCapture(t *testing.T) {
+	utils.InitLogger()
+
+	sess, err := NewSession("sh", []string{"-c", "for i in 1 2 3 4 5; do echo tick $i; sleep 0.1; done; sleep 10"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer sess.Close()
+
+	interval := 20 * time.Millisecond
+	if err := sess.StartFrameCapture("plain", interval, 100); err != nil {
+		t.Fatalf("StartFrameCapture failed: %v", err)
+	}
+	if err := sess.StartFrameCapture("plain", interval, 100); !errors.Is(err, ErrCaptureRunning) {
+		t.Errorf("Expected a second capture to be refused, got %v", err)
+	}
+	time.Sleep(800 * time.Millisecond)
+
+	result, err := sess.StopFrameCapture()
+	if err != nil {
+		t.Fatalf("StopFrameCapture failed: %v", err)
+	}
+	if !strings.Contains(result.Frames[len(result.Frames)-1].Content, "tick 5") {
+		t.Errorf("Expected the last frame to show tick 5, got %q", result.Frames[len(result.Frames)-1].Content)
+	}
+	ticks := 0
+	for i, frame := range result.Frames {
+		if strings.Contains(frame.Content, "tick") {
+			ticks++
+		}
+		if i == 0 {
+			continue
+		}
+		prev := result.Frames[i-1]
+		if frame.Version <= prev.Version {
+			t.Errorf("Frame %d repeats version %d", i, frame.Version)
+		}
+		if gap := frame.Time.Sub(prev.Time); gap < interval-5*time.Millisecond {
+			t.Errorf("Frames %d and %d only %v apart, interval is %v", i-1, i, gap, interval)
+		}
+	}
+	if ticks < 5 {
+		t.Errorf("Expected a frame per tick, got %d frames with ticks out of %d", ticks, len(result.Frames))
+	}
+
+	if _, err := sess.StopFrameCapture(); !errors.Is(err, ErrCaptureNotRunning) {
+		t.Errorf("Expected no capture to stop, got %v", err)
+	}
+}
+
+func TestSession_FrameCaptureLimits(t *testing.T) {
+	utils.InitLogger()
+
+	sess, err := NewSession("sh", []string{"-c", "for i in 1 2 3 4 5; do echo tick $i; sleep 0.05; done; sleep 10"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer sess.Close()
+
+	// The interval is raised to the minimum
+	if err := sess.StartFrameCapture("plain", 0, 2); err != nil {
+		t.Fatalf("StartFrameCapture failed: %v", err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	result, err := sess.StopFrameCapture()
+	if err != nil {
+		t.Fatalf("StopFrameCapture failed: %v", err)
+	}
+	if len(result.Frames) != 2 || result.Dropped == 0 {
+		t.Errorf("Expected 2 frames kept and the rest dropped, got %d kept, %d dropped", len(result.Frames), result.Dropped)
+	}
+	if result.Interval != MinCaptureInterval {
+		t.Errorf("Expected the interval raised to %v, got %v", MinCaptureInterval, result.Interval)
+	}
+
+	// Closing the session ends a running capture
+	if err := sess.StartFrameCapture("plain", MinCaptureInterval, 10); err != nil {
+		t.Fatalf("StartFrameCapture failed: %v", err)
+	}
+	sess.Close()
+	if _, err := sess.StopFrameCapture(); !errors.Is(err, ErrCaptureNotRunning) {
+		t.Errorf("Expected the capture to end with the session, got %v", err)
+	}
+	if err := sess.StartFrameCapture("plain", MinCaptureInterval, 10); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("Expected a closed session to refuse captures, got %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+package session
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/clock"
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+)
+
+// defaultEventRecords is how many events a session keeps before the oldest
+// are dropped
+const defaultEventRecords = 500
+
+// DefaultEventRetention is how long the events of a removed session stay
+// readable
+const DefaultEventRetention = 10 * time.Minute
+
+// Session event types
+const (
+	EventCreated      = "created"
+	EventRestarted    = "restarted"
+	EventExited       = "exited"       // The process went away on its own
+	EventIdleCleanup  = "cleaned_idle" // Closed by the idle session cleanup
+	EventClosed       = "closed"       // Stopped or removed through the server
+	EventBell         = "bell"
+	EventTitle        = "title"
+	EventResize       = "resize"
+	EventSignal       = "signal"       // A signal sent with send_signal
+	EventTrigger      = "trigger"      // A trigger fired; see triggers.go
+	EventUnresponsive = "unresponsive" // Input went unanswered; see responsive.go
+	EventResponsive   = "responsive"   // An unresponsive session wrote output again
+)
+
+// exitStatusWait bounds how long an exited event waits for the process's
+// exit status after its terminal went away
+const exitStatusWait = time.Second
+
+// Event is something that happened to a session. Sequence numbers start at
+// 1 and increase by one per event, so a gap between a cursor and the oldest
+// kept event means events were dropped.
+type Event struct {
+	Seq  uint64                 `json:"seq"`
+	Time time.Time              `json:"time"`
+	Type string                 `json:"type"`
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+// EventPage is a run of events after a cursor, as returned by Events
+type EventPage struct {
+	Events  []Event `json:"events"`
+	LastSeq uint64  `json:"last_seq"` // Newest event recorded, whether or not it is in the page
+	NextSeq uint64  `json:"next_since_seq"`
+	Missed  uint64  `json:"missed"`   // Events after the cursor that were dropped before being read
+	HasMore bool    `json:"has_more"` // The limit cut the page short
+}
+
+// eventRing keeps a session's most recent events
+type eventRing struct {
+	mu      sync.Mutex
+	entries []Event
+	max     int
+	seq     uint64      // Sequence number of the newest event
+	forward func(Event) // Also receives each event, if set; see forwardTo
+	clock   clock.Clock
+}
+
+func newEventRing(max int, clk clock.Clock) *eventRing {
+	return &eventRing{max: max, clock: clk}
+}
+
+func (r *eventRing) add(eventType string, data map[string]interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	event := Event{Seq: r.seq, Time: r.clock.Now(), Type: eventType, Data: data}
+	r.entries = append(r.entries, event)
+	if len(r.entries) > r.max {
+		r.entries = append([]Event(nil), r.entries[len(r.entries)-r.max:]...)
+	}
+	if r.forward != nil {
+		r.forward(event)
+	}
+}
+
+// forwardTo passes the events kept so far, and every event from now on, to
+// fn, in order. fn runs with the ring locked, so it must not read the ring.
+func (r *eventRing) forwardTo(fn func(Event)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.forward = fn
+	for _, event := range r.entries {
+		fn(event)
+	}
+}
+
+func (r *eventRing) lastSeq() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.seq
+}
+
+// since returns up to limit events with sequence numbers above cursor,
+// oldest first. A limit of 0 or less returns all of them.
+func (r *eventRing) since(cursor uint64, limit int) EventPage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// A cursor past the newest event can't have come from this ring, so
+	// the next poll starts from the newest event
+	page := EventPage{Events: []Event{}, LastSeq: r.seq, NextSeq: r.seq}
+	if cursor >= r.seq {
+		return page
+	}
+	if len(r.entries) > 0 && r.entries[0].Seq > cursor+1 {
+		page.Missed = r.entries[0].Seq - cursor - 1
+	}
+	for _, event := range r.entries {
+		if event.Seq <= cursor {
+			continue
+		}
+		if limit > 0 && len(page.Events) == limit {
+			page.HasMore = true
+			break
+		}
+		page.Events = append(page.Events, event)
+	}
+	if n := len(page.Events); n > 0 {
+		page.NextSeq = page.Events[n-1].Seq
+	}
+	return page
+}
+
+// Events returns up to limit of the session's events recorded after the
+// cursor, oldest first
+func (s *Session) Events(cursor uint64, limit int) EventPage {
+	return s.events.since(cursor, limit)
+}
+
+// LastEventSeq returns the sequence number of the session's newest event
+func (s *Session) LastEventSeq() uint64 {
+	return s.events.lastSeq()
+}
+
+func (s *Session) recordEvent(eventType string, data map[string]interface{}) {
+	s.events.add(eventType, data)
+}
+
+// recordExit records that the process went away on its own, with its exit
+// status once the process has been reaped
+func (s *Session) recordExit(pty *terminal.PTYWrapper) {
+	select {
+	case <-pty.Exited():
+	case <-time.After(exitStatusWait):
+	}
+	data := map[string]interface{}{}
+	if exited, code, status := pty.ExitStatus(); exited {
+		data["exit_code"] = code
+		data["exit_status"] = status
+	}
+	s.recordEvent(EventExited, data)
+}
+
+// recordOutputEvents records the bells and title changes a write to the
+// buffer caused, given the bell count and title from before it
+func (s *Session) recordOutputEvents(bells uint64, title string) {
+	if n := s.Buffer.Bells() - bells; n > 0 {
+		s.recordEvent(EventBell, map[string]interface{}{"count": n})
+	}
+	if now := s.Buffer.Title(); now != title {
+		s.recordEvent(EventTitle, map[string]interface{}{"title": now})
+	}
+}
+
+// retainedEvents are the events of a removed session, kept until expires
+type retainedEvents struct {
+	events  *eventRing
+	expires time.Time
+}
+
+// retainEvents keeps a removed session's events readable for the retention
+// window. Caller must hold m.mu.
+func (m *Manager) retainEvents(session *Session) {
+	if m.eventRetention <= 0 {
+		return
+	}
+	m.pruneRetainedLocked()
+	m.retained[session.ID] = retainedEvents{
+		events:  session.events,
+		expires: m.clock.Now().Add(m.eventRetention),
+	}
+}
+
+// pruneRetainedLocked drops retained events past their window. Caller must
+// hold m.mu.
+func (m *Manager) pruneRetainedLocked() {
+	now := m.clock.Now()
+	for id, kept := range m.retained {
+		if now.After(kept.expires) {
+			delete(m.retained, id)
+		}
+	}
+}
+
+// SetEventRetention sets how long the events of removed sessions stay
+// readable. Zero stops keeping them.
+func (m *Manager) SetEventRetention(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.eventRetention = d
+	if d <= 0 {
+		m.retained = make(map[string]retainedEvents)
+	}
+}
+
+// SessionEvents returns the events after cursor of a live session, by ID
+// or label, or of a session removed within the retention window, by ID.
+// Reading events doesn't count as session activity, so polling doesn't
+// hold off the idle cleanup. removed reports a removed session.
+func (m *Manager) SessionEvents(ref string, cursor uint64, limit int) (id string, page EventPage, removed bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if session, ok := m.sessions[strings.ToLower(ref)]; ok {
+		return session.ID, session.Events(cursor, limit), false, nil
+	}
+	m.pruneRetainedLocked()
+	if kept, ok := m.retained[strings.ToLower(ref)]; ok {
+		return strings.ToLower(ref), kept.events.since(cursor, limit), true, nil
+	}
+
+	var matches []*Session
+	for _, session := range m.sessions {
+		if session.Label != "" && session.Label == ref {
+			matches = append(matches, session)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", EventPage{}, false, fmt.Errorf("%w: %s", ErrSessionNotFound, ref)
+	case 1:
+		return matches[0].ID, matches[0].Events(cursor, limit), false, nil
+	default:
+		return "", EventPage{}, false, fmt.Errorf("label %q matches %d sessions; use the session ID", ref, len(matches))
+	}
+}
@@ -0,0 +1,148 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/clock"
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+)
+
+func TestEventRing(t *testing.T) {
+	ring := newEventRing(3, clock.Real)
+	if page := ring.since(0, 0); len(page.Events) != 0 || page.LastSeq != 0 || page.NextSeq != 0 {
+		t.Fatalf("Expected an empty page, got %+v", page)
+	}
+	for _, eventType := range []string{"a", "b", "c", "d", "e"} {
+		ring.add(eventType, nil)
+	}
+
+	types := func(page EventPage) string {
+		var s string
+		for _, e := range page.Events {
+			s += e.Type
+		}
+		return s
+	}
+
+	// The two oldest events were dropped, which a cursor before them sees
+	page := ring.since(0, 0)
+	if got := types(page); got != "cde" || page.Missed != 2 || page.LastSeq != 5 || page.NextSeq != 5 {
+		t.Fatalf("Expected the newest 3 events with 2 missed, got %q %+v", got, page)
+	}
+	for i, e := range page.Events {
+		if e.Seq != uint64(i+3) {
+			t.Errorf("Expected sequence numbers 3-5 in order, got %+v", page.Events)
+		}
+	}
+
+	// A limit cuts the page short and the cursor picks up after it
+	page = ring.since(2, 2)
+	if got := types(page); got != "cd" || !page.HasMore || page.Missed != 0 || page.NextSeq != 4 {
+		t.Fatalf("Expected a page of 2 with more to come, got %q %+v", got, page)
+	}
+	page = ring.since(page.NextSeq, 2)
+	if got := types(page); got != "e" || page.HasMore || page.NextSeq != 5 {
+		t.Fatalf("Expected the last event, got %q %+v", got, page)
+	}
+
+	// Polling at the head returns nothing and keeps the cursor
+	page = ring.since(5, 0)
+	if len(page.Events) != 0 || page.NextSeq != 5 {
+		t.Errorf("Expected nothing new, got %+v", page)
+	}
+	ring.add("f", nil)
+	if got := types(ring.since(5, 0)); got != "f" {
+		t.Errorf("Expected only the new event, got %q", got)
+	}
+
+	// A cursor from somewhere else restarts at the head
+	if page := ring.since(100, 0); len(page.Events) != 0 || page.NextSeq != 6 {
+		t.Errorf("Expected a cursor past the head to move back to it, got %+v", page)
+	}
+}
+
+func TestSessionEvents(t *testing.T) {
+	utils.InitLogger()
+	m := NewManager()
+	defer m.Shutdown()
+
+	s, err := m.CreateSession("sh", []string{"-c", "printf 'x\\007\\033]2;busy\\007'; read line; exit 3"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	// Resizing to the size the session already has is a no-op
+	for i := 0; i < 2; i++ {
+		if err := s.Resize(context.Background(), 100, 30); err != nil {
+			t.Fatalf("Resize failed: %v", err)
+		}
+	}
+
+	waitFor := func(eventType string) EventPage {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			page := s.Events(0, 0)
+			for _, e := range page.Events {
+				if e.Type == eventType {
+					return page
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatalf("Timed out waiting for a %s event, got %+v", eventType, s.Events(0, 0))
+		return EventPage{}
+	}
+
+	waitFor(EventTitle)
+	if _, err := s.SendKeys(context.Background(), "\n"); err != nil {
+		t.Fatalf("SendKeys failed: %v", err)
+	}
+	page := waitFor(EventExited)
+
+	seen := map[string]Event{}
+	resizes := 0
+	for i, e := range page.Events {
+		if e.Type == EventResize {
+			resizes++
+		}
+		if e.Seq != uint64(i+1) {
+			t.Errorf("Expected consecutive sequence numbers, got %+v", page.Events)
+		}
+		seen[e.Type] = e
+	}
+	if page.Events[0].Type != EventCreated {
+		t.Errorf("Expected created first, got %+v", page.Events)
+	}
+	if seen[EventTitle].Data["title"] != "busy" || seen[EventBell].Data == nil {
+		t.Errorf("Expected the bell and the title, got %+v", page.Events)
+	}
+	if seen[EventResize].Data["width"] != 100 || resizes != 1 {
+		t.Errorf("Expected one resize to the new size, got %+v", page.Events)
+	}
+	if seen[EventExited].Data["exit_code"] != 3 {
+		t.Errorf("Expected exit code 3, got %+v", seen[EventExited])
+	}
+	if s.GetDetails().LastEventSeq != page.LastSeq {
+		t.Errorf("Expected the details to carry seq %d, got %d", page.LastSeq, s.GetDetails().LastEventSeq)
+	}
+
+	// The events outlive the session for the retention window
+	if err := m.RemoveSession(s.ID); err != nil {
+		t.Fatalf("RemoveSession failed: %v", err)
+	}
+	id, after, removed, err := m.SessionEvents(s.ID, page.LastSeq, 0)
+	if err != nil || id != s.ID || !removed {
+		t.Fatalf("Expected the removed session's events, got %q %v %v", id, removed, err)
+	}
+	if len(after.Events) != 1 || after.Events[0].Type != EventClosed {
+		t.Errorf("Expected only the close after the cursor, got %+v", after.Events)
+	}
+
+	m.SetEventRetention(0)
+	if _, _, _, err := m.SessionEvents(s.ID, 0, 0); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected the events gone without retention, got %v", err)
+	}
+}
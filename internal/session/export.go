@@ -0,0 +1,295 @@
+package session
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Size limits for a session bundle, counting the files' contents before
+// compression
+const (
+	DefaultBundleBytes = 16 << 20
+	MaxBundleBytes     = 256 << 20
+)
+
+// minRedactBytes is the shortest environment value scrubbed from a bundle;
+// shorter ones would match too much unrelated text
+const minRedactBytes = 4
+
+// Files in a session bundle
+const (
+	bundleMetadata    = "session.json"     // Session record, options and launch configuration
+	bundleDiagnostics = "diagnostics.json" // Parser diagnostics
+	bundleScreen      = "screen.txt"       // Final screen, plain
+	bundleScreenRaw   = "screen.ansi"      // Final screen with colors and attributes
+	bundleInputs      = "inputs.json"      // Input history, secrets redacted
+	bundleLogs        = "logs.json"        // Recent log records about the session
+	bundleResources   = "resources.json"   // Resource samples; only once sampling was turned on
+	bundleScrollback  = "scrollback.txt"   // Scrollback and screen, plain
+	bundleStderr      = "stderr.txt"       // Separated stderr, as much as is kept; only with separate_stderr
+	bundleOutput      = "output.raw"       // Raw output as the process wrote it, as much as is kept
+	bundleManifest    = "manifest.json"    // What the bundle holds; always written
+)
+
+// BundleFile is one file of a session bundle
+type BundleFile struct {
+	Name      string `json:"name"`
+	Bytes     int    `json:"bytes"`
+	Truncated bool   `json:"truncated,omitempty"` // Cut to fit the size limit, keeping the end
+	data      []byte
+}
+
+// BundleManifest describes a session bundle
+type BundleManifest struct {
+	SessionID  string       `json:"session_id"`
+	ExportedAt time.Time    `json:"exported_at"`
+	MaxBytes   int          `json:"max_bytes"`
+	Files      []BundleFile `json:"files"`
+	Omitted    []string     `json:"omitted"`  // Files left out because they didn't fit
+	Redacted   []string     `json:"redacted"` // Environment variables whose values were scrubbed
+}
+
+// bundleSource is the content of one bundle file before redaction
+type bundleSource struct {
+	name string
+	data []byte
+	cut  bool // May be cut to fit; otherwise left out
+}
+
+// Bundle is everything needed to reproduce what a session showed, ready to
+// be written out
+type Bundle struct {
+	Manifest BundleManifest
+}
+
+// Bundle gathers the session's record, screens, output, input history,
+// diagnostics, logs and resource samples for a bug report. Launch environment values never
+// appear: they are scrubbed from every file, and secrets are kept only by
+// length. Files are added in the order of the bundle* constants until
+// maxBytes is reached; the output and scrollback are then cut to their
+// newest part, and a JSON file that doesn't fit is left out.
+func (s *Session) Bundle(maxBytes int) (*Bundle, error) {
+	now := s.clock.Now()
+	details := s.GetDetails()
+	config := s.Config()
+	config.Env = nil // Only the names, which details has
+
+	metadata, err := json.MarshalIndent(map[string]interface{}{
+		"session":     details,
+		"options":     s.Options(),
+		"config":      config,
+		"exported_at": now,
+	}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode session metadata: %w", err)
+	}
+	diagnostics, err := json.MarshalIndent(s.Buffer.ParserDiagnostics(), "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode parser diagnostics: %w", err)
+	}
+	history := s.Inputs()
+	if history == nil {
+		history = []InputEntry{}
+	}
+	inputs, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode input history: %w", err)
+	}
+	records := s.Logs(slog.LevelDebug, 0)
+	if records == nil {
+		records = []LogEntry{}
+	}
+	logs, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode logs: %w", err)
+	}
+	screen, err := s.Buffer.Render("plain")
+	if err != nil {
+		return nil, err
+	}
+	screenRaw, err := s.Buffer.Render("raw")
+	if err != nil {
+		return nil, err
+	}
+	scrollback, err := s.Buffer.Render("scrollback")
+	if err != nil {
+		return nil, err
+	}
+
+	files := []bundleSource{
+		{bundleMetadata, metadata, false},
+		{bundleDiagnostics, diagnostics, false},
+		{bundleScreen, []byte(screen), true},
+		{bundleScreenRaw, []byte(screenRaw), true},
+		{bundleInputs, inputs, false},
+		{bundleLogs, logs, false},
+	}
+	if s.sampledResources() {
+		resources, err := json.MarshalIndent(s.ResourceHistory(), "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode resource samples: %w", err)
+		}
+		files = append(files, bundleSource{bundleResources, resources, false})
+	}
+	files = append(files, bundleSource{bundleScrollback, []byte(scrollback), true})
+	if s.stderr != nil {
+		files = append(files, bundleSource{bundleStderr, s.stderr.all(), true})
+	}
+	files = append(files, bundleSource{bundleOutput, s.Buffer.GetRawData(), true})
+
+	scrub, redacted := s.redactor()
+	b := &Bundle{Manifest: BundleManifest{
+		SessionID:  s.ID,
+		ExportedAt: now,
+		MaxBytes:   maxBytes,
+		Files:      []BundleFile{},
+		Omitted:    []string{},
+		Redacted:   redacted,
+	}}
+	left := maxBytes
+	for _, f := range files {
+		data := scrub(f.data)
+		file := BundleFile{Name: f.name}
+		if len(data) > left {
+			if !f.cut || left == 0 {
+				b.Manifest.Omitted = append(b.Manifest.Omitted, f.name)
+				continue
+			}
+			data, file.Truncated = data[len(data)-left:], true
+		}
+		file.data, file.Bytes = data, len(data)
+		left -= len(data)
+		b.Manifest.Files = append(b.Manifest.Files, file)
+	}
+	return b, nil
+}
+
+// redactor returns a function that replaces the session's launch
+// environment values, as written and as JSON escapes them, with the
+// variable's name, and the names it redacts
+func (s *Session) redactor() (func([]byte) []byte, []string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var names []string
+	var old, repl [][]byte
+	for name, value := range s.Env {
+		if len(value) < minRedactBytes {
+			continue
+		}
+		// A value made of placeholders is the bridge's own, not a secret
+		if tmpl, ok := s.expansion.template(name); ok && len(placeholderPattern.ReplaceAllString(tmpl, "")) < minRedactBytes {
+			continue
+		}
+		names = append(names, name)
+		marker := []byte("[REDACTED:" + name + "]")
+		old, repl = append(old, []byte(value)), append(repl, marker)
+		if quoted, err := json.Marshal(value); err == nil {
+			if escaped := quoted[1 : len(quoted)-1]; !bytes.Equal(escaped, []byte(value)) {
+				old, repl = append(old, escaped), append(repl, marker)
+			}
+		}
+	}
+	sort.Strings(names)
+	if names == nil {
+		names = []string{}
+	}
+	return func(data []byte) []byte {
+		for i := range old {
+			data = bytes.ReplaceAll(data, old[i], repl[i])
+		}
+		return data
+	}, names
+}
+
+// WriteDir writes the bundle into a new directory under parent and returns
+// its path
+func (b *Bundle) WriteDir(parent string) (string, error) {
+	dir, err := os.MkdirTemp(parent, b.prefix()+"-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create bundle directory: %w", err)
+	}
+	files, err := b.contents()
+	if err != nil {
+		return "", err
+	}
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(dir, f.Name), f.data, 0o644); err != nil {
+			return "", fmt.Errorf("failed to write %s: %w", f.Name, err)
+		}
+	}
+	return dir, nil
+}
+
+// WriteArchive writes the bundle as a new .tar.gz file under parent, its
+// files in a directory named like the archive, and returns its path
+func (b *Bundle) WriteArchive(parent string) (path string, err error) {
+	out, err := os.CreateTemp(parent, b.prefix()+"-*.tar.gz")
+	if err != nil {
+		return "", fmt.Errorf("failed to create bundle archive: %w", err)
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("failed to write bundle archive: %w", cerr)
+		}
+		if err != nil {
+			os.Remove(out.Name())
+		}
+	}()
+
+	files, err := b.contents()
+	if err != nil {
+		return "", err
+	}
+	root := strings.TrimSuffix(filepath.Base(out.Name()), ".tar.gz")
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		hdr := &tar.Header{
+			Name:    root + "/" + f.Name,
+			Mode:    0o644,
+			Size:    int64(len(f.data)),
+			ModTime: b.Manifest.ExportedAt,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return "", fmt.Errorf("failed to write bundle archive: %w", err)
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return "", fmt.Errorf("failed to write bundle archive: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return "", fmt.Errorf("failed to write bundle archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return "", fmt.Errorf("failed to write bundle archive: %w", err)
+	}
+	return out.Name(), nil
+}
+
+func (b *Bundle) prefix() string {
+	id := b.Manifest.SessionID
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	return "session-" + id + "-" + b.Manifest.ExportedAt.Format("20060102-150405")
+}
+
+// contents returns the files to write, the manifest last
+func (b *Bundle) contents() ([]BundleFile, error) {
+	manifest, err := json.MarshalIndent(b.Manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode bundle manifest: %w", err)
+	}
+	files := append([]BundleFile(nil), b.Manifest.Files...)
+	return append(files, BundleFile{Name: bundleManifest, Bytes: len(manifest), data: manifest}), nil
+}
@@ -0,0 +1,199 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// OpKind is how a tool operation shares a session with others running at
+// the same time
+type OpKind int
+
+const (
+	// OpShared operations (send_keys, view_screen) run alongside each other
+	OpShared OpKind = iota
+	// OpExclusive operations (resize) run alone
+	OpExclusive
+	// OpLifecycle operations (restart, stop) run alone and interrupt shared
+	// operations that are holding them up, such as a blocked send
+	OpLifecycle
+)
+
+// DefaultGateWait bounds how long an operation queues for a busy session
+const DefaultGateWait = 10 * time.Second
+
+// ErrSessionBusy reports that conflicting operations kept a session busy.
+// Use errors.Is to test a *BusyError against it.
+var ErrSessionBusy = errors.New("session busy")
+
+// errInterrupted is the cause given to shared operations cut short by a
+// lifecycle operation
+var errInterrupted = errors.New("interrupted by a restart or stop of the session")
+
+// BusyError reports what a session was busy with
+type BusyError struct {
+	Operation string // Operation that could not start
+	Holder    string // Exclusive operation in progress, if any
+	Shared    int    // Shared operations in progress
+}
+
+func (e *BusyError) Error() string {
+	if e.Holder != "" {
+		return fmt.Sprintf("%s: %s in progress", ErrSessionBusy, e.Holder)
+	}
+	return fmt.Sprintf("%s: %d operations in progress", ErrSessionBusy, e.Shared)
+}
+
+func (e *BusyError) Unwrap() error {
+	return ErrSessionBusy
+}
+
+// opGate is a reader/writer gate for tool operations on a session. Waiting
+// exclusive operations take priority, so a stream of sends can't starve a
+// restart.
+type opGate struct {
+	mu       sync.Mutex
+	shared   int           // Shared operations running
+	holder   string        // Exclusive operation running, empty if none
+	waiting  int           // Exclusive operations queued
+	released chan struct{} // Closed and replaced whenever an operation ends
+
+	// Shared operations run under sharedCtx, which lifecycle operations
+	// cancel to interrupt them
+	sharedCtx    context.Context
+	cancelShared context.CancelCauseFunc
+	maxWait      time.Duration
+}
+
+func newOpGate() *opGate {
+	g := &opGate{
+		released: make(chan struct{}),
+		maxWait:  DefaultGateWait,
+	}
+	g.sharedCtx, g.cancelShared = context.WithCancelCause(context.Background())
+	return g
+}
+
+// begin starts an operation, queueing for up to maxWait when wait is set and
+// failing at once with a *BusyError otherwise. It returns the context the
+// operation should run under and a function that ends it.
+func (g *opGate) begin(ctx context.Context, op string, kind OpKind, wait bool) (context.Context, func(), error) {
+	g.mu.Lock()
+	queued := false
+	var timer *time.Timer
+	for {
+		if g.free(kind, queued) {
+			if queued {
+				g.waiting--
+			}
+			return g.enter(ctx, op, kind)
+		}
+		if !wait {
+			err := g.busy(op)
+			g.mu.Unlock()
+			return nil, nil, err
+		}
+
+		if timer == nil {
+			timer = time.NewTimer(g.maxWait)
+			defer timer.Stop()
+		}
+		if !queued && kind != OpShared {
+			queued = true
+			g.waiting++
+			if kind == OpLifecycle {
+				g.cancelShared(errInterrupted)
+			}
+		}
+		released := g.released
+		g.mu.Unlock()
+
+		select {
+		case <-released:
+			g.mu.Lock()
+		case <-timer.C:
+			g.mu.Lock()
+			return nil, nil, g.giveUp(op, queued)
+		case <-ctx.Done():
+			g.mu.Lock()
+			g.giveUp(op, queued)
+			return nil, nil, context.Cause(ctx)
+		}
+	}
+}
+
+// free reports whether an operation of the given kind can start. Callers
+// hold g.mu.
+func (g *opGate) free(kind OpKind, queued bool) bool {
+	if kind == OpShared {
+		return g.holder == "" && g.waiting == 0
+	}
+	// A queued exclusive operation counts itself in waiting
+	return g.holder == "" && g.shared == 0 && (queued || g.waiting == 0)
+}
+
+// enter records a starting operation and unlocks g.mu
+func (g *opGate) enter(ctx context.Context, op string, kind OpKind) (context.Context, func(), error) {
+	if kind == OpShared {
+		g.shared++
+		opCtx, cancel := bindContext(ctx, g.sharedCtx)
+		g.mu.Unlock()
+		return opCtx, func() {
+			cancel()
+			g.release(func() { g.shared-- })
+		}, nil
+	}
+
+	g.holder = op
+	if g.sharedCtx.Err() != nil {
+		// Shared operations that start after this one get a fresh context
+		g.sharedCtx, g.cancelShared = context.WithCancelCause(context.Background())
+	}
+	g.mu.Unlock()
+	return ctx, func() {
+		g.release(func() { g.holder = "" })
+	}, nil
+}
+
+// release applies update and wakes queued operations
+func (g *opGate) release(update func()) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	update()
+	close(g.released)
+	g.released = make(chan struct{})
+}
+
+// giveUp abandons a queued operation and unlocks g.mu, returning the busy
+// error to report
+func (g *opGate) giveUp(op string, queued bool) error {
+	err := g.busy(op)
+	if queued {
+		g.waiting--
+		if g.waiting == 0 && g.sharedCtx.Err() != nil {
+			// Nothing is going to run exclusively after all
+			g.sharedCtx, g.cancelShared = context.WithCancelCause(context.Background())
+		}
+		// Shared operations may have been queued behind this one
+		close(g.released)
+		g.released = make(chan struct{})
+	}
+	g.mu.Unlock()
+	return err
+}
+
+// busy describes the conflict; callers hold g.mu
+func (g *opGate) busy(op string) error {
+	return &BusyError{Operation: op, Holder: g.holder, Shared: g.shared}
+}
+
+// Begin starts a tool operation on the session. Conflicting operations
+// queue for up to DefaultGateWait when wait is set, and fail at once with a
+// *BusyError otherwise. The operation runs under the returned context and
+// must call the returned function when done.
+func (s *Session) Begin(ctx context.Context, op string, kind OpKind, wait bool) (context.Context, func(), error) {
+	return s.gate.begin(ctx, op, kind, wait)
+}
@@ -0,0 +1,147 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOpGate_SerializesConflictingOps(t *testing.T) {
+	g := newOpGate()
+	kinds := []OpKind{OpShared, OpShared, OpShared, OpExclusive, OpLifecycle}
+
+	var shared, exclusive, violations int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				kind := kinds[(i+j)%len(kinds)]
+				_, done, err := g.begin(context.Background(), "op", kind, true)
+				if err != nil {
+					t.Errorf("begin failed: %v", err)
+					return
+				}
+				if kind == OpShared {
+					atomic.AddInt32(&shared, 1)
+					if atomic.LoadInt32(&exclusive) != 0 {
+						atomic.AddInt32(&violations, 1)
+					}
+					time.Sleep(100 * time.Microsecond)
+					atomic.AddInt32(&shared, -1)
+				} else {
+					if atomic.AddInt32(&exclusive, 1) != 1 || atomic.LoadInt32(&shared) != 0 {
+						atomic.AddInt32(&violations, 1)
+					}
+					time.Sleep(100 * time.Microsecond)
+					atomic.AddInt32(&exclusive, -1)
+				}
+				done()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if violations != 0 {
+		t.Errorf("Exclusive operations overlapped others %d times", violations)
+	}
+}
+
+func TestOpGate_NoWaitReportsBusy(t *testing.T) {
+	g := newOpGate()
+	_, done, err := g.begin(context.Background(), "resize_terminal", OpExclusive, true)
+	if err != nil {
+		t.Fatalf("begin failed: %v", err)
+	}
+
+	_, _, err = g.begin(context.Background(), "send_keys", OpShared, false)
+	var busy *BusyError
+	if !errors.As(err, &busy) || !errors.Is(err, ErrSessionBusy) {
+		t.Fatalf("Expected a busy error, got %v", err)
+	}
+	if busy.Operation != "send_keys" || busy.Holder != "resize_terminal" {
+		t.Errorf("Unexpected busy details: %+v", busy)
+	}
+
+	done()
+	_, done, err = g.begin(context.Background(), "send_keys", OpShared, false)
+	if err != nil {
+		t.Fatalf("Expected the gate to be free after release, got %v", err)
+	}
+	done()
+}
+
+func TestOpGate_WaitTimesOut(t *testing.T) {
+	g := newOpGate()
+	g.maxWait = 50 * time.Millisecond
+	_, done, err := g.begin(context.Background(), "send_keys", OpShared, true)
+	if err != nil {
+		t.Fatalf("begin failed: %v", err)
+	}
+	defer done()
+
+	start := time.Now()
+	_, _, err = g.begin(context.Background(), "resize_terminal", OpExclusive, true)
+	if !errors.Is(err, ErrSessionBusy) {
+		t.Fatalf("Expected a busy error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < g.maxWait {
+		t.Errorf("Gave up after %v, before the %v wait", elapsed, g.maxWait)
+	}
+
+	// The abandoned exclusive operation no longer holds up shared ones
+	_, sharedDone, err := g.begin(context.Background(), "view_screen", OpShared, false)
+	if err != nil {
+		t.Fatalf("Expected shared operations to run again, got %v", err)
+	}
+	sharedDone()
+}
+
+func TestOpGate_LifecycleInterruptsShared(t *testing.T) {
+	g := newOpGate()
+	opCtx, done, err := g.begin(context.Background(), "send_keys", OpShared, true)
+	if err != nil {
+		t.Fatalf("begin failed: %v", err)
+	}
+
+	entered := make(chan func(), 1)
+	go func() {
+		_, stopDone, err := g.begin(context.Background(), "stop_app", OpLifecycle, true)
+		if err != nil {
+			t.Errorf("stop begin failed: %v", err)
+			close(entered)
+			return
+		}
+		entered <- stopDone
+	}()
+
+	select {
+	case <-opCtx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shared operation was not interrupted")
+	}
+	if !errors.Is(context.Cause(opCtx), errInterrupted) {
+		t.Errorf("Expected errInterrupted, got %v", context.Cause(opCtx))
+	}
+
+	done()
+	stopDone := <-entered
+	if stopDone == nil {
+		return
+	}
+	stopDone()
+
+	// Operations after the stop run under a live context
+	opCtx, done, err = g.begin(context.Background(), "view_screen", OpShared, false)
+	if err != nil {
+		t.Fatalf("begin failed: %v", err)
+	}
+	defer done()
+	if opCtx.Err() != nil {
+		t.Errorf("Expected a live context, got %v", context.Cause(opCtx))
+	}
+}
@@ -0,0 +1,121 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/clock"
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+	"github.com/google/uuid"
+)
+
+// MaxImportBytes caps the size of a capture file that can be imported
+const MaxImportBytes = 64 << 20
+
+// ImportCommand is the command an imported session reports
+const ImportCommand = "import_capture"
+
+// ErrImported is returned by operations that need a process when the
+// session was imported from a capture
+var ErrImported = errors.New("session was imported from a capture and has no process")
+
+// NewImportedSession creates a frozen session with no process: its screen
+// and scrollback are data, a capture of terminal output read from path,
+// parsed at the configured size with the configured options. Like a session
+// whose process has exited it stays active, so it can be read until it is
+// stopped. Only cfg's label, options and size are used.
+func NewImportedSession(path string, data []byte, cfg SessionConfig) (*Session, error) {
+	width, height := cfg.Width, cfg.Height
+	if width <= 0 {
+		width = 80
+	}
+	if height <= 0 {
+		height = 24
+	}
+	if err := ValidateOptions(cfg.Options); err != nil {
+		return nil, err
+	}
+
+	id := uuid.New().String()
+	buffer := terminal.NewScreenBuffer(width, height)
+	buffer.SetSessionID(id)
+
+	clk := clock.Or(cfg.Clock)
+	now := clk.Now()
+	session := &Session{
+		ID:         id,
+		Command:    ImportCommand,
+		Args:       []string{path},
+		Env:        map[string]string{},
+		Label:      cfg.Label,
+		Imported:   path,
+		Buffer:     buffer,
+		Created:    now,
+		LastActive: now,
+		State:      StateActive,
+		logs:       newLogRing(defaultLogRecords),
+		events:     newEventRing(defaultEventRecords, clk),
+		inputs:     newInputRing(defaultInputRecords, clk),
+		gate:       newOpGate(),
+		clock:      clk,
+	}
+	session.ctx, session.cancel = context.WithCancelCause(context.Background())
+	// Options such as scrollback_lines and line_feed must be in place
+	// before the capture is parsed
+	if err := session.SetOptions(cfg.Options, SourceLaunch); err != nil {
+		return nil, err
+	}
+	registerLogTarget(session)
+	session.startOutputStats()
+
+	buffer.Write(data)
+	session.observers.publish(OutputEvent{Time: now, Data: data, Generation: buffer.Generation()})
+	session.lastOutput.Store(now.UnixNano())
+	// Queries in the capture have no one to answer
+	buffer.TakeReplies()
+
+	session.recordEvent(EventCreated, map[string]interface{}{
+		"command": ImportCommand,
+		"path":    path,
+		"bytes":   len(data),
+		"width":   width,
+		"height":  height,
+	})
+	slog.Info("Session imported from capture",
+		slog.String("session_id", id),
+		slog.String("path", path),
+		slog.Int("bytes", len(data)),
+	)
+	return session, nil
+}
+
+// ImportSession creates a session from a capture with NewImportedSession and
+// adds it to the manager
+func (m *Manager) ImportSession(path string, data []byte, cfg SessionConfig) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.sessions) >= m.maxSessions {
+		return nil, fmt.Errorf("maximum number of sessions (%d) reached", m.maxSessions)
+	}
+
+	if cfg.Clock == nil {
+		cfg.Clock = m.clock
+	}
+	session, err := NewImportedSession(path, data, cfg)
+	if err != nil {
+		utils.LogError(err, "Failed to import capture", slog.String("path", path))
+		return nil, err
+	}
+
+	m.sessions[session.ID] = session
+	m.trackActivity(session)
+	utils.LogSessionEvent(session.ID, "imported",
+		slog.String("path", path),
+		slog.Int("total_sessions", len(m.sessions)),
+	)
+	return session, nil
+}
@@ -0,0 +1,65 @@
+package session
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/clock"
+)
+
+// defaultInputRecords is how many writes to the process a session keeps
+const defaultInputRecords = 200
+
+// maxInputRecordBytes truncates the data kept for one large write
+const maxInputRecordBytes = 4096
+
+// InputEntry is one write of input to the session's process
+type InputEntry struct {
+	Time      time.Time `json:"time"`
+	Data      string    `json:"data"`                // Bytes as written, empty when redacted
+	Bytes     int       `json:"bytes"`               // Bytes delivered
+	Truncated bool      `json:"truncated,omitempty"` // Data holds only the first maxInputRecordBytes
+	Redacted  bool      `json:"redacted,omitempty"`  // Sent with send_secret, so Data is left out
+}
+
+// inputRing keeps a session's most recent writes to its process
+type inputRing struct {
+	mu      sync.Mutex
+	entries []InputEntry
+	max     int
+	clock   clock.Clock
+}
+
+func newInputRing(max int, clk clock.Clock) *inputRing {
+	return &inputRing{max: max, clock: clk}
+}
+
+// add records data as delivered. A secret keeps only its length.
+func (r *inputRing) add(data string, secret bool) {
+	entry := InputEntry{Time: r.clock.Now(), Bytes: len(data), Redacted: secret}
+	if !secret {
+		if len(data) > maxInputRecordBytes {
+			data, entry.Truncated = data[:maxInputRecordBytes], true
+		}
+		entry.Data = data
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, entry)
+	if len(r.entries) > r.max {
+		r.entries = append([]InputEntry(nil), r.entries[len(r.entries)-r.max:]...)
+	}
+}
+
+func (r *inputRing) all() []InputEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]InputEntry(nil), r.entries...)
+}
+
+// Inputs returns the session's most recent writes to its process, oldest
+// first. Secrets are recorded by length only.
+func (s *Session) Inputs() []InputEntry {
+	return s.inputs.all()
+}
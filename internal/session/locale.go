@@ -0,0 +1,19 @@
+package session
+
+// LocaleVars are the environment variables a launch locale sets. LC_ALL
+// alone would do for programs that follow POSIX; LANG and LC_CTYPE are set
+// too for the ones that only look at those.
+var LocaleVars = []string{"LANG", "LC_ALL", "LC_CTYPE"}
+
+// LocaleEnv returns the environment entries that launch a program under
+// locale, or nil for an empty locale
+func LocaleEnv(locale string) map[string]string {
+	if locale == "" {
+		return nil
+	}
+	env := make(map[string]string, len(LocaleVars))
+	for _, name := range LocaleVars {
+		env[name] = locale
+	}
+	return env
+}
@@ -0,0 +1,153 @@
+package session
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+)
+
+// defaultLogRecords is how many log records a session keeps unless the
+// log_records option says otherwise
+const defaultLogRecords = 200
+
+// LogEntry is a server log record about one session
+type LogEntry struct {
+	Time    time.Time              `json:"time"`
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+	Attrs   map[string]interface{} `json:"attrs,omitempty"`
+	level   slog.Level
+}
+
+// logRing keeps a session's most recent log records
+type logRing struct {
+	mu      sync.Mutex
+	entries []LogEntry
+	max     int
+}
+
+func newLogRing(max int) *logRing {
+	return &logRing{max: max}
+}
+
+func (r *logRing) add(entry LogEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, entry)
+	if len(r.entries) > r.max {
+		r.entries = r.entries[len(r.entries)-r.max:]
+	}
+}
+
+func (r *logRing) setMax(max int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.max = max
+	if len(r.entries) > max {
+		r.entries = append([]LogEntry(nil), r.entries[len(r.entries)-max:]...)
+	}
+}
+
+// since returns up to limit of the newest entries at or above level, oldest
+// first. A limit of 0 or less returns all of them.
+func (r *logRing) since(level slog.Level, limit int) []LogEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var matched []LogEntry
+	for i := len(r.entries) - 1; i >= 0; i-- {
+		if limit > 0 && len(matched) == limit {
+			break
+		}
+		if r.entries[i].level >= level {
+			matched = append(matched, r.entries[i])
+		}
+	}
+	for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+		matched[i], matched[j] = matched[j], matched[i]
+	}
+	return matched
+}
+
+// Logs returns up to limit of the session's most recent log records at or
+// above level, oldest first
+func (s *Session) Logs(level slog.Level, limit int) []LogEntry {
+	return s.logs.since(level, limit)
+}
+
+// logTargets maps session IDs to the sessions whose log rings receive their
+// records. Sessions are registered on creation and removed once closed.
+var logTargets = struct {
+	sync.RWMutex
+	sessions map[string]*Session
+}{sessions: make(map[string]*Session)}
+
+func init() {
+	utils.SetSessionLogSink(captureLog)
+}
+
+func registerLogTarget(s *Session) {
+	logTargets.Lock()
+	logTargets.sessions[s.ID] = s
+	logTargets.Unlock()
+}
+
+func unregisterLogTarget(s *Session) {
+	logTargets.Lock()
+	if logTargets.sessions[s.ID] == s {
+		delete(logTargets.sessions, s.ID)
+	}
+	logTargets.Unlock()
+}
+
+// captureLog stores a log record with the session it names
+func captureLog(sessionID string, record slog.Record) {
+	logTargets.RLock()
+	s := logTargets.sessions[sessionID]
+	logTargets.RUnlock()
+	if s == nil {
+		return
+	}
+
+	entry := LogEntry{
+		Time:    record.Time,
+		Level:   strings.ToLower(record.Level.String()),
+		Message: record.Message,
+		level:   record.Level,
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		if attr.Key == "session_id" {
+			return true
+		}
+		if entry.Attrs == nil {
+			entry.Attrs = make(map[string]interface{})
+		}
+		entry.Attrs[attr.Key] = attrValue(attr.Value)
+		return true
+	})
+	s.logs.add(entry)
+}
+
+// attrValue converts an attribute value to something encoding/json renders
+// the way the server log does
+func attrValue(value slog.Value) interface{} {
+	value = value.Resolve()
+	switch value.Kind() {
+	case slog.KindGroup:
+		group := make(map[string]interface{})
+		for _, attr := range value.Group() {
+			group[attr.Key] = attrValue(attr.Value)
+		}
+		return group
+	case slog.KindDuration:
+		return value.Duration().String()
+	case slog.KindAny:
+		if err, ok := value.Any().(error); ok {
+			return err.Error()
+		}
+	}
+	return value.Any()
+}
@@ -0,0 +1,99 @@
+package session
+
+import (
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+)
+
+func TestLogRing(t *testing.T) {
+	ring := newLogRing(3)
+	for i, level := range []slog.Level{slog.LevelInfo, slog.LevelError, slog.LevelInfo, slog.LevelWarn, slog.LevelError} {
+		ring.add(LogEntry{Message: string(rune('a' + i)), level: level})
+	}
+
+	messages := func(entries []LogEntry) string {
+		var s string
+		for _, e := range entries {
+			s += e.Message
+		}
+		return s
+	}
+	if got := messages(ring.since(slog.LevelDebug, 0)); got != "cde" {
+		t.Errorf("Expected the newest 3 records, got %q", got)
+	}
+	if got := messages(ring.since(slog.LevelWarn, 0)); got != "de" {
+		t.Errorf("Expected warn and above, got %q", got)
+	}
+	if got := messages(ring.since(slog.LevelDebug, 1)); got != "e" {
+		t.Errorf("Expected the newest record, got %q", got)
+	}
+
+	ring.setMax(1)
+	if got := messages(ring.since(slog.LevelDebug, 0)); got != "e" {
+		t.Errorf("Expected shrinking to keep the newest record, got %q", got)
+	}
+}
+
+func TestSessionLogsAreIsolated(t *testing.T) {
+	utils.InitLogger()
+
+	a, err := NewSession("cat", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer a.Close()
+	b, err := NewSession("cat", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer b.Close()
+
+	utils.LogError(errors.New("disk on fire"), "Failed to do the thing", slog.String("session_id", a.ID))
+
+	errs := a.Logs(slog.LevelError, 0)
+	if len(errs) != 1 || errs[0].Message != "Failed to do the thing" || errs[0].Attrs["error"] != "disk on fire" {
+		t.Fatalf("Expected the error in session a's log, got %+v", errs)
+	}
+	if _, ok := errs[0].Attrs["session_id"]; ok {
+		t.Error("session_id should not be repeated in the attributes")
+	}
+	if errs := b.Logs(slog.LevelError, 0); len(errs) != 0 {
+		t.Errorf("Session b should not see session a's error, got %+v", errs)
+	}
+
+	// Lifecycle records land in the session's own log
+	if len(b.Logs(slog.LevelInfo, 0)) == 0 {
+		t.Error("Expected session b to have its creation record")
+	}
+}
+
+func TestLogRecordsOption(t *testing.T) {
+	utils.InitLogger()
+
+	s, err := NewSessionWithConfig(SessionConfig{
+		Command: "cat",
+		Options: map[string]interface{}{OptionLogRecords: 2},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer s.Close()
+
+	for i := 0; i < 5; i++ {
+		slog.Warn("noise", slog.String("session_id", s.ID))
+	}
+	if n := len(s.Logs(slog.LevelDebug, 0)); n != 2 {
+		t.Errorf("Expected 2 records kept, got %d", n)
+	}
+
+	if err := s.SetOption(OptionLogRecords, 0, SourceRuntime); err != nil {
+		t.Fatalf("Failed to set option: %v", err)
+	}
+	slog.Warn("dropped", slog.String("session_id", s.ID))
+	if n := len(s.Logs(slog.LevelDebug, 0)); n != 0 {
+		t.Errorf("Expected no records with log_records=0, got %d", n)
+	}
+}
@@ -1,26 +1,62 @@
 package session
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/bioharz/mcp-terminal-tester/internal/clock"
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
 	"github.com/bioharz/mcp-terminal-tester/internal/utils"
 )
 
 type Manager struct {
 	sessions map[string]*Session
+	groups   map[string]map[string]struct{} // group name -> session IDs
 	mu       sync.RWMutex
 	maxSessions int
 	sessionTimeout time.Duration
+	stopGracePeriod time.Duration // How long StopAllSessions waits after SIGTERM
+	state    *stateStore     // nil when persistence is disabled
+	orphans  []SessionRecord // Live processes left behind by a previous server
+
+	// Events of removed sessions, by ID, guarded by mu; see events.go
+	retained       map[string]retainedEvents
+	eventRetention time.Duration
+
+	activity *activityLog // Lifecycle across all sessions; see activity.go
+	clock    clock.Clock  // Idle times, retention and the cleanup ticker
+
+	// Warm pool of idle sessions, guarded by mu
+	pool        []*Session
+	poolConfig  poolConfig
+	poolFilling bool
+	poolWG      sync.WaitGroup
+
+	// Cleanup routine lifecycle, guarded by cleanupMu
+	cleanupMu       sync.Mutex
+	cleanupInterval time.Duration
+	cleanupDone     chan struct{}
+	cleanupWG       sync.WaitGroup
+	cleanupPaused   bool
 }
 
 func NewManager() *Manager {
 	m := &Manager{
 		sessions: make(map[string]*Session),
+		groups:   make(map[string]map[string]struct{}),
+		retained: make(map[string]retainedEvents),
+		activity: newActivityLog(defaultActivityRecords),
 		maxSessions: 100,
 		sessionTimeout: 30 * time.Minute,
+		stopGracePeriod: 2 * time.Second,
+		cleanupInterval: 5 * time.Minute,
+		eventRetention: DefaultEventRetention,
+		clock:    clock.Real,
 	}
 	slog.Info("Session manager created",
 		slog.Int("max_sessions", m.maxSessions),
@@ -29,7 +65,56 @@ func NewManager() *Manager {
 	return m
 }
 
+// MaxSessions returns the maximum number of concurrent sessions
+func (m *Manager) MaxSessions() int {
+	return m.maxSessions
+}
+
+// SetClock replaces the clock the manager and the sessions it creates from
+// now on use, such as with a fake one in tests. Call it before creating
+// sessions or starting the cleanup routine.
+func (m *Manager) SetClock(c clock.Clock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clock = clock.Or(c)
+}
+
+// Clock returns the manager's clock
+func (m *Manager) Clock() clock.Clock {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.clock
+}
+
+// StopResult describes the outcome of stopping a single session
+type StopResult struct {
+	ID     string `json:"id"`
+	Result string `json:"result"` // "stopped", "killed", "already_removed" or "error"
+	Error  string `json:"error,omitempty"`
+}
+
+// ErrSessionNotFound is returned when no session matches an ID or label
+var ErrSessionNotFound = errors.New("session not found")
+
+// GroupInfo describes a named group of sessions
+type GroupInfo struct {
+	Name       string   `json:"name"`
+	SessionIDs []string `json:"session_ids"`
+}
+
 func (m *Manager) CreateSession(command string, args []string, env map[string]string) (*Session, error) {
+	return m.CreateSessionWithConfig(SessionConfig{
+		Command: command,
+		Args:    args,
+		Env:     env,
+	})
+}
+
+// CreateSessionWithConfig creates a session from a launch configuration,
+// registering it with its group if one is set
+func (m *Manager) CreateSessionWithConfig(cfg SessionConfig) (*Session, error) {
+	command, args := cfg.Command, cfg.Args
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -42,31 +127,59 @@ func (m *Manager) CreateSession(command string, args []string, env map[string]st
 		return nil, err
 	}
 
-	session, err := NewSession(command, args, env)
+	m.warnNearFDLimit()
+
+	if cfg.Clock == nil {
+		cfg.Clock = m.clock
+	}
+	session, err := NewSessionWithConfig(cfg)
 	if err != nil {
 		utils.LogError(err, "Failed to create session",
 			slog.String("command", command),
 			slog.Any("args", args),
+			slog.Int("active_sessions", len(m.sessions)),
 		)
-		return nil, fmt.Errorf("failed to create session: %w", err)
+		return nil, fmt.Errorf("failed to create session (%d active sessions): %w", len(m.sessions), err)
 	}
 
 	m.sessions[session.ID] = session
+	m.trackActivity(session)
+	if cfg.Group != "" {
+		if m.groups[cfg.Group] == nil {
+			m.groups[cfg.Group] = make(map[string]struct{})
+		}
+		m.groups[cfg.Group][session.ID] = struct{}{}
+	}
+	m.persistLocked()
 	utils.LogSessionEvent(session.ID, "created",
 		slog.String("command", command),
 		slog.Any("args", args),
+		slog.String("group", cfg.Group),
 		slog.Int("total_sessions", len(m.sessions)),
 	)
 	return session, nil
 }
 
+// warnNearFDLimit logs a warning when the server is close to running out of
+// file descriptors. Each session holds several, so this comes before opening
+// a PTY starts failing with EMFILE. Callers hold m.mu.
+func (m *Manager) warnNearFDLimit() {
+	if near, open, limit := terminal.NearFDLimit(); near {
+		slog.Warn("Near file descriptor limit",
+			slog.Int("open_fds", open),
+			slog.Int("fd_limit", limit),
+			slog.Int("active_sessions", len(m.sessions)),
+		)
+	}
+}
+
 func (m *Manager) GetSession(id string) (*Session, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	session, exists := m.sessions[id]
 	if !exists {
-		err := fmt.Errorf("session not found: %s", id)
+		err := fmt.Errorf("%w: %s", ErrSessionNotFound, id)
 		slog.Debug("Session lookup failed",
 			slog.String("session_id", id),
 			slog.String("error", err.Error()),
@@ -81,13 +194,61 @@ func (m *Manager) GetSession(id string) (*Session, error) {
 	return session, nil
 }
 
+// ResolveSession finds a session by ID, ignoring letter case, or by exact
+// label match when no ID matches
+func (m *Manager) ResolveSession(ref string) (*Session, error) {
+	if session, err := m.GetSession(strings.ToLower(ref)); err == nil {
+		return session, nil
+	}
+
+	m.mu.RLock()
+	var matches []*Session
+	for _, session := range m.sessions {
+		if session.Label != "" && session.Label == ref {
+			matches = append(matches, session)
+		}
+	}
+	m.mu.RUnlock()
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, ref)
+	case 1:
+		matches[0].UpdateLastActive()
+		return matches[0], nil
+	default:
+		return nil, fmt.Errorf("label %q matches %d sessions; use the session ID", ref, len(matches))
+	}
+}
+
+// SessionIDFor returns the ID of the session ref names, the way
+// ResolveSession finds it, without marking the session active
+func (m *Manager) SessionIDFor(ref string) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, exists := m.sessions[strings.ToLower(ref)]; exists {
+		return strings.ToLower(ref), true
+	}
+	id := ""
+	for _, session := range m.sessions {
+		if session.Label != "" && session.Label == ref {
+			if id != "" {
+				return "", false
+			}
+			id = session.ID
+		}
+	}
+	return id, id != ""
+}
+
 func (m *Manager) RemoveSession(id string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	session, exists := m.sessions[id]
 	if !exists {
-		err := fmt.Errorf("session not found: %s", id)
+		err := fmt.Errorf("%w: %s", ErrSessionNotFound, id)
 		slog.Debug("Cannot remove non-existent session",
 			slog.String("session_id", id),
 			slog.String("error", err.Error()),
@@ -101,7 +262,8 @@ func (m *Manager) RemoveSession(id string) error {
 		return fmt.Errorf("failed to close session: %w", err)
 	}
 
-	delete(m.sessions, id)
+	m.forgetSession(session)
+	m.persistLocked()
 	utils.LogSessionEvent(id, "removed",
 		slog.Int("remaining_sessions", len(m.sessions)),
 	)
@@ -120,45 +282,467 @@ func (m *Manager) ListSessions() []*SessionInfo {
 	return sessions
 }
 
-func (m *Manager) CleanupIdleSessions() {
+// DuplicateSession launches a new, independent session using the source
+// session's command, args and env. Entries in env override the source's
+// environment; non-zero width/height replace the source's values. The clone
+// does not join the source's group or share its label, so the label keeps
+// resolving to the source; pass label to name the clone.
+func (m *Manager) DuplicateSession(sourceID string, env map[string]string, width, height int, label string) (*Session, error) {
+	source, err := m.GetSession(sourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if source.Imported != "" {
+		return nil, ErrImported
+	}
+	cfg := source.Config()
+	cfg.Group = ""
+	cfg.Label = label
+	for k, v := range env {
+		cfg.Env[k] = v
+	}
+	// A locale variable given for the clone replaces the source's locale,
+	// along with the encoding it chose
+	for _, name := range LocaleVars {
+		if _, ok := env[name]; ok && cfg.Locale != "" {
+			cfg.Locale = ""
+			delete(cfg.Options, OptionEncoding)
+		}
+	}
+	if _, ok := env["TZ"]; ok {
+		cfg.Timezone = ""
+	}
+	if width > 0 {
+		cfg.Width = width
+	}
+	if height > 0 {
+		cfg.Height = height
+	}
+
+	session, err := m.CreateSessionWithConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	utils.LogSessionEvent(session.ID, "duplicated",
+		slog.String("source_session_id", sourceID),
+	)
+	return session, nil
+}
+
+// ListGroupSessions returns the sessions belonging to the given group
+func (m *Manager) ListGroupSessions(group string) []*SessionInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var sessions []*SessionInfo
+	for id := range m.groups[group] {
+		if session, exists := m.sessions[id]; exists {
+			sessions = append(sessions, session.GetInfo())
+		}
+	}
+
+	return sessions
+}
+
+// ListGroups returns all groups that currently have at least one session
+func (m *Manager) ListGroups() []*GroupInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	groups := make([]*GroupInfo, 0, len(m.groups))
+	for name, members := range m.groups {
+		info := &GroupInfo{Name: name, SessionIDs: make([]string, 0, len(members))}
+		for id := range members {
+			info.SessionIDs = append(info.SessionIDs, id)
+		}
+		sort.Strings(info.SessionIDs)
+		groups = append(groups, info)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+
+	return groups
+}
+
+// StopGroup closes every session in the group and returns the stopped IDs.
+// Sessions are detached from the manager under the lock and closed after
+// releasing it, so slow process shutdowns don't block other callers.
+func (m *Manager) StopGroup(group string) ([]string, error) {
+	m.mu.Lock()
+	members, exists := m.groups[group]
+	if !exists {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("group not found: %s", group)
+	}
+
+	var toClose []*Session
+	for id := range members {
+		if session, ok := m.sessions[id]; ok {
+			toClose = append(toClose, session)
+			m.forgetSession(session)
+		}
+	}
+	delete(m.groups, group)
+	m.persistLocked()
+	m.mu.Unlock()
+
+	stopped := make([]string, 0, len(toClose))
+	var firstErr error
+	for _, session := range toClose {
+		if err := session.Close(); err != nil {
+			utils.LogError(err, "Failed to close grouped session",
+				slog.String("session_id", session.ID),
+				slog.String("group", group),
+			)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to close session %s: %w", session.ID, err)
+			}
+		}
+		stopped = append(stopped, session.ID)
+		utils.LogSessionEvent(session.ID, "removed", slog.String("group", group))
+	}
+	sort.Strings(stopped)
+
+	slog.Info("Session group stopped",
+		slog.String("group", group),
+		slog.Int("stopped", len(stopped)),
+	)
+	return stopped, firstErr
+}
+
+// StopSession closes and removes a single session. The process gets the
+// manager's grace period to exit after SIGTERM unless force is set, in which
+// case it is killed immediately. An unknown ID is an error unless
+// ignoreMissing is set, which reports it as "already_removed" instead.
+func (m *Manager) StopSession(id string, force, ignoreMissing bool) (StopResult, error) {
+	m.mu.Lock()
+	session, exists := m.sessions[id]
+	if !exists {
+		m.mu.Unlock()
+		if ignoreMissing {
+			return StopResult{ID: id, Result: "already_removed"}, nil
+		}
+		return StopResult{}, fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+	m.forgetSession(session)
+	m.persistLocked()
+	grace := m.stopGracePeriod
+	m.mu.Unlock()
+
+	if force {
+		grace = 0
+	}
+	result := closeSession(session, grace)
+	if result.Result == "error" {
+		return result, fmt.Errorf("failed to close session: %s", result.Error)
+	}
+	return result, nil
+}
+
+// closeSession closes a session that has already been removed from the
+// manager and describes the outcome
+func closeSession(session *Session, grace time.Duration) StopResult {
+	result := StopResult{ID: session.ID, Result: "stopped"}
+	killed, err := session.CloseGracefully(grace)
+	if err != nil {
+		result.Result = "error"
+		result.Error = err.Error()
+	} else if killed {
+		result.Result = "killed"
+	}
+
+	utils.LogSessionEvent(session.ID, "removed",
+		slog.String("result", result.Result),
+	)
+	return result
+}
+
+// StopAllSessions detaches every session from the manager and closes them
+// concurrently, asking each process to exit before killing it. The map lock
+// is only held while taking the snapshot, so sessions exiting on their own or
+// a concurrent cleanup run cannot deadlock with it.
+func (m *Manager) StopAllSessions() []StopResult {
+	m.mu.Lock()
+	toClose := make([]*Session, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		toClose = append(toClose, session)
+		m.forgetSession(session)
+	}
+	grace := m.stopGracePeriod
+	m.persistLocked()
+	m.mu.Unlock()
+
+	results := make([]StopResult, len(toClose))
+	var wg sync.WaitGroup
+	for i, session := range toClose {
+		wg.Add(1)
+		go func(i int, session *Session) {
+			defer wg.Done()
+			results[i] = closeSession(session, grace)
+		}(i, session)
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].ID < results[j].ID })
+
+	slog.Info("All sessions stopped", slog.Int("count", len(results)))
+	return results
+}
+
+// Shutdown stops the cleanup routine, closes every session and clears all
+// bookkeeping
+func (m *Manager) Shutdown() {
+	m.StopCleanupRoutine()
+	m.shutdownPool()
+	m.StopAllSessions()
+	slog.Info("Session manager shut down")
+}
+
+// RestartSession restarts a session's process and records its new PID. See
+// Session.Restart for clearHistory.
+func (m *Manager) RestartSession(id string, clearHistory bool) error {
+	session, err := m.GetSession(id)
+	if err != nil {
+		return err
+	}
+
+	err = session.Restart(clearHistory)
+
+	m.mu.Lock()
+	m.persistLocked()
+	m.mu.Unlock()
+
+	return err
+}
+
+// EnableStatePersistence starts recording session metadata in dir. Records
+// left behind by servers that are no longer running are checked, and any
+// processes that are still alive become available through ListOrphans.
+func (m *Manager) EnableStatePersistence(dir string) error {
+	store, err := newStateStore(dir)
+	if err != nil {
+		return err
+	}
+
+	orphans, err := store.loadOrphans()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.state = store
+	m.orphans = append(m.orphans, orphans...)
+	m.persistLocked()
+
+	slog.Info("Session state persistence enabled",
+		slog.String("path", store.path),
+		slog.Int("orphans", len(m.orphans)),
+	)
+	return nil
+}
+
+// ListOrphans returns processes from a previous server run that are still
+// alive. Orphans that have exited since the last check are dropped.
+func (m *Manager) ListOrphans() []SessionRecord {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	now := time.Now()
+	alive := make([]SessionRecord, 0, len(m.orphans))
+	for _, rec := range m.orphans {
+		if recordAlive(rec) {
+			alive = append(alive, rec)
+		}
+	}
+	if len(alive) != len(m.orphans) {
+		m.orphans = alive
+		m.persistLocked()
+	}
+
+	result := make([]SessionRecord, len(alive))
+	copy(result, alive)
+	return result
+}
+
+// ReapOrphans kills every known orphan's process group and forgets them
+func (m *Manager) ReapOrphans() []ReapResult {
+	m.mu.Lock()
+	orphans := m.orphans
+	m.orphans = nil
+	m.persistLocked()
+	m.mu.Unlock()
+
+	results := make([]ReapResult, 0, len(orphans))
+	for _, rec := range orphans {
+		result := reapRecord(rec)
+		slog.Info("Orphan reaped",
+			slog.String("session_id", rec.ID),
+			slog.Int("pid", rec.PID),
+			slog.String("result", result.Result),
+		)
+		results = append(results, result)
+	}
+	return results
+}
+
+// persistLocked writes the current session records to the state file.
+// Caller must hold m.mu.
+func (m *Manager) persistLocked() {
+	if m.state == nil {
+		return
+	}
+
+	records := make([]SessionRecord, 0, len(m.sessions)+len(m.pool))
+	for _, session := range m.sessions {
+		if session.Imported != "" {
+			continue // No process to reap
+		}
+		records = append(records, session.record())
+	}
+	for _, session := range m.pool {
+		records = append(records, session.record())
+	}
+	if err := m.state.save(records, m.orphans); err != nil {
+		utils.LogError(err, "Failed to persist session state")
+	}
+}
+
+// forgetSession removes a session from the session map and its group,
+// keeping its events for the retention window. Caller must hold m.mu.
+func (m *Manager) forgetSession(session *Session) {
+	delete(m.sessions, session.ID)
+	m.retainEvents(session)
+	if session.Group == "" {
+		return
+	}
+	if members, ok := m.groups[session.Group]; ok {
+		delete(members, session.ID)
+		if len(members) == 0 {
+			delete(m.groups, session.Group)
+		}
+	}
+}
+
+func (m *Manager) CleanupIdleSessions() {
+	m.mu.Lock()
+
+	now := m.clock.Now()
 	cleaned := 0
 	for id, session := range m.sessions {
 		idleTime := now.Sub(session.LastActive)
 		if idleTime > m.sessionTimeout {
+			session.recordEvent(EventIdleCleanup, map[string]interface{}{
+				"idle_ms": idleTime.Milliseconds(),
+			})
 			if err := session.Close(); err != nil {
 				utils.LogError(err, "Error closing idle session",
 					slog.String("session_id", id),
 					slog.Duration("idle_time", idleTime),
 				)
 			}
-			delete(m.sessions, id)
+			m.forgetSession(session)
 			utils.LogSessionEvent(id, "cleaned_idle",
 				slog.Duration("idle_time", idleTime),
 			)
 			cleaned++
 		}
 	}
+	m.pruneRetainedLocked()
+	remaining := len(m.sessions)
 	if cleaned > 0 {
+		m.persistLocked()
+	}
+	m.mu.Unlock()
+
+	if cleaned > 0 {
+		m.RecordActivity(ActivityCleanup, "", map[string]interface{}{
+			"cleaned":   cleaned,
+			"remaining": remaining,
+		})
 		slog.Info("Idle session cleanup completed",
 			slog.Int("cleaned", cleaned),
-			slog.Int("remaining", len(m.sessions)),
+			slog.Int("remaining", remaining),
 		)
 	}
 }
 
+// StartCleanupRoutine starts the periodic idle session cleanup. Calling it
+// while the routine is already running has no effect.
 func (m *Manager) StartCleanupRoutine() {
-	interval := 5 * time.Minute
+	m.cleanupMu.Lock()
+	defer m.cleanupMu.Unlock()
+
+	if m.cleanupDone != nil {
+		slog.Debug("Session cleanup routine already running")
+		return
+	}
+
+	interval := m.cleanupInterval
 	slog.Info("Starting session cleanup routine", slog.Duration("interval", interval))
-	
-	ticker := time.NewTicker(interval)
+
+	done := make(chan struct{})
+	m.cleanupDone = done
+	ticker := m.Clock().NewTicker(interval)
+
+	m.cleanupWG.Add(1)
 	go func() {
-		for range ticker.C {
-			slog.Debug("Running idle session cleanup")
-			m.CleanupIdleSessions()
+		defer m.cleanupWG.Done()
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C():
+				if m.IsCleanupPaused() {
+					slog.Debug("Idle session cleanup skipped (paused)")
+					continue
+				}
+				slog.Debug("Running idle session cleanup")
+				m.CleanupIdleSessions()
+			case <-done:
+				return
+			}
 		}
 	}()
-}
\ No newline at end of file
+}
+
+// StopCleanupRoutine stops the cleanup routine and waits for it to exit
+func (m *Manager) StopCleanupRoutine() {
+	m.cleanupMu.Lock()
+	done := m.cleanupDone
+	m.cleanupDone = nil
+	m.cleanupMu.Unlock()
+
+	if done == nil {
+		return
+	}
+	close(done)
+	m.cleanupWG.Wait()
+
+	slog.Info("Session cleanup routine stopped")
+}
+
+// IsCleanupRunning reports whether the cleanup routine goroutine is active
+func (m *Manager) IsCleanupRunning() bool {
+	m.cleanupMu.Lock()
+	defer m.cleanupMu.Unlock()
+	return m.cleanupDone != nil
+}
+
+// SetCleanupPaused pauses or resumes idle session cleanup without stopping
+// the routine, e.g. to keep sessions alive during a long debugging session
+func (m *Manager) SetCleanupPaused(paused bool) {
+	m.cleanupMu.Lock()
+	defer m.cleanupMu.Unlock()
+	m.cleanupPaused = paused
+
+	slog.Info("Session cleanup pause changed", slog.Bool("paused", paused))
+}
+
+// IsCleanupPaused reports whether idle session cleanup is paused
+func (m *Manager) IsCleanupPaused() bool {
+	m.cleanupMu.Lock()
+	defer m.cleanupMu.Unlock()
+	return m.cleanupPaused
+}
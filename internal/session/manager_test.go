@@ -1,10 +1,13 @@
 package session
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 	
+	"github.com/bioharz/mcp-terminal-tester/internal/testutil"
 	"github.com/bioharz/mcp-terminal-tester/internal/utils"
 )
 
@@ -162,18 +165,17 @@ func TestManager_ListSessions(t *testing.T) {
 func TestManager_CleanupIdleSessions(t *testing.T) {
 	utils.InitLogger()
 	manager := NewManager()
-	manager.sessionTimeout = 100 * time.Millisecond // Short timeout for testing
+	clk := testutil.NewFakeClock(time.Now())
+	manager.SetClock(clk)
+	manager.sessionTimeout = time.Minute
 	
 	// Create sessions
 	sess1, _ := manager.CreateSession("echo", []string{}, nil)
+	clk.Advance(40 * time.Second)
 	sess2, _ := manager.CreateSession("echo", []string{}, nil)
 	
-	// Make sess1 idle by setting LastActive in the past
-	sess1.mu.Lock()
-	sess1.LastActive = time.Now().Add(-200 * time.Millisecond)
-	sess1.mu.Unlock()
-	
-	// Run cleanup
+	// sess1 has now been idle past the timeout, sess2 hasn't
+	clk.Advance(30 * time.Second)
 	manager.CleanupIdleSessions()
 	
 	// sess1 should be gone, sess2 should remain
@@ -237,4 +239,288 @@ func TestManager_ConcurrentAccess(t *testing.T) {
 	for _, sess := range sessions {
 		manager.RemoveSession(sess.ID)
 	}
-}
\ No newline at end of file
+}
+
+func TestManager_GroupBookkeeping(t *testing.T) {
+	utils.InitLogger()
+	manager := NewManager()
+	clk := testutil.NewFakeClock(time.Now())
+	manager.SetClock(clk)
+	manager.sessionTimeout = time.Minute
+
+	sess1, err := manager.CreateSessionWithConfig(SessionConfig{Command: "sleep", Args: []string{"10"}, Group: "g1"})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	_, err = manager.CreateSessionWithConfig(SessionConfig{Command: "sleep", Args: []string{"10"}, Group: "g1"})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	groups := manager.ListGroups()
+	if len(groups) != 1 || len(groups[0].SessionIDs) != 2 {
+		t.Fatalf("Expected one group with 2 sessions, got %+v", groups)
+	}
+
+	// Removing one member keeps the group
+	manager.RemoveSession(sess1.ID)
+	if members := manager.ListGroupSessions("g1"); len(members) != 1 {
+		t.Errorf("Expected 1 member after removal, got %d", len(members))
+	}
+
+	// Idle cleanup of the last member removes the group
+	clk.Advance(2 * time.Minute)
+	manager.CleanupIdleSessions()
+
+	if groups := manager.ListGroups(); len(groups) != 0 {
+		t.Errorf("Expected no groups after cleanup, got %+v", groups)
+	}
+
+	if _, err := manager.StopGroup("g1"); err == nil {
+		t.Error("Expected error stopping a group that no longer exists")
+	}
+}
+
+func TestManager_DuplicateSession(t *testing.T) {
+	utils.InitLogger()
+	manager := NewManager()
+
+	source, err := manager.CreateSessionWithConfig(SessionConfig{
+		Command: "sleep",
+		Args:    []string{"10"},
+		Env:     map[string]string{"A": "1", "B": "1"},
+		Group:   "g1",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer manager.RemoveSession(source.ID)
+
+	clone, err := manager.DuplicateSession(source.ID, map[string]string{"B": "2"}, 0, 40, "tall")
+	if err != nil {
+		t.Fatalf("Failed to duplicate session: %v", err)
+	}
+	defer manager.RemoveSession(clone.ID)
+
+	cfg := clone.Config()
+	if cfg.Env["A"] != "1" || cfg.Env["B"] != "2" {
+		t.Errorf("Unexpected merged env: %v", cfg.Env)
+	}
+	if cfg.Width != 80 || cfg.Height != 40 {
+		t.Errorf("Expected 80x40, got %dx%d", cfg.Width, cfg.Height)
+	}
+	if cfg.Group != "" || cfg.Label != "tall" {
+		t.Errorf("Unexpected group/label: %q/%q", cfg.Group, cfg.Label)
+	}
+
+	// The source's env must not be modified by the merge
+	if source.Env["B"] != "1" {
+		t.Errorf("Source env modified: %v", source.Env)
+	}
+	if clone.Buffer == source.Buffer || clone.PTY == source.PTY {
+		t.Error("Clone shares buffer or PTY with source")
+	}
+}
+
+func TestManager_DuplicateSessionKeepsSourceLabel(t *testing.T) {
+	utils.InitLogger()
+	manager := NewManager()
+	defer manager.StopAllSessions()
+
+	source, err := manager.CreateSessionWithConfig(SessionConfig{Command: "sleep", Args: []string{"10"}, Label: "editor"})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	// A clone made without a label must not take the source's
+	clone, err := manager.DuplicateSession(source.ID, nil, 0, 0, "")
+	if err != nil {
+		t.Fatalf("Failed to duplicate session: %v", err)
+	}
+	if label := clone.Config().Label; label != "" {
+		t.Errorf("Expected clone without a label, got %q", label)
+	}
+	if sess, err := manager.ResolveSession("editor"); err != nil || sess != source {
+		t.Errorf("Expected label to still resolve to the source, got %v", err)
+	}
+}
+
+func TestManager_StopAllSessions(t *testing.T) {
+	utils.InitLogger()
+	manager := NewManager()
+	manager.stopGracePeriod = 300 * time.Millisecond
+
+	live, _ := manager.CreateSession("sleep", []string{"10"}, nil)
+	stubborn, _ := manager.CreateSession("sh", []string{"-c", "trap '' TERM; while true; do sleep 0.1; done"}, nil)
+	dead, _ := manager.CreateSession("true", []string{}, nil)
+	_, _ = manager.CreateSessionWithConfig(SessionConfig{Command: "sleep", Args: []string{"10"}, Group: "g1"})
+
+	// Let the short-lived process exit and the trap get installed
+	time.Sleep(200 * time.Millisecond)
+
+	// A concurrent cleanup run must not deadlock with stopping
+	done := make(chan struct{})
+	go func() {
+		manager.CleanupIdleSessions()
+		close(done)
+	}()
+
+	results := manager.StopAllSessions()
+	<-done
+
+	if len(results) != 4 {
+		t.Fatalf("Expected 4 results, got %d: %+v", len(results), results)
+	}
+
+	byID := make(map[string]StopResult)
+	for _, r := range results {
+		byID[r.ID] = r
+	}
+	if byID[live.ID].Result != "stopped" {
+		t.Errorf("Expected live session to stop cleanly, got %+v", byID[live.ID])
+	}
+	if byID[stubborn.ID].Result != "killed" {
+		t.Errorf("Expected stubborn session to be killed, got %+v", byID[stubborn.ID])
+	}
+	if byID[dead.ID].Result != "stopped" {
+		t.Errorf("Expected dead session to report stopped, got %+v", byID[dead.ID])
+	}
+
+	if len(manager.ListSessions()) != 0 || len(manager.ListGroups()) != 0 {
+		t.Error("Expected no sessions or groups after StopAllSessions")
+	}
+}
+
+func TestManager_CleanupRoutineLifecycle(t *testing.T) {
+	utils.InitLogger()
+	manager := NewManager()
+	clk := testutil.NewFakeClock(time.Now())
+	manager.SetClock(clk)
+	manager.sessionTimeout = 30 * time.Second
+
+	// Starting twice must not spawn a second goroutine
+	manager.StartCleanupRoutine()
+	done := manager.cleanupDone
+	manager.StartCleanupRoutine()
+	if !manager.IsCleanupRunning() {
+		t.Fatal("Cleanup routine should be running")
+	}
+	if manager.cleanupDone != done {
+		t.Error("Second StartCleanupRoutine call started a new routine")
+	}
+	if clk.Waiters() != 1 {
+		t.Errorf("Expected one cleanup ticker, got %d", clk.Waiters())
+	}
+
+	// While paused, idle sessions survive. Each tick is taken before
+	// Advance returns, so the second has waited for the first to be handled.
+	manager.SetCleanupPaused(true)
+	sess, err := manager.CreateSession("sleep", []string{"10"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	clk.Advance(manager.cleanupInterval)
+	clk.Advance(manager.cleanupInterval)
+	if len(manager.ListSessions()) != 1 {
+		t.Fatal("Idle session cleaned up while cleanup was paused")
+	}
+
+	// Once resumed, the routine removes them
+	manager.SetCleanupPaused(false)
+	clk.Advance(manager.cleanupInterval)
+	clk.Advance(manager.cleanupInterval)
+	if len(manager.ListSessions()) != 0 {
+		manager.RemoveSession(sess.ID)
+		t.Error("Idle session not cleaned up after resuming")
+	}
+
+	manager.Shutdown()
+	if manager.IsCleanupRunning() {
+		t.Error("Cleanup routine should be stopped after Shutdown")
+	}
+	if clk.Waiters() != 0 {
+		t.Error("Expected the cleanup ticker to be stopped")
+	}
+
+	// Stopping again is a no-op, and the routine can be restarted
+	manager.StopCleanupRoutine()
+	manager.StartCleanupRoutine()
+	if !manager.IsCleanupRunning() {
+		t.Error("Cleanup routine should restart after being stopped")
+	}
+	manager.StopCleanupRoutine()
+}
+
+func TestManager_ResolveSession(t *testing.T) {
+	utils.InitLogger()
+	manager := NewManager()
+	defer manager.StopAllSessions()
+
+	first, err := manager.CreateSessionWithConfig(SessionConfig{Command: "sleep", Args: []string{"10"}, Label: "editor"})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	if sess, err := manager.ResolveSession(strings.ToUpper(first.ID)); err != nil || sess != first {
+		t.Errorf("Expected uppercase ID to resolve, got %v", err)
+	}
+	if sess, err := manager.ResolveSession("editor"); err != nil || sess != first {
+		t.Errorf("Expected label to resolve, got %v", err)
+	}
+	if _, err := manager.ResolveSession("missing"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Expected not found error, got %v", err)
+	}
+
+	// Duplicate labels are ambiguous rather than picking one at random
+	if _, err := manager.CreateSessionWithConfig(SessionConfig{Command: "sleep", Args: []string{"10"}, Label: "editor"}); err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	if _, err := manager.ResolveSession("editor"); err == nil || !strings.Contains(err.Error(), "matches 2 sessions") {
+		t.Errorf("Expected ambiguous label error, got %v", err)
+	}
+}
+
+func TestManager_StopSession(t *testing.T) {
+	utils.InitLogger()
+	manager := NewManager()
+	manager.stopGracePeriod = 5 * time.Second
+
+	// A process that ignores SIGTERM is killed at once when forced
+	stubborn, err := manager.CreateSession("sh", []string{"-c", "trap '' TERM; while true; do sleep 1; done"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	result, err := manager.StopSession(stubborn.ID, true, false)
+	if err != nil || result.Result != "killed" {
+		t.Errorf("Expected forced stop to kill, got %+v, %v", result, err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Forced stop waited for the grace period (%v)", elapsed)
+	}
+
+	// Stopping again is an error unless missing sessions are ignored
+	if _, err := manager.StopSession(stubborn.ID, false, false); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound, got %v", err)
+	}
+	result, err = manager.StopSession(stubborn.ID, false, true)
+	if err != nil || result.Result != "already_removed" {
+		t.Errorf("Expected already_removed, got %+v, %v", result, err)
+	}
+
+	// A session whose process already exited stops cleanly
+	exited, err := manager.CreateSession("true", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if result, err := manager.StopSession(exited.ID, false, false); err != nil || result.Result != "stopped" {
+		t.Errorf("Expected exited session to stop cleanly, got %+v, %v", result, err)
+	}
+
+	// Closing a session twice succeeds
+	if err := exited.Close(); err != nil {
+		t.Errorf("Expected second Close to succeed, got %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+package session
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultObserverQueue is how many output events wait for observers before
+// the oldest are dropped
+const defaultObserverQueue = 1024
+
+// OutputEvent is one write of the process's output to the screen buffer
+type OutputEvent struct {
+	Time       time.Time // When the output was read
+	Data       []byte    // The bytes as the process wrote them; shared, so observers must not change them
+	Generation uint64    // The screen's generation once they were written
+}
+
+// OutputObserver is told about each write of output to a session's screen
+// buffer.
+//
+// The contract every observer can rely on:
+//   - Observers are called after the write, never with the buffer's lock
+//     held, so they may read the buffer.
+//   - They are called one at a time on the session's dispatch goroutine,
+//     in the order they were registered, and see events in the order the
+//     output was written.
+//   - The read loop never waits for them. Events queue for dispatch up to
+//     a bound; past it the oldest queued event is dropped for every
+//     observer and counted in OutputStats.DroppedEvents. An observer that
+//     must not miss output should be quick, or keep its own state from
+//     the buffer rather than from the events.
+//   - Unregistering never waits, so it is safe from inside an observer,
+//     including the one being removed. Once it returns the observer isn't
+//     called again, apart from a call the dispatcher had already begun.
+//   - Events already queued when the session closes are still delivered
+//     before Close returns.
+type OutputObserver func(OutputEvent)
+
+// observerSet holds a session's output observers and the queue of events
+// waiting for them
+type observerSet struct {
+	mu        sync.Mutex
+	observers []*observerEntry // In registration order
+	queue     []OutputEvent
+	max       int
+	dropped   uint64
+	closed    bool
+	wake      chan struct{} // Signalled when an event is queued
+	done      chan struct{} // Closed when the dispatcher has exited
+	// delivering is held while an event is handed to the observers. It is
+	// taken with mu held, so once discard holds it no dequeued event is
+	// still on its way.
+	delivering sync.Mutex
+}
+
+type observerEntry struct {
+	fn      OutputObserver
+	removed atomic.Bool
+}
+
+func newObserverSet(max int) *observerSet {
+	o := &observerSet{
+		max:  max,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	go o.dispatch()
+	return o
+}
+
+// ObserveOutput registers fn to be told about each write of output from
+// now on, after the observers registered before it, and returns a function
+// that unregisters it. See OutputObserver for how it is called.
+func (s *Session) ObserveOutput(fn OutputObserver) (unregister func()) {
+	return s.observers.add(fn)
+}
+
+func (o *observerSet) add(fn OutputObserver) func() {
+	entry := &observerEntry{fn: fn}
+	o.mu.Lock()
+	o.observers = append(o.observers, entry)
+	o.mu.Unlock()
+
+	return func() {
+		entry.removed.Store(true)
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		for i, e := range o.observers {
+			if e == entry {
+				o.observers = append(o.observers[:i:i], o.observers[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// publish queues an event for the observers without waiting for them
+func (o *observerSet) publish(event OutputEvent) {
+	o.mu.Lock()
+	if o.closed || len(o.observers) == 0 {
+		o.mu.Unlock()
+		return
+	}
+	if len(o.queue) >= o.max {
+		over := len(o.queue) - o.max + 1
+		o.queue = append(o.queue[:0], o.queue[over:]...)
+		o.dropped += uint64(over)
+	}
+	o.queue = append(o.queue, event)
+	o.mu.Unlock()
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// droppedEvents returns how many events were dropped for a full queue
+func (o *observerSet) droppedEvents() uint64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.dropped
+}
+
+// dispatch delivers queued events until the set is closed and the queue
+// drained
+func (o *observerSet) dispatch() {
+	defer close(o.done)
+	for {
+		o.mu.Lock()
+		if len(o.queue) == 0 {
+			closed := o.closed
+			o.mu.Unlock()
+			if closed {
+				return
+			}
+			<-o.wake
+			continue
+		}
+		event := o.queue[0]
+		o.queue[0] = OutputEvent{} // Let the data go
+		o.queue = o.queue[1:]
+		observers := o.observers
+		o.delivering.Lock()
+		o.mu.Unlock()
+
+		// observers is never changed in place, so the snapshot stays
+		// valid; removed catches an unregister since it was taken
+		for _, e := range observers {
+			if !e.removed.Load() {
+				e.fn(event)
+			}
+		}
+		o.delivering.Unlock()
+	}
+}
+
+// discard drops the queued events and the count of dropped ones, waits
+// for an event being delivered, then calls reset before any later event is
+// delivered. It must not be called from an observer.
+func (o *observerSet) discard(reset func()) {
+	o.mu.Lock()
+	clear(o.queue)
+	o.queue = o.queue[:0]
+	o.dropped = 0
+	o.mu.Unlock()
+
+	o.delivering.Lock()
+	defer o.delivering.Unlock()
+	reset()
+}
+
+// close stops taking events and waits until those queued are delivered
+func (o *observerSet) close() {
+	o.mu.Lock()
+	o.closed = true
+	o.mu.Unlock()
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	<-o.done
+}
+
+// OutputStats counts a session's output. It is the first output observer,
+// registered when the session is made.
+type OutputStats struct {
+	Writes        uint64    `json:"writes"`         // Reads from the process written to the screen
+	Bytes         uint64    `json:"bytes"`          // Bytes in them
+	LastWrite     time.Time `json:"last_write"`     // When the newest was read
+	Generation    uint64    `json:"generation"`     // Screen generation after it
+	DroppedEvents uint64    `json:"dropped_events"` // Writes observers missed because they fell behind, not counted above
+}
+
+// outputStats is the observer behind OutputStats
+type outputStats struct {
+	mu    sync.Mutex
+	stats OutputStats
+}
+
+func (c *outputStats) observe(event OutputEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Writes++
+	c.stats.Bytes += uint64(len(event.Data))
+	c.stats.LastWrite = event.Time
+	c.stats.Generation = event.Generation
+}
+
+// startOutputStats makes the session's observers and registers the
+// output counters first among them
+func (s *Session) startOutputStats() {
+	s.observers = newObserverSet(defaultObserverQueue)
+	s.observers.add(s.stats.observe)
+}
+
+// resetOutputStats forgets the output counted so far and the events still
+// waiting for observers, so counting starts afresh
+func (s *Session) resetOutputStats() {
+	s.observers.discard(func() {
+		s.stats.mu.Lock()
+		s.stats.stats = OutputStats{}
+		s.stats.mu.Unlock()
+	})
+}
+
+// OutputStats returns counts of the session's output. They are kept by an
+// output observer, so output read a moment ago may not be counted yet.
+func (s *Session) OutputStats() OutputStats {
+	s.stats.mu.Lock()
+	stats := s.stats.stats
+	s.stats.mu.Unlock()
+	stats.DroppedEvents = s.observers.droppedEvents()
+	return stats
+}
@@ -0,0 +1,158 @@
+//go:build !windows
+
+package session
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+)
+
+func TestObserverSet_Ordering(t *testing.T) {
+	o := newObserverSet(defaultObserverQueue)
+
+	var mu sync.Mutex
+	var calls []string
+	for _, name := range []string{"a", "b", "c"} {
+		o.add(func(event OutputEvent) {
+			mu.Lock()
+			calls = append(calls, fmt.Sprintf("%s%d", name, event.Generation))
+			mu.Unlock()
+		})
+	}
+	for gen := uint64(1); gen <= 3; gen++ {
+		o.publish(OutputEvent{Time: time.Now(), Data: []byte("x"), Generation: gen})
+	}
+	o.close()
+
+	want := "a1 b1 c1 a2 b2 c2 a3 b3 c3"
+	if got := strings.Join(calls, " "); got != want {
+		t.Errorf("Expected calls %q, got %q", want, got)
+	}
+	if o.droppedEvents() != 0 {
+		t.Errorf("Expected nothing dropped, got %d", o.droppedEvents())
+	}
+}
+
+func TestObserverSet_DropsOldestWhenBehind(t *testing.T) {
+	o := newObserverSet(3)
+
+	// The first event blocks its observer until the rest are queued
+	started, release := make(chan struct{}), make(chan struct{})
+	var seen []uint64
+	o.add(func(event OutputEvent) {
+		if event.Generation == 1 {
+			close(started)
+			<-release
+		}
+		seen = append(seen, event.Generation)
+	})
+
+	o.publish(OutputEvent{Generation: 1})
+	<-started
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for gen := uint64(2); gen <= 10; gen++ {
+			o.publish(OutputEvent{Generation: gen})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected publishing not to wait for a blocked observer")
+	}
+	close(release)
+	o.close()
+
+	if want := []uint64{1, 8, 9, 10}; fmt.Sprint(seen) != fmt.Sprint(want) {
+		t.Errorf("Expected the oldest events dropped, seeing %v, got %v", want, seen)
+	}
+	if o.droppedEvents() != 6 {
+		t.Errorf("Expected 6 events dropped, got %d", o.droppedEvents())
+	}
+}
+
+func TestObserverSet_UnregisterDuringDispatch(t *testing.T) {
+	o := newObserverSet(defaultObserverQueue)
+
+	var mu sync.Mutex
+	counts := map[string]int{}
+	record := func(name string) {
+		mu.Lock()
+		counts[name]++
+		mu.Unlock()
+	}
+
+	// The first observer removes itself and the one after it on the first
+	// event, before that one is reached
+	var removeSelf, removeNext func()
+	removeSelf = o.add(func(event OutputEvent) {
+		record("self")
+		removeSelf()
+		removeNext()
+	})
+	removeNext = o.add(func(event OutputEvent) { record("next") })
+	o.add(func(event OutputEvent) { record("last") })
+
+	for gen := uint64(1); gen <= 3; gen++ {
+		o.publish(OutputEvent{Generation: gen})
+	}
+	o.close()
+
+	if counts["self"] != 1 || counts["next"] != 0 || counts["last"] != 3 {
+		t.Errorf("Expected self once, next never and last every time, got %v", counts)
+	}
+	removeSelf() // Unregistering twice is harmless
+}
+
+func TestSession_OutputStats(t *testing.T) {
+	utils.InitLogger()
+
+	sess, err := NewSession("sh", []string{"-c", "printf hello; sleep 10"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer sess.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for sess.OutputStats().Bytes < 5 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	stats := sess.OutputStats()
+	if stats.Writes == 0 || stats.Bytes < 5 || stats.LastWrite.IsZero() || stats.Generation == 0 {
+		t.Errorf("Expected the output counted, got %+v", stats)
+	}
+
+	// An observer added later sees output from then on
+	var mu sync.Mutex
+	var observed strings.Builder
+	unregister := sess.ObserveOutput(func(event OutputEvent) {
+		mu.Lock()
+		observed.Write(event.Data)
+		mu.Unlock()
+	})
+	defer unregister()
+	if _, err := sess.SendKeys(context.Background(), "x"); err != nil {
+		t.Fatalf("SendKeys failed: %v", err)
+	}
+	seen := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return observed.String()
+	}
+	for !strings.Contains(seen(), "x") && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if !strings.Contains(seen(), "x") {
+		t.Errorf("Expected the observer to see the echo, got %q", seen())
+	}
+	if got := sess.OutputStats(); got.Bytes <= stats.Bytes {
+		t.Errorf("Expected the echo counted, got %+v", got)
+	}
+}
@@ -0,0 +1,485 @@
+package session
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+)
+
+// OptionKind is the value type of a session option
+type OptionKind string
+
+const (
+	OptionString  OptionKind = "string"
+	OptionInteger OptionKind = "integer"
+)
+
+// OptionSource records where an option's effective value came from
+type OptionSource string
+
+const (
+	SourceDefault OptionSource = "default" // Built-in default
+	SourceLaunch  OptionSource = "launch"  // Set when the session was launched
+	SourceRuntime OptionSource = "runtime" // Changed with set_session_option
+)
+
+// Option names
+const (
+	OptionDefaultFormat    = "default_format"
+	OptionScrollbackLines  = "scrollback_lines"
+	OptionRawBufferSize    = "raw_buffer_size"
+	OptionLogRecords       = "log_records"
+	OptionParserStrictness = "parser_strictness"
+	OptionLineFeed         = "line_feed"
+	OptionEncoding         = "encoding"
+	OptionScreenHistory    = "screen_history"
+	OptionColumnMode       = "column_mode"
+	OptionPromptPattern    = "prompt_pattern"
+	OptionMaxLineWraps     = "max_line_wraps"
+	OptionRawColors        = "raw_colors"
+	OptionKeyProfile       = "key_profile"
+
+	OptionUnresponsiveInputMs  = "unresponsive_input_ms"
+	OptionUnresponsiveOutputMs = "unresponsive_output_ms"
+	OptionResourceSampleMs     = "resource_sample_ms"
+	OptionResourceSamples      = "resource_samples"
+)
+
+// Values of the column_mode option
+const (
+	ColumnModeTrack  = "track"
+	ColumnModeResize = "resize"
+)
+
+// Values of the key_profile option. The tables behind them are in the
+// tools package, which maps key names.
+const (
+	KeyProfileXterm        = "xterm"
+	KeyProfileVT100        = "vt100"
+	KeyProfileLinuxConsole = "linux-console"
+)
+
+// BuiltinKeyProfiles lists the key profiles there are without a keymap file
+var BuiltinKeyProfiles = []string{KeyProfileXterm, KeyProfileVT100, KeyProfileLinuxConsole}
+
+var (
+	keyProfilesMu sync.RWMutex
+	keyProfiles   = BuiltinKeyProfiles
+)
+
+// KeyProfiles returns the key profiles the key_profile option accepts: the
+// built-in ones and any the loaded keymap file defines
+func KeyProfiles() []string {
+	keyProfilesMu.RLock()
+	defer keyProfilesMu.RUnlock()
+	return append([]string(nil), keyProfiles...)
+}
+
+// SetKeyProfiles sets the key profiles the key_profile option accepts.
+// Sessions already set to a profile no longer listed keep the value.
+func SetKeyProfiles(names []string) {
+	keyProfilesMu.Lock()
+	defer keyProfilesMu.Unlock()
+	keyProfiles = append([]string(nil), names...)
+}
+
+// OptionDef describes a per-session option. Values are string for
+// OptionString and int for OptionInteger.
+type OptionDef struct {
+	Name        string
+	Kind        OptionKind
+	Description string
+	Default     interface{}
+	validate    func(value interface{}) error
+	apply       func(s *Session, value interface{}) // Pushes the value into the session's components
+}
+
+// OptionValue is an option's effective value and its source
+type OptionValue struct {
+	Value  interface{}  `json:"value"`
+	Source OptionSource `json:"source"`
+}
+
+var optionDefs = map[string]*OptionDef{
+	OptionDefaultFormat: {
+		Name:        OptionDefaultFormat,
+		Kind:        OptionString,
+		Description: "Format view_screen uses when none is given; empty means the server default",
+		Default:     "",
+		validate: func(value interface{}) error {
+			format := value.(string)
+			if format == "" {
+				return nil
+			}
+			for _, f := range terminal.RenderFormats {
+				if format == f {
+					return nil
+				}
+			}
+			return fmt.Errorf("must be one of: %s", strings.Join(terminal.RenderFormats, ", "))
+		},
+	},
+	OptionScrollbackLines: {
+		Name:        OptionScrollbackLines,
+		Kind:        OptionInteger,
+		Description: "Lines of history kept after they scroll off the screen",
+		Default:     1000,
+		validate:    intRange(0, 100000),
+		apply: func(s *Session, value interface{}) {
+			s.Buffer.SetScrollbackSize(value.(int))
+		},
+	},
+	OptionMaxLineWraps: {
+		Name:        OptionMaxLineWraps,
+		Kind:        OptionInteger,
+		Description: "Times one line of output may wrap before the rest of it is dropped and counted in get_parser_diagnostics; 0 for no limit",
+		Default:     terminal.DefaultMaxLineWraps,
+		validate:    intRange(0, 1000000),
+		apply: func(s *Session, value interface{}) {
+			s.Buffer.SetMaxLineWraps(value.(int))
+		},
+	},
+	OptionScreenHistory: {
+		Name:        OptionScreenHistory,
+		Kind:        OptionInteger,
+		Description: "Earlier screen versions kept, compressed, so view_screen can show the screen as of a version a wait reported after the application has redrawn; 0 keeps only the current one",
+		Default:     0,
+		validate:    intRange(0, terminal.MaxHistoryFrames),
+		apply: func(s *Session, value interface{}) {
+			s.Buffer.SetHistorySize(value.(int))
+		},
+	},
+	OptionRawBufferSize: {
+		Name:        OptionRawBufferSize,
+		Kind:        OptionInteger,
+		Description: "Bytes of raw output kept for the passthrough format",
+		Default:     1024 * 1024,
+		validate:    intRange(4096, 64*1024*1024),
+		apply: func(s *Session, value interface{}) {
+			s.Buffer.SetRawDataSize(value.(int))
+		},
+	},
+	OptionLogRecords: {
+		Name:        OptionLogRecords,
+		Kind:        OptionInteger,
+		Description: "Recent log records kept for get_session_logs",
+		Default:     defaultLogRecords,
+		validate:    intRange(0, 10000),
+		apply: func(s *Session, value interface{}) {
+			s.logs.setMax(value.(int))
+		},
+	},
+	OptionParserStrictness: {
+		Name:        OptionParserStrictness,
+		Kind:        OptionString,
+		Description: "How unsupported escape sequences are reported: off counts them, log also logs them, mark also draws U+FFFD and flags the session degraded",
+		Default:     string(terminal.StrictnessOff),
+		validate: func(value interface{}) error {
+			_, err := terminal.ParseStrictness(value.(string))
+			return err
+		},
+		apply: func(s *Session, value interface{}) {
+			s.Buffer.SetStrictness(terminal.Strictness(value.(string)))
+		},
+	},
+	OptionLineFeed: {
+		Name:        OptionLineFeed,
+		Kind:        OptionString,
+		Description: "How a bare line feed is drawn: lf only moves down unless the application set newline mode, crlf also returns to the first column, for output that skipped the tty's newline translation",
+		Default:     string(terminal.LineFeedLF),
+		validate: func(value interface{}) error {
+			_, err := terminal.ParseLineFeed(value.(string))
+			return err
+		},
+		apply: func(s *Session, value interface{}) {
+			s.Buffer.SetLineFeed(terminal.LineFeed(value.(string)))
+		},
+	},
+	OptionEncoding: {
+		Name:        OptionEncoding,
+		Kind:        OptionString,
+		Description: "How output bytes outside ASCII are decoded: utf-8, or latin1 for applications writing a single-byte codeset, whose accented characters UTF-8 would draw as U+FFFD; launching with a locale sets it to match",
+		Default:     string(terminal.EncodingUTF8),
+		validate: func(value interface{}) error {
+			_, err := terminal.ParseEncoding(value.(string))
+			return err
+		},
+		apply: func(s *Session, value interface{}) {
+			s.Buffer.SetEncoding(terminal.Encoding(value.(string)))
+		},
+	},
+	OptionRawColors: {
+		Name:        OptionRawColors,
+		Kind:        OptionString,
+		Description: "Colors the raw, raw_canonical and scrollback_raw formats write: original writes each color as the application gave it, 256 turns 24-bit colors into the nearest 256-color entry, 16 turns every color into the nearest of the 16 palette colors",
+		Default:     string(terminal.ColorDepthOriginal),
+		validate: func(value interface{}) error {
+			_, err := terminal.ParseColorDepth(value.(string))
+			return err
+		},
+		apply: func(s *Session, value interface{}) {
+			s.Buffer.SetRenderOptions(terminal.RenderOptions{Colors: terminal.ColorDepth(value.(string))})
+		},
+	},
+	OptionKeyProfile: {
+		Name:        OptionKeyProfile,
+		Kind:        OptionString,
+		Description: "Terminal whose key sequences send_keys uses for key names, for applications that expect another terminal's: xterm, vt100, linux-console or a profile from the keymap file; describe_key shows the result",
+		Default:     KeyProfileXterm,
+		validate: func(value interface{}) error {
+			profiles := KeyProfiles()
+			for _, p := range profiles {
+				if value.(string) == p {
+					return nil
+				}
+			}
+			return fmt.Errorf("must be one of: %s", strings.Join(profiles, ", "))
+		},
+	},
+	OptionUnresponsiveInputMs: {
+		Name:        OptionUnresponsiveInputMs,
+		Kind:        OptionInteger,
+		Description: "Milliseconds input may go without any output in answer before the session is marked unresponsive, once unresponsive_output_ms has also passed",
+		Default:     5000,
+		validate:    intRange(100, 3600000),
+	},
+	OptionUnresponsiveOutputMs: {
+		Name:        OptionUnresponsiveOutputMs,
+		Kind:        OptionInteger,
+		Description: "Milliseconds without output, counted from the last output, a session waiting on input must also go before it is marked unresponsive",
+		Default:     10000,
+		validate:    intRange(100, 3600000),
+	},
+	OptionResourceSampleMs: {
+		Name:        OptionResourceSampleMs,
+		Kind:        OptionInteger,
+		Description: fmt.Sprintf("Milliseconds between samples of the process's CPU use and RSS for get_resource_history, at least %d; 0 turns sampling off. Needs /proc", MinResourceSampleMs),
+		Default:     0,
+		validate: func(value interface{}) error {
+			if n := value.(int); n != 0 && (n < MinResourceSampleMs || n > MaxResourceSampleMs) {
+				return fmt.Errorf("must be 0 or between %d and %d", MinResourceSampleMs, MaxResourceSampleMs)
+			}
+			return nil
+		},
+		apply: func(s *Session, value interface{}) {
+			s.setResourceSampling(value.(int))
+		},
+	},
+	OptionResourceSamples: {
+		Name:        OptionResourceSamples,
+		Kind:        OptionInteger,
+		Description: "Resource samples kept for get_resource_history; the oldest are dropped first",
+		Default:     defaultResourceSamples,
+		validate:    intRange(1, MaxResourceSamples),
+		apply: func(s *Session, value interface{}) {
+			s.setResourceSamples(value.(int))
+		},
+	},
+	OptionColumnMode: {
+		Name:        OptionColumnMode,
+		Kind:        OptionString,
+		Description: "What DECCOLM (CSI ?3h/l) does: track only records the mode, resize also switches the terminal to 132 or 80 columns and clears the screen, for legacy applications that expect it",
+		Default:     ColumnModeTrack,
+		validate: func(value interface{}) error {
+			switch value.(string) {
+			case ColumnModeTrack, ColumnModeResize:
+				return nil
+			}
+			return fmt.Errorf("must be one of: %s, %s", ColumnModeTrack, ColumnModeResize)
+		},
+		apply: func(s *Session, value interface{}) {
+			s.Buffer.SetColumnMode(value.(string) == ColumnModeResize)
+		},
+	},
+	OptionPromptPattern: {
+		Name:        OptionPromptPattern,
+		Kind:        OptionString,
+		Description: "Regular expression is_ready_for_input matches against the cursor's line up to the cursor to recognise the application's prompt; empty means common shell and REPL prompts",
+		Default:     "",
+		validate: func(value interface{}) error {
+			if _, err := regexp.Compile(value.(string)); err != nil {
+				return fmt.Errorf("must be a valid regular expression: %w", err)
+			}
+			return nil
+		},
+	},
+}
+
+func intRange(min, max int) func(interface{}) error {
+	return func(value interface{}) error {
+		if n := value.(int); n < min || n > max {
+			return fmt.Errorf("must be between %d and %d", min, max)
+		}
+		return nil
+	}
+}
+
+// LookupOption returns the definition of a session option
+func LookupOption(name string) (*OptionDef, error) {
+	def, ok := optionDefs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown option %q, valid options: %s", name, strings.Join(OptionNames(), ", "))
+	}
+	return def, nil
+}
+
+// OptionNames returns the names of all session options, sorted
+func OptionNames() []string {
+	names := make([]string, 0, len(optionDefs))
+	for name := range optionDefs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate checks a value against the option's type and constraints
+func (d *OptionDef) Validate(value interface{}) error {
+	switch d.Kind {
+	case OptionString:
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("option %s must be a string", d.Name)
+		}
+	case OptionInteger:
+		if _, ok := value.(int); !ok {
+			return fmt.Errorf("option %s must be an integer", d.Name)
+		}
+	}
+	if d.validate != nil {
+		if err := d.validate(value); err != nil {
+			return fmt.Errorf("option %s %w", d.Name, err)
+		}
+	}
+	return nil
+}
+
+// Option returns the effective value of a session option. Components read
+// per-session settings through here rather than through dedicated fields.
+func (s *Session) Option(name string) interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.optionLocked(name)
+}
+
+// optionLocked returns an option's effective value. The caller must hold s.mu.
+func (s *Session) optionLocked(name string) interface{} {
+	if v, ok := s.options[name]; ok {
+		return v.Value
+	}
+	if def, ok := optionDefs[name]; ok {
+		return def.Default
+	}
+	return nil
+}
+
+// StringOption returns the effective value of a string option
+func (s *Session) StringOption(name string) string {
+	v, _ := s.Option(name).(string)
+	return v
+}
+
+// IntOption returns the effective value of an integer option
+func (s *Session) IntOption(name string) int {
+	v, _ := s.Option(name).(int)
+	return v
+}
+
+// SetOption validates and sets a session option, applying it to the
+// session's components immediately
+func (s *Session) SetOption(name string, value interface{}, source OptionSource) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.setOptionLocked(name, value, source)
+}
+
+func (s *Session) setOptionLocked(name string, value interface{}, source OptionSource) error {
+	def, err := LookupOption(name)
+	if err != nil {
+		return err
+	}
+	if err := def.Validate(value); err != nil {
+		return err
+	}
+
+	if s.options == nil {
+		s.options = make(map[string]OptionValue)
+	}
+	s.options[name] = OptionValue{Value: value, Source: source}
+	if def.apply != nil {
+		def.apply(s, value)
+	}
+	return nil
+}
+
+// SetOptions validates and sets several options at once. Nothing is changed
+// if any of them is invalid.
+func (s *Session) SetOptions(opts map[string]interface{}, source OptionSource) error {
+	if err := ValidateOptions(opts); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, name := range sortedKeys(opts) {
+		if err := s.setOptionLocked(name, opts[name], source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Options returns the effective value and source of every session option
+func (s *Session) Options() map[string]OptionValue {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make(map[string]OptionValue, len(optionDefs))
+	for name, def := range optionDefs {
+		if v, ok := s.options[name]; ok {
+			result[name] = v
+		} else {
+			result[name] = OptionValue{Value: def.Default, Source: SourceDefault}
+		}
+	}
+	return result
+}
+
+// launchOptions returns the options set explicitly on the session, for
+// launching a copy of it
+func (s *Session) launchOptions() map[string]interface{} {
+	if len(s.options) == 0 {
+		return nil
+	}
+	opts := make(map[string]interface{}, len(s.options))
+	for name, v := range s.options {
+		opts[name] = v.Value
+	}
+	return opts
+}
+
+// ValidateOptions checks a set of launch options without applying them
+func ValidateOptions(opts map[string]interface{}) error {
+	for _, name := range sortedKeys(opts) {
+		def, err := LookupOption(name)
+		if err != nil {
+			return err
+		}
+		if err := def.Validate(opts[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
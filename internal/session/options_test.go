@@ -0,0 +1,131 @@
+package session
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+)
+
+func TestOptionDef_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		option  string
+		value   interface{}
+		wantErr string
+	}{
+		{"format", OptionDefaultFormat, "raw", ""},
+		{"empty format", OptionDefaultFormat, "", ""},
+		{"bad format", OptionDefaultFormat, "fancy", "must be one of"},
+		{"format as number", OptionDefaultFormat, 3, "must be a string"},
+		{"scrollback", OptionScrollbackLines, 50, ""},
+		{"no scrollback", OptionScrollbackLines, 0, ""},
+		{"negative scrollback", OptionScrollbackLines, -1, "must be between 0 and 100000"},
+		{"scrollback as string", OptionScrollbackLines, "50", "must be an integer"},
+		{"raw buffer too small", OptionRawBufferSize, 10, "must be between 4096"},
+		{"crlf line feeds", OptionLineFeed, "crlf", ""},
+		{"bad line feed", OptionLineFeed, "cr", "must be one of: lf, crlf"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			def, err := LookupOption(tt.option)
+			if err != nil {
+				t.Fatalf("Lookup failed: %v", err)
+			}
+			err = def.Validate(tt.value)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLookupOption_Unknown(t *testing.T) {
+	_, err := LookupOption("colour")
+	if err == nil {
+		t.Fatal("Expected error for unknown option")
+	}
+	for _, name := range OptionNames() {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("Expected %q listed in %q", name, err.Error())
+		}
+	}
+}
+
+func TestSession_OptionPrecedence(t *testing.T) {
+	utils.InitLogger()
+
+	sess, err := NewSessionWithConfig(SessionConfig{
+		Command: "sh",
+		Args:    []string{"-c", "sleep 10"},
+		Options: map[string]interface{}{OptionScrollbackLines: 50},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer sess.Close()
+
+	opts := sess.Options()
+	if got := opts[OptionScrollbackLines]; got.Value != 50 || got.Source != SourceLaunch {
+		t.Errorf("Expected launch value 50, got %+v", got)
+	}
+	if got := opts[OptionRawBufferSize]; got.Source != SourceDefault || got.Value != optionDefs[OptionRawBufferSize].Default {
+		t.Errorf("Expected default raw buffer size, got %+v", got)
+	}
+	if _, max := sess.Buffer.ScrollbackInfo(); max != 50 {
+		t.Errorf("Launch option not applied to buffer, max lines %d", max)
+	}
+
+	// A runtime change overrides the launch value and reaches the buffer
+	if err := sess.SetOption(OptionScrollbackLines, 20, SourceRuntime); err != nil {
+		t.Fatalf("Failed to set option: %v", err)
+	}
+	if got := sess.Options()[OptionScrollbackLines]; got.Value != 20 || got.Source != SourceRuntime {
+		t.Errorf("Expected runtime value 20, got %+v", got)
+	}
+	if sess.IntOption(OptionScrollbackLines) != 20 {
+		t.Errorf("Accessor returned %d", sess.IntOption(OptionScrollbackLines))
+	}
+	if _, max := sess.Buffer.ScrollbackInfo(); max != 20 {
+		t.Errorf("Runtime option not applied to buffer, max lines %d", max)
+	}
+
+	// Invalid values leave the current value alone
+	if err := sess.SetOption(OptionScrollbackLines, -5, SourceRuntime); err == nil {
+		t.Error("Expected invalid value to be rejected")
+	}
+	err = sess.SetOptions(map[string]interface{}{
+		OptionDefaultFormat:   "raw",
+		OptionScrollbackLines: "lots",
+	}, SourceRuntime)
+	if err == nil {
+		t.Error("Expected invalid batch to be rejected")
+	}
+	if sess.StringOption(OptionDefaultFormat) != "" || sess.IntOption(OptionScrollbackLines) != 20 {
+		t.Error("Rejected batch was partially applied")
+	}
+
+	// Copies of the session keep its explicit options
+	if got := sess.Config().Options; len(got) != 1 || got[OptionScrollbackLines] != 20 {
+		t.Errorf("Expected config to carry options, got %v", got)
+	}
+}
+
+func TestNewSessionWithConfig_InvalidOption(t *testing.T) {
+	utils.InitLogger()
+
+	_, err := NewSessionWithConfig(SessionConfig{
+		Command: "sh",
+		Options: map[string]interface{}{"colour": "red"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "unknown option") {
+		t.Errorf("Expected unknown option error, got %v", err)
+	}
+}
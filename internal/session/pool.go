@@ -0,0 +1,206 @@
+package session
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+)
+
+// poolConfig describes the pre-warmed sessions kept ready for handoff
+type poolConfig struct {
+	size    int
+	command string
+	args    []string
+}
+
+// ConfigurePool keeps size idle sessions running command ready for instant
+// handoff. A size of 0 disables the pool and closes any idle sessions.
+func (m *Manager) ConfigurePool(size int, command string, args []string) {
+	m.mu.Lock()
+	old := m.pool
+	m.pool = nil
+	m.poolConfig = poolConfig{size: size, command: command, args: args}
+	m.persistLocked()
+	m.mu.Unlock()
+
+	for _, session := range old {
+		session.Close()
+	}
+
+	slog.Info("Session pool configured",
+		slog.Int("size", size),
+		slog.String("command", command),
+		slog.Any("args", args),
+	)
+
+	if size > 0 {
+		m.replenishPool()
+	}
+}
+
+// PoolSize returns the number of idle sessions currently ready in the pool
+func (m *Manager) PoolSize() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.pool)
+}
+
+// PoolMatches reports whether a launch request can be served from the pool.
+// Only requests for exactly the pool's command and args without extra
+// environment qualify, since a running process can't take on a new env.
+func (m *Manager) PoolMatches(command string, args []string, env map[string]string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.poolConfig.size == 0 || command != m.poolConfig.command || len(env) > 0 {
+		return false
+	}
+	if len(args) != len(m.poolConfig.args) {
+		return false
+	}
+	for i := range args {
+		if args[i] != m.poolConfig.args[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// AcquirePooledSession hands out an idle session from the pool and refills
+// the pool in the background. The session's screen, scrollback, raw
+// history and output stats are cleared so it looks freshly launched. Pooled sessions are
+// returned like any other, with RemoveSession.
+func (m *Manager) AcquirePooledSession() (*Session, error) {
+	m.mu.Lock()
+
+	if len(m.sessions) >= m.maxSessions {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("maximum number of sessions (%d) reached", m.maxSessions)
+	}
+
+	var session *Session
+	for len(m.pool) > 0 {
+		candidate := m.pool[0]
+		m.pool = m.pool[1:]
+		if candidate.GetInfo().State == "active" && candidate.PTY.IsRunning() {
+			session = candidate
+			break
+		}
+		// The idle process died while waiting; discard it
+		go candidate.Close()
+	}
+
+	if session == nil {
+		m.mu.Unlock()
+		m.replenishPool()
+		return nil, fmt.Errorf("session pool is empty")
+	}
+
+	session.resetForHandoff()
+	m.sessions[session.ID] = session
+	m.trackActivity(session)
+	m.persistLocked()
+	m.mu.Unlock()
+
+	utils.LogSessionEvent(session.ID, "acquired_from_pool",
+		slog.String("command", session.Command),
+	)
+
+	m.replenishPool()
+	return session, nil
+}
+
+// replenishPool starts a background fill if the pool is below its size and
+// no fill is already running
+func (m *Manager) replenishPool() {
+	m.mu.Lock()
+	if m.poolFilling || len(m.pool) >= m.poolConfig.size {
+		m.mu.Unlock()
+		return
+	}
+	m.poolFilling = true
+	m.poolWG.Add(1)
+	m.mu.Unlock()
+
+	go m.fillPool()
+}
+
+func (m *Manager) fillPool() {
+	defer m.poolWG.Done()
+
+	for {
+		m.mu.Lock()
+		cfg := m.poolConfig
+		if len(m.pool) >= cfg.size {
+			m.poolFilling = false
+			m.mu.Unlock()
+			return
+		}
+		m.mu.Unlock()
+
+		// Start the process without holding the lock
+		session, err := NewSessionWithConfig(SessionConfig{
+			Command: cfg.command,
+			Args:    cfg.args,
+			Clock:   m.clock,
+		})
+		if err != nil {
+			utils.LogError(err, "Failed to create pooled session",
+				slog.String("command", cfg.command),
+			)
+			m.mu.Lock()
+			m.poolFilling = false
+			m.mu.Unlock()
+			return
+		}
+
+		m.mu.Lock()
+		// The pool may have been reconfigured or shut down meanwhile
+		if m.poolConfig.command != cfg.command || len(m.pool) >= m.poolConfig.size {
+			m.mu.Unlock()
+			session.Close()
+			continue
+		}
+		m.pool = append(m.pool, session)
+		m.persistLocked()
+		m.mu.Unlock()
+
+		slog.Debug("Pooled session ready", slog.String("session_id", session.ID))
+	}
+}
+
+// shutdownPool disables the pool, waits for any fill to finish and closes
+// the idle sessions
+func (m *Manager) shutdownPool() {
+	m.mu.Lock()
+	m.poolConfig = poolConfig{}
+	m.mu.Unlock()
+
+	m.poolWG.Wait()
+
+	m.mu.Lock()
+	idle := m.pool
+	m.pool = nil
+	m.persistLocked()
+	m.mu.Unlock()
+
+	for _, session := range idle {
+		session.Close()
+	}
+}
+
+// resetForHandoff clears everything the idle process produced before the
+// session is handed out, its output counts included
+func (s *Session) resetForHandoff() {
+	// Observers may take s.mu, so this waits for them without holding it
+	s.resetOutputStats()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Buffer.Reset()
+	now := s.clock.Now()
+	s.Created = now
+	s.LastActive = now
+}
@@ -0,0 +1,113 @@
+package session
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+)
+
+// waitForPool waits until the pool holds n idle sessions
+func waitForPool(t *testing.T, m *Manager, n int) {
+	deadline := time.Now().Add(3 * time.Second)
+	for m.PoolSize() < n && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if m.PoolSize() < n {
+		t.Fatalf("Pool did not fill: have %d, want %d", m.PoolSize(), n)
+	}
+}
+
+func TestManager_PooledSessionReusesProcess(t *testing.T) {
+	utils.InitLogger()
+	manager := NewManager()
+	defer manager.Shutdown()
+
+	manager.ConfigurePool(2, "cat", nil)
+	waitForPool(t, manager, 2)
+
+	// Record the PIDs of the idle processes and give them some output
+	pooledPIDs := make(map[int]bool)
+	manager.mu.RLock()
+	for _, s := range manager.pool {
+		pooledPIDs[s.PTY.PID()] = true
+		s.Buffer.Write([]byte("leftover output\r\n"))
+	}
+	manager.mu.RUnlock()
+
+	if !manager.PoolMatches("cat", nil, nil) {
+		t.Fatal("Pool should match its own command")
+	}
+	if manager.PoolMatches("cat", nil, map[string]string{"A": "1"}) {
+		t.Error("Pool must not serve requests with extra env")
+	}
+
+	sess, err := manager.AcquirePooledSession()
+	if err != nil {
+		t.Fatalf("Failed to acquire pooled session: %v", err)
+	}
+
+	if !pooledPIDs[sess.PTY.PID()] {
+		t.Errorf("Acquired session PID %d was not one of the pooled processes", sess.PTY.PID())
+	}
+
+	content, _ := sess.GetScreen(context.Background(), "plain")
+	if strings.Contains(content, "leftover") || len(sess.Buffer.GetRawData()) != 0 {
+		t.Errorf("Pooled session buffer not cleared before handoff: %q", content)
+	}
+
+	if _, err := manager.GetSession(sess.ID); err != nil {
+		t.Errorf("Acquired session should be managed: %v", err)
+	}
+
+	// The pool refills in the background
+	waitForPool(t, manager, 2)
+
+	// Returning a pooled session is a normal removal
+	if err := manager.RemoveSession(sess.ID); err != nil {
+		t.Errorf("Failed to remove pooled session: %v", err)
+	}
+}
+
+func TestManager_PooledSessionResetsOutputStats(t *testing.T) {
+	utils.InitLogger()
+	manager := NewManager()
+	defer manager.Shutdown()
+
+	manager.ConfigurePool(1, "sh", []string{"-c", "echo warming up; exec cat"})
+	waitForPool(t, manager, 1)
+
+	// Wait for the warm-up output to be counted
+	manager.mu.RLock()
+	pooled := manager.pool[0]
+	manager.mu.RUnlock()
+	deadline := time.Now().Add(3 * time.Second)
+	for pooled.OutputStats().Writes == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if pooled.OutputStats().Writes == 0 {
+		t.Fatal("Pooled session's warm-up output was never counted")
+	}
+
+	sess, err := manager.AcquirePooledSession()
+	if err != nil {
+		t.Fatalf("Failed to acquire pooled session: %v", err)
+	}
+	if stats := sess.OutputStats(); stats != (OutputStats{}) {
+		t.Errorf("Expected output stats cleared at handoff, got %+v", stats)
+	}
+
+	// Output after the handoff is counted as usual
+	if _, err := sess.SendKeys(context.Background(), "hi\n"); err != nil {
+		t.Fatalf("Failed to send keys: %v", err)
+	}
+	deadline = time.Now().Add(3 * time.Second)
+	for sess.OutputStats().Writes == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if sess.OutputStats().Writes == 0 {
+		t.Error("Expected output after the handoff to be counted")
+	}
+}
@@ -0,0 +1,241 @@
+package session
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/clock"
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+)
+
+// Limits of the resource_sample_ms and resource_samples options
+const (
+	MinResourceSampleMs    = 100
+	MaxResourceSampleMs    = 3600000
+	defaultResourceSamples = 600
+	MaxResourceSamples     = 100000
+)
+
+// ResourceSample is the session's process's resource use at one moment
+type ResourceSample struct {
+	Time       time.Time `json:"time"`
+	PID        int       `json:"pid"`
+	CPUPercent float64   `json:"cpu_percent"` // Of one CPU, since the sample before
+	RSSBytes   int64     `json:"rss_bytes"`
+}
+
+// ResourceHistory is what resource sampling recorded, oldest sample first
+type ResourceHistory struct {
+	Enabled        bool             `json:"enabled"`
+	Supported      bool             `json:"supported"` // False on platforms without /proc, where nothing is sampled
+	IntervalMs     int              `json:"interval_ms"`
+	MaxSamples     int              `json:"max_samples"`
+	Samples        []ResourceSample `json:"samples"`
+	Dropped        int              `json:"dropped"` // Older samples let go to keep max_samples
+	MaxRSSBytes    int64            `json:"max_rss_bytes"`
+	MeanCPUPercent float64          `json:"mean_cpu_percent"`
+}
+
+// resourceSampler records the CPU and memory use of a session's process
+// every interval into a bounded ring. It keeps going across restarts,
+// following the new process, until sampling is turned off or the session
+// closes.
+type resourceSampler struct {
+	mu       sync.Mutex
+	samples  []ResourceSample
+	max      int
+	dropped  int
+	interval time.Duration // 0 when sampling is off
+	stop     chan struct{} // Closed to end the running sampler, if any
+	done     chan struct{}
+	clock    clock.Clock
+}
+
+// setResourceSampling turns sampling on every intervalMs, or off for 0.
+// The caller must hold s.mu.
+func (s *Session) setResourceSampling(intervalMs int) {
+	if s.resources == nil {
+		if intervalMs == 0 {
+			return
+		}
+		s.resources = &resourceSampler{max: defaultResourceSamples, clock: s.clock}
+		// Read directly, as optionLocked would make optionDefs refer to itself
+		if v, ok := s.options[OptionResourceSamples]; ok {
+			s.resources.max = v.Value.(int)
+		}
+	}
+	// The sampler takes s.mu, so it is left to end on its own rather than
+	// waited for
+	r := s.resources
+	r.halt()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interval = time.Duration(intervalMs) * time.Millisecond
+	// A session still being created has no process yet; start begins
+	// sampling once it has one
+	if s.closed || s.Imported != "" || s.PID == 0 {
+		return
+	}
+	r.startLocked(s)
+}
+
+// startResources begins sampling a newly started process if sampling is
+// on and not already running, as it is across a restart
+func (s *Session) startResources() {
+	if s.resources == nil {
+		return
+	}
+	r := s.resources
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.startLocked(s)
+}
+
+// startLocked starts the sampler goroutine. The caller must hold r.mu.
+func (r *resourceSampler) startLocked(s *Session) {
+	if r.interval == 0 || r.stop != nil || !terminal.ResourceUsageSupported {
+		return
+	}
+	r.stop, r.done = make(chan struct{}), make(chan struct{})
+	go r.run(s, r.interval, r.stop, r.done)
+}
+
+// setResourceSamples changes how many samples are kept. The caller must
+// hold s.mu.
+func (s *Session) setResourceSamples(n int) {
+	if s.resources != nil {
+		s.resources.setMax(n)
+	}
+}
+
+// stopResources ends sampling when the session closes, keeping what was
+// recorded
+func (s *Session) stopResources() {
+	s.mu.RLock()
+	r := s.resources
+	s.mu.RUnlock()
+	if r != nil {
+		<-r.halt()
+	}
+}
+
+// halt stops the running sampler, if any, and returns a channel closed
+// once it has ended
+func (r *resourceSampler) halt() <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stop == nil {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+	close(r.stop)
+	done := r.done
+	r.stop, r.done = nil, nil
+	return done
+}
+
+func (r *resourceSampler) run(s *Session, interval time.Duration, stop, done chan struct{}) {
+	defer close(done)
+	ticker := r.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	// CPU use is worked out between samples, so the first reading of each
+	// process is only a baseline
+	var last terminal.ResourceUsage
+	var lastPID int
+	var lastTime time.Time
+	for {
+		if pid := s.samplePID(); pid != 0 {
+			// An error means the process went away since the check
+			if usage, err := terminal.ReadResourceUsage(pid); err == nil {
+				now := r.clock.Now()
+				if pid == lastPID {
+					cpu := 0.0
+					if elapsed := now.Sub(lastTime).Seconds(); elapsed > 0 {
+						cpu = max(usage.CPUTime-last.CPUTime, 0) / elapsed * 100
+					}
+					r.add(ResourceSample{Time: now, PID: pid, CPUPercent: cpu, RSSBytes: usage.RSSBytes})
+				}
+				last, lastPID, lastTime = usage, pid, now
+			}
+		}
+
+		select {
+		case <-ticker.C():
+		case <-stop:
+			return
+		}
+	}
+}
+
+// samplePID returns the PID of the session's process while it runs, or 0
+func (s *Session) samplePID() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.State != StateActive {
+		return 0
+	}
+	return s.PID
+}
+
+func (r *resourceSampler) add(sample ResourceSample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples = append(r.samples, sample)
+	r.trim()
+}
+
+func (r *resourceSampler) setMax(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.max = n
+	r.trim()
+}
+
+// trim drops the oldest samples over max. The caller must hold r.mu.
+func (r *resourceSampler) trim() {
+	if over := len(r.samples) - r.max; over > 0 {
+		r.samples = append([]ResourceSample(nil), r.samples[over:]...)
+		r.dropped += over
+	}
+}
+
+// sampledResources reports whether sampling was ever turned on
+func (s *Session) sampledResources() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.resources != nil
+}
+
+// ResourceHistory returns the session's resource samples, with the
+// largest RSS and the mean CPU use among them
+func (s *Session) ResourceHistory() ResourceHistory {
+	s.mu.RLock()
+	r := s.resources
+	h := ResourceHistory{
+		Supported:  terminal.ResourceUsageSupported,
+		MaxSamples: s.optionLocked(OptionResourceSamples).(int),
+		IntervalMs: s.optionLocked(OptionResourceSampleMs).(int),
+		Samples:    []ResourceSample{},
+	}
+	s.mu.RUnlock()
+	h.Enabled = h.IntervalMs > 0
+	if r == nil {
+		return h
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h.Samples = append(h.Samples, r.samples...)
+	h.Dropped = r.dropped
+	var cpu float64
+	for _, sample := range h.Samples {
+		h.MaxRSSBytes = max(h.MaxRSSBytes, sample.RSSBytes)
+		cpu += sample.CPUPercent
+	}
+	if len(h.Samples) > 0 {
+		h.MeanCPUPercent = cpu / float64(len(h.Samples))
+	}
+	return h
+}
@@ -0,0 +1,70 @@
+//go:build !windows
+
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+)
+
+func TestSession_ResourceSampling(t *testing.T) {
+	if !terminal.ResourceUsageSupported {
+		t.Skip("resource sampling needs /proc")
+	}
+	utils.InitLogger()
+
+	sess, err := NewSession("sh", []string{"-c", "sleep 10"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer sess.Close()
+
+	if h := sess.ResourceHistory(); h.Enabled || len(h.Samples) != 0 {
+		t.Errorf("Expected sampling to be off by default, got %+v", h)
+	}
+	if err := sess.SetOption(OptionResourceSampleMs, 50, SourceRuntime); err == nil {
+		t.Error("Expected an interval under the minimum to be refused")
+	}
+	if err := sess.SetOption(OptionResourceSampleMs, MinResourceSampleMs, SourceRuntime); err != nil {
+		t.Fatalf("Failed to turn sampling on: %v", err)
+	}
+	time.Sleep(550 * time.Millisecond)
+
+	// A restart is followed to the new process
+	firstPID := sess.PID
+	if err := sess.Restart(false); err != nil {
+		t.Fatalf("Restart failed: %v", err)
+	}
+	time.Sleep(550 * time.Millisecond)
+	pids := map[int]bool{}
+	for _, sample := range sess.ResourceHistory().Samples {
+		pids[sample.PID] = true
+	}
+	if !pids[firstPID] || !pids[sess.PID] || len(pids) != 2 {
+		t.Errorf("Expected samples of %d and then %d, got %v", firstPID, sess.PID, pids)
+	}
+
+	// Fewer samples kept drops the oldest at once
+	before := sess.ResourceHistory()
+	if err := sess.SetOption(OptionResourceSamples, 2, SourceRuntime); err != nil {
+		t.Fatalf("Failed to set resource_samples: %v", err)
+	}
+	after := sess.ResourceHistory()
+	if len(after.Samples) != 2 || after.Dropped < len(before.Samples)-2 {
+		t.Errorf("Expected 2 samples kept and the rest dropped, got %+v", after)
+	}
+	if last := before.Samples[len(before.Samples)-1]; after.Samples[1].Time.Before(last.Time) {
+		t.Errorf("Expected the newest samples to be kept, got %+v", after.Samples)
+	}
+
+	// Closing ends the sampler and keeps the samples
+	sess.Close()
+	kept := sess.ResourceHistory().Samples
+	time.Sleep(300 * time.Millisecond)
+	if got := sess.ResourceHistory().Samples; len(got) != len(kept) || !got[len(got)-1].Time.Equal(kept[len(kept)-1].Time) {
+		t.Errorf("Expected no samples after close, had %d, now %d", len(kept), len(got))
+	}
+}
@@ -0,0 +1,169 @@
+package session
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// responsiveness tracks whether the process answers its input. A process
+// is unresponsive once input has gone unanswered for the
+// unresponsive_input_ms option and it hasn't written anything for the
+// unresponsive_output_ms option; its next output makes it responsive
+// again. Output from the terminal's own echo counts as an answer.
+type responsiveness struct {
+	mu           sync.Mutex
+	pendingSince time.Time     // Oldest input sent since the last output; zero when none is waiting
+	unresponsive bool          // Set by watchResponse, cleared by the next output
+	since        time.Time     // When the session was marked unresponsive
+	output       chan struct{} // Closed on the next output, if anyone is listening
+}
+
+// reset forgets pending input, for a process that was just started
+func (r *responsiveness) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pendingSince = time.Time{}
+	r.unresponsive = false
+}
+
+// outputArrived returns a channel closed when the process next writes
+// output. Unlike the buffer's Changed, output that doesn't change the
+// screen counts too.
+func (s *Session) outputArrived() <-chan struct{} {
+	r := &s.responsive
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.output == nil {
+		r.output = make(chan struct{})
+	}
+	return r.output
+}
+
+// noteOutput records that the process wrote output, which answers any
+// input waiting
+func (s *Session) noteOutput(now time.Time) {
+	r := &s.responsive
+	r.mu.Lock()
+	r.pendingSince = time.Time{}
+	recovered, stalled := r.unresponsive, now.Sub(r.since)
+	r.unresponsive = false
+	if r.output != nil {
+		close(r.output)
+		r.output = nil
+	}
+	r.mu.Unlock()
+
+	if recovered {
+		s.recordEvent(EventResponsive, map[string]interface{}{
+			"unresponsive_ms": stalled.Milliseconds(),
+		})
+		slog.Info("Session responsive again",
+			slog.String("session_id", s.ID),
+			slog.Duration("unresponsive", stalled),
+		)
+	}
+}
+
+// noteInput records that input was sent and, unless earlier input is
+// already waiting, watches for an answer. The caller must hold s.mu.
+func (s *Session) noteInput() {
+	r := &s.responsive
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.pendingSince.IsZero() {
+		return
+	}
+	r.pendingSince = s.clock.Now()
+	go s.watchResponse(s.ctx, r.pendingSince)
+}
+
+// watchResponse waits for output answering the input sent at sent, and
+// marks the session unresponsive if none comes in time
+func (s *Session) watchResponse(ctx context.Context, sent time.Time) {
+	for {
+		// Listen before checking, so output in between isn't missed
+		output := s.outputArrived()
+		s.responsive.mu.Lock()
+		answered := !s.responsive.pendingSince.Equal(sent)
+		s.responsive.mu.Unlock()
+		if answered {
+			return
+		}
+
+		// The options are read each time round, so a change applies to
+		// input already waiting
+		now := s.clock.Now()
+		deadline := sent.Add(time.Duration(s.IntOption(OptionUnresponsiveInputMs)) * time.Millisecond)
+		if quiet := s.LastOutput().Add(time.Duration(s.IntOption(OptionUnresponsiveOutputMs)) * time.Millisecond); quiet.After(deadline) {
+			deadline = quiet
+		}
+		if !now.Before(deadline) {
+			s.markUnresponsive(sent, now)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-output:
+		case <-s.clock.After(deadline.Sub(now)):
+		}
+	}
+}
+
+// markUnresponsive flags the session, unless output answered the input
+// sent at sent in the meantime
+func (s *Session) markUnresponsive(sent, now time.Time) {
+	r := &s.responsive
+	r.mu.Lock()
+	if !r.pendingSince.Equal(sent) || r.unresponsive {
+		r.mu.Unlock()
+		return
+	}
+	r.unresponsive = true
+	r.since = now
+	r.mu.Unlock()
+
+	quiet := now.Sub(s.LastOutput())
+	s.recordEvent(EventUnresponsive, map[string]interface{}{
+		"input_waiting_ms": now.Sub(sent).Milliseconds(),
+		"no_output_ms":     quiet.Milliseconds(),
+	})
+	slog.Warn("Session unresponsive",
+		slog.String("session_id", s.ID),
+		slog.Duration("input_waiting", now.Sub(sent)),
+		slog.Duration("no_output", quiet),
+	)
+}
+
+// DefaultProbeKeys is what Probe is usually given: a Device Attributes
+// query, which shells and most applications drop or echo without acting
+// on it
+const DefaultProbeKeys = "\x1b[c"
+
+// Probe sends keys and waits until ctx is done for the process to write
+// any output in answer, returning how long it took or false when none
+// came. The answer also clears the unresponsive mark, as any output does.
+func (s *Session) Probe(ctx context.Context, keys string) (time.Duration, bool, error) {
+	output := s.outputArrived()
+	start := s.clock.Now()
+	if _, err := s.SendKeys(ctx, keys); err != nil {
+		return 0, false, err
+	}
+	select {
+	case <-output:
+		return s.clock.Now().Sub(start), true, nil
+	case <-ctx.Done():
+		return 0, false, nil
+	}
+}
+
+// Unresponsive reports whether input has gone unanswered past the
+// session's thresholds and the process has written nothing since
+func (s *Session) Unresponsive() bool {
+	s.responsive.mu.Lock()
+	defer s.responsive.mu.Unlock()
+	return s.responsive.unresponsive
+}
@@ -0,0 +1,85 @@
+//go:build !windows
+
+package session
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/testutil"
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+)
+
+func TestSession_Unresponsive(t *testing.T) {
+	utils.InitLogger()
+	manager := NewManager()
+	defer manager.Shutdown()
+	clk := testutil.NewFakeClock(time.Now())
+	manager.SetClock(clk)
+
+	// The child answers only once it has read two keys
+	sess, err := manager.CreateSession("sh", []string{"-c", "stty raw -echo; echo ready; head -c 2 >/dev/null; echo done; exec sleep 30"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	waitScreen := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			screen, _ := sess.GetScreen(context.Background(), "plain")
+			if strings.Contains(screen, want) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %q on screen, got %q", want, screen)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	waitScreen("ready")
+
+	if _, err := sess.SendKeys(context.Background(), "x"); err != nil {
+		t.Fatalf("Failed to send keys: %v", err)
+	}
+	for clk.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Past the input threshold but not the output one
+	clk.Advance(6 * time.Second)
+	time.Sleep(50 * time.Millisecond)
+	if sess.Unresponsive() {
+		t.Fatal("Expected recent output to hold off the mark")
+	}
+	clk.Advance(5 * time.Second)
+	deadline := time.Now().Add(2 * time.Second)
+	for !sess.Unresponsive() {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the session to be marked unresponsive")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if info := sess.GetInfo(); !info.Unresponsive {
+		t.Errorf("Expected GetInfo to report it, got %+v", info)
+	}
+
+	// The answer to the second key clears the mark
+	elapsed, answered, err := sess.Probe(context.Background(), "y")
+	if err != nil || !answered || elapsed < 0 {
+		t.Fatalf("Expected the probe to be answered, got %v %v %v", elapsed, answered, err)
+	}
+	waitScreen("done")
+	if sess.Unresponsive() {
+		t.Error("Expected output to clear the mark")
+	}
+
+	var types []string
+	for _, e := range sess.Events(0, 0).Events {
+		types = append(types, e.Type)
+	}
+	if got := strings.Join(types, " "); !strings.Contains(got, "unresponsive responsive") {
+		t.Errorf("Expected unresponsive then responsive events, got %q", got)
+	}
+}
@@ -1,11 +1,19 @@
 package session
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"os"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/bioharz/mcp-terminal-tester/internal/clock"
 	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
 	"github.com/bioharz/mcp-terminal-tester/internal/utils"
 	"github.com/google/uuid"
@@ -20,33 +28,181 @@ const (
 )
 
 type Session struct {
-	ID         string
-	Command    string
-	Args       []string
-	Env        map[string]string
-	PTY        *terminal.PTYWrapper
-	Buffer     *terminal.ScreenBuffer
-	Created    time.Time
-	LastActive time.Time
-	State      SessionState
-	mu         sync.RWMutex
-	done       chan struct{}
+	ID             string
+	Command        string
+	Args           []string
+	Env            map[string]string
+	Group          string
+	Label          string
+	Shell          bool   // Launched as an interactive shell, so probe_shell may type into it
+	Imported       string // Capture file the session was parsed from; such a session has no PTY. See import.go
+	Locale         string // Locale set at launch, already expanded into Env; see LocaleEnv
+	Timezone       string // TZ set at launch, already in Env
+	SeparateStderr bool   // The process's stderr is kept apart from the terminal; see stderr.go
+	TmpDir         string // Directory made for {{session_tmpdir}}, removed on close
+	FreePort       int    // Port reserved for {{port_free}} until close
+	PID            int    // Child process ID, updated on restart
+	Cwd            string // Working directory the process was started in
+	Restarts       int    // Number of times the session has been restarted
+	PTY            *terminal.PTYWrapper
+	Buffer         *terminal.ScreenBuffer
+	Created        time.Time
+	LastActive     time.Time
+	State          SessionState
+	options        map[string]OptionValue // Options set at launch or runtime; see options.go
+	logs           *logRing               // Recent log records about this session; see logs.go
+	events         *eventRing             // Lifecycle and terminal events; see events.go
+	inputs         *inputRing             // Recent writes to the process; see inputs.go
+	mu             sync.RWMutex
+	lifecycle      sync.Mutex // Serializes Restart and close; never taken by readLoop
+	sizeMu         sync.Mutex // Keeps the PTY's size in step with the buffer's between Resize and readLoop
+	closed         bool
+	// ctx is the session's lifetime: cancelled with ErrSessionClosed or
+	// ErrSessionRestarted. Replaced under lifecycle and mu on restart.
+	ctx        context.Context
+	cancel     context.CancelCauseFunc
 	readLoopWG sync.WaitGroup
+	gate       *opGate          // Orders tool operations; see gate.go
+	capture    *frameCapture    // Running frame capture, if any; see capture.go
+	triggers   *triggerSet      // Output triggers, once one is added; see triggers.go
+	resources  *resourceSampler // CPU and memory samples, once sampling is turned on; see resources.go
+	observers  *observerSet     // Told about each write of output; see observers.go
+	stats      outputStats      // Output counters, the first observer
+	lastOutput atomic.Int64     // When the process last wrote output, in Unix nanoseconds
+	responsive responsiveness   // Whether input gets answered; see responsive.go
+	stderr     *stderrLog       // The process's stderr, with SeparateStderr
+	expansion  *envExpansion    // Env placeholders filled in at launch; see template.go
+	clock      clock.Clock
 }
 
+// Causes of a session context's cancellation, returned by operations that
+// were in flight
+var (
+	ErrSessionClosed    = errors.New("session closed")
+	ErrSessionRestarted = errors.New("session restarted")
+)
+
 type SessionInfo struct {
-	ID         string            `json:"id"`
-	Command    string            `json:"command"`
-	Args       []string          `json:"args"`
-	Created    time.Time         `json:"created"`
-	LastActive time.Time         `json:"last_active"`
-	State      string            `json:"state"`
+	ID             string    `json:"id"`
+	Command        string    `json:"command"`
+	Args           []string  `json:"args"`
+	PID            int       `json:"pid"`
+	Created        time.Time `json:"created"`
+	LastActive     time.Time `json:"last_active"`
+	State          string    `json:"state"`
+	Group          string    `json:"group,omitempty"`
+	Label          string    `json:"label,omitempty"`
+	Shell          bool      `json:"shell,omitempty"`
+	Imported       string    `json:"imported,omitempty"`        // Capture file of a session made by import_capture
+	Locale         string    `json:"locale,omitempty"`          // Locale the session was launched under
+	Timezone       string    `json:"timezone,omitempty"`        // TZ the session was launched with
+	SeparateStderr bool      `json:"separate_stderr,omitempty"` // stderr is read with get_stderr, not on the screen
+	TmpDir         string    `json:"tmpdir,omitempty"`          // Directory made for {{session_tmpdir}}
+	FreePort       int       `json:"free_port,omitempty"`       // Port reserved for {{port_free}}
+	Degraded       bool      `json:"degraded"`                  // Unsupported output arrived with parser_strictness "mark"
+	Unresponsive   bool      `json:"unresponsive"`              // Input went unanswered; see responsive.go
+}
+
+// SessionDetails is the full session record returned by get_session_info.
+// Only environment variable names are included, never their values.
+type SessionDetails struct {
+	SessionInfo
+	EnvKeys       []string            `json:"env_keys"`
+	Cwd           string              `json:"cwd"`
+	Width         int                 `json:"width"`
+	Height        int                 `json:"height"`
+	Scrollback    ScrollbackInfo      `json:"scrollback"`
+	RestartCount  int                 `json:"restart_count"`
+	DefaultFormat string              `json:"default_format"` // Effective default, filled in by the handler
+	Exited        bool                `json:"exited"`
+	ExitCode      *int                `json:"exit_code"`             // nil while the process is running
+	ExitStatus    string              `json:"exit_status,omitempty"` // e.g. "exit status 1" or "signal: killed"
+	Unhandled     int64               `json:"unhandled_sequences"`   // Escape sequences the parser ignored; see get_parser_diagnostics
+	LastEventSeq  uint64              `json:"last_event_seq"`        // Newest event; see get_session_events
+	InputModes    terminal.InputModes `json:"input_modes"`           // Key modes the application set, which send_keys follows
+	Encoding      terminal.Encoding   `json:"encoding"`              // How output outside ASCII is decoded
+	KeyProfile    string              `json:"key_profile"`           // Key sequences send_keys uses; see the key_profile option
+	Output        OutputStats         `json:"output"`                // Output counted so far
+}
+
+// ScrollbackInfo describes a session's scrollback buffer
+type ScrollbackInfo struct {
+	Lines    int `json:"lines"`     // Lines currently held
+	MaxLines int `json:"max_lines"` // Configured capacity
+}
+
+// SessionConfig describes how a session is launched
+type SessionConfig struct {
+	Command        string                 `json:"command"`
+	Args           []string               `json:"args"`
+	Env            map[string]string      `json:"env"`
+	Group          string                 `json:"group,omitempty"`           // Optional group the session belongs to
+	Label          string                 `json:"label,omitempty"`           // Optional human-readable label
+	Shell          bool                   `json:"shell,omitempty"`           // The command is an interactive POSIX shell
+	Locale         string                 `json:"locale,omitempty"`          // Sets LANG, LC_ALL and LC_CTYPE, and the encoding option unless Options has it
+	Timezone       string                 `json:"timezone,omitempty"`        // Sets TZ
+	SeparateStderr bool                   `json:"separate_stderr,omitempty"` // Sends stderr to a pipe of its own instead of the terminal
+	Options        map[string]interface{} `json:"options,omitempty"`         // Session options set at launch
+	Width          int                    `json:"width"`                     // Initial columns, defaults to 80
+	Height         int                    `json:"height"`                    // Initial rows, defaults to 24
+	Clock          clock.Clock            `json:"-"`                         // Source of the session's timestamps; nil for the real clock
 }
 
 func NewSession(command string, args []string, env map[string]string) (*Session, error) {
+	return NewSessionWithConfig(SessionConfig{
+		Command: command,
+		Args:    args,
+		Env:     env,
+	})
+}
+
+// NewSessionWithConfig creates and starts a session from a launch configuration
+func NewSessionWithConfig(cfg SessionConfig) (*Session, error) {
+	command, args, env := cfg.Command, cfg.Args, cfg.Env
+	if cfg.Locale != "" || cfg.Timezone != "" {
+		env = make(map[string]string, len(cfg.Env)+4)
+		for k, v := range cfg.Env {
+			env[k] = v
+		}
+		for k, v := range LocaleEnv(cfg.Locale) {
+			env[k] = v
+		}
+		if cfg.Timezone != "" {
+			env["TZ"] = cfg.Timezone
+		}
+	}
+	options := cfg.Options
+	if _, ok := options[OptionEncoding]; cfg.Locale != "" && !ok {
+		options = make(map[string]interface{}, len(cfg.Options)+1)
+		for k, v := range cfg.Options {
+			options[k] = v
+		}
+		options[OptionEncoding] = string(terminal.EncodingForLocale(cfg.Locale))
+	}
+	width, height := cfg.Width, cfg.Height
+	if width <= 0 {
+		width = 80
+	}
+	if height <= 0 {
+		height = 24
+	}
+
+	// Reject bad options before anything is started
+	if err := ValidateOptions(options); err != nil {
+		return nil, err
+	}
+
 	// Generate unique session ID
 	id := uuid.New().String()
 
+	// The ID and the rest of the placeholders are filled in before the
+	// process sees its environment
+	expansion, err := expandEnvTemplates(env, id)
+	if err != nil {
+		return nil, err
+	}
+	env = expansion.env
+
 	slog.Debug("Creating new session",
 		slog.String("session_id", id),
 		slog.String("command", command),
@@ -57,34 +213,76 @@ func NewSession(command string, args []string, env map[string]string) (*Session,
 	pty, err := terminal.NewPTYWrapper(command, args, env)
 	if err != nil {
 		utils.LogError(err, "Failed to create PTY", slog.String("session_id", id))
+		expansion.release()
 		return nil, err
 	}
-	
+
 	// Set session ID for logging
 	pty.SetSessionID(id)
+	pty.SetSize(uint16(height), uint16(width))
 
 	// Create screen buffer
-	buffer := terminal.NewScreenBuffer(80, 24)
+	buffer := terminal.NewScreenBuffer(width, height)
+	buffer.SetSessionID(id)
+
+	// The child inherits the server's working directory
+	cwd, _ := os.Getwd()
 
+	clk := clock.Or(cfg.Clock)
 	session := &Session{
-		ID:         id,
-		Command:    command,
-		Args:       args,
-		Env:        env,
-		PTY:        pty,
-		Buffer:     buffer,
-		Created:    time.Now(),
-		LastActive: time.Now(),
-		State:      StateActive,
-		done:       make(chan struct{}),
+		ID:             id,
+		Command:        command,
+		Args:           args,
+		Env:            env,
+		Group:          cfg.Group,
+		Label:          cfg.Label,
+		Shell:          cfg.Shell,
+		Locale:         cfg.Locale,
+		Timezone:       cfg.Timezone,
+		SeparateStderr: cfg.SeparateStderr,
+		TmpDir:         expansion.tmpDir,
+		FreePort:       expansion.port,
+		Cwd:            cwd,
+		PTY:            pty,
+		Buffer:         buffer,
+		Created:        clk.Now(),
+		LastActive:     clk.Now(),
+		State:          StateActive,
+		logs:           newLogRing(defaultLogRecords),
+		events:         newEventRing(defaultEventRecords, clk),
+		inputs:         newInputRing(defaultInputRecords, clk),
+		gate:           newOpGate(),
+		expansion:      expansion,
+		clock:          clk,
 	}
+	if cfg.SeparateStderr {
+		session.stderr = &stderrLog{}
+	}
+	session.ctx, session.cancel = context.WithCancelCause(context.Background())
+	if err := session.SetOptions(options, SourceLaunch); err != nil {
+		expansion.release()
+		return nil, err
+	}
+	session.startOutputStats()
+	registerLogTarget(session)
 
 	// Start PTY and connect it to the buffer
 	if err := session.start(); err != nil {
 		utils.LogError(err, "Failed to start session", slog.String("session_id", id))
+		session.cancel(ErrSessionClosed)
+		session.observers.close()
+		unregisterLogTarget(session)
+		expansion.release()
 		return nil, err
 	}
 
+	session.recordEvent(EventCreated, map[string]interface{}{
+		"command": command,
+		"pid":     session.PID,
+		"width":   width,
+		"height":  height,
+	})
+
 	slog.Info("Session created successfully",
 		slog.String("session_id", id),
 		slog.String("command", command),
@@ -94,21 +292,39 @@ func NewSession(command string, args []string, env map[string]string) (*Session,
 }
 
 func (s *Session) start() error {
+	if s.SeparateStderr {
+		if err := s.PTY.SeparateStderr(); err != nil {
+			return err
+		}
+	}
+
 	// Start the PTY process
 	if err := s.PTY.Start(); err != nil {
 		return err
 	}
+	s.PID = s.PTY.PID()
+	s.startResources()
+	if stderr := s.PTY.Stderr(); stderr != nil {
+		go s.copyStderr(stderr)
+	}
+	s.lastOutput.Store(s.clock.Now().UnixNano())
+	s.responsive.reset()
 
 	slog.Debug("PTY started", slog.String("session_id", s.ID))
 
 	// Start goroutine to read from PTY and update buffer
 	s.readLoopWG.Add(1)
-	go s.readLoop()
+	go s.readLoop(s.ctx)
 
 	return nil
 }
 
-func (s *Session) readLoop() {
+// readLoop copies the process's output into the screen buffer until the
+// PTY fails. A failure after ctx is cancelled is the PTY being stopped on
+// purpose and ends the loop quietly; otherwise the process has exited.
+// Close and Restart wait for the loop, so once they return nothing touches
+// the buffer any more.
+func (s *Session) readLoop(ctx context.Context) {
 	defer s.readLoopWG.Done()
 	slog.Debug("Starting read loop", slog.String("session_id", s.ID))
 	
@@ -119,162 +335,390 @@ func (s *Session) readLoop() {
 				slog.String("session_id", s.ID),
 				slog.Any("panic", r),
 			)
-			s.mu.Lock()
-			s.State = StateError
-			s.mu.Unlock()
+			s.markExited()
 		}
 	}()
 	
-	// Create channels for coordinating PTY read operations
-	dataCh := make(chan []byte, 1)
-	errorCh := make(chan error, 1)
-	
-	// Start PTY reader goroutine
-	go func() {
-		for {
-			// Check if we should stop
-			select {
-			case <-s.done:
-				return
-			default:
-			}
-			
-			data, err := s.PTY.Read()
-			if err != nil {
-				errorCh <- err
-				return
-			}
-			
-			select {
-			case dataCh <- data:
-			case <-s.done:
+	for {
+		data, err := s.PTY.Read()
+		if err != nil {
+			if ctx.Err() != nil {
+				slog.Debug("Read loop stopped", slog.String("session_id", s.ID))
 				return
 			}
-		}
-	}()
-	
-	for {
-		select {
-		case <-s.done:
-			slog.Debug("Read loop stopped by done signal", slog.String("session_id", s.ID))
-			return
-			
-		case data := <-dataCh:
-			// Update the screen buffer with new data
-			s.Buffer.Write(data)
-			slog.Debug("Buffer updated",
-				slog.String("session_id", s.ID),
-				slog.Int("bytes", len(data)),
-			)
-			
-		case err := <-errorCh:
-			s.mu.Lock()
-			s.State = StateError
-			s.mu.Unlock()
-			
-			if err.Error() != "EOF" {
-				utils.LogError(err, "Read loop error", slog.String("session_id", s.ID))
+
+			s.markExited()
+			s.recordExit(s.PTY)
+			if processGone(err) {
+				slog.Debug("Read loop ended (process exited)", slog.String("session_id", s.ID))
 			} else {
-				slog.Debug("Read loop ended (EOF)", slog.String("session_id", s.ID))
+				utils.LogError(err, "Read loop error", slog.String("session_id", s.ID))
 			}
 			return
 		}
+
+		// While the session stops, keep draining output so a process
+		// writing during its grace period doesn't block, but drop it
+		if ctx.Err() != nil {
+			continue
+		}
+
+		// Update the screen buffer with new data
+		now := s.clock.Now()
+		s.lastOutput.Store(now.UnixNano())
+		s.noteOutput(now)
+		bells, title, switches := s.Buffer.Bells(), s.Buffer.Title(), s.Buffer.ColumnSwitches()
+		s.Buffer.Write(data)
+		s.observers.publish(OutputEvent{Time: now, Data: data, Generation: s.Buffer.Generation()})
+		s.recordOutputEvents(bells, title)
+		if s.Buffer.ColumnSwitches() != switches {
+			s.followColumnSwitch()
+		}
+		if replies := s.Buffer.TakeReplies(); len(replies) > 0 {
+			s.sendReplies(ctx, replies)
+		}
+		slog.Debug("Buffer updated",
+			slog.String("session_id", s.ID),
+			slog.Int("bytes", len(data)),
+		)
+	}
+}
+
+// sendReplies writes the screen buffer's answers to the application's
+// queries back to it, as a terminal would. The PTY's write timeout keeps an
+// application that stopped reading from stalling the read loop.
+func (s *Session) sendReplies(ctx context.Context, replies []byte) {
+	if _, err := s.PTY.Write(ctx, replies); err != nil {
+		utils.LogError(err, "Failed to answer terminal query",
+			slog.String("session_id", s.ID),
+			slog.Int("bytes", len(replies)),
+		)
 	}
 }
 
-func (s *Session) SendKeys(keys string) error {
+// processGone reports whether a PTY read error means the process and its
+// terminal went away: EOF, or EIO, which Linux returns once the last process
+// holding the terminal has exited
+func processGone(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, syscall.EIO)
+}
+
+// markExited records that the process went away on its own. A session that
+// was stopped deliberately keeps its stopped state.
+func (s *Session) markExited() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if s.State == StateActive {
+		s.State = StateError
+	}
+}
+
+// SendKeys writes keys to the process and returns how many bytes were
+// delivered. Records logged on the way carry the request ID from ctx. When
+// the process stops reading its input the error wraps
+// terminal.ErrInputBlocked and part of keys may have been delivered.
+func (s *Session) SendKeys(ctx context.Context, keys string) (int, error) {
+	return s.send(ctx, keys, false)
+}
+
+// SendSecret writes keys like SendKeys, but the input history keeps only
+// their length
+func (s *Session) SendSecret(ctx context.Context, keys string) (int, error) {
+	return s.send(ctx, keys, true)
+}
 
+func (s *Session) send(ctx context.Context, keys string, secret bool) (int, error) {
+	s.mu.RLock()
 	if s.State != StateActive {
 		err := fmt.Errorf("session is not active")
-		slog.Debug("Cannot send keys to inactive session",
+		slog.DebugContext(ctx, "Cannot send keys to inactive session",
 			slog.String("session_id", s.ID),
 			slog.String("state", s.getStateString()),
 		)
-		return err
+		s.mu.RUnlock()
+		return 0, err
 	}
+	if s.Imported != "" {
+		s.mu.RUnlock()
+		return 0, ErrImported
+	}
+	pty, lifetime := s.PTY, s.ctx
+	s.mu.RUnlock()
 
-	err := s.PTY.Write([]byte(keys))
+	// The write runs without s.mu, which a process that stopped reading
+	// would otherwise keep from list_sessions, view_screen and every other
+	// reader until the write timed out; the PTY keeps writes in order.
+	// Closing or restarting the session aborts a write the process isn't
+	// taking, rather than leaving it to time out.
+	writeCtx, cancel := bindContext(ctx, lifetime)
+	defer cancel()
+	n, err := pty.Write(writeCtx, []byte(keys))
+	if n > 0 {
+		s.inputs.add(keys[:n], secret)
+		s.mu.RLock()
+		s.noteInput()
+		s.mu.RUnlock()
+	}
 	if err != nil {
-		utils.LogError(err, "Failed to send keys",
+		utils.LogErrorContext(ctx, err, "Failed to send keys",
 			slog.String("session_id", s.ID),
 			slog.Int("key_length", len(keys)),
+			slog.Int("bytes_written", n),
 		)
 	} else {
-		slog.Debug("Keys sent",
+		slog.DebugContext(ctx, "Keys sent",
 			slog.String("session_id", s.ID),
 			slog.Int("key_length", len(keys)),
 		)
 	}
-	return err
+	return n, err
+}
+
+func (s *Session) GetScreen(ctx context.Context, format string) (string, error) {
+	content, _, err := s.GetScreenWithOffset(ctx, format, s.Buffer.RenderOptions())
+	return content, err
 }
 
-func (s *Session) GetScreen(format string) (string, error) {
+// GetScreenWithOffset renders the screen with the given options and returns
+// the raw output offset the rendering corresponds to
+func (s *Session) GetScreenWithOffset(ctx context.Context, format string, opts terminal.RenderOptions) (string, int64, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	if s.State != StateActive {
 		err := fmt.Errorf("session is not active")
-		slog.Debug("Cannot get screen from inactive session",
+		slog.DebugContext(ctx, "Cannot get screen from inactive session",
 			slog.String("session_id", s.ID),
 			slog.String("state", s.getStateString()),
 		)
-		return "", err
+		return "", 0, err
 	}
 
-	content, err := s.Buffer.Render(format)
+	content, offset, err := s.Buffer.RenderWithOptions(format, opts)
 	if err != nil {
-		utils.LogError(err, "Failed to render screen",
+		utils.LogErrorContext(ctx, err, "Failed to render screen",
 			slog.String("session_id", s.ID),
 			slog.String("format", format),
 		)
 	} else {
-		slog.Debug("Screen rendered",
+		slog.DebugContext(ctx, "Screen rendered",
 			slog.String("session_id", s.ID),
 			slog.String("format", format),
 			slog.Int("content_length", len(content)),
 		)
 	}
-	return content, err
+	return content, offset, err
+}
+
+// GetFrame renders the screen in several formats at once; see
+// ScreenBuffer.RenderFrame
+func (s *Session) GetFrame(ctx context.Context, formats []string, opts terminal.RenderOptions) (terminal.Frame, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.State != StateActive {
+		err := fmt.Errorf("session is not active")
+		slog.DebugContext(ctx, "Cannot get screen from inactive session",
+			slog.String("session_id", s.ID),
+			slog.String("state", s.getStateString()),
+		)
+		return terminal.Frame{}, err
+	}
+	return s.Buffer.RenderFrame(formats, opts)
 }
 
+// GetScreenVersion renders the screen as it was at version; see
+// ScreenBuffer.RenderVersion
+func (s *Session) GetScreenVersion(ctx context.Context, format string, version uint64, opts terminal.RenderOptions) (terminal.ScreenVersion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.State != StateActive {
+		err := fmt.Errorf("session is not active")
+		slog.DebugContext(ctx, "Cannot get screen from inactive session",
+			slog.String("session_id", s.ID),
+			slog.String("state", s.getStateString()),
+		)
+		return terminal.ScreenVersion{}, err
+	}
+	return s.Buffer.RenderVersionWithOptions(format, version, opts)
+}
+
+// GetLines returns the screen rows that changed after generation since,
+// every row for 0, and the generation they were read at
+func (s *Session) GetLines(ctx context.Context, since uint64) ([]terminal.Line, uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.State != StateActive {
+		err := fmt.Errorf("session is not active")
+		slog.DebugContext(ctx, "Cannot get lines from inactive session",
+			slog.String("session_id", s.ID),
+			slog.String("state", s.getStateString()),
+		)
+		return nil, 0, err
+	}
+
+	lines, generation := s.Buffer.Lines(since)
+	return lines, generation, nil
+}
+
+// ReadRawOutput returns raw output starting at a stream offset. Output
+// stays readable after the process exits.
+func (s *Session) ReadRawOutput(offset int64, max int) (terminal.RawChunk, error) {
+	return s.Buffer.RawDataSince(offset, max)
+}
+
+// GetCursorPosition returns the 0-based cursor column (x) and row (y)
 func (s *Session) GetCursorPosition() (int, int) {
 	return s.Buffer.GetCursorPosition()
 }
 
+// InputModes returns the key modes the application has set, which decide
+// how cursor and keypad keys must be encoded
+func (s *Session) InputModes() terminal.InputModes {
+	return s.Buffer.InputModes()
+}
+
+// KeyProfile returns the name of the key profile send_keys encodes key
+// names with
+func (s *Session) KeyProfile() string {
+	return s.StringOption(OptionKeyProfile)
+}
+
+// TerminalModes returns every terminal mode the application has set
+func (s *Session) TerminalModes() terminal.TerminalModes {
+	return s.Buffer.Modes()
+}
+
+// LastOutput returns when the process last wrote output, or when it was
+// started if it hasn't written any
+func (s *Session) LastOutput() time.Time {
+	return time.Unix(0, s.lastOutput.Load())
+}
+
 func (s *Session) GetScreenSize() (int, int) {
 	return s.Buffer.GetSize()
 }
 
-func (s *Session) Restart() error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// GetProcessInfo returns details about the session's child process
+func (s *Session) GetProcessInfo() (*terminal.ProcessInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	slog.Info("Restarting session", slog.String("session_id", s.ID))
+	if s.Imported != "" {
+		return nil, ErrImported
+	}
+	if s.State != StateActive {
+		err := fmt.Errorf("session is not active")
+		slog.Debug("Cannot get process info from inactive session",
+			slog.String("session_id", s.ID),
+			slog.String("state", s.getStateString()),
+		)
+		return nil, err
+	}
 
-	// Signal readLoop to stop if not already closed
-	select {
-	case <-s.done:
-		// Already closed
-	default:
-		close(s.done)
+	info, err := s.PTY.ProcessInfo()
+	if err != nil {
+		utils.LogError(err, "Failed to read process info", slog.String("session_id", s.ID))
+	}
+	return info, err
+}
+
+// Signal sends a signal to the process. With foreground it goes to the
+// terminal's foreground process group instead, as typing the signal's
+// control character would when the line discipline generates signals; that
+// reaches a shell's running job and works while the application has the
+// terminal in raw mode. It returns the process or group signalled.
+func (s *Session) Signal(sig terminal.Signal, foreground bool) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.Imported != "" {
+		return 0, ErrImported
+	}
+	if s.State != StateActive {
+		return 0, fmt.Errorf("session is not active")
+	}
+
+	target, pid := "process", s.PID
+	var err error
+	if foreground {
+		target = "foreground"
+		pid, err = s.PTY.SignalForeground(sig)
+	} else {
+		err = s.PTY.Signal(sig)
+	}
+	if err != nil {
+		utils.LogError(err, "Failed to send signal",
+			slog.String("session_id", s.ID),
+			slog.String("signal", sig.String()),
+		)
+		return 0, err
+	}
+	s.recordEvent(EventSignal, map[string]interface{}{
+		"signal": sig.String(),
+		"target": target,
+		"pid":    pid,
+	})
+	return pid, nil
+}
+
+// Restart stops the process and starts it again in the same session. The
+// screen is moved into the scrollback under a separator line, so the old
+// process's last output can still be read; clearHistory drops the
+// scrollback and raw output instead.
+func (s *Session) Restart(clearHistory bool) error {
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+
+	// A closed session's buffer has been released
+	s.mu.RLock()
+	closed := s.closed
+	s.mu.RUnlock()
+	if closed {
+		return ErrSessionClosed
+	}
+	if s.Imported != "" {
+		return ErrImported
 	}
+
+	slog.Info("Restarting session", slog.String("session_id", s.ID))
+
+	// Abort in-flight operations and let readLoop know the stop is ours.
+	// This comes before taking mu, which a blocked send may be holding.
+	s.cancel(ErrSessionRestarted)
+	s.mu.Lock()
+	oldPTY := s.PTY
+	s.mu.Unlock()
 	
 	// Stop current process
-	if err := s.PTY.Stop(); err != nil {
+	if err := oldPTY.Stop(); err != nil {
 		utils.LogError(err, "Failed to stop PTY during restart", slog.String("session_id", s.ID))
 		return err
 	}
 	
-	// Wait for readLoop to finish
+	// Wait for readLoop to finish. s.mu must not be held here, since
+	// readLoop takes it to record the process exit.
 	s.readLoopWG.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	
-	// Create new done channel
-	s.done = make(chan struct{})
+	// The restarted process gets a new lifetime
+	s.ctx, s.cancel = context.WithCancelCause(context.Background())
 
-	// Clear buffer
-	s.Buffer.Clear()
+	// The new process gets a blank screen in the default modes. The old
+	// one's output stays in the scrollback unless asked otherwise. Only
+	// close freezes the buffer, but whatever froze it, the new process
+	// must be able to draw.
+	s.Buffer.Unfreeze()
+	if clearHistory {
+		s.Buffer.ClearHistory()
+	} else {
+		s.Buffer.ArchiveScreen(fmt.Sprintf("── restarted at %s ──", s.clock.Now().Format(time.RFC3339)))
+	}
+	s.Buffer.ResetModes()
 
 	// Create new PTY
 	pty, err := terminal.NewPTYWrapper(s.Command, s.Args, s.Env)
@@ -283,12 +727,15 @@ func (s *Session) Restart() error {
 		return err
 	}
 	
-	// Set session ID for logging
+	// Set session ID for logging and keep the current terminal size
 	pty.SetSessionID(s.ID)
+	width, height := s.Buffer.GetSize()
+	pty.SetSize(uint16(height), uint16(width))
 
 	s.PTY = pty
 	s.State = StateActive
-	s.LastActive = time.Now()
+	s.Restarts++
+	s.LastActive = s.clock.Now()
 
 	// Start again
 	err = s.start()
@@ -296,49 +743,117 @@ func (s *Session) Restart() error {
 		utils.LogError(err, "Failed to start session after restart", slog.String("session_id", s.ID))
 		s.State = StateError
 	} else {
+		s.recordEvent(EventRestarted, map[string]interface{}{
+			"pid":           s.PID,
+			"restart_count": s.Restarts,
+		})
 		slog.Info("Session restarted successfully", slog.String("session_id", s.ID))
 	}
 	return err
 }
 
 func (s *Session) Close() error {
+	_, err := s.close(0)
+	return err
+}
+
+// CloseGracefully sends SIGTERM to the process, kills it if it hasn't exited
+// within the grace period, and reports whether the kill was needed
+func (s *Session) CloseGracefully(grace time.Duration) (bool, error) {
+	return s.close(grace)
+}
+
+// close stops the process and releases the session's resources. Closing an
+// already closed session, or one whose process has already exited, succeeds.
+func (s *Session) close(grace time.Duration) (bool, error) {
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+
+	// Abort in-flight operations and let readLoop know the stop is ours.
+	// This comes before taking mu, which a blocked send may be holding.
+	s.cancel(ErrSessionClosed)
+
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	if s.closed {
+		s.mu.Unlock()
+		return false, nil
+	}
+	s.closed = true
+	// Keep the final screen as it is now. readLoop drains what the process
+	// writes while it stops, and output it read just before the cancel
+	// would otherwise still land.
+	if s.Buffer != nil {
+		s.Buffer.Freeze()
+	}
 
 	slog.Debug("Closing session", slog.String("session_id", s.ID))
 
 	s.State = StateStopped
+	pty := s.PTY
+	s.mu.Unlock()
 	
-	// Signal readLoop to stop if not already closed
-	select {
-	case <-s.done:
-		// Already closed
-	default:
-		close(s.done)
+	var killed bool
+	var err error
+	if pty != nil {
+		killed, err = pty.Terminate(grace)
 	}
-	
-	err := s.PTY.Stop()
 	if err != nil {
 		utils.LogError(err, "Failed to stop PTY during close", slog.String("session_id", s.ID))
 	} else {
-		slog.Info("Session closed", slog.String("session_id", s.ID))
+		slog.Info("Session closed",
+			slog.String("session_id", s.ID),
+			slog.Bool("killed", killed),
+		)
 	}
+	s.recordEvent(EventClosed, map[string]interface{}{"killed": killed})
 	
-	// Wait for readLoop to finish
+	// Wait for readLoop to finish; s.mu is released so it can exit
 	s.readLoopWG.Wait()
+	s.observers.close()
+	s.stopCapture()
+	s.stopTriggers()
+	s.stopResources()
 	
 	// Clean up buffer resources
 	if s.Buffer != nil {
 		s.Buffer.Close()
 	}
-	
-	return err
+	unregisterLogTarget(s)
+	s.expansion.release()
+
+	return killed, err
+}
+
+// Context returns the session's lifetime context, which is cancelled when
+// the session is closed or restarted. context.Cause reports which.
+func (s *Session) Context() context.Context {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ctx
+}
+
+// Bind returns a context that ends with either ctx or the session, for
+// operations that wait on the session. When the session goes first, the
+// context's cause is ErrSessionClosed or ErrSessionRestarted.
+func (s *Session) Bind(ctx context.Context) (context.Context, context.CancelFunc) {
+	return bindContext(ctx, s.Context())
+}
+
+func bindContext(ctx, sessionCtx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(sessionCtx, func() {
+		cancel(context.Cause(sessionCtx))
+	})
+	return ctx, func() {
+		stop()
+		cancel(context.Canceled)
+	}
 }
 
 func (s *Session) UpdateLastActive() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.LastActive = time.Now()
+	s.LastActive = s.clock.Now()
 }
 
 func (s *Session) GetInfo() *SessionInfo {
@@ -354,12 +869,140 @@ func (s *Session) GetInfo() *SessionInfo {
 	}
 
 	return &SessionInfo{
-		ID:         s.ID,
-		Command:    s.Command,
-		Args:       s.Args,
-		Created:    s.Created,
-		LastActive: s.LastActive,
-		State:      state,
+		ID:             s.ID,
+		Command:        s.Command,
+		Args:           s.Args,
+		PID:            s.PID,
+		Created:        s.Created,
+		LastActive:     s.LastActive,
+		State:          state,
+		Group:          s.Group,
+		Label:          s.Label,
+		Shell:          s.Shell,
+		Imported:       s.Imported,
+		Locale:         s.Locale,
+		Timezone:       s.Timezone,
+		SeparateStderr: s.SeparateStderr,
+		TmpDir:         s.TmpDir,
+		FreePort:       s.FreePort,
+		Degraded:       s.Buffer.Degraded(),
+		Unresponsive:   s.Unresponsive(),
+	}
+}
+
+// GetDetails returns the full session record. Every piece of per-session
+// state worth reporting should be threaded through here.
+func (s *Session) GetDetails() *SessionDetails {
+	info := s.GetInfo()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	envKeys := make([]string, 0, len(s.Env))
+	for k := range s.Env {
+		envKeys = append(envKeys, k)
+	}
+	sort.Strings(envKeys)
+
+	width, height := s.Buffer.GetSize()
+	lines, maxLines := s.Buffer.ScrollbackInfo()
+
+	details := &SessionDetails{
+		SessionInfo:   *info,
+		EnvKeys:       envKeys,
+		Cwd:           s.Cwd,
+		Width:         width,
+		Height:        height,
+		Scrollback:    ScrollbackInfo{Lines: lines, MaxLines: maxLines},
+		RestartCount:  s.Restarts,
+		DefaultFormat: s.optionLocked(OptionDefaultFormat).(string),
+		Unhandled:     s.Buffer.ParserDiagnostics().Total,
+		LastEventSeq:  s.events.lastSeq(),
+		InputModes:    s.Buffer.InputModes(),
+		Encoding:      s.Buffer.Encoding(),
+		KeyProfile:    s.optionLocked(OptionKeyProfile).(string),
+		Output:        s.OutputStats(),
+	}
+
+	if s.PTY == nil {
+		return details
+	}
+	if exited, code, status := s.PTY.ExitStatus(); exited {
+		details.Exited = true
+		details.ExitCode = &code
+		details.ExitStatus = status
+	}
+	return details
+}
+
+// BufferInfo describes a session's terminal model, as returned by
+// get_buffer_info
+type BufferInfo struct {
+	SessionID string `json:"session_id"`
+	State     string `json:"state"`
+	Frozen    bool   `json:"frozen"` // The process is gone or, for an imported capture, never was, so the screen won't change until a restart
+	terminal.BufferInfo
+}
+
+// BufferInfo returns a snapshot of the session's screen buffer
+func (s *Session) BufferInfo() *BufferInfo {
+	info := s.GetInfo()
+	return &BufferInfo{
+		SessionID:  s.ID,
+		State:      info.State,
+		Frozen:     info.State != "active" || info.Imported != "",
+		BufferInfo: s.Buffer.Info(),
+	}
+}
+
+// record returns the persisted metadata for the session's process
+func (s *Session) record() SessionRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pgid, _ := s.PTY.PGID()
+	return SessionRecord{
+		ID:        s.ID,
+		PID:       s.PTY.PID(),
+		PGID:      pgid,
+		Command:   s.Command,
+		StartedAt: s.Created,
+	}
+}
+
+// Config returns a copy of the session's launch configuration, using the
+// current terminal size
+func (s *Session) Config() SessionConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	args := make([]string, len(s.Args))
+	copy(args, s.Args)
+	env := make(map[string]string, len(s.Env))
+	for k, v := range s.Env {
+		env[k] = v
+	}
+	// A copy gets placeholders of its own rather than this session's values
+	if s.expansion != nil {
+		for k, v := range s.expansion.templates {
+			env[k] = v
+		}
+	}
+	width, height := s.Buffer.GetSize()
+
+	return SessionConfig{
+		Command:        s.Command,
+		Args:           args,
+		Env:            env,
+		Group:          s.Group,
+		Label:          s.Label,
+		Shell:          s.Shell,
+		Locale:         s.Locale,
+		Timezone:       s.Timezone,
+		SeparateStderr: s.SeparateStderr,
+		Options:        s.launchOptions(),
+		Width:          width,
+		Height:         height,
 	}
 }
 
@@ -377,23 +1020,46 @@ func (s *Session) getStateString() string {
 }
 
 // Resize resizes the terminal
-func (s *Session) Resize(width, height int) error {
+func (s *Session) Resize(ctx context.Context, width, height int) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	if s.State != StateActive {
 		err := fmt.Errorf("session is not active")
-		slog.Debug("Cannot resize inactive session",
+		slog.DebugContext(ctx, "Cannot resize inactive session",
 			slog.String("session_id", s.ID),
 			slog.String("state", s.getStateString()),
 		)
 		return err
 	}
+	if s.Imported != "" {
+		return ErrImported
+	}
+
+	// Resize the buffer before the PTY. Resizing the PTY sends the process
+	// SIGWINCH, and the redraw it triggers must land in a buffer that already
+	// has the new size; the other way round, output drawn for the new size
+	// could be wrapped or clipped at the old one.
+	s.sizeMu.Lock()
+	defer s.sizeMu.Unlock()
+	oldWidth, oldHeight := s.Buffer.GetSize()
+	if rows, cols := s.PTY.Size(); width == oldWidth && height == oldHeight && int(cols) == width && int(rows) == height {
+		// Nothing to do: no SIGWINCH, so a storm of identical resizes
+		// doesn't make the application redraw over and over
+		slog.DebugContext(ctx, "Session already has the requested size",
+			slog.String("session_id", s.ID),
+			slog.Int("width", width),
+			slog.Int("height", height),
+		)
+		return nil
+	}
+	s.Buffer.Resize(width, height)
 
-	// Resize the PTY
 	err := s.PTY.Resize(uint16(height), uint16(width))
 	if err != nil {
-		utils.LogError(err, "Failed to resize PTY",
+		// Keep the buffer matching the terminal the process still has
+		s.Buffer.Resize(oldWidth, oldHeight)
+		utils.LogErrorContext(ctx, err, "Failed to resize PTY",
 			slog.String("session_id", s.ID),
 			slog.Int("width", width),
 			slog.Int("height", height),
@@ -401,14 +1067,58 @@ func (s *Session) Resize(width, height int) error {
 		return err
 	}
 
-	// Resize the buffer
-	s.Buffer.Resize(width, height)
+	s.recordEvent(EventResize, map[string]interface{}{
+		"width":       width,
+		"height":      height,
+		"prev_width":  oldWidth,
+		"prev_height": oldHeight,
+		"source":      ResizeByClient,
+	})
 
-	slog.Info("Session resized",
+	slog.InfoContext(ctx, "Session resized",
 		slog.String("session_id", s.ID),
 		slog.Int("width", width),
 		slog.Int("height", height),
 	)
 
 	return nil
-}
\ No newline at end of file
+}
+
+// Sources of a resize event
+const (
+	ResizeByClient      = "client"      // resize_terminal
+	ResizeByApplication = "application" // The application switched columns with DECCOLM
+)
+
+// followColumnSwitch gives the PTY the size DECCOLM gave the buffer, so the
+// application's idea of the terminal matches the screen it draws on. It
+// runs on readLoop, which must not take s.mu.
+func (s *Session) followColumnSwitch() {
+	s.sizeMu.Lock()
+	defer s.sizeMu.Unlock()
+
+	width, height := s.Buffer.GetSize()
+	rows, cols := s.PTY.Size()
+	if int(cols) == width && int(rows) == height {
+		return
+	}
+	if err := s.PTY.Resize(uint16(height), uint16(width)); err != nil {
+		utils.LogError(err, "Failed to follow column switch",
+			slog.String("session_id", s.ID),
+			slog.Int("width", width),
+		)
+		return
+	}
+	s.recordEvent(EventResize, map[string]interface{}{
+		"width":       width,
+		"height":      height,
+		"prev_width":  int(cols),
+		"prev_height": int(rows),
+		"source":      ResizeByApplication,
+	})
+	slog.Info("Session resized by the application",
+		slog.String("session_id", s.ID),
+		slog.Int("width", width),
+		slog.Int("height", height),
+	)
+}
@@ -0,0 +1,422 @@
+//go:build !windows
+
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+)
+
+func TestSession_RapidResizeKeepsBufferAndPTYInStep(t *testing.T) {
+	utils.InitLogger()
+	ctx := context.Background()
+
+	// The process reports its terminal size once told to
+	sess, err := NewSession("sh", []string{"-c", "read x; stty size; sleep 10"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer sess.Close()
+
+	var width, height int
+	for i := 0; i < 50; i++ {
+		width, height = 40+i, 10+i%20
+		if err := sess.Resize(ctx, width, height); err != nil {
+			t.Fatalf("Resize %d to %dx%d failed: %v", i, width, height, err)
+		}
+	}
+
+	if w, h := sess.GetScreenSize(); w != width || h != height {
+		t.Errorf("Expected buffer %dx%d, got %dx%d", width, height, w, h)
+	}
+	if rows, cols := sess.PTY.Size(); int(cols) != width || int(rows) != height {
+		t.Errorf("Expected PTY %dx%d, got %dx%d", width, height, cols, rows)
+	}
+
+	// The process sees the last size, not one that was dropped or reordered
+	if _, err := sess.SendKeys(ctx, "\r"); err != nil {
+		t.Fatalf("Failed to send keys: %v", err)
+	}
+	want := fmt.Sprintf("%d %d", height, width)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		screen, err := sess.GetScreen(ctx, "plain")
+		if err != nil {
+			t.Fatalf("Failed to get screen: %v", err)
+		}
+		if strings.Contains(screen, want) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the process to report %q, got %q", want, screen)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestSession_StopIsQuiet(t *testing.T) {
+	utils.InitLogger()
+
+	// Stopping sessions that are busy writing, idle, or already gone
+	for _, args := range [][]string{
+		{"sh", "-c", "yes"},
+		{"cat"},
+		{"true"},
+	} {
+		sess, err := NewSession(args[0], args[1:], nil)
+		if err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if err := sess.Close(); err != nil {
+			t.Errorf("%v: Close failed: %v", args, err)
+		}
+
+		if sess.State != StateStopped {
+			t.Errorf("%v: expected stopped state, got %s", args, sess.getStateString())
+		}
+		if warnings := sess.Logs(slog.LevelWarn, 0); len(warnings) != 0 {
+			t.Errorf("%v: expected no warnings or errors from a deliberate stop, got %+v", args, warnings)
+		}
+	}
+}
+
+func TestSession_RestartIsQuiet(t *testing.T) {
+	utils.InitLogger()
+
+	sess, err := NewSession("cat", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer sess.Close()
+
+	if err := sess.Restart(false); err != nil {
+		t.Fatalf("Restart failed: %v", err)
+	}
+	if sess.State != StateActive {
+		t.Errorf("Expected active state after restart, got %s", sess.getStateString())
+	}
+	if warnings := sess.Logs(slog.LevelWarn, 0); len(warnings) != 0 {
+		t.Errorf("Expected no warnings or errors from a restart, got %+v", warnings)
+	}
+}
+
+// waitForGoroutines waits for the goroutine count to fall back to at most
+// want, returning the last count seen
+func waitForGoroutines(want int) int {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		n := runtime.NumGoroutine()
+		if n <= want || time.Now().After(deadline) {
+			return n
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestManager_NoGoroutinesLeft(t *testing.T) {
+	utils.InitLogger()
+	manager := NewManager()
+	manager.maxSessions = 100
+	before := runtime.NumGoroutine()
+
+	var ids []string
+	var cancels []context.CancelFunc
+	for i := 0; i < 100; i++ {
+		sess, err := manager.CreateSession("cat", nil, nil)
+		if err != nil {
+			t.Fatalf("Failed to create session %d: %v", i, err)
+		}
+		ids = append(ids, sess.ID)
+		// An operation waiting on the session while it is removed
+		_, cancel := sess.Bind(context.Background())
+		cancels = append(cancels, cancel)
+	}
+	for _, id := range ids {
+		if err := manager.RemoveSession(id); err != nil {
+			t.Fatalf("Failed to remove session: %v", err)
+		}
+	}
+	for _, cancel := range cancels {
+		cancel()
+	}
+
+	if after := waitForGoroutines(before); after > before {
+		buf := make([]byte, 1<<20)
+		n := runtime.Stack(buf, true)
+		t.Errorf("Expected %d goroutines after removing all sessions, got %d:\n%s", before, after, buf[:n])
+	}
+}
+
+func TestManager_ChurnKeepsHeapBounded(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Starts thousands of processes")
+	}
+	utils.InitLogger()
+	manager := NewManager()
+
+	// Each session fills part of its scrollback before it goes
+	churn := func(n int) {
+		for i := 0; i < n; i++ {
+			sess, err := manager.CreateSession("sh", []string{"-c", "seq 1 200"}, nil)
+			if err != nil {
+				t.Fatalf("Failed to create session %d: %v", i, err)
+			}
+			sess.readLoopWG.Wait()
+			if err := manager.RemoveSession(sess.ID); err != nil {
+				t.Fatalf("Failed to remove session: %v", err)
+			}
+		}
+	}
+	heap := func() int64 {
+		var stats runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&stats)
+		return int64(stats.HeapAlloc)
+	}
+
+	// Warm up pools and caches before taking the baseline
+	churn(100)
+	before := heap()
+	churn(2000)
+	growth := heap() - before
+
+	if limit := int64(16 << 20); growth > limit {
+		t.Errorf("Heap grew by %d KB over 2000 sessions, limit %d KB", growth>>10, limit>>10)
+	}
+	if sessions := manager.ListSessions(); len(sessions) != 0 {
+		t.Errorf("Expected no sessions left, got %d", len(sessions))
+	}
+}
+
+func TestSession_BindEndsWithSession(t *testing.T) {
+	utils.InitLogger()
+
+	sess, err := NewSession("cat", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer sess.Close()
+
+	// The request's own cancellation still applies
+	reqCtx, reqCancel := context.WithCancel(context.Background())
+	ctx, cancel := sess.Bind(reqCtx)
+	reqCancel()
+	<-ctx.Done()
+	if !errors.Is(context.Cause(ctx), context.Canceled) {
+		t.Errorf("Expected the request's cancellation, got %v", context.Cause(ctx))
+	}
+	cancel()
+
+	expectCause := func(ctx context.Context, want error) {
+		t.Helper()
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Bound context not cancelled, expected %v", want)
+		}
+		if cause := context.Cause(ctx); !errors.Is(cause, want) {
+			t.Errorf("Expected cause %v, got %v", want, cause)
+		}
+	}
+
+	ctx, cancel = sess.Bind(context.Background())
+	defer cancel()
+	if err := sess.Restart(false); err != nil {
+		t.Fatalf("Restart failed: %v", err)
+	}
+	expectCause(ctx, ErrSessionRestarted)
+
+	// Contexts bound after the restart follow the new process
+	ctx, cancel = sess.Bind(context.Background())
+	defer cancel()
+	if ctx.Err() != nil {
+		t.Fatal("Context bound after restart is already done")
+	}
+	sess.Close()
+	expectCause(ctx, ErrSessionClosed)
+}
+
+func TestSession_CloseAbortsBlockedSend(t *testing.T) {
+	utils.InitLogger()
+
+	// The child reads raw input, then stops reading altogether
+	sess, err := NewSession("sh", []string{"-c", "stty raw -echo; echo ready; exec sleep 30"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer sess.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		screen, _ := sess.GetScreen(context.Background(), "plain")
+		if strings.Contains(screen, "ready") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Child never switched to raw mode")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err := syscall.Kill(sess.PID, syscall.SIGSTOP); err != nil {
+		t.Fatalf("Failed to stop child: %v", err)
+	}
+
+	sent := make(chan error, 1)
+	go func() {
+		_, err := sess.SendKeys(context.Background(), strings.Repeat("x", 1<<20))
+		sent <- err
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	start := time.Now()
+	if err := sess.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	select {
+	case err := <-sent:
+		if !errors.Is(err, ErrSessionClosed) {
+			t.Errorf("Expected the send to fail with ErrSessionClosed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send still blocked after Close")
+	}
+	// Well inside the 5 second write timeout
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Close took %v with a blocked send", elapsed)
+	}
+}
+
+func TestSession_CloseFreezesScreen(t *testing.T) {
+	utils.InitLogger()
+
+	// The child ignores SIGTERM and keeps writing through the grace period
+	sess, err := NewSession("sh", []string{"-c", `trap "" TERM; i=0; while :; do i=$((i+1)); echo "tick $i"; done`}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer sess.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for sess.Buffer.Generation() < 10 {
+		if time.Now().After(deadline) {
+			t.Fatal("Child never started writing")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		if _, err := sess.CloseGracefully(500 * time.Millisecond); err != nil {
+			t.Errorf("Close failed: %v", err)
+		}
+	}()
+	for !sess.Buffer.Frozen() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Output arriving until the kill doesn't reach the screen
+	frozen, _ := sess.Buffer.Render("raw")
+	time.Sleep(200 * time.Millisecond)
+	if screen, _ := sess.Buffer.Render("raw"); screen != frozen {
+		t.Errorf("Expected the screen to stay as it was when closing began\n%q\ngot\n%q", frozen, screen)
+	}
+	<-closed
+	if screen, _ := sess.Buffer.Render("raw"); screen != frozen {
+		t.Errorf("Expected the closed screen to stay as it was when closing began\n%q\ngot\n%q", frozen, screen)
+	}
+}
+
+func TestSession_ColumnSwitchResizesPTY(t *testing.T) {
+	utils.InitLogger()
+	ctx := context.Background()
+
+	// The process switches to 132 columns and reports the size it then has
+	sess, err := NewSessionWithConfig(SessionConfig{
+		Command: "sh",
+		Args:    []string{"-c", `read x; printf '\033[?3h'; sleep 0.2; stty size; sleep 10`},
+		Options: map[string]interface{}{OptionColumnMode: ColumnModeResize},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer sess.Close()
+
+	if _, err := sess.SendKeys(ctx, "\r"); err != nil {
+		t.Fatalf("Failed to send keys: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		screen, err := sess.GetScreen(ctx, "plain")
+		if err != nil {
+			t.Fatalf("Failed to get screen: %v", err)
+		}
+		if strings.Contains(screen, "24 132") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the process to see 132 columns, got %q", screen)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if w, h := sess.GetScreenSize(); w != 132 || h != 24 {
+		t.Errorf("Expected buffer 132x24, got %dx%d", w, h)
+	}
+	if rows, cols := sess.PTY.Size(); cols != 132 || rows != 24 {
+		t.Errorf("Expected PTY 132x24, got %dx%d", cols, rows)
+	}
+
+	var resizes []Event
+	for _, e := range sess.Events(0, 0).Events {
+		if e.Type == EventResize {
+			resizes = append(resizes, e)
+		}
+	}
+	if len(resizes) != 1 || resizes[0].Data["source"] != ResizeByApplication ||
+		resizes[0].Data["width"] != 132 || resizes[0].Data["prev_width"] != 80 {
+		t.Errorf("Expected one application resize event, got %+v", resizes)
+	}
+}
+
+func TestSession_AnswersDECRQSS(t *testing.T) {
+	utils.InitLogger()
+	ctx := context.Background()
+
+	// The process asks for its SGR state and prints the answer as hex
+	sess, err := NewSessionWithConfig(SessionConfig{
+		Command: "sh",
+		Args:    []string{"-c", `stty raw -echo; printf '\033P$qm\033\\'; r=$(dd bs=1 count=7 2>/dev/null | od -An -tx1); stty sane; echo "got$r"; sleep 10`},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer sess.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		screen, err := sess.GetScreen(ctx, "plain")
+		if err != nil {
+			t.Fatalf("Failed to get screen: %v", err)
+		}
+		if strings.Contains(screen, "got 1b 50 30 24 72 1b 5c") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the process to read ESC P 0 $ r ST, got %q", screen)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if n := sess.Buffer.ParserDiagnostics().Counts["DCS DECRQSS"]; n != 1 {
+		t.Errorf("Expected one DECRQSS counted, got %d", n)
+	}
+}
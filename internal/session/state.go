@@ -0,0 +1,159 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+)
+
+// SessionRecord is the persisted metadata for a session's process
+type SessionRecord struct {
+	ID        string    `json:"id"`
+	PID       int       `json:"pid"`
+	PGID      int       `json:"pgid"`
+	Command   string    `json:"command"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// ReapResult describes the outcome of killing a single orphan
+type ReapResult struct {
+	ID     string `json:"id"`
+	PID    int    `json:"pid"`
+	Result string `json:"result"` // "killed", "already_exited" or "error"
+	Error  string `json:"error,omitempty"`
+}
+
+// stateFile is the on-disk format. Each server process writes its own file
+// so concurrently running servers sharing a directory don't clobber each other.
+type stateFile struct {
+	ServerPID int             `json:"server_pid"`
+	Sessions  []SessionRecord `json:"sessions"`
+	Orphans   []SessionRecord `json:"orphans"`
+}
+
+const stateFilePrefix = "sessions-"
+
+// stateStore persists session records to a JSON file in a directory
+type stateStore struct {
+	dir  string
+	path string
+}
+
+func newStateStore(dir string) (*stateStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+	return &stateStore{
+		dir:  dir,
+		path: filepath.Join(dir, fmt.Sprintf("%s%d.json", stateFilePrefix, os.Getpid())),
+	}, nil
+}
+
+// save atomically writes the current sessions and known orphans
+func (st *stateStore) save(sessions, orphans []SessionRecord) error {
+	data, err := json.MarshalIndent(stateFile{
+		ServerPID: os.Getpid(),
+		Sessions:  sessions,
+		Orphans:   orphans,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	tmp := st.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	if err := os.Rename(tmp, st.path); err != nil {
+		return fmt.Errorf("failed to replace state file: %w", err)
+	}
+	return nil
+}
+
+// loadOrphans reads state files left behind by servers that are no longer
+// running and returns the recorded processes that are still alive. The stale
+// files are removed; the caller takes ownership of the returned orphans.
+func (st *stateStore) loadOrphans() ([]SessionRecord, error) {
+	entries, err := os.ReadDir(st.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state directory: %w", err)
+	}
+
+	var orphans []SessionRecord
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, stateFilePrefix) || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		path := filepath.Join(st.dir, name)
+		if path == st.path {
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			utils.LogError(err, "Failed to read state file", slog.String("path", path))
+			continue
+		}
+		var state stateFile
+		if err := json.Unmarshal(data, &state); err != nil {
+			utils.LogError(err, "Ignoring corrupt state file", slog.String("path", path))
+			continue
+		}
+
+		// Another server is still running and owns these sessions
+		if state.ServerPID != os.Getpid() && processAlive(state.ServerPID) {
+			continue
+		}
+
+		for _, rec := range append(state.Sessions, state.Orphans...) {
+			if recordAlive(rec) {
+				orphans = append(orphans, rec)
+			}
+		}
+
+		if err := os.Remove(path); err != nil {
+			utils.LogError(err, "Failed to remove stale state file", slog.String("path", path))
+		}
+	}
+
+	return orphans, nil
+}
+
+// recordAlive reports whether the recorded process still exists. The process
+// group must also match, which guards against the PID having been reused.
+func recordAlive(rec SessionRecord) bool {
+	if !processAlive(rec.PID) {
+		return false
+	}
+	if rec.PGID > 0 {
+		pgid, err := processGroupID(rec.PID)
+		if err != nil || pgid != rec.PGID {
+			return false
+		}
+	}
+	return true
+}
+
+// reapRecord kills an orphaned process and its process group
+func reapRecord(rec SessionRecord) ReapResult {
+	result := ReapResult{ID: rec.ID, PID: rec.PID, Result: "killed"}
+	if !recordAlive(rec) {
+		result.Result = "already_exited"
+		return result
+	}
+
+	if exited, err := killRecord(rec); exited {
+		result.Result = "already_exited"
+	} else if err != nil {
+		result.Result = "error"
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,136 @@
+//go:build !windows
+
+package session
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+)
+
+// deadPID returns the PID of a process that has already exited
+func deadPID(t *testing.T) int {
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Failed to run true: %v", err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestManager_OrphanDetection(t *testing.T) {
+	utils.InitLogger()
+	dir := t.TempDir()
+
+	// A synthetic live process in its own process group, as a PTY child would be
+	live := exec.Command("sleep", "100")
+	live.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := live.Start(); err != nil {
+		t.Fatalf("Failed to start live process: %v", err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		live.Wait()
+		close(exited)
+	}()
+	defer live.Process.Kill()
+
+	// Stale state file from a server that is no longer running
+	stale := stateFile{
+		ServerPID: deadPID(t),
+		Sessions: []SessionRecord{
+			{ID: "live", PID: live.Process.Pid, PGID: live.Process.Pid, Command: "sleep", StartedAt: time.Now()},
+			{ID: "dead", PID: deadPID(t), PGID: 0, Command: "true", StartedAt: time.Now()},
+		},
+	}
+	data, _ := json.Marshal(stale)
+	stalePath := filepath.Join(dir, "sessions-1.json")
+	if err := os.WriteFile(stalePath, data, 0o600); err != nil {
+		t.Fatalf("Failed to write stale state: %v", err)
+	}
+
+	manager := NewManager()
+	if err := manager.EnableStatePersistence(dir); err != nil {
+		t.Fatalf("Failed to enable persistence: %v", err)
+	}
+
+	orphans := manager.ListOrphans()
+	if len(orphans) != 1 || orphans[0].ID != "live" {
+		t.Fatalf("Expected only the live orphan, got %+v", orphans)
+	}
+
+	if _, err := os.Stat(stalePath); !os.IsNotExist(err) {
+		t.Error("Stale state file should have been removed")
+	}
+
+	// Orphans are carried in this server's own state file
+	var own stateFile
+	data, err := os.ReadFile(manager.state.path)
+	if err != nil {
+		t.Fatalf("Failed to read own state file: %v", err)
+	}
+	json.Unmarshal(data, &own)
+	if len(own.Orphans) != 1 {
+		t.Errorf("Expected orphan in own state file, got %+v", own)
+	}
+
+	results := manager.ReapOrphans()
+	if len(results) != 1 || results[0].Result != "killed" {
+		t.Fatalf("Unexpected reap results: %+v", results)
+	}
+
+	select {
+	case <-exited:
+	case <-time.After(2 * time.Second):
+		t.Error("Orphan process was not killed")
+	}
+
+	if orphans := manager.ListOrphans(); len(orphans) != 0 {
+		t.Errorf("Expected no orphans after reap, got %+v", orphans)
+	}
+}
+
+func TestManager_PersistsSessions(t *testing.T) {
+	utils.InitLogger()
+	dir := t.TempDir()
+
+	manager := NewManager()
+	if err := manager.EnableStatePersistence(dir); err != nil {
+		t.Fatalf("Failed to enable persistence: %v", err)
+	}
+
+	sess, err := manager.CreateSession("sleep", []string{"10"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	readState := func() stateFile {
+		var st stateFile
+		data, err := os.ReadFile(manager.state.path)
+		if err != nil {
+			t.Fatalf("Failed to read state file: %v", err)
+		}
+		if err := json.Unmarshal(data, &st); err != nil {
+			t.Fatalf("Invalid state file: %v", err)
+		}
+		return st
+	}
+
+	st := readState()
+	if len(st.Sessions) != 1 || st.Sessions[0].ID != sess.ID || st.Sessions[0].PID != sess.PTY.PID() {
+		t.Fatalf("Session not recorded correctly: %+v", st)
+	}
+	if st.Sessions[0].PGID == 0 {
+		t.Error("Expected process group to be recorded")
+	}
+
+	manager.RemoveSession(sess.ID)
+	if st := readState(); len(st.Sessions) != 0 {
+		t.Errorf("Expected no sessions after removal, got %+v", st.Sessions)
+	}
+}
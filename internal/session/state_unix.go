@@ -0,0 +1,38 @@
+//go:build !windows
+
+package session
+
+import (
+	"errors"
+	"syscall"
+)
+
+// processAlive reports whether a process with the given PID exists
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// processGroupID returns the process group of a running process
+func processGroupID(pid int) (int, error) {
+	return syscall.Getpgid(pid)
+}
+
+// killRecord kills a recorded process and its process group. exited is true
+// when there was nothing left to kill.
+func killRecord(rec SessionRecord) (exited bool, err error) {
+	target := rec.PID
+	if rec.PGID > 0 {
+		target = -rec.PGID
+	}
+	if err := syscall.Kill(target, syscall.SIGKILL); err != nil {
+		if errors.Is(err, syscall.ESRCH) {
+			return true, nil
+		}
+		return false, err
+	}
+	return false, nil
+}
@@ -0,0 +1,55 @@
+//go:build windows
+
+package session
+
+import (
+	"errors"
+	"syscall"
+)
+
+const (
+	processQueryLimitedInformation = 0x1000
+	stillActive                    = 259
+	errorInvalidParameter          = syscall.Errno(87)
+)
+
+// processAlive reports whether a process with the given PID is running
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		// Access denied still means the process exists
+		return errors.Is(err, syscall.ERROR_ACCESS_DENIED)
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+	return code == stillActive
+}
+
+// processGroupID returns the PID itself: Windows has no process groups, and
+// sessions record their PID as the group
+func processGroupID(pid int) (int, error) {
+	return pid, nil
+}
+
+// killRecord terminates a recorded process. exited is true when there was
+// nothing left to kill. Children that outlived the console are not reached.
+func killRecord(rec SessionRecord) (exited bool, err error) {
+	h, err := syscall.OpenProcess(syscall.PROCESS_TERMINATE, false, uint32(rec.PID))
+	if err != nil {
+		if errors.Is(err, errorInvalidParameter) {
+			return true, nil
+		}
+		return false, err
+	}
+	defer syscall.CloseHandle(h)
+	if err := syscall.TerminateProcess(h, 1); err != nil {
+		return false, err
+	}
+	return false, nil
+}
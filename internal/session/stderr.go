@@ -0,0 +1,110 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+)
+
+// maxStderrBytes is how much of a separated stderr a session keeps; the
+// oldest output goes first
+const maxStderrBytes = 1 << 20
+
+// ErrStderrNotSeparated is returned for the stderr of a session launched
+// without separate_stderr, whose stderr is on the terminal
+var ErrStderrNotSeparated = errors.New("session was not launched with separate_stderr; its stderr is part of the terminal output")
+
+// stderrLog keeps the newest output of a separated stderr. Offsets count
+// every byte since the session started, across restarts, and never go
+// backwards.
+type stderrLog struct {
+	mu     sync.Mutex
+	data   []byte
+	offset int64 // Stream offset of data[0]
+}
+
+func (l *stderrLog) write(p []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.data = append(l.data, p...)
+	if over := len(l.data) - maxStderrBytes; over > 0 {
+		l.data = append([]byte(nil), l.data[over:]...)
+		l.offset += int64(over)
+	}
+}
+
+// since returns up to max bytes from offset on, like
+// terminal.ScreenBuffer.RawDataSince
+func (l *stderrLog) since(offset int64, max int) (terminal.RawChunk, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	end := l.offset + int64(len(l.data))
+	if offset < 0 || offset > end {
+		return terminal.RawChunk{}, fmt.Errorf("offset %d is outside the stderr stream (0-%d)", offset, end)
+	}
+	chunk := terminal.RawChunk{Offset: offset}
+	if offset < l.offset {
+		chunk.Offset = l.offset
+		chunk.Truncated = true
+	}
+	start := int(chunk.Offset - l.offset)
+	stop := len(l.data)
+	if max > 0 && stop-start > max {
+		stop = start + max
+	}
+	chunk.Data = append([]byte(nil), l.data[start:stop]...)
+	chunk.Next = chunk.Offset + int64(len(chunk.Data))
+	return chunk, nil
+}
+
+// end returns the offset just past the newest byte
+func (l *stderrLog) end() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.offset + int64(len(l.data))
+}
+
+// all returns the output kept
+func (l *stderrLog) all() []byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]byte(nil), l.data...)
+}
+
+// copyStderr reads the process's separated stderr into the session's log
+// until the process exits or the PTY is stopped
+func (s *Session) copyStderr(r io.Reader) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			s.stderr.write(buf[:n])
+		}
+		if err != nil {
+			slog.Debug("Stderr reader stopped", slog.String("session_id", s.ID))
+			return
+		}
+	}
+}
+
+// ReadStderr returns the separated stderr from a stream offset. Like the
+// raw output, it stays readable after the process exits.
+func (s *Session) ReadStderr(offset int64, max int) (terminal.RawChunk, error) {
+	if s.stderr == nil {
+		return terminal.RawChunk{}, ErrStderrNotSeparated
+	}
+	return s.stderr.since(offset, max)
+}
+
+// StderrEnd returns the stderr stream offset just past the newest byte
+func (s *Session) StderrEnd() int64 {
+	if s.stderr == nil {
+		return 0
+	}
+	return s.stderr.end()
+}
@@ -0,0 +1,168 @@
+package session
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Placeholders that launch environment values may contain, filled in for
+// each session before its process starts
+const (
+	PlaceholderSessionID  = "session_id"     // The session's own ID
+	PlaceholderSessionTmp = "session_tmpdir" // A directory created for the session and removed when it closes
+	PlaceholderPortFree   = "port_free"      // A free TCP port reserved for the session while it lives
+)
+
+var placeholderPattern = regexp.MustCompile(`\{\{([^{}]*)\}\}`)
+
+// ValidateEnvTemplates rejects environment values with a placeholder other
+// than the known ones, so a typo fails the launch instead of reaching the
+// process as literal text
+func ValidateEnvTemplates(env map[string]string) error {
+	for key, value := range env {
+		for _, m := range placeholderPattern.FindAllStringSubmatch(value, -1) {
+			switch m[1] {
+			case PlaceholderSessionID, PlaceholderSessionTmp, PlaceholderPortFree:
+			default:
+				return fmt.Errorf("environment value for '%s' has unknown placeholder %s; use {{%s}}, {{%s}} or {{%s}}",
+					key, m[0], PlaceholderSessionID, PlaceholderSessionTmp, PlaceholderPortFree)
+			}
+		}
+	}
+	return nil
+}
+
+// envExpansion is a launch environment with its placeholders filled in,
+// and what was set aside for them
+type envExpansion struct {
+	env       map[string]string
+	templates map[string]string // Values as given, for the entries that had placeholders
+	tmpDir    string
+	port      int
+}
+
+// expandEnvTemplates fills in the placeholders in env for session id. Each
+// placeholder gets one value per session, however often it appears. The
+// tmpdir and port are only set aside when some value asks for them, and
+// must be given back with release.
+func expandEnvTemplates(env map[string]string, id string) (*envExpansion, error) {
+	if err := ValidateEnvTemplates(env); err != nil {
+		return nil, err
+	}
+	x := &envExpansion{env: env}
+	for key, value := range env {
+		if !placeholderPattern.MatchString(value) {
+			continue
+		}
+		if x.templates == nil {
+			x.templates = make(map[string]string)
+			x.env = make(map[string]string, len(env))
+			for k, v := range env {
+				x.env[k] = v
+			}
+		}
+		x.templates[key] = value
+	}
+
+	var err error
+	for key, value := range x.templates {
+		x.env[key] = placeholderPattern.ReplaceAllStringFunc(value, func(m string) string {
+			switch strings.TrimSuffix(strings.TrimPrefix(m, "{{"), "}}") {
+			case PlaceholderSessionID:
+				return id
+			case PlaceholderSessionTmp:
+				if x.tmpDir == "" && err == nil {
+					x.tmpDir, err = os.MkdirTemp("", "mcp-session-"+id[:8]+"-")
+				}
+				return x.tmpDir
+			default:
+				if x.port == 0 && err == nil {
+					x.port, err = reservePort()
+				}
+				return fmt.Sprint(x.port)
+			}
+		})
+	}
+	if err != nil {
+		x.release()
+		return nil, fmt.Errorf("failed to expand environment placeholders: %w", err)
+	}
+	return x, nil
+}
+
+// template returns the value given for name when it had placeholders
+func (x *envExpansion) template(name string) (string, bool) {
+	if x == nil {
+		return "", false
+	}
+	tmpl, ok := x.templates[name]
+	return tmpl, ok
+}
+
+// release removes the session's tmpdir and gives its port back
+func (x *envExpansion) release() {
+	if x == nil {
+		return
+	}
+	if x.tmpDir != "" {
+		if err := os.RemoveAll(x.tmpDir); err != nil {
+			slog.Warn("Failed to remove session tmpdir",
+				slog.String("dir", x.tmpDir),
+				slog.String("error", err.Error()),
+			)
+		}
+		x.tmpDir = ""
+	}
+	if x.port != 0 {
+		releasePort(x.port)
+		x.port = 0
+	}
+}
+
+// reservedPorts are the ports handed to live sessions. The OS only avoids
+// ports that are bound, and a session's app may not have bound its port
+// yet when the next session asks for one.
+var (
+	reservedMu    sync.Mutex
+	reservedPorts = make(map[int]bool)
+)
+
+// reservePort finds a free TCP port on the loopback interface that no
+// other session holds. Listeners on ports already held are kept open until
+// a new one turns up, so the OS doesn't hand them out again.
+func reservePort() (int, error) {
+	reservedMu.Lock()
+	defer reservedMu.Unlock()
+
+	var held []net.Listener
+	defer func() {
+		for _, l := range held {
+			l.Close()
+		}
+	}()
+	for attempt := 0; attempt < 100; attempt++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return 0, err
+		}
+		port := l.Addr().(*net.TCPAddr).Port
+		if !reservedPorts[port] {
+			l.Close()
+			reservedPorts[port] = true
+			return port, nil
+		}
+		held = append(held, l)
+	}
+	return 0, fmt.Errorf("no free TCP port found")
+}
+
+func releasePort(port int) {
+	reservedMu.Lock()
+	defer reservedMu.Unlock()
+	delete(reservedPorts, port)
+}
@@ -0,0 +1,136 @@
+//go:build !windows
+
+package session
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+)
+
+func TestSession_EnvTemplates(t *testing.T) {
+	utils.InitLogger()
+	ctx := context.Background()
+
+	sess, err := NewSessionWithConfig(SessionConfig{
+		Command: "sh",
+		Args:    []string{"-c", `echo "id=$APP_ID dir=$APP_DIR port=$APP_PORT again=$APP_AGAIN"; sleep 10`},
+		Env: map[string]string{
+			"APP_ID":    "{{session_id}}",
+			"APP_DIR":   "{{session_tmpdir}}/logs",
+			"APP_PORT":  "{{port_free}}",
+			"APP_AGAIN": "{{port_free}}-{{session_id}}",
+			"PLAIN":     "unchanged",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer sess.Close()
+
+	if sess.TmpDir == "" || sess.FreePort == 0 {
+		t.Fatalf("Expected a tmpdir and a port, got %q and %d", sess.TmpDir, sess.FreePort)
+	}
+	if info, err := os.Stat(sess.TmpDir); err != nil || !info.IsDir() {
+		t.Fatalf("Expected the tmpdir to exist: %v", err)
+	}
+	if info := sess.GetInfo(); info.TmpDir != sess.TmpDir || info.FreePort != sess.FreePort {
+		t.Errorf("Expected the tmpdir and port in the session info, got %+v", info)
+	}
+
+	// Each placeholder has one value, wherever it appears
+	want := fmt.Sprintf("id=%s dir=%s/logs port=%d again=%d-%s", sess.ID, sess.TmpDir, sess.FreePort, sess.FreePort, sess.ID)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		screen, err := sess.GetScreen(ctx, "plain")
+		if err != nil {
+			t.Fatalf("Failed to get screen: %v", err)
+		}
+		if strings.Contains(strings.ReplaceAll(screen, "\n", ""), want) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %q, got %q", want, screen)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// A copy of the configuration gets placeholders of its own
+	cfg := sess.Config()
+	if cfg.Env["APP_ID"] != "{{session_id}}" || cfg.Env["APP_DIR"] != "{{session_tmpdir}}/logs" || cfg.Env["PLAIN"] != "unchanged" {
+		t.Errorf("Expected the config to keep the templates, got %v", cfg.Env)
+	}
+
+	// Closing removes the tmpdir and gives the port back
+	dir, port := sess.TmpDir, sess.FreePort
+	if err := sess.Close(); err != nil {
+		t.Fatalf("Failed to close session: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Expected the tmpdir to be removed, got %v", err)
+	}
+	reservedMu.Lock()
+	held := reservedPorts[port]
+	reservedMu.Unlock()
+	if held {
+		t.Errorf("Expected port %d to be released", port)
+	}
+}
+
+func TestSession_EnvTemplatesDistinctPorts(t *testing.T) {
+	utils.InitLogger()
+
+	ports := map[int]bool{}
+	for i := 0; i < 5; i++ {
+		sess, err := NewSessionWithConfig(SessionConfig{
+			Command: "sleep",
+			Args:    []string{"10"},
+			Env:     map[string]string{"PORT": "{{port_free}}"},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create session %d: %v", i, err)
+		}
+		defer sess.Close()
+		if ports[sess.FreePort] {
+			t.Fatalf("Expected distinct ports, got %d twice", sess.FreePort)
+		}
+		ports[sess.FreePort] = true
+		if sess.TmpDir != "" {
+			t.Errorf("Expected no tmpdir when none is asked for, got %q", sess.TmpDir)
+		}
+	}
+}
+
+func TestValidateEnvTemplates(t *testing.T) {
+	tests := []struct {
+		value string
+		ok    bool
+	}{
+		{"plain", true},
+		{"{{session_id}}", true},
+		{"{{session_tmpdir}}/a:{{port_free}}", true},
+		{"{ not a placeholder }", true},
+		{"{{sesion_id}}", false},
+		{"{{ session_id }}", false},
+		{"{{}}", false},
+	}
+	for _, tt := range tests {
+		err := ValidateEnvTemplates(map[string]string{"V": tt.value})
+		if (err == nil) != tt.ok {
+			t.Errorf("ValidateEnvTemplates(%q) = %v, want ok=%v", tt.value, err, tt.ok)
+		}
+	}
+
+	// An unknown placeholder fails the launch before anything is set aside
+	if _, err := NewSessionWithConfig(SessionConfig{
+		Command: "true",
+		Env:     map[string]string{"DIR": "{{session_tmpdir}}", "BAD": "{{port}}"},
+	}); err == nil || !strings.Contains(err.Error(), "{{port}}") {
+		t.Errorf("Expected the unknown placeholder to be named, got %v", err)
+	}
+}
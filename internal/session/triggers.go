@@ -0,0 +1,454 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+)
+
+// Limits on output triggers. A trigger fires at most once per cooldown;
+// matches in between are only counted, so a storm of matching output can't
+// keep writing snapshots.
+const (
+	MaxTriggers     = 16
+	TriggerCooldown = time.Second
+)
+
+const (
+	// triggerScanInterval is the least time between two scans of new
+	// output, so a burst is scanned in one go
+	triggerScanInterval = 50 * time.Millisecond
+	// maxTriggerScan is how much output one scan reads; when more is
+	// waiting, the oldest is skipped
+	maxTriggerScan = 1 << 20
+	// maxTriggerLine is how much of an unfinished line is kept so a
+	// pattern can match across reads
+	maxTriggerLine = 4096
+	// maxTriggerMatch is how much of the matched text an event keeps
+	maxTriggerMatch = 200
+)
+
+// Trigger actions. A signal action is TriggerSignalPrefix followed by a
+// signal name, such as "signal:SIGTERM", sent to the foreground process
+// group.
+const (
+	TriggerSnapshot     = "snapshot" // Write the plain screen to a file in the trigger's directory
+	TriggerExport       = "export"   // Write a session bundle to the trigger's directory
+	TriggerStop         = "stop"     // Stop the session, after the other actions
+	TriggerNotify       = "notify"   // Copy the firing to the manager's activity log and notifier
+	TriggerSignalPrefix = "signal:"
+)
+
+// Errors from adding and removing triggers
+var (
+	ErrTooManyTriggers = fmt.Errorf("a session can have at most %d triggers", MaxTriggers)
+	ErrTriggerNotFound = errors.New("no such trigger on this session")
+)
+
+// escapeSequence matches the terminal control sequences left out of the
+// text triggers match: CSI, OSC and DCS-style strings, and two-byte escapes
+var escapeSequence = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b[\]PX^_][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[ -/]*[0-~]`)
+
+// TriggerConfig describes a trigger to add to a session
+type TriggerConfig struct {
+	Pattern string   // Regular expression matched against each line of new output
+	Actions []string // Done in order when the pattern matches; see the Trigger* constants
+	OneShot bool     // Remove the trigger once it has fired
+	Dir     string   // Where snapshot and export write
+	// Stop is called, in its own goroutine, for the stop action, so the
+	// owner can remove the session; nil closes the session in place
+	Stop func()
+}
+
+// TriggerInfo describes a trigger and how often it fired
+type TriggerInfo struct {
+	ID         string     `json:"id"`
+	Pattern    string     `json:"pattern"`
+	Actions    []string   `json:"actions"`
+	OneShot    bool       `json:"one_shot"`
+	Dir        string     `json:"dir,omitempty"`
+	Created    time.Time  `json:"created"`
+	Fired      int        `json:"fired"`
+	LastFired  *time.Time `json:"last_fired,omitempty"`
+	Suppressed int        `json:"suppressed"` // Matches within the cooldown that didn't fire
+}
+
+// ValidateTriggerActions checks a trigger's action list: at least one
+// action, each known and listed once
+func ValidateTriggerActions(actions []string) error {
+	if len(actions) == 0 {
+		return fmt.Errorf("at least one action is required")
+	}
+	seen := make(map[string]bool, len(actions))
+	for _, action := range actions {
+		if seen[action] {
+			return fmt.Errorf("action %q is listed twice", action)
+		}
+		seen[action] = true
+		switch action {
+		case TriggerSnapshot, TriggerExport, TriggerStop, TriggerNotify:
+		default:
+			name, ok := strings.CutPrefix(action, TriggerSignalPrefix)
+			if !ok {
+				return fmt.Errorf("unknown action %q, valid actions: %s, %s, %s, %s and %sNAME",
+					action, TriggerSnapshot, TriggerExport, TriggerStop, TriggerNotify, TriggerSignalPrefix)
+			}
+			if _, err := terminal.ParseSignal(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type trigger struct {
+	info TriggerInfo
+	re   *regexp.Regexp
+	stop func()
+}
+
+// triggerSet holds a session's triggers and the watcher that scans new
+// output for them
+type triggerSet struct {
+	mu       sync.Mutex
+	triggers []*trigger
+	nextID   int
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// AddTrigger adds a trigger that watches the session's output from now on.
+// The first trigger starts a watcher that scans new output each time the
+// screen changes, stopped when the session closes.
+func (s *Session) AddTrigger(cfg TriggerConfig) (TriggerInfo, error) {
+	re, err := regexp.Compile(cfg.Pattern)
+	if err != nil {
+		return TriggerInfo{}, fmt.Errorf("invalid pattern: %w", err)
+	}
+	if err := ValidateTriggerActions(cfg.Actions); err != nil {
+		return TriggerInfo{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return TriggerInfo{}, ErrSessionClosed
+	}
+	if s.Imported != "" {
+		return TriggerInfo{}, ErrImported
+	}
+	ts := s.triggers
+	if ts == nil {
+		ts = &triggerSet{stop: make(chan struct{}), done: make(chan struct{})}
+		s.triggers = ts
+		go ts.run(s, s.Buffer.RawDataEnd())
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if len(ts.triggers) >= MaxTriggers {
+		return TriggerInfo{}, ErrTooManyTriggers
+	}
+	ts.nextID++
+	t := &trigger{
+		info: TriggerInfo{
+			ID:      fmt.Sprintf("t%d", ts.nextID),
+			Pattern: cfg.Pattern,
+			Actions: append([]string(nil), cfg.Actions...),
+			OneShot: cfg.OneShot,
+			Dir:     cfg.Dir,
+			Created: s.clock.Now(),
+		},
+		re:   re,
+		stop: cfg.Stop,
+	}
+	ts.triggers = append(ts.triggers, t)
+
+	slog.Debug("Trigger added",
+		slog.String("session_id", s.ID),
+		slog.String("trigger_id", t.info.ID),
+		slog.String("pattern", cfg.Pattern),
+		slog.Any("actions", cfg.Actions),
+	)
+	return t.info, nil
+}
+
+// Triggers returns the session's triggers, oldest first
+func (s *Session) Triggers() []TriggerInfo {
+	s.mu.RLock()
+	ts := s.triggers
+	s.mu.RUnlock()
+
+	infos := []TriggerInfo{}
+	if ts == nil {
+		return infos
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for _, t := range ts.triggers {
+		infos = append(infos, t.info)
+	}
+	return infos
+}
+
+// RemoveTrigger removes a trigger and returns what it was
+func (s *Session) RemoveTrigger(id string) (TriggerInfo, error) {
+	s.mu.RLock()
+	ts := s.triggers
+	s.mu.RUnlock()
+	if ts == nil {
+		return TriggerInfo{}, fmt.Errorf("%w: %s", ErrTriggerNotFound, id)
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for i, t := range ts.triggers {
+		if t.info.ID == id {
+			ts.triggers = append(ts.triggers[:i:i], ts.triggers[i+1:]...)
+			return t.info, nil
+		}
+	}
+	return TriggerInfo{}, fmt.Errorf("%w: %s", ErrTriggerNotFound, id)
+}
+
+// stopTriggers stops the trigger watcher when the session closes
+func (s *Session) stopTriggers() {
+	s.mu.Lock()
+	ts := s.triggers
+	s.triggers = nil
+	s.mu.Unlock()
+	if ts != nil {
+		close(ts.stop)
+		<-ts.done
+	}
+}
+
+// run scans the output written after offset, each time the screen changes
+// and at most once per triggerScanInterval, until stopped. Output is matched
+// line by line with control sequences left out; an unfinished line is
+// matched too, and again once finished by the triggers it didn't match.
+func (ts *triggerSet) run(s *Session, offset int64) {
+	defer close(ts.done)
+
+	timer := time.NewTimer(triggerScanInterval)
+	defer timer.Stop()
+
+	var line []byte
+	matched := map[*trigger]bool{} // Triggers that fired on the unfinished line
+	for {
+		// Listen before reading so output written meanwhile isn't missed
+		changed := s.Buffer.Changed()
+		if end := s.Buffer.RawDataEnd(); end-offset > maxTriggerScan {
+			offset = end - maxTriggerScan
+			line = nil
+			clear(matched)
+		}
+		chunk, err := s.Buffer.RawDataSince(offset, maxTriggerScan)
+		if err == nil && len(chunk.Data) > 0 {
+			if chunk.Truncated {
+				line = nil
+				clear(matched)
+			}
+			offset = chunk.Next
+			data := append(line, chunk.Data...)
+			for {
+				i := bytes.IndexByte(data, '\n')
+				if i < 0 {
+					break
+				}
+				ts.match(s, data[:i], matched)
+				clear(matched)
+				data = data[i+1:]
+			}
+			if len(data) > maxTriggerLine {
+				data = data[len(data)-maxTriggerLine:]
+			}
+			if len(data) > 0 {
+				ts.match(s, data, matched)
+			}
+			line = append([]byte(nil), data...)
+		}
+
+		timer.Reset(triggerScanInterval)
+		select {
+		case <-ts.stop:
+			return
+		case <-timer.C:
+		}
+		select {
+		case <-ts.stop:
+			return
+		case <-changed:
+		}
+	}
+}
+
+// match fires the triggers whose pattern matches raw, one line of output,
+// skipping and then adding to those in matched
+func (ts *triggerSet) match(s *Session, raw []byte, matched map[*trigger]bool) {
+	ts.mu.Lock()
+	triggers := append([]*trigger(nil), ts.triggers...)
+	ts.mu.Unlock()
+	if len(triggers) == 0 {
+		return
+	}
+
+	text := escapeSequence.ReplaceAllString(string(raw), "")
+	text = strings.ReplaceAll(text, "\r", "")
+	for _, t := range triggers {
+		if matched[t] {
+			continue
+		}
+		loc := t.re.FindStringIndex(text)
+		if loc == nil {
+			continue
+		}
+		matched[t] = true
+		ts.fire(s, t, text[loc[0]:loc[1]])
+	}
+}
+
+// fire does a trigger's actions for a match, unless the trigger fired less
+// than TriggerCooldown ago or has been removed, and records what it did as
+// a trigger event
+func (ts *triggerSet) fire(s *Session, t *trigger, match string) {
+	now := s.clock.Now()
+	ts.mu.Lock()
+	i := 0
+	for i < len(ts.triggers) && ts.triggers[i] != t {
+		i++
+	}
+	if i == len(ts.triggers) {
+		ts.mu.Unlock()
+		return
+	}
+	if t.info.LastFired != nil && now.Sub(*t.info.LastFired) < TriggerCooldown {
+		t.info.Suppressed++
+		ts.mu.Unlock()
+		return
+	}
+	t.info.Fired++
+	t.info.LastFired = &now
+	if t.info.OneShot {
+		ts.triggers = append(ts.triggers[:i:i], ts.triggers[i+1:]...)
+	}
+	info := t.info
+	ts.mu.Unlock()
+
+	scrub, _ := s.redactor()
+	if len(match) > maxTriggerMatch {
+		match = match[:maxTriggerMatch]
+	}
+
+	results := make([]map[string]interface{}, 0, len(info.Actions))
+	var stop, notify bool
+	for _, action := range info.Actions {
+		result := map[string]interface{}{"action": action}
+		var err error
+		switch action {
+		case TriggerSnapshot:
+			var path string
+			if path, err = s.writeSnapshot(info.Dir, scrub); err == nil {
+				result["path"] = path
+			}
+		case TriggerExport:
+			var path string
+			if path, err = s.writeBundle(info.Dir); err == nil {
+				result["path"] = path
+			}
+		case TriggerStop:
+			// Last, so the other actions see the session as it was
+			stop = true
+			continue
+		case TriggerNotify:
+			notify = true
+		default:
+			name := strings.TrimPrefix(action, TriggerSignalPrefix)
+			var sig terminal.Signal
+			if sig, err = terminal.ParseSignal(name); err == nil {
+				var pid int
+				if pid, err = s.Signal(sig, true); err == nil {
+					result["pid"] = pid
+				}
+			}
+		}
+		if err != nil {
+			result["error"] = err.Error()
+		}
+		results = append(results, result)
+	}
+	if stop {
+		results = append(results, map[string]interface{}{"action": TriggerStop})
+	}
+
+	s.recordEvent(EventTrigger, map[string]interface{}{
+		"trigger_id": info.ID,
+		"pattern":    info.Pattern,
+		"match":      string(scrub([]byte(match))),
+		"actions":    results,
+		"fired":      info.Fired,
+		"one_shot":   info.OneShot,
+		"notify":     notify,
+	})
+	slog.Info("Trigger fired",
+		slog.String("session_id", s.ID),
+		slog.String("trigger_id", info.ID),
+		slog.String("pattern", info.Pattern),
+		slog.Any("actions", info.Actions),
+	)
+
+	if stop {
+		if t.stop != nil {
+			go t.stop()
+		} else {
+			go s.CloseGracefully(0)
+		}
+	}
+}
+
+// writeSnapshot writes the plain screen, scrubbed, to a new file in dir
+func (s *Session) writeSnapshot(dir string, scrub func([]byte) []byte) (string, error) {
+	screen, err := s.Buffer.Render("plain")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	id := s.ID
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	f, err := os.CreateTemp(dir, "session-"+id+"-snapshot-*.txt")
+	if err != nil {
+		return "", fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	_, err = f.Write(scrub([]byte(screen)))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// writeBundle writes a session bundle of the default size into dir
+func (s *Session) writeBundle(dir string) (string, error) {
+	bundle, err := s.Bundle(DefaultBundleBytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to build session bundle: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create export directory: %w", err)
+	}
+	return bundle.WriteDir(dir)
+}
@@ -0,0 +1,171 @@
+//go:build !windows
+
+package session
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+)
+
+// triggerEvents returns the session's trigger events
+func triggerEvents(sess *Session) []Event {
+	var events []Event
+	for _, event := range sess.Events(0, 0).Events {
+		if event.Type == EventTrigger {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// waitForTriggerEvents waits until the session has recorded n trigger events
+func waitForTriggerEvents(t *testing.T, sess *Session, n int) []Event {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		events := triggerEvents(sess)
+		if len(events) >= n || time.Now().After(deadline) {
+			if len(events) < n {
+				t.Fatalf("Expected %d trigger events, got %+v", n, events)
+			}
+			return events
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSession_Triggers(t *testing.T) {
+	utils.InitLogger()
+
+	sess, err := NewSession("sleep", []string{"10"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer sess.Close()
+
+	// Output from before the trigger was added is never matched
+	sess.Buffer.Write([]byte("panic: old\r\n"))
+	dir := t.TempDir()
+	info, err := sess.AddTrigger(TriggerConfig{Pattern: `panic: \w+`, Actions: []string{TriggerSnapshot, TriggerNotify}, Dir: dir})
+	if err != nil {
+		t.Fatalf("AddTrigger failed: %v", err)
+	}
+	if info.ID != "t1" || info.Fired != 0 {
+		t.Errorf("Unexpected trigger: %+v", info)
+	}
+
+	// Control sequences are left out, and a line split across writes
+	// still matches once
+	sess.Buffer.Write([]byte("\x1b[31mpan"))
+	time.Sleep(2 * triggerScanInterval)
+	sess.Buffer.Write([]byte("ic:\x1b[0m boom"))
+	events := waitForTriggerEvents(t, sess, 1)
+	sess.Buffer.Write([]byte(" more\r\n"))
+	data := events[0].Data
+	if data["trigger_id"] != "t1" || data["match"] != "panic: boom" || data["notify"] != true {
+		t.Errorf("Unexpected trigger event: %+v", data)
+	}
+	actions := data["actions"].([]map[string]interface{})
+	if len(actions) != 2 || actions[0]["action"] != TriggerSnapshot || actions[1]["action"] != TriggerNotify {
+		t.Fatalf("Unexpected actions: %+v", actions)
+	}
+	snapshot, err := os.ReadFile(actions[0]["path"].(string))
+	if err != nil || !strings.Contains(string(snapshot), "panic: boom") {
+		t.Errorf("Expected a snapshot of the screen, got %q (%v)", snapshot, err)
+	}
+
+	// Matches within the cooldown are counted, not fired
+	sess.Buffer.Write([]byte("panic: again\r\n"))
+	deadline := time.Now().Add(2 * time.Second)
+	for sess.Triggers()[0].Suppressed == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := sess.Triggers()[0]; got.Fired != 1 || got.Suppressed != 1 || got.LastFired == nil {
+		t.Errorf("Expected one firing and one suppressed match, got %+v", got)
+	}
+
+	// A one-shot trigger goes away once it fires
+	if _, err := sess.AddTrigger(TriggerConfig{Pattern: "done", Actions: []string{TriggerNotify}, OneShot: true}); err != nil {
+		t.Fatalf("AddTrigger failed: %v", err)
+	}
+	sess.Buffer.Write([]byte("done\r\ndone\r\n"))
+	waitForTriggerEvents(t, sess, 2)
+	time.Sleep(2 * triggerScanInterval)
+	if triggers := sess.Triggers(); len(triggers) != 1 || triggers[0].ID != "t1" {
+		t.Errorf("Expected only t1 left, got %+v", triggers)
+	}
+	if n := len(triggerEvents(sess)); n != 2 {
+		t.Errorf("Expected the one-shot trigger to fire once, got %d trigger events", n)
+	}
+
+	if _, err := sess.RemoveTrigger("t2"); !errors.Is(err, ErrTriggerNotFound) {
+		t.Errorf("Expected the fired one-shot trigger to be gone, got %v", err)
+	}
+	if _, err := sess.RemoveTrigger("t1"); err != nil {
+		t.Errorf("RemoveTrigger failed: %v", err)
+	}
+	if triggers := sess.Triggers(); len(triggers) != 0 {
+		t.Errorf("Expected no triggers, got %+v", triggers)
+	}
+}
+
+func TestSession_TriggerStop(t *testing.T) {
+	utils.InitLogger()
+
+	sess, err := NewSession("sleep", []string{"10"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer sess.Close()
+
+	stopped := make(chan struct{})
+	_, err = sess.AddTrigger(TriggerConfig{
+		Pattern: "fatal",
+		Actions: []string{TriggerStop, TriggerNotify},
+		Stop:    func() { close(stopped) },
+	})
+	if err != nil {
+		t.Fatalf("AddTrigger failed: %v", err)
+	}
+	sess.Buffer.Write([]byte("fatal error\r\n"))
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the trigger to stop the session")
+	}
+
+	// Stop is done after the other actions, whatever the order given
+	actions := waitForTriggerEvents(t, sess, 1)[0].Data["actions"].([]map[string]interface{})
+	if len(actions) != 2 || actions[0]["action"] != TriggerNotify || actions[1]["action"] != TriggerStop {
+		t.Errorf("Expected stop last, got %+v", actions)
+	}
+}
+
+func TestValidateTriggerActions(t *testing.T) {
+	valid := [][]string{
+		{TriggerSnapshot},
+		{TriggerExport, TriggerStop, TriggerNotify},
+		{"signal:SIGTERM", TriggerStop},
+	}
+	for _, actions := range valid {
+		if err := ValidateTriggerActions(actions); err != nil {
+			t.Errorf("Expected %v to be valid, got %v", actions, err)
+		}
+	}
+	invalid := [][]string{
+		nil,
+		{"reboot"},
+		{"signal:SIGNOPE"},
+		{TriggerStop, TriggerStop},
+	}
+	for _, actions := range invalid {
+		if err := ValidateTriggerActions(actions); err == nil {
+			t.Errorf("Expected %v to be refused", actions)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package stress
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/session"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// pollInterval is how often the checks below look again while waiting for
+// the system to settle
+const pollInterval = 50 * time.Millisecond
+
+// CheckSessions verifies that list_sessions reports exactly the sessions
+// the manager holds. Run it while no tool calls are in flight.
+func CheckSessions(ctx context.Context, m *session.Manager, call Caller) error {
+	result, err := call(ctx, "list_sessions", map[string]interface{}{})
+	if err != nil {
+		return fmt.Errorf("list_sessions failed: %w", err)
+	}
+	if result.IsError || len(result.Content) == 0 {
+		return fmt.Errorf("list_sessions returned an error result")
+	}
+	text, _ := result.Content[0].(mcp.TextContent)
+	var resp struct {
+		Sessions []struct {
+			ID string `json:"id"`
+		} `json:"sessions"`
+	}
+	if err := json.Unmarshal([]byte(text.Text), &resp); err != nil {
+		return fmt.Errorf("failed to decode list_sessions: %w", err)
+	}
+
+	listed := make([]string, 0, len(resp.Sessions))
+	for _, s := range resp.Sessions {
+		listed = append(listed, s.ID)
+	}
+	var held []string
+	for _, info := range m.ListSessions() {
+		held = append(held, info.ID)
+		if _, err := m.GetSession(info.ID); err != nil {
+			return fmt.Errorf("listed session %s can't be fetched: %w", info.ID, err)
+		}
+	}
+	sort.Strings(listed)
+	sort.Strings(held)
+	if strings.Join(listed, ",") != strings.Join(held, ",") {
+		return fmt.Errorf("list_sessions reports %v but the manager holds %v", listed, held)
+	}
+	return nil
+}
+
+// CheckProcesses waits up to settle for every process group in pids to be
+// gone, reaped included
+func CheckProcesses(pids []int, settle time.Duration) error {
+	deadline := time.Now().Add(settle)
+	for {
+		var alive []int
+		for _, pid := range pids {
+			if processGroupAlive(pid) {
+				alive = append(alive, pid)
+			}
+		}
+		if len(alive) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d of %d process groups still exist: %v", len(alive), len(pids), alive)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// CheckGoroutines waits up to settle for the goroutine count to drop back
+// to baseline. The error carries every goroutine's stack.
+func CheckGoroutines(baseline int, settle time.Duration) error {
+	deadline := time.Now().Add(settle)
+	for {
+		n := runtime.NumGoroutine()
+		if n <= baseline {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			buf = buf[:runtime.Stack(buf, true)]
+			return fmt.Errorf("%d goroutines leaked (%d running, baseline %d):\n%s", n-baseline, n, baseline, buf)
+		}
+		time.Sleep(pollInterval)
+	}
+}
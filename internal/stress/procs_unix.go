@@ -0,0 +1,16 @@
+//go:build !windows
+
+package stress
+
+import (
+	"errors"
+	"syscall"
+)
+
+// processGroupAlive reports whether any process, zombies included, is left
+// in the group led by pid. Session processes start in their own session, so
+// the group also covers anything they spawned.
+func processGroupAlive(pid int) bool {
+	err := syscall.Kill(-pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
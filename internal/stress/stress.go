@@ -0,0 +1,248 @@
+// Package stress drives the tool handlers the way a runaway agent might:
+// many workers creating, streaming into, resizing, restarting and stopping
+// sessions at random, so leaks and races show up under churn. Runs are
+// seeded, and the same seed replays the same sequence of tool calls per
+// worker.
+package stress
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/tools"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Caller invokes a tool by name, as the MCP server would
+type Caller func(ctx context.Context, tool string, args map[string]interface{}) (*mcp.CallToolResult, error)
+
+// HandlerCaller calls tools directly on h
+func HandlerCaller(h *tools.Handlers) Caller {
+	return h.Registry().Call
+}
+
+// Command is a program the workload launches
+type Command struct {
+	Name string
+	Args []string
+}
+
+// DefaultCommands mix a heavy output stream, an idle reader and a program
+// that exits on its own
+var DefaultCommands = []Command{
+	{Name: "yes", Args: []string{"stress output line"}},
+	{Name: "cat"},
+	{Name: "sh", Args: []string{"-c", "echo started; sleep 1"}},
+}
+
+// Config sets the shape of a run
+type Config struct {
+	Workers     int
+	Duration    time.Duration // Stop after this long; 0 runs until Ops or ctx ends
+	Ops         int           // Tool calls per worker; 0 means unbounded
+	Seed        int64         // Worker i uses Seed+i
+	MaxSessions int           // Live sessions across workers before launches pause; 0 means 2 per worker
+	Commands    []Command     // Defaults to DefaultCommands
+}
+
+// Report summarizes a run
+type Report struct {
+	Seed   int64
+	Calls  map[string]int64 // Tool calls by tool
+	Errors map[string]int64 // Failed calls by tool; expected under churn
+	PIDs   []int            // Every process started, each leading its own process group
+}
+
+func (r *Report) String() string {
+	var calls, errs int64
+	for _, n := range r.Calls {
+		calls += n
+	}
+	for _, n := range r.Errors {
+		errs += n
+	}
+	return fmt.Sprintf("seed %d: %d calls, %d errors, %d processes", r.Seed, calls, errs, len(r.PIDs))
+}
+
+// Run executes the workload and returns once every worker has stopped.
+// Sessions it started are left running so the caller can check them.
+func Run(ctx context.Context, cfg Config, call Caller) *Report {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.MaxSessions <= 0 {
+		cfg.MaxSessions = 2 * cfg.Workers
+	}
+	if len(cfg.Commands) == 0 {
+		cfg.Commands = DefaultCommands
+	}
+	if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+
+	r := &run{
+		cfg:    cfg,
+		call:   call,
+		calls:  make(map[string]int64),
+		errors: make(map[string]int64),
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Workers; i++ {
+		wg.Add(1)
+		go func(rng *rand.Rand) {
+			defer wg.Done()
+			r.worker(ctx, rng)
+		}(rand.New(rand.NewSource(cfg.Seed + int64(i))))
+	}
+	wg.Wait()
+
+	sort.Ints(r.pids)
+	return &Report{Seed: cfg.Seed, Calls: r.calls, Errors: r.errors, PIDs: r.pids}
+}
+
+// run is the state workers share: the sessions any of them may act on and
+// the tallies for the report
+type run struct {
+	cfg  Config
+	call Caller
+
+	mu       sync.Mutex
+	sessions []string
+	pids     []int
+	calls    map[string]int64
+	errors   map[string]int64
+}
+
+func (r *run) worker(ctx context.Context, rng *rand.Rand) {
+	for op := 0; r.cfg.Ops == 0 || op < r.cfg.Ops; op++ {
+		if ctx.Err() != nil {
+			return
+		}
+		r.step(ctx, rng)
+	}
+}
+
+// step makes one random tool call. Sessions are picked from the shared
+// list, so workers restart and stop sessions others are streaming from.
+func (r *run) step(ctx context.Context, rng *rand.Rand) {
+	id, live := r.pick(rng)
+	roll := rng.Intn(100)
+	if id == "" || (roll < 15 && live < r.cfg.MaxSessions) {
+		if live >= r.cfg.MaxSessions {
+			r.invoke(ctx, "list_sessions", map[string]interface{}{})
+			return
+		}
+		cmd := r.cfg.Commands[rng.Intn(len(r.cfg.Commands))]
+		resp := r.invoke(ctx, "launch_app", map[string]interface{}{
+			"command": cmd.Name,
+			"args":    cmd.Args,
+		})
+		if sid, ok := resp["session_id"].(string); ok {
+			r.mu.Lock()
+			r.sessions = append(r.sessions, sid)
+			r.mu.Unlock()
+		}
+		return
+	}
+
+	// Half the session calls give up at once when the session is busy
+	wait := rng.Intn(2) == 0
+	switch {
+	case roll < 40:
+		formats := []string{"plain", "raw", "ansi"}
+		r.invoke(ctx, "view_screen", map[string]interface{}{
+			"session_id": id,
+			"format":     formats[rng.Intn(len(formats))],
+			"wait":       wait,
+		})
+	case roll < 55:
+		keys := []string{"hello", "Enter", "Ctrl+C", "Up", "q"}
+		r.invoke(ctx, "send_keys", map[string]interface{}{
+			"session_id": id,
+			"keys":       keys[rng.Intn(len(keys))],
+			"wait":       wait,
+		})
+	case roll < 70:
+		r.invoke(ctx, "resize_terminal", map[string]interface{}{
+			"session_id": id,
+			"width":      20 + rng.Intn(180),
+			"height":     5 + rng.Intn(55),
+			"wait":       wait,
+		})
+	case roll < 78:
+		r.invoke(ctx, "restart_app", map[string]interface{}{
+			"session_id": id,
+			"wait":       wait,
+		})
+	case roll < 88:
+		resp := r.invoke(ctx, "stop_app", map[string]interface{}{
+			"session_id": id,
+			"wait":       wait,
+		})
+		if resp != nil {
+			r.forget(id)
+		}
+	case roll < 94:
+		r.invoke(ctx, "get_session_info", map[string]interface{}{"session_id": id})
+	default:
+		r.invoke(ctx, "list_sessions", map[string]interface{}{})
+	}
+}
+
+// pick returns a random known session, or "" if there are none, and how
+// many are known
+func (r *run) pick(rng *rand.Rand) (string, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.sessions) == 0 {
+		return "", 0
+	}
+	return r.sessions[rng.Intn(len(r.sessions))], len(r.sessions)
+}
+
+func (r *run) forget(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, sid := range r.sessions {
+		if sid == id {
+			r.sessions = append(r.sessions[:i], r.sessions[i+1:]...)
+			return
+		}
+	}
+}
+
+// invoke calls a tool and tallies it. It returns the decoded response of a
+// successful call, or nil if the call failed.
+func (r *run) invoke(ctx context.Context, tool string, args map[string]interface{}) map[string]interface{} {
+	result, err := r.call(ctx, tool, args)
+	failed := err != nil || result == nil || result.IsError
+
+	var resp map[string]interface{}
+	if !failed && len(result.Content) > 0 {
+		if text, ok := result.Content[0].(mcp.TextContent); ok {
+			json.Unmarshal([]byte(text.Text), &resp)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls[tool]++
+	if failed {
+		r.errors[tool]++
+		return nil
+	}
+	if pid, ok := resp["pid"].(float64); ok && pid > 0 {
+		r.pids = append(r.pids, int(pid))
+	}
+	if resp == nil {
+		resp = map[string]interface{}{}
+	}
+	return resp
+}
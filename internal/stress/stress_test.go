@@ -0,0 +1,78 @@
+package stress
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// fakeCaller records the calls made and answers launches with new sessions
+func fakeCaller(log *[]string) Caller {
+	next := 0
+	return func(ctx context.Context, tool string, args map[string]interface{}) (*mcp.CallToolResult, error) {
+		*log = append(*log, fmt.Sprintf("%s %v", tool, args))
+		text := `{"success": true}`
+		switch tool {
+		case "launch_app":
+			next++
+			text = fmt.Sprintf(`{"session_id": "s%d", "pid": %d, "success": true}`, next, 1000+next)
+		case "restart_app":
+			text = fmt.Sprintf(`{"success": true, "pid": %d}`, 2000+len(*log))
+		}
+		return &mcp.CallToolResult{Content: []mcp.Content{mcp.TextContent{Type: "text", Text: text}}}, nil
+	}
+}
+
+func TestRun_SeedReplaysCalls(t *testing.T) {
+	var first, second, other []string
+	cfg := Config{Workers: 1, Ops: 300, Seed: 42}
+	report := Run(context.Background(), cfg, fakeCaller(&first))
+	Run(context.Background(), cfg, fakeCaller(&second))
+	cfg.Seed = 43
+	Run(context.Background(), cfg, fakeCaller(&other))
+
+	if strings.Join(first, "\n") != strings.Join(second, "\n") {
+		t.Error("Expected the same seed to replay the same calls")
+	}
+	if strings.Join(first, "\n") == strings.Join(other, "\n") {
+		t.Error("Expected a different seed to make different calls")
+	}
+
+	var total int64
+	for _, n := range report.Calls {
+		total += n
+	}
+	if total != 300 || len(first) != 300 {
+		t.Errorf("Expected 300 calls, got %d (%d recorded)", total, len(first))
+	}
+	if report.Calls["launch_app"] == 0 || report.Calls["stop_app"] == 0 || report.Calls["restart_app"] == 0 {
+		t.Errorf("Expected launches, stops and restarts, got %v", report.Calls)
+	}
+	if len(report.Errors) != 0 {
+		t.Errorf("Expected no errors, got %v", report.Errors)
+	}
+	if int64(len(report.PIDs)) != report.Calls["launch_app"]+report.Calls["restart_app"] {
+		t.Errorf("Expected a PID per launch and restart, got %d", len(report.PIDs))
+	}
+}
+
+func TestRun_CapsLiveSessions(t *testing.T) {
+	var calls []string
+	Run(context.Background(), Config{Workers: 1, Ops: 500, Seed: 7, MaxSessions: 3}, fakeCaller(&calls))
+
+	live := 0
+	for _, c := range calls {
+		switch {
+		case strings.HasPrefix(c, "launch_app"):
+			live++
+		case strings.HasPrefix(c, "stop_app"):
+			live--
+		}
+		if live > 3 {
+			t.Fatalf("Expected at most 3 live sessions, got %d", live)
+		}
+	}
+}
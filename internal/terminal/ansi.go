@@ -2,18 +2,31 @@ package terminal
 
 import (
 	"bytes"
+	"fmt"
+	"log/slog"
 	"strconv"
 	"strings"
 	"sync"
+	"unicode/utf8"
 )
 
-// Buffer pool for escape sequence parsing to reduce allocations
-var escapeBufferPool = sync.Pool{
+// Parser pool, so sessions that come and go reuse parsers and their escape
+// buffers rather than allocating new ones
+var parserPool = sync.Pool{
 	New: func() interface{} {
-		return &bytes.Buffer{}
+		return &ANSIParser{escapeBuffer: &bytes.Buffer{}}
 	},
 }
 
+// maxPooledEscapeBuffer is the largest escape buffer kept when a parser goes
+// back to the pool; one grown by a huge sequence is dropped instead
+const maxPooledEscapeBuffer = 4096
+
+// maxStringPayload caps how much of an OSC or DCS payload the parser keeps.
+// The rest of a longer one, such as a sixel image, is dropped while the
+// parser waits for its terminator.
+const maxStringPayload = 4096
+
 // cursorState holds saved cursor position and attributes
 type cursorState struct {
 	x, y         int
@@ -29,6 +42,12 @@ type ANSIParser struct {
 	currentBG    Color
 	currentAttrs Attributes
 	savedCursor  *cursorState // Per-parser cursor save state
+	diag         diagnostics  // Sequences received but not acted on
+	stringEsc    bool         // An ESC arrived inside an OSC or DCS string
+	stringLong   bool         // The OSC or DCS payload outgrew maxStringPayload
+	utf8Buf      [utf8.UTFMax]byte
+	utf8Len      int // Bytes of a UTF-8 sequence received so far
+	utf8Need     int // Length of the UTF-8 sequence being received; 0 when none is
 }
 
 type parserState int
@@ -42,24 +61,39 @@ const (
 	stateCharset // Character set selection
 )
 
+// NewANSIParser returns a parser that draws into buffer, taken from the pool
+// when one is available
 func NewANSIParser(buffer *ScreenBuffer) *ANSIParser {
-	buf := escapeBufferPool.Get().(*bytes.Buffer)
-	buf.Reset() // Ensure buffer is clean
-	return &ANSIParser{
+	p := parserPool.Get().(*ANSIParser)
+	p.reset(buffer)
+	return p
+}
+
+// Release resets the parser and returns it to the pool. The parser must not
+// be used afterwards; releasing it again does nothing.
+func (p *ANSIParser) Release() {
+	if p.buffer == nil {
+		return
+	}
+	p.reset(nil)
+	parserPool.Put(p)
+}
+
+// reset returns the parser to its initial state, drawing into buffer. Nothing
+// from the previous buffer survives: not a half-read sequence, the current
+// attributes, the saved cursor or the diagnostics.
+func (p *ANSIParser) reset(buffer *ScreenBuffer) {
+	escapeBuffer := p.escapeBuffer
+	if escapeBuffer == nil || escapeBuffer.Cap() > maxPooledEscapeBuffer {
+		escapeBuffer = &bytes.Buffer{}
+	}
+	escapeBuffer.Reset()
+	*p = ANSIParser{
 		buffer:       buffer,
 		state:        stateNormal,
+		escapeBuffer: escapeBuffer,
 		currentFG:    Color{Default: true},
 		currentBG:    Color{Default: true},
-		escapeBuffer: buf,
-	}
-}
-
-// Release returns the escape buffer to the pool
-func (p *ANSIParser) Release() {
-	if p.escapeBuffer != nil {
-		p.escapeBuffer.Reset()
-		escapeBufferPool.Put(p.escapeBuffer)
-		p.escapeBuffer = nil
 	}
 }
 
@@ -83,18 +117,30 @@ func (p *ANSIParser) Parse(data []byte) {
 }
 
 func (p *ANSIParser) handleNormal(b byte) {
+	if p.utf8Need > 0 {
+		if b&0xC0 == 0x80 {
+			p.utf8Buf[p.utf8Len] = b
+			p.utf8Len++
+			if p.utf8Len == p.utf8Need {
+				p.utf8Need = 0
+				r, _ := utf8.DecodeRune(p.utf8Buf[:p.utf8Len])
+				p.putRune(r) // Overlong forms and surrogates decode to U+FFFD
+			}
+			return
+		}
+		// The sequence was cut short; b starts something new
+		p.utf8Need = 0
+		p.putRune(utf8.RuneError)
+	}
+
 	switch b {
 	case 0x1B: // ESC
 		p.state = stateEscape
 		p.escapeBuffer.Reset()
 	case '\r': // Carriage return
 		p.buffer.MoveCursor(0, p.buffer.cursorY)
-	case '\n': // Line feed
-		p.buffer.cursorY++
-		if p.buffer.cursorY >= p.buffer.height {
-			p.buffer.ScrollUp()
-			p.buffer.cursorY = p.buffer.height - 1
-		}
+	case '\n', '\v', '\f': // Line feed; vertical tab and form feed act the same
+		p.lineFeed()
 	case '\t': // Tab
 		// Move to next tab stop (every 8 columns)
 		newX := ((p.buffer.cursorX / 8) + 1) * 8
@@ -102,22 +148,98 @@ func (p *ANSIParser) handleNormal(b byte) {
 			newX = p.buffer.width - 1
 		}
 		p.buffer.MoveCursor(newX, p.buffer.cursorY)
+	case 0x07: // Bell
+		p.buffer.bells++
 	case '\b': // Backspace
 		if p.buffer.cursorX > 0 {
 			p.buffer.MoveCursor(p.buffer.cursorX-1, p.buffer.cursorY)
 		}
 	default:
-		if b >= 0x20 && b < 0x7F { // Printable ASCII
-			p.buffer.SetCell(p.buffer.cursorX, p.buffer.cursorY, rune(b), p.currentFG, p.currentBG, p.currentAttrs)
-			p.buffer.cursorX++
-			if p.buffer.cursorX >= p.buffer.width {
-				p.buffer.cursorX = 0
-				p.buffer.cursorY++
-				if p.buffer.cursorY >= p.buffer.height {
-					p.buffer.ScrollUp()
-					p.buffer.cursorY = p.buffer.height - 1
-				}
-			}
+		switch {
+		case b >= 0x20 && b < 0x7F: // Printable ASCII
+			p.putRune(rune(b))
+		case b >= 0x80:
+			p.decodeByte(b)
+		}
+	}
+}
+
+// decodeByte handles a byte outside ASCII in the buffer's encoding. In
+// UTF-8 it starts a sequence that handleNormal completes; a byte that can't
+// start one draws U+FFFD. In Latin-1 it is a character of its own, except
+// for the C1 controls 0x80-0x9F, which are dropped.
+func (p *ANSIParser) decodeByte(b byte) {
+	if p.buffer.encoding == EncodingLatin1 {
+		if b >= 0xA0 {
+			p.putRune(rune(b))
+		}
+		return
+	}
+
+	switch {
+	case b >= 0xC2 && b <= 0xDF:
+		p.utf8Need = 2
+	case b >= 0xE0 && b <= 0xEF:
+		p.utf8Need = 3
+	case b >= 0xF0 && b <= 0xF4:
+		p.utf8Need = 4
+	default:
+		p.putRune(utf8.RuneError)
+		return
+	}
+	p.utf8Buf[0] = b
+	p.utf8Len = 1
+}
+
+// lineFeed moves the cursor down a line, scrolling at the bottom. In
+// newline mode (LNM), or when the buffer translates bare line feeds, it
+// also returns the carriage.
+func (p *ANSIParser) lineFeed() {
+	p.buffer.endLine()
+	if p.buffer.modes.NewLine || p.buffer.lineFeed == LineFeedCRLF {
+		p.buffer.MoveCursor(0, p.buffer.cursorY)
+	}
+	p.buffer.cursorY++
+	if p.buffer.cursorY >= p.buffer.height {
+		p.buffer.scrollUp(p.eraseCell())
+		p.buffer.cursorY = p.buffer.height - 1
+	}
+}
+
+// eraseCell is what erased and scrolled-in cells are filled with: a space in
+// the current background, as xterm does (back color erase)
+func (p *ANSIParser) eraseCell() Cell {
+	return Cell{Rune: ' ', Foreground: Color{Default: true}, Background: p.currentBG}
+}
+
+// putRune draws r at the cursor and advances it, wrapping at the right edge.
+// A line that has wrapped the buffer's maximum number of times stops at the
+// edge instead, and the rest of it is dropped.
+func (p *ANSIParser) putRune(r rune) {
+	if p.buffer.clipping {
+		p.diag.droppedChars++
+		return
+	}
+	p.buffer.SetCell(p.buffer.cursorX, p.buffer.cursorY, r, p.currentFG, p.currentBG, p.currentAttrs)
+	p.buffer.cursorX++
+	if p.buffer.cursorX >= p.buffer.width {
+		if p.buffer.maxWraps > 0 && p.buffer.wraps >= p.buffer.maxWraps {
+			p.buffer.cursorX = p.buffer.width - 1
+			p.buffer.clipping = true
+			p.diag.clippedLines++
+			slog.Warn("Long line cut short",
+				slog.String("session_id", p.buffer.sessionID),
+				slog.Int("wraps", p.buffer.wraps),
+				slog.Int("width", p.buffer.width),
+			)
+			return
+		}
+		p.buffer.wraps++
+		p.buffer.cursorX = 0
+		p.buffer.cursorY++
+		if p.buffer.cursorY >= p.buffer.height {
+			p.buffer.scrollUp(p.eraseCell())
+			p.buffer.cursorY = p.buffer.height - 1
 		}
 	}
 }
@@ -137,7 +259,9 @@ func (p *ANSIParser) handleEscape(b byte) {
 		p.state = stateCharset
 		p.escapeBuffer.WriteByte(b)
 	case 'c': // RIS - Reset to Initial State
-		p.buffer.Clear()
+		p.buffer.clear(blankCell)
+		p.buffer.modes = defaultModes()
+		p.buffer.savedModes = nil
 		p.currentFG = Color{Default: true}
 		p.currentBG = Color{Default: true}
 		p.currentAttrs = Attributes{}
@@ -145,7 +269,7 @@ func (p *ANSIParser) handleEscape(b byte) {
 	case 'D': // IND - Index (move down one line)
 		p.buffer.cursorY++
 		if p.buffer.cursorY >= p.buffer.height {
-			p.buffer.ScrollUp()
+			p.buffer.scrollUp(p.eraseCell())
 			p.buffer.cursorY = p.buffer.height - 1
 		}
 		p.state = stateNormal
@@ -153,14 +277,14 @@ func (p *ANSIParser) handleEscape(b byte) {
 		if p.buffer.cursorY > 0 {
 			p.buffer.cursorY--
 		} else {
-			p.buffer.ScrollDown()
+			p.buffer.ScrollDown(p.eraseCell())
 		}
 		p.state = stateNormal
 	case 'E': // NEL - Next Line
 		p.buffer.cursorX = 0
 		p.buffer.cursorY++
 		if p.buffer.cursorY >= p.buffer.height {
-			p.buffer.ScrollUp()
+			p.buffer.scrollUp(p.eraseCell())
 			p.buffer.cursorY = p.buffer.height - 1
 		}
 		p.state = stateNormal
@@ -170,11 +294,19 @@ func (p *ANSIParser) handleEscape(b byte) {
 	case '8': // DECRC - Restore Cursor
 		p.restoreCursor()
 		p.state = stateNormal
+	case '=': // DECKPAM - Application keypad
+		p.buffer.modes.Keypad = true
+		p.state = stateNormal
+	case '>': // DECKPNM - Normal keypad
+		p.buffer.modes.Keypad = false
+		p.state = stateNormal
 	case 'H': // HTS - Horizontal Tab Set
 		// Set tab stop at current position
+		p.unhandled("ESC H", []byte{0x1B, b})
 		p.state = stateNormal
 	default:
 		// Unknown escape sequence
+		p.unhandled("ESC "+string(rune(b)), []byte{0x1B, b})
 		p.state = stateNormal
 	}
 }
@@ -182,14 +314,76 @@ func (p *ANSIParser) handleEscape(b byte) {
 func (p *ANSIParser) handleCSI(b byte) {
 	// Check if this is a parameter byte or final byte
 	if b >= 0x20 && b <= 0x3F {
-		// Parameter byte
+		// Parameter or intermediate byte
 		p.escapeBuffer.WriteByte(b)
 		return
 	}
 
-	// Final byte - execute the command
-	params := p.parseCSIParams(p.escapeBuffer.String())
-	
+	// Final byte - execute the command. The same final means different
+	// things with a private marker or intermediates (CSI ? 25 h is DECSET,
+	// CSI 4 h is SM), so dispatch on all three.
+	seq, ok := p.parseCSI(p.escapeBuffer.Bytes())
+	switch {
+	case !ok:
+		p.recordCSI(b)
+	case seq.prefix == 0 && seq.intermediates == "":
+		p.executeCSI(seq.params, b)
+	case seq.prefix == '?' && seq.intermediates == "":
+		p.executePrivateCSI(seq.params, b)
+	case seq.prefix == 0 && seq.intermediates == "!" && b == 'p': // DECSTR - Soft Terminal Reset
+		p.softReset()
+	default:
+		p.recordCSI(b)
+	}
+
+	p.state = stateNormal
+}
+
+// csiSequence is the part of a control sequence before its final byte:
+// CSI [prefix] parameters [intermediates] final
+type csiSequence struct {
+	prefix        byte // Private marker '<', '=', '>' or '?'; 0 if none
+	params        []int
+	intermediates string // Bytes 0x20-0x2F before the final, e.g. " " in DECSCUSR or "!" in DECSTR
+}
+
+// parseCSI splits the bytes collected after CSI. It reports false for a
+// malformed sequence: a private marker after the first byte, or a parameter
+// byte after an intermediate.
+func (p *ANSIParser) parseCSI(collected []byte) (csiSequence, bool) {
+	var seq csiSequence
+	if len(collected) > 0 && collected[0] >= '<' && collected[0] <= '?' {
+		seq.prefix = collected[0]
+		collected = collected[1:]
+	}
+
+	end := len(collected)
+	for i, b := range collected {
+		if b >= 0x20 && b <= 0x2F {
+			end = i
+			break
+		}
+	}
+	params, intermediates := collected[:end], collected[end:]
+	for _, b := range params {
+		if b >= '<' && b <= '?' {
+			return seq, false
+		}
+	}
+	for _, b := range intermediates {
+		if b > 0x2F {
+			return seq, false
+		}
+	}
+
+	seq.params = p.parseCSIParams(string(params))
+	seq.intermediates = string(intermediates)
+	return seq, true
+}
+
+// executeCSI runs a control sequence without a private marker or
+// intermediates
+func (p *ANSIParser) executeCSI(params []int, b byte) {
 	switch b {
 	case 'A': // Cursor up
 		n := 1
@@ -230,44 +424,38 @@ func (p *ANSIParser) handleCSI(b byte) {
 		if len(params) > 0 {
 			mode = params[0]
 		}
+		fill := p.eraseCell()
 		switch mode {
 		case 0: // Clear from cursor to end
 			// Clear current line from cursor
-			for x := p.buffer.cursorX; x < p.buffer.width; x++ {
-				p.buffer.SetCell(x, p.buffer.cursorY, ' ', p.currentFG, p.currentBG, Attributes{})
-			}
+			p.buffer.eraseCells(p.buffer.cursorY, p.buffer.cursorX, p.buffer.width, fill)
 			// Clear lines below
 			for y := p.buffer.cursorY + 1; y < p.buffer.height; y++ {
-				p.buffer.ClearLine(y)
+				p.buffer.ClearLine(y, fill)
 			}
 		case 1: // Clear from start to cursor
 			// Clear lines above
 			for y := 0; y < p.buffer.cursorY; y++ {
-				p.buffer.ClearLine(y)
+				p.buffer.ClearLine(y, fill)
 			}
 			// Clear current line to cursor
-			for x := 0; x <= p.buffer.cursorX; x++ {
-				p.buffer.SetCell(x, p.buffer.cursorY, ' ', p.currentFG, p.currentBG, Attributes{})
-			}
+			p.buffer.eraseCells(p.buffer.cursorY, 0, p.buffer.cursorX+1, fill)
 		case 2: // Clear entire display
-			p.buffer.Clear()
+			p.buffer.clear(fill)
 		}
 	case 'K': // Erase line
 		mode := 0
 		if len(params) > 0 {
 			mode = params[0]
 		}
+		fill := p.eraseCell()
 		switch mode {
 		case 0: // Clear from cursor to end of line
-			for x := p.buffer.cursorX; x < p.buffer.width; x++ {
-				p.buffer.SetCell(x, p.buffer.cursorY, ' ', p.currentFG, p.currentBG, Attributes{})
-			}
+			p.buffer.eraseCells(p.buffer.cursorY, p.buffer.cursorX, p.buffer.width, fill)
 		case 1: // Clear from start of line to cursor
-			for x := 0; x <= p.buffer.cursorX; x++ {
-				p.buffer.SetCell(x, p.buffer.cursorY, ' ', p.currentFG, p.currentBG, Attributes{})
-			}
+			p.buffer.eraseCells(p.buffer.cursorY, 0, p.buffer.cursorX+1, fill)
 		case 2: // Clear entire line
-			p.buffer.ClearLine(p.buffer.cursorY)
+			p.buffer.ClearLine(p.buffer.cursorY, fill)
 		}
 	case 'm': // SGR - Select Graphic Rendition
 		p.handleSGR(params)
@@ -280,33 +468,31 @@ func (p *ANSIParser) handleCSI(b byte) {
 		if len(params) > 0 && params[0] > 0 {
 			n = params[0]
 		}
-		p.buffer.InsertLines(p.buffer.cursorY, n)
+		p.buffer.InsertLines(p.buffer.cursorY, n, p.eraseCell())
 	case 'M': // DL - Delete Lines
 		n := 1
 		if len(params) > 0 && params[0] > 0 {
 			n = params[0]
 		}
-		p.buffer.DeleteLines(p.buffer.cursorY, n)
+		p.buffer.DeleteLines(p.buffer.cursorY, n, p.eraseCell())
 	case 'P': // DCH - Delete Characters
 		n := 1
 		if len(params) > 0 && params[0] > 0 {
 			n = params[0]
 		}
-		p.buffer.DeleteChars(p.buffer.cursorX, p.buffer.cursorY, n)
+		p.buffer.DeleteChars(p.buffer.cursorX, p.buffer.cursorY, n, p.eraseCell())
 	case '@': // ICH - Insert Characters
 		n := 1
 		if len(params) > 0 && params[0] > 0 {
 			n = params[0]
 		}
-		p.buffer.InsertChars(p.buffer.cursorX, p.buffer.cursorY, n)
+		p.buffer.InsertChars(p.buffer.cursorX, p.buffer.cursorY, n, p.eraseCell())
 	case 'X': // ECH - Erase Characters
 		n := 1
 		if len(params) > 0 && params[0] > 0 {
 			n = params[0]
 		}
-		for i := 0; i < n && p.buffer.cursorX+i < p.buffer.width; i++ {
-			p.buffer.SetCell(p.buffer.cursorX+i, p.buffer.cursorY, ' ', p.currentFG, p.currentBG, Attributes{})
-		}
+		p.buffer.eraseCells(p.buffer.cursorY, p.buffer.cursorX, p.buffer.cursorX+n, p.eraseCell())
 	case 'G': // CHA - Cursor Horizontal Absolute
 		col := 1
 		if len(params) > 0 {
@@ -321,35 +507,204 @@ func (p *ANSIParser) handleCSI(b byte) {
 		p.buffer.MoveCursor(p.buffer.cursorX, row-1)
 	case 'r': // DECSTBM - Set Top and Bottom Margins
 		// TODO: Implement scrolling regions
-	case 'h': // SM - Set Mode
-		// TODO: Implement various modes
-	case 'l': // RM - Reset Mode
-		// TODO: Implement various modes
-	case '?': // Private modes
-		if len(p.escapeBuffer.String()) > 0 && p.escapeBuffer.String()[0] == '?' {
-			// Handle private modes like ?25h (show cursor), ?25l (hide cursor)
+		p.recordCSI(b)
+	case 'h', 'l': // SM, RM - Set and Reset Mode
+		if !p.setANSIModes(params, b == 'h') {
+			// TODO: Implement various modes
+			p.recordCSI(b)
 		}
+	case 't': // XTWINOPS - Window manipulation and reports
+		p.windowOp(params)
+	default:
+		p.recordCSI(b)
 	}
+}
 
-	p.state = stateNormal
+// Nominal cell size in pixels, for size reports in pixels: there is no
+// window to measure
+const (
+	cellWidthPixels  = 10
+	cellHeightPixels = 20
+)
+
+// windowOp answers the XTWINOPS text area size reports, so applications
+// that ask the terminal rather than the kernel see the size the session
+// has. Moving, resizing, iconifying and the rest have no window to act on
+// and are counted by operation.
+func (p *ANSIParser) windowOp(params []int) {
+	op := 0
+	if len(params) > 0 {
+		op = params[0]
+	}
+	switch op {
+	case 14: // Text area size in pixels: CSI 4 ; height ; width t
+		p.buffer.reply(fmt.Appendf(nil, "\x1b[4;%d;%dt", p.buffer.height*cellHeightPixels, p.buffer.width*cellWidthPixels))
+	case 18: // Text area size in characters: CSI 8 ; rows ; cols t
+		p.buffer.reply(fmt.Appendf(nil, "\x1b[8;%d;%dt", p.buffer.height, p.buffer.width))
+	default:
+		seq := append([]byte("\x1b["), p.escapeBuffer.Bytes()...)
+		p.unhandled(fmt.Sprintf("XTWINOPS %d", op), append(seq, 't'))
+	}
+}
+
+// executePrivateCSI runs a DEC private control sequence, CSI ? Pm final
+func (p *ANSIParser) executePrivateCSI(params []int, b byte) {
+	switch b {
+	case 'h', 'l': // DECSET, DECRST
+		if !p.setPrivateModes(params, b == 'h') {
+			p.recordCSI(b)
+		}
+	case 's': // XTSAVE - Save DEC private modes
+		if !p.saveModes(params) {
+			p.recordCSI(b)
+		}
+	case 'r': // XTRESTORE - Restore DEC private modes
+		if !p.restoreModes(params) {
+			p.recordCSI(b)
+		}
+	default:
+		p.recordCSI(b)
+	}
+}
+
+// saveModes records the current state of DEC private modes for XTRESTORE,
+// reporting whether every mode is tracked
+func (p *ANSIParser) saveModes(modes []int) bool {
+	handled := true
+	for _, mode := range modes {
+		on, tracked := p.buffer.modes.getPrivate(mode)
+		if !tracked {
+			handled = false
+			continue
+		}
+		if p.buffer.savedModes == nil {
+			p.buffer.savedModes = make(map[int]bool)
+		}
+		p.buffer.savedModes[mode] = on
+	}
+	return handled
+}
+
+// restoreModes returns DEC private modes to the state XTSAVE recorded,
+// reporting like setPrivateModes. Modes that were never saved are left
+// alone.
+func (p *ANSIParser) restoreModes(modes []int) bool {
+	handled := true
+	for _, mode := range modes {
+		on, saved := p.buffer.savedModes[mode]
+		if !saved {
+			if _, tracked := p.buffer.modes.getPrivate(mode); !tracked {
+				handled = false
+			}
+			continue
+		}
+		if !p.setPrivateModes([]int{mode}, on) {
+			handled = false
+		}
+	}
+	return handled
+}
+
+// softReset handles DECSTR: modes, character set, attributes and the saved
+// cursor and modes go back to their defaults, while the screen is kept
+func (p *ANSIParser) softReset() {
+	p.buffer.modes.softReset()
+	p.buffer.savedModes = nil
+	p.currentFG = Color{Default: true}
+	p.currentBG = Color{Default: true}
+	p.currentAttrs = Attributes{}
+	p.savedCursor = nil
+}
+
+// setANSIModes tracks an ANSI mode set or reset (CSI Pm h/l). It reports
+// whether every mode in the sequence is emulated; the caller records the
+// sequence otherwise.
+func (p *ANSIParser) setANSIModes(modes []int, on bool) bool {
+	handled := true
+	for _, mode := range modes {
+		if !p.buffer.modes.setANSI(mode, on) {
+			handled = false
+		}
+	}
+	return handled
+}
+
+// setPrivateModes tracks a DEC private mode set or reset (CSI ? Pm h/l),
+// reporting like setANSIModes
+func (p *ANSIParser) setPrivateModes(modes []int, on bool) bool {
+	handled := true
+	for _, mode := range modes {
+		if !p.buffer.modes.setPrivate(mode, on) && !(mode == 3 && p.buffer.switchColumns(on)) {
+			handled = false
+		}
+	}
+	return handled
 }
 
 func (p *ANSIParser) handleOSC(b byte) {
 	// OSC sequences are terminated by BEL or ST (ESC \)
-	if b == 0x07 { // BEL
-		// Process OSC command
+	end := p.collectString(b, true)
+	switch {
+	case end == stringOpen:
+		return
+	case end == stringTerminated && p.stringLong:
+		p.unhandled("OSC oversized", append([]byte("\x1b]"), p.escapeBuffer.Bytes()...))
+	case end == stringTerminated:
 		p.processOSC(p.escapeBuffer.String())
-		p.state = stateNormal
-	} else if b == 0x1B { // Might be start of ST
-		// Look for \ to complete ST
-		p.escapeBuffer.WriteByte(b)
-	} else if b == '\\' && p.escapeBuffer.Len() > 0 && p.escapeBuffer.Bytes()[p.escapeBuffer.Len()-1] == 0x1B {
-		// Found ST (ESC \)
-		p.processOSC(p.escapeBuffer.String()[:p.escapeBuffer.Len()-1])
-		p.state = stateNormal
-	} else {
+	default:
+		p.unhandled("OSC truncated", append([]byte("\x1b]"), p.escapeBuffer.Bytes()...))
+	}
+	p.endString(end, b)
+}
+
+// stringEnd says where a byte left an OSC or DCS string
+type stringEnd int
+
+const (
+	stringOpen        stringEnd = iota // The string goes on
+	stringTerminated                   // BEL (OSC only) or ST ended it
+	stringCancelled                    // CAN or SUB abandoned it
+	stringInterrupted                  // An escape sequence other than ST cut it short
+)
+
+// collectString adds b to the OSC or DCS payload in escapeBuffer, up to
+// maxStringPayload, and reports whether the string ended. ESC followed by
+// anything but \ starts a new sequence, so a string the application never
+// finished doesn't swallow the output after it.
+func (p *ANSIParser) collectString(b byte, belEnds bool) stringEnd {
+	if p.stringEsc {
+		p.stringEsc = false
+		if b == '\\' {
+			return stringTerminated
+		}
+		return stringInterrupted
+	}
+	switch {
+	case b == 0x1B:
+		p.stringEsc = true
+	case b == 0x07 && belEnds:
+		return stringTerminated
+	case b == 0x18 || b == 0x1A: // CAN, SUB
+		return stringCancelled
+	case p.escapeBuffer.Len() >= maxStringPayload:
+		p.stringLong = true
+	default:
 		p.escapeBuffer.WriteByte(b)
 	}
+	return stringOpen
+}
+
+// endString leaves an OSC or DCS string. When an escape sequence
+// interrupted it, b is that sequence's byte after ESC and is handled as
+// such.
+func (p *ANSIParser) endString(end stringEnd, b byte) {
+	p.stringEsc, p.stringLong = false, false
+	p.state = stateNormal
+	if end == stringInterrupted {
+		p.state = stateEscape
+		p.escapeBuffer.Reset()
+		p.handleEscape(b)
+	}
 }
 
 func (p *ANSIParser) parseCSIParams(s string) []int {
@@ -417,79 +772,66 @@ func (p *ANSIParser) handleSGR(params []int) {
 			p.currentBG = p.ansiToColor(params[i] - 40)
 		case 49: // Default background
 			p.currentBG = Color{Default: true}
-		case 38: // Extended foreground color
-			if i+2 < len(params) && params[i+1] == 5 {
-				// 256 color mode
-				p.currentFG = p.ansi256ToColor(params[i+2])
-				i += 2
+		case 90, 91, 92, 93, 94, 95, 96, 97: // Bright foreground colors
+			p.currentFG = p.ansiBrightToColor(params[i] - 90)
+		case 100, 101, 102, 103, 104, 105, 106, 107: // Bright background colors
+			p.currentBG = p.ansiBrightToColor(params[i] - 100)
+		case 38, 48: // Extended foreground or background color
+			color, used := p.extendedColor(params[i+1:])
+			if used == 0 {
+				continue
 			}
-		case 48: // Extended background color
-			if i+2 < len(params) && params[i+1] == 5 {
-				// 256 color mode
-				p.currentBG = p.ansi256ToColor(params[i+2])
-				i += 2
+			if params[i] == 38 {
+				p.currentFG = color
+			} else {
+				p.currentBG = color
 			}
+			i += used
 		}
 	}
 }
 
-func (p *ANSIParser) ansiToColor(code int) Color {
-	// Basic ANSI colors
-	colors := []Color{
-		{R: 0, G: 0, B: 0},       // Black
-		{R: 170, G: 0, B: 0},     // Red
-		{R: 0, G: 170, B: 0},     // Green
-		{R: 170, G: 85, B: 0},    // Yellow
-		{R: 0, G: 0, B: 170},     // Blue
-		{R: 170, G: 0, B: 170},   // Magenta
-		{R: 0, G: 170, B: 170},   // Cyan
-		{R: 170, G: 170, B: 170}, // White
+// extendedColor reads the color after SGR 38 or 48: 5;n from the 256-color
+// palette or 2;r;g;b in 24 bits. It returns how many parameters it used, 0
+// when they don't form a color.
+func (p *ANSIParser) extendedColor(params []int) (Color, int) {
+	switch {
+	case len(params) >= 2 && params[0] == 5:
+		return p.ansi256ToColor(params[1]), 2
+	case len(params) >= 4 && params[0] == 2:
+		return Color{R: clampByte(params[1]), G: clampByte(params[2]), B: clampByte(params[3])}, 4
+	}
+	return Color{}, 0
+}
+
+func clampByte(v int) uint8 {
+	switch {
+	case v < 0:
+		return 0
+	case v > 255:
+		return 255
 	}
+	return uint8(v)
+}
 
-	if code >= 0 && code < len(colors) {
-		return colors[code]
+func (p *ANSIParser) ansiToColor(code int) Color {
+	// Basic ANSI colors
+	if code >= 0 && code < 8 {
+		return paletteColor(code)
 	}
 	return Color{Default: true}
 }
 
 func (p *ANSIParser) ansi256ToColor(code int) Color {
-	if code < 16 {
-		// Standard and bright colors
-		if code < 8 {
-			return p.ansiToColor(code)
-		} else {
-			// Bright colors (8-15)
-			return p.ansiBrightToColor(code - 8)
-		}
-	} else if code < 232 {
-		// 216 color cube (16-231)
-		code -= 16
-		r := (code / 36) * 51
-		g := ((code / 6) % 6) * 51
-		b := (code % 6) * 51
-		return Color{R: uint8(r), G: uint8(g), B: uint8(b)}
-	} else {
-		// Grayscale (232-255)
-		gray := 8 + (code-232)*10
-		return Color{R: uint8(gray), G: uint8(gray), B: uint8(gray)}
-	}
+	// Standard and bright colors, the 216 color cube (16-231) and the
+	// grayscale ramp (232-255)
+	return color256(code)
 }
 
 func (p *ANSIParser) ansiBrightToColor(code int) Color {
 	// Bright ANSI colors
-	colors := []Color{
-		{R: 85, G: 85, B: 85},       // Bright Black (Gray)
-		{R: 255, G: 85, B: 85},      // Bright Red
-		{R: 85, G: 255, B: 85},      // Bright Green
-		{R: 255, G: 255, B: 85},     // Bright Yellow
-		{R: 85, G: 85, B: 255},      // Bright Blue
-		{R: 255, G: 85, B: 255},     // Bright Magenta
-		{R: 85, G: 255, B: 255},     // Bright Cyan
-		{R: 255, G: 255, B: 255},    // Bright White
-	}
-
-	if code >= 0 && code < len(colors) {
-		return colors[code]
+	if code >= 0 && code < 8 {
+		return paletteColor(code + 8)
 	}
 	return Color{Default: true}
 }
@@ -498,23 +840,85 @@ func (p *ANSIParser) ansiBrightToColor(code int) Color {
 
 func (p *ANSIParser) handleDCS(b byte) {
 	// DCS sequences are terminated by ST (ESC \)
-	if b == 0x1B {
-		p.escapeBuffer.WriteByte(b)
-	} else if b == '\\' && p.escapeBuffer.Len() > 0 && p.escapeBuffer.Bytes()[p.escapeBuffer.Len()-1] == 0x1B {
-		// Found ST, process DCS
-		// For now, we just ignore DCS sequences
-		p.state = stateNormal
+	end := p.collectString(b, false)
+	if end == stringOpen {
+		return
+	}
+	payload := p.escapeBuffer.Bytes()
+	seq := append([]byte("\x1bP"), payload...)
+	if end == stringTerminated {
+		// DCS payloads aren't acted on, but are counted by what they are
+		kind := dcsKind(payload)
+		if kind == "DCS DECRQSS" {
+			// Applications wait for an answer to DECRQSS; tell them the
+			// request wasn't understood
+			p.buffer.reply([]byte("\x1bP0$r\x1b\\"))
+		}
+		p.unhandled(kind, append(seq, 0x1B, '\\'))
 	} else {
-		p.escapeBuffer.WriteByte(b)
+		p.unhandled("DCS truncated", seq)
+	}
+	p.endString(end, b)
+}
+
+// dcsKind classifies a DCS payload for the diagnostics: sixel graphics,
+// an XTGETTCAP (+q) or DECRQSS ($q) query, or something else
+func dcsKind(payload []byte) string {
+	i := 0
+	for i < len(payload) && (payload[i] >= '0' && payload[i] <= '9' || payload[i] == ';') {
+		i++
+	}
+	rest := payload[i:]
+	switch {
+	case bytes.HasPrefix(rest, []byte("q")):
+		return "DCS sixel"
+	case bytes.HasPrefix(rest, []byte("+q")):
+		return "DCS XTGETTCAP"
+	case bytes.HasPrefix(rest, []byte("$q")):
+		return "DCS DECRQSS"
+	default:
+		return "DCS other"
 	}
 }
 
 func (p *ANSIParser) handleCharset(b byte) {
-	// Handle character set selection
-	// For now, we just ignore these
+	// Handle character set selection. G0 is tracked, but only US-ASCII is
+	// drawn as sent, so any other set is reported as unhandled.
+	designator := p.escapeBuffer.String()
+	if designator == "(" {
+		p.buffer.modes.designateG0(b)
+	}
+	if b != 'B' {
+		p.unhandled("ESC "+designator+string(rune(b)), []byte("\x1b"+designator+string(rune(b))))
+	}
 	p.state = stateNormal
 }
 
+// unhandled counts a sequence the parser ignored and, depending on the
+// buffer's strictness, logs it and marks the screen
+func (p *ANSIParser) unhandled(kind string, seq []byte) {
+	p.diag.record(kind, seq)
+	if p.buffer.strictness == StrictnessOff {
+		return
+	}
+
+	slog.Warn("Unhandled escape sequence",
+		slog.String("session_id", p.buffer.sessionID),
+		slog.String("kind", kind),
+		slog.String("sequence", fmt.Sprintf("%q", seq)),
+	)
+	if p.buffer.strictness == StrictnessMark {
+		p.diag.degraded = true
+		p.putRune(utf8.RuneError)
+	}
+}
+
+// recordCSI counts a CSI sequence the parser ignored
+func (p *ANSIParser) recordCSI(final byte) {
+	seq := append([]byte("\x1b["), p.escapeBuffer.Bytes()...)
+	p.unhandled(csiKind(p.escapeBuffer.Bytes(), final), append(seq, final))
+}
+
 func (p *ANSIParser) processOSC(command string) {
 	// Process OSC commands (like setting window title)
 	// Format: OSC Ps ; Pt BEL
@@ -527,7 +931,18 @@ func (p *ANSIParser) processOSC(command string) {
 	// 0 - Set window title and icon
 	// 1 - Set icon 
 	// 2 - Set window title
-	// We don't need to handle these for a terminal buffer
+	// The icon name isn't kept; the title is
+	switch parts[0] {
+	case "0", "2":
+		if len(parts) == 2 {
+			p.buffer.title = parts[1]
+		} else {
+			p.buffer.title = ""
+		}
+	case "1":
+	default:
+		p.unhandled("OSC "+parts[0], []byte("\x1b]"+command+"\x07"))
+	}
 }
 
 func (p *ANSIParser) saveCursor() {
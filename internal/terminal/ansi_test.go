@@ -147,6 +147,48 @@ func TestANSIParser_ClearOperations(t *testing.T) {
 	}
 }
 
+func TestANSIParser_BackColorErase(t *testing.T) {
+	red := Color{R: 170, Indexed: true, Index: 1, Space: ColorSpace16}
+
+	tests := []struct {
+		name     string
+		sequence string
+		erased   [][2]int // Cells, as {x, y}, that must have the red background
+		kept     [][2]int // Cells that must keep the default background
+	}{
+		{"erase display", "\x1b[41m\x1b[2J", [][2]int{{0, 0}, {9, 2}}, nil},
+		{"erase below", "\x1b[2;5H\x1b[41m\x1b[J", [][2]int{{4, 1}, {0, 2}}, [][2]int{{3, 1}, {9, 0}}},
+		{"erase line", "\x1b[2;5H\x1b[41m\x1b[1K", [][2]int{{0, 1}, {4, 1}}, [][2]int{{5, 1}}},
+		{"erase characters", "\x1b[1;3H\x1b[41m\x1b[2X", [][2]int{{2, 0}, {3, 0}}, [][2]int{{4, 0}}},
+		{"insert line", "\x1b[2;1H\x1b[41m\x1b[L", [][2]int{{0, 1}, {9, 1}}, [][2]int{{0, 2}}},
+		{"delete line", "\x1b[2;1H\x1b[41m\x1b[M", [][2]int{{0, 2}, {9, 2}}, [][2]int{{0, 1}}},
+		{"delete characters", "\x1b[41m\x1b[3P", [][2]int{{7, 0}, {9, 0}}, [][2]int{{6, 0}}},
+		{"scroll", "\x1b[3;1H\x1b[41m\n", [][2]int{{0, 2}, {9, 2}}, [][2]int{{0, 1}}},
+		{"reverse index", "\x1b[41m\x1bM", [][2]int{{0, 0}, {9, 0}}, [][2]int{{0, 1}}},
+		// A full reset blanks the screen in the default colors
+		{"reset", "\x1b[41m\x1bc", nil, [][2]int{{0, 0}, {9, 2}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buffer := NewScreenBuffer(10, 3)
+			buffer.Write([]byte("XXXXXXXXXX\r\nXXXXXXXXXX\r\nXXXXXXXXXX\x1b[H"))
+			buffer.Write([]byte(tt.sequence))
+
+			for _, pos := range tt.erased {
+				if cell := buffer.cells[pos[1]][pos[0]]; cell.Rune != ' ' || cell.Background != red {
+					t.Errorf("Expected (%d,%d) erased in red, got %q on %+v", pos[0], pos[1], cell.Rune, cell.Background)
+				}
+			}
+			for _, pos := range tt.kept {
+				if cell := buffer.cells[pos[1]][pos[0]]; !cell.Background.Default {
+					t.Errorf("Expected (%d,%d) on the default background, got %+v", pos[0], pos[1], cell.Background)
+				}
+			}
+		})
+	}
+}
+
 func TestANSIParser_ColorSGR(t *testing.T) {
 	buffer := NewScreenBuffer(10, 3)
 	parser := NewANSIParser(buffer)
@@ -266,6 +308,47 @@ func TestANSIParser_SaveRestoreCursor(t *testing.T) {
 	}
 }
 
+func TestANSIParser_InputModes(t *testing.T) {
+	buffer := NewScreenBuffer(10, 3)
+
+	buffer.Write([]byte("\x1b[?1h\x1b="))
+	if modes := buffer.InputModes(); !modes.ApplicationCursorKeys || !modes.ApplicationKeypad {
+		t.Errorf("Expected both application modes, got %+v", modes)
+	}
+
+	buffer.Write([]byte("\x1b[?1l"))
+	if modes := buffer.InputModes(); modes.ApplicationCursorKeys || !modes.ApplicationKeypad {
+		t.Errorf("Expected only the keypad mode left, got %+v", modes)
+	}
+	buffer.Write([]byte("\x1b>"))
+	if modes := buffer.InputModes(); modes != (InputModes{}) {
+		t.Errorf("Expected normal modes, got %+v", modes)
+	}
+
+	// Clearing the screen keeps the modes; a full reset drops them
+	buffer.Write([]byte("\x1b[?1h\x1b=\x1b[2J"))
+	if modes := buffer.InputModes(); !modes.ApplicationCursorKeys || !modes.ApplicationKeypad {
+		t.Errorf("Expected modes to survive a clear, got %+v", modes)
+	}
+	buffer.Write([]byte("\x1bc"))
+	if modes := buffer.InputModes(); modes != (InputModes{}) {
+		t.Errorf("Expected RIS to reset the modes, got %+v", modes)
+	}
+
+	// Handled modes are not counted as unhandled, others in the same
+	// sequence still are
+	if total := buffer.ParserDiagnostics().Total; total != 0 {
+		t.Errorf("Expected no unhandled sequences, got %d", total)
+	}
+	buffer.Write([]byte("\x1b[?1;25h"))
+	if !buffer.InputModes().ApplicationCursorKeys {
+		t.Error("Expected ?1 to be applied alongside ?25")
+	}
+	if total := buffer.ParserDiagnostics().Total; total != 1 {
+		t.Errorf("Expected the sequence with ?25 to be recorded, got %d", total)
+	}
+}
+
 // Helper function to get runes from cells
 func getCellRunes(cells []Cell) []rune {
 	runes := make([]rune, len(cells))
@@ -273,4 +356,109 @@ func getCellRunes(cells []Cell) []rune {
 		runes[i] = cell.Rune
 	}
 	return runes
-}
\ No newline at end of file
+}
+
+func TestANSIParser_ResetOnReuse(t *testing.T) {
+	first := NewScreenBuffer(10, 3)
+	parser := NewANSIParser(first)
+
+	// Leave attributes set, a saved cursor, a recorded sequence and a
+	// sequence half read
+	parser.Parse([]byte("\x1b[31;1m\x1b7\x1b[?25h\x1b[3"))
+	if parser.state != stateCSI || parser.savedCursor == nil || parser.diag.total == 0 || !parser.currentAttrs.Bold {
+		t.Fatalf("Expected a dirty parser, got %+v", parser)
+	}
+
+	parser.Release()
+	if parser.buffer != nil || parser.state != stateNormal || parser.savedCursor != nil ||
+		parser.diag.total != 0 || parser.currentAttrs.Bold || !parser.currentFG.Default || parser.escapeBuffer.Len() != 0 {
+		t.Errorf("Expected a released parser to be reset, got %+v", parser)
+	}
+	parser.Release() // Releasing twice is a no-op
+
+	// Whichever parser the pool hands out draws plain text into the new
+	// buffer, with nothing carried over
+	second := NewScreenBuffer(10, 3)
+	reused := NewANSIParser(second)
+	reused.Parse([]byte("x"))
+	cell := second.cells[0][0]
+	if cell.Rune != 'x' || cell.Attributes.Bold || !cell.Foreground.Default {
+		t.Errorf("Expected a plain x, got %+v", cell)
+	}
+	if first.cells[0][0].Rune != ' ' {
+		t.Errorf("Expected the first buffer untouched, got %q", first.cells[0][0].Rune)
+	}
+	if reused.diag.total != 0 {
+		t.Errorf("Expected no diagnostics carried over, got %d", reused.diag.total)
+	}
+}
+
+func TestANSIParser_CSIDispatch(t *testing.T) {
+	buffer := NewScreenBuffer(20, 5)
+
+	// The same final byte with and without a private marker reaches
+	// different modes
+	buffer.Write([]byte("\x1b[?4h\x1b[?20h\x1b[25l"))
+	if modes := buffer.Modes(); modes.Insert || modes.NewLine || !modes.CursorVisible {
+		t.Errorf("Private and ANSI modes mixed up: %+v", modes)
+	}
+	buffer.Write([]byte("\x1b[4h\x1b[?25l"))
+	if modes := buffer.Modes(); !modes.Insert || modes.CursorVisible {
+		t.Errorf("Expected insert mode set and the cursor hidden: %+v", modes)
+	}
+
+	// xterm's modifyOtherKeys is not SGR, and secondary DA, DECRQM and
+	// malformed sequences are not their unprefixed namesakes
+	buffer.ResetParserDiagnostics()
+	buffer.Write([]byte("\x1b[>4;1mX\x1b[>c\x1b[c\x1b[4$p\x1b[1?h\x1b[!5p"))
+	if cell := buffer.cells[0][0]; cell.Rune != 'X' || cell.Attributes.Bold || cell.Attributes.Underline {
+		t.Errorf("Expected a plain X, got %q with %+v", cell.Rune, cell.Attributes)
+	}
+	if modes := buffer.Modes(); !modes.Autowrap || modes.CursorKeys {
+		t.Errorf("Malformed sequence changed modes: %+v", modes)
+	}
+
+	diag := buffer.ParserDiagnostics()
+	want := map[string]int64{"CSI >m": 1, "CSI >c": 1, "CSI c": 1, "CSI $p": 1, "CSI !p": 1, "CSI h": 1}
+	for kind, n := range want {
+		if diag.Counts[kind] != n {
+			t.Errorf("Expected %d of %q, got %d (all: %v)", n, kind, diag.Counts[kind], diag.Counts)
+		}
+	}
+	if diag.Total != 6 {
+		t.Errorf("Expected 6 unhandled sequences, got %d: %v", diag.Total, diag.Counts)
+	}
+}
+
+func TestANSIParser_WindowOps(t *testing.T) {
+	buffer := NewScreenBuffer(100, 30)
+
+	// Size reports are answered with the buffer's size
+	buffer.Write([]byte("\x1b[18t\x1b[14t"))
+	if got := string(buffer.TakeReplies()); got != "\x1b[8;30;100t\x1b[4;600;1000t" {
+		t.Errorf("Unexpected size reports %q", got)
+	}
+	buffer.Resize(80, 24)
+	buffer.Write([]byte("\x1b[18t"))
+	if got := string(buffer.TakeReplies()); got != "\x1b[8;24;80t" {
+		t.Errorf("Expected the report to follow a resize, got %q", got)
+	}
+
+	// Window manipulations are refused and counted by operation
+	buffer.Write([]byte("\x1b[8;50;200t\x1b[3;0;0t\x1b[2t\x1b[22;0t"))
+	if got := buffer.TakeReplies(); len(got) != 0 {
+		t.Errorf("Expected no replies to window manipulation, got %q", got)
+	}
+	if w, h := buffer.GetSize(); w != 80 || h != 24 {
+		t.Errorf("Expected the size to stay 80x24, got %dx%d", w, h)
+	}
+	diag := buffer.ParserDiagnostics()
+	for _, kind := range []string{"XTWINOPS 8", "XTWINOPS 3", "XTWINOPS 2", "XTWINOPS 22"} {
+		if diag.Counts[kind] != 1 {
+			t.Errorf("Expected one %q, got %v", kind, diag.Counts)
+		}
+	}
+	if s := diag.Samples[0]; s.Sequence != "\x1b[8;50;200t" {
+		t.Errorf("Unexpected sample %+v", s)
+	}
+}
@@ -2,9 +2,15 @@ package terminal
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // Buffer pool for render operations to reduce allocations
@@ -14,6 +20,9 @@ var renderBufferPool = sync.Pool{
 	},
 }
 
+// RenderFormats lists the formats accepted by Render
+var RenderFormats = []string{"plain", "raw", "raw_canonical", "debug", "ansi", "scrollback", "scrollback_raw", "passthrough", "lines"}
+
 type Cell struct {
 	Rune       rune
 	Foreground Color
@@ -21,9 +30,35 @@ type Cell struct {
 	Attributes Attributes
 }
 
+// RenderOptions change how a render writes cells. The zero value renders
+// every format as it always has.
+type RenderOptions struct {
+	Colors ColorDepth // Colors the raw formats write; "" is ColorDepthOriginal
+
+	// The debug format
+	CursorMarker rune // Drawn at the cursor; 0 for DefaultCursorMarker
+	StyleMarker  rune // Drawn over cells with a color or attribute; 0 leaves them as they are
+	KeepSpaces   bool // Leave spaces as they are instead of drawing SpaceMarker
+	Rulers       bool // Number the rows and columns around the screen
+}
+
+// Characters the debug format draws by default
+const (
+	DefaultCursorMarker = '▮'
+	SpaceMarker         = '·'
+)
+
 type Color struct {
 	R, G, B uint8
 	Default bool
+	Indexed bool       // Set from the 16-color palette; a Theme resolves it again
+	Index   uint8      // Palette entry when Indexed, n for ColorSpace256
+	Space   ColorSpace // How the application gave the color
+}
+
+// styled reports whether the cell has a color or attribute
+func (c Cell) styled() bool {
+	return !c.Foreground.Default || !c.Background.Default || c.Attributes != (Attributes{})
 }
 
 type Attributes struct {
@@ -51,6 +86,54 @@ type ScreenBuffer struct {
 	rawData         []byte       // Store raw input data with ANSI sequences
 	rawDataMu       sync.RWMutex // Separate mutex for raw data
 	maxRawDataSize  int          // Maximum size for raw data buffer
+	rawDataOffset   int64        // Stream offset of rawData[0]; counts every byte ever received
+
+	strictness Strictness    // How the parser reports sequences it ignores
+	lineFeed   LineFeed      // Whether a bare line feed also returns the carriage
+	encoding   Encoding      // How bytes outside ASCII are decoded
+	renderOpts RenderOptions // How the raw formats write cells
+	modes      TerminalModes // Modes the application set, changed by the parser
+	savedModes map[int]bool  // DEC private modes saved with XTSAVE, by number
+	bells      uint64        // BEL characters received
+	columnMode bool          // DECCOLM resizes the screen; see SetColumnMode
+	colSwitch  uint64        // Screen resizes DECCOLM made
+	title      string        // Window title set with OSC 0 or 2
+	replies    []byte        // Answers to the application's queries, not yet sent; see TakeReplies
+	sessionID  string        // For logging
+	closed     bool          // Close was called; output is ignored from then on
+	frozen     bool          // Writes, resizes and clears are ignored; see Freeze
+	ignored    uint64        // Writes, resizes and clears ignored while frozen
+
+	// Long line guard: a logical line that auto-wraps maxWraps times is cut
+	// there, so one huge line can't flood the scrollback
+	maxWraps int  // 0 for no limit; see SetMaxLineWraps
+	wraps    int  // Auto-wraps of the cursor's logical line so far
+	clipping bool // The line hit maxWraps; printable output is dropped until the cursor moves
+
+	// Change tracking, so waiters can tell whether the screen moved on
+	// without rendering it
+	generation uint64         // Bumped whenever screen content changes
+	rowGen     []uint64       // Generation at which each row last changed
+	history    *screenHistory // Earlier screen versions; nil when off, see history.go
+	changed    chan struct{}  // Closed on the next change, if anyone is listening
+	changeMu   sync.Mutex     // Guards changed
+}
+
+// InputModes are the terminal modes that change which sequences keys must
+// be sent as. Applications set them to read cursor and keypad keys in the
+// "application" form.
+type InputModes struct {
+	ApplicationCursorKeys bool `json:"application_cursor_keys"` // DECCKM, CSI ?1h: arrows, Home and End as SS3 (ESC O A)
+	ApplicationKeypad     bool `json:"application_keypad"`      // DECKPAM, ESC =: numeric keypad as SS3 (ESC O p)
+}
+
+// RawChunk is a slice of the raw output stream. Offsets count bytes since the
+// buffer was created and never go backwards, even when old data is dropped.
+type RawChunk struct {
+	Data      []byte
+	Offset    int64 // Stream offset of Data[0]
+	Next      int64 // Offset to ask for next time
+	Truncated bool  // Data between the requested offset and Offset was dropped
 }
 
 func NewScreenBuffer(width, height int) *ScreenBuffer {
@@ -74,7 +157,13 @@ func NewScreenBuffer(width, height int) *ScreenBuffer {
 		cursorY:        0,
 		maxScrollback:  1000, // Default scrollback size
 		maxRawDataSize: 1024 * 1024, // 1MB max raw data buffer
+		maxWraps:       DefaultMaxLineWraps,
 		rawData:        make([]byte, 0, 4096), // Start with 4KB capacity
+		strictness:     StrictnessOff,
+		lineFeed:       LineFeedLF,
+		encoding:       EncodingUTF8,
+		modes:          defaultModes(),
+		rowGen:         make([]uint64, height),
 	}
 
 	// Initialize scrollback buffer
@@ -84,18 +173,73 @@ func NewScreenBuffer(width, height int) *ScreenBuffer {
 	return sb
 }
 
-// Close releases resources associated with the screen buffer
+// Close returns the parser to the pool and drops the scrollback and raw
+// output, which hold most of the buffer's memory. The visible screen is
+// kept, so a caller still holding the buffer can render its final state;
+// the buffer stays frozen from then on. Closing twice is safe.
 func (sb *ScreenBuffer) Close() {
-	if sb.parser != nil {
-		sb.parser.Release()
-		sb.parser = nil
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	if sb.closed {
+		return
 	}
+	sb.closed = true
+	sb.frozen = true
+
+	sb.parser.Release()
+	sb.parser = nil
+	sb.scrollback = nil
+	sb.maxScrollback = 0
+	sb.scrollbackStart = 0
+	sb.replies = nil
+	sb.history = nil
+
+	sb.rawDataMu.Lock()
+	sb.rawData = nil
+	sb.rawDataMu.Unlock()
+}
+
+// Freeze makes Write, Resize and Clear do nothing until Unfreeze, so the
+// screen a stopping process left stays as it was while output still in
+// flight is drained. Rendering and reading carry on as before.
+func (sb *ScreenBuffer) Freeze() {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.frozen = true
+}
+
+// Unfreeze lets the buffer change again. A closed buffer stays frozen.
+func (sb *ScreenBuffer) Unfreeze() {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.frozen = sb.closed
+}
+
+// Frozen reports whether the buffer is ignoring writes
+func (sb *ScreenBuffer) Frozen() bool {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	return sb.frozen
+}
+
+// ignoreFrozen counts and logs a change dropped because the buffer is
+// frozen. The caller must hold sb.mu.
+func (sb *ScreenBuffer) ignoreFrozen(change string, size int) {
+	sb.ignored++
+	slog.Debug("Frozen screen buffer ignored a change",
+		slog.String("session_id", sb.sessionID),
+		slog.String("change", change),
+		slog.Int("size", size),
+	)
 }
 
 // SetScrollbackSize sets the maximum scrollback buffer size
 func (sb *ScreenBuffer) SetScrollbackSize(size int) {
 	sb.mu.Lock()
 	defer sb.mu.Unlock()
+	if sb.closed {
+		return
+	}
 	
 	if size < 0 {
 		size = 0
@@ -124,25 +268,182 @@ func (sb *ScreenBuffer) SetScrollbackSize(size int) {
 			newScrollback[i] = sb.scrollback[srcIndex]
 		}
 		
-		// Update start index
-		if sb.scrollbackStart > size {
-			sb.scrollbackStart = size
-		}
+		// The copied lines now start at index 0
+		sb.scrollbackStart = linesToCopy
+	} else {
+		sb.scrollbackStart = 0
 	}
 	
 	sb.scrollback = newScrollback
 	sb.maxScrollback = size
 }
 
+// SetRawDataSize sets the maximum size of the raw data kept for passthrough
+// rendering, dropping the oldest data if the buffer is already larger
+func (sb *ScreenBuffer) SetRawDataSize(size int) {
+	sb.rawDataMu.Lock()
+	defer sb.rawDataMu.Unlock()
+
+	if size < 0 {
+		size = 0
+	}
+	if len(sb.rawData) > size {
+		dropped := len(sb.rawData) - size
+		sb.rawData = append([]byte(nil), sb.rawData[dropped:]...)
+		sb.rawDataOffset += int64(dropped)
+	}
+	sb.maxRawDataSize = size
+}
+
+// RawDataSize returns the maximum size of the raw data buffer
+func (sb *ScreenBuffer) RawDataSize() int {
+	sb.rawDataMu.RLock()
+	defer sb.rawDataMu.RUnlock()
+	return sb.maxRawDataSize
+}
+
 func (sb *ScreenBuffer) Write(data []byte) {
 	sb.mu.Lock()
 	defer sb.mu.Unlock()
+	if sb.frozen {
+		sb.ignoreFrozen("write", len(data))
+		return
+	}
 
 	// Store raw data for true passthrough
 	sb.storeRawData(data)
 	
 	// Parse ANSI sequences and update buffer
+	before := sb.generation
 	sb.parser.Parse(data)
+	if sb.generation != before {
+		sb.recordHistory()
+		sb.notifyChange()
+	}
+}
+
+// Bells returns how many times the application has rung the bell
+func (sb *ScreenBuffer) Bells() uint64 {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	return sb.bells
+}
+
+// DefaultMaxLineWraps is how many times one logical line may wrap before the
+// rest of it is dropped: 80,000 characters at 80 columns
+const DefaultMaxLineWraps = 1000
+
+// SetMaxLineWraps sets how many times one logical line, output without a
+// carriage return, line feed or cursor movement, may wrap at the right edge.
+// Past that the rest of the line is dropped and counted in the parser
+// diagnostics. 0 removes the limit.
+func (sb *ScreenBuffer) SetMaxLineWraps(n int) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.maxWraps = n
+}
+
+// endLine starts a new logical line for the long line guard. The caller must
+// hold sb.mu.
+func (sb *ScreenBuffer) endLine() {
+	sb.wraps = 0
+	sb.clipping = false
+}
+
+// Columns for DECCOLM's 80- and 132-column modes
+const (
+	columns80  = 80
+	columns132 = 132
+)
+
+// SetColumnMode sets whether DECCOLM (CSI ? 3 h/l) resizes the screen to
+// 132 or 80 columns. Off, the mode is only tracked, as most terminals do
+// unless told to allow it.
+func (sb *ScreenBuffer) SetColumnMode(on bool) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.columnMode = on
+}
+
+// ColumnSwitches returns how many times DECCOLM has resized the screen, so
+// the caller can bring the terminal's size along
+func (sb *ScreenBuffer) ColumnSwitches() uint64 {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	return sb.colSwitch
+}
+
+// switchColumns acts on DECCOLM: the screen becomes 132 or 80 columns wide,
+// is cleared and the cursor goes home. It reports whether the mode was
+// acted on. The caller must hold sb.mu.
+func (sb *ScreenBuffer) switchColumns(wide bool) bool {
+	if !sb.columnMode {
+		return false
+	}
+	width := columns80
+	if wide {
+		width = columns132
+	}
+	if width != sb.width {
+		sb.resize(width, sb.height)
+		sb.colSwitch++
+	}
+	for y := 0; y < sb.height; y++ {
+		sb.eraseCells(y, 0, sb.width, blankCell)
+	}
+	sb.cursorX, sb.cursorY = 0, 0
+	return true
+}
+
+// maxPendingReplies caps the answers waiting to be taken, so an application
+// flooding queries that nobody reads can't grow the buffer without end
+const maxPendingReplies = 4096
+
+// reply queues an answer to a query the application made. The caller must
+// hold sb.mu.
+func (sb *ScreenBuffer) reply(data []byte) {
+	if len(sb.replies)+len(data) > maxPendingReplies {
+		return
+	}
+	sb.replies = append(sb.replies, data...)
+}
+
+// TakeReplies returns the answers the parser queued for the application
+// since the last call, for the caller to write to its input
+func (sb *ScreenBuffer) TakeReplies() []byte {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	replies := sb.replies
+	sb.replies = nil
+	return replies
+}
+
+// Title returns the window title the application last set
+func (sb *ScreenBuffer) Title() string {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	return sb.title
+}
+
+// Changed returns a channel that is closed the next time the screen content
+// changes, so listeners can wait for changes without polling
+func (sb *ScreenBuffer) Changed() <-chan struct{} {
+	sb.changeMu.Lock()
+	defer sb.changeMu.Unlock()
+	if sb.changed == nil {
+		sb.changed = make(chan struct{})
+	}
+	return sb.changed
+}
+
+// notifyChange wakes the listeners waiting in Changed
+func (sb *ScreenBuffer) notifyChange() {
+	sb.changeMu.Lock()
+	defer sb.changeMu.Unlock()
+	if sb.changed != nil {
+		close(sb.changed)
+		sb.changed = nil
+	}
 }
 
 // storeRawData appends raw data to the buffer with size management
@@ -157,6 +458,99 @@ func (sb *ScreenBuffer) storeRawData(data []byte) {
 	if len(sb.rawData) > sb.maxRawDataSize {
 		trimPoint := sb.maxRawDataSize / 4
 		sb.rawData = sb.rawData[trimPoint:]
+		sb.rawDataOffset += int64(trimPoint)
+	}
+}
+
+// touch records that rows from up to (but not including) to changed
+func (sb *ScreenBuffer) touch(from, to int) {
+	sb.generation++
+	for y := max(from, 0); y < to && y < len(sb.rowGen); y++ {
+		sb.rowGen[y] = sb.generation
+	}
+}
+
+// Generation returns a counter that advances whenever the screen content
+// changes. Cursor movement alone does not advance it.
+func (sb *ScreenBuffer) Generation() uint64 {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	return sb.generation
+}
+
+// RowsGeneration returns the generation at which any of rows from through
+// to (inclusive) last changed, ignoring changes elsewhere on the screen.
+// Rows past the bottom of the screen are ignored.
+func (sb *ScreenBuffer) RowsGeneration(from, to int) uint64 {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	var gen uint64
+	for y := max(from, 0); y <= to && y < len(sb.rowGen); y++ {
+		gen = max(gen, sb.rowGen[y])
+	}
+	return gen
+}
+
+// RenderGeneration renders the buffer together with the generation the
+// rendering shows
+func (sb *ScreenBuffer) RenderGeneration(format string) (string, uint64, error) {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	content, err := sb.render(format, sb.renderOpts)
+	return content, sb.generation, err
+}
+
+// WaitStable waits until the screen has gone stable without changing, or
+// ctx ends, and returns the generation it settled on. With toRow >= 0 only
+// rows fromRow through toRow count, so a clock elsewhere on the screen
+// doesn't keep it waiting. The screen must hold still for stable from the
+// time of the call.
+func (sb *ScreenBuffer) WaitStable(ctx context.Context, stable time.Duration, fromRow, toRow int) (uint64, error) {
+	generation := sb.Generation
+	if toRow >= 0 {
+		generation = func() uint64 { return sb.RowsGeneration(fromRow, toRow) }
+	}
+
+	interval := min(max(stable/5, 5*time.Millisecond), 50*time.Millisecond)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := generation()
+	since := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return last, ctx.Err()
+		case now := <-ticker.C:
+			if gen := generation(); gen != last {
+				last, since = gen, now
+			} else if now.Sub(since) >= stable {
+				return last, nil
+			}
+		}
+	}
+}
+
+// WaitMatch waits until re matches the plain screen, or ctx ends, and
+// returns the match and its submatches with the version of the screen it
+// matched, for RenderVersion. The screen is checked at once and again each
+// time it changes. On failure it returns the last screen seen.
+func (sb *ScreenBuffer) WaitMatch(ctx context.Context, re *regexp.Regexp) ([]string, string, uint64, error) {
+	for {
+		// Listen before rendering so a change made meanwhile isn't missed
+		changed := sb.Changed()
+		screen, version, err := sb.RenderGeneration("plain")
+		if err != nil {
+			return nil, screen, version, err
+		}
+		if match := re.FindStringSubmatch(screen); match != nil {
+			return match, screen, version, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, screen, version, ctx.Err()
+		case <-changed:
+		}
 	}
 }
 
@@ -164,6 +558,7 @@ func (sb *ScreenBuffer) SetCell(x, y int, r rune, fg, bg Color, attrs Attributes
 	if x < 0 || x >= sb.width || y < 0 || y >= sb.height {
 		return
 	}
+	sb.touch(y, y+1)
 
 	sb.cells[y][x] = Cell{
 		Rune:       r,
@@ -174,6 +569,7 @@ func (sb *ScreenBuffer) SetCell(x, y int, r rune, fg, bg Color, attrs Attributes
 }
 
 func (sb *ScreenBuffer) MoveCursor(x, y int) {
+	sb.endLine()
 	sb.cursorX = x
 	sb.cursorY = y
 
@@ -192,38 +588,70 @@ func (sb *ScreenBuffer) MoveCursor(x, y int) {
 	}
 }
 
+// blankCell is an erased cell in the default colors. Erases the application
+// asks for fill with its current background instead (back color erase), see
+// ANSIParser.eraseCell.
+var blankCell = Cell{Rune: ' ', Foreground: Color{Default: true}, Background: Color{Default: true}}
+
+// Clear blanks the screen in the default colors and homes the cursor
 func (sb *ScreenBuffer) Clear() {
+	if sb.frozen {
+		sb.ignoreFrozen("clear", 0)
+		return
+	}
+	sb.clear(blankCell)
+	sb.recordHistory()
+}
+
+func (sb *ScreenBuffer) clear(fill Cell) {
+	sb.touch(0, sb.height)
 	for y := 0; y < sb.height; y++ {
 		for x := 0; x < sb.width; x++ {
-			sb.cells[y][x] = Cell{
-				Rune:       ' ',
-				Foreground: Color{Default: true},
-				Background: Color{Default: true},
-			}
+			sb.cells[y][x] = fill
 		}
 	}
 	sb.cursorX = 0
 	sb.cursorY = 0
+	sb.endLine()
 	
 	// Also clear raw data on full clear
 	sb.ClearRawData()
+	sb.notifyChange()
 }
 
-func (sb *ScreenBuffer) ClearLine(y int) {
+// ClearLine fills line y with fill
+func (sb *ScreenBuffer) ClearLine(y int, fill Cell) {
 	if y < 0 || y >= sb.height {
 		return
 	}
+	sb.eraseCells(y, 0, sb.width, fill)
+}
 
-	for x := 0; x < sb.width; x++ {
-		sb.cells[y][x] = Cell{
-			Rune:       ' ',
-			Foreground: Color{Default: true},
-			Background: Color{Default: true},
-		}
+// eraseCells fills columns [from, to) of line y with fill
+func (sb *ScreenBuffer) eraseCells(y, from, to int, fill Cell) {
+	if y < 0 || y >= sb.height {
+		return
+	}
+	from = max(from, 0)
+	to = min(to, sb.width)
+	if from >= to {
+		return
+	}
+	sb.touch(y, y+1)
+
+	for x := from; x < to; x++ {
+		sb.cells[y][x] = fill
 	}
 }
 
+// ScrollUp scrolls the screen up by one line into the scrollback, blanking
+// the bottom line in the default colors
 func (sb *ScreenBuffer) ScrollUp() {
+	sb.scrollUp(blankCell)
+}
+
+func (sb *ScreenBuffer) scrollUp(fill Cell) {
+	sb.touch(0, sb.height)
 	// Save the top line to scrollback
 	sb.addToScrollback(sb.cells[0])
 
@@ -232,37 +660,200 @@ func (sb *ScreenBuffer) ScrollUp() {
 		sb.cells[y] = sb.cells[y+1]
 	}
 
-	// Clear the bottom line
-	sb.cells[sb.height-1] = make([]Cell, sb.width)
-	for x := 0; x < sb.width; x++ {
-		sb.cells[sb.height-1][x] = Cell{
-			Rune:       ' ',
-			Foreground: Color{Default: true},
-			Background: Color{Default: true},
-		}
+	// Clear the bottom line; the old one now belongs to the scrollback
+	sb.cells[sb.height-1] = sb.fillLine(fill)
+}
+
+// fillLine returns a new screen-wide line of fill
+func (sb *ScreenBuffer) fillLine(fill Cell) []Cell {
+	line := make([]Cell, sb.width)
+	for x := range line {
+		line[x] = fill
 	}
+	return line
 }
 
 func (sb *ScreenBuffer) Render(format string) (string, error) {
 	sb.mu.RLock()
 	defer sb.mu.RUnlock()
+	return sb.render(format, sb.renderOpts)
+}
 
+// RenderWithOffset renders the buffer and returns the raw stream offset the
+// rendering corresponds to, so a client can follow on with RawDataSince
+// without missing or repeating output
+func (sb *ScreenBuffer) RenderWithOffset(format string) (string, int64, error) {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	content, err := sb.render(format, sb.renderOpts)
+	return content, sb.RawDataEnd(), err
+}
+
+// RenderWithOptions is RenderWithOffset with render options of the
+// caller's in place of the buffer's own
+func (sb *ScreenBuffer) RenderWithOptions(format string, opts RenderOptions) (string, int64, error) {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	content, err := sb.render(format, opts)
+	return content, sb.RawDataEnd(), err
+}
+
+// Frame is one screen state rendered in several formats
+type Frame struct {
+	Renders    map[string]string // Rendering in each format asked for
+	Generation uint64            // Change counter the renderings show
+	CursorX    int
+	CursorY    int
+	RawEnd     int64 // Raw output offset the renderings correspond to
+}
+
+// RenderFrame renders the buffer in each of formats under one read lock, so
+// every rendering shows the same state
+func (sb *ScreenBuffer) RenderFrame(formats []string, opts RenderOptions) (Frame, error) {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	frame := Frame{
+		Renders:    make(map[string]string, len(formats)),
+		Generation: sb.generation,
+		CursorX:    sb.cursorX,
+		CursorY:    sb.cursorY,
+		RawEnd:     sb.RawDataEnd(),
+	}
+	for _, format := range formats {
+		content, err := sb.render(format, opts)
+		if err != nil {
+			return Frame{}, err
+		}
+		frame.Renders[format] = content
+	}
+	return frame, nil
+}
+
+// render renders the buffer in the given format. The caller must hold sb.mu.
+func (sb *ScreenBuffer) render(format string, opts RenderOptions) (string, error) {
 	switch format {
 	case "plain":
 		return sb.renderPlain(), nil
 	case "raw":
-		return sb.renderRaw(), nil
-	case "ansi":
-		return sb.renderANSI(), nil
+		return sb.renderRaw(opts.Colors), nil
+	case "raw_canonical":
+		return sb.renderCanonical(opts.Colors), nil
+	case "debug", "ansi":
+		return sb.renderDebug(opts), nil
 	case "scrollback":
 		return sb.renderWithScrollback(), nil
+	case "scrollback_raw":
+		return sb.renderRawWithScrollback(opts.Colors), nil
 	case "passthrough":
 		return sb.renderPassthrough(), nil
+	case "lines":
+		lines, err := json.Marshal(sb.lines(0))
+		return string(lines), err
 	default:
 		return sb.renderPlain(), nil
 	}
 }
 
+// Line is a screen row as the lines format renders it. Rows keep their
+// index however much of the screen is blank, so a client can patch its own
+// copy row by row.
+type Line struct {
+	Row             int    `json:"row"`
+	Text            string `json:"text"`             // Trailing spaces trimmed; empty for a blank row
+	DirtyGeneration uint64 `json:"dirty_generation"` // Generation at which the row last changed
+}
+
+// Lines returns the rows that changed after generation since, every row
+// for 0, and the current generation to pass as since next time
+func (sb *ScreenBuffer) Lines(since uint64) ([]Line, uint64) {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	return sb.lines(since), sb.generation
+}
+
+// lines renders the rows that changed after generation since. The caller
+// must hold sb.mu.
+func (sb *ScreenBuffer) lines(since uint64) []Line {
+	lines := []Line{}
+	var text strings.Builder
+	for y := 0; y < sb.height; y++ {
+		if since > 0 && sb.rowGen[y] <= since {
+			continue
+		}
+		text.Reset()
+		for x := 0; x < sb.width; x++ {
+			text.WriteRune(sb.cells[y][x].Rune)
+		}
+		lines = append(lines, Line{
+			Row:             y,
+			Text:            strings.TrimRight(text.String(), " "),
+			DirtyGeneration: sb.rowGen[y],
+		})
+	}
+	return lines
+}
+
+// Transcript is the scrollback and screen as plain text. Lines are numbered
+// from the first line scrolled into the history since it was last cleared,
+// so a line keeps its number as it scrolls up.
+type Transcript struct {
+	Lines      []string // Oldest first, trailing spaces trimmed
+	First      int      // Number of Lines[0]; above 0 once the scrollback is full
+	CursorLine int      // Number of the cursor's line
+	CursorCol  int
+}
+
+// Transcript returns the scrollback and screen as numbered lines
+func (sb *ScreenBuffer) Transcript() Transcript {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	return sb.transcript()
+}
+
+// transcript builds the transcript. The caller must hold sb.mu.
+func (sb *ScreenBuffer) transcript() Transcript {
+	history := sb.scrollbackLines()
+	t := Transcript{
+		Lines:      make([]string, 0, len(history)+sb.height),
+		First:      sb.scrollbackStart - len(history),
+		CursorLine: sb.scrollbackStart + sb.cursorY,
+		CursorCol:  sb.cursorX,
+	}
+	var text strings.Builder
+	appendLine := func(line []Cell) {
+		text.Reset()
+		for _, cell := range line {
+			text.WriteRune(cell.Rune)
+		}
+		t.Lines = append(t.Lines, strings.TrimRight(text.String(), " "))
+	}
+	for _, line := range history {
+		appendLine(line)
+	}
+	for y := 0; y < sb.height; y++ {
+		appendLine(sb.cells[y])
+	}
+	return t
+}
+
+// WaitTranscript waits until done accepts the transcript and returns it. On
+// an error it returns the last transcript it looked at.
+func (sb *ScreenBuffer) WaitTranscript(ctx context.Context, done func(Transcript) bool) (Transcript, error) {
+	for {
+		// Listen before reading so a change made meanwhile isn't missed
+		changed := sb.Changed()
+		t := sb.Transcript()
+		if done(t) {
+			return t, nil
+		}
+		select {
+		case <-ctx.Done():
+			return t, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
 func (sb *ScreenBuffer) renderPlain() string {
 	buf := renderBufferPool.Get().(*bytes.Buffer)
 	defer func() {
@@ -283,7 +874,7 @@ func (sb *ScreenBuffer) renderPlain() string {
 	return strings.TrimRight(buf.String(), " \n")
 }
 
-func (sb *ScreenBuffer) renderRaw() string {
+func (sb *ScreenBuffer) renderRaw(colors ColorDepth) string {
 	buf := renderBufferPool.Get().(*bytes.Buffer)
 	defer func() {
 		buf.Reset()
@@ -304,7 +895,12 @@ func (sb *ScreenBuffer) renderRaw() string {
 			
 			// Only emit SGR if attributes changed
 			if cell.Foreground != currentFG || cell.Background != currentBG || cell.Attributes != currentAttrs {
-				sgr := sb.buildSGRSequence(cell.Foreground, cell.Background, cell.Attributes)
+				sgr := sb.buildSGRSequence(cell.Foreground, cell.Background, cell.Attributes, colors)
+				// A sequence only turns things on, so reset first when the
+				// new style drops a color or attribute
+				if sgr != "\x1b[0m" && styleDropped(currentFG, currentBG, currentAttrs, cell) {
+					buf.WriteString("\x1b[0m")
+				}
 				if sgr != "" {
 					buf.WriteString(sgr)
 				}
@@ -327,40 +923,143 @@ func (sb *ScreenBuffer) renderRaw() string {
 	return buf.String()
 }
 
-func (sb *ScreenBuffer) renderANSI() string {
+// renderCanonical renders the screen like renderRaw, but so that two
+// screens that look the same render to the same bytes however the
+// application drew them: each style change is one SGR sequence with its
+// parameters in a fixed order, a change back to the default style is a lone
+// reset, every styled line ends with a reset and there is no cursor
+// trailer. Colors are written in the smallest space that holds them.
+func (sb *ScreenBuffer) renderCanonical(colors ColorDepth) string {
 	buf := renderBufferPool.Get().(*bytes.Buffer)
 	defer func() {
 		buf.Reset()
 		renderBufferPool.Put(buf)
 	}()
 
+	line := make([]Cell, sb.width)
 	for y := 0; y < sb.height; y++ {
+		for x, cell := range sb.cells[y] {
+			cell.Foreground = canonicalColor(cell.Foreground, colors)
+			cell.Background = canonicalColor(cell.Background, colors)
+			line[x] = cell
+		}
+		styled := false
+		styleRuns(line, func(style Cell, text string) {
+			switch {
+			case style.styled():
+				// One sequence that resets and sets the whole style
+				sgr := sb.buildSGRSequence(style.Foreground, style.Background, style.Attributes, colors)
+				buf.WriteString("\x1b[0;" + sgr[2:])
+				styled = true
+			case styled:
+				buf.WriteString("\x1b[0m")
+				styled = false
+			}
+			buf.WriteString(text)
+		})
+		if styled {
+			buf.WriteString("\x1b[0m")
+		}
+		if y < sb.height-1 {
+			buf.WriteRune('\n')
+		}
+	}
+
+	return buf.String()
+}
+
+// renderDebug draws the screen for reading by eye: the cursor as a
+// marker, spaces as dots and, when asked, styled cells as a marker of their
+// own and rulers numbering the rows and columns from 0
+func (sb *ScreenBuffer) renderDebug(opts RenderOptions) string {
+	buf := renderBufferPool.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		renderBufferPool.Put(buf)
+	}()
+
+	cursor := opts.CursorMarker
+	if cursor == 0 {
+		cursor = DefaultCursorMarker
+	}
+	labelWidth := 0
+	if opts.Rulers {
+		labelWidth = len(strconv.Itoa(sb.height - 1))
+		sb.writeColumnRuler(buf, labelWidth, true)
+	}
+
+	for y := 0; y < sb.height; y++ {
+		if opts.Rulers {
+			fmt.Fprintf(buf, "%*d ", labelWidth, y)
+		}
 		for x := 0; x < sb.width; x++ {
 			cell := sb.cells[y][x]
-			
-			// Show cursor position with a marker
-			if x == sb.cursorX && y == sb.cursorY {
-				buf.WriteString("▮")
-			} else if cell.Rune == ' ' {
-				buf.WriteString("·")
-			} else {
+			switch {
+			case x == sb.cursorX && y == sb.cursorY:
+				buf.WriteRune(cursor)
+			case opts.StyleMarker != 0 && cell.styled():
+				buf.WriteRune(opts.StyleMarker)
+			case cell.Rune == ' ' && !opts.KeepSpaces:
+				buf.WriteRune(SpaceMarker)
+			default:
 				buf.WriteRune(cell.Rune)
 			}
 		}
-		if y < sb.height-1 {
+		if opts.Rulers {
+			fmt.Fprintf(buf, " %d", y)
+		}
+		if y < sb.height-1 || opts.Rulers {
 			buf.WriteRune('\n')
 		}
 	}
 
+	if opts.Rulers {
+		sb.writeColumnRuler(buf, labelWidth, false)
+	}
 	return buf.String()
 }
 
+// writeColumnRuler writes two lines numbering the columns, the tens above
+// the units on top of the screen and below them underneath it
+func (sb *ScreenBuffer) writeColumnRuler(buf *bytes.Buffer, indent int, top bool) {
+	tens := make([]byte, sb.width)
+	units := make([]byte, sb.width)
+	for x := range units {
+		tens[x] = ' '
+		if x%10 == 0 {
+			tens[x] = byte('0' + x/10%10)
+		}
+		units[x] = byte('0' + x%10)
+	}
+	pad := strings.Repeat(" ", indent+1)
+	lines := []string{strings.TrimRight(string(tens), " "), string(units)}
+	if !top {
+		lines[0], lines[1] = lines[1], lines[0]
+	}
+	for i, line := range lines {
+		buf.WriteString(pad)
+		buf.WriteString(line)
+		if top || i == 0 {
+			buf.WriteByte('\n')
+		}
+	}
+}
+
+// GetCursorPosition returns the 0-based cursor column (x) and row (y)
 func (sb *ScreenBuffer) GetCursorPosition() (int, int) {
 	sb.mu.RLock()
 	defer sb.mu.RUnlock()
 	return sb.cursorX, sb.cursorY
 }
 
+// Cursor returns the 0-based cursor column and row and whether the
+// application shows the cursor, read together
+func (sb *ScreenBuffer) Cursor() (int, int, bool) {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	return sb.cursorX, sb.cursorY, sb.modes.CursorVisible
+}
+
 func (sb *ScreenBuffer) GetSize() (int, int) {
 	sb.mu.RLock()
 	defer sb.mu.RUnlock()
@@ -370,6 +1069,21 @@ func (sb *ScreenBuffer) GetSize() (int, int) {
 func (sb *ScreenBuffer) Resize(width, height int) {
 	sb.mu.Lock()
 	defer sb.mu.Unlock()
+	if sb.frozen {
+		sb.ignoreFrozen("resize", width*height)
+		return
+	}
+	sb.resize(width, height)
+	sb.recordHistory()
+}
+
+// resize resizes the screen, keeping the content that still fits. The
+// caller must hold sb.mu.
+func (sb *ScreenBuffer) resize(width, height int) {
+	if width == sb.width && height == sb.height {
+		return
+	}
+	sb.endLine()
 
 	// Create new cells
 	newCells := make([][]Cell, height)
@@ -403,6 +1117,9 @@ func (sb *ScreenBuffer) Resize(width, height int) {
 	sb.cells = newCells
 	sb.width = width
 	sb.height = height
+	sb.rowGen = make([]uint64, height)
+	sb.touch(0, height)
+	sb.notifyChange()
 
 	// Adjust cursor position if needed
 	if sb.cursorX >= width {
@@ -413,26 +1130,219 @@ func (sb *ScreenBuffer) Resize(width, height int) {
 	}
 }
 
-// ScrollDown scrolls the buffer content down by one line
-func (sb *ScreenBuffer) ScrollDown() {
+// Reset returns the buffer to its initial state: blank screen, cursor at the
+// origin, empty scrollback and raw history, and default parser attributes
+func (sb *ScreenBuffer) Reset() {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	if sb.closed {
+		return
+	}
+
+	sb.dropHistory()
+	sb.Clear()
+	sb.scrollback = make([][]Cell, sb.maxScrollback)
+	sb.scrollbackStart = 0
+
+	sb.modes = defaultModes()
+	sb.savedModes = nil
+
+	sb.parser.Release()
+	sb.parser = NewANSIParser(sb)
+}
+
+// ArchiveScreen moves the screen into the scrollback and blanks it, for a
+// restarted process to draw on while the old one's output stays readable.
+// Lines down to the last one with text go to the scrollback, followed by
+// separator on a line of its own. The raw output is kept.
+func (sb *ScreenBuffer) ArchiveScreen(separator string) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	if sb.closed {
+		return
+	}
+
+	last := -1
+	for y := 0; y < sb.height; y++ {
+		for _, cell := range sb.cells[y] {
+			if cell.Rune != ' ' && cell.Rune != 0 {
+				last = y
+				break
+			}
+		}
+	}
+	for y := 0; y <= last; y++ {
+		sb.addToScrollback(sb.cells[y])
+	}
+	line := sb.fillLine(blankCell)
+	x := 0
+	for _, r := range separator {
+		if x == sb.width {
+			break
+		}
+		line[x].Rune = r
+		x++
+	}
+	sb.addToScrollback(line)
+
+	sb.touch(0, sb.height)
+	for y := 0; y < sb.height; y++ {
+		sb.eraseCells(y, 0, sb.width, blankCell)
+	}
+	sb.cursorX, sb.cursorY = 0, 0
+	sb.recordHistory()
+	sb.notifyChange()
+}
+
+// ClearHistory blanks the screen and drops the scrollback and raw output
+func (sb *ScreenBuffer) ClearHistory() {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	if sb.closed {
+		return
+	}
+	sb.scrollback = make([][]Cell, sb.maxScrollback)
+	sb.scrollbackStart = 0
+	sb.dropHistory()
+	sb.Clear()
+}
+
+// Snapshot returns a copy of the screen's cells, by row, with the cursor
+// column and row they were read with
+func (sb *ScreenBuffer) Snapshot() ([][]Cell, int, int) {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	cells := make([][]Cell, sb.height)
+	for y := range cells {
+		cells[y] = append([]Cell(nil), sb.cells[y]...)
+	}
+	return cells, sb.cursorX, sb.cursorY
+}
+
+// Modes returns the terminal modes the application has set
+func (sb *ScreenBuffer) Modes() TerminalModes {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	return sb.modes
+}
+
+// InputModes returns the key modes the application has set
+func (sb *ScreenBuffer) InputModes() InputModes {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	return sb.modes.Input()
+}
+
+// ResetModes returns the terminal modes to their defaults, as for a newly
+// started application, and forgets any saved with XTSAVE and the title
+func (sb *ScreenBuffer) ResetModes() {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.modes = defaultModes()
+	sb.savedModes = nil
+	sb.title = ""
+}
+
+// ParserDiagnostics returns the escape sequences received since the buffer
+// was created or last reset that the parser did not act on
+func (sb *ScreenBuffer) ParserDiagnostics() ParserDiagnostics {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	if sb.closed {
+		return (&diagnostics{}).snapshot()
+	}
+	return sb.parser.diag.snapshot()
+}
+
+// SetStrictness sets how the parser reports sequences it ignores. It
+// applies to output written from now on.
+func (sb *ScreenBuffer) SetStrictness(strictness Strictness) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.strictness = strictness
+}
+
+// SetLineFeed sets whether a line feed also returns the carriage even when
+// the application has not set newline mode. Output from a terminal that
+// translated line feeds (ONLCR) never needs this; raw logs and programs run
+// with the translation off do. It applies to output written from now on.
+func (sb *ScreenBuffer) SetLineFeed(lineFeed LineFeed) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.lineFeed = lineFeed
+}
+
+// SetEncoding sets how bytes outside ASCII are decoded. Applications
+// running under a non-UTF-8 locale write single-byte characters that UTF-8
+// decoding would draw as U+FFFD. It applies to output written from now on.
+func (sb *ScreenBuffer) SetEncoding(encoding Encoding) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.encoding = encoding
+}
+
+// Encoding returns how bytes outside ASCII are decoded
+func (sb *ScreenBuffer) Encoding() Encoding {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	return sb.encoding
+}
+
+// SetRenderOptions sets how the raw formats write cells, for clients that
+// show fewer colors than the application used
+func (sb *ScreenBuffer) SetRenderOptions(opts RenderOptions) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.renderOpts = opts
+}
+
+// RenderOptions returns how the raw formats write cells
+func (sb *ScreenBuffer) RenderOptions() RenderOptions {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	return sb.renderOpts
+}
+
+// SetSessionID sets the session ID for logging
+func (sb *ScreenBuffer) SetSessionID(id string) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.sessionID = id
+}
+
+// Degraded reports whether output the buffer can't represent arrived while
+// the parser was in mark mode
+func (sb *ScreenBuffer) Degraded() bool {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	return !sb.closed && sb.parser.diag.degraded
+}
+
+// ResetParserDiagnostics clears the parser's unhandled sequence counters
+// and the degraded flag
+func (sb *ScreenBuffer) ResetParserDiagnostics() {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	if !sb.closed {
+		sb.parser.diag.reset()
+	}
+}
+
+// ScrollDown scrolls the buffer content down by one line, filling the top
+// line with fill
+func (sb *ScreenBuffer) ScrollDown(fill Cell) {
+	sb.touch(0, sb.height)
 	// Move all lines down by one
 	for y := sb.height - 1; y > 0; y-- {
 		sb.cells[y] = sb.cells[y-1]
 	}
 
 	// Clear the top line
-	sb.cells[0] = make([]Cell, sb.width)
-	for x := 0; x < sb.width; x++ {
-		sb.cells[0][x] = Cell{
-			Rune:       ' ',
-			Foreground: Color{Default: true},
-			Background: Color{Default: true},
-		}
-	}
+	sb.cells[0] = sb.fillLine(fill)
 }
 
-// InsertLines inserts n blank lines at position y
-func (sb *ScreenBuffer) InsertLines(y, n int) {
+// InsertLines inserts n lines of fill at position y
+func (sb *ScreenBuffer) InsertLines(y, n int, fill Cell) {
 	if y < 0 || y >= sb.height || n <= 0 {
 		return
 	}
@@ -441,20 +1351,23 @@ func (sb *ScreenBuffer) InsertLines(y, n int) {
 	if y + n > sb.height {
 		n = sb.height - y
 	}
+	sb.touch(y, sb.height)
 
-	// Shift lines down
-	for i := sb.height - 1; i >= y + n; i-- {
-		sb.cells[i] = sb.cells[i-n]
-	}
+	// Shift lines down, reusing the lines pushed off the bottom for the
+	// inserted ones so that no two rows share a slice
+	freed := append([][]Cell(nil), sb.cells[sb.height-n:]...)
+	copy(sb.cells[y+n:], sb.cells[y:sb.height-n])
+	copy(sb.cells[y:], freed)
 
 	// Clear inserted lines
 	for i := y; i < y + n && i < sb.height; i++ {
-		sb.ClearLine(i)
+		sb.ClearLine(i, fill)
 	}
 }
 
-// DeleteLines deletes n lines starting at position y
-func (sb *ScreenBuffer) DeleteLines(y, n int) {
+// DeleteLines deletes n lines starting at position y, filling the lines
+// freed at the bottom with fill
+func (sb *ScreenBuffer) DeleteLines(y, n int, fill Cell) {
 	if y < 0 || y >= sb.height || n <= 0 {
 		return
 	}
@@ -463,20 +1376,22 @@ func (sb *ScreenBuffer) DeleteLines(y, n int) {
 	if y + n > sb.height {
 		n = sb.height - y
 	}
+	sb.touch(y, sb.height)
 
-	// Shift lines up
-	for i := y; i < sb.height - n; i++ {
-		sb.cells[i] = sb.cells[i+n]
-	}
+	// Shift lines up, reusing the deleted lines for the freed ones at the
+	// bottom so that no two rows share a slice
+	freed := append([][]Cell(nil), sb.cells[y:y+n]...)
+	copy(sb.cells[y:], sb.cells[y+n:])
+	copy(sb.cells[sb.height-n:], freed)
 
 	// Clear bottom lines
 	for i := sb.height - n; i < sb.height; i++ {
-		sb.ClearLine(i)
+		sb.ClearLine(i, fill)
 	}
 }
 
-// InsertChars inserts n blank characters at position (x, y)
-func (sb *ScreenBuffer) InsertChars(x, y, n int) {
+// InsertChars inserts n characters of fill at position (x, y)
+func (sb *ScreenBuffer) InsertChars(x, y, n int, fill Cell) {
 	if x < 0 || x >= sb.width || y < 0 || y >= sb.height || n <= 0 {
 		return
 	}
@@ -485,6 +1400,7 @@ func (sb *ScreenBuffer) InsertChars(x, y, n int) {
 	if x + n > sb.width {
 		n = sb.width - x
 	}
+	sb.touch(y, y+1)
 
 	// Shift characters right
 	for i := sb.width - 1; i >= x + n; i-- {
@@ -493,16 +1409,13 @@ func (sb *ScreenBuffer) InsertChars(x, y, n int) {
 
 	// Clear inserted characters
 	for i := x; i < x + n && i < sb.width; i++ {
-		sb.cells[y][i] = Cell{
-			Rune:       ' ',
-			Foreground: Color{Default: true},
-			Background: Color{Default: true},
-		}
+		sb.cells[y][i] = fill
 	}
 }
 
-// DeleteChars deletes n characters at position (x, y)
-func (sb *ScreenBuffer) DeleteChars(x, y, n int) {
+// DeleteChars deletes n characters at position (x, y), filling the end of
+// the line with fill
+func (sb *ScreenBuffer) DeleteChars(x, y, n int, fill Cell) {
 	if x < 0 || x >= sb.width || y < 0 || y >= sb.height || n <= 0 {
 		return
 	}
@@ -511,6 +1424,7 @@ func (sb *ScreenBuffer) DeleteChars(x, y, n int) {
 	if x + n > sb.width {
 		n = sb.width - x
 	}
+	sb.touch(y, y+1)
 
 	// Shift characters left
 	for i := x; i < sb.width - n; i++ {
@@ -519,11 +1433,7 @@ func (sb *ScreenBuffer) DeleteChars(x, y, n int) {
 
 	// Clear end of line
 	for i := sb.width - n; i < sb.width; i++ {
-		sb.cells[y][i] = Cell{
-			Rune:       ' ',
-			Foreground: Color{Default: true},
-			Background: Color{Default: true},
-		}
+		sb.cells[y][i] = fill
 	}
 }
 
@@ -547,7 +1457,12 @@ func (sb *ScreenBuffer) addToScrollback(line []Cell) {
 func (sb *ScreenBuffer) GetScrollback() [][]Cell {
 	sb.mu.RLock()
 	defer sb.mu.RUnlock()
+	return sb.scrollbackLines()
+}
 
+// scrollbackLines returns the scrollback lines, oldest first. The caller must
+// hold sb.mu.
+func (sb *ScreenBuffer) scrollbackLines() [][]Cell {
 	if sb.scrollbackStart == 0 {
 		return nil
 	}
@@ -572,6 +1487,133 @@ func (sb *ScreenBuffer) GetScrollback() [][]Cell {
 	return result
 }
 
+// WindowFormats lists the formats Window renders lines in
+var WindowFormats = []string{"plain", "raw"}
+
+// LineWindow is part of the scrollback followed by the screen, read as one
+// document of numbered lines: the oldest scrollback line held is line 0 and
+// the screen's bottom row is line Total-1
+type LineWindow struct {
+	Lines   []string // Rendered lines from Start on
+	Start   int
+	Total   int // Lines in the document, scrollback and screen
+	Dropped int // Lines that have left the full scrollback; each shifts the numbering by one
+}
+
+// Window returns up to count lines of the scrollback and screen from line
+// start on, each rendered as the scrollback formats render it: plain keeps
+// trailing spaces, raw makes each line self-contained with colors and
+// attributes. Joined with newlines, all the lines of raw give the
+// scrollback_raw format, and of plain the scrollback format once the blank
+// end of the screen is trimmed. A start past the end returns no lines.
+func (sb *ScreenBuffer) Window(format string, start, count int) (LineWindow, error) {
+	if format != "plain" && format != "raw" {
+		return LineWindow{}, fmt.Errorf("format %s can't page the history, use plain or raw", format)
+	}
+	if start < 0 || count < 0 {
+		return LineWindow{}, fmt.Errorf("start and count must not be negative")
+	}
+
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	held := sb.scrollbackHeld()
+	w := LineWindow{
+		Start:   start,
+		Total:   held + sb.height,
+		Dropped: sb.scrollbackStart - held,
+	}
+	end := min(start+count, w.Total)
+	if start >= end {
+		w.Lines = []string{}
+		return w, nil
+	}
+
+	w.Lines = make([]string, 0, end-start)
+	var buf bytes.Buffer
+	for i := start; i < end; i++ {
+		line := sb.documentLine(i, held)
+		buf.Reset()
+		if format == "raw" {
+			sb.writeStyledLine(&buf, line, sb.renderOpts.Colors)
+		} else {
+			for _, cell := range line {
+				buf.WriteRune(cell.Rune)
+			}
+		}
+		w.Lines = append(w.Lines, buf.String())
+	}
+	return w, nil
+}
+
+// scrollbackHeld returns how many lines the scrollback holds. The caller
+// must hold sb.mu.
+func (sb *ScreenBuffer) scrollbackHeld() int {
+	return min(sb.scrollbackStart, sb.maxScrollback)
+}
+
+// documentLine returns line i of the scrollback followed by the screen,
+// given held from scrollbackHeld. The caller must hold sb.mu.
+func (sb *ScreenBuffer) documentLine(i, held int) []Cell {
+	if i >= held {
+		return sb.cells[i-held]
+	}
+	// The oldest line held sits just past the newest in the circular buffer
+	return sb.scrollback[(sb.scrollbackStart-held+i)%sb.maxScrollback]
+}
+
+// ScrollbackInfo returns the number of lines held in scrollback and the
+// maximum it will keep
+func (sb *ScreenBuffer) ScrollbackInfo() (int, int) {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+
+	lines := sb.scrollbackStart
+	if lines > sb.maxScrollback {
+		lines = sb.maxScrollback
+	}
+	return lines, sb.maxScrollback
+}
+
+// BufferInfo is a snapshot of the terminal model's size and history
+type BufferInfo struct {
+	Width              int    `json:"width"`
+	Height             int    `json:"height"`
+	ScrollbackLines    int    `json:"scrollback_lines"`    // Lines held in scrollback
+	ScrollbackCapacity int    `json:"scrollback_capacity"` // Most lines scrollback will hold
+	ScrollbackDropped  int    `json:"scrollback_dropped"`  // Lines scrolled out of a full scrollback
+	RawBytes           int    `json:"raw_bytes"`           // Raw output held for passthrough
+	RawCapacity        int    `json:"raw_capacity"`        // Most raw output that will be held
+	RawDiscarded       int64  `json:"raw_discarded"`       // Raw output dropped, to stay in capacity or by a full clear
+	Generation         uint64 `json:"version"`             // Change counter, as reported by wait_for_stable_screen
+	AltScreen          bool   `json:"alt_screen"`
+	Closed             bool   `json:"closed"`         // The buffer was released and takes no more output
+	FrozenIgnored      uint64 `json:"frozen_ignored"` // Writes, resizes and clears ignored because the buffer was frozen
+}
+
+// Info returns a consistent snapshot of the buffer's size, history and
+// change counter
+func (sb *ScreenBuffer) Info() BufferInfo {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	sb.rawDataMu.RLock()
+	defer sb.rawDataMu.RUnlock()
+
+	return BufferInfo{
+		Width:              sb.width,
+		Height:             sb.height,
+		ScrollbackLines:    min(sb.scrollbackStart, sb.maxScrollback),
+		ScrollbackCapacity: sb.maxScrollback,
+		ScrollbackDropped:  max(sb.scrollbackStart-sb.maxScrollback, 0),
+		RawBytes:           len(sb.rawData),
+		RawCapacity:        sb.maxRawDataSize,
+		RawDiscarded:       sb.rawDataOffset,
+		Generation:         sb.generation,
+		AltScreen:          sb.modes.AltScreen != 0,
+		Closed:             sb.closed,
+		FrozenIgnored:      sb.ignored,
+	}
+}
+
 // renderWithScrollback renders the buffer including scrollback history
 func (sb *ScreenBuffer) renderWithScrollback() string {
 	buf := renderBufferPool.Get().(*bytes.Buffer)
@@ -581,8 +1623,7 @@ func (sb *ScreenBuffer) renderWithScrollback() string {
 	}()
 
 	// First render scrollback
-	scrollbackLines := sb.GetScrollback()
-	for _, line := range scrollbackLines {
+	for _, line := range sb.scrollbackLines() {
 		for _, cell := range line {
 			buf.WriteRune(cell.Rune)
 		}
@@ -595,6 +1636,71 @@ func (sb *ScreenBuffer) renderWithScrollback() string {
 	return buf.String()
 }
 
+// renderRawWithScrollback renders scrollback history followed by the current
+// screen, keeping colors and attributes. Each line is self-contained: it
+// starts from default attributes and ends with a reset, so any slice of the
+// output can be displayed on its own.
+func (sb *ScreenBuffer) renderRawWithScrollback(colors ColorDepth) string {
+	buf := renderBufferPool.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		renderBufferPool.Put(buf)
+	}()
+
+	for _, line := range sb.scrollbackLines() {
+		sb.writeStyledLine(buf, line, colors)
+		buf.WriteRune('\n')
+	}
+
+	for y := 0; y < sb.height; y++ {
+		sb.writeStyledLine(buf, sb.cells[y], colors)
+		if y < sb.height-1 {
+			buf.WriteRune('\n')
+		}
+	}
+
+	return buf.String()
+}
+
+// writeStyledLine writes a row of cells as runs of text sharing the same
+// style, followed by an attribute reset
+func (sb *ScreenBuffer) writeStyledLine(buf *bytes.Buffer, line []Cell, colors ColorDepth) {
+	styled := false
+	styleRuns(line, func(style Cell, text string) {
+		if styled || style.styled() {
+			// Reset first so attributes from the previous run don't carry over
+			buf.WriteString("\x1b[0m")
+			if sgr := sb.buildSGRSequence(style.Foreground, style.Background, style.Attributes, colors); sgr != "\x1b[0m" {
+				buf.WriteString(sgr)
+			}
+			styled = true
+		}
+		buf.WriteString(text)
+	})
+
+	if styled {
+		buf.WriteString("\x1b[0m")
+	}
+}
+
+// styleRuns calls fn with each run of cells in line sharing the same colors
+// and attributes, in order, giving the run's first cell and its text
+func styleRuns(line []Cell, fn func(style Cell, text string)) {
+	var text strings.Builder
+	start := 0
+	for x := range line {
+		text.WriteRune(line[x].Rune)
+		next := x + 1
+		if next < len(line) && line[next].Foreground == line[start].Foreground &&
+			line[next].Background == line[start].Background && line[next].Attributes == line[start].Attributes {
+			continue
+		}
+		fn(line[start], text.String())
+		text.Reset()
+		start = next
+	}
+}
+
 // renderPassthrough returns the raw data exactly as received, preserving all ANSI sequences
 func (sb *ScreenBuffer) renderPassthrough() string {
 	sb.rawDataMu.RLock()
@@ -620,11 +1726,56 @@ func (sb *ScreenBuffer) ClearRawData() {
 	sb.rawDataMu.Lock()
 	defer sb.rawDataMu.Unlock()
 	
+	sb.rawDataOffset += int64(len(sb.rawData))
 	sb.rawData = sb.rawData[:0] // Keep capacity
 }
 
+// RawDataEnd returns the stream offset just past the newest raw byte
+func (sb *ScreenBuffer) RawDataEnd() int64 {
+	sb.rawDataMu.RLock()
+	defer sb.rawDataMu.RUnlock()
+	return sb.rawDataOffset + int64(len(sb.rawData))
+}
+
+// RawDataSince returns up to max bytes of raw output starting at the given
+// stream offset. If that data has already been dropped, the chunk starts at
+// the oldest byte still held and is marked truncated.
+func (sb *ScreenBuffer) RawDataSince(offset int64, max int) (RawChunk, error) {
+	sb.rawDataMu.RLock()
+	defer sb.rawDataMu.RUnlock()
+
+	end := sb.rawDataOffset + int64(len(sb.rawData))
+	if offset < 0 || offset > end {
+		return RawChunk{}, fmt.Errorf("offset %d is outside the output stream (0-%d)", offset, end)
+	}
+
+	chunk := RawChunk{Offset: offset}
+	if offset < sb.rawDataOffset {
+		chunk.Offset = sb.rawDataOffset
+		chunk.Truncated = true
+	}
+	start := int(chunk.Offset - sb.rawDataOffset)
+	stop := len(sb.rawData)
+	if max > 0 && stop-start > max {
+		stop = start + max
+	}
+	chunk.Data = make([]byte, stop-start)
+	copy(chunk.Data, sb.rawData[start:stop])
+	chunk.Next = chunk.Offset + int64(len(chunk.Data))
+	return chunk, nil
+}
+
+// styleDropped reports whether cell lacks a color or attribute of the
+// style before it
+func styleDropped(fg, bg Color, attrs Attributes, cell Cell) bool {
+	a := cell.Attributes
+	return (!fg.Default && cell.Foreground.Default) || (!bg.Default && cell.Background.Default) ||
+		(attrs.Bold && !a.Bold) || (attrs.Italic && !a.Italic) || (attrs.Underline && !a.Underline) ||
+		(attrs.Blink && !a.Blink) || (attrs.Reverse && !a.Reverse) || (attrs.Hidden && !a.Hidden)
+}
+
 // buildSGRSequence builds an ANSI SGR sequence for the given attributes
-func (sb *ScreenBuffer) buildSGRSequence(fg, bg Color, attrs Attributes) string {
+func (sb *ScreenBuffer) buildSGRSequence(fg, bg Color, attrs Attributes, colors ColorDepth) string {
 	// Reset if all defaults
 	if fg.Default && bg.Default && attrs == (Attributes{}) {
 		return "\x1b[0m"
@@ -663,14 +1814,13 @@ func (sb *ScreenBuffer) buildSGRSequence(fg, bg Color, attrs Attributes) string
 		addParam("8")
 	}
 
-	// Foreground color
+	// Colors, in the space the application gave them unless the render
+	// options ask for fewer
 	if !fg.Default {
-		addParam(fmt.Sprintf("38;2;%d;%d;%d", fg.R, fg.G, fg.B))
+		addParam(sgrColor(fg, false, colors))
 	}
-
-	// Background color
 	if !bg.Default {
-		addParam(fmt.Sprintf("48;2;%d;%d;%d", bg.R, bg.G, bg.B))
+		addParam(sgrColor(bg, true, colors))
 	}
 
 	if !hasParam {
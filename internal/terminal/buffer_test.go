@@ -1,8 +1,16 @@
 package terminal
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"runtime"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestScreenBuffer_Creation(t *testing.T) {
@@ -179,6 +187,64 @@ func TestScreenBuffer_Resize(t *testing.T) {
 	}
 }
 
+func TestScreenBuffer_ResizeToSameSize(t *testing.T) {
+	buffer := NewScreenBuffer(10, 5)
+	defer buffer.Close()
+
+	buffer.Write([]byte("hello"))
+	gen := buffer.Generation()
+	for i := 0; i < 100; i++ {
+		buffer.Resize(10, 5)
+	}
+	if got := buffer.Generation(); got != gen {
+		t.Errorf("Expected resizes to the same size to change nothing, generation went from %d to %d", gen, got)
+	}
+	if x, y := buffer.GetCursorPosition(); x != 5 || y != 0 {
+		t.Errorf("Expected the cursor to stay at (5,0), got (%d,%d)", x, y)
+	}
+}
+
+func TestScreenBuffer_LongLineGuard(t *testing.T) {
+	buffer := NewScreenBuffer(80, 24)
+	defer buffer.Close()
+	buffer.SetScrollbackSize(100000)
+	buffer.SetMaxLineWraps(100)
+
+	// A 4 MB line, written in one go and then in PTY-sized chunks, is cut
+	// after 101 rows each time
+	line := bytes.Repeat([]byte("x"), 4<<20)
+	buffer.Write(line)
+	buffer.Write([]byte("\r\n"))
+	for chunk := range slices.Chunk(line, 4096) {
+		buffer.Write(chunk)
+	}
+	buffer.Write([]byte("\r\ndone"))
+
+	if lines, _ := buffer.ScrollbackInfo(); lines > 2*101 {
+		t.Errorf("Expected the scrollback to hold at most %d lines, got %d", 2*101, lines)
+	}
+	diag := buffer.ParserDiagnostics()
+	if want := int64(2 * (len(line) - 101*80)); diag.ClippedLines != 2 || diag.DroppedChars != want {
+		t.Errorf("Expected 2 clipped lines and %d dropped characters, got %d and %d", want, diag.ClippedLines, diag.DroppedChars)
+	}
+
+	// The next line prints normally
+	if x, _ := buffer.GetCursorPosition(); x != 4 {
+		t.Errorf("Expected the cursor after \"done\", got column %d", x)
+	}
+	if screen, _ := buffer.Render("plain"); !strings.HasSuffix(screen, "\ndone") {
+		t.Errorf("Expected the screen to end with done, got %q", screen[len(screen)-100:])
+	}
+
+	// 0 removes the limit
+	buffer.SetMaxLineWraps(0)
+	buffer.Write([]byte("\r\n"))
+	buffer.Write(line[:200*80])
+	if diag := buffer.ParserDiagnostics(); diag.ClippedLines != 2 {
+		t.Errorf("Expected no more clipped lines without a limit, got %d", diag.ClippedLines)
+	}
+}
+
 func TestScreenBuffer_RenderPlain(t *testing.T) {
 	buffer := NewScreenBuffer(10, 3)
 	
@@ -215,6 +281,44 @@ func TestScreenBuffer_RenderPlain(t *testing.T) {
 	}
 }
 
+func TestScreenBuffer_RenderDebug(t *testing.T) {
+	buffer := NewScreenBuffer(6, 3)
+	buffer.Write([]byte("ab\x1b[31mc\x1b[0m d\r\nxy"))
+
+	// The default output is pinned; ansi is its old name
+	want := "abc·d·\nxy▮···\n······"
+	for _, format := range []string{"debug", "ansi"} {
+		if got, _ := buffer.Render(format); got != want {
+			t.Errorf("%s: expected %q, got %q", format, want, got)
+		}
+	}
+
+	tests := []struct {
+		name string
+		opts RenderOptions
+		want string
+	}{
+		{"cursor marker", RenderOptions{CursorMarker: '_'}, "abc·d·\nxy_···\n······"},
+		{"style marker", RenderOptions{StyleMarker: '#'}, "ab#·d·\nxy▮···\n······"},
+		{"keep spaces", RenderOptions{KeepSpaces: true}, "abc d \nxy▮   \n      "},
+		{"rulers", RenderOptions{Rulers: true}, "  0\n  012345\n0 abc·d· 0\n1 xy▮··· 1\n2 ······ 2\n  012345\n  0"},
+	}
+	for _, tt := range tests {
+		got, _, err := buffer.RenderWithOptions("debug", tt.opts)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: expected %q, got %q (%v)", tt.name, tt.want, got, err)
+		}
+	}
+
+	// Row labels are as wide as the last row's
+	tall := NewScreenBuffer(12, 11)
+	got, _, _ := tall.RenderWithOptions("debug", RenderOptions{Rulers: true})
+	lines := strings.Split(got, "\n")
+	if lines[0] != "   0         1" || lines[2] != " 0 ▮··········· 0" || lines[12] != "10 ············ 10" {
+		t.Errorf("Expected aligned rulers, got:\n%s", got)
+	}
+}
+
 func TestScreenBuffer_Scrollback(t *testing.T) {
 	buffer := NewScreenBuffer(5, 3)
 	buffer.SetScrollbackSize(10) // Small for testing
@@ -243,6 +347,232 @@ func TestScreenBuffer_Scrollback(t *testing.T) {
 	}
 }
 
+func TestScreenBuffer_Transcript(t *testing.T) {
+	buffer := NewScreenBuffer(10, 3)
+	buffer.SetScrollbackSize(2)
+	buffer.Write([]byte("one\r\ntwo\r\n$ "))
+	got := buffer.Transcript()
+	if got.First != 0 || got.CursorLine != 2 || got.CursorCol != 2 || strings.Join(got.Lines, "|") != "one|two|$" {
+		t.Errorf("Unexpected transcript %+v", got)
+	}
+
+	// Lines keep their numbers as they scroll, until a full scrollback
+	// drops them
+	buffer.Write([]byte("\r\nthree\r\nfour\r\nfive"))
+	got = buffer.Transcript()
+	if got.First != 1 || got.CursorLine != 5 || strings.Join(got.Lines, "|") != "two|$|three|four|five" {
+		t.Errorf("Unexpected transcript after scrolling %+v", got)
+	}
+}
+
+func TestScreenBuffer_Window(t *testing.T) {
+	buffer := NewScreenBuffer(20, 5)
+	defer buffer.Close()
+	buffer.SetScrollbackSize(200)
+	for i := 0; i < 100; i++ {
+		buffer.Write([]byte(fmt.Sprintf("\x1b[3%dmline %d\x1b[0m\r\n", i%8, i)))
+	}
+
+	// Paging through in windows of 10 gives back the whole render
+	page := func(format string) (string, LineWindow) {
+		var lines []string
+		var w LineWindow
+		for start := 0; ; start += 10 {
+			var err error
+			if w, err = buffer.Window(format, start, 10); err != nil {
+				t.Fatalf("Window(%s, %d) failed: %v", format, start, err)
+			}
+			if len(w.Lines) == 0 {
+				return strings.Join(lines, "\n"), w
+			}
+			lines = append(lines, w.Lines...)
+		}
+	}
+	for format, full := range map[string]string{"plain": "scrollback", "raw": "scrollback_raw"} {
+		want, _ := buffer.Render(full)
+		got, w := page(format)
+		if format == "plain" {
+			// The render trims the blank end of the screen
+			got = strings.TrimRight(got, " \n")
+		}
+		if got != want {
+			t.Errorf("Paging %s doesn't match the %s render:\n%q\n%q", format, full, got, want)
+		}
+		if w.Total != 101 || w.Dropped != 0 {
+			t.Errorf("Expected 101 lines with none dropped, got %+v", w)
+		}
+	}
+
+	// Once the scrollback wraps, line 0 is the oldest line still held.
+	// Shrinking keeps lines 46 to 95, and 30 more push out 46 to 75.
+	buffer.SetScrollbackSize(50)
+	for i := 100; i < 130; i++ {
+		buffer.Write([]byte(fmt.Sprintf("line %d\r\n", i)))
+	}
+	w, _ := buffer.Window("plain", 0, 2)
+	if w.Total != 55 || w.Dropped != 30 || !strings.HasPrefix(w.Lines[0], "line 76 ") || !strings.HasPrefix(w.Lines[1], "line 77 ") {
+		t.Errorf("Unexpected window over a wrapped scrollback %+v", w)
+	}
+	want, _ := buffer.Render("scrollback")
+	if got, _ := page("plain"); strings.TrimRight(got, " \n") != want {
+		t.Errorf("Paging a wrapped scrollback doesn't match the render:\n%q\n%q", got, want)
+	}
+
+	// The last window is cut short, and one past the end is empty
+	if w, _ := buffer.Window("plain", 50, 10); len(w.Lines) != 5 || !strings.HasPrefix(w.Lines[3], "line 129") {
+		t.Errorf("Expected the screen's 5 lines, got %+v", w)
+	}
+	if w, err := buffer.Window("plain", 500, 10); err != nil || len(w.Lines) != 0 || w.Total != 55 {
+		t.Errorf("Expected no lines past the end, got %+v (%v)", w, err)
+	}
+	if _, err := buffer.Window("ansi", 0, 10); err == nil {
+		t.Error("Expected the ansi format to be refused")
+	}
+}
+
+func TestScreenBuffer_ArchiveScreen(t *testing.T) {
+	buffer := NewScreenBuffer(10, 4)
+	buffer.Write([]byte("old\r\nlast"))
+
+	// Lines down to the last with text go to the scrollback, then the
+	// separator, cut to the width
+	buffer.ArchiveScreen("-- restarted --")
+	if screen, _ := buffer.Render("plain"); strings.TrimSpace(screen) != "" {
+		t.Errorf("Expected a blank screen, got %q", screen)
+	}
+	if x, y := buffer.GetCursorPosition(); x != 0 || y != 0 {
+		t.Errorf("Expected the cursor home, got %d,%d", x, y)
+	}
+	var lines []string
+	for _, line := range buffer.GetScrollback() {
+		var b strings.Builder
+		for _, cell := range line {
+			b.WriteRune(cell.Rune)
+		}
+		lines = append(lines, strings.TrimRight(b.String(), " "))
+	}
+	if want := []string{"old", "last", "-- restart"}; strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected scrollback %q, got %q", want, lines)
+	}
+	if raw := string(buffer.GetRawData()); raw != "old\r\nlast" {
+		t.Errorf("Expected the raw output kept, got %q", raw)
+	}
+
+	buffer.Write([]byte("new"))
+	buffer.ClearHistory()
+	if lines, _ := buffer.ScrollbackInfo(); lines != 0 {
+		t.Errorf("Expected no scrollback after ClearHistory, got %d lines", lines)
+	}
+	if raw := buffer.GetRawData(); len(raw) != 0 {
+		t.Errorf("Expected no raw output after ClearHistory, got %q", raw)
+	}
+	if screen, _ := buffer.Render("plain"); strings.TrimSpace(screen) != "" {
+		t.Errorf("Expected a blank screen, got %q", screen)
+	}
+}
+
+func TestScreenBuffer_SetScrollbackSizeKeepsNewest(t *testing.T) {
+	buffer := NewScreenBuffer(5, 3)
+	buffer.SetScrollbackSize(4)
+
+	// Wrap the circular buffer: 6 lines pushed into a capacity of 4
+	for i := 0; i < 6; i++ {
+		buffer.SetCell(0, 0, rune('A'+i), Color{}, Color{}, Attributes{})
+		buffer.ScrollUp()
+	}
+
+	checkScrollback := func(want string) {
+		t.Helper()
+		got := ""
+		for _, line := range buffer.GetScrollback() {
+			if line == nil {
+				t.Fatal("Scrollback contains a missing line")
+			}
+			got += string(line[0].Rune)
+		}
+		if got != want {
+			t.Errorf("Expected scrollback %q, got %q", want, got)
+		}
+	}
+
+	checkScrollback("CDEF")
+	buffer.SetScrollbackSize(10)
+	checkScrollback("CDEF")
+	buffer.SetScrollbackSize(2)
+	checkScrollback("EF")
+	if lines, max := buffer.ScrollbackInfo(); lines != 2 || max != 2 {
+		t.Errorf("Expected 2 of 2 lines, got %d of %d", lines, max)
+	}
+}
+
+func TestScreenBuffer_SetRawDataSize(t *testing.T) {
+	sb := NewScreenBuffer(80, 24)
+	sb.Write([]byte("0123456789"))
+
+	sb.SetRawDataSize(4)
+	if got := string(sb.GetRawData()); got != "6789" {
+		t.Errorf("Expected newest data kept, got %q", got)
+	}
+	if sb.RawDataSize() != 4 {
+		t.Errorf("Expected size 4, got %d", sb.RawDataSize())
+	}
+}
+
+func TestScreenBuffer_RawDataSince(t *testing.T) {
+	sb := NewScreenBuffer(80, 24)
+	sb.Write([]byte("hello"))
+
+	chunk, err := sb.RawDataSince(0, 0)
+	if err != nil || string(chunk.Data) != "hello" || chunk.Offset != 0 || chunk.Next != 5 || chunk.Truncated {
+		t.Fatalf("Unexpected first chunk %+v, err %v", chunk, err)
+	}
+
+	// Deltas pick up where the last read stopped, limited by max
+	sb.Write([]byte(" world"))
+	chunk, _ = sb.RawDataSince(chunk.Next, 3)
+	if string(chunk.Data) != " wo" || chunk.Next != 8 {
+		t.Errorf("Unexpected limited chunk %+v", chunk)
+	}
+	chunk, _ = sb.RawDataSince(chunk.Next, 0)
+	if string(chunk.Data) != "rld" || chunk.Next != 11 {
+		t.Errorf("Unexpected follow-on chunk %+v", chunk)
+	}
+	if chunk, _ = sb.RawDataSince(11, 0); len(chunk.Data) != 0 || chunk.Next != 11 {
+		t.Errorf("Expected empty chunk at the end, got %+v", chunk)
+	}
+
+	// Dropped data is reported, and offsets keep counting
+	sb.SetRawDataSize(4)
+	chunk, _ = sb.RawDataSince(0, 0)
+	if !chunk.Truncated || chunk.Offset != 7 || string(chunk.Data) != "orld" {
+		t.Errorf("Expected truncated chunk from offset 7, got %+v", chunk)
+	}
+	sb.ClearRawData()
+	if end := sb.RawDataEnd(); end != 11 {
+		t.Errorf("Expected end offset 11 after clear, got %d", end)
+	}
+
+	if _, err := sb.RawDataSince(12, 0); err == nil {
+		t.Error("Expected error for an offset past the end")
+	}
+	if _, err := sb.RawDataSince(-1, 0); err == nil {
+		t.Error("Expected error for a negative offset")
+	}
+}
+
+func TestScreenBuffer_RenderWithOffset(t *testing.T) {
+	sb := NewScreenBuffer(20, 5)
+	sb.Write([]byte("abc"))
+
+	content, offset, err := sb.RenderWithOffset("plain")
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if !strings.HasPrefix(content, "abc") || offset != 3 {
+		t.Errorf("Expected abc at offset 3, got %q at %d", content, offset)
+	}
+}
+
 func TestScreenBuffer_Passthrough(t *testing.T) {
 	sb := NewScreenBuffer(80, 24)
 	
@@ -286,4 +616,393 @@ func TestScreenBuffer_Passthrough(t *testing.T) {
 	if !strings.HasSuffix(string(rawData), "END") {
 		t.Error("Raw data should preserve latest data after trimming")
 	}
-}
\ No newline at end of file
+}
+
+func TestScreenBuffer_ScrollbackRaw(t *testing.T) {
+	sb := NewScreenBuffer(20, 3)
+
+	// A red line followed by enough lines to push it into scrollback
+	sb.Write([]byte("\x1b[31mred line\x1b[0m\r\nplain 1\r\nplain 2\r\nplain 3"))
+
+	if lines, _ := sb.ScrollbackInfo(); lines != 1 {
+		t.Fatalf("Expected 1 scrollback line, got %d", lines)
+	}
+
+	raw, err := sb.Render("scrollback_raw")
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	lines := strings.Split(raw, "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected 1 scrollback + 3 screen lines, got %d: %q", len(lines), raw)
+	}
+
+	// The scrolled-off line keeps its color and ends with a reset
+	if !strings.Contains(lines[0], "\x1b[31m") || !strings.Contains(lines[0], "red line") {
+		t.Errorf("Expected colored scrollback line, got %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[0], "\x1b[0m") {
+		t.Errorf("Expected scrollback line to end with a reset, got %q", lines[0])
+	}
+	if !strings.Contains(lines[3], "plain 3") {
+		t.Errorf("Expected live screen after scrollback, got %q", lines[3])
+	}
+
+	// Plain scrollback keeps the text but drops the styling
+	plain, _ := sb.Render("scrollback")
+	if !strings.Contains(plain, "red line") || strings.Contains(plain, "\x1b[") {
+		t.Errorf("Expected unstyled scrollback, got %q", plain)
+	}
+}
+
+func TestScreenBuffer_RawCanonical(t *testing.T) {
+	// The same screen, drawn with differently split and ordered sequences,
+	// redundant ones and the cursor left in different places
+	streams := []string{
+		"\x1b[1;31mbold red\x1b[0m plain \x1b[44mon blue\x1b[m\r\n" +
+			"\x1b[38;5;208morange\x1b[39m done\x1b[3;1H",
+		"\x1b[31m\x1b[1mbold\x1b[1m red\x1b[22;39m \x1b[0mplain \x1b[48;5;4mon \x1b[44mblue\x1b[49m\r\n" +
+			"\x1b[38;5;208mor\x1b[38;5;208mange\x1b[0m done\x1b[1;5H",
+	}
+
+	var raws, canonicals []string
+	for _, stream := range streams {
+		sb := NewScreenBuffer(30, 3)
+		sb.Write([]byte(stream))
+		raw, _ := sb.Render("raw")
+		canonical, err := sb.Render("raw_canonical")
+		if err != nil {
+			t.Fatalf("Render failed: %v", err)
+		}
+		raws = append(raws, raw)
+		canonicals = append(canonicals, canonical)
+	}
+	if raws[0] == raws[1] {
+		t.Fatal("Expected the raw renders to differ")
+	}
+	if canonicals[0] != canonicals[1] {
+		t.Errorf("Expected identical canonical renders, got\n%q\n%q", canonicals[0], canonicals[1])
+	}
+
+	// One sequence per change, a lone reset back to the default style, a
+	// reset ending each styled line and no cursor trailer
+	want := "\x1b[0;1;31mbold red\x1b[0m plain \x1b[0;44mon blue\x1b[0m" + strings.Repeat(" ", 8) + "\n" +
+		"\x1b[0;38;5;208morange\x1b[0m done" + strings.Repeat(" ", 19) + "\n" +
+		strings.Repeat(" ", 30)
+	if canonicals[0] != want {
+		t.Errorf("Expected\n%q\ngot\n%q", want, canonicals[0])
+	}
+
+	// A palette color given from the 256-color palette is written as the
+	// 16-color one
+	palette := [2]string{}
+	for i, stream := range []string{"\x1b[38;5;1mX", "\x1b[31mX"} {
+		sb := NewScreenBuffer(5, 1)
+		sb.Write([]byte(stream))
+		palette[i], _ = sb.Render("raw_canonical")
+	}
+	if palette[0] != palette[1] || !strings.HasPrefix(palette[0], "\x1b[0;31mX") {
+		t.Errorf("Expected both palette colors as SGR 31, got %q and %q", palette[0], palette[1])
+	}
+}
+
+func TestScreenBuffer_RenderFrame(t *testing.T) {
+	sb := NewScreenBuffer(20, 3)
+	sb.Write([]byte("\x1b[1mbold\x1b[0m text\r\nnext"))
+
+	frame, err := sb.RenderFrame([]string{"plain", "raw", "lines"}, RenderOptions{})
+	if err != nil {
+		t.Fatalf("RenderFrame failed: %v", err)
+	}
+	for _, format := range []string{"plain", "raw", "lines"} {
+		if want, _ := sb.Render(format); frame.Renders[format] != want {
+			t.Errorf("Expected the %s rendering %q, got %q", format, want, frame.Renders[format])
+		}
+	}
+	if frame.Generation != sb.Generation() || frame.CursorX != 4 || frame.CursorY != 1 || frame.RawEnd != sb.RawDataEnd() {
+		t.Errorf("Expected the buffer's state alongside the renderings, got %+v", frame)
+	}
+}
+
+func TestScreenBuffer_Generation(t *testing.T) {
+	sb := NewScreenBuffer(20, 5)
+	start := sb.Generation()
+
+	// Cursor movement alone is not a change
+	sb.Write([]byte("\x1b[3;4H"))
+	if gen := sb.Generation(); gen != start {
+		t.Errorf("Cursor movement advanced the generation from %d to %d", start, gen)
+	}
+
+	sb.Write([]byte("x"))
+	row2 := sb.RowsGeneration(2, 2)
+	if row2 <= start || sb.Generation() != row2 {
+		t.Errorf("Expected row 2 to carry the new generation %d, got %d", sb.Generation(), row2)
+	}
+	if gen := sb.RowsGeneration(3, 4); gen != 0 {
+		t.Errorf("Expected untouched rows at generation 0, got %d", gen)
+	}
+
+	// Scrolling moves every row
+	sb.Write([]byte("\x1b[5;1H\n"))
+	if gen := sb.RowsGeneration(0, 0); gen <= row2 {
+		t.Errorf("Expected scrolling to change row 0, got generation %d", gen)
+	}
+
+	content, gen, err := sb.RenderGeneration("plain")
+	if err != nil || gen != sb.Generation() || !strings.Contains(content, "x") {
+		t.Errorf("RenderGeneration returned %q at %d (err %v), current %d", content, gen, err, sb.Generation())
+	}
+}
+
+func TestScreenBuffer_Lines(t *testing.T) {
+	sb := NewScreenBuffer(10, 4)
+	sb.Write([]byte("top\r\n\r\nthird   "))
+
+	// Every row, blank ones included, with trailing spaces trimmed
+	lines, gen := sb.Lines(0)
+	var texts []string
+	for i, line := range lines {
+		if line.Row != i {
+			t.Errorf("Expected row %d at index %d, got %d", i, i, line.Row)
+		}
+		texts = append(texts, line.Text)
+	}
+	if got := strings.Join(texts, "|"); got != "top||third|" {
+		t.Errorf("Unexpected rows %q", got)
+	}
+
+	// A partial update only dirties the rows it touched, which keep their
+	// indices
+	sb.Write([]byte("\x1b[4;1Hbottom"))
+	dirty, next := sb.Lines(gen)
+	if len(dirty) != 1 || dirty[0].Row != 3 || dirty[0].Text != "bottom" || dirty[0].DirtyGeneration != next {
+		t.Errorf("Expected only row 3 dirty at generation %d, got %+v", next, dirty)
+	}
+	if next <= gen {
+		t.Errorf("Expected the generation to advance past %d, got %d", gen, next)
+	}
+	if dirty, _ := sb.Lines(next); len(dirty) != 0 {
+		t.Errorf("Expected nothing dirty since %d, got %+v", next, dirty)
+	}
+	lines, _ = sb.Lines(0)
+	if len(lines) != 4 || lines[0].Text != "top" || lines[0].DirtyGeneration > gen || lines[3].Text != "bottom" {
+		t.Errorf("Expected all rows with row 0 unchanged, got %+v", lines)
+	}
+
+	// The lines format renders the same rows as JSON
+	rendered, err := sb.Render("lines")
+	if err != nil || !strings.HasPrefix(rendered, `[{"row":0,"text":"top","dirty_generation":`) {
+		t.Errorf("Unexpected lines rendering %q (%v)", rendered, err)
+	}
+}
+
+func TestScreenBuffer_WaitStable(t *testing.T) {
+	sb := NewScreenBuffer(20, 5)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	// A clock on row 0 that never stops
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			case <-time.After(10 * time.Millisecond):
+				sb.Write([]byte(fmt.Sprintf("\x1b[1;1H%d", i)))
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if _, err := sb.WaitStable(ctx, 100*time.Millisecond, 0, -1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the whole screen never to settle, got %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	start := time.Now()
+	gen, err := sb.WaitStable(ctx, 100*time.Millisecond, 1, 4)
+	if err != nil {
+		t.Fatalf("Expected rows 1-4 to settle, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Settled after %v, before the stable period", elapsed)
+	}
+	if gen != sb.RowsGeneration(1, 4) {
+		t.Errorf("Returned generation %d, rows are at %d", gen, sb.RowsGeneration(1, 4))
+	}
+}
+
+func TestScreenBuffer_WaitMatch(t *testing.T) {
+	sb := NewScreenBuffer(20, 5)
+	sb.Write([]byte("> "))
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		sb.Write([]byte("hello\r\nYou typed: hello\r\n> "))
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	match, _, version, err := sb.WaitMatch(ctx, regexp.MustCompile(`You typed: (\w+)`))
+	if err != nil {
+		t.Fatalf("Expected a match, got %v", err)
+	}
+	if len(match) != 2 || match[1] != "hello" {
+		t.Errorf("Expected the submatch hello, got %q", match)
+	}
+	if version != sb.Generation() {
+		t.Errorf("Expected the version matched, %d, got %d", sb.Generation(), version)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, screen, _, err := sb.WaitMatch(ctx, regexp.MustCompile(`Goodbye`))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected no match, got %v", err)
+	}
+	if !strings.Contains(screen, "You typed: hello") {
+		t.Errorf("Expected the last screen on failure, got %q", screen)
+	}
+}
+
+func TestScreenBuffer_Close(t *testing.T) {
+	sb := NewScreenBuffer(20, 3)
+	sb.Write([]byte("one\r\ntwo\r\nthree\r\nfour\x1b[?25h"))
+	if lines, _ := sb.ScrollbackInfo(); lines == 0 {
+		t.Fatal("Expected a line in scrollback")
+	}
+
+	sb.Close()
+	sb.Close() // Closing twice is safe
+
+	// The final screen is kept, everything else is dropped
+	if content, _ := sb.Render("plain"); !strings.Contains(content, "four") {
+		t.Errorf("Expected the final screen after Close, got %q", content)
+	}
+	if lines, max := sb.ScrollbackInfo(); lines != 0 || max != 0 {
+		t.Errorf("Expected scrollback dropped, got %d of %d lines", lines, max)
+	}
+	if raw := sb.GetRawData(); len(raw) != 0 {
+		t.Errorf("Expected raw output dropped, got %q", raw)
+	}
+	if diag := sb.ParserDiagnostics(); diag.Total != 0 || sb.Degraded() {
+		t.Errorf("Expected no diagnostics after Close, got %+v", diag)
+	}
+	sb.ResetParserDiagnostics()
+	sb.Reset()
+
+	// Output after Close is ignored rather than drawn
+	generation := sb.Generation()
+	sb.Write([]byte("late"))
+	if content, _ := sb.Render("plain"); strings.Contains(content, "late") || sb.Generation() != generation {
+		t.Errorf("Expected output after Close to be ignored, got %q", content)
+	}
+}
+
+func TestScreenBuffer_Freeze(t *testing.T) {
+	sb := NewScreenBuffer(20, 3)
+	sb.Write([]byte("\x1b[31mfinal\x1b[0m screen"))
+	sb.Freeze()
+	before, _ := sb.Render("raw")
+	generation := sb.Generation()
+
+	// Changes are dropped and counted, reads carry on
+	sb.Write([]byte("\r\nlate output"))
+	sb.Resize(40, 10)
+	sb.Clear()
+	after, err := sb.Render("raw")
+	if err != nil || after != before || sb.Generation() != generation {
+		t.Errorf("Expected the frozen render to stay\n%q\ngot\n%q (%v)", before, after, err)
+	}
+	if info := sb.Info(); info.FrozenIgnored != 3 || info.Width != 20 || !sb.Frozen() {
+		t.Errorf("Expected 3 ignored changes at the old size, got %+v", info)
+	}
+
+	sb.Unfreeze()
+	sb.Write([]byte(" again"))
+	if content, _ := sb.Render("plain"); content != "final screen again" || sb.Frozen() {
+		t.Errorf("Expected output drawn after Unfreeze, got %q", content)
+	}
+
+	// A closed buffer stays frozen
+	sb.Close()
+	sb.Unfreeze()
+	if !sb.Frozen() {
+		t.Error("Expected a closed buffer to stay frozen")
+	}
+}
+
+func TestScreenBuffer_Info(t *testing.T) {
+	sb := NewScreenBuffer(20, 5)
+	sb.SetScrollbackSize(10)
+
+	// 30 lines on a 5-row screen scroll 26 lines off the top, the last line
+	// break leaving the cursor on an empty bottom row
+	var output strings.Builder
+	for i := 1; i <= 30; i++ {
+		fmt.Fprintf(&output, "line %d\r\n", i)
+	}
+	sb.Write([]byte(output.String()))
+
+	info := sb.Info()
+	if info.Width != 20 || info.Height != 5 {
+		t.Errorf("Expected a 20x5 screen, got %dx%d", info.Width, info.Height)
+	}
+	if info.ScrollbackLines != 10 || info.ScrollbackCapacity != 10 || info.ScrollbackDropped != 16 {
+		t.Errorf("Expected 10 of 10 scrollback lines with 16 dropped, got %+v", info)
+	}
+	if info.RawBytes != output.Len() || info.RawDiscarded != 0 {
+		t.Errorf("Expected %d raw bytes and none discarded, got %+v", output.Len(), info)
+	}
+	if info.Generation != sb.Generation() || info.Generation == 0 {
+		t.Errorf("Expected generation %d, got %d", sb.Generation(), info.Generation)
+	}
+	if info.AltScreen || info.Closed {
+		t.Errorf("Expected the primary screen of an open buffer, got %+v", info)
+	}
+
+	sb.Write([]byte("\x1b[?1049h"))
+	if info := sb.Info(); !info.AltScreen {
+		t.Error("Expected the alternate screen to be reported")
+	}
+
+	sb.Close()
+	if info := sb.Info(); !info.Closed || info.ScrollbackLines != 0 || info.RawBytes != 0 {
+		t.Errorf("Expected a closed, emptied buffer, got %+v", info)
+	}
+}
+
+func TestScreenBuffer_CloseReleasesMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Allocates thousands of buffers")
+	}
+
+	// Closed buffers that are still referenced, as a session is while a
+	// call on it finishes, must only cost their visible screen
+	line := strings.Repeat("x", 38) + "\r\n"
+	output := []byte(strings.Repeat(line, 300))
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	buffers := make([]*ScreenBuffer, 2000)
+	for i := range buffers {
+		sb := NewScreenBuffer(40, 10)
+		sb.Write(output)
+		sb.Close()
+		buffers[i] = sb
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+
+	// Each closed buffer keeps 400 cells, about 10 KB; open, each would
+	// also hold 290 lines of scrollback and 12 KB of raw output
+	growth := int64(after.HeapAlloc) - int64(before.HeapAlloc)
+	if limit := int64(64 << 20); growth > limit {
+		t.Errorf("Heap grew by %d MB for %d closed buffers, limit %d MB", growth>>20, len(buffers), limit>>20)
+	}
+	runtime.KeepAlive(buffers)
+}
@@ -0,0 +1,135 @@
+package terminal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ColorSpace is how the application gave a color, so a raw render can
+// write it back the same way
+type ColorSpace uint8
+
+const (
+	ColorSpaceRGB ColorSpace = iota // 24-bit, SGR 38;2;r;g;b; also colors not set by the parser
+	ColorSpace16                    // SGR 30-37 and 90-97, Index is the palette entry
+	ColorSpace256                   // SGR 38;5;n, Index is n
+)
+
+// ColorDepth is the most colors raw renders write; colors given in a
+// larger space are matched to the nearest one that fits
+type ColorDepth string
+
+const (
+	ColorDepthOriginal ColorDepth = "original" // Each color as the application gave it
+	ColorDepth256      ColorDepth = "256"      // Truecolor becomes the nearest 256-color entry
+	ColorDepth16       ColorDepth = "16"       // Everything becomes the nearest of the 16 palette colors
+)
+
+// ColorDepths lists the accepted color depths
+var ColorDepths = []string{string(ColorDepthOriginal), string(ColorDepth256), string(ColorDepth16)}
+
+// ParseColorDepth validates a color depth
+func ParseColorDepth(s string) (ColorDepth, error) {
+	for _, d := range ColorDepths {
+		if s == d {
+			return ColorDepth(s), nil
+		}
+	}
+	return "", fmt.Errorf("must be one of: %s", strings.Join(ColorDepths, ", "))
+}
+
+// color256 returns entry n of the 256-color palette: the 16 palette
+// colors, then a 6x6x6 cube and a grayscale ramp
+func color256(n int) Color {
+	var c Color
+	switch {
+	case n < 0 || n > 255:
+		return Color{Default: true}
+	case n < 16:
+		c = paletteColor(n)
+	case n < 232:
+		n -= 16
+		c = Color{R: uint8(n / 36 * 51), G: uint8(n / 6 % 6 * 51), B: uint8(n % 6 * 51)}
+		n += 16
+	default:
+		gray := uint8(8 + (n-232)*10)
+		c = Color{R: gray, G: gray, B: gray}
+	}
+	c.Space = ColorSpace256
+	c.Index = uint8(n)
+	return c
+}
+
+// downsample returns c in a space that fits depth
+func (c Color) downsample(depth ColorDepth) Color {
+	if c.Default {
+		return c
+	}
+	switch depth {
+	case ColorDepth256:
+		if c.Space == ColorSpaceRGB {
+			return nearestColor(c, 16, 256)
+		}
+	case ColorDepth16:
+		switch {
+		case c.Space == ColorSpace256 && c.Index < 16:
+			c.Space = ColorSpace16
+		case c.Space != ColorSpace16:
+			c = nearestColor(c, 0, 16)
+			c.Space = ColorSpace16
+		}
+	}
+	return c
+}
+
+// nearestColor returns the entry of the 256-color palette from lo up to hi
+// closest to c. The 16 palette colors are compared as the default theme
+// shows them.
+func nearestColor(c Color, lo, hi int) Color {
+	best, bestDist := color256(lo), -1
+	for n := lo; n < hi; n++ {
+		e := color256(n)
+		dr, dg, db := int(c.R)-int(e.R), int(c.G)-int(e.G), int(c.B)-int(e.B)
+		if dist := dr*dr + dg*dg + db*db; bestDist < 0 || dist < bestDist {
+			best, bestDist = e, dist
+		}
+	}
+	return best
+}
+
+// sgrColor returns the SGR parameters that set c as the foreground, or the
+// background when background is set
+func sgrColor(c Color, background bool, depth ColorDepth) string {
+	c = c.downsample(depth)
+	base := 30
+	if background {
+		base = 40
+	}
+	switch c.Space {
+	case ColorSpace16:
+		if c.Index < 8 {
+			return fmt.Sprint(base + int(c.Index))
+		}
+		return fmt.Sprint(base + 60 + int(c.Index) - 8)
+	case ColorSpace256:
+		return fmt.Sprintf("%d;5;%d", base+8, c.Index)
+	}
+	return fmt.Sprintf("%d;2;%d;%d;%d", base+8, c.R, c.G, c.B)
+}
+
+// canonicalColor returns c downsampled to depth and in the smallest space
+// that holds it, with only the fields that space uses set, so colors that
+// look the same compare equal however the application gave them
+func canonicalColor(c Color, depth ColorDepth) Color {
+	if c.Default {
+		return Color{Default: true}
+	}
+	c = c.downsample(depth)
+	switch {
+	case c.Space == ColorSpace16 || (c.Space == ColorSpace256 && c.Index < 16):
+		return paletteColor(int(c.Index))
+	case c.Space == ColorSpace256:
+		return color256(int(c.Index))
+	}
+	return Color{R: c.R, G: c.G, B: c.B}
+}
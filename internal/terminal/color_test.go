@@ -0,0 +1,124 @@
+package terminal
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var sgrPattern = regexp.MustCompile(`\x1b\[([0-9;]*)m`)
+
+// sgrRuns returns the SGR parameters in s, one list per run of sequences
+// with nothing between them, skipping runs not followed by text and runs
+// that repeat the one before
+func sgrRuns(s string) [][]int {
+	var runs [][]int
+	var run []int
+	pos := 0
+	for _, m := range sgrPattern.FindAllStringSubmatchIndex(s, -1) {
+		if m[0] > pos {
+			if run != nil && (len(runs) == 0 || !reflect.DeepEqual(run, runs[len(runs)-1])) {
+				runs = append(runs, run)
+			}
+			run = nil
+		}
+		for _, p := range strings.Split(s[m[2]:m[3]], ";") {
+			n, _ := strconv.Atoi(p) // An empty parameter is 0
+			run = append(run, n)
+		}
+		pos = m[1]
+	}
+	if run != nil && pos < len(s) && (len(runs) == 0 || !reflect.DeepEqual(run, runs[len(runs)-1])) {
+		runs = append(runs, run)
+	}
+	return runs
+}
+
+func TestRawColorRoundTrip(t *testing.T) {
+	ls, err := os.ReadFile(filepath.Join("..", "..", "test", "fixtures", "differential", "ls_color.bin"))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	// Captures set attributes, then the foreground, then the background,
+	// the order a raw render writes them in
+	tests := []struct {
+		name    string
+		capture string
+	}{
+		{"ls", string(ls)},
+		{"16 colors", "\x1b[0m\x1b[31mred\x1b[0m \x1b[1;92mbright\x1b[0m\r\n" +
+			"\x1b[37;44mon blue\x1b[0m \x1b[4;30;103mon bright yellow\x1b[0;97mwhite\x1b[0m\r\n"},
+		{"256 colors", "\x1b[0m\x1b[38;5;1mpalette\x1b[0m \x1b[38;5;208;48;5;236morange\x1b[0m\r\n"},
+		{"truecolor", "\x1b[0m\x1b[38;2;12;34;56mdeep\x1b[0m \x1b[1;38;2;255;255;255;48;2;200;100;0mwarm\x1b[0m\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buffer := NewScreenBuffer(80, 24)
+			buffer.Write([]byte(tt.capture))
+			raw, err := buffer.Render("raw")
+			if err != nil {
+				t.Fatalf("Render failed: %v", err)
+			}
+			want, got := sgrRuns(tt.capture), sgrRuns(raw)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Expected SGR runs\n%v\ngot\n%v", want, got)
+			}
+		})
+	}
+}
+
+func TestRawColorDepth(t *testing.T) {
+	tests := []struct {
+		capture string
+		depth   ColorDepth
+		want    string
+	}{
+		{"\x1b[38;2;255;0;0mX", ColorDepthOriginal, "\x1b[38;2;255;0;0m"},
+		{"\x1b[38;2;255;0;0mX", "", "\x1b[38;2;255;0;0m"},
+		{"\x1b[38;2;255;0;0mX", ColorDepth256, "\x1b[38;5;196m"},
+		{"\x1b[48;2;9;9;9mX", ColorDepth256, "\x1b[48;5;232m"},
+		{"\x1b[38;2;255;0;0mX", ColorDepth16, "\x1b[31m"},
+		{"\x1b[38;2;255;80;80mX", ColorDepth16, "\x1b[91m"},
+		{"\x1b[48;2;0;0;160mX", ColorDepth16, "\x1b[44m"},
+		{"\x1b[38;5;231mX", ColorDepth16, "\x1b[97m"},
+		{"\x1b[38;5;4mX", ColorDepth16, "\x1b[34m"},
+		// Colors that already fit are written as they were given
+		{"\x1b[38;5;208mX", ColorDepth256, "\x1b[38;5;208m"},
+		{"\x1b[1;35;102mX", ColorDepth16, "\x1b[1;35;102m"},
+	}
+
+	for _, tt := range tests {
+		buffer := NewScreenBuffer(10, 2)
+		buffer.SetRenderOptions(RenderOptions{Colors: tt.depth})
+		buffer.Write([]byte(tt.capture))
+		raw, err := buffer.Render("raw")
+		if err != nil {
+			t.Fatalf("Render failed: %v", err)
+		}
+		if !strings.HasPrefix(raw, "\x1b[0m"+tt.want+"X") {
+			t.Errorf("%q at depth %q: expected %q, got %q", tt.capture, tt.depth, tt.want, raw)
+		}
+		// The same applies to the scrollback formats
+		scrollback, _ := buffer.Render("scrollback_raw")
+		if !strings.Contains(scrollback, tt.want+"X") {
+			t.Errorf("%q at depth %q: expected %q in scrollback_raw, got %q", tt.capture, tt.depth, tt.want, scrollback)
+		}
+	}
+}
+
+func TestParseColorDepth(t *testing.T) {
+	for _, s := range ColorDepths {
+		if d, err := ParseColorDepth(s); err != nil || string(d) != s {
+			t.Errorf("ParseColorDepth(%q) = %q, %v", s, d, err)
+		}
+	}
+	if _, err := ParseColorDepth("8"); err == nil {
+		t.Error("Expected an error for an unknown depth")
+	}
+}
@@ -0,0 +1,76 @@
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Reasons a command cannot be launched. Use errors.Is to test a
+// *CommandError against them.
+var (
+	ErrCommandNotFound = errors.New("command not found")
+	ErrNotExecutable   = errors.New("command is not executable")
+)
+
+// CommandError reports a command that could not be resolved to an
+// executable file
+type CommandError struct {
+	Command string // Command as requested
+	Path    string // Resolved path that was attempted, empty if PATH had no match
+	Err     error  // ErrCommandNotFound or ErrNotExecutable
+	Detail  string // Additional explanation, e.g. "is a directory"
+}
+
+func (e *CommandError) Error() string {
+	msg := fmt.Sprintf("%s: %s", e.Err, e.Command)
+	if e.Path != "" {
+		msg += fmt.Sprintf(" (resolved to %s)", e.Path)
+	} else {
+		msg += " (not found in PATH)"
+	}
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	return msg
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// ResolveCommand finds the executable a command refers to. Commands
+// containing a slash are taken as paths; others are searched for in PATH.
+// The returned error is a *CommandError describing why the command can't run.
+func ResolveCommand(command string) (string, error) {
+	if !strings.Contains(command, "/") {
+		path, err := exec.LookPath(command)
+		if err != nil && !errors.Is(err, exec.ErrDot) {
+			return "", &CommandError{Command: command, Err: ErrCommandNotFound}
+		}
+		return path, nil
+	}
+
+	path := command
+	if abs, err := filepath.Abs(command); err == nil {
+		path = abs
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", &CommandError{Command: command, Path: path, Err: ErrCommandNotFound}
+		}
+		return "", &CommandError{Command: command, Path: path, Err: ErrNotExecutable, Detail: err.Error()}
+	}
+	if info.IsDir() {
+		return "", &CommandError{Command: command, Path: path, Err: ErrNotExecutable, Detail: "is a directory"}
+	}
+	if info.Mode().Perm()&0o111 == 0 {
+		return "", &CommandError{Command: command, Path: path, Err: ErrNotExecutable, Detail: "missing execute permission"}
+	}
+	return path, nil
+}
@@ -0,0 +1,83 @@
+package terminal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveCommand(t *testing.T) {
+	dir := t.TempDir()
+
+	script := filepath.Join(dir, "script.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho hi\n"), 0o755); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+	noExec := filepath.Join(dir, "noexec.sh")
+	if err := os.WriteFile(noExec, []byte("#!/bin/sh\necho hi\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		command    string
+		wantErr    error
+		wantDetail string
+	}{
+		{"path lookup", "sh", nil, ""},
+		{"absolute path", script, nil, ""},
+		{"missing binary", filepath.Join(dir, "missing"), ErrCommandNotFound, ""},
+		{"missing from PATH", "definitely-not-a-real-command", ErrCommandNotFound, "not found in PATH"},
+		{"directory", dir, ErrNotExecutable, "is a directory"},
+		{"no execute bit", noExec, ErrNotExecutable, "missing execute permission"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, err := ResolveCommand(tt.command)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if !filepath.IsAbs(path) {
+					t.Errorf("Expected absolute path, got %q", path)
+				}
+				return
+			}
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected %v, got %v", tt.wantErr, err)
+			}
+			var cmdErr *CommandError
+			if !errors.As(err, &cmdErr) {
+				t.Fatalf("Expected *CommandError, got %T", err)
+			}
+			if tt.wantDetail != "" && !strings.Contains(err.Error(), tt.wantDetail) {
+				t.Errorf("Expected %q in error, got %q", tt.wantDetail, err.Error())
+			}
+			// Path commands report the resolved path that was tried
+			if strings.Contains(tt.command, "/") && !strings.Contains(err.Error(), tt.command) {
+				t.Errorf("Expected resolved path in error, got %q", err.Error())
+			}
+		})
+	}
+}
+
+func TestPTYWrapper_ExecFormatError(t *testing.T) {
+	// Executable bit set, but no shebang and not a binary
+	bogus := filepath.Join(t.TempDir(), "bogus")
+	if err := os.WriteFile(bogus, []byte("not a program\n"), 0o755); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	p, err := NewPTYWrapper(bogus, nil, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error creating wrapper: %v", err)
+	}
+	err = p.Start()
+	if !errors.Is(err, ErrNotExecutable) || !strings.Contains(err.Error(), "exec format error") {
+		t.Fatalf("Expected exec format error, got %v", err)
+	}
+}
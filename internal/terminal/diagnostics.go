@@ -0,0 +1,129 @@
+package terminal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Strictness is how loudly the parser reports sequences it ignores
+type Strictness string
+
+const (
+	StrictnessOff  Strictness = "off"  // Only count them
+	StrictnessLog  Strictness = "log"  // Also log each one with its raw bytes
+	StrictnessMark Strictness = "mark" // Also draw U+FFFD and mark the buffer degraded
+)
+
+// Strictnesses lists the accepted strictness levels
+var Strictnesses = []string{string(StrictnessOff), string(StrictnessLog), string(StrictnessMark)}
+
+// ParseStrictness validates a strictness level
+func ParseStrictness(s string) (Strictness, error) {
+	for _, level := range Strictnesses {
+		if s == level {
+			return Strictness(s), nil
+		}
+	}
+	return "", fmt.Errorf("must be one of: %s", strings.Join(Strictnesses, ", "))
+}
+
+// maxDiagnosticSamples bounds the raw samples kept per parser
+const maxDiagnosticSamples = 32
+
+// maxSampleBytes truncates long samples such as DCS payloads
+const maxSampleBytes = 64
+
+// UnhandledSample is one escape sequence the parser ignored
+type UnhandledSample struct {
+	Kind     string `json:"kind"`     // Counter key, e.g. "CSI ?h" or "DCS"
+	Sequence string `json:"sequence"` // Raw bytes, truncated to maxSampleBytes
+}
+
+// ParserDiagnostics counts escape sequences the parser received but did not
+// act on, to show which terminal features an application relies on that the
+// buffer doesn't emulate
+type ParserDiagnostics struct {
+	Total    int64             `json:"total"`
+	Counts   map[string]int64  `json:"counts"`   // By sequence class and final byte
+	Samples  []UnhandledSample `json:"samples"`  // Most recent last
+	Degraded bool              `json:"degraded"` // An unhandled sequence arrived in mark mode
+
+	// The long line guard; see ScreenBuffer.SetMaxLineWraps
+	ClippedLines int64 `json:"clipped_lines"` // Lines cut short after wrapping the maximum number of times
+	DroppedChars int64 `json:"dropped_chars"` // Characters dropped from them
+}
+
+// diagnostics collects unhandled sequences for a parser. It is guarded by
+// the owning buffer's lock.
+type diagnostics struct {
+	total    int64
+	counts   map[string]int64
+	samples  []UnhandledSample // Ring of up to maxDiagnosticSamples
+	next     int               // Ring position of the next sample
+	degraded bool
+
+	clippedLines int64
+	droppedChars int64
+}
+
+// record counts an unhandled sequence of the given kind and keeps a sample
+func (d *diagnostics) record(kind string, seq []byte) {
+	if d.counts == nil {
+		d.counts = make(map[string]int64)
+	}
+	d.total++
+	d.counts[kind]++
+
+	if len(seq) > maxSampleBytes {
+		seq = seq[:maxSampleBytes]
+	}
+	sample := UnhandledSample{Kind: kind, Sequence: string(seq)}
+	if len(d.samples) < maxDiagnosticSamples {
+		d.samples = append(d.samples, sample)
+		return
+	}
+	d.samples[d.next] = sample
+	d.next = (d.next + 1) % maxDiagnosticSamples
+}
+
+func (d *diagnostics) snapshot() ParserDiagnostics {
+	counts := make(map[string]int64, len(d.counts))
+	for k, v := range d.counts {
+		counts[k] = v
+	}
+	samples := make([]UnhandledSample, 0, len(d.samples))
+	samples = append(samples, d.samples[d.next:]...)
+	samples = append(samples, d.samples[:d.next]...)
+	return ParserDiagnostics{
+		Total:        d.total,
+		Counts:       counts,
+		Samples:      samples,
+		Degraded:     d.degraded,
+		ClippedLines: d.clippedLines,
+		DroppedChars: d.droppedChars,
+	}
+}
+
+func (d *diagnostics) reset() {
+	*d = diagnostics{}
+}
+
+// csiKind names a CSI sequence by its private marker, intermediates and
+// final byte, dropping numeric parameters so e.g. all DECSET modes count
+// together as "CSI ?h"
+func csiKind(params []byte, final byte) string {
+	kind := []byte("CSI ")
+	if len(params) > 0 && params[0] >= '<' && params[0] <= '?' {
+		kind = append(kind, params[0])
+	}
+	for _, b := range params {
+		if b >= 0x20 && b <= 0x2F {
+			kind = append(kind, b)
+		}
+	}
+	if final < 0x20 || final >= 0x7F {
+		// A control byte cut the sequence short
+		return fmt.Sprintf("%s0x%02x", kind, final)
+	}
+	return string(append(kind, final))
+}
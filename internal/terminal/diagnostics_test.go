@@ -0,0 +1,182 @@
+package terminal
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestParserDiagnostics_CountsUnhandledSequences(t *testing.T) {
+	buffer := NewScreenBuffer(20, 5)
+	buffer.Write([]byte("\x1b[?1049h\x1b[?25l\x1b[1;5r\x1b[4h\x1b[2 q"))
+	buffer.Write([]byte("\x1bPq#0;2;0;0;0\x1b\\"))
+	buffer.Write([]byte("\x1b(0\x1bH\x1bZ"))
+	// Handled sequences are not counted, US-ASCII designations included
+	buffer.Write([]byte("\x1b(B\x1b)B\x1b[1;1H\x1b[31mhi\x1b[0m\x1b[2J\x1b7\x1b8"))
+
+	diag := buffer.ParserDiagnostics()
+	want := map[string]int64{
+		"CSI ?h":    1,
+		"CSI ?l":    1,
+		"CSI r":     1,
+		"CSI h":     1,
+		"CSI  q":    1,
+		"DCS sixel": 1,
+		"ESC (0":    1,
+		"ESC H":     1,
+		"ESC Z":     1,
+	}
+	if diag.Total != 9 {
+		t.Errorf("Expected 9 unhandled sequences, got %d: %v", diag.Total, diag.Counts)
+	}
+	for kind, n := range want {
+		if diag.Counts[kind] != n {
+			t.Errorf("Expected %d of %q, got %d (all: %v)", n, kind, diag.Counts[kind], diag.Counts)
+		}
+	}
+	if len(diag.Counts) != len(want) {
+		t.Errorf("Unexpected kinds counted: %v", diag.Counts)
+	}
+
+	if len(diag.Samples) != 9 {
+		t.Fatalf("Expected 9 samples, got %d", len(diag.Samples))
+	}
+	if s := diag.Samples[0]; s.Kind != "CSI ?h" || s.Sequence != "\x1b[?1049h" {
+		t.Errorf("Unexpected first sample %+v", s)
+	}
+	if s := diag.Samples[5]; s.Kind != "DCS sixel" || s.Sequence != "\x1bPq#0;2;0;0;0\x1b\\" {
+		t.Errorf("Unexpected DCS sample %+v", s)
+	}
+
+	buffer.ResetParserDiagnostics()
+	if diag := buffer.ParserDiagnostics(); diag.Total != 0 || len(diag.Counts) != 0 || len(diag.Samples) != 0 {
+		t.Errorf("Expected empty diagnostics after reset, got %+v", diag)
+	}
+}
+
+func TestParserDiagnostics_SampleRing(t *testing.T) {
+	buffer := NewScreenBuffer(20, 5)
+	for i := 0; i < maxDiagnosticSamples+8; i++ {
+		buffer.Write([]byte(fmt.Sprintf("\x1b[%dr", i)))
+	}
+
+	diag := buffer.ParserDiagnostics()
+	if diag.Total != maxDiagnosticSamples+8 || diag.Counts["CSI r"] != diag.Total {
+		t.Errorf("Unexpected counts: total %d, %v", diag.Total, diag.Counts)
+	}
+	if len(diag.Samples) != maxDiagnosticSamples {
+		t.Fatalf("Expected %d samples, got %d", maxDiagnosticSamples, len(diag.Samples))
+	}
+	// The oldest samples are dropped and the rest stay in order
+	if got := diag.Samples[0].Sequence; got != "\x1b[8r" {
+		t.Errorf("Expected oldest kept sample to be the 9th, got %q", got)
+	}
+	if got := diag.Samples[len(diag.Samples)-1].Sequence; got != fmt.Sprintf("\x1b[%dr", maxDiagnosticSamples+7) {
+		t.Errorf("Expected newest sample last, got %q", got)
+	}
+
+	// Long payloads are truncated
+	buffer.Write([]byte("\x1bP" + string(make([]byte, 500)) + "\x1b\\"))
+	diag = buffer.ParserDiagnostics()
+	if got := diag.Samples[len(diag.Samples)-1]; got.Kind != "DCS other" || len(got.Sequence) != maxSampleBytes {
+		t.Errorf("Expected a truncated DCS sample, got %s with %d bytes", got.Kind, len(got.Sequence))
+	}
+}
+
+func TestParserDiagnostics_DCS(t *testing.T) {
+	buffer := NewScreenBuffer(20, 3)
+	buffer.Write([]byte("\x1bP+q544e\x1b\\\x1bP$q\"p\x1b\\\x1bP1$tx\x1b\\"))
+
+	diag := buffer.ParserDiagnostics()
+	if diag.Counts["DCS XTGETTCAP"] != 1 || diag.Counts["DCS DECRQSS"] != 1 || diag.Counts["DCS other"] != 1 {
+		t.Errorf("Unexpected DCS counts %v", diag.Counts)
+	}
+	// Only DECRQSS is answered, and only once
+	if got := string(buffer.TakeReplies()); got != "\x1bP0$r\x1b\\" {
+		t.Errorf("Expected an invalid DECRQSS reply, got %q", got)
+	}
+	if got := buffer.TakeReplies(); len(got) != 0 {
+		t.Errorf("Expected replies to be taken once, got %q", got)
+	}
+
+	// A sixel image cut short by the next escape sequence doesn't swallow
+	// the text after it, and neither does one cancelled with CAN
+	buffer.Write([]byte("\x1bPq#0;2;0;0;0#0~~\x1b[2J\x1b[Hready"))
+	buffer.Write([]byte("\r\n\x1bPq#0~~\x18set"))
+	if screen, _ := buffer.Render("plain"); strings.Join(strings.Fields(screen), " ") != "ready set" {
+		t.Errorf("Expected text after truncated DCS to be drawn, got %q", screen)
+	}
+	diag = buffer.ParserDiagnostics()
+	if diag.Counts["DCS truncated"] != 2 || diag.Counts["DCS sixel"] != 0 {
+		t.Errorf("Expected two truncated DCS counted, got %v", diag.Counts)
+	}
+
+	// An oversized payload is dropped past the cap without leaving the
+	// parser stuck
+	buffer.Write([]byte("\x1bPq" + strings.Repeat("~", 3*maxStringPayload) + "\x1b\\!"))
+	if screen, _ := buffer.Render("plain"); !strings.HasSuffix(screen, "set!") {
+		t.Errorf("Expected text after an oversized DCS to be drawn, got %q", screen)
+	}
+	if diag := buffer.ParserDiagnostics(); diag.Counts["DCS sixel"] != 1 {
+		t.Errorf("Expected the oversized sixel counted, got %v", diag.Counts)
+	}
+}
+
+func TestParserStrictness(t *testing.T) {
+	var logged bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logged, nil)))
+
+	output := []byte("a\x1b[1;5rb\x1b]0;title\x07c")
+
+	// off only counts
+	buffer := NewScreenBuffer(20, 3)
+	buffer.SetSessionID("s1")
+	buffer.Write(output)
+	if screen, _ := buffer.Render("plain"); screen != "abc" || buffer.Degraded() || logged.Len() != 0 {
+		t.Errorf("off: expected a quiet, unmarked screen, got %q (degraded %v, log %q)", screen, buffer.Degraded(), logged.String())
+	}
+
+	// log adds a record with the raw bytes, leaving the screen alone
+	buffer.SetStrictness(StrictnessLog)
+	buffer.Write(output)
+	if screen, _ := buffer.Render("plain"); screen != "abcabc" || buffer.Degraded() {
+		t.Errorf("log: expected an unmarked screen, got %q (degraded %v)", screen, buffer.Degraded())
+	}
+	if !strings.Contains(logged.String(), "Unhandled escape sequence") ||
+		!strings.Contains(logged.String(), "session_id=s1") ||
+		!strings.Contains(logged.String(), `1;5r`) {
+		t.Errorf("log: expected a record with the sequence, got %q", logged.String())
+	}
+
+	// mark draws a replacement character and flags the buffer
+	buffer.SetStrictness(StrictnessMark)
+	buffer.Write(output)
+	if screen, _ := buffer.Render("plain"); screen != "abcabca\uFFFDbc" || !buffer.Degraded() {
+		t.Errorf("mark: expected a marked, degraded screen, got %q (degraded %v)", screen, buffer.Degraded())
+	}
+
+	// Window titles are ignored on purpose; other OSC commands are counted
+	buffer.Write([]byte("\x1b]52;c;aGk=\x07"))
+	diag := buffer.ParserDiagnostics()
+	if diag.Counts["OSC 52"] != 1 || diag.Counts["OSC 0"] != 0 || !diag.Degraded {
+		t.Errorf("Unexpected diagnostics %+v", diag)
+	}
+
+	buffer.ResetParserDiagnostics()
+	if buffer.Degraded() {
+		t.Error("Expected reset to clear the degraded flag")
+	}
+
+	// ncurses selects US-ASCII all the time; that must not mark the screen
+	buffer.Write([]byte("\x1b(B"))
+	if diag := buffer.ParserDiagnostics(); diag.Total != 0 || diag.Degraded {
+		t.Errorf("mark: expected US-ASCII designation to pass unmarked, got %+v", diag)
+	}
+
+	if _, err := ParseStrictness("loud"); err == nil {
+		t.Error("Expected an unknown strictness to be rejected")
+	}
+}
@@ -0,0 +1,175 @@
+//go:build tmux && !windows
+
+package terminal
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Run with: go test -tags tmux -run TestDifferential ./internal/terminal
+//
+// Each fixture is a raw byte stream recorded from a real program. It is
+// rendered both by our parser and by a detached tmux pane of the same size,
+// and the two plain screens must match.
+
+var recordDifferential = flag.Bool("differential.record", false, "Re-record the differential fixtures from their commands instead of comparing")
+
+// differentialDir holds the recorded byte streams, one <name>.bin each
+var differentialDir = filepath.Join("..", "..", "test", "fixtures", "differential")
+
+// differentialCorpus lists the fixtures. A fixture with a divergent gap is
+// expected to differ from tmux until the parser handles that gap; once it
+// matches, the test fails until the gap is cleared, so the fixture must keep
+// matching from then on.
+var differentialCorpus = []struct {
+	name       string
+	cols, rows int
+	record     []string      // Command recorded with -differential.record
+	settle     time.Duration // How long to record; 0 waits for the command to exit
+	divergent  string        // Known parser gap, "" when the screens must match
+}{
+	{"ls_color", 80, 24, []string{"ls", "--color=always", "-C", "/usr/share"}, 0, ""},
+	{"vim_startup", 80, 24, []string{"vim", "-u", "NONE", "-N", "-i", "NONE", "../../test/apps/README.md"}, 3 * time.Second, ""},
+	// Watching a lone sleep keeps other processes out of the frame
+	{"top_frame", 80, 24, []string{"sh", "-c", "sleep 30 & exec top -d 5 -p $!"}, 500 * time.Millisecond, "deferred autowrap at the last column"},
+}
+
+func TestDifferential(t *testing.T) {
+	if *recordDifferential {
+		for _, f := range differentialCorpus {
+			if err := recordFixture(filepath.Join(differentialDir, f.name+".bin"), f.cols, f.rows, f.record, f.settle); err != nil {
+				t.Fatalf("Failed to record %s: %v", f.name, err)
+			}
+			t.Logf("Recorded %s", f.name)
+		}
+		return
+	}
+
+	tmux, err := newTmuxServer()
+	if err != nil {
+		t.Skipf("Skipping: %v", err)
+	}
+	defer tmux.close()
+
+	for _, f := range differentialCorpus {
+		t.Run(f.name, func(t *testing.T) {
+			path, err := filepath.Abs(filepath.Join(differentialDir, f.name+".bin"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Failed to read fixture: %v", err)
+			}
+
+			buffer := NewScreenBuffer(f.cols, f.rows)
+			buffer.Write(data)
+			ours, _ := buffer.Render("plain")
+
+			theirs, err := tmux.render(path, f.cols, f.rows)
+			if err != nil {
+				t.Fatalf("tmux failed to render the fixture: %v", err)
+			}
+
+			diff := screenDiff(normalizeScreen(theirs), normalizeScreen(ours))
+			switch {
+			case f.divergent == "" && diff != "":
+				t.Errorf("Screen differs from tmux (- tmux, + ours):\n%s", diff)
+			case f.divergent != "" && diff == "":
+				t.Errorf("Screen now matches tmux; clear the divergent gap (%s) so it must keep matching", f.divergent)
+			case f.divergent != "":
+				t.Skipf("Expected divergence (%s), - tmux, + ours:\n%s", f.divergent, diff)
+			}
+		})
+	}
+}
+
+// tmuxServer is a private tmux server, so the harness never touches a
+// user's sessions or configuration
+type tmuxServer struct {
+	socket string
+}
+
+func newTmuxServer() (*tmuxServer, error) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		return nil, fmt.Errorf("tmux not available: %w", err)
+	}
+	return &tmuxServer{socket: fmt.Sprintf("terminalbridge-diff-%d", os.Getpid())}, nil
+}
+
+func (s *tmuxServer) run(ctx context.Context, args ...string) (string, error) {
+	args = append([]string{"-L", s.socket, "-f", os.DevNull}, args...)
+	out, err := exec.CommandContext(ctx, "tmux", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("tmux %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return string(out), nil
+}
+
+// render shows the byte stream in a fresh pane and captures the screen
+func (s *tmuxServer) render(path string, cols, rows int) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	name := fmt.Sprintf("diff%d", time.Now().UnixNano())
+	signal := name + "-done"
+	if _, err := s.run(ctx, "new-session", "-d", "-s", name, "-x", fmt.Sprint(cols), "-y", fmt.Sprint(rows), "sleep 3600"); err != nil {
+		return "", err
+	}
+	defer s.run(context.Background(), "kill-session", "-t", name)
+	if _, err := s.run(ctx, "set-option", "-t", name, "status", "off"); err != nil {
+		return "", err
+	}
+
+	// Raw mode passes the bytes through untranslated, as our parser sees them
+	script := fmt.Sprintf("stty raw -echo; cat %q; tmux -L %s wait-for -S %s; exec sleep 3600", path, s.socket, signal)
+	if _, err := s.run(ctx, "respawn-pane", "-k", "-t", name, script); err != nil {
+		return "", err
+	}
+	if _, err := s.run(ctx, "wait-for", signal); err != nil {
+		return "", err
+	}
+	return s.run(ctx, "capture-pane", "-p", "-t", name)
+}
+
+func (s *tmuxServer) close() {
+	s.run(context.Background(), "kill-server")
+}
+
+// normalizeScreen drops trailing spaces and blank lines, which tmux and
+// our renderer pad differently
+func normalizeScreen(screen string) string {
+	lines := strings.Split(screen, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
+
+// screenDiff lists the rows that differ, or "" when the screens match
+func screenDiff(want, got string) string {
+	a := strings.Split(want, "\n")
+	b := strings.Split(got, "\n")
+	var out strings.Builder
+	for i := 0; i < max(len(a), len(b)); i++ {
+		var wa, gb string
+		if i < len(a) {
+			wa = a[i]
+		}
+		if i < len(b) {
+			gb = b[i]
+		}
+		if wa != gb {
+			fmt.Fprintf(&out, "row %2d - %q\n       + %q\n", i+1, wa, gb)
+		}
+	}
+	return out.String()
+}
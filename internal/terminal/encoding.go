@@ -0,0 +1,47 @@
+package terminal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Encoding is how the parser decodes bytes outside ASCII
+type Encoding string
+
+const (
+	EncodingUTF8   Encoding = "utf-8"  // Decode UTF-8; malformed sequences draw U+FFFD
+	EncodingLatin1 Encoding = "latin1" // Each byte 0xA0-0xFF is one ISO 8859-1 character; 0x80-0x9F are dropped
+)
+
+// Encodings lists the accepted encodings
+var Encodings = []string{string(EncodingUTF8), string(EncodingLatin1)}
+
+// ParseEncoding validates an encoding
+func ParseEncoding(s string) (Encoding, error) {
+	for _, e := range Encodings {
+		if s == e {
+			return Encoding(s), nil
+		}
+	}
+	return "", fmt.Errorf("must be one of: %s", strings.Join(Encodings, ", "))
+}
+
+// EncodingForLocale returns the encoding an application running under
+// locale writes: UTF-8 for a locale whose codeset is UTF-8, such as
+// en_US.UTF-8 or C.utf8, and Latin-1 for any other, including C and POSIX.
+// Latin-1 keeps single-byte output readable; it is exact for ISO 8859-1 and
+// close for the other single-byte codesets.
+func EncodingForLocale(locale string) Encoding {
+	codeset := ""
+	if i := strings.IndexByte(locale, '.'); i >= 0 {
+		codeset = locale[i+1:]
+	}
+	if i := strings.IndexByte(codeset, '@'); i >= 0 {
+		codeset = codeset[:i]
+	}
+	codeset = strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(codeset))
+	if codeset == "utf8" {
+		return EncodingUTF8
+	}
+	return EncodingLatin1
+}
@@ -0,0 +1,58 @@
+package terminal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEncodings(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding Encoding
+		writes   []string
+		expected string
+	}{
+		{"utf-8", EncodingUTF8, []string{"caf\xc3\xa9 \xe2\x94\x80 \xf0\x9f\x98\x80"}, "café ─ 😀"},
+		{"utf-8 split across writes", EncodingUTF8, []string{"caf\xc3", "\xa9 \xe2\x94", "\x80"}, "café ─"},
+		{"latin-1 byte in utf-8", EncodingUTF8, []string{"caf\xe9!"}, "caf�!"},
+		{"stray continuation byte", EncodingUTF8, []string{"a\x80b"}, "a�b"},
+		{"overlong form", EncodingUTF8, []string{"a\xe0\x80\x80b"}, "a�b"},
+		{"sequence cut by an escape", EncodingUTF8, []string{"a\xc3\x1b[1mb"}, "a�b"},
+		{"latin-1", EncodingLatin1, []string{"caf\xe9 \xa9\xff"}, "café ©ÿ"},
+		{"latin-1 drops C1 controls", EncodingLatin1, []string{"a\x85\x9bb"}, "ab"},
+		{"utf-8 in latin-1", EncodingLatin1, []string{"caf\xc3\xa9"}, "cafÃ©"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sb := NewScreenBuffer(20, 3)
+			sb.SetEncoding(tt.encoding)
+			for _, w := range tt.writes {
+				sb.Write([]byte(w))
+			}
+
+			content, _ := sb.Render("plain")
+			line := strings.TrimRight(strings.Split(content, "\n")[0], " ")
+			if line != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, line)
+			}
+		})
+	}
+}
+
+func TestEncodingForLocale(t *testing.T) {
+	tests := map[string]Encoding{
+		"en_US.UTF-8":      EncodingUTF8,
+		"C.utf8":           EncodingUTF8,
+		"de_DE.UTF-8@euro": EncodingUTF8,
+		"C":                EncodingLatin1,
+		"POSIX":            EncodingLatin1,
+		"en_US.ISO-8859-1": EncodingLatin1,
+		"de_DE@euro":       EncodingLatin1,
+	}
+	for locale, want := range tests {
+		if got := EncodingForLocale(locale); got != want {
+			t.Errorf("EncodingForLocale(%q) = %s, want %s", locale, got, want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+//go:build !windows
+
+package terminal
+
+import (
+	"os"
+	"syscall"
+)
+
+// FDUsage returns the number of file descriptors the process has open and
+// its soft limit. When descriptors cannot be counted (for example because
+// none are left to list them with) the limit is still returned with the
+// error.
+func FDUsage() (open, limit int, err error) {
+	var rlim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlim); err != nil {
+		return 0, 0, err
+	}
+	limit = int(rlim.Cur)
+
+	// Linux lists descriptors under /proc, macOS and the BSDs under /dev/fd
+	for _, dir := range []string{"/proc/self/fd", "/dev/fd"} {
+		entries, derr := os.ReadDir(dir)
+		if derr != nil {
+			err = derr
+			continue
+		}
+		// Reading the directory used a descriptor of its own
+		return len(entries) - 1, limit, nil
+	}
+	return 0, limit, err
+}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package terminal
+
+import "errors"
+
+// FDUsage is not available on Windows, whose handle limits are not
+// per-process descriptor tables
+func FDUsage() (open, limit int, err error) {
+	return 0, 0, errors.New("file descriptor usage is not available on Windows")
+}
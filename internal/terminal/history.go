@@ -0,0 +1,285 @@
+package terminal
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxHistoryFrames bounds the earlier screen versions a buffer keeps.
+// maxHistoryBytes bounds their compressed size however large the screen
+// is; the oldest versions go first when either is reached.
+const (
+	MaxHistoryFrames = 1000
+	maxHistoryBytes  = 16 << 20
+)
+
+// HistoryFormats lists the formats an earlier screen version can be
+// rendered in. The others need scrollback or raw output, which aren't kept
+// per version.
+var HistoryFormats = []string{"plain", "raw", "debug", "ansi"}
+
+// ErrVersionNotRetained is returned for a screen version that has left the
+// history, or was never kept because history was off
+var ErrVersionNotRetained = errors.New("no longer retained")
+
+// ScreenVersion is the screen as it was at one version
+type ScreenVersion struct {
+	Version uint64
+	Time    time.Time // When the version was recorded
+	Content string
+	CursorX int
+	CursorY int
+	RawEnd  int64 // Raw output offset the version corresponds to
+	Current bool  // The version is the screen as it is now
+}
+
+// cellStyle is the part of a cell other than its rune
+type cellStyle struct {
+	fg, bg Color
+	attrs  Attributes
+}
+
+// historyFrame is one screen version, stored compactly: each cell is a
+// pair of uvarints, an index into styles and the rune, row by row,
+// compressed with flate
+type historyFrame struct {
+	version          uint64
+	time             time.Time
+	width, height    int
+	cursorX, cursorY int
+	rawEnd           int64
+	styles           []cellStyle
+	data             []byte
+}
+
+// screenHistory keeps the newest screen versions, oldest first
+type screenHistory struct {
+	max    int
+	frames []historyFrame
+	bytes  int
+	zw     *flate.Writer
+	cells  []byte // Encoding scratch space, reused between frames
+}
+
+// SetHistorySize sets how many earlier screen versions the buffer keeps for
+// RenderVersion, each recorded as the screen changes. 0, the default, keeps
+// none and drops those kept. Shrinking keeps the newest.
+func (sb *ScreenBuffer) SetHistorySize(frames int) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	frames = min(max(frames, 0), MaxHistoryFrames)
+	if frames == 0 {
+		sb.history = nil
+		return
+	}
+	if sb.history == nil {
+		sb.history = &screenHistory{}
+	}
+	sb.history.max = frames
+	sb.history.trim()
+	sb.recordHistory()
+}
+
+// dropHistory forgets the versions kept, as when the screen is cleared for
+// a new process. The caller must hold sb.mu.
+func (sb *ScreenBuffer) dropHistory() {
+	if sb.history != nil {
+		sb.history.frames = nil
+		sb.history.bytes = 0
+	}
+}
+
+// recordHistory keeps the screen as it is now, if history is on. The
+// caller must hold sb.mu.
+func (sb *ScreenBuffer) recordHistory() {
+	if sb.history == nil {
+		return
+	}
+	h := sb.history
+	if n := len(h.frames); n > 0 && h.frames[n-1].version == sb.generation {
+		// Already kept; only the cursor can have moved since
+		last := &h.frames[n-1]
+		last.cursorX, last.cursorY = sb.cursorX, sb.cursorY
+		last.rawEnd = sb.RawDataEnd()
+		return
+	}
+	frame, err := h.encode(sb)
+	if err != nil {
+		return
+	}
+	h.frames = append(h.frames, frame)
+	h.bytes += len(frame.data)
+	h.trim()
+}
+
+// trim drops the oldest frames past the frame and byte limits, always
+// keeping the newest
+func (h *screenHistory) trim() {
+	drop := 0
+	for len(h.frames)-drop > 1 && (len(h.frames)-drop > h.max || h.bytes > maxHistoryBytes) {
+		h.bytes -= len(h.frames[drop].data)
+		drop++
+	}
+	if drop > 0 {
+		h.frames = append(h.frames[:0:0], h.frames[drop:]...)
+	}
+}
+
+func (h *screenHistory) encode(sb *ScreenBuffer) (historyFrame, error) {
+	frame := historyFrame{
+		version: sb.generation,
+		time:    time.Now(),
+		width:   sb.width,
+		height:  sb.height,
+		cursorX: sb.cursorX,
+		cursorY: sb.cursorY,
+		rawEnd:  sb.RawDataEnd(),
+	}
+
+	index := make(map[cellStyle]uint64)
+	h.cells = h.cells[:0]
+	for y := 0; y < sb.height; y++ {
+		for _, cell := range sb.cells[y] {
+			style := cellStyle{cell.Foreground, cell.Background, cell.Attributes}
+			i, ok := index[style]
+			if !ok {
+				i = uint64(len(frame.styles))
+				index[style] = i
+				frame.styles = append(frame.styles, style)
+			}
+			h.cells = binary.AppendUvarint(h.cells, i)
+			h.cells = binary.AppendUvarint(h.cells, uint64(cell.Rune))
+		}
+	}
+
+	var compressed bytes.Buffer
+	if h.zw == nil {
+		zw, err := flate.NewWriter(&compressed, flate.BestSpeed)
+		if err != nil {
+			return frame, err
+		}
+		h.zw = zw
+	} else {
+		h.zw.Reset(&compressed)
+	}
+	if _, err := h.zw.Write(h.cells); err != nil {
+		return frame, err
+	}
+	if err := h.zw.Close(); err != nil {
+		return frame, err
+	}
+	frame.data = bytes.Clone(compressed.Bytes())
+	return frame, nil
+}
+
+// decode rebuilds the frame's cells
+func (f *historyFrame) decode() ([][]Cell, error) {
+	data, err := io.ReadAll(flate.NewReader(bytes.NewReader(f.data)))
+	if err != nil {
+		return nil, err
+	}
+	r := bytes.NewReader(data)
+	cells := make([][]Cell, f.height)
+	for y := range cells {
+		cells[y] = make([]Cell, f.width)
+		for x := range cells[y] {
+			i, err := binary.ReadUvarint(r)
+			if err != nil || i >= uint64(len(f.styles)) {
+				return nil, fmt.Errorf("corrupt screen history frame")
+			}
+			ch, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, fmt.Errorf("corrupt screen history frame")
+			}
+			style := f.styles[i]
+			cells[y][x] = Cell{Rune: rune(ch), Foreground: style.fg, Background: style.bg, Attributes: style.attrs}
+		}
+	}
+	return cells, nil
+}
+
+// HistoryRange returns the oldest and newest screen versions kept, and how
+// many there are
+func (sb *ScreenBuffer) HistoryRange() (oldest, newest uint64, frames int) {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	if sb.history == nil || len(sb.history.frames) == 0 {
+		return sb.generation, sb.generation, 0
+	}
+	frames = len(sb.history.frames)
+	return sb.history.frames[0].version, sb.history.frames[frames-1].version, frames
+}
+
+// RenderVersion renders the screen as it was at version, as reported by
+// Generation, in one of HistoryFormats. The current version is always
+// available; earlier ones only while the history keeps them, otherwise the
+// error wraps ErrVersionNotRetained.
+func (sb *ScreenBuffer) RenderVersion(format string, version uint64) (ScreenVersion, error) {
+	return sb.RenderVersionWithOptions(format, version, sb.RenderOptions())
+}
+
+// RenderVersionWithOptions is RenderVersion with render options of the
+// caller's in place of the buffer's own
+func (sb *ScreenBuffer) RenderVersionWithOptions(format string, version uint64, opts RenderOptions) (ScreenVersion, error) {
+	if !validHistoryFormat(format) {
+		return ScreenVersion{}, fmt.Errorf("format %s can't show an earlier version, use one of: %s", format, strings.Join(HistoryFormats, ", "))
+	}
+
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	switch {
+	case version > sb.generation:
+		return ScreenVersion{}, fmt.Errorf("version %d doesn't exist yet, the current version is %d", version, sb.generation)
+	case version == sb.generation:
+		content, err := sb.render(format, opts)
+		return ScreenVersion{Version: version, Time: time.Now(), Content: content, CursorX: sb.cursorX, CursorY: sb.cursorY, RawEnd: sb.RawDataEnd(), Current: true}, err
+	}
+
+	var frames []historyFrame
+	if sb.history != nil {
+		frames = sb.history.frames
+	}
+	i := sort.Search(len(frames), func(i int) bool { return frames[i].version >= version })
+	if len(frames) == 0 || version < frames[0].version {
+		return ScreenVersion{}, fmt.Errorf("version %d is %w; %s", version, ErrVersionNotRetained, sb.retainedLocked())
+	}
+	if i == len(frames) || frames[i].version != version {
+		// One write changed the screen several times; only the result
+		// was ever shown
+		return ScreenVersion{}, fmt.Errorf("the screen never showed version %d, one write passed over it; %s", version, sb.retainedLocked())
+	}
+
+	frame := frames[i]
+	cells, err := frame.decode()
+	if err != nil {
+		return ScreenVersion{}, err
+	}
+	old := &ScreenBuffer{cells: cells, width: frame.width, height: frame.height, cursorX: frame.cursorX, cursorY: frame.cursorY}
+	content, err := old.render(format, opts)
+	return ScreenVersion{Version: version, Time: frame.time, Content: content, CursorX: frame.cursorX, CursorY: frame.cursorY, RawEnd: frame.rawEnd}, err
+}
+
+// retainedLocked describes the versions available. The caller must hold
+// sb.mu.
+func (sb *ScreenBuffer) retainedLocked() string {
+	if sb.history == nil || len(sb.history.frames) == 0 || sb.history.frames[0].version == sb.generation {
+		return fmt.Sprintf("only the current version %d is available", sb.generation)
+	}
+	return fmt.Sprintf("versions from %d to %d are retained", sb.history.frames[0].version, sb.generation)
+}
+
+func validHistoryFormat(format string) bool {
+	for _, f := range HistoryFormats {
+		if format == f {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package terminal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestScreenBuffer_RenderVersion(t *testing.T) {
+	sb := NewScreenBuffer(20, 3)
+	defer sb.Close()
+
+	// Without history only the current version can be shown
+	sb.Write([]byte("before"))
+	before := sb.Generation()
+	sb.Write([]byte("!"))
+	if _, err := sb.RenderVersion("plain", before); !errors.Is(err, ErrVersionNotRetained) {
+		t.Errorf("Expected version %d not retained with history off, got %v", before, err)
+	}
+	if view, err := sb.RenderVersion("plain", sb.Generation()); err != nil || view.Content != "before!" || !view.Current {
+		t.Errorf("Expected the current screen, got %+v (%v)", view, err)
+	}
+
+	sb.SetHistorySize(2)
+	var versions []uint64
+	for _, frame := range []string{"\x1b[2J\x1b[Hone", "\x1b[2J\x1b[H\x1b[1mtwo\x1b[0m\r\n>", "\x1b[2J\x1b[Hthree"} {
+		sb.Write([]byte(frame))
+		versions = append(versions, sb.Generation())
+	}
+
+	// The middle frame renders as it was, cursor and attributes included
+	view, err := sb.RenderVersion("plain", versions[1])
+	if err != nil {
+		t.Fatalf("RenderVersion failed: %v", err)
+	}
+	if !strings.HasPrefix(view.Content, "two ") || !strings.HasSuffix(view.Content, "\n>") || view.Current || view.CursorX != 1 || view.CursorY != 1 {
+		t.Errorf("Expected the second frame, got %+v", view)
+	}
+	if view, _ := sb.RenderVersion("raw", versions[1]); !strings.Contains(view.Content, "\x1b[1mtwo") {
+		t.Errorf("Expected the raw frame to keep bold, got %q", view.Content)
+	}
+	if view, _ := sb.RenderVersion("ansi", versions[1]); !strings.HasPrefix(view.Content, "two·") || !strings.Contains(view.Content, ">▮") {
+		t.Errorf("Expected the ansi frame to mark the cursor, got %q", view.Content)
+	}
+
+	// Only two versions are kept, so the first has gone
+	if _, err := sb.RenderVersion("plain", versions[0]); !errors.Is(err, ErrVersionNotRetained) {
+		t.Errorf("Expected version %d not retained, got %v", versions[0], err)
+	}
+	if oldest, newest, frames := sb.HistoryRange(); oldest != versions[1] || newest != versions[2] || frames != 2 {
+		t.Errorf("Expected versions %d to %d kept, got %d to %d (%d)", versions[1], versions[2], oldest, newest, frames)
+	}
+
+	if _, err := sb.RenderVersion("plain", versions[2]+1); err == nil || errors.Is(err, ErrVersionNotRetained) {
+		t.Errorf("Expected a future version to be refused, got %v", err)
+	}
+	if _, err := sb.RenderVersion("scrollback", versions[1]); err == nil {
+		t.Error("Expected the scrollback format to be refused")
+	}
+
+	// Clearing the history for a new process forgets the old screens
+	sb.ClearHistory()
+	if _, err := sb.RenderVersion("plain", versions[2]); !errors.Is(err, ErrVersionNotRetained) {
+		t.Errorf("Expected cleared versions to be gone, got %v", err)
+	}
+}
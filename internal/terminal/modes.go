@@ -0,0 +1,214 @@
+package terminal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TerminalModes are the DEC private and ANSI modes an application has set.
+// Only the parser changes them, under the buffer lock. A mode being tracked
+// does not mean its effect on the screen is emulated; ignored sequences are
+// still counted by the parser diagnostics.
+type TerminalModes struct {
+	CursorKeys     bool   // ?1 DECCKM: application cursor keys
+	Columns132     bool   // ?3 DECCOLM: 132-column mode; resizes only where the buffer allows it
+	Origin         bool   // ?6 DECOM: cursor addressing relative to the scroll region
+	Autowrap       bool   // ?7 DECAWM, on by default
+	MouseX10       bool   // ?9: report button presses
+	CursorVisible  bool   // ?25 DECTCEM, on by default
+	MouseNormal    bool   // ?1000: report presses and releases
+	MouseButton    bool   // ?1002: also report drags
+	MouseAny       bool   // ?1003: report all motion
+	FocusEvents    bool   // ?1004: report focus in and out
+	MouseUTF8      bool   // ?1005: UTF-8 mouse coordinates
+	MouseSGR       bool   // ?1006: SGR mouse encoding
+	MouseURXVT     bool   // ?1015: urxvt mouse encoding
+	AltScreen      int    // ?47, ?1047 or ?1049 that switched to the alternate screen; 0 on the primary
+	BracketedPaste bool   // ?2004
+	Keypad         bool   // DECKPAM (ESC =): application keypad
+	Insert         bool   // 4 IRM: insert rather than replace characters
+	NewLine        bool   // 20 LNM: line feed also returns the carriage
+	Charset        string // Character set designated as G0
+}
+
+// Character sets reported in TerminalModes.Charset
+const (
+	CharsetASCII    = "ascii"
+	CharsetDECGraph = "dec_special_graphics" // Line drawing, ESC ( 0
+	CharsetUK       = "uk"
+	CharsetOther    = "other"
+)
+
+// LineFeed is how a buffer treats a line feed the application did not
+// pair with a carriage return
+type LineFeed string
+
+const (
+	LineFeedLF   LineFeed = "lf"   // Only move down, unless the application set LNM
+	LineFeedCRLF LineFeed = "crlf" // Also return the carriage, as the tty's ONLCR would have
+)
+
+// LineFeeds lists the accepted line feed treatments
+var LineFeeds = []string{string(LineFeedLF), string(LineFeedCRLF)}
+
+// ParseLineFeed validates a line feed treatment
+func ParseLineFeed(s string) (LineFeed, error) {
+	for _, lf := range LineFeeds {
+		if s == lf {
+			return LineFeed(s), nil
+		}
+	}
+	return "", fmt.Errorf("must be one of: %s", strings.Join(LineFeeds, ", "))
+}
+
+// defaultModes are the modes a terminal starts in
+func defaultModes() TerminalModes {
+	return TerminalModes{Autowrap: true, CursorVisible: true, Charset: CharsetASCII}
+}
+
+// privateModes lists the tracked DEC private modes in the order reported
+var privateModes = []int{1, 3, 6, 7, 9, 25, 47, 1000, 1002, 1003, 1004, 1005, 1006, 1015, 1047, 1049, 2004}
+
+// ansiModes lists the tracked ANSI modes in the order reported
+var ansiModes = []int{4, 20}
+
+// private returns the flag for a DEC private mode other than the alternate
+// screen modes, or nil if it isn't tracked
+func (m *TerminalModes) private(mode int) *bool {
+	switch mode {
+	case 1:
+		return &m.CursorKeys
+	case 3:
+		return &m.Columns132
+	case 6:
+		return &m.Origin
+	case 7:
+		return &m.Autowrap
+	case 9:
+		return &m.MouseX10
+	case 25:
+		return &m.CursorVisible
+	case 1000:
+		return &m.MouseNormal
+	case 1002:
+		return &m.MouseButton
+	case 1003:
+		return &m.MouseAny
+	case 1004:
+		return &m.FocusEvents
+	case 1005:
+		return &m.MouseUTF8
+	case 1006:
+		return &m.MouseSGR
+	case 1015:
+		return &m.MouseURXVT
+	case 2004:
+		return &m.BracketedPaste
+	}
+	return nil
+}
+
+// setPrivate sets or resets a DEC private mode and reports whether the
+// parser fully emulates it. Only cursor keys is: it changes nothing on
+// screen, only how send_keys encodes keys.
+func (m *TerminalModes) setPrivate(mode int, on bool) bool {
+	switch mode {
+	case 47, 1047, 1049:
+		if on {
+			m.AltScreen = mode
+		} else {
+			m.AltScreen = 0
+		}
+		return false
+	}
+	if flag := m.private(mode); flag != nil {
+		*flag = on
+	}
+	return mode == 1
+}
+
+// getPrivate reports whether a DEC private mode is set, and false for a
+// mode that isn't tracked
+func (m *TerminalModes) getPrivate(mode int) (on, tracked bool) {
+	switch mode {
+	case 47, 1047, 1049:
+		return m.AltScreen == mode, true
+	}
+	if flag := m.private(mode); flag != nil {
+		return *flag, true
+	}
+	return false, false
+}
+
+// softReset returns the modes DECSTR resets to their defaults. Mouse
+// reporting, bracketed paste and the active screen are left alone, as
+// xterm does.
+func (m *TerminalModes) softReset() {
+	defaults := defaultModes()
+	m.CursorKeys = defaults.CursorKeys
+	m.Origin = defaults.Origin
+	m.Autowrap = defaults.Autowrap
+	m.CursorVisible = defaults.CursorVisible
+	m.Keypad = defaults.Keypad
+	m.Insert = defaults.Insert
+	m.Charset = defaults.Charset
+}
+
+// setANSI sets or resets an ANSI mode and reports whether the parser
+// emulates it. Only newline mode is.
+func (m *TerminalModes) setANSI(mode int, on bool) bool {
+	switch mode {
+	case 4:
+		m.Insert = on
+	case 20:
+		m.NewLine = on
+		return true
+	}
+	return false
+}
+
+// designateG0 records the character set selected by ESC ( <final>
+func (m *TerminalModes) designateG0(final byte) {
+	switch final {
+	case 'B':
+		m.Charset = CharsetASCII
+	case '0':
+		m.Charset = CharsetDECGraph
+	case 'A':
+		m.Charset = CharsetUK
+	default:
+		m.Charset = CharsetOther
+	}
+}
+
+// Screen names the active screen, "primary" or "alternate"
+func (m TerminalModes) Screen() string {
+	if m.AltScreen != 0 {
+		return "alternate"
+	}
+	return "primary"
+}
+
+// Map returns every tracked mode by its number as sent in CSI h/l, DEC
+// private modes prefixed with "?" (e.g. "?1006") and ANSI modes bare
+// (e.g. "4")
+func (m TerminalModes) Map() map[string]bool {
+	modes := make(map[string]bool, len(privateModes)+len(ansiModes))
+	for _, mode := range privateModes {
+		key := fmt.Sprintf("?%d", mode)
+		switch mode {
+		case 47, 1047, 1049:
+			modes[key] = m.AltScreen == mode
+		default:
+			modes[key] = *m.private(mode)
+		}
+	}
+	modes["4"] = m.Insert
+	modes["20"] = m.NewLine
+	return modes
+}
+
+// Input returns the modes that change what keys send
+func (m TerminalModes) Input() InputModes {
+	return InputModes{ApplicationCursorKeys: m.CursorKeys, ApplicationKeypad: m.Keypad}
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package terminal
+
+import (
+	"flag"
+	"path/filepath"
+	"testing"
+)
+
+var recordModes = flag.Bool("modes.record", false, "Re-record the terminal mode fixtures from their commands")
+
+// Run with: go test -run TestRecordModeFixtures ./internal/terminal -modes.record
+func TestRecordModeFixtures(t *testing.T) {
+	if !*recordModes {
+		t.Skip("Pass -modes.record to re-record the mode fixtures")
+	}
+	for _, f := range modeFixtures {
+		if err := recordFixture(filepath.Join(modesDir, f.name+".bin"), 80, 24, f.record, f.settle); err != nil {
+			t.Fatalf("Failed to record %s: %v", f.name, err)
+		}
+		t.Logf("Recorded %s", f.name)
+	}
+}
@@ -0,0 +1,234 @@
+package terminal
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// modesDir holds startup output recorded from real programs, one
+// <name>.bin each
+var modesDir = filepath.Join("..", "..", "test", "fixtures", "modes")
+
+// modeFixtures are replayed into a fresh buffer, which must end up in the
+// listed modes; every mode not listed must be at its default
+var modeFixtures = []struct {
+	name    string
+	record  []string      // Command recorded with -modes.record
+	settle  time.Duration // How long to record before stopping it
+	enabled map[string]bool
+	screen  string
+	charset string
+	keypad  bool
+}{
+	{
+		name:   "ncurses_startup",
+		record: []string{"python3", filepath.Join(modesDir, "ncurses_startup.py")},
+		settle: time.Second,
+		// Alternate screen, hidden cursor, keypad transmit and SGR mouse
+		// reporting of clicks
+		enabled: map[string]bool{"?1": true, "?7": true, "?25": false, "?1000": true, "?1006": true, "?1049": true},
+		screen:  "alternate",
+		charset: CharsetASCII,
+		keypad:  true,
+	},
+}
+
+func TestModeFixtures(t *testing.T) {
+	for _, f := range modeFixtures {
+		t.Run(f.name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join(modesDir, f.name+".bin"))
+			if err != nil {
+				t.Fatalf("Failed to read fixture: %v", err)
+			}
+			buffer := NewScreenBuffer(80, 24)
+			buffer.Write(data)
+			modes := buffer.Modes()
+
+			want := defaultModes().Map()
+			for mode, on := range f.enabled {
+				want[mode] = on
+			}
+			if got := modes.Map(); !reflect.DeepEqual(got, want) {
+				t.Errorf("Unexpected modes\n got: %v\nwant: %v", got, want)
+			}
+			if modes.Screen() != f.screen || modes.Charset != f.charset || modes.Keypad != f.keypad {
+				t.Errorf("Expected screen %s, charset %s, keypad %v; got %s, %s, %v",
+					f.screen, f.charset, f.keypad, modes.Screen(), modes.Charset, modes.Keypad)
+			}
+		})
+	}
+}
+
+func TestTerminalModes(t *testing.T) {
+	buffer := NewScreenBuffer(20, 5)
+	if modes := buffer.Modes(); modes != defaultModes() {
+		t.Fatalf("Expected default modes, got %+v", modes)
+	}
+
+	buffer.Write([]byte("\x1b[?1049h\x1b[?25l\x1b[?2004h\x1b[?1000;1006h\x1b[4h\x1b(0"))
+	modes := buffer.Modes()
+	if modes.Screen() != "alternate" || modes.CursorVisible || !modes.BracketedPaste ||
+		!modes.MouseNormal || !modes.MouseSGR || !modes.Insert || modes.Charset != CharsetDECGraph {
+		t.Errorf("Modes not tracked: %+v", modes)
+	}
+
+	// Leaving through a different alternate screen mode still returns to
+	// the primary screen
+	buffer.Write([]byte("\x1b[?47l\x1b[?1000;1006l\x1b[4l\x1b(B"))
+	modes = buffer.Modes()
+	if modes.Screen() != "primary" || modes.MouseNormal || modes.MouseSGR || modes.Insert || modes.Charset != CharsetASCII {
+		t.Errorf("Modes not reset: %+v", modes)
+	}
+	if modes.CursorVisible || !modes.BracketedPaste {
+		t.Errorf("Expected untouched modes to keep their state: %+v", modes)
+	}
+
+	// Unknown modes are ignored; RIS and Reset restore the defaults
+	buffer.Write([]byte("\x1b[?12345h\x1bc"))
+	if modes := buffer.Modes(); modes != defaultModes() {
+		t.Errorf("Expected RIS to restore the defaults, got %+v", modes)
+	}
+	buffer.Write([]byte("\x1b[?1049h"))
+	buffer.Reset()
+	if modes := buffer.Modes(); modes != defaultModes() {
+		t.Errorf("Expected Reset to restore the defaults, got %+v", modes)
+	}
+}
+
+func TestColumnMode(t *testing.T) {
+	buffer := NewScreenBuffer(80, 5)
+	buffer.Write([]byte("hello\x1b[?3h"))
+
+	// By default DECCOLM is only tracked
+	if w, _ := buffer.GetSize(); w != 80 || !buffer.Modes().Columns132 || buffer.ColumnSwitches() != 0 {
+		t.Fatalf("Expected the mode tracked without a resize, got width %d %+v", w, buffer.Modes())
+	}
+	if buffer.ParserDiagnostics().Total != 1 {
+		t.Errorf("Expected the ignored resize counted, got %+v", buffer.ParserDiagnostics())
+	}
+
+	// Allowed, it resizes, clears and homes the cursor, and output that
+	// follows in the same write lands on the wide screen
+	buffer.SetColumnMode(true)
+	buffer.Write([]byte("\x1b[3;5H\x1b[?3h\x1b[132Gx"))
+	if w, h := buffer.GetSize(); w != 132 || h != 5 || buffer.ColumnSwitches() != 1 {
+		t.Fatalf("Expected 132x5 after one switch, got %dx%d", w, h)
+	}
+	if got := string(buffer.cells[0][131].Rune); got != "x" {
+		t.Errorf("Expected output in column 132, got %q", got)
+	}
+	if content, _ := buffer.Render("plain"); strings.Contains(content, "hello") {
+		t.Errorf("Expected the screen cleared, got %q", content)
+	}
+
+	// Setting the mode it is already in clears without resizing again
+	buffer.Write([]byte("\x1b[?3h"))
+	if buffer.ColumnSwitches() != 1 {
+		t.Errorf("Expected no second switch, got %d", buffer.ColumnSwitches())
+	}
+	buffer.Write([]byte("\x1b[?3l"))
+	if w, _ := buffer.GetSize(); w != 80 || buffer.Modes().Columns132 || buffer.ColumnSwitches() != 2 {
+		t.Errorf("Expected 80 columns back, got %d", w)
+	}
+}
+
+func TestLineFeedModes(t *testing.T) {
+	// Bare line feeds, as written with the tty's ONLCR translation off
+	const input = "one\ntwo\nthree"
+	staircased := []string{"one", "   two", "      three"}
+	aligned := []string{"one", "two", "three"}
+
+	tests := []struct {
+		name     string
+		setup    func(sb *ScreenBuffer)
+		prefix   string
+		expected []string
+	}{
+		{"line feed only", func(*ScreenBuffer) {}, "", staircased},
+		{"newline mode", func(*ScreenBuffer) {}, "\x1b[20h", aligned},
+		{"newline mode reset", func(*ScreenBuffer) {}, "\x1b[20h\x1b[20l", staircased},
+		{"crlf translation", func(sb *ScreenBuffer) { sb.SetLineFeed(LineFeedCRLF) }, "", aligned},
+		{"crlf translation ignores LNM reset", func(sb *ScreenBuffer) { sb.SetLineFeed(LineFeedCRLF) }, "\x1b[20l", aligned},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sb := NewScreenBuffer(20, 5)
+			tt.setup(sb)
+			sb.Write([]byte(tt.prefix + input))
+
+			content, _ := sb.Render("plain")
+			lines := strings.Split(content, "\n")
+			for i, want := range tt.expected {
+				if got := strings.TrimRight(lines[i], " "); got != want {
+					t.Errorf("Line %d: expected %q, got %q", i, want, got)
+				}
+			}
+		})
+	}
+
+	// Newline mode is emulated, so setting it is not a parser diagnostic,
+	// and vertical tab and form feed follow it like a line feed
+	sb := NewScreenBuffer(20, 5)
+	sb.Write([]byte("\x1b[20ha\vb\fc"))
+	if diag := sb.ParserDiagnostics(); diag.Total != 0 {
+		t.Errorf("Expected CSI 20 h to be handled, got %+v", diag.Counts)
+	}
+	content, _ := sb.Render("plain")
+	if lines := strings.Split(content, "\n"); strings.TrimRight(lines[2], " ") != "c" {
+		t.Errorf("Expected VT and FF to start new lines, got %q", content)
+	}
+}
+
+func TestSaveRestoreModes(t *testing.T) {
+	buffer := NewScreenBuffer(20, 5)
+
+	// Save a few modes, change them and more, then restore the saved ones
+	buffer.Write([]byte("\x1b[?1h\x1b[?1000;1006h\x1b[?1;1000;1006;1049s"))
+	buffer.Write([]byte("\x1b[?1l\x1b[?1000;1006l\x1b[?1049h\x1b[?25l"))
+	if modes := buffer.Modes(); modes.CursorKeys || modes.MouseNormal || modes.Screen() != "alternate" {
+		t.Fatalf("Modes not changed: %+v", modes)
+	}
+	buffer.Write([]byte("\x1b[?1;1000;1006;1049r"))
+	modes := buffer.Modes()
+	if !modes.CursorKeys || !modes.MouseNormal || !modes.MouseSGR || modes.Screen() != "primary" {
+		t.Errorf("Expected saved modes restored: %+v", modes)
+	}
+	if modes.CursorVisible {
+		t.Error("Expected a mode that wasn't saved to keep its state")
+	}
+
+	// Restoring again gives the same state; a mode never saved is left alone
+	buffer.Write([]byte("\x1b[?1l\x1b[?1;25r"))
+	if modes := buffer.Modes(); !modes.CursorKeys || modes.CursorVisible {
+		t.Errorf("Expected only the saved mode restored: %+v", modes)
+	}
+	if diag := buffer.ParserDiagnostics(); diag.Counts["CSI ?s"] != 0 || diag.Counts["CSI ?r"] != 1 {
+		// Restoring the alternate screen is tracked but not emulated
+		t.Errorf("Unexpected diagnostics: %v", diag.Counts)
+	}
+
+	// RIS and DECSTR forget the saved modes; DECSTR keeps mouse reporting
+	for _, reset := range []string{"\x1bc", "\x1b[!p"} {
+		buffer.Write([]byte("\x1b[?1h\x1b[?1s" + reset + "\x1b[?1000h\x1b[?1r"))
+		if modes := buffer.Modes(); modes.CursorKeys || !modes.MouseNormal {
+			t.Errorf("%q: expected saved modes forgotten: %+v", reset, modes)
+		}
+	}
+	buffer.Write([]byte("\x1b[4h\x1b[?1h\x1b[?25l\x1b[!p"))
+	if modes := buffer.Modes(); modes.Insert || modes.CursorKeys || !modes.CursorVisible || !modes.MouseNormal {
+		t.Errorf("Unexpected modes after DECSTR: %+v", modes)
+	}
+
+	// Reset, as on restart, forgets them too
+	buffer.Write([]byte("\x1b[?1h\x1b[?1s"))
+	buffer.ResetModes()
+	buffer.Write([]byte("\x1b[?1r"))
+	if buffer.Modes().CursorKeys {
+		t.Error("Expected ResetModes to forget saved modes")
+	}
+}
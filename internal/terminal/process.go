@@ -0,0 +1,41 @@
+package terminal
+
+import "errors"
+
+// ProcessInfo describes the process running inside a PTY and its descendants
+type ProcessInfo struct {
+	PID         int            `json:"pid"`
+	PGID        int            `json:"pgid"`
+	State       string         `json:"state"`
+	CPUTime     float64        `json:"cpu_time_seconds"`
+	RSSBytes    int64          `json:"rss_bytes"`
+	Command     string         `json:"command"`
+	Descendants []ProcessEntry `json:"descendants"`
+	Partial     bool           `json:"partial"` // Set when the platform could not provide every field
+}
+
+// ProcessEntry is a single process in the child's process group
+type ProcessEntry struct {
+	PID     int    `json:"pid"`
+	PPID    int    `json:"ppid"`
+	State   string `json:"state"`
+	Command string `json:"command"`
+}
+
+// ResourceUsage is a process's CPU time and memory at one moment
+type ResourceUsage struct {
+	CPUTime  float64 // Seconds of user and system time since it started
+	RSSBytes int64
+}
+
+// ErrResourceUsageUnsupported reports a platform without /proc, where
+// reading a process's usage would mean running ps. ResourceUsageSupported
+// tells in advance.
+var ErrResourceUsageUnsupported = errors.New("resource usage sampling needs /proc, which this platform doesn't have")
+
+// ReadResourceUsage reads the CPU time and resident memory of process pid.
+// It reads one small file, so it is cheap enough to call every few hundred
+// milliseconds.
+func ReadResourceUsage(pid int) (ResourceUsage, error) {
+	return readResourceUsage(pid)
+}
@@ -0,0 +1,84 @@
+//go:build darwin
+
+package terminal
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// readProcessInfo uses ps since macOS has no /proc. This is best-effort:
+// any field that fails to parse marks the result as partial.
+func readProcessInfo(pid, pgid int) (*ProcessInfo, error) {
+	out, err := exec.Command("ps", "-A", "-o", "pid=,ppid=,pgid=,state=,time=,rss=,command=").Output()
+	if err != nil {
+		return &ProcessInfo{PID: pid, PGID: pgid, Descendants: []ProcessEntry{}, Partial: true}, nil
+	}
+
+	info := &ProcessInfo{
+		PID:         pid,
+		PGID:        pgid,
+		Descendants: []ProcessEntry{},
+		Partial:     true,
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 7 {
+			continue
+		}
+		linePID, err1 := strconv.Atoi(fields[0])
+		ppid, err2 := strconv.Atoi(fields[1])
+		linePGID, err3 := strconv.Atoi(fields[2])
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+		command := strings.Join(fields[6:], " ")
+
+		if linePID == pid {
+			info.State = fields[3]
+			info.Command = command
+			cpu, cpuErr := parsePSTime(fields[4])
+			rss, rssErr := strconv.ParseInt(fields[5], 10, 64)
+			info.CPUTime = cpu
+			info.RSSBytes = rss * 1024 // ps reports kilobytes
+			info.Partial = cpuErr != nil || rssErr != nil
+			continue
+		}
+
+		if linePGID == pgid {
+			info.Descendants = append(info.Descendants, ProcessEntry{
+				PID:     linePID,
+				PPID:    ppid,
+				State:   fields[3],
+				Command: command,
+			})
+		}
+	}
+
+	return info, nil
+}
+
+// parsePSTime parses ps cputime values such as "1:02.50" or "1:02:03.00"
+func parsePSTime(s string) (float64, error) {
+	var total float64
+	parts := strings.Split(s, ":")
+	for _, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid cpu time %q: %w", s, err)
+		}
+		total = total*60 + v
+	}
+	return total, nil
+}
+
+// ResourceUsageSupported is whether ReadResourceUsage works here
+const ResourceUsageSupported = false
+
+// readResourceUsage is not supported without /proc
+func readResourceUsage(pid int) (ResourceUsage, error) {
+	return ResourceUsage{}, ErrResourceUsageUnsupported
+}
@@ -0,0 +1,124 @@
+//go:build linux
+
+package terminal
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// clockTicks is USER_HZ, which is 100 on every mainstream Linux architecture
+const clockTicks = 100
+
+// procStat holds the fields of /proc/<pid>/stat that we care about
+type procStat struct {
+	pid     int
+	comm    string
+	state   string
+	ppid    int
+	pgrp    int
+	utime   uint64
+	stime   uint64
+	rssPage int64
+}
+
+func readProcStat(pid int) (*procStat, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	// The command name is wrapped in parentheses and may itself contain
+	// spaces or parentheses, so split around the last closing paren
+	s := string(data)
+	open := strings.IndexByte(s, '(')
+	closing := strings.LastIndexByte(s, ')')
+	if open < 0 || closing < open {
+		return nil, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+
+	fields := strings.Fields(s[closing+1:])
+	// fields[0] is state (field 3 in proc(5)), rss is field 24
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("short stat for pid %d", pid)
+	}
+
+	st := &procStat{
+		pid:   pid,
+		comm:  s[open+1 : closing],
+		state: fields[0],
+	}
+	st.ppid, _ = strconv.Atoi(fields[1])
+	st.pgrp, _ = strconv.Atoi(fields[2])
+	st.utime, _ = strconv.ParseUint(fields[11], 10, 64)
+	st.stime, _ = strconv.ParseUint(fields[12], 10, 64)
+	st.rssPage, _ = strconv.ParseInt(fields[21], 10, 64)
+	return st, nil
+}
+
+func readProcCmdline(pid int, fallback string) string {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil || len(data) == 0 {
+		return fallback
+	}
+	return strings.TrimSpace(strings.ReplaceAll(string(data), "\x00", " "))
+}
+
+// ResourceUsageSupported is whether ReadResourceUsage works here
+const ResourceUsageSupported = true
+
+func readResourceUsage(pid int) (ResourceUsage, error) {
+	st, err := readProcStat(pid)
+	if err != nil {
+		return ResourceUsage{}, fmt.Errorf("failed to read process %d: %w", pid, err)
+	}
+	return ResourceUsage{
+		CPUTime:  float64(st.utime+st.stime) / clockTicks,
+		RSSBytes: st.rssPage * int64(os.Getpagesize()),
+	}, nil
+}
+
+func readProcessInfo(pid, pgid int) (*ProcessInfo, error) {
+	st, err := readProcStat(pid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read process %d: %w", pid, err)
+	}
+
+	info := &ProcessInfo{
+		PID:         pid,
+		PGID:        pgid,
+		State:       st.state,
+		CPUTime:     float64(st.utime+st.stime) / clockTicks,
+		RSSBytes:    st.rssPage * int64(os.Getpagesize()),
+		Command:     readProcCmdline(pid, st.comm),
+		Descendants: []ProcessEntry{},
+	}
+
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		info.Partial = true
+		return info, nil
+	}
+
+	for _, entry := range entries {
+		otherPID, err := strconv.Atoi(entry.Name())
+		if err != nil || otherPID == pid {
+			continue
+		}
+		other, err := readProcStat(otherPID)
+		if err != nil || other.pgrp != pgid {
+			// Processes can exit while we scan
+			continue
+		}
+		info.Descendants = append(info.Descendants, ProcessEntry{
+			PID:     other.pid,
+			PPID:    other.ppid,
+			State:   other.state,
+			Command: readProcCmdline(other.pid, other.comm),
+		})
+	}
+
+	return info, nil
+}
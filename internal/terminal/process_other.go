@@ -0,0 +1,21 @@
+//go:build !linux && !darwin
+
+package terminal
+
+// readProcessInfo returns only what is known without OS support
+func readProcessInfo(pid, pgid int) (*ProcessInfo, error) {
+	return &ProcessInfo{
+		PID:         pid,
+		PGID:        pgid,
+		Descendants: []ProcessEntry{},
+		Partial:     true,
+	}, nil
+}
+
+// ResourceUsageSupported is whether ReadResourceUsage works here
+const ResourceUsageSupported = false
+
+// readResourceUsage is not supported without /proc
+func readResourceUsage(pid int) (ResourceUsage, error) {
+	return ResourceUsage{}, ErrResourceUsageUnsupported
+}
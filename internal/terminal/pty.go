@@ -2,16 +2,18 @@ package terminal
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"os/exec"
-	"os/signal"
+	"runtime"
+	"strings"
 	"sync"
-	"syscall"
+	"time"
 
-	"github.com/creack/pty"
 	"github.com/bioharz/mcp-terminal-tester/internal/utils"
 )
 
@@ -22,22 +24,158 @@ var bufferPool = sync.Pool{
 	},
 }
 
+// Writes to the process are split into chunks of writeChunkSize bytes. A
+// write gives up once DefaultWriteTimeout has passed without the process
+// taking the next chunk, so a large paste into an application that keeps
+// reading is not cut off however long it takes overall.
+const (
+	writeChunkSize      = 4096
+	DefaultWriteTimeout = 5 * time.Second
+)
+
+// ErrInputBlocked reports that the process stopped consuming its input, so
+// a write could not finish before its deadline. Use errors.Is to test a
+// *WriteError against it.
+var ErrInputBlocked = errors.New("child not consuming input")
+
+// WriteError reports a write that did not deliver all of its data. The
+// first Written bytes reached the terminal; the rest did not.
+type WriteError struct {
+	Written int
+	Total   int
+	Err     error // ErrInputBlocked or the underlying write error
+}
+
+func (e *WriteError) Error() string {
+	return fmt.Sprintf("wrote %d of %d bytes: %v", e.Written, e.Total, e.Err)
+}
+
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// Winsize is a terminal size in character cells
+type Winsize struct {
+	Rows uint16
+	Cols uint16
+}
+
+// Signal is a platform-neutral request to the process in a terminal
+type Signal int
+
+const (
+	SignalInterrupt Signal = iota // Ctrl+C: SIGINT on Unix, CTRL_C_EVENT on Windows
+	SignalTerminate               // Ask to exit: SIGTERM on Unix, CTRL_C_EVENT on Windows
+	SignalKill                    // Force exit: SIGKILL on Unix, TerminateProcess on Windows
+	SignalQuit                    // Ctrl+\: SIGQUIT on Unix; not on Windows
+	SignalSuspend                 // Ctrl+Z: SIGTSTP on Unix; not on Windows
+	SignalContinue                // Resume after a suspend: SIGCONT on Unix; not on Windows
+)
+
+// signalNames are the names send_signal accepts
+var signalNames = map[Signal]string{
+	SignalInterrupt: "interrupt",
+	SignalTerminate: "terminate",
+	SignalKill:      "kill",
+	SignalQuit:      "quit",
+	SignalSuspend:   "suspend",
+	SignalContinue:  "continue",
+}
+
+func (s Signal) String() string {
+	if name, ok := signalNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("signal %d", int(s))
+}
+
+// SignalNames returns the names of all signals, in Signal order
+func SignalNames() []string {
+	names := make([]string, len(signalNames))
+	for sig, name := range signalNames {
+		names[sig] = name
+	}
+	return names
+}
+
+// signalAliases are the Unix names ParseSignal also accepts
+var signalAliases = map[string]Signal{
+	"SIGINT":  SignalInterrupt,
+	"SIGTERM": SignalTerminate,
+	"SIGKILL": SignalKill,
+	"SIGQUIT": SignalQuit,
+	"SIGTSTP": SignalSuspend,
+	"SIGCONT": SignalContinue,
+}
+
+// ParseSignal returns the signal with the given name, or its Unix name such
+// as SIGTERM
+func ParseSignal(name string) (Signal, error) {
+	for sig, n := range signalNames {
+		if n == name {
+			return sig, nil
+		}
+	}
+	if sig, ok := signalAliases[name]; ok {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("unknown signal %q, valid signals: %s", name, strings.Join(SignalNames(), ", "))
+}
+
+// pseudoTerminal is the platform's pseudo-terminal: creack/pty on Unix (see
+// pty_unix.go) and ConPTY on Windows (see pty_windows.go). Both speak VT
+// sequences, so key mapping and the screen buffer are shared.
+type pseudoTerminal interface {
+	// Start runs cmd attached to a new terminal of the given size and sets
+	// cmd.Process
+	Start(cmd *exec.Cmd, size Winsize) error
+	Read(p []byte) (int, error)
+	Write(p []byte) (int, error)
+	Resize(size Winsize) error
+	// SetWriteDeadline bounds pending and later writes; os.ErrNoDeadline
+	// means writes cannot time out
+	SetWriteDeadline(t time.Time) error
+	Signal(process *os.Process, sig Signal) error
+	// SignalForeground delivers sig to the terminal's foreground process
+	// group, as the line discipline does for Ctrl+C, and returns the group
+	SignalForeground(process *os.Process, sig Signal) (int, error)
+	// Stop closes the terminal; pending and later reads fail
+	Stop() error
+}
+
 type PTYWrapper struct {
 	cmd         *exec.Cmd
-	pty         *os.File
+	term        pseudoTerminal // nil until started
+	newTerm     func() pseudoTerminal
 	process     *os.Process
 	reader      *bufio.Reader
-	writer      *bufio.Writer
-	size        *pty.Winsize
+	writeTimeout time.Duration
+	size        *Winsize
 	mu          sync.Mutex
 	stopChan    chan struct{}
-	resizeChan  chan *pty.Winsize
 	sessionID   string // For logging
+
+	// With SeparateStderr, the process's stderr goes to this pipe instead
+	// of the terminal; stderrWrite is the child's end, closed once started
+	stderrRead  *os.File
+	stderrWrite *os.File
+
+	// exited is closed once the process has been reaped; exitState is only
+	// read after that
+	exited    chan struct{}
+	exitState *os.ProcessState
 }
 
 func NewPTYWrapper(command string, args []string, env map[string]string) (*PTYWrapper, error) {
+	// Fail early, with a specific reason, if the command can't be run
+	path, err := ResolveCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create command
-	cmd := exec.Command(command, args...)
+	cmd := exec.Command(path, args...)
+	cmd.Args[0] = command
 	
 	// Set environment variables
 	cmd.Env = os.Environ()
@@ -46,36 +184,97 @@ func NewPTYWrapper(command string, args []string, env map[string]string) (*PTYWr
 	}
 
 	// Default terminal size
-	size := &pty.Winsize{
+	size := &Winsize{
 		Rows: 24,
 		Cols: 80,
 	}
 
 	return &PTYWrapper{
-		cmd:        cmd,
-		size:       size,
-		stopChan:   make(chan struct{}),
-		resizeChan: make(chan *pty.Winsize, 1),
+		cmd:          cmd,
+		size:         size,
+		newTerm:      newPseudoTerminal,
+		writeTimeout: DefaultWriteTimeout,
+		stopChan:     make(chan struct{}),
 	}, nil
 }
 
+// SetSize sets the initial terminal size. It must be called before Start;
+// use Resize for running processes.
+func (p *PTYWrapper) SetSize(rows, cols uint16) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.size = &Winsize{
+		Rows: rows,
+		Cols: cols,
+	}
+}
+
+// SeparateStderr sends the process's stderr to a pipe, read with Stderr,
+// instead of the terminal. It must be called before Start. The process
+// then finds that stderr isn't a terminal.
+func (p *PTYWrapper) SeparateStderr() error {
+	if !stderrSeparable {
+		return fmt.Errorf("separate stderr is not supported on %s", runtime.GOOS)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stderrRead != nil {
+		return nil
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stderr pipe: %w", err)
+	}
+	p.stderrRead, p.stderrWrite = r, w
+	p.cmd.Stderr = w
+	return nil
+}
+
+// Stderr returns the process's stderr with SeparateStderr, nil otherwise.
+// Reads end with io.EOF once the process, and any children it passed
+// stderr on to, have exited, or with an error once the PTY is stopped.
+func (p *PTYWrapper) Stderr() io.Reader {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stderrRead == nil {
+		return nil
+	}
+	return p.stderrRead
+}
+
 func (p *PTYWrapper) Start() error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
 	// Start command with PTY
-	ptmx, err := pty.StartWithSize(p.cmd, p.size)
+	term := p.newTerm()
+	err := term.Start(p.cmd, *p.size)
+	// Only the child needs the write end of the stderr pipe, so reads see
+	// EOF when it exits
+	if p.stderrWrite != nil {
+		p.stderrWrite.Close()
+		p.stderrWrite = nil
+		if err != nil {
+			p.stderrRead.Close()
+		}
+	}
 	if err != nil {
-		return fmt.Errorf("failed to start PTY: %w", err)
+		return err
 	}
 
-	p.pty = ptmx
+	p.term = term
 	p.process = p.cmd.Process
-	p.reader = bufio.NewReader(ptmx)
-	p.writer = bufio.NewWriter(ptmx)
+	p.reader = bufio.NewReader(term)
 
-	// Start resize handler
-	go p.handleResize()
+	// Reap the process as soon as it exits so its status is available and
+	// it doesn't linger as a zombie
+	p.exited = make(chan struct{})
+	go func(process *os.Process, exited chan struct{}) {
+		state, _ := process.Wait()
+		p.exitState = state
+		close(exited)
+	}(p.process, p.exited)
 
 	slog.Debug("PTY started",
 		slog.String("session_id", p.sessionID),
@@ -112,49 +311,150 @@ func (p *PTYWrapper) Read() ([]byte, error) {
 	return result, nil
 }
 
-func (p *PTYWrapper) Write(data []byte) error {
+// SetWriteTimeout sets how long a Write may wait for the process to take
+// its data
+func (p *PTYWrapper) SetWriteTimeout(timeout time.Duration) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	p.writeTimeout = timeout
+}
 
-	if p.writer == nil {
-		return fmt.Errorf("PTY not started")
+// Write sends data to the process in bounded chunks and returns how many
+// bytes were delivered. If the process stops reading, the write gives up at
+// the write timeout with a *WriteError wrapping ErrInputBlocked; if ctx ends
+// first, it gives up then with the context's cause. Where the platform has
+// no write deadlines (Windows pipes), writes block as before.
+func (p *PTYWrapper) Write(ctx context.Context, data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.term == nil {
+		return 0, fmt.Errorf("PTY not started")
+	}
+	if ctx.Err() != nil {
+		return 0, &WriteError{Total: len(data), Err: context.Cause(ctx)}
 	}
 
-	_, err := p.writer.Write(data)
-	if err != nil {
-		return fmt.Errorf("failed to write to PTY: %w", err)
+	deadline := p.term.SetWriteDeadline(time.Now().Add(p.writeTimeout)) == nil
+	if deadline {
+		defer p.term.SetWriteDeadline(time.Time{})
+		// Pull the deadline in to abort the write when ctx ends
+		stop := context.AfterFunc(ctx, func() {
+			p.term.SetWriteDeadline(time.Unix(1, 0))
+		})
+		defer stop()
 	}
 
-	return p.writer.Flush()
+	written := 0
+	for written < len(data) {
+		if deadline && written > 0 {
+			// The process took the last chunk; give it a fresh timeout for
+			// this one. Checking ctx afterwards keeps the AfterFunc's
+			// deadline from being overwritten.
+			p.term.SetWriteDeadline(time.Now().Add(p.writeTimeout))
+			if ctx.Err() != nil {
+				return written, &WriteError{Written: written, Total: len(data), Err: context.Cause(ctx)}
+			}
+		}
+		end := min(written+writeChunkSize, len(data))
+		n, err := p.term.Write(data[written:end])
+		written += n
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) && ctx.Err() != nil {
+				err = context.Cause(ctx)
+			} else if errors.Is(err, os.ErrDeadlineExceeded) {
+				err = ErrInputBlocked
+			} else {
+				err = fmt.Errorf("failed to write to PTY: %w", err)
+			}
+			return written, &WriteError{Written: written, Total: len(data), Err: err}
+		}
+	}
+	return written, nil
 }
 
+// Resize sets the terminal size and returns once the terminal has it, so
+// the process's SIGWINCH (or console resize event) is already on its way.
+// Resizes are applied in call order; none are dropped.
 func (p *PTYWrapper) Resize(rows, cols uint16) error {
-	newSize := &pty.Winsize{
-		Rows: rows,
-		Cols: cols,
-	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	// Send resize request to handler goroutine
 	select {
-	case p.resizeChan <- newSize:
-		slog.Debug("Resize requested",
-			slog.String("session_id", p.sessionID),
-			slog.Int("rows", int(rows)),
-			slog.Int("cols", int(cols)),
-		)
-		return nil
 	case <-p.stopChan:
 		return fmt.Errorf("PTY is stopped")
 	default:
-		// Resize channel is full, skip this resize
-		slog.Debug("Resize skipped (channel full)",
-			slog.String("session_id", p.sessionID),
-		)
-		return nil
 	}
+	if p.term == nil {
+		return fmt.Errorf("PTY not started")
+	}
+
+	newSize := &Winsize{
+		Rows: rows,
+		Cols: cols,
+	}
+	if err := p.term.Resize(*newSize); err != nil {
+		return fmt.Errorf("failed to resize PTY: %w", err)
+	}
+
+	oldRows, oldCols := p.size.Rows, p.size.Cols
+	p.size = newSize
+	slog.Debug("PTY resized",
+		slog.String("session_id", p.sessionID),
+		slog.Int("old_rows", int(oldRows)),
+		slog.Int("old_cols", int(oldCols)),
+		slog.Int("new_rows", int(rows)),
+		slog.Int("new_cols", int(cols)),
+	)
+	return nil
+}
+
+// Size returns the current terminal size
+func (p *PTYWrapper) Size() (rows, cols uint16) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.size.Rows, p.size.Cols
 }
 
 func (p *PTYWrapper) Stop() error {
+	_, err := p.shutdown(0)
+	return err
+}
+
+// Signal sends a signal to the process
+func (p *PTYWrapper) Signal(sig Signal) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.term == nil || p.process == nil {
+		return fmt.Errorf("PTY not started")
+	}
+	return p.term.Signal(p.process, sig)
+}
+
+// SignalForeground sends a signal to the terminal's foreground process
+// group, which is what typing the control character does in a terminal
+// whose line discipline generates signals. It returns the group signalled.
+func (p *PTYWrapper) SignalForeground(sig Signal) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.term == nil || p.process == nil {
+		return 0, fmt.Errorf("PTY not started")
+	}
+	return p.term.SignalForeground(p.process, sig)
+}
+
+// Terminate asks the process to exit (SIGTERM, or Ctrl+C on Windows) and
+// kills it if it is still running after the grace period. It reports whether
+// a kill was needed.
+func (p *PTYWrapper) Terminate(grace time.Duration) (bool, error) {
+	return p.shutdown(grace)
+}
+
+// shutdown stops the process and closes the PTY. A zero grace period kills
+// the process immediately.
+func (p *PTYWrapper) shutdown(grace time.Duration) (bool, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -162,99 +462,148 @@ func (p *PTYWrapper) Stop() error {
 	select {
 	case <-p.stopChan:
 		// Already stopped
-		return nil
+		return false, nil
 	default:
 		close(p.stopChan)
 	}
 
-	// Kill the process if it's still running
+	killed := false
 	if p.process != nil {
-		if err := p.process.Kill(); err != nil {
-			// Process might already be dead
-			if !os.IsPermission(err) {
-				utils.LogError(err, "Failed to kill process",
-					slog.String("session_id", p.sessionID),
-				)
+		exited := p.exited
+
+		graceful := false
+		if grace > 0 && p.term.Signal(p.process, SignalTerminate) == nil {
+			select {
+			case <-exited:
+				graceful = true
+			case <-time.After(grace):
 			}
 		}
-		
-		// Wait for process to exit
-		_, _ = p.process.Wait()
+
+		// Kill the process if it's still running
+		if !graceful {
+			select {
+			case <-exited:
+				// Already exited on its own
+			default:
+				if err := p.process.Kill(); err != nil {
+					// Process might already be dead
+					if !os.IsPermission(err) && !errors.Is(err, os.ErrProcessDone) {
+						utils.LogError(err, "Failed to kill process",
+							slog.String("session_id", p.sessionID),
+						)
+					}
+				} else {
+					killed = true
+				}
+			}
+			<-exited
+		}
+	}
+
+	if p.stderrRead != nil {
+		p.stderrRead.Close()
 	}
 
-	// Close PTY
-	if p.pty != nil {
-		if err := p.pty.Close(); err != nil {
-			return fmt.Errorf("failed to close PTY: %w", err)
+	// Close PTY; an already closed PTY is not an error
+	if p.term != nil {
+		if err := p.term.Stop(); err != nil && !errors.Is(err, os.ErrClosed) {
+			return killed, fmt.Errorf("failed to close PTY: %w", err)
 		}
 	}
 
-	return nil
+	return killed, nil
 }
 
-func (p *PTYWrapper) IsRunning() bool {
+// Exited returns a channel that is closed once the process has exited and
+// its status is known. It is nil before the process is started.
+func (p *PTYWrapper) Exited() <-chan struct{} {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	return p.exited
+}
 
-	if p.process == nil {
+func (p *PTYWrapper) IsRunning() bool {
+	p.mu.Lock()
+	done := p.exited
+	p.mu.Unlock()
+
+	if done == nil {
 		return false
 	}
 
-	// Check if process is still running
-	// This is a non-blocking check
-	return p.process.Signal(os.Signal(nil)) == nil
+	// The process is running until the reaper sees it exit
+	select {
+	case <-done:
+		return false
+	default:
+		return true
+	}
 }
 
-// SetSessionID sets the session ID for logging
-func (p *PTYWrapper) SetSessionID(id string) {
-	p.sessionID = id
+// ExitStatus reports whether the process has exited and, if so, its exit
+// code and a description such as "exit status 1" or "signal: killed".
+// The code is -1 when the process was terminated by a signal.
+func (p *PTYWrapper) ExitStatus() (exited bool, code int, status string) {
+	p.mu.Lock()
+	done := p.exited
+	p.mu.Unlock()
+
+	if done == nil {
+		return false, 0, ""
+	}
+	select {
+	case <-done:
+	default:
+		return false, 0, ""
+	}
+	if p.exitState == nil {
+		return true, -1, "unknown"
+	}
+	return true, p.exitState.ExitCode(), p.exitState.String()
 }
 
-// handleResize handles resize requests in a separate goroutine
-func (p *PTYWrapper) handleResize() {
-	for {
-		select {
-		case newSize := <-p.resizeChan:
-			p.mu.Lock()
-			if p.pty != nil {
-				oldRows, oldCols := p.size.Rows, p.size.Cols
-				p.size = newSize
-				
-				err := pty.Setsize(p.pty, p.size)
-				if err != nil {
-					utils.LogError(err, "Failed to resize PTY",
-						slog.String("session_id", p.sessionID),
-						slog.Int("rows", int(newSize.Rows)),
-						slog.Int("cols", int(newSize.Cols)),
-					)
-				} else {
-					slog.Info("PTY resized",
-						slog.String("session_id", p.sessionID),
-						slog.Int("old_rows", int(oldRows)),
-						slog.Int("old_cols", int(oldCols)),
-						slog.Int("new_rows", int(newSize.Rows)),
-						slog.Int("new_cols", int(newSize.Cols)),
-					)
-				}
-			}
-			p.mu.Unlock()
-		case <-p.stopChan:
-			return
-		}
+// PID returns the process ID of the child, or 0 if it has not been started
+func (p *PTYWrapper) PID() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.process == nil {
+		return 0
 	}
+	return p.process.Pid
 }
 
-// StartSIGWINCHHandler starts monitoring for terminal size changes
-// This is mainly for when the MCP server itself is running in a terminal
-func StartSIGWINCHHandler() {
-	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, syscall.SIGWINCH)
-	
-	go func() {
-		for range ch {
-			// In a real implementation, you would get the new terminal size
-			// and propagate it to active sessions
-			slog.Debug("SIGWINCH received")
-		}
-	}()
-}
\ No newline at end of file
+// PGID returns the process group ID of the child
+func (p *PTYWrapper) PGID() (int, error) {
+	pid := p.PID()
+	if pid == 0 {
+		return 0, fmt.Errorf("PTY not started")
+	}
+
+	pgid, err := processGroup(pid)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get process group: %w", err)
+	}
+	return pgid, nil
+}
+
+// ProcessInfo returns state, resource usage and descendants of the child process
+func (p *PTYWrapper) ProcessInfo() (*ProcessInfo, error) {
+	pid := p.PID()
+	if pid == 0 {
+		return nil, fmt.Errorf("PTY not started")
+	}
+
+	pgid, err := p.PGID()
+	if err != nil {
+		return nil, err
+	}
+
+	return readProcessInfo(pid, pgid)
+}
+
+// SetSessionID sets the session ID for logging
+func (p *PTYWrapper) SetSessionID(id string) {
+	p.sessionID = id
+}
@@ -0,0 +1,166 @@
+package terminal
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTerminal records what PTYWrapper asks of the platform terminal. The
+// process itself is real, so exit and kill handling behave as usual.
+type fakeTerminal struct {
+	mu        sync.Mutex
+	startSize Winsize
+	resizes   []Winsize
+	signals   []Signal
+	stopped   bool
+	out       *io.PipeReader
+	outWriter *io.PipeWriter
+}
+
+func newFakeTerminal() *fakeTerminal {
+	r, w := io.Pipe()
+	return &fakeTerminal{out: r, outWriter: w}
+}
+
+func (f *fakeTerminal) Start(cmd *exec.Cmd, size Winsize) error {
+	f.mu.Lock()
+	f.startSize = size
+	f.mu.Unlock()
+	return cmd.Start()
+}
+
+func (f *fakeTerminal) Read(p []byte) (int, error)  { return f.out.Read(p) }
+func (f *fakeTerminal) Write(p []byte) (int, error) { return len(p), nil }
+
+func (f *fakeTerminal) SetWriteDeadline(t time.Time) error { return os.ErrNoDeadline }
+
+func (f *fakeTerminal) Resize(size Winsize) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.resizes = append(f.resizes, size)
+	return nil
+}
+
+// Signal records the request without delivering it, so the process only
+// goes away when it is killed
+func (f *fakeTerminal) Signal(process *os.Process, sig Signal) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.signals = append(f.signals, sig)
+	return nil
+}
+
+func (f *fakeTerminal) SignalForeground(process *os.Process, sig Signal) (int, error) {
+	return process.Pid, f.Signal(process, sig)
+}
+
+func (f *fakeTerminal) Stop() error {
+	f.mu.Lock()
+	f.stopped = true
+	f.mu.Unlock()
+	return f.outWriter.Close()
+}
+
+// TestHelperProcess is the child process for the PTYWrapper tests
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("PTY_TEST_HELPER") != "1" {
+		return
+	}
+	time.Sleep(30 * time.Second)
+	os.Exit(0)
+}
+
+func startFakeWrapper(t *testing.T) (*PTYWrapper, *fakeTerminal) {
+	t.Helper()
+	p, err := NewPTYWrapper(os.Args[0], []string{"-test.run=^TestHelperProcess$"},
+		map[string]string{"PTY_TEST_HELPER": "1"})
+	if err != nil {
+		t.Fatalf("NewPTYWrapper failed: %v", err)
+	}
+	fake := newFakeTerminal()
+	p.newTerm = func() pseudoTerminal { return fake }
+	p.SetSize(30, 100)
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	t.Cleanup(func() { p.Stop() })
+	return p, fake
+}
+
+func TestPTYWrapper_UsesPlatformTerminal(t *testing.T) {
+	p, fake := startFakeWrapper(t)
+
+	if fake.startSize != (Winsize{Rows: 30, Cols: 100}) {
+		t.Errorf("Expected the terminal to start at 30x100, got %+v", fake.startSize)
+	}
+	if !p.IsRunning() || p.PID() == 0 {
+		t.Fatal("Expected a running process")
+	}
+
+	// Resizes reach the terminal before Resize returns
+	for i := uint16(0); i < 5; i++ {
+		if err := p.Resize(40+i, 120); err != nil {
+			t.Fatalf("Resize failed: %v", err)
+		}
+	}
+	if len(fake.resizes) != 5 || fake.resizes[4] != (Winsize{Rows: 44, Cols: 120}) {
+		t.Errorf("Expected 5 resizes ending at 44x120, got %+v", fake.resizes)
+	}
+	if rows, cols := p.Size(); rows != 44 || cols != 120 {
+		t.Errorf("Expected size 44x120, got %dx%d", rows, cols)
+	}
+
+	if err := p.Signal(SignalInterrupt); err != nil {
+		t.Fatalf("Signal failed: %v", err)
+	}
+	if len(fake.signals) != 1 || fake.signals[0] != SignalInterrupt {
+		t.Errorf("Expected an interrupt, got %v", fake.signals)
+	}
+}
+
+func TestPTYWrapper_TerminateKillsAfterGrace(t *testing.T) {
+	p, fake := startFakeWrapper(t)
+
+	killed, err := p.Terminate(100 * time.Millisecond)
+	if err != nil {
+		t.Fatalf("Terminate failed: %v", err)
+	}
+	if !killed {
+		t.Error("Expected a kill after the ignored terminate request")
+	}
+	if len(fake.signals) != 1 || fake.signals[0] != SignalTerminate {
+		t.Errorf("Expected a terminate request first, got %v", fake.signals)
+	}
+	if err := p.Resize(30, 90); err == nil {
+		t.Error("Expected resizing a stopped PTY to fail")
+	}
+	if !fake.stopped {
+		t.Error("Expected the terminal to be stopped")
+	}
+	if p.IsRunning() {
+		t.Error("Process still running after Terminate")
+	}
+	if _, err := p.Read(); err != io.EOF {
+		t.Errorf("Expected EOF from a stopped terminal, got %v", err)
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	for name, want := range map[string]Signal{
+		"terminate": SignalTerminate,
+		"SIGTERM":   SignalTerminate,
+		"interrupt": SignalInterrupt,
+		"SIGTSTP":   SignalSuspend,
+	} {
+		if sig, err := ParseSignal(name); err != nil || sig != want {
+			t.Errorf("ParseSignal(%q) = %v, %v; want %v", name, sig, err, want)
+		}
+	}
+	if _, err := ParseSignal("sigterm"); err == nil {
+		t.Error("Expected an unknown signal name to be refused")
+	}
+}
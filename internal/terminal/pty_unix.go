@@ -0,0 +1,181 @@
+//go:build !windows
+
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/creack/pty"
+)
+
+// stderrSeparable is whether SeparateStderr can give the process a stderr
+// apart from the terminal
+const stderrSeparable = true
+
+// startPTY opens a terminal and starts cmd in it; tests replace it to
+// inject failures
+var startPTY = pty.StartWithSize
+
+// unixPTY is a pseudo-terminal from the operating system's pty driver
+type unixPTY struct {
+	ptmx *os.File
+}
+
+func newPseudoTerminal() pseudoTerminal {
+	return &unixPTY{}
+}
+
+func (u *unixPTY) Start(cmd *exec.Cmd, size Winsize) error {
+	ptmx, err := startPTY(cmd, &pty.Winsize{Rows: size.Rows, Cols: size.Cols})
+	if err != nil {
+		// The file passed the executable check but the kernel refused it,
+		// e.g. a script without a shebang or a binary for another platform
+		if errors.Is(err, syscall.ENOEXEC) {
+			return &CommandError{
+				Command: cmd.Args[0],
+				Path:    cmd.Path,
+				Err:     ErrNotExecutable,
+				Detail:  "exec format error",
+			}
+		}
+		return fmt.Errorf("failed to start PTY: %w", classifyOpenError(err))
+	}
+	if ptmx, err = pollable(ptmx); err != nil {
+		// The process is already running without a terminal to talk to
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("failed to start PTY: %w", classifyOpenError(err))
+	}
+	u.ptmx = ptmx
+	return nil
+}
+
+// pollable replaces a blocking terminal file with a non-blocking duplicate.
+// The pty package leaves the master blocking (os.File.Fd does that), and
+// only non-blocking files go through Go's poller, which deadlines need.
+func pollable(f *os.File) (*os.File, error) {
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), f.Name()), nil
+}
+
+func (u *unixPTY) Read(p []byte) (int, error) {
+	return u.ptmx.Read(p)
+}
+
+func (u *unixPTY) Write(p []byte) (int, error) {
+	return u.ptmx.Write(p)
+}
+
+func (u *unixPTY) SetWriteDeadline(t time.Time) error {
+	return u.ptmx.SetWriteDeadline(t)
+}
+
+// Resize sets the window size through the file's raw connection;
+// pty.Setsize would put the master back into blocking mode
+func (u *unixPTY) Resize(size Winsize) error {
+	conn, err := u.ptmx.SyscallConn()
+	if err != nil {
+		return err
+	}
+	ws := pty.Winsize{Rows: size.Rows, Cols: size.Cols}
+	var errno syscall.Errno
+	if err := conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&ws)))
+	}); err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// unixSignals maps signals onto Unix signals
+var unixSignals = map[Signal]syscall.Signal{
+	SignalInterrupt: syscall.SIGINT,
+	SignalTerminate: syscall.SIGTERM,
+	SignalKill:      syscall.SIGKILL,
+	SignalQuit:      syscall.SIGQUIT,
+	SignalSuspend:   syscall.SIGTSTP,
+	SignalContinue:  syscall.SIGCONT,
+}
+
+func (u *unixPTY) Signal(process *os.Process, sig Signal) error {
+	s, ok := unixSignals[sig]
+	if !ok {
+		return fmt.Errorf("unknown signal %d", sig)
+	}
+	return process.Signal(s)
+}
+
+func (u *unixPTY) SignalForeground(process *os.Process, sig Signal) (int, error) {
+	s, ok := unixSignals[sig]
+	if !ok {
+		return 0, fmt.Errorf("unknown signal %d", sig)
+	}
+	pgrp, err := u.foregroundGroup()
+	if err != nil {
+		return 0, err
+	}
+	return pgrp, syscall.Kill(-pgrp, s)
+}
+
+// foregroundGroup asks the terminal for its foreground process group
+// through the file's raw connection, like Resize
+func (u *unixPTY) foregroundGroup() (int, error) {
+	conn, err := u.ptmx.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var pgrp int32
+	var errno syscall.Errno
+	if err := conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCGPGRP, uintptr(unsafe.Pointer(&pgrp)))
+	}); err != nil {
+		return 0, err
+	}
+	if errno != 0 {
+		return 0, fmt.Errorf("failed to get the foreground process group: %w", errno)
+	}
+	return int(pgrp), nil
+}
+
+func (u *unixPTY) Stop() error {
+	return u.ptmx.Close()
+}
+
+// processGroup returns the process group ID of a process
+func processGroup(pid int) (int, error) {
+	return syscall.Getpgid(pid)
+}
+
+// StartSIGWINCHHandler starts monitoring for terminal size changes
+// This is mainly for when the MCP server itself is running in a terminal
+func StartSIGWINCHHandler() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGWINCH)
+	
+	go func() {
+		for range ch {
+			// In a real implementation, you would get the new terminal size
+			// and propagate it to active sessions
+			slog.Debug("SIGWINCH received")
+		}
+	}()
+}
@@ -0,0 +1,229 @@
+//go:build !windows
+
+package terminal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+// startStalledChild starts a child that stops reading its input: it
+// switches to raw mode and is then suspended. In canonical mode the line
+// discipline would discard input past a full line instead of blocking.
+func startStalledChild(t *testing.T, writeTimeout time.Duration) *PTYWrapper {
+	t.Helper()
+	p, err := NewPTYWrapper("sh", []string{"-c", "stty raw -echo; echo ready; exec sleep 30"}, nil)
+	if err != nil {
+		t.Fatalf("NewPTYWrapper failed: %v", err)
+	}
+	p.SetWriteTimeout(writeTimeout)
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	t.Cleanup(func() { p.Stop() })
+
+	// Keep draining output as a session would, so only the input side stalls
+	ready := make(chan struct{})
+	started := ready
+	go func() {
+		var out strings.Builder
+		for {
+			data, err := p.Read()
+			if err != nil {
+				return
+			}
+			if ready != nil {
+				out.Write(data)
+				if strings.Contains(out.String(), "ready") {
+					close(ready)
+					ready = nil
+				}
+			}
+		}
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Child never switched to raw mode")
+	}
+
+	// A small write fits in the terminal's buffers and succeeds
+	if n, err := p.Write(context.Background(), []byte("hello")); err != nil || n != 5 {
+		t.Fatalf("Expected 5 bytes written, got %d: %v", n, err)
+	}
+
+	pid := p.PID()
+	if err := syscall.Kill(pid, syscall.SIGSTOP); err != nil {
+		t.Fatalf("Failed to stop child: %v", err)
+	}
+	t.Cleanup(func() { syscall.Kill(pid, syscall.SIGCONT) })
+	return p
+}
+
+func TestPTYWrapper_WriteTimesOutWhenChildStopped(t *testing.T) {
+	p := startStalledChild(t, 200*time.Millisecond)
+
+	payload := []byte(strings.Repeat("x", 1<<20))
+	start := time.Now()
+	n, err := p.Write(context.Background(), payload)
+	elapsed := time.Since(start)
+
+	if !errors.Is(err, ErrInputBlocked) {
+		t.Fatalf("Expected ErrInputBlocked, got %v", err)
+	}
+	var writeErr *WriteError
+	if !errors.As(err, &writeErr) {
+		t.Fatalf("Expected a *WriteError, got %T", err)
+	}
+	if writeErr.Written != n || writeErr.Total != len(payload) || n >= len(payload) {
+		t.Errorf("Expected a partial write, got %d of %d (returned %d)", writeErr.Written, writeErr.Total, n)
+	}
+	if elapsed > 2*time.Second {
+		t.Errorf("Write took %v, expected it to give up near the 200ms timeout", elapsed)
+	}
+}
+
+func TestPTYWrapper_WriteToSlowReaderOutlastsTimeout(t *testing.T) {
+	// The child takes a chunk at a time with pauses in between, so the whole
+	// payload takes several write timeouts but no single chunk does
+	script := "stty raw -echo; echo ready; while dd bs=4096 count=1 of=/dev/null 2>/dev/null; do sleep 0.02; done"
+	p, err := NewPTYWrapper("sh", []string{"-c", script}, nil)
+	if err != nil {
+		t.Fatalf("NewPTYWrapper failed: %v", err)
+	}
+	p.SetWriteTimeout(300 * time.Millisecond)
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	t.Cleanup(func() { p.Stop() })
+
+	ready := make(chan struct{})
+	go func(signal chan struct{}) {
+		var out strings.Builder
+		for {
+			data, err := p.Read()
+			if err != nil {
+				return
+			}
+			if signal != nil {
+				out.Write(data)
+				if strings.Contains(out.String(), "ready") {
+					close(signal)
+					signal = nil
+				}
+			}
+		}
+	}(ready)
+	select {
+	case <-ready:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Child never switched to raw mode")
+	}
+
+	payload := []byte(strings.Repeat("x", 256<<10))
+	start := time.Now()
+	n, err := p.Write(context.Background(), payload)
+	if err != nil || n != len(payload) {
+		t.Fatalf("Expected %d bytes written, got %d after %v: %v", len(payload), n, time.Since(start), err)
+	}
+}
+
+func TestPTYWrapper_WriteAbortedByContext(t *testing.T) {
+	p := startStalledChild(t, 30*time.Second)
+
+	stopped := errors.New("session went away")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	time.AfterFunc(100*time.Millisecond, func() { cancel(stopped) })
+
+	start := time.Now()
+	n, err := p.Write(ctx, []byte(strings.Repeat("x", 1<<20)))
+	if !errors.Is(err, stopped) {
+		t.Fatalf("Expected the context's cause, got %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("Write took %v to notice the cancelled context", time.Since(start))
+	}
+	if n >= 1<<20 {
+		t.Errorf("Expected a partial write, got %d bytes", n)
+	}
+
+	if _, err := p.Write(ctx, []byte("x")); !errors.Is(err, stopped) {
+		t.Errorf("Expected a write with a done context to fail, got %v", err)
+	}
+}
+
+func TestPTYWrapper_StartClassifiesOpenFailures(t *testing.T) {
+	saved := startPTY
+	startPTY = func(cmd *exec.Cmd, ws *pty.Winsize) (*os.File, error) {
+		return nil, &os.PathError{Op: "open", Path: "/dev/ptmx", Err: syscall.EMFILE}
+	}
+	defer func() { startPTY = saved }()
+	stubFDUsage(t, 1023, 1024, nil)
+
+	p, err := NewPTYWrapper("sleep", []string{"1"}, nil)
+	if err != nil {
+		t.Fatalf("NewPTYWrapper failed: %v", err)
+	}
+	err = p.Start()
+	var ptyErr *PTYError
+	if !errors.As(err, &ptyErr) || ptyErr.Code() != "fd_exhausted" {
+		t.Fatalf("Expected an fd_exhausted PTYError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "1023 of 1024 file descriptors in use") {
+		t.Errorf("Expected descriptor counts in %q", err.Error())
+	}
+}
+
+func TestPTYWrapper_SeparateStderr(t *testing.T) {
+	p, err := NewPTYWrapper("sh", []string{"-c", "[ -t 2 ] || echo stderr-not-tty; echo to-stdout; echo to-stderr >&2"}, nil)
+	if err != nil {
+		t.Fatalf("NewPTYWrapper failed: %v", err)
+	}
+	if p.Stderr() != nil {
+		t.Error("Expected no stderr reader before SeparateStderr")
+	}
+	if err := p.SeparateStderr(); err != nil {
+		t.Fatalf("SeparateStderr failed: %v", err)
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer p.Stop()
+
+	// The pipe ends when the child exits, as only it holds the write end
+	stderr, err := io.ReadAll(p.Stderr())
+	if err != nil || string(stderr) != "to-stderr\n" {
+		t.Errorf("Expected only the stderr line on the pipe, got %q (%v)", stderr, err)
+	}
+	var stdout strings.Builder
+	for {
+		data, err := p.Read()
+		if err != nil {
+			break
+		}
+		stdout.Write(data)
+	}
+	if out := stdout.String(); !strings.Contains(out, "stderr-not-tty") || !strings.Contains(out, "to-stdout") || strings.Contains(out, "to-stderr") {
+		t.Errorf("Expected stdout alone on the terminal, got %q", out)
+	}
+}
+
+func TestFDUsage(t *testing.T) {
+	open, limit, err := FDUsage()
+	if err != nil {
+		t.Fatalf("FDUsage failed: %v", err)
+	}
+	// stdin, stdout and stderr at least
+	if open < 3 || limit < open {
+		t.Errorf("Implausible usage: %d open of %d", open, limit)
+	}
+}
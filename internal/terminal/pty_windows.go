@@ -0,0 +1,272 @@
+//go:build windows
+
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// ConPTY is available from Windows 10 1809 and Windows Server 2019
+var (
+	kernel32                              = syscall.NewLazyDLL("kernel32.dll")
+	procCreatePseudoConsole               = kernel32.NewProc("CreatePseudoConsole")
+	procResizePseudoConsole               = kernel32.NewProc("ResizePseudoConsole")
+	procClosePseudoConsole                = kernel32.NewProc("ClosePseudoConsole")
+	procInitializeProcThreadAttributeList = kernel32.NewProc("InitializeProcThreadAttributeList")
+	procUpdateProcThreadAttribute         = kernel32.NewProc("UpdateProcThreadAttribute")
+	procDeleteProcThreadAttributeList     = kernel32.NewProc("DeleteProcThreadAttributeList")
+)
+
+// stderrSeparable is false: a process attached to a pseudo console gets
+// its standard handles from the console
+const stderrSeparable = false
+
+const (
+	procThreadAttributePseudoConsole = 0x00020016
+	extendedStartupInfoPresent       = 0x00080000
+	errorBadExeFormat                = syscall.Errno(193)
+)
+
+// startupInfoEx is STARTUPINFOEXW
+type startupInfoEx struct {
+	StartupInfo   syscall.StartupInfo
+	AttributeList *byte
+}
+
+// conPTY is a Windows pseudoconsole. The console translates between VT
+// sequences on its pipes and the console API used by the process.
+type conPTY struct {
+	console   syscall.Handle // HPCON
+	input     *os.File       // Write end of the console's input pipe
+	output    *os.File       // Read end of the console's output pipe
+	closeOnce sync.Once
+}
+
+func newPseudoTerminal() pseudoTerminal {
+	return &conPTY{}
+}
+
+// coord packs a size into a COORD passed by value: columns in the low
+// 16 bits, rows in the high 16 bits
+func coord(size Winsize) uintptr {
+	return uintptr(uint32(size.Cols) | uint32(size.Rows)<<16)
+}
+
+func (c *conPTY) Start(cmd *exec.Cmd, size Winsize) error {
+	if err := procCreatePseudoConsole.Find(); err != nil {
+		return fmt.Errorf("failed to start PTY: ConPTY requires Windows 10 1809 or later: %w", err)
+	}
+
+	// The console reads input from inputRead and writes output to outputWrite
+	var inputRead, inputWrite, outputRead, outputWrite syscall.Handle
+	if err := syscall.CreatePipe(&inputRead, &inputWrite, nil, 0); err != nil {
+		return fmt.Errorf("failed to start PTY: %w", err)
+	}
+	if err := syscall.CreatePipe(&outputRead, &outputWrite, nil, 0); err != nil {
+		syscall.CloseHandle(inputRead)
+		syscall.CloseHandle(inputWrite)
+		return fmt.Errorf("failed to start PTY: %w", err)
+	}
+
+	var console syscall.Handle
+	hr, _, _ := procCreatePseudoConsole.Call(coord(size), uintptr(inputRead), uintptr(outputWrite), 0, uintptr(unsafe.Pointer(&console)))
+	// The console keeps its own references to its ends of the pipes
+	syscall.CloseHandle(inputRead)
+	syscall.CloseHandle(outputWrite)
+	if hr != 0 {
+		syscall.CloseHandle(inputWrite)
+		syscall.CloseHandle(outputRead)
+		return fmt.Errorf("failed to start PTY: CreatePseudoConsole failed with HRESULT 0x%08x", hr)
+	}
+
+	c.console = console
+	c.input = os.NewFile(uintptr(inputWrite), "conpty-input")
+	c.output = os.NewFile(uintptr(outputRead), "conpty-output")
+
+	processHandle, pid, err := startInConsole(cmd, console)
+	if err != nil {
+		c.Stop()
+		return err
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		syscall.TerminateProcess(processHandle, 1)
+		syscall.CloseHandle(processHandle)
+		c.Stop()
+		return fmt.Errorf("failed to start PTY: %w", err)
+	}
+	cmd.Process = process
+
+	// The console keeps its output pipe open after the process exits, so
+	// reads would block forever. Closing it once the process is gone flushes
+	// the remaining output and then ends reads with EOF, as on Unix.
+	go func() {
+		syscall.WaitForSingleObject(processHandle, syscall.INFINITE)
+		syscall.CloseHandle(processHandle)
+		c.closeConsole()
+	}()
+
+	return nil
+}
+
+// startInConsole creates cmd's process attached to the pseudoconsole and
+// returns a handle to it and its PID
+func startInConsole(cmd *exec.Cmd, console syscall.Handle) (syscall.Handle, int, error) {
+	// Build a thread attribute list holding the pseudoconsole
+	var listSize uintptr
+	procInitializeProcThreadAttributeList.Call(0, 1, 0, uintptr(unsafe.Pointer(&listSize)))
+	list := make([]byte, listSize)
+	if ok, _, err := procInitializeProcThreadAttributeList.Call(uintptr(unsafe.Pointer(&list[0])), 1, 0, uintptr(unsafe.Pointer(&listSize))); ok == 0 {
+		return 0, 0, fmt.Errorf("failed to start PTY: %w", err)
+	}
+	defer procDeleteProcThreadAttributeList.Call(uintptr(unsafe.Pointer(&list[0])))
+	if ok, _, err := procUpdateProcThreadAttribute.Call(uintptr(unsafe.Pointer(&list[0])), 0,
+		procThreadAttributePseudoConsole, uintptr(console), unsafe.Sizeof(console), 0, 0); ok == 0 {
+		return 0, 0, fmt.Errorf("failed to start PTY: %w", err)
+	}
+
+	var si startupInfoEx
+	si.StartupInfo.Cb = uint32(unsafe.Sizeof(si))
+	si.AttributeList = &list[0]
+
+	appName, err := syscall.UTF16PtrFromString(cmd.Path)
+	if err != nil {
+		return 0, 0, err
+	}
+	args := make([]string, len(cmd.Args))
+	for i, arg := range cmd.Args {
+		args[i] = syscall.EscapeArg(arg)
+	}
+	commandLine, err := syscall.UTF16PtrFromString(strings.Join(args, " "))
+	if err != nil {
+		return 0, 0, err
+	}
+	env, err := environmentBlock(cmd.Env)
+	if err != nil {
+		return 0, 0, err
+	}
+	var dir *uint16
+	if cmd.Dir != "" {
+		if dir, err = syscall.UTF16PtrFromString(cmd.Dir); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	var pi syscall.ProcessInformation
+	err = syscall.CreateProcess(appName, commandLine, nil, nil, false,
+		extendedStartupInfoPresent|syscall.CREATE_UNICODE_ENVIRONMENT,
+		env, dir, &si.StartupInfo, &pi)
+	if err != nil {
+		if errors.Is(err, errorBadExeFormat) {
+			return 0, 0, &CommandError{
+				Command: cmd.Args[0],
+				Path:    cmd.Path,
+				Err:     ErrNotExecutable,
+				Detail:  "exec format error",
+			}
+		}
+		return 0, 0, fmt.Errorf("failed to start PTY: %w", err)
+	}
+	syscall.CloseHandle(pi.Thread)
+	return pi.Process, int(pi.ProcessId), nil
+}
+
+// environmentBlock encodes env as a Unicode environment block: each
+// KEY=VALUE terminated by NUL, and the block by another NUL
+func environmentBlock(env []string) (*uint16, error) {
+	var block []uint16
+	for _, kv := range env {
+		encoded, err := syscall.UTF16FromString(kv)
+		if err != nil {
+			return nil, err
+		}
+		block = append(block, encoded...)
+	}
+	block = append(block, 0)
+	return &block[0], nil
+}
+
+func (c *conPTY) Read(p []byte) (int, error) {
+	n, err := c.output.Read(p)
+	if errors.Is(err, syscall.ERROR_BROKEN_PIPE) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (c *conPTY) Write(p []byte) (int, error) {
+	return c.input.Write(p)
+}
+
+// SetWriteDeadline is not supported: the console's input is a synchronous
+// pipe, so writes block until the console takes them
+func (c *conPTY) SetWriteDeadline(t time.Time) error {
+	return os.ErrNoDeadline
+}
+
+func (c *conPTY) Resize(size Winsize) error {
+	if hr, _, _ := procResizePseudoConsole.Call(uintptr(c.console), coord(size)); hr != 0 {
+		return fmt.Errorf("ResizePseudoConsole failed with HRESULT 0x%08x", hr)
+	}
+	return nil
+}
+
+// Signal maps signals onto console control events. GenerateConsoleCtrlEvent
+// only reaches processes attached to the caller's own console, which the
+// pseudoconsole is not, so Ctrl+C is typed instead: the console turns an ETX
+// byte on its input into a CTRL_C_EVENT for the processes attached to it.
+// Windows has no separate polite termination request, so SignalTerminate
+// is delivered the same way.
+func (c *conPTY) Signal(process *os.Process, sig Signal) error {
+	switch sig {
+	case SignalInterrupt, SignalTerminate:
+		_, err := c.input.Write([]byte{0x03})
+		return err
+	case SignalKill:
+		return process.Kill()
+	case SignalQuit, SignalSuspend, SignalContinue:
+		return fmt.Errorf("%s is not supported on Windows", sig)
+	}
+	return fmt.Errorf("unknown signal %d", sig)
+}
+
+// SignalForeground is Signal: the console's control events already reach
+// every process attached to it
+func (c *conPTY) SignalForeground(process *os.Process, sig Signal) (int, error) {
+	return process.Pid, c.Signal(process, sig)
+}
+
+func (c *conPTY) Stop() error {
+	c.closeConsole()
+	inErr := c.input.Close()
+	outErr := c.output.Close()
+	if inErr != nil {
+		return inErr
+	}
+	return outErr
+}
+
+// closeConsole closes the pseudoconsole, which ends the output stream
+func (c *conPTY) closeConsole() {
+	c.closeOnce.Do(func() {
+		procClosePseudoConsole.Call(uintptr(c.console))
+	})
+}
+
+// processGroup stands in for the Unix process group. Windows has none, so
+// a process is its own group.
+func processGroup(pid int) (int, error) {
+	return pid, nil
+}
+
+// StartSIGWINCHHandler does nothing on Windows, which has no SIGWINCH
+func StartSIGWINCHHandler() {}
@@ -0,0 +1,43 @@
+//go:build windows
+
+package terminal
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCoord(t *testing.T) {
+	if got := coord(Winsize{Rows: 24, Cols: 80}); got != 24<<16|80 {
+		t.Errorf("Expected columns in the low word and rows in the high word, got %#x", got)
+	}
+}
+
+func TestConPTY_RunsCommand(t *testing.T) {
+	if procCreatePseudoConsole.Find() != nil {
+		t.Skip("ConPTY not available")
+	}
+
+	p, err := NewPTYWrapper("cmd.exe", []string{"/c", "echo conpty-ok"}, nil)
+	if err != nil {
+		t.Fatalf("NewPTYWrapper failed: %v", err)
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer p.Stop()
+
+	var out strings.Builder
+	deadline := time.Now().Add(10 * time.Second)
+	for !strings.Contains(out.String(), "conpty-ok") {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for output, got %q", out.String())
+		}
+		data, err := p.Read()
+		if err != nil {
+			t.Fatalf("Read failed after %q: %v", out.String(), err)
+		}
+		out.Write(data)
+	}
+}
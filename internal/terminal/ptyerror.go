@@ -0,0 +1,99 @@
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// Reasons a pseudo-terminal cannot be opened. Use errors.Is to test a
+// *PTYError against them.
+var (
+	ErrTooManyFiles   = errors.New("too many open files")
+	ErrPTYUnavailable = errors.New("pseudo-terminal device unavailable")
+	ErrPTYPermission  = errors.New("permission denied opening pseudo-terminal")
+)
+
+// PTYError reports a pseudo-terminal that could not be opened, with a hint
+// on how to fix it
+type PTYError struct {
+	Err   error  // ErrTooManyFiles, ErrPTYUnavailable or ErrPTYPermission
+	Cause error  // Error from the operating system
+	Hint  string // What to do about it
+}
+
+func (e *PTYError) Error() string {
+	msg := fmt.Sprintf("%s: %v", e.Err, e.Cause)
+	if e.Hint != "" {
+		msg += " (" + e.Hint + ")"
+	}
+	return msg
+}
+
+// Unwrap exposes both the reason and the cause, so errors.Is matches
+// ErrTooManyFiles as well as syscall.EMFILE
+func (e *PTYError) Unwrap() []error {
+	return []error{e.Err, e.Cause}
+}
+
+// Code is a stable identifier for the reason, for clients to act on
+func (e *PTYError) Code() string {
+	switch e.Err {
+	case ErrTooManyFiles:
+		return "fd_exhausted"
+	case ErrPTYUnavailable:
+		return "pty_unavailable"
+	case ErrPTYPermission:
+		return "pty_permission"
+	}
+	return "pty_error"
+}
+
+// fdUsage reports open file descriptors and the limit; tests replace it
+var fdUsage = FDUsage
+
+// classifyOpenError turns the common ways of failing to open a terminal
+// into a *PTYError. Other errors are returned unchanged.
+func classifyOpenError(err error) error {
+	switch {
+	case errors.Is(err, syscall.EMFILE):
+		hint := "stop unused sessions or raise the limit with ulimit -n"
+		if open, limit, ferr := fdUsage(); ferr == nil {
+			hint = fmt.Sprintf("%d of %d file descriptors in use; %s", open, limit, hint)
+		} else if limit > 0 {
+			hint = fmt.Sprintf("file descriptor limit is %d; %s", limit, hint)
+		}
+		return &PTYError{Err: ErrTooManyFiles, Cause: err, Hint: hint}
+	case errors.Is(err, syscall.ENFILE):
+		return &PTYError{Err: ErrTooManyFiles, Cause: err,
+			Hint: "the system-wide file table is full; stop unused sessions or raise fs.file-max"}
+	}
+
+	// The remaining cases only concern the terminal devices, not the command
+	var pathErr *os.PathError
+	if !errors.As(err, &pathErr) || !strings.HasPrefix(pathErr.Path, "/dev/") {
+		return err
+	}
+	switch {
+	case errors.Is(err, syscall.ENOENT), errors.Is(err, syscall.ENXIO), errors.Is(err, syscall.ENODEV):
+		return &PTYError{Err: ErrPTYUnavailable, Cause: err,
+			Hint: "check that /dev/ptmx exists and devpts is mounted on /dev/pts; in containers, mount -t devpts devpts /dev/pts"}
+	case errors.Is(err, syscall.EACCES), errors.Is(err, syscall.EPERM):
+		return &PTYError{Err: ErrPTYPermission, Cause: err,
+			Hint: "the server's user needs read and write access to /dev/ptmx and /dev/pts"}
+	}
+	return err
+}
+
+// NearFDLimit reports whether the process is within 10% of its file
+// descriptor limit, along with the counts it used. It is false when usage
+// cannot be determined.
+func NearFDLimit() (near bool, open, limit int) {
+	open, limit, err := fdUsage()
+	if err != nil || limit <= 0 {
+		return false, open, limit
+	}
+	return open >= limit-limit/10, open, limit
+}
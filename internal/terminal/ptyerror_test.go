@@ -0,0 +1,96 @@
+package terminal
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// stubFDUsage replaces the descriptor counts for a test
+func stubFDUsage(t *testing.T, open, limit int, err error) {
+	t.Helper()
+	saved := fdUsage
+	fdUsage = func() (int, int, error) { return open, limit, err }
+	t.Cleanup(func() { fdUsage = saved })
+}
+
+func TestClassifyOpenError(t *testing.T) {
+	stubFDUsage(t, 1020, 1024, nil)
+
+	openErr := func(path string, errno syscall.Errno) error {
+		return &os.PathError{Op: "open", Path: path, Err: errno}
+	}
+	tests := []struct {
+		name     string
+		err      error
+		want     error // nil when the error should pass through unchanged
+		code     string
+		hintPart string
+	}{
+		{"emfile", openErr("/dev/ptmx", syscall.EMFILE), ErrTooManyFiles, "fd_exhausted", "1020 of 1024"},
+		{"emfile from dup", syscall.EMFILE, ErrTooManyFiles, "fd_exhausted", "ulimit -n"},
+		{"enfile", openErr("/dev/ptmx", syscall.ENFILE), ErrTooManyFiles, "fd_exhausted", "system-wide"},
+		{"no ptmx", openErr("/dev/ptmx", syscall.ENOENT), ErrPTYUnavailable, "pty_unavailable", "devpts"},
+		{"no pts", openErr("/dev/pts/7", syscall.ENXIO), ErrPTYUnavailable, "pty_unavailable", "devpts"},
+		{"ptmx denied", openErr("/dev/ptmx", syscall.EACCES), ErrPTYPermission, "pty_permission", "read and write"},
+		{"command missing", openErr("/usr/bin/nothing", syscall.ENOENT), nil, "", ""},
+		{"other", errors.New("boom"), nil, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyOpenError(tt.err)
+			if tt.want == nil {
+				if got != tt.err {
+					t.Errorf("Expected the error unchanged, got %v", got)
+				}
+				return
+			}
+			var ptyErr *PTYError
+			if !errors.As(got, &ptyErr) {
+				t.Fatalf("Expected a *PTYError, got %T: %v", got, got)
+			}
+			if !errors.Is(got, tt.want) || !errors.Is(got, tt.err) {
+				t.Errorf("Expected to match both %v and the cause, got %v", tt.want, got)
+			}
+			if ptyErr.Code() != tt.code {
+				t.Errorf("Expected code %q, got %q", tt.code, ptyErr.Code())
+			}
+			if !strings.Contains(got.Error(), tt.hintPart) {
+				t.Errorf("Expected hint containing %q, got %q", tt.hintPart, got.Error())
+			}
+		})
+	}
+}
+
+func TestClassifyOpenError_UncountableFDs(t *testing.T) {
+	// With no descriptors left, listing them fails too; the limit still helps
+	stubFDUsage(t, 0, 256, syscall.EMFILE)
+
+	err := classifyOpenError(&os.PathError{Op: "open", Path: "/dev/ptmx", Err: syscall.EMFILE})
+	if !strings.Contains(err.Error(), "file descriptor limit is 256") {
+		t.Errorf("Expected the limit in the hint, got %q", err.Error())
+	}
+}
+
+func TestNearFDLimit(t *testing.T) {
+	tests := []struct {
+		open, limit int
+		err         error
+		want        bool
+	}{
+		{100, 1024, nil, false},
+		{921, 1024, nil, false},
+		{922, 1024, nil, true},
+		{1024, 1024, nil, true},
+		{1000, 1024, errors.New("unavailable"), false},
+	}
+	for _, tt := range tests {
+		stubFDUsage(t, tt.open, tt.limit, tt.err)
+		if near, _, _ := NearFDLimit(); near != tt.want {
+			t.Errorf("%d of %d (err %v): expected near=%v", tt.open, tt.limit, tt.err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+//go:build !windows
+
+package terminal
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// recordFixture runs a command in a PTY of the given size and saves what it
+// prints to path, stopping it after settle if it is still running
+func recordFixture(path string, cols, rows int, command []string, settle time.Duration) error {
+	pty, err := NewPTYWrapper(command[0], command[1:], map[string]string{"TERM": "xterm-256color"})
+	if err != nil {
+		return err
+	}
+	pty.SetSize(uint16(rows), uint16(cols))
+	if err := pty.Start(); err != nil {
+		return err
+	}
+
+	var (
+		mu  sync.Mutex
+		out []byte
+	)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			data, err := pty.Read()
+			mu.Lock()
+			out = append(out, data...)
+			mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	if settle > 0 {
+		// Keep only the output up to now, not what the program prints
+		// while being stopped
+		time.Sleep(settle)
+		mu.Lock()
+		recorded := append([]byte(nil), out...)
+		mu.Unlock()
+		pty.Terminate(time.Second)
+		<-done
+		return os.WriteFile(path, recorded, 0o644)
+	}
+	<-done
+	pty.Stop()
+	return os.WriteFile(path, out, 0o644)
+}
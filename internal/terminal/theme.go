@@ -0,0 +1,103 @@
+package terminal
+
+import "fmt"
+
+// Theme gives the RGB values a terminal would show for the 16-color
+// palette and for the default foreground and background. The parser stores
+// palette colors in the default theme's values and remembers their index, so
+// a render can resolve them again under another theme.
+type Theme struct {
+	Name       string
+	Palette    [16]Color // Entries 0-7 are the normal colors, 8-15 the bright ones
+	Foreground Color     // Default foreground
+	Background Color     // Default background
+}
+
+// DefaultTheme is the VGA palette the parser resolves colors with
+const DefaultTheme = "vga"
+
+// ThemeNames lists the built-in themes accepted by LookupTheme
+var ThemeNames = []string{"vga", "xterm", "solarized-dark"}
+
+var themes = map[string]Theme{
+	"vga": {
+		Name: "vga",
+		Palette: [16]Color{
+			rgb(0x000000), rgb(0xaa0000), rgb(0x00aa00), rgb(0xaa5500),
+			rgb(0x0000aa), rgb(0xaa00aa), rgb(0x00aaaa), rgb(0xaaaaaa),
+			rgb(0x555555), rgb(0xff5555), rgb(0x55ff55), rgb(0xffff55),
+			rgb(0x5555ff), rgb(0xff55ff), rgb(0x55ffff), rgb(0xffffff),
+		},
+		Foreground: rgb(0xaaaaaa),
+		Background: rgb(0x000000),
+	},
+	"xterm": {
+		Name: "xterm",
+		Palette: [16]Color{
+			rgb(0x000000), rgb(0xcd0000), rgb(0x00cd00), rgb(0xcdcd00),
+			rgb(0x0000ee), rgb(0xcd00cd), rgb(0x00cdcd), rgb(0xe5e5e5),
+			rgb(0x7f7f7f), rgb(0xff0000), rgb(0x00ff00), rgb(0xffff00),
+			rgb(0x5c5cff), rgb(0xff00ff), rgb(0x00ffff), rgb(0xffffff),
+		},
+		Foreground: rgb(0x000000),
+		Background: rgb(0xffffff),
+	},
+	"solarized-dark": {
+		Name: "solarized-dark",
+		Palette: [16]Color{
+			rgb(0x073642), rgb(0xdc322f), rgb(0x859900), rgb(0xb58900),
+			rgb(0x268bd2), rgb(0xd33682), rgb(0x2aa198), rgb(0xeee8d5),
+			rgb(0x002b36), rgb(0xcb4b16), rgb(0x586e75), rgb(0x657b83),
+			rgb(0x839496), rgb(0x6c71c4), rgb(0x93a1a1), rgb(0xfdf6e3),
+		},
+		Foreground: rgb(0x839496),
+		Background: rgb(0x002b36),
+	},
+}
+
+// LookupTheme returns the built-in theme with the given name
+func LookupTheme(name string) (Theme, error) {
+	theme, ok := themes[name]
+	if !ok {
+		return Theme{}, fmt.Errorf("unknown theme %q (valid: %v)", name, ThemeNames)
+	}
+	return theme, nil
+}
+
+// paletteColor returns entry code of the default theme, marked with its
+// index
+func paletteColor(code int) Color {
+	c := themes[DefaultTheme].Palette[code]
+	c.Indexed = true
+	c.Index = uint8(code)
+	c.Space = ColorSpace16
+	return c
+}
+
+// Resolve returns the RGB value c is shown in under the theme. Palette
+// colors take the theme's entry and a default color takes the theme's
+// foreground, or its background when background is set; other colors are
+// returned as they are.
+func (t Theme) Resolve(c Color, background bool) Color {
+	switch {
+	case c.Default && background:
+		return t.Background
+	case c.Default:
+		return t.Foreground
+	case c.Indexed && int(c.Index) < len(t.Palette):
+		return t.Palette[c.Index]
+	}
+	return Color{R: c.R, G: c.G, B: c.B}
+}
+
+// Hex formats the color as #rrggbb, or "" for a default color
+func (c Color) Hex() string {
+	if c.Default {
+		return ""
+	}
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func rgb(v uint32) Color {
+	return Color{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v)}
+}
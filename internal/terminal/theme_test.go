@@ -0,0 +1,38 @@
+package terminal
+
+import "testing"
+
+func TestThemeResolve(t *testing.T) {
+	buffer := NewScreenBuffer(10, 1)
+	// Red on the default background, bright blue on green, and a cube
+	// color that no theme changes
+	buffer.Write([]byte("\x1b[31mA\x1b[38;5;12;42mB\x1b[0;38;5;196mC"))
+
+	cells := buffer.cells[0]
+	if fg := cells[0].Foreground; !fg.Indexed || fg.Index != 1 || fg.Hex() != "#aa0000" {
+		t.Fatalf("Expected palette red stored in the default theme, got %+v", fg)
+	}
+
+	want := map[string][]string{
+		// fg and bg hex per cell
+		"xterm":          {"#cd0000", "#ffffff", "#5c5cff", "#00cd00", "#ff0000", "#ffffff"},
+		"solarized-dark": {"#dc322f", "#002b36", "#839496", "#859900", "#ff0000", "#002b36"},
+	}
+	for name, hexes := range want {
+		theme, err := LookupTheme(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			fg := theme.Resolve(cells[i].Foreground, false).Hex()
+			bg := theme.Resolve(cells[i].Background, true).Hex()
+			if fg != hexes[2*i] || bg != hexes[2*i+1] {
+				t.Errorf("%s: cell %d resolved to %s on %s, want %s on %s", name, i, fg, bg, hexes[2*i], hexes[2*i+1])
+			}
+		}
+	}
+
+	if _, err := LookupTheme("nord"); err == nil {
+		t.Error("Expected an unknown theme to be rejected")
+	}
+}
@@ -0,0 +1,97 @@
+package terminal
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Truncation is rendered content cut to a size limit by Truncate
+type Truncation struct {
+	Content      string
+	Truncated    bool
+	OmittedBytes int // Bytes dropped from the top
+	OmittedLines int // Line breaks among the dropped bytes
+}
+
+// Truncate keeps at most max bytes from the end of rendered content, so the
+// most recent output survives. It cuts at the start of a line where one
+// fits, and otherwise never inside an escape sequence or a multibyte rune.
+// A max of zero or less keeps everything.
+func Truncate(content string, max int) Truncation {
+	if max <= 0 || len(content) <= max {
+		return Truncation{Content: content}
+	}
+
+	start := len(content) - max
+	if content[start-1] != '\n' {
+		if i := strings.IndexByte(content[start:], '\n'); i >= 0 && start+i+1 < len(content) {
+			start += i + 1
+		} else {
+			start = safeCut(content, start)
+		}
+	}
+
+	return Truncation{
+		Content:      content[start:],
+		Truncated:    true,
+		OmittedBytes: start,
+		OmittedLines: strings.Count(content[:start], "\n"),
+	}
+}
+
+// safeCut moves a cut at start forward past any escape sequence or rune
+// it would split
+func safeCut(content string, start int) int {
+	// An escape sequence can only have begun since the last line break
+	lineStart := strings.LastIndexByte(content[:start], '\n') + 1
+	for i := lineStart; i < start; i++ {
+		if content[i] != 0x1b {
+			continue
+		}
+		end := escapeEnd(content, i)
+		if end > start {
+			start = end
+			break
+		}
+		i = end - 1
+	}
+	for start < len(content) && !utf8.RuneStart(content[start]) {
+		start++
+	}
+	return start
+}
+
+// escapeEnd returns the index just past the escape sequence starting at i
+func escapeEnd(content string, i int) int {
+	j := i + 1
+	if j >= len(content) {
+		return j
+	}
+	switch content[j] {
+	case '[': // CSI: parameters and intermediates, then a final byte
+		for j++; j < len(content); j++ {
+			if content[j] >= 0x40 && content[j] <= 0x7e {
+				return j + 1
+			}
+		}
+		return j
+	case ']', 'P', '_', '^': // OSC and other strings, ended by BEL or ST
+		for j++; j < len(content); j++ {
+			if content[j] == 0x07 {
+				return j + 1
+			}
+			if content[j] == 0x1b && j+1 < len(content) && content[j+1] == '\\' {
+				return j + 2
+			}
+		}
+		return j
+	}
+	// Intermediates, then a final byte
+	for j < len(content) && content[j] >= 0x20 && content[j] <= 0x2f {
+		j++
+	}
+	if j < len(content) {
+		j++
+	}
+	return j
+}
@@ -0,0 +1,56 @@
+package terminal
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	sb := NewScreenBuffer(10, 3)
+	sb.Write([]byte("line 1\r\nline 2\r\n\x1b[31mline 3\x1b[0m\r\nline 4\r\nline 5"))
+
+	for _, format := range []string{"plain", "raw", "scrollback"} {
+		t.Run(format, func(t *testing.T) {
+			content, err := sb.Render(format)
+			if err != nil {
+				t.Fatalf("Render failed: %v", err)
+			}
+
+			// Content that fits is kept whole
+			if got := Truncate(content, len(content)); got.Truncated || got.Content != content {
+				t.Errorf("Expected content at the limit kept, got %+v", got)
+			}
+
+			// One byte over drops the top line, keeping whole lines
+			got := Truncate(content, len(content)-1)
+			if !got.Truncated || got.OmittedLines != 1 {
+				t.Fatalf("Expected the first line omitted, got %+v", got)
+			}
+			if got.OmittedBytes+len(got.Content) != len(content) || !strings.HasSuffix(content, got.Content) {
+				t.Errorf("Expected the tail of the content, got %q from %q", got.Content, content)
+			}
+			if first := strings.SplitN(content, "\n", 2)[1]; got.Content != first {
+				t.Errorf("Expected the cut at a line start, got %q", got.Content)
+			}
+		})
+	}
+
+	// Without a line break to cut at, escape sequences and runes stay whole
+	line := "ab\x1b[38;2;1;2;3mcd\x1b]0;title\x07é€x"
+	for max := 1; max < len(line); max++ {
+		got := Truncate(line, max)
+		if !utf8.ValidString(got.Content) || len(got.Content) > max || !strings.HasSuffix(line, got.Content) {
+			t.Errorf("max %d: split a rune or kept too much: %q", max, got.Content)
+		}
+		cut := got.OmittedBytes
+		for _, seq := range []string{"\x1b[38;2;1;2;3m", "\x1b]0;title\x07"} {
+			if i := strings.Index(line, seq); cut > i && cut < i+len(seq) {
+				t.Errorf("max %d: split an escape sequence: %q", max, got.Content)
+			}
+		}
+	}
+	if got := Truncate(line, 0); got.Truncated {
+		t.Error("Expected no limit to keep everything")
+	}
+}
@@ -0,0 +1,137 @@
+// Package testutil holds helpers shared by tests across packages
+package testutil
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/clock"
+)
+
+// FakeClock is a clock.Clock that only moves when Advance is called.
+// Tickers and After channels fire as the time passes them.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+var _ clock.Clock = (*FakeClock)(nil)
+
+// fakeWaiter is a pending After channel, or a ticker when period is set
+type fakeWaiter struct {
+	when    time.Time
+	period  time.Duration
+	c       chan time.Time
+	stopped chan struct{}
+}
+
+// NewFakeClock returns a fake clock set to start
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+// Now returns the fake time
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives the time once the clock has been
+// advanced by d
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := &fakeWaiter{when: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.c <- c.now
+		return w.c
+	}
+	c.waiters = append(c.waiters, w)
+	return w.c
+}
+
+// NewTicker returns a ticker that ticks each time the clock passes another
+// period d
+func (c *FakeClock) NewTicker(d time.Duration) clock.Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := &fakeWaiter{when: c.now.Add(d), period: d, c: make(chan time.Time), stopped: make(chan struct{})}
+	c.waiters = append(c.waiters, w)
+	return &fakeTicker{clock: c, w: w}
+}
+
+// Waiters returns how many tickers and After channels are pending, so a
+// test can wait for a goroutine to start waiting before advancing
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// Advance moves the clock forward by d, firing what falls due in time
+// order. A tick is handed over rather than buffered: Advance waits until
+// the ticker's goroutine takes it or the ticker is stopped, so by the time
+// a later tick is taken, or a later Advance returns, the goroutine has
+// finished with the earlier one.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	for {
+		sort.SliceStable(c.waiters, func(i, j int) bool { return c.waiters[i].when.Before(c.waiters[j].when) })
+		if len(c.waiters) == 0 || c.waiters[0].when.After(end) {
+			break
+		}
+		w := c.waiters[0]
+		c.now = w.when
+		if w.period == 0 {
+			c.waiters = c.waiters[1:]
+			w.c <- c.now
+			continue
+		}
+		w.when = w.when.Add(w.period)
+
+		// Let the goroutine read the clock while it takes the tick
+		now := c.now
+		c.mu.Unlock()
+		select {
+		case w.c <- now:
+		case <-w.stopped:
+		}
+		c.mu.Lock()
+	}
+	c.now = end
+	c.mu.Unlock()
+}
+
+// remove drops w from the pending waiters
+func (c *FakeClock) remove(w *fakeWaiter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, pending := range c.waiters {
+		if pending == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+type fakeTicker struct {
+	clock *FakeClock
+	w     *fakeWaiter
+	once  sync.Once
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.w.c }
+
+func (t *fakeTicker) Stop() {
+	t.once.Do(func() {
+		close(t.w.stopped)
+		t.clock.remove(t.w)
+	})
+}
@@ -0,0 +1,52 @@
+package testutil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Unix(1000, 0)
+	c := NewFakeClock(start)
+
+	after := c.After(time.Second)
+	c.Advance(999 * time.Millisecond)
+	select {
+	case <-after:
+		t.Fatal("After fired early")
+	default:
+	}
+	c.Advance(time.Millisecond)
+	if got := <-after; !got.Equal(start.Add(time.Second)) {
+		t.Errorf("Expected After to fire at %v, got %v", start.Add(time.Second), got)
+	}
+
+	// Each tick is taken before Advance returns
+	ticker := c.NewTicker(time.Minute)
+	var ticks []time.Time
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for tick := range ticker.C() {
+			ticks = append(ticks, tick)
+			if len(ticks) == 3 {
+				return
+			}
+		}
+	}()
+	c.Advance(3*time.Minute + 30*time.Second)
+	<-done
+	if len(ticks) != 3 || !ticks[2].Equal(start.Add(time.Second+3*time.Minute)) {
+		t.Errorf("Expected 3 ticks a minute apart, got %v", ticks)
+	}
+	if !c.Now().Equal(start.Add(time.Second + 3*time.Minute + 30*time.Second)) {
+		t.Errorf("Expected the clock at the end of the advance, got %v", c.Now())
+	}
+
+	// A stopped ticker no one reads doesn't hold up Advance
+	ticker.Stop()
+	if c.Waiters() != 0 {
+		t.Errorf("Expected no waiters after Stop, got %d", c.Waiters())
+	}
+	c.Advance(time.Hour)
+}
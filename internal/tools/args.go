@@ -0,0 +1,235 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Tool arguments arrive as decoded JSON, but clients are not always strict
+// about types: numbers may be sent as strings ("100"), booleans as "true",
+// and arrays as JSON-encoded strings. The helpers below coerce those loose
+// encodings to the expected type. Each returns ok=false when the argument is
+// absent or null, and an error naming the parameter when it is present but
+// cannot be converted.
+
+// GetString returns a string argument. Numbers and booleans are formatted.
+func GetString(args map[string]interface{}, name string) (string, bool, error) {
+	v, ok := args[name]
+	if !ok || v == nil {
+		return "", false, nil
+	}
+
+	switch val := v.(type) {
+	case string:
+		return val, true, nil
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), true, nil
+	case int:
+		return strconv.Itoa(val), true, nil
+	case int64:
+		return strconv.FormatInt(val, 10), true, nil
+	case json.Number:
+		return val.String(), true, nil
+	case bool:
+		return strconv.FormatBool(val), true, nil
+	}
+	return "", true, fmt.Errorf("%s must be a string, got %s", name, typeName(v))
+}
+
+// GetInt returns an integer argument. Integral floats and numeric strings are
+// accepted; fractional values are rejected rather than truncated.
+func GetInt(args map[string]interface{}, name string) (int, bool, error) {
+	v, ok := args[name]
+	if !ok || v == nil {
+		return 0, false, nil
+	}
+
+	var f float64
+	switch val := v.(type) {
+	case int:
+		return val, true, nil
+	case int64:
+		f = float64(val)
+	case float64:
+		f = val
+	case json.Number:
+		parsed, err := val.Float64()
+		if err != nil {
+			return 0, true, fmt.Errorf("%s must be an integer, got %q", name, val.String())
+		}
+		f = parsed
+	case string:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		if err != nil {
+			return 0, true, fmt.Errorf("%s must be an integer, got string %q", name, val)
+		}
+		f = parsed
+	default:
+		return 0, true, fmt.Errorf("%s must be an integer, got %s", name, typeName(v))
+	}
+
+	if math.IsNaN(f) || math.IsInf(f, 0) || f != math.Trunc(f) {
+		return 0, true, fmt.Errorf("%s must be an integer, got %v", name, v)
+	}
+	if f > math.MaxInt32 || f < math.MinInt32 {
+		return 0, true, fmt.Errorf("%s is out of range: %v", name, v)
+	}
+	return int(f), true, nil
+}
+
+// GetBool returns a boolean argument. The strings "true"/"false", "1"/"0",
+// "yes"/"no" (any case) and the numbers 1 and 0 are accepted.
+func GetBool(args map[string]interface{}, name string) (bool, bool, error) {
+	v, ok := args[name]
+	if !ok || v == nil {
+		return false, false, nil
+	}
+
+	switch val := v.(type) {
+	case bool:
+		return val, true, nil
+	case string:
+		switch strings.ToLower(strings.TrimSpace(val)) {
+		case "true", "1", "yes":
+			return true, true, nil
+		case "false", "0", "no":
+			return false, true, nil
+		}
+		return false, true, fmt.Errorf("%s must be a boolean, got string %q", name, val)
+	case float64:
+		if val == 1 || val == 0 {
+			return val == 1, true, nil
+		}
+	case int:
+		if val == 1 || val == 0 {
+			return val == 1, true, nil
+		}
+	}
+	return false, true, fmt.Errorf("%s must be a boolean, got %s", name, typeName(v))
+}
+
+// GetStringSlice returns an array-of-strings argument. Both []interface{}
+// (as decoded from JSON) and []string are accepted, as is a string holding a
+// JSON array. Elements must be strings.
+func GetStringSlice(args map[string]interface{}, name string) ([]string, bool, error) {
+	v, ok := args[name]
+	if !ok || v == nil {
+		return nil, false, nil
+	}
+
+	switch val := v.(type) {
+	case []string:
+		return val, true, nil
+	case []interface{}:
+		result := make([]string, 0, len(val))
+		for i, item := range val {
+			s, ok := item.(string)
+			if !ok {
+				return nil, true, fmt.Errorf("%s[%d] must be a string, got %s", name, i, typeName(item))
+			}
+			result = append(result, s)
+		}
+		return result, true, nil
+	case string:
+		if strings.HasPrefix(strings.TrimSpace(val), "[") {
+			var result []string
+			if err := json.Unmarshal([]byte(val), &result); err != nil {
+				return nil, true, fmt.Errorf("%s must be an array of strings: %w", name, err)
+			}
+			return result, true, nil
+		}
+	}
+	return nil, true, fmt.Errorf("%s must be an array of strings, got %s", name, typeName(v))
+}
+
+// GetObjectSlice returns an array-of-objects argument, such as a list of
+// steps. A string holding a JSON array is also accepted. Elements must be
+// objects; their fields are left for the helpers above to read.
+func GetObjectSlice(args map[string]interface{}, name string) ([]map[string]interface{}, bool, error) {
+	v, ok := args[name]
+	if !ok || v == nil {
+		return nil, false, nil
+	}
+
+	var items []interface{}
+	switch val := v.(type) {
+	case []map[string]interface{}:
+		return val, true, nil
+	case []interface{}:
+		items = val
+	case string:
+		if !strings.HasPrefix(strings.TrimSpace(val), "[") {
+			return nil, true, fmt.Errorf("%s must be an array of objects, got %s", name, typeName(v))
+		}
+		if err := json.Unmarshal([]byte(val), &items); err != nil {
+			return nil, true, fmt.Errorf("%s must be an array of objects: %w", name, err)
+		}
+	default:
+		return nil, true, fmt.Errorf("%s must be an array of objects, got %s", name, typeName(v))
+	}
+
+	result := make([]map[string]interface{}, 0, len(items))
+	for i, item := range items {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, true, fmt.Errorf("%s[%d] must be an object, got %s", name, i, typeName(item))
+		}
+		result = append(result, obj)
+	}
+	return result, true, nil
+}
+
+// GetStringMap returns an object argument whose values are all strings, such
+// as an environment. A string holding a JSON object is also accepted.
+func GetStringMap(args map[string]interface{}, name string) (map[string]string, bool, error) {
+	v, ok := args[name]
+	if !ok || v == nil {
+		return nil, false, nil
+	}
+
+	switch val := v.(type) {
+	case map[string]string:
+		return val, true, nil
+	case map[string]interface{}:
+		result := make(map[string]string, len(val))
+		for k, item := range val {
+			s, ok := item.(string)
+			if !ok {
+				return nil, true, fmt.Errorf("%s.%s must be a string, got %s", name, k, typeName(item))
+			}
+			result[k] = s
+		}
+		return result, true, nil
+	case string:
+		if strings.HasPrefix(strings.TrimSpace(val), "{") {
+			var result map[string]string
+			if err := json.Unmarshal([]byte(val), &result); err != nil {
+				return nil, true, fmt.Errorf("%s must be an object of strings: %w", name, err)
+			}
+			return result, true, nil
+		}
+	}
+	return nil, true, fmt.Errorf("%s must be an object of strings, got %s", name, typeName(v))
+}
+
+// typeName describes a decoded argument value in JSON terms
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64, int, int64, json.Number:
+		return "number"
+	case []interface{}, []string:
+		return "array"
+	case map[string]interface{}, map[string]string:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,224 @@
+package tools
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGetString(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    string
+		wantOK  bool
+		wantErr string
+	}{
+		{"absent", nil, "", false, ""},
+		{"string", "hello", "hello", true, ""},
+		{"integral float", float64(42), "42", true, ""},
+		{"fractional float", 1.5, "1.5", true, ""},
+		{"int", 7, "7", true, ""},
+		{"bool", true, "true", true, ""},
+		{"array", []interface{}{"a"}, "", true, "value must be a string, got array"},
+		{"object", map[string]interface{}{}, "", true, "value must be a string, got object"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := map[string]interface{}{}
+			if tt.value != nil {
+				args["value"] = tt.value
+			}
+			got, ok, err := GetString(args, "value")
+			checkArgResult(t, got, ok, err, tt.want, tt.wantOK, tt.wantErr)
+		})
+	}
+}
+
+func TestGetInt(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    int
+		wantOK  bool
+		wantErr string
+	}{
+		{"absent", nil, 0, false, ""},
+		{"float", float64(100), 100, true, ""},
+		{"int", 80, 80, true, ""},
+		{"int64", int64(24), 24, true, ""},
+		{"numeric string", "100", 100, true, ""},
+		{"padded string", " 42 ", 42, true, ""},
+		{"float string", "30.0", 30, true, ""},
+		{"negative", float64(-3), -3, true, ""},
+		{"fractional float", 10.5, 0, true, "value must be an integer, got 10.5"},
+		{"fractional string", "10.5", 0, true, "value must be an integer, got 10.5"},
+		{"non-numeric string", "wide", 0, true, `value must be an integer, got string "wide"`},
+		{"bool", true, 0, true, "value must be an integer, got boolean"},
+		{"array", []interface{}{1}, 0, true, "value must be an integer, got array"},
+		{"too large", float64(1e12), 0, true, "value is out of range"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := map[string]interface{}{}
+			if tt.value != nil {
+				args["value"] = tt.value
+			}
+			got, ok, err := GetInt(args, "value")
+			checkArgResult(t, got, ok, err, tt.want, tt.wantOK, tt.wantErr)
+		})
+	}
+}
+
+func TestGetBool(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    bool
+		wantOK  bool
+		wantErr string
+	}{
+		{"absent", nil, false, false, ""},
+		{"true", true, true, true, ""},
+		{"false", false, false, true, ""},
+		{"string true", "true", true, true, ""},
+		{"string TRUE", "TRUE", true, true, ""},
+		{"string false", "false", false, true, ""},
+		{"string 1", "1", true, true, ""},
+		{"string no", "no", false, true, ""},
+		{"number 1", float64(1), true, true, ""},
+		{"number 0", float64(0), false, true, ""},
+		{"int 1", 1, true, true, ""},
+		{"number 2", float64(2), false, true, "value must be a boolean, got number"},
+		{"bad string", "maybe", false, true, `value must be a boolean, got string "maybe"`},
+		{"object", map[string]interface{}{}, false, true, "value must be a boolean, got object"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := map[string]interface{}{}
+			if tt.value != nil {
+				args["value"] = tt.value
+			}
+			got, ok, err := GetBool(args, "value")
+			checkArgResult(t, got, ok, err, tt.want, tt.wantOK, tt.wantErr)
+		})
+	}
+}
+
+func TestGetStringSlice(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    []string
+		wantOK  bool
+		wantErr string
+	}{
+		{"absent", nil, nil, false, ""},
+		{"interface slice", []interface{}{"-c", "echo"}, []string{"-c", "echo"}, true, ""},
+		{"string slice", []string{"-n", "5"}, []string{"-n", "5"}, true, ""},
+		{"empty", []interface{}{}, []string{}, true, ""},
+		{"json string", `["a", "b"]`, []string{"a", "b"}, true, ""},
+		{"non-string element", []interface{}{"a", float64(1)}, nil, true, "value[1] must be a string, got number"},
+		{"bare string", "a b", nil, true, "value must be an array of strings, got string"},
+		{"bad json", `["a",`, nil, true, "value must be an array of strings"},
+		{"number", float64(3), nil, true, "value must be an array of strings, got number"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := map[string]interface{}{}
+			if tt.value != nil {
+				args["value"] = tt.value
+			}
+			got, ok, err := GetStringSlice(args, "value")
+			checkArgResult(t, got, ok, err, tt.want, tt.wantOK, tt.wantErr)
+		})
+	}
+}
+
+func TestGetObjectSlice(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    []map[string]interface{}
+		wantOK  bool
+		wantErr string
+	}{
+		{"absent", nil, nil, false, ""},
+		{"interface slice", []interface{}{map[string]interface{}{"send": "ls"}}, []map[string]interface{}{{"send": "ls"}}, true, ""},
+		{"empty", []interface{}{}, []map[string]interface{}{}, true, ""},
+		{"json string", `[{"sleep_ms": 10}]`, []map[string]interface{}{{"sleep_ms": float64(10)}}, true, ""},
+		{"non-object element", []interface{}{map[string]interface{}{}, "ls"}, nil, true, "value[1] must be an object, got string"},
+		{"bare string", "ls", nil, true, "value must be an array of objects, got string"},
+		{"bad json", `[{`, nil, true, "value must be an array of objects"},
+		{"object", map[string]interface{}{}, nil, true, "value must be an array of objects, got object"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := map[string]interface{}{}
+			if tt.value != nil {
+				args["value"] = tt.value
+			}
+			got, ok, err := GetObjectSlice(args, "value")
+			checkArgResult(t, got, ok, err, tt.want, tt.wantOK, tt.wantErr)
+		})
+	}
+}
+
+func TestGetStringMap(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    map[string]string
+		wantOK  bool
+		wantErr string
+	}{
+		{"absent", nil, nil, false, ""},
+		{"object", map[string]interface{}{"A": "1"}, map[string]string{"A": "1"}, true, ""},
+		{"string map", map[string]string{"B": "2"}, map[string]string{"B": "2"}, true, ""},
+		{"json string", `{"C": "3"}`, map[string]string{"C": "3"}, true, ""},
+		{"non-string value", map[string]interface{}{"A": float64(1)}, nil, true, "value.A must be a string, got number"},
+		{"array", []interface{}{}, nil, true, "value must be an object of strings, got array"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := map[string]interface{}{}
+			if tt.value != nil {
+				args["value"] = tt.value
+			}
+			got, ok, err := GetStringMap(args, "value")
+			checkArgResult(t, got, ok, err, tt.want, tt.wantOK, tt.wantErr)
+		})
+	}
+}
+
+func TestGetArgs_ExplicitNull(t *testing.T) {
+	args := map[string]interface{}{"value": nil}
+	if _, ok, err := GetInt(args, "value"); ok || err != nil {
+		t.Errorf("Expected null to be treated as absent, got ok=%v err=%v", ok, err)
+	}
+}
+
+func checkArgResult(t *testing.T, got interface{}, ok bool, err error, want interface{}, wantOK bool, wantErr string) {
+	t.Helper()
+	if ok != wantOK {
+		t.Errorf("ok = %v, want %v", ok, wantOK)
+	}
+	if wantErr != "" {
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("error = %v, want containing %q", err, wantErr)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
@@ -0,0 +1,171 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/session"
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// maxBroadcastSessions bounds the session_ids one broadcast_keys call takes
+const maxBroadcastSessions = 100
+
+// broadcastTargets resolves the sessions a broadcast goes to: the sessions
+// named in session_ids, by ID or label, or those in group. A reference that
+// doesn't resolve becomes a failed result instead of failing the call, and a
+// session named twice is written to once.
+func (h *Handlers) broadcastTargets(refs []string, group string) ([]*session.Session, []BroadcastResult) {
+	var targets []*session.Session
+	var failed []BroadcastResult
+	if group != "" {
+		for _, info := range h.sessionManager.ListGroupSessions(group) {
+			if sess, err := h.sessionManager.GetSession(info.ID); err == nil {
+				targets = append(targets, sess)
+			}
+		}
+		return targets, failed
+	}
+
+	seen := make(map[string]bool, len(refs))
+	for _, ref := range refs {
+		var sess *session.Session
+		err := validateSessionID(ref)
+		if err == nil {
+			sess, err = h.sessionManager.ResolveSession(ref)
+		}
+		if err != nil {
+			failed = append(failed, BroadcastResult{SessionID: ref, Error: err.Error()})
+			continue
+		}
+		if !seen[sess.ID] {
+			seen[sess.ID] = true
+			targets = append(targets, sess)
+		}
+	}
+	return targets, failed
+}
+
+// BroadcastKeys sends the same keys to several sessions at once, such as
+// replicas of one application whose screens are then compared. One
+// session's failure doesn't stop the others.
+func (h *Handlers) BroadcastKeys(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	refs, hasRefs, err := GetStringSlice(args, "session_ids")
+	if err != nil {
+		return nil, invalidParam(ctx, "broadcast_keys", err)
+	}
+	group, _, err := GetString(args, "group")
+	if err != nil {
+		return nil, invalidParam(ctx, "broadcast_keys", err)
+	}
+	switch {
+	case hasRefs && group != "":
+		return nil, invalidParam(ctx, "broadcast_keys", fmt.Errorf("session_ids and group can't be combined"))
+	case group != "":
+		if err := validateGroup(group); err != nil {
+			return nil, invalidParam(ctx, "broadcast_keys", err)
+		}
+	case len(refs) == 0:
+		return nil, invalidParam(ctx, "broadcast_keys", fmt.Errorf("session_ids or group is required"))
+	case len(refs) > maxBroadcastSessions:
+		return nil, invalidParam(ctx, "broadcast_keys", fmt.Errorf("session_ids can name at most %d sessions", maxBroadcastSessions))
+	}
+	keys, hasKeys, err := GetString(args, "keys")
+	if err != nil {
+		return nil, invalidParam(ctx, "broadcast_keys", err)
+	}
+	if !hasKeys {
+		return nil, invalidParam(ctx, "broadcast_keys", fmt.Errorf("keys parameter is required"))
+	}
+	if err := validateKeys(keys, h.maxInput); err != nil {
+		return nil, invalidParam(ctx, "broadcast_keys", err)
+	}
+	if _, _, err := GetBool(args, "wait"); err != nil {
+		return nil, invalidParam(ctx, "broadcast_keys", err)
+	}
+
+	targets, results := h.broadcastTargets(refs, group)
+
+	utils.LogToolCall(ctx, "broadcast_keys", "",
+		slog.Int("sessions", len(targets)),
+		slog.String("group", group),
+		slog.Int("key_count", len(keys)),
+	)
+
+	// Keys are mapped once per key profile and input mode in use, normally
+	// once for all
+	type encoding struct {
+		profile string
+		modes   terminal.InputModes
+	}
+	mapped := map[encoding]string{}
+	inputs := make([]string, len(targets))
+	for i, sess := range targets {
+		enc := encoding{sess.KeyProfile(), sess.InputModes()}
+		if _, ok := mapped[enc]; !ok {
+			mapped[enc] = LookupKeyProfile(enc.profile).MapKeys(keys, enc.modes)
+		}
+		inputs[i] = mapped[enc]
+	}
+
+	sent := make([]BroadcastResult, len(targets))
+	var wg sync.WaitGroup
+	for i, sess := range targets {
+		wg.Add(1)
+		go func(i int, sess *session.Session) {
+			defer wg.Done()
+			sent[i] = h.broadcastTo(ctx, sess, inputs[i], args)
+		}(i, sess)
+	}
+	wg.Wait()
+	results = append(sent, results...)
+
+	response := BroadcastKeysResponse{Results: results}
+	for _, result := range results {
+		if result.Success {
+			response.Succeeded++
+		} else {
+			response.Failed++
+		}
+	}
+	response.Success = response.Failed == 0
+	return jsonResult(response)
+}
+
+// broadcastTo writes one session's share of a broadcast
+func (h *Handlers) broadcastTo(ctx context.Context, sess *session.Session, input string, args map[string]interface{}) BroadcastResult {
+	result := BroadcastResult{SessionID: sess.ID}
+
+	opCtx, done, err := beginOperation(ctx, "broadcast_keys", sess, session.OpShared, args)
+	if err != nil {
+		result.Error = err.Error()
+		var busy *session.BusyError
+		if errors.As(err, &busy) {
+			result.Code = sessionBusyCode
+		}
+		return result
+	}
+	defer done()
+
+	written, err := sess.SendKeys(opCtx, input)
+	result.BytesWritten = written
+	if err != nil {
+		utils.LogErrorContext(ctx, err, "Failed to broadcast keys",
+			slog.String("tool", "broadcast_keys"),
+			slog.String("session_id", sess.ID),
+		)
+		result.Error = err.Error()
+		if errors.Is(err, terminal.ErrInputBlocked) {
+			result.Code = inputBlockedCode
+		}
+		return result
+	}
+	result.Success = true
+	return result
+}
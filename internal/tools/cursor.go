@@ -0,0 +1,128 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// cursorTimeoutCode marks a wait_for_cursor call whose cursor didn't come
+// to rest where asked in time
+const cursorTimeoutCode = "cursor_timeout"
+
+const (
+	defaultCursorStableMs = 100
+
+	// cursorPoll is how often wait_for_cursor reads the cursor. Moving the
+	// cursor doesn't count as a screen change, so there is nothing to be
+	// notified of; reading it is a lock and three fields.
+	cursorPoll = 10 * time.Millisecond
+)
+
+// WaitForCursor waits until the cursor is at the requested row and column,
+// and shown or hidden if asked, and has stayed there for stable_ms. A row or
+// column left out matches any.
+func (h *Handlers) WaitForCursor(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "wait_for_cursor", args)
+	if err != nil {
+		return nil, err
+	}
+
+	width, height := sess.GetScreenSize()
+	row, hasRow, err := GetInt(args, "row")
+	if err != nil {
+		return nil, invalidParam(ctx, "wait_for_cursor", err)
+	}
+	if hasRow && (row < 0 || row >= height) {
+		return nil, invalidParam(ctx, "wait_for_cursor", fmt.Errorf("row must be between 0 and %d", height-1))
+	}
+	col, hasCol, err := GetInt(args, "col")
+	if err != nil {
+		return nil, invalidParam(ctx, "wait_for_cursor", err)
+	}
+	if hasCol && (col < 0 || col >= width) {
+		return nil, invalidParam(ctx, "wait_for_cursor", fmt.Errorf("col must be between 0 and %d", width-1))
+	}
+	visible, hasVisible, err := GetBool(args, "visible")
+	if err != nil {
+		return nil, invalidParam(ctx, "wait_for_cursor", err)
+	}
+	stableMs, hasStable, err := GetInt(args, "stable_ms")
+	if err != nil {
+		return nil, invalidParam(ctx, "wait_for_cursor", err)
+	}
+	if !hasStable {
+		stableMs = defaultCursorStableMs
+	}
+	if stableMs < 0 || stableMs > maxStableMs {
+		return nil, invalidParam(ctx, "wait_for_cursor", fmt.Errorf("stable_ms must be between 0 and %d", maxStableMs))
+	}
+	timeoutMs, hasTimeout, err := GetInt(args, "timeout_ms")
+	if err != nil {
+		return nil, invalidParam(ctx, "wait_for_cursor", err)
+	}
+	if !hasTimeout {
+		timeoutMs = defaultWaitMs
+	}
+	if timeoutMs < 1 || timeoutMs > maxWaitMs {
+		return nil, invalidParam(ctx, "wait_for_cursor", fmt.Errorf("timeout_ms must be between 1 and %d", maxWaitMs))
+	}
+
+	utils.LogToolCall(ctx, "wait_for_cursor", sess.ID,
+		slog.Int("stable_ms", stableMs),
+		slog.Int("timeout_ms", timeoutMs),
+	)
+
+	// The session closing or restarting ends the wait early
+	waitCtx, cancel := context.WithTimeout(ctx, time.Duration(timeoutMs)*time.Millisecond)
+	defer cancel()
+	runCtx, unbind := sess.Bind(waitCtx)
+	defer unbind()
+
+	clock := h.sessionManager.Clock()
+	start := clock.Now()
+	stable := time.Duration(stableMs) * time.Millisecond
+	var cursor CursorState
+	var since time.Time // When the cursor came to rest at a match; zero while it isn't at one
+	for {
+		x, y, shown := sess.Buffer.Cursor()
+		now := clock.Now()
+		matches := (!hasRow || y == row) && (!hasCol || x == col) && (!hasVisible || shown == visible)
+		moved := x != cursor.Col || y != cursor.Row || shown != cursor.Visible
+		cursor = CursorState{CursorPosition: CursorPosition{Row: y, Col: x}, Visible: shown}
+		switch {
+		case !matches:
+			since = time.Time{}
+		case since.IsZero() || moved:
+			since = now
+		}
+		if !since.IsZero() && now.Sub(since) >= stable {
+			return jsonResult(WaitForCursorResponse{
+				SessionID: sess.ID,
+				Cursor:    cursor,
+				WaitedMs:  now.Sub(start).Milliseconds(),
+			})
+		}
+
+		select {
+		case <-runCtx.Done():
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if waitCtx.Err() == nil {
+				return nil, context.Cause(runCtx)
+			}
+			return toolErrorResult(fmt.Errorf("cursor didn't come to rest where asked within %d ms", timeoutMs), cursorTimeoutCode, map[string]interface{}{
+				"session_id": sess.ID,
+				"cursor":     cursor,
+				"waited_ms":  h.since(start).Milliseconds(),
+			}), nil
+		case <-clock.After(cursorPoll):
+		}
+	}
+}
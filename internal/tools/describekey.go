@@ -0,0 +1,61 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/session"
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// DescribeKey returns the bytes send_keys would write for a key name under
+// the session's key profile, or another one, and its current key modes.
+// Nothing is sent.
+func (h *Handlers) DescribeKey(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "describe_key", args)
+	if err != nil {
+		return nil, err
+	}
+
+	key, hasKey, err := GetString(args, "key")
+	if err != nil {
+		return nil, invalidParam(ctx, "describe_key", err)
+	}
+	if !hasKey {
+		return nil, invalidParam(ctx, "describe_key", fmt.Errorf("key parameter is required"))
+	}
+	if err := validateKeys(key, h.maxInput); err != nil {
+		return nil, invalidParam(ctx, "describe_key", err)
+	}
+	profile, hasProfile, err := GetString(args, "profile")
+	if err != nil {
+		return nil, invalidParam(ctx, "describe_key", err)
+	}
+	if !hasProfile {
+		profile = sess.KeyProfile()
+	}
+	if profiles := session.KeyProfiles(); !slices.Contains(profiles, profile) {
+		return nil, invalidParam(ctx, "describe_key", fmt.Errorf("profile must be one of: %s", strings.Join(profiles, ", ")))
+	}
+
+	utils.LogToolCall(ctx, "describe_key", sess.ID, slog.String("profile", profile))
+
+	modes := sess.InputModes()
+	m := LookupKeyProfile(profile).mapKey(key, modes)
+	return jsonResult(DescribeKeyResponse{
+		SessionID:  sess.ID,
+		Key:        key,
+		Profile:    profile,
+		InputModes: modes,
+		Known:      m.Key,
+		Mode:       m.Mode,
+		Escaped:    EscapeBytes(m.Bytes),
+		Hex:        HexBytes(m.Bytes),
+		Bytes:      len(m.Bytes),
+	})
+}
@@ -0,0 +1,239 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/session"
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Limits for run_expect_script. Durations are in milliseconds.
+const (
+	maxScriptSteps      = 100
+	defaultExpectWaitMs = 5000
+	defaultScriptMs     = 60000
+	maxSleepMs          = 60000
+)
+
+// stepFailedCode marks a script that stopped at a step that failed
+const stepFailedCode = "step_failed"
+
+// scriptStep is one step of an expect script: wait for a pattern, send
+// keys or sleep
+type scriptStep struct {
+	kind    string // "expect", "send" or "sleep"
+	pattern *regexp.Regexp
+	keys    string
+	wait    time.Duration // How long an expect waits, or a sleep lasts
+}
+
+// parseScriptSteps validates the steps of an expect script. Each step must
+// have exactly one of expect, send and sleep_ms.
+func parseScriptSteps(raw []map[string]interface{}, maxInput int) ([]scriptStep, error) {
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("steps must not be empty")
+	}
+	if len(raw) > maxScriptSteps {
+		return nil, fmt.Errorf("steps has %d steps, at most %d are allowed", len(raw), maxScriptSteps)
+	}
+
+	steps := make([]scriptStep, 0, len(raw))
+	for i, item := range raw {
+		pattern, hasExpect, err := GetString(item, "expect")
+		if err != nil {
+			return nil, fmt.Errorf("steps[%d]: %w", i, err)
+		}
+		keys, hasSend, err := GetString(item, "send")
+		if err != nil {
+			return nil, fmt.Errorf("steps[%d]: %w", i, err)
+		}
+		sleepMs, hasSleep, err := GetInt(item, "sleep_ms")
+		if err != nil {
+			return nil, fmt.Errorf("steps[%d]: %w", i, err)
+		}
+		timeoutMs, hasTimeout, err := GetInt(item, "timeout_ms")
+		if err != nil {
+			return nil, fmt.Errorf("steps[%d]: %w", i, err)
+		}
+
+		kinds := 0
+		for _, has := range []bool{hasExpect, hasSend, hasSleep} {
+			if has {
+				kinds++
+			}
+		}
+		if kinds != 1 {
+			return nil, fmt.Errorf("steps[%d] must have exactly one of expect, send and sleep_ms", i)
+		}
+		if hasTimeout && !hasExpect {
+			return nil, fmt.Errorf("steps[%d]: timeout_ms only applies to expect steps", i)
+		}
+
+		switch {
+		case hasExpect:
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("steps[%d]: invalid expect pattern: %w", i, err)
+			}
+			if !hasTimeout {
+				timeoutMs = defaultExpectWaitMs
+			}
+			if timeoutMs < 1 || timeoutMs > maxWaitMs {
+				return nil, fmt.Errorf("steps[%d]: timeout_ms must be between 1 and %d", i, maxWaitMs)
+			}
+			steps = append(steps, scriptStep{kind: "expect", pattern: re, wait: time.Duration(timeoutMs) * time.Millisecond})
+		case hasSend:
+			if err := validateKeys(keys, maxInput); err != nil {
+				return nil, fmt.Errorf("steps[%d]: %w", i, err)
+			}
+			steps = append(steps, scriptStep{kind: "send", keys: keys})
+		default:
+			if sleepMs < 0 || sleepMs > maxSleepMs {
+				return nil, fmt.Errorf("steps[%d]: sleep_ms must be between 0 and %d", i, maxSleepMs)
+			}
+			steps = append(steps, scriptStep{kind: "sleep", wait: time.Duration(sleepMs) * time.Millisecond})
+		}
+	}
+	return steps, nil
+}
+
+// RunExpectScript runs a list of expect, send and sleep steps against a
+// session in one call, stopping at the first step that fails
+func (h *Handlers) RunExpectScript(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "run_expect_script", args)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, hasSteps, err := GetObjectSlice(args, "steps")
+	if err != nil {
+		return nil, invalidParam(ctx, "run_expect_script", err)
+	}
+	if !hasSteps {
+		return nil, invalidParam(ctx, "run_expect_script", fmt.Errorf("steps parameter is required"))
+	}
+	steps, err := parseScriptSteps(raw, h.maxInput)
+	if err != nil {
+		return nil, invalidParam(ctx, "run_expect_script", err)
+	}
+	timeoutMs, hasTimeout, err := GetInt(args, "timeout_ms")
+	if err != nil {
+		return nil, invalidParam(ctx, "run_expect_script", err)
+	}
+	if !hasTimeout {
+		timeoutMs = defaultScriptMs
+	}
+	if timeoutMs < 1 || timeoutMs > maxWaitMs {
+		return nil, invalidParam(ctx, "run_expect_script", fmt.Errorf("timeout_ms must be between 1 and %d", maxWaitMs))
+	}
+
+	utils.LogToolCall(ctx, "run_expect_script", sess.ID,
+		slog.Int("steps", len(steps)),
+		slog.Int("timeout_ms", timeoutMs),
+	)
+
+	// The script ends when its time is up or the session closes or restarts
+	scriptCtx, cancel := context.WithTimeout(ctx, time.Duration(timeoutMs)*time.Millisecond)
+	defer cancel()
+	runCtx, unbind := sess.Bind(scriptCtx)
+	defer unbind()
+
+	start := h.now()
+	results := make([]StepResult, 0, len(steps))
+	for i, step := range steps {
+		stepStart := h.now()
+		result := StepResult{Index: i, Kind: step.kind}
+		screen, err := h.runScriptStep(runCtx, sess, step, &result, args)
+		result.ElapsedMs = h.since(stepStart).Milliseconds()
+		results = append(results, result)
+		if err == nil {
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		switch {
+		case scriptCtx.Err() != nil:
+			err = fmt.Errorf("script timed out after %d ms at step %d (%s)", timeoutMs, i, step.kind)
+		case runCtx.Err() != nil:
+			err = fmt.Errorf("step %d (%s): %w", i, step.kind, context.Cause(runCtx))
+		default:
+			err = fmt.Errorf("step %d (%s): %w", i, step.kind, err)
+		}
+		if screen == "" {
+			screen, _ = sess.Buffer.Render("plain")
+		}
+		slog.DebugContext(ctx, "Expect script failed",
+			slog.String("session_id", sess.ID),
+			slog.Int("step", i),
+			slog.String("error", err.Error()),
+		)
+		return toolErrorResult(err, stepFailedCode, map[string]interface{}{
+			"session_id":  sess.ID,
+			"failed_step": i,
+			"steps":       results,
+			"screen":      screen,
+			"elapsed_ms":  h.since(start).Milliseconds(),
+		}), nil
+	}
+
+	return jsonResult(ExpectScriptResponse{
+		SessionID: sess.ID,
+		Success:   true,
+		Steps:     results,
+		ElapsedMs: h.since(start).Milliseconds(),
+	})
+}
+
+// runScriptStep runs one step, adding what it did to result. A failed
+// expect also returns the last screen it saw.
+func (h *Handlers) runScriptStep(ctx context.Context, sess *session.Session, step scriptStep, result *StepResult, args map[string]interface{}) (string, error) {
+	switch step.kind {
+	case "expect":
+		result.Pattern = step.pattern.String()
+		waitCtx, cancel := context.WithTimeout(ctx, step.wait)
+		defer cancel()
+		match, screen, version, err := sess.Buffer.WaitMatch(waitCtx, step.pattern)
+		if err != nil {
+			if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+				err = fmt.Errorf("pattern %q not found within %d ms", step.pattern.String(), step.wait.Milliseconds())
+			}
+			return screen, err
+		}
+		result.Match = &match[0]
+		result.Version = &version
+		if len(match) > 1 {
+			result.Groups = match[1:]
+		}
+		return "", nil
+
+	case "send":
+		// Map keys for the modes in effect now, as an earlier step may
+		// have changed them
+		mapped := MapSessionKeys(sess, step.keys)
+		opCtx, done, err := beginOperation(ctx, "run_expect_script", sess, session.OpShared, args)
+		if err != nil {
+			return "", err
+		}
+		defer done()
+		written, err := sess.SendKeys(opCtx, mapped)
+		result.BytesWritten = &written
+		return "", err
+
+	default:
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-h.sessionManager.Clock().After(step.wait):
+			return "", nil
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package tools
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/session"
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// maxViewFormats is the most formats one view_screen call renders
+const maxViewFormats = 4
+
+// validateFormats checks view_screen's formats parameter
+func validateFormats(formats []string) error {
+	if len(formats) == 0 || len(formats) > maxViewFormats {
+		return fmt.Errorf("formats must list between 1 and %d formats", maxViewFormats)
+	}
+	for i, format := range formats {
+		if err := validateFormat(format); err != nil {
+			return fmt.Errorf("formats[%d]: %w", i, err)
+		}
+		if slices.Contains(formats[:i], format) {
+			return fmt.Errorf("formats lists %s twice", format)
+		}
+	}
+	return nil
+}
+
+// viewScreenFormats answers view_screen for several formats, all rendered
+// from the same screen state. The plain rendering is always made, for the
+// hash, so frames compare equal across calls whatever formats they asked
+// for.
+func (h *Handlers) viewScreenFormats(ctx context.Context, sess *session.Session, formats []string, opts terminal.RenderOptions, maxBytes int) (*mcp.CallToolResult, error) {
+	render := formats
+	if !slices.Contains(formats, "plain") {
+		render = append(slices.Clone(formats), "plain")
+	}
+	frame, err := sess.GetFrame(ctx, render, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256([]byte(frame.Renders["plain"]))
+	response := ViewFormatsResponse{
+		ScreenResponse: ScreenResponse{
+			Cursor:   CursorPosition{Row: frame.CursorY, Col: frame.CursorX},
+			Degraded: sess.Buffer.Degraded(),
+		},
+		Formats:   make(map[string]json.RawMessage, len(formats)),
+		Version:   frame.Generation,
+		Hash:      hex.EncodeToString(sum[:]),
+		RawOffset: frame.RawEnd,
+	}
+	for _, format := range formats {
+		content := frame.Renders[format]
+		if format == "lines" {
+			// Already JSON; max_bytes doesn't apply, as for format lines
+			response.Formats[format] = json.RawMessage(content)
+			continue
+		}
+		cut := terminal.Truncate(content, maxBytes)
+		if cut.Truncated {
+			response.Truncated = append(response.Truncated, format)
+		}
+		encoded, err := json.Marshal(cut.Content)
+		if err != nil {
+			return nil, err
+		}
+		response.Formats[format] = encoded
+	}
+	return jsonResult(response)
+}
@@ -2,40 +2,225 @@ package tools
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/bioharz/mcp-terminal-tester/internal/analyzer"
 	"github.com/bioharz/mcp-terminal-tester/internal/session"
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
 	"github.com/bioharz/mcp-terminal-tester/internal/utils"
 	"github.com/mark3labs/mcp-go/mcp"
 )
 
 type Handlers struct {
 	sessionManager *session.Manager
+	limits         DimensionLimits
+	maxInput       int    // Bytes one send_keys or send_raw_bytes call may deliver
+	maxOutput      int    // Content bytes one view_screen call returns by default
+	defaultFormat  string // Render format when neither the call nor the session sets one
+	importDir      string   // Directory import_capture reads from; empty disables it
+	locales        []string // Locales launch_app accepts
+	keymapFile     string   // Keymap file reload_keymap reads; empty for none
 }
 
 func NewHandlers(sm *session.Manager) *Handlers {
 	return &Handlers{
 		sessionManager: sm,
+		limits:         DimensionLimitsFromEnv(),
+		maxInput:       MaxInputBytesFromEnv(),
+		maxOutput:      MaxOutputBytesFromEnv(),
+		defaultFormat:  "plain",
+		importDir:      ImportDirFromEnv(),
+		locales:        LocalesFromEnv(),
+		keymapFile:     KeymapFileFromEnv(),
 	}
 }
 
+// now and since tell the time by the session manager's clock, so waits and
+// elapsed times follow a fake clock in tests
+func (h *Handlers) now() time.Time {
+	return h.sessionManager.Clock().Now()
+}
+
+func (h *Handlers) since(t time.Time) time.Duration {
+	return h.now().Sub(t)
+}
+
+// SetDefaultFormat sets the server-wide default render format
+func (h *Handlers) SetDefaultFormat(format string) error {
+	if err := validateFormat(format); err != nil {
+		return err
+	}
+	h.defaultFormat = format
+	return nil
+}
+
+// effectiveFormat returns the format to render with when a call doesn't
+// specify one: the session's default, then the server's
+func (h *Handlers) effectiveFormat(sess *session.Session) string {
+	if format := sess.StringOption(session.OptionDefaultFormat); format != "" {
+		return format
+	}
+	return h.defaultFormat
+}
+
+// LoadKeymapFile loads the keymap file named by MCP_KEYMAP_FILE, if any
+func (h *Handlers) LoadKeymapFile() error {
+	if h.keymapFile == "" {
+		return nil
+	}
+	_, err := LoadKeymap(h.keymapFile)
+	return err
+}
+
+// Limits returns the terminal size limits enforced by the handlers
+func (h *Handlers) Limits() DimensionLimits {
+	return h.limits
+}
+
+// MaxInputBytes returns the input limit for one send_keys or send_raw_bytes
+// call
+func (h *Handlers) MaxInputBytes() int {
+	return h.maxInput
+}
+
+// MaxOutputBytes returns the content limit for a view_screen call that
+// doesn't set max_bytes
+func (h *Handlers) MaxOutputBytes() int {
+	return h.maxOutput
+}
+
 // Input validation functions
+
+// validateSessionID checks that a session reference is well formed. A
+// reference is either a session UUID (any letter case) or a session label.
 func validateSessionID(sessionID string) error {
 	if sessionID == "" {
 		return fmt.Errorf("session_id parameter is required")
 	}
-	// Basic UUID format validation
-	uuidRegex := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
-	if !uuidRegex.MatchString(sessionID) {
-		return fmt.Errorf("session_id must be a valid UUID")
+	if len(sessionID) > 100 {
+		return fmt.Errorf("session_id is malformed: exceeds maximum length (100 characters)")
+	}
+	for _, r := range sessionID {
+		if r < 0x20 || r == 0x7f {
+			return fmt.Errorf("session_id is malformed: contains control characters")
+		}
 	}
 	return nil
 }
 
+// invalidParam logs a rejected tool argument and returns the error
+func invalidParam(ctx context.Context, tool string, err error) error {
+	slog.ErrorContext(ctx, "Invalid tool call",
+		slog.String("tool", tool),
+		slog.String("error", err.Error()),
+	)
+	return err
+}
+
+// inputBlockedCode marks a send that timed out because the process stopped
+// reading its input
+const inputBlockedCode = "input_not_consumed"
+
+// toolErrorResult reports a failure as a tool error rather than a protocol
+// error, so the agent gets a code to act on and any extra details
+func toolErrorResult(err error, code string, details map[string]interface{}) *mcp.CallToolResult {
+	response := map[string]interface{}{
+		"error": err.Error(),
+		"code":  code,
+	}
+	for k, v := range details {
+		response[k] = v
+	}
+	jsonData, _ := json.Marshal(response)
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: string(jsonData),
+			},
+		},
+		IsError: true,
+	}
+}
+
+// inputBlockedResult reports a blocked send with how much was delivered, so
+// the agent can react, e.g. by sending Ctrl+C before retrying
+func inputBlockedResult(err error, written int) *mcp.CallToolResult {
+	return toolErrorResult(err, inputBlockedCode, map[string]interface{}{
+		"bytes_written": written,
+	})
+}
+
+// sessionBusyCode marks a call refused because conflicting operations kept
+// the session busy
+const sessionBusyCode = "session_busy"
+
+// beginOperation starts a gated operation on the session, queueing behind
+// conflicting ones unless the optional wait argument is false
+func beginOperation(ctx context.Context, tool string, sess *session.Session, kind session.OpKind, args map[string]interface{}) (context.Context, func(), error) {
+	wait, hasWait, err := GetBool(args, "wait")
+	if err != nil {
+		return nil, nil, invalidParam(ctx, tool, err)
+	}
+	if !hasWait {
+		wait = true
+	}
+	return sess.Begin(ctx, tool, kind, wait)
+}
+
+// operationError reports a failure to begin an operation, as a tool error
+// when the session was busy so the agent can retry later
+func operationError(ctx context.Context, tool string, err error) (*mcp.CallToolResult, error) {
+	var busy *session.BusyError
+	if !errors.As(err, &busy) {
+		return nil, err
+	}
+	slog.WarnContext(ctx, "Session busy",
+		slog.String("tool", tool),
+		slog.String("holder", busy.Holder),
+		slog.Int("shared", busy.Shared),
+	)
+	return toolErrorResult(err, sessionBusyCode, map[string]interface{}{
+		"holder": busy.Holder,
+		"shared": busy.Shared,
+	}), nil
+}
+
+// resolveSession extracts the session_id argument, validates it and looks up
+// the session by ID or, failing that, by label
+func (h *Handlers) resolveSession(ctx context.Context, tool string, args map[string]interface{}) (*session.Session, error) {
+	sessionID, _, err := GetString(args, "session_id")
+	if err != nil {
+		return nil, invalidParam(ctx, tool, err)
+	}
+
+	// Validate session ID
+	if err := validateSessionID(sessionID); err != nil {
+		slog.ErrorContext(ctx, "Invalid session ID",
+			slog.String("tool", tool),
+			slog.String("session_id", sessionID),
+			slog.String("error", err.Error()),
+		)
+		return nil, err
+	}
+
+	return h.sessionManager.ResolveSession(sessionID)
+}
+
 func validateCommand(command string) error {
 	if command == "" {
 		return fmt.Errorf("command parameter is required")
@@ -77,56 +262,152 @@ func validateEnvironment(env map[string]string) error {
 			return fmt.Errorf("environment key '%s' contains invalid characters", key)
 		}
 	}
-	return nil
+	return session.ValidateEnvTemplates(env)
 }
 
-func validateKeys(keys string) error {
+func validateKeys(keys string, maxBytes int) error {
 	if keys == "" {
 		return fmt.Errorf("keys parameter is required")
 	}
-	if len(keys) > 10000 {
-		return fmt.Errorf("keys parameter exceeds maximum length (10000 characters)")
+	if len(keys) > maxBytes {
+		return fmt.Errorf("keys parameter is %d bytes, over the input limit of %d bytes (MCP_MAX_INPUT_BYTES)", len(keys), maxBytes)
 	}
 	return nil
 }
 
+// Size limits for raw output export
+const (
+	defaultExportSize = 64 * 1024
+	maxExportSize     = 1024 * 1024
+)
+
+// Window sizes for view_history, in lines
+const (
+	defaultHistoryLines = 50
+	maxHistoryLines     = 1000
+)
+
+// Limits for get_session_logs
+const (
+	defaultLogLimit = 100
+	maxLogLimit     = 10000
+)
+
+// Limits for get_session_events
+const (
+	defaultEventLimit = 100
+	maxEventLimit     = 1000
+)
+
+// Limits for list_recent_activity
+const (
+	defaultActivityLimit = 100
+	maxActivityLimit     = 1000
+)
+
+// Quiet time is_ready_for_input needs by default, in milliseconds
+const defaultReadyIdleMs = 200
+
+// Durations for wait_for_stable_screen, in milliseconds
+const (
+	defaultStableMs = 500
+	maxStableMs     = 60000
+	defaultWaitMs   = 10000
+	maxWaitMs       = 300000
+)
+
 func validateFormat(format string) error {
-	validFormats := []string{"plain", "raw", "ansi", "scrollback", "passthrough"}
-	for _, valid := range validFormats {
+	for _, valid := range terminal.RenderFormats {
 		if format == valid {
 			return nil
 		}
 	}
-	return fmt.Errorf("format must be one of: %s", strings.Join(validFormats, ", "))
+	return fmt.Errorf("format must be one of: %s", strings.Join(terminal.RenderFormats, ", "))
+}
+
+// debugRenderOptions applies view_screen's debug format parameters to the
+// session's render options, and reports whether any were given
+func debugRenderOptions(args map[string]interface{}, opts terminal.RenderOptions) (terminal.RenderOptions, bool, error) {
+	given := false
+	for _, p := range []struct {
+		name   string
+		marker *rune
+	}{
+		{"cursor_marker", &opts.CursorMarker},
+		{"style_marker", &opts.StyleMarker},
+	} {
+		value, ok, err := GetString(args, p.name)
+		if err != nil {
+			return opts, false, err
+		}
+		if !ok {
+			continue
+		}
+		given = true
+		if value == "" {
+			*p.marker = 0
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(value)
+		if size != len(value) || r == utf8.RuneError || !unicode.IsPrint(r) {
+			return opts, false, fmt.Errorf("%s must be one printable character", p.name)
+		}
+		*p.marker = r
+	}
+	dots, ok, err := GetBool(args, "dot_spaces")
+	if err != nil {
+		return opts, false, err
+	}
+	if ok {
+		given = true
+		opts.KeepSpaces = !dots
+	}
+	rulers, ok, err := GetBool(args, "rulers")
+	if err != nil {
+		return opts, false, err
+	}
+	if ok {
+		given = true
+		opts.Rulers = rulers
+	}
+	return opts, given, nil
+}
+
+func validateGroup(group string) error {
+	if group == "" {
+		return fmt.Errorf("group parameter is required")
+	}
+	groupRegex := regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+	if !groupRegex.MatchString(group) {
+		return fmt.Errorf("group must be 1-64 characters of letters, digits, '.', '_' or '-'")
+	}
+	return nil
 }
 
-func validateDimensions(width, height float64) error {
-	if width < 1 || width > 1000 {
-		return fmt.Errorf("width must be between 1 and 1000")
+func validateLabel(label string) error {
+	if len(label) > 100 {
+		return fmt.Errorf("label exceeds maximum length (100 characters)")
 	}
-	if height < 1 || height > 1000 {
-		return fmt.Errorf("height must be between 1 and 1000")
+	for _, r := range label {
+		if r < 0x20 || r == 0x7f {
+			return fmt.Errorf("label contains control characters")
+		}
 	}
 	return nil
 }
 
 func (h *Handlers) LaunchApp(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	utils.LogToolCall("launch_app", "")
+	utils.LogToolCall(ctx, "launch_app", "")
 	
 	args := request.GetArguments()
-	command, ok := args["command"].(string)
-	if !ok {
-		err := fmt.Errorf("command parameter is required")
-		slog.Error("Invalid tool call", 
-			slog.String("tool", "launch_app"),
-			slog.String("error", err.Error()),
-		)
-		return nil, err
+	command, _, err := GetString(args, "command")
+	if err != nil {
+		return nil, invalidParam(ctx, "launch_app", err)
 	}
 	
 	// Validate command
 	if err := validateCommand(command); err != nil {
-		slog.Error("Invalid command", 
+		slog.ErrorContext(ctx, "Invalid command", 
 			slog.String("tool", "launch_app"),
 			slog.String("command", command),
 			slog.String("error", err.Error()),
@@ -135,28 +416,20 @@ func (h *Handlers) LaunchApp(ctx context.Context, request mcp.CallToolRequest) (
 	}
 
 	// Extract args if provided
-	var cmdArgs []string
-	if argsParam, exists := args["args"]; exists {
-		// Try []interface{} first
-		if argsArray, ok := argsParam.([]interface{}); ok {
-			for _, arg := range argsArray {
-				if argStr, ok := arg.(string); ok {
-					cmdArgs = append(cmdArgs, argStr)
-				}
-			}
-		} else if argsArray, ok := argsParam.([]string); ok {
-			// Also try []string directly
-			cmdArgs = argsArray
-		}
-		slog.Debug("Extracted args", 
+	cmdArgs, hasArgs, err := GetStringSlice(args, "args")
+	if err != nil {
+		return nil, invalidParam(ctx, "launch_app", err)
+	}
+	if hasArgs {
+		slog.DebugContext(ctx, "Extracted args", 
 			slog.String("tool", "launch_app"),
 			slog.Any("args", cmdArgs),
-			slog.Any("raw_args", argsParam),
+			slog.Any("raw_args", args["args"]),
 		)
 		
 		// Validate arguments
 		if err := validateArguments(cmdArgs); err != nil {
-			slog.Error("Invalid arguments", 
+			slog.ErrorContext(ctx, "Invalid arguments", 
 				slog.String("tool", "launch_app"),
 				slog.Any("args", cmdArgs),
 				slog.String("error", err.Error()),
@@ -166,19 +439,17 @@ func (h *Handlers) LaunchApp(ctx context.Context, request mcp.CallToolRequest) (
 	}
 
 	// Extract env if provided
-	env := make(map[string]string)
-	if envParam, exists := args["env"]; exists {
-		if envMap, ok := envParam.(map[string]interface{}); ok {
-			for k, v := range envMap {
-				if vStr, ok := v.(string); ok {
-					env[k] = vStr
-				}
-			}
-		}
-		
+	env, hasEnv, err := GetStringMap(args, "env")
+	if err != nil {
+		return nil, invalidParam(ctx, "launch_app", err)
+	}
+	if env == nil {
+		env = make(map[string]string)
+	}
+	if hasEnv {
 		// Validate environment
 		if err := validateEnvironment(env); err != nil {
-			slog.Error("Invalid environment", 
+			slog.ErrorContext(ctx, "Invalid environment", 
 				slog.String("tool", "launch_app"),
 				slog.Any("env", env),
 				slog.String("error", err.Error()),
@@ -187,471 +458,2031 @@ func (h *Handlers) LaunchApp(ctx context.Context, request mcp.CallToolRequest) (
 		}
 	}
 
-	// Create new session
-	sess, err := h.sessionManager.CreateSession(command, cmdArgs, env)
+	// Extract group if provided
+	group, _, err := GetString(args, "group")
 	if err != nil {
-		utils.LogError(err, "Failed to launch app",
-			slog.String("tool", "launch_app"),
-			slog.String("command", command),
-		)
-		return nil, fmt.Errorf("failed to launch app: %w", err)
+		return nil, invalidParam(ctx, "launch_app", err)
+	}
+	if group != "" {
+		if err := validateGroup(group); err != nil {
+			slog.ErrorContext(ctx, "Invalid group",
+				slog.String("tool", "launch_app"),
+				slog.String("group", group),
+				slog.String("error", err.Error()),
+			)
+			return nil, err
+		}
 	}
 
-	slog.Info("App launched successfully",
-		slog.String("tool", "launch_app"),
-		slog.String("session_id", sess.ID),
-		slog.String("command", command),
-	)
-
-	return &mcp.CallToolResult{
-		Content: []mcp.Content{
-			mcp.TextContent{
-				Type: "text",
-				Text: fmt.Sprintf(`{"session_id": "%s", "success": true}`, sess.ID),
-			},
-		},
-	}, nil
-}
-
-func (h *Handlers) ViewScreen(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	args := request.GetArguments()
-	sessionID, ok := args["session_id"].(string)
-	if !ok {
-		err := fmt.Errorf("session_id parameter is required")
-		slog.Error("Invalid tool call",
-			slog.String("tool", "view_screen"),
-			slog.String("error", err.Error()),
-		)
-		return nil, err
+	// Extract label if provided
+	label, _, err := GetString(args, "label")
+	if err != nil {
+		return nil, invalidParam(ctx, "launch_app", err)
 	}
-	
-	// Validate session ID
-	if err := validateSessionID(sessionID); err != nil {
-		slog.Error("Invalid session ID",
-			slog.String("tool", "view_screen"),
-			slog.String("session_id", sessionID),
+	if err := validateLabel(label); err != nil {
+		slog.ErrorContext(ctx, "Invalid label",
+			slog.String("tool", "launch_app"),
+			slog.String("label", label),
 			slog.String("error", err.Error()),
 		)
 		return nil, err
 	}
-	
-	utils.LogToolCall("view_screen", sessionID)
 
-	format := "plain"
-	if formatParam, exists := args["format"]; exists {
-		if f, ok := formatParam.(string); ok {
-			format = f
+	shell, _, err := GetBool(args, "shell")
+	if err != nil {
+		return nil, invalidParam(ctx, "launch_app", err)
+	}
+	separateStderr, _, err := GetBool(args, "separate_stderr")
+	if err != nil {
+		return nil, invalidParam(ctx, "launch_app", err)
+	}
+
+	locale, _, err := GetString(args, "locale")
+	if err != nil {
+		return nil, invalidParam(ctx, "launch_app", err)
+	}
+	if locale != "" {
+		if err := h.validateLocale(locale, env); err != nil {
+			return nil, invalidParam(ctx, "launch_app", err)
 		}
 	}
-	
-	// Validate format
-	if err := validateFormat(format); err != nil {
-		slog.Error("Invalid format",
-			slog.String("tool", "view_screen"),
-			slog.String("format", format),
+	timezone, _, err := GetString(args, "timezone")
+	if err != nil {
+		return nil, invalidParam(ctx, "launch_app", err)
+	}
+	if timezone != "" {
+		if err := validateTimezone(timezone, env); err != nil {
+			return nil, invalidParam(ctx, "launch_app", err)
+		}
+	}
+
+	// Extract session options if provided
+	options, err := launchOptions(args)
+	if err != nil {
+		slog.ErrorContext(ctx, "Invalid session options",
+			slog.String("tool", "launch_app"),
 			slog.String("error", err.Error()),
 		)
 		return nil, err
 	}
 
-	sess, err := h.sessionManager.GetSession(sessionID)
+	// Extract terminal size if provided; 0 means the default 80x24
+	width, hasWidth, err := GetInt(args, "width")
 	if err != nil {
-		return nil, err
+		return nil, invalidParam(ctx, "launch_app", err)
 	}
-
-	content, err := sess.GetScreen(format)
+	height, hasHeight, err := GetInt(args, "height")
 	if err != nil {
-		return nil, err
+		return nil, invalidParam(ctx, "launch_app", err)
+	}
+	if hasWidth || hasHeight {
+		checkWidth, checkHeight := width, height
+		if !hasWidth {
+			checkWidth = MinDimension
+		}
+		if !hasHeight {
+			checkHeight = MinDimension
+		}
+		if err := h.limits.Validate(checkWidth, checkHeight); err != nil {
+			slog.ErrorContext(ctx, "Invalid dimensions",
+				slog.String("tool", "launch_app"),
+				slog.Int("width", width),
+				slog.Int("height", height),
+				slog.String("error", err.Error()),
+			)
+			return nil, err
+		}
 	}
 
-	row, col := sess.GetCursorPosition()
-
-	// Create response object and marshal to JSON properly
-	response := map[string]interface{}{
-		"content": content,
-		"cursor": map[string]interface{}{
-			"row": row,
-			"col": col,
-		},
+	probe, err := parseReadyProbe(args)
+	if err != nil {
+		return nil, invalidParam(ctx, "launch_app", err)
 	}
-	
-	respData, err := json.Marshal(response)
+
+	// Hand out a pre-warmed session when requested and the pool can serve it
+	usePool, _, err := GetBool(args, "pooled")
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal response: %w", err)
+		return nil, invalidParam(ctx, "launch_app", err)
+	}
+	pooled := false
+	var sess *session.Session
+	if usePool && group == "" && label == "" && !shell && !separateStderr && locale == "" && timezone == "" && !hasWidth && !hasHeight && h.sessionManager.PoolMatches(command, cmdArgs, env) {
+		sess, err = h.sessionManager.AcquirePooledSession()
+		if err != nil {
+			slog.DebugContext(ctx, "Pooled session unavailable, launching normally",
+				slog.String("tool", "launch_app"),
+				slog.String("reason", err.Error()),
+			)
+		} else {
+			pooled = true
+			// Already validated, so this can't fail
+			sess.SetOptions(options, session.SourceLaunch)
+		}
 	}
-	
-	return &mcp.CallToolResult{
-		Content: []mcp.Content{
-			mcp.TextContent{
-				Type: "text",
-				Text: string(respData),
-			},
-		},
-	}, nil
-}
 
-func (h *Handlers) SendKeys(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	args := request.GetArguments()
-	sessionID, ok := args["session_id"].(string)
-	if !ok {
-		err := fmt.Errorf("session_id parameter is required")
-		slog.Error("Invalid tool call",
-			slog.String("tool", "send_keys"),
-			slog.String("error", err.Error()),
-		)
-		return nil, err
+	// Create new session
+	if !pooled {
+		sess, err = h.sessionManager.CreateSessionWithConfig(session.SessionConfig{
+			Command:        command,
+			Args:           cmdArgs,
+			Env:            env,
+			Group:          group,
+			Label:          label,
+			Shell:          shell,
+			Locale:         locale,
+			Timezone:       timezone,
+			Options:        options,
+			Width:          width,
+			Height:         height,
+			SeparateStderr: separateStderr,
+		})
 	}
-	
-	// Validate session ID
-	if err := validateSessionID(sessionID); err != nil {
-		slog.Error("Invalid session ID",
-			slog.String("tool", "send_keys"),
-			slog.String("session_id", sessionID),
-			slog.String("error", err.Error()),
+	if err != nil {
+		utils.LogErrorContext(ctx, err, "Failed to launch app",
+			slog.String("tool", "launch_app"),
+			slog.String("command", command),
 		)
-		return nil, err
+		// Surface the specific reason rather than the layers it passed through
+		var cmdErr *terminal.CommandError
+		if errors.As(err, &cmdErr) {
+			return nil, fmt.Errorf("failed to launch app: %w", cmdErr)
+		}
+		// Terminal exhaustion and setup problems get a code and a hint
+		var ptyErr *terminal.PTYError
+		if errors.As(err, &ptyErr) {
+			return toolErrorResult(fmt.Errorf("failed to launch app: %w", err), ptyErr.Code(), map[string]interface{}{
+				"hint":            ptyErr.Hint,
+				"active_sessions": len(h.sessionManager.ListSessions()),
+			}), nil
+		}
+		return nil, fmt.Errorf("failed to launch app: %w", err)
 	}
 
-	keys, ok := args["keys"].(string)
-	if !ok {
-		err := fmt.Errorf("keys parameter is required")
-		slog.Error("Invalid tool call",
-			slog.String("tool", "send_keys"),
-			slog.String("error", err.Error()),
-		)
-		return nil, err
+	slog.InfoContext(ctx, "App launched successfully",
+		slog.String("tool", "launch_app"),
+		slog.String("session_id", sess.ID),
+		slog.String("command", command),
+		slog.Bool("pooled", pooled),
+	)
+
+	response := LaunchAppResponse{
+		SessionID: sess.ID,
+		PID:       sess.GetInfo().PID,
+		Success:   true,
+		Pooled:    pooled,
 	}
-	
-	// Validate keys
-	if err := validateKeys(keys); err != nil {
-		slog.Error("Invalid keys",
-			slog.String("tool", "send_keys"),
-			slog.String("keys", keys),
-			slog.String("error", err.Error()),
-		)
-		return nil, err
+	if probe != nil {
+		start := h.now()
+		waitCtx, cancel := context.WithTimeout(ctx, probe.wait)
+		_, screen, version, err := sess.Buffer.WaitMatch(waitCtx, probe.pattern)
+		cancel()
+		if err != nil && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		ready, readyMs := err == nil, h.since(start).Milliseconds()
+		response.Ready, response.ReadyMs = &ready, &readyMs
+		if ready {
+			response.ReadyVersion = &version
+		}
+		if err != nil && probe.abort {
+			if _, stopErr := h.sessionManager.StopSession(sess.ID, true, true); stopErr != nil {
+				slog.WarnContext(ctx, "Failed to stop app that never got ready",
+					slog.String("tool", "launch_app"),
+					slog.String("session_id", sess.ID),
+					slog.String("error", stopErr.Error()),
+				)
+			}
+			return toolErrorResult(fmt.Errorf("app not ready after %d ms; session stopped", probe.wait.Milliseconds()), appNotReadyCode, map[string]interface{}{
+				"session_id": response.SessionID,
+				"pid":        response.PID,
+				"pooled":     response.Pooled,
+				"ready":      ready,
+				"ready_ms":   readyMs,
+				"screen":     screen,
+			}), nil
+		}
 	}
-	
-	utils.LogToolCall("send_keys", sessionID, slog.Int("key_count", len(keys)))
 
-	sess, err := h.sessionManager.GetSession(sessionID)
+	return jsonResult(response)
+}
+
+func (h *Handlers) ViewScreen(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "view_screen", args)
 	if err != nil {
 		return nil, err
 	}
+	sessionID := sess.ID
+	
+	utils.LogToolCall(ctx, "view_screen", sessionID)
 
-	// Map special keys
-	mappedKeys := MapKeys(keys)
-	if mappedKeys != keys {
-		slog.Debug("Keys mapped",
-			slog.String("original", keys),
-			slog.String("mapped", fmt.Sprintf("%q", mappedKeys)),
-		)
+	format, _, err := GetString(args, "format")
+	if err != nil {
+		return nil, invalidParam(ctx, "view_screen", err)
 	}
-
-	if err := sess.SendKeys(mappedKeys); err != nil {
-		utils.LogError(err, "Failed to send keys",
-			slog.String("tool", "send_keys"),
-			slog.String("session_id", sessionID),
-		)
-		return nil, err
+	formats, hasFormats, err := GetStringSlice(args, "formats")
+	if err != nil {
+		return nil, invalidParam(ctx, "view_screen", err)
 	}
+	if hasFormats {
+		if format != "" {
+			return nil, invalidParam(ctx, "view_screen", fmt.Errorf("format and formats can't be combined"))
+		}
+		if err := validateFormats(formats); err != nil {
+			return nil, invalidParam(ctx, "view_screen", err)
+		}
+	} else {
+		if format == "" {
+			format = h.effectiveFormat(sess)
+		}
 
-	return &mcp.CallToolResult{
-		Content: []mcp.Content{
-			mcp.TextContent{
-				Type: "text",
-				Text: `{"success": true}`,
-			},
-		},
-	}, nil
-}
+		// Validate format
+		if err := validateFormat(format); err != nil {
+			slog.ErrorContext(ctx, "Invalid format",
+				slog.String("tool", "view_screen"),
+				slog.String("format", format),
+				slog.String("error", err.Error()),
+			)
+			return nil, err
+		}
+		formats = []string{format}
+	}
 
-func (h *Handlers) GetCursorPosition(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	args := request.GetArguments()
-	sessionID, ok := args["session_id"].(string)
-	if !ok {
-		err := fmt.Errorf("session_id parameter is required")
-		slog.Error("Invalid tool call",
-			slog.String("tool", "get_cursor_position"),
-			slog.String("error", err.Error()),
-		)
-		return nil, err
+	maxBytes, hasMax, err := GetInt(args, "max_bytes")
+	if err != nil {
+		return nil, invalidParam(ctx, "view_screen", err)
 	}
-	
-	// Validate session ID
-	if err := validateSessionID(sessionID); err != nil {
-		slog.Error("Invalid session ID",
-			slog.String("tool", "get_cursor_position"),
-			slog.String("session_id", sessionID),
-			slog.String("error", err.Error()),
-		)
-		return nil, err
+	if !hasMax {
+		maxBytes = h.maxOutput
+	} else if maxBytes < 1 {
+		return nil, invalidParam(ctx, "view_screen", fmt.Errorf("max_bytes must be positive"))
 	}
-	
-	utils.LogToolCall("get_cursor_position", sessionID)
-
-	sess, err := h.sessionManager.GetSession(sessionID)
+	dirtySince, hasDirtySince, err := GetInt(args, "only_dirty_since")
 	if err != nil {
-		return nil, err
+		return nil, invalidParam(ctx, "view_screen", err)
+	}
+	if hasDirtySince && hasFormats {
+		return nil, invalidParam(ctx, "view_screen", fmt.Errorf("only_dirty_since can't be combined with formats"))
+	}
+	if hasDirtySince && format != "lines" {
+		return nil, invalidParam(ctx, "view_screen", fmt.Errorf("only_dirty_since needs the lines format"))
+	}
+	if dirtySince < 0 {
+		return nil, invalidParam(ctx, "view_screen", fmt.Errorf("only_dirty_since must not be negative"))
+	}
+	renderOpts, hasDebugOpts, err := debugRenderOptions(args, sess.Buffer.RenderOptions())
+	if err != nil {
+		return nil, invalidParam(ctx, "view_screen", err)
+	}
+	if hasDebugOpts && !slices.Contains(formats, "debug") && !slices.Contains(formats, "ansi") {
+		return nil, invalidParam(ctx, "view_screen", fmt.Errorf("cursor_marker, style_marker, dot_spaces and rulers need the debug format"))
+	}
+	version, hasVersion, err := GetInt(args, "version")
+	if err != nil {
+		return nil, invalidParam(ctx, "view_screen", err)
+	}
+	if hasVersion {
+		if hasFormats {
+			return nil, invalidParam(ctx, "view_screen", fmt.Errorf("version can't be combined with formats"))
+		}
+		if version < 0 {
+			return nil, invalidParam(ctx, "view_screen", fmt.Errorf("version must not be negative"))
+		}
+		if !slices.Contains(terminal.HistoryFormats, format) {
+			return nil, invalidParam(ctx, "view_screen", fmt.Errorf("format %s can't show an earlier version, use one of: %s", format, strings.Join(terminal.HistoryFormats, ", ")))
+		}
 	}
 
-	row, col := sess.GetCursorPosition()
+	opCtx, done, err := beginOperation(ctx, "view_screen", sess, session.OpShared, args)
+	if err != nil {
+		return operationError(ctx, "view_screen", err)
+	}
+	defer done()
 
-	return &mcp.CallToolResult{
-		Content: []mcp.Content{
-			mcp.TextContent{
-				Type: "text",
-				Text: fmt.Sprintf(`{"row": %d, "col": %d}`, row, col),
-			},
-		},
-	}, nil
-}
+	if hasVersion {
+		return h.viewScreenVersion(opCtx, sess, format, uint64(version), renderOpts, maxBytes)
+	}
+	if hasFormats {
+		return h.viewScreenFormats(opCtx, sess, formats, renderOpts, maxBytes)
+	}
 
-func (h *Handlers) GetScreenSize(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	args := request.GetArguments()
-	sessionID, ok := args["session_id"].(string)
-	if !ok {
-		err := fmt.Errorf("session_id parameter is required")
-		slog.Error("Invalid tool call",
-			slog.String("tool", "get_screen_size"),
-			slog.String("error", err.Error()),
-		)
-		return nil, err
+	col, row := sess.GetCursorPosition()
+	screen := ScreenResponse{
+		Cursor:   CursorPosition{Row: row, Col: col},
+		Degraded: sess.Buffer.Degraded(),
 	}
-	
-	// Validate session ID
-	if err := validateSessionID(sessionID); err != nil {
-		slog.Error("Invalid session ID",
-			slog.String("tool", "get_screen_size"),
-			slog.String("session_id", sessionID),
-			slog.String("error", err.Error()),
-		)
-		return nil, err
+
+	if format == "lines" {
+		// Rows as structured data; max_bytes doesn't apply, since dropping
+		// rows would defeat the stable indices
+		lines, generation, err := sess.GetLines(opCtx, uint64(dirtySince))
+		if err != nil {
+			return nil, err
+		}
+		return jsonResult(ViewLinesResponse{
+			ScreenResponse: screen,
+			Lines:          lines,
+			Generation:     generation,
+		})
 	}
-	
-	utils.LogToolCall("get_screen_size", sessionID)
 
-	sess, err := h.sessionManager.GetSession(sessionID)
+	content, rawOffset, err := sess.GetScreenWithOffset(opCtx, format, renderOpts)
 	if err != nil {
 		return nil, err
 	}
-
-	width, height := sess.GetScreenSize()
-
-	return &mcp.CallToolResult{
-		Content: []mcp.Content{
-			mcp.TextContent{
-				Type: "text",
-				Text: fmt.Sprintf(`{"width": %d, "height": %d}`, width, height),
-			},
-		},
-	}, nil
+	cut := terminal.Truncate(content, maxBytes)
+	response := ViewScreenResponse{
+		ScreenResponse: screen,
+		Content:        cut.Content,
+		RawOffset:      rawOffset,
+		Truncated:      cut.Truncated,
+	}
+	if cut.Truncated {
+		response.Truncation = &Truncation{OmittedBytes: cut.OmittedBytes, OmittedLines: cut.OmittedLines}
+	}
+	return jsonResult(response)
 }
 
-func (h *Handlers) RestartApp(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+func (h *Handlers) SendKeys(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	args := request.GetArguments()
-	sessionID, ok := args["session_id"].(string)
-	if !ok {
-		err := fmt.Errorf("session_id parameter is required")
-		slog.Error("Invalid tool call",
-			slog.String("tool", "restart_app"),
-			slog.String("error", err.Error()),
-		)
+	sess, err := h.resolveSession(ctx, "send_keys", args)
+	if err != nil {
 		return nil, err
 	}
+	sessionID := sess.ID
+
+	keys, hasKeys, err := GetString(args, "keys")
+	if err != nil {
+		return nil, invalidParam(ctx, "send_keys", err)
+	}
+	if !hasKeys {
+		return nil, invalidParam(ctx, "send_keys", fmt.Errorf("keys parameter is required"))
+	}
+	dryRun, _, err := GetBool(args, "dry_run")
+	if err != nil {
+		return nil, invalidParam(ctx, "send_keys", err)
+	}
+	verbose, _, err := GetBool(args, "verbose")
+	if err != nil {
+		return nil, invalidParam(ctx, "send_keys", err)
+	}
 	
-	// Validate session ID
-	if err := validateSessionID(sessionID); err != nil {
-		slog.Error("Invalid session ID",
-			slog.String("tool", "restart_app"),
-			slog.String("session_id", sessionID),
+	// Validate keys
+	if err := validateKeys(keys, h.maxInput); err != nil {
+		slog.ErrorContext(ctx, "Invalid keys",
+			slog.String("tool", "send_keys"),
+			slog.String("keys", keys),
 			slog.String("error", err.Error()),
 		)
 		return nil, err
 	}
 	
-	utils.LogToolCall("restart_app", sessionID)
+	utils.LogToolCall(ctx, "send_keys", sessionID, slog.Int("key_count", len(keys)))
+
+
+	// Map special keys to the form the application currently expects
+	mappings := LookupKeyProfile(sess.KeyProfile()).MapTokens(keys, sess.InputModes())
+	var mapped strings.Builder
+	for _, m := range mappings {
+		mapped.WriteString(m.Bytes)
+	}
+	mappedKeys := mapped.String()
+	if dryRun {
+		return keyMappingResult(sessionID, mappings, mappedKeys)
+	}
+	if mappedKeys != keys {
+		slog.DebugContext(ctx, "Keys mapped",
+			slog.String("original", keys),
+			slog.String("mapped", fmt.Sprintf("%q", mappedKeys)),
+		)
+	}
 
-	sess, err := h.sessionManager.GetSession(sessionID)
+	opCtx, done, err := beginOperation(ctx, "send_keys", sess, session.OpShared, args)
 	if err != nil {
-		return nil, err
+		return operationError(ctx, "send_keys", err)
 	}
+	defer done()
 
-	if err := sess.Restart(); err != nil {
-		return nil, fmt.Errorf("failed to restart app: %w", err)
+	written, err := sess.SendKeys(opCtx, mappedKeys)
+	if err != nil {
+		utils.LogErrorContext(ctx, err, "Failed to send keys",
+			slog.String("tool", "send_keys"),
+			slog.String("session_id", sessionID),
+		)
+		if errors.Is(err, terminal.ErrInputBlocked) {
+			return inputBlockedResult(err, written), nil
+		}
+		return nil, err
 	}
 
-	return &mcp.CallToolResult{
-		Content: []mcp.Content{
-			mcp.TextContent{
-				Type: "text",
-				Text: `{"success": true}`,
-			},
-		},
-	}, nil
+	response := SendKeysResponse{Success: true, BytesWritten: written}
+	if verbose {
+		response.Mapped = EscapeBytes(mappedKeys)
+	}
+	return jsonResult(response)
 }
 
-func (h *Handlers) StopApp(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+// SendSecret sends a password or other secret the way send_keys sends keys.
+// The secret is never logged or echoed back, and the audit log records only
+// its length and a hash, so it stays out of transcripts.
+func (h *Handlers) SendSecret(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	args := request.GetArguments()
-	sessionID, ok := args["session_id"].(string)
-	if !ok {
-		err := fmt.Errorf("session_id parameter is required")
-		slog.Error("Invalid tool call",
-			slog.String("tool", "stop_app"),
-			slog.String("error", err.Error()),
-		)
+	sess, err := h.resolveSession(ctx, "send_secret", args)
+	if err != nil {
 		return nil, err
 	}
-	
-	// Validate session ID
-	if err := validateSessionID(sessionID); err != nil {
-		slog.Error("Invalid session ID",
-			slog.String("tool", "stop_app"),
-			slog.String("session_id", sessionID),
-			slog.String("error", err.Error()),
-		)
-		return nil, err
+
+	secret, _, err := GetString(args, "secret")
+	if err != nil {
+		return nil, invalidParam(ctx, "send_secret", err)
 	}
-	
-	utils.LogToolCall("stop_app", sessionID)
+	if secret == "" {
+		return nil, invalidParam(ctx, "send_secret", fmt.Errorf("secret parameter is required"))
+	}
+	if len(secret) > h.maxInput {
+		return nil, invalidParam(ctx, "send_secret", fmt.Errorf("secret parameter is %d bytes, over the input limit of %d bytes (MCP_MAX_INPUT_BYTES)", len(secret), h.maxInput))
+	}
+
+	utils.LogToolCall(ctx, "send_secret", sess.ID, slog.Int("key_count", len(secret)))
+
+	opCtx, done, err := beginOperation(ctx, "send_secret", sess, session.OpShared, args)
+	if err != nil {
+		return operationError(ctx, "send_secret", err)
+	}
+	defer done()
 
-	if err := h.sessionManager.RemoveSession(sessionID); err != nil {
+	written, err := sess.SendSecret(opCtx, MapSessionKeys(sess, secret))
+	if err != nil {
+		utils.LogErrorContext(ctx, err, "Failed to send secret",
+			slog.String("tool", "send_secret"),
+			slog.String("session_id", sess.ID),
+		)
+		if errors.Is(err, terminal.ErrInputBlocked) {
+			return inputBlockedResult(err, written), nil
+		}
 		return nil, err
 	}
 
-	return &mcp.CallToolResult{
-		Content: []mcp.Content{
-			mcp.TextContent{
-				Type: "text",
-				Text: `{"success": true}`,
-			},
-		},
-	}, nil
+	return jsonResult(SendKeysResponse{Success: true, BytesWritten: written})
 }
 
-func (h *Handlers) ListSessions(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	utils.LogToolCall("list_sessions", "")
-	
-	sessions := h.sessionManager.ListSessions()
-	
-	slog.Debug("Sessions listed",
-		slog.String("tool", "list_sessions"),
-		slog.Int("count", len(sessions)),
-	)
-
-	// Convert sessions to JSON string
-	var sessionStrings []string
-	for _, s := range sessions {
-		sessionStrings = append(sessionStrings, fmt.Sprintf(`{"id": %q, "command": %q, "state": %q, "created": %q}`, 
-			s.ID, s.Command, s.State, s.Created.Format("2006-01-02T15:04:05Z")))
+// keyMappingResult describes what send_keys would write for a dry run,
+// token by token
+func keyMappingResult(sessionID string, mappings []KeyMapping, mapped string) (*mcp.CallToolResult, error) {
+	tokens := make([]KeyToken, 0, len(mappings))
+	for _, m := range mappings {
+		token := KeyToken{
+			Token:   m.Token,
+			Kind:    "text",
+			Escaped: EscapeBytes(m.Bytes),
+			Hex:     HexBytes(m.Bytes),
+		}
+		if m.Key {
+			token.Kind = "key"
+			token.Mode = m.Mode
+		}
+		tokens = append(tokens, token)
 	}
 
-	return &mcp.CallToolResult{
-		Content: []mcp.Content{
-			mcp.TextContent{
-				Type: "text",
-				Text: fmt.Sprintf(`{"sessions": [%s]}`, strings.Join(sessionStrings, ", ")),
-			},
-		},
-	}, nil
+	return jsonResult(KeyMappingResponse{
+		SessionID: sessionID,
+		DryRun:    true,
+		Tokens:    tokens,
+		Escaped:   EscapeBytes(mapped),
+		Hex:       HexBytes(mapped),
+		Bytes:     len(mapped),
+	})
 }
 
-func (h *Handlers) ResizeTerminal(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+func (h *Handlers) SendRawBytes(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	args := request.GetArguments()
-	
-	// Debug logging
-	slog.Debug("ResizeTerminal called", 
-		slog.String("tool", "resize_terminal"),
-		slog.Any("args", args),
-	)
-	
-	sessionID, ok := args["session_id"].(string)
-	if !ok {
-		err := fmt.Errorf("session_id parameter is required")
-		slog.Error("Invalid tool call",
-			slog.String("tool", "resize_terminal"),
-			slog.String("error", err.Error()),
-		)
+	sess, err := h.resolveSession(ctx, "send_raw_bytes", args)
+	if err != nil {
 		return nil, err
 	}
-	
-	// Validate session ID
-	if err := validateSessionID(sessionID); err != nil {
-		slog.Error("Invalid session ID",
-			slog.String("tool", "resize_terminal"),
-			slog.String("session_id", sessionID),
-			slog.String("error", err.Error()),
-		)
-		return nil, err
+
+	encoded, _, err := GetString(args, "data")
+	if err != nil {
+		return nil, invalidParam(ctx, "send_raw_bytes", err)
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, invalidParam(ctx, "send_raw_bytes", fmt.Errorf("data must be base64: %w", err))
+	}
+	if len(data) == 0 {
+		return nil, invalidParam(ctx, "send_raw_bytes", fmt.Errorf("data parameter is required"))
+	}
+	if len(data) > h.maxInput {
+		return nil, invalidParam(ctx, "send_raw_bytes", fmt.Errorf("data is %d bytes, over the input limit of %d bytes (MCP_MAX_INPUT_BYTES)", len(data), h.maxInput))
 	}
 
-	// Try to get width as float64 or int
-	var width float64
-	if w, ok := args["width"].(float64); ok {
-		width = w
-	} else if w, ok := args["width"].(int); ok {
-		width = float64(w)
-	} else {
-		err := fmt.Errorf("width parameter is required")
-		slog.Error("Invalid tool call",
-			slog.String("tool", "resize_terminal"),
-			slog.String("error", err.Error()),
-			slog.Any("width_type", fmt.Sprintf("%T", args["width"])),
-		)
-		return nil, err
+	utils.LogToolCall(ctx, "send_raw_bytes", sess.ID, slog.Int("bytes", len(data)))
+
+	opCtx, done, err := beginOperation(ctx, "send_raw_bytes", sess, session.OpShared, args)
+	if err != nil {
+		return operationError(ctx, "send_raw_bytes", err)
 	}
+	defer done()
 
-	// Try to get height as float64 or int
-	var height float64
-	if h, ok := args["height"].(float64); ok {
-		height = h
-	} else if h, ok := args["height"].(int); ok {
-		height = float64(h)
-	} else {
-		err := fmt.Errorf("height parameter is required")
-		slog.Error("Invalid tool call",
-			slog.String("tool", "resize_terminal"),
-			slog.String("error", err.Error()),
-			slog.Any("height_type", fmt.Sprintf("%T", args["height"])),
+	// Bytes go to the process exactly as given, without key name mapping
+	if written, err := sess.SendKeys(opCtx, string(data)); err != nil {
+		utils.LogErrorContext(ctx, err, "Failed to send raw bytes",
+			slog.String("tool", "send_raw_bytes"),
+			slog.String("session_id", sess.ID),
 		)
+		if errors.Is(err, terminal.ErrInputBlocked) {
+			return inputBlockedResult(err, written), nil
+		}
 		return nil, err
 	}
-	
-	// Validate dimensions
-	if err := validateDimensions(width, height); err != nil {
-		slog.Error("Invalid dimensions",
-			slog.String("tool", "resize_terminal"),
-			slog.Float64("width", width),
-			slog.Float64("height", height),
-			slog.String("error", err.Error()),
-		)
+
+	return jsonResult(SendRawBytesResponse{Success: true, Bytes: len(data)})
+}
+
+// Targets of send_signal
+const (
+	signalTargetForeground = "foreground"
+	signalTargetProcess    = "process"
+)
+
+// SendSignal delivers a signal without going through the terminal's input,
+// for applications in raw mode that read control characters as bytes
+func (h *Handlers) SendSignal(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "send_signal", args)
+	if err != nil {
 		return nil, err
 	}
 
-	utils.LogToolCall("resize_terminal", sessionID,
-		slog.Int("width", int(width)),
-		slog.Int("height", int(height)),
+	name, _, err := GetString(args, "signal")
+	if err != nil {
+		return nil, invalidParam(ctx, "send_signal", err)
+	}
+	if name == "" {
+		return nil, invalidParam(ctx, "send_signal", fmt.Errorf("signal parameter is required"))
+	}
+	sig, err := terminal.ParseSignal(name)
+	if err != nil {
+		return nil, invalidParam(ctx, "send_signal", err)
+	}
+	target, _, err := GetString(args, "target")
+	if err != nil {
+		return nil, invalidParam(ctx, "send_signal", err)
+	}
+	switch target {
+	case "":
+		target = signalTargetForeground
+	case signalTargetForeground, signalTargetProcess:
+	default:
+		return nil, invalidParam(ctx, "send_signal", fmt.Errorf("target must be %s or %s", signalTargetForeground, signalTargetProcess))
+	}
+
+	utils.LogToolCall(ctx, "send_signal", sess.ID,
+		slog.String("signal", name),
+		slog.String("target", target),
 	)
 
-	sess, err := h.sessionManager.GetSession(sessionID)
+	_, done, err := beginOperation(ctx, "send_signal", sess, session.OpShared, args)
+	if err != nil {
+		return operationError(ctx, "send_signal", err)
+	}
+	defer done()
+
+	pid, err := sess.Signal(sig, target == signalTargetForeground)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := sess.Resize(int(width), int(height)); err != nil {
-		utils.LogError(err, "Failed to resize terminal",
-			slog.String("tool", "resize_terminal"),
-			slog.String("session_id", sessionID),
-		)
+	return jsonResult(SendSignalResponse{
+		Success: true,
+		Signal:  name,
+		Target:  target,
+		PID:     pid,
+	})
+}
+
+func (h *Handlers) ExportRawOutput(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "export_raw_output", args)
+	if err != nil {
 		return nil, err
 	}
 
-	return &mcp.CallToolResult{
-		Content: []mcp.Content{
-			mcp.TextContent{
-				Type: "text",
-				Text: fmt.Sprintf(`{"success": true, "width": %d, "height": %d}`, int(width), int(height)),
-			},
-		},
-	}, nil
-}
\ No newline at end of file
+	since, _, err := GetInt(args, "since")
+	if err != nil {
+		return nil, invalidParam(ctx, "export_raw_output", err)
+	}
+	maxBytes, hasMax, err := GetInt(args, "max_bytes")
+	if err != nil {
+		return nil, invalidParam(ctx, "export_raw_output", err)
+	}
+	if !hasMax {
+		maxBytes = defaultExportSize
+	}
+	if maxBytes < 1 || maxBytes > maxExportSize {
+		return nil, invalidParam(ctx, "export_raw_output", fmt.Errorf("max_bytes must be between 1 and %d", maxExportSize))
+	}
+
+	utils.LogToolCall(ctx, "export_raw_output", sess.ID, slog.Int("since", since))
+
+	chunk, err := sess.ReadRawOutput(int64(since), maxBytes)
+	if err != nil {
+		return nil, invalidParam(ctx, "export_raw_output", err)
+	}
+
+	return jsonResult(ExportRawOutputResponse{
+		SessionID:  sess.ID,
+		Data:       base64.StdEncoding.EncodeToString(chunk.Data),
+		Encoding:   "base64",
+		Offset:     chunk.Offset,
+		NextOffset: chunk.Next,
+		EndOffset:  sess.Buffer.RawDataEnd(),
+		Truncated:  chunk.Truncated,
+		State:      sess.GetInfo().State,
+	})
+}
+
+// stderrNotSeparatedCode marks a get_stderr call for a session whose stderr
+// goes to the terminal
+const stderrNotSeparatedCode = "stderr_not_separated"
+
+// GetStderr reads the separated stderr of a session launched with
+// separate_stderr from a byte offset, for paging through it like
+// export_raw_output does the terminal output
+func (h *Handlers) GetStderr(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "get_stderr", args)
+	if err != nil {
+		return nil, err
+	}
+
+	offset, _, err := GetInt(args, "offset")
+	if err != nil {
+		return nil, invalidParam(ctx, "get_stderr", err)
+	}
+	limit, hasLimit, err := GetInt(args, "limit")
+	if err != nil {
+		return nil, invalidParam(ctx, "get_stderr", err)
+	}
+	if !hasLimit {
+		limit = defaultExportSize
+	}
+	if limit < 1 || limit > maxExportSize {
+		return nil, invalidParam(ctx, "get_stderr", fmt.Errorf("limit must be between 1 and %d", maxExportSize))
+	}
+
+	utils.LogToolCall(ctx, "get_stderr", sess.ID, slog.Int("offset", offset))
+
+	chunk, err := sess.ReadStderr(int64(offset), limit)
+	if errors.Is(err, session.ErrStderrNotSeparated) {
+		return toolErrorResult(err, stderrNotSeparatedCode, nil), nil
+	}
+	if err != nil {
+		return nil, invalidParam(ctx, "get_stderr", err)
+	}
+
+	return jsonResult(GetStderrResponse{
+		SessionID:  sess.ID,
+		Data:       string(chunk.Data),
+		Offset:     chunk.Offset,
+		NextOffset: chunk.Next,
+		EndOffset:  sess.StderrEnd(),
+		Truncated:  chunk.Truncated,
+		State:      sess.GetInfo().State,
+	})
+}
+
+// ViewHistory pages through the scrollback followed by the screen as one
+// document of numbered lines, for reading a long history a window at a
+// time, whether the process is running or has exited
+func (h *Handlers) ViewHistory(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "view_history", args)
+	if err != nil {
+		return nil, err
+	}
+
+	start, _, err := GetInt(args, "start_line")
+	if err != nil {
+		return nil, invalidParam(ctx, "view_history", err)
+	}
+	if start < 0 {
+		return nil, invalidParam(ctx, "view_history", fmt.Errorf("start_line must not be negative"))
+	}
+	count, hasCount, err := GetInt(args, "count")
+	if err != nil {
+		return nil, invalidParam(ctx, "view_history", err)
+	}
+	if !hasCount {
+		count = defaultHistoryLines
+	}
+	if count < 1 || count > maxHistoryLines {
+		return nil, invalidParam(ctx, "view_history", fmt.Errorf("count must be between 1 and %d", maxHistoryLines))
+	}
+	format, _, err := GetString(args, "format")
+	if err != nil {
+		return nil, invalidParam(ctx, "view_history", err)
+	}
+	if format == "" {
+		format = "plain"
+	}
+	if !slices.Contains(terminal.WindowFormats, format) {
+		return nil, invalidParam(ctx, "view_history", fmt.Errorf("format must be one of: %s", strings.Join(terminal.WindowFormats, ", ")))
+	}
+
+	utils.LogToolCall(ctx, "view_history", sess.ID,
+		slog.Int("start_line", start),
+		slog.Int("count", count),
+	)
+
+	_, done, err := beginOperation(ctx, "view_history", sess, session.OpShared, args)
+	if err != nil {
+		return operationError(ctx, "view_history", err)
+	}
+	defer done()
+
+	window, err := sess.Buffer.Window(format, start, count)
+	if err != nil {
+		return nil, invalidParam(ctx, "view_history", err)
+	}
+	return jsonResult(ViewHistoryResponse{
+		SessionID:    sess.ID,
+		Lines:        window.Lines,
+		StartLine:    window.Start,
+		NextLine:     window.Start + len(window.Lines),
+		TotalLines:   window.Total,
+		DroppedLines: window.Dropped,
+		Frozen:       sess.BufferInfo().Frozen,
+	})
+}
+
+func (h *Handlers) GetCursorPosition(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "get_cursor_position", args)
+	if err != nil {
+		return nil, err
+	}
+	sessionID := sess.ID
+	
+	utils.LogToolCall(ctx, "get_cursor_position", sessionID)
+
+
+	col, row := sess.GetCursorPosition()
+
+	return jsonResult(CursorPosition{Row: row, Col: col})
+}
+
+// AnalyzeScreen runs the analyzer's heuristics over the screen and reports
+// the prompts, highlights, boxes and input fields they found
+func (h *Handlers) AnalyzeScreen(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "analyze_screen", args)
+	if err != nil {
+		return nil, err
+	}
+
+	utils.LogToolCall(ctx, "analyze_screen", sess.ID)
+
+	_, done, err := beginOperation(ctx, "analyze_screen", sess, session.OpShared, args)
+	if err != nil {
+		return operationError(ctx, "analyze_screen", err)
+	}
+	defer done()
+
+	cells, col, row := sess.Buffer.Snapshot()
+	visible := sess.TerminalModes().CursorVisible
+	elements := analyzer.Analyze(analyzer.Screen{Cells: cells, CursorRow: row, CursorCol: col, CursorVisible: visible})
+
+	return jsonResult(AnalyzeScreenResponse{
+		SessionID:    sess.ID,
+		Elements:     elements,
+		Cursor:       CursorState{CursorPosition: CursorPosition{Row: row, Col: col}, Visible: visible},
+		Experimental: true,
+	})
+}
+
+// IsReadyForInput combines idle time, cursor visibility, a prompt at the
+// cursor and the process's state into a guess at whether the application
+// is waiting for input, with each signal's part in it
+func (h *Handlers) IsReadyForInput(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "is_ready_for_input", args)
+	if err != nil {
+		return nil, err
+	}
+
+	idleMs, hasIdle, err := GetInt(args, "idle_ms")
+	if err != nil {
+		return nil, invalidParam(ctx, "is_ready_for_input", err)
+	}
+	if !hasIdle {
+		idleMs = defaultReadyIdleMs
+	}
+	if idleMs < 0 || idleMs > maxStableMs {
+		return nil, invalidParam(ctx, "is_ready_for_input", fmt.Errorf("idle_ms must be between 0 and %d", maxStableMs))
+	}
+	// The call's pattern wins over the session's prompt_pattern option
+	pattern, _, err := GetString(args, "prompt_pattern")
+	if err != nil {
+		return nil, invalidParam(ctx, "is_ready_for_input", err)
+	}
+	if pattern == "" {
+		pattern = sess.StringOption(session.OptionPromptPattern)
+	}
+	var prompt *regexp.Regexp
+	if pattern != "" {
+		if prompt, err = regexp.Compile(pattern); err != nil {
+			return nil, invalidParam(ctx, "is_ready_for_input", fmt.Errorf("invalid prompt_pattern: %w", err))
+		}
+	}
+
+	utils.LogToolCall(ctx, "is_ready_for_input", sess.ID, slog.Int("idle_ms", idleMs))
+
+	_, done, err := beginOperation(ctx, "is_ready_for_input", sess, session.OpShared, args)
+	if err != nil {
+		return operationError(ctx, "is_ready_for_input", err)
+	}
+	defer done()
+
+	idle := h.since(sess.LastOutput())
+	cells, col, row := sess.Buffer.Snapshot()
+	visible := sess.TerminalModes().CursorVisible
+	exited := sess.BufferInfo().Frozen
+	var processes []terminal.ProcessEntry
+	if !exited {
+		if info, err := sess.GetProcessInfo(); err == nil {
+			processes = append([]terminal.ProcessEntry{{PID: info.PID, State: info.State, Command: info.Command}}, info.Descendants...)
+		}
+	}
+
+	readiness := analyzer.Ready(analyzer.ReadyInput{
+		Screen:    analyzer.Screen{Cells: cells, CursorRow: row, CursorCol: col, CursorVisible: visible},
+		Idle:      idle,
+		MinIdle:   time.Duration(idleMs) * time.Millisecond,
+		Prompt:    prompt,
+		Exited:    exited,
+		Processes: processes,
+	})
+
+	return jsonResult(ReadyForInputResponse{
+		SessionID: sess.ID,
+		Readiness: readiness,
+		IdleMs:    idle.Milliseconds(),
+		Cursor:    CursorState{CursorPosition: CursorPosition{Row: row, Col: col}, Visible: visible},
+	})
+}
+
+// GetTerminalModes reports the DEC private and ANSI modes the application
+// has set, the active screen and the G0 character set
+func (h *Handlers) GetTerminalModes(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "get_terminal_modes", args)
+	if err != nil {
+		return nil, err
+	}
+
+	utils.LogToolCall(ctx, "get_terminal_modes", sess.ID)
+
+	modes := sess.TerminalModes()
+	return jsonResult(TerminalModesResponse{
+		SessionID:         sess.ID,
+		Modes:             modes.Map(),
+		ApplicationKeypad: modes.Keypad,
+		Screen:            modes.Screen(),
+		Charset:           modes.Charset,
+	})
+}
+
+// GetBufferInfo reports how much scrollback and raw output a session holds,
+// with its size and change counter, so a client knows what there is to page
+// through
+func (h *Handlers) GetBufferInfo(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "get_buffer_info", args)
+	if err != nil {
+		return nil, err
+	}
+
+	utils.LogToolCall(ctx, "get_buffer_info", sess.ID)
+
+	return jsonResult(sess.BufferInfo())
+}
+
+// Defaults for start_frame_capture
+const (
+	defaultCaptureIntervalMs = 100
+	maxCaptureIntervalMs     = 60000
+	defaultCaptureFrames     = 1000
+)
+
+// StartFrameCapture starts recording a session's screen every time it
+// changes, at most once per interval_ms
+func (h *Handlers) StartFrameCapture(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "start_frame_capture", args)
+	if err != nil {
+		return nil, err
+	}
+
+	intervalMs, hasInterval, err := GetInt(args, "interval_ms")
+	if err != nil {
+		return nil, invalidParam(ctx, "start_frame_capture", err)
+	}
+	if !hasInterval {
+		intervalMs = defaultCaptureIntervalMs
+	}
+	minIntervalMs := int(session.MinCaptureInterval / time.Millisecond)
+	if intervalMs < minIntervalMs || intervalMs > maxCaptureIntervalMs {
+		return nil, invalidParam(ctx, "start_frame_capture", fmt.Errorf("interval_ms must be between %d and %d", minIntervalMs, maxCaptureIntervalMs))
+	}
+	maxFrames, hasMax, err := GetInt(args, "max_frames")
+	if err != nil {
+		return nil, invalidParam(ctx, "start_frame_capture", err)
+	}
+	if !hasMax {
+		maxFrames = defaultCaptureFrames
+	}
+	if maxFrames < 1 || maxFrames > session.MaxCaptureFrames {
+		return nil, invalidParam(ctx, "start_frame_capture", fmt.Errorf("max_frames must be between 1 and %d", session.MaxCaptureFrames))
+	}
+	format, _, err := GetString(args, "format")
+	if err != nil {
+		return nil, invalidParam(ctx, "start_frame_capture", err)
+	}
+	if format == "" {
+		format = "plain"
+	}
+	if err := validateFormat(format); err != nil {
+		return nil, invalidParam(ctx, "start_frame_capture", err)
+	}
+
+	utils.LogToolCall(ctx, "start_frame_capture", sess.ID,
+		slog.Int("interval_ms", intervalMs),
+		slog.Int("max_frames", maxFrames),
+	)
+
+	if err := sess.StartFrameCapture(format, time.Duration(intervalMs)*time.Millisecond, maxFrames); err != nil {
+		return nil, err
+	}
+
+	return jsonResult(StartFrameCaptureResponse{
+		SessionID:  sess.ID,
+		Capturing:  true,
+		Format:     format,
+		IntervalMs: intervalMs,
+		MaxFrames:  maxFrames,
+	})
+}
+
+// StopFrameCapture stops a session's frame capture and returns the frames,
+// or writes them to files in dir and returns their paths
+func (h *Handlers) StopFrameCapture(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "stop_frame_capture", args)
+	if err != nil {
+		return nil, err
+	}
+	dir, _, err := GetString(args, "dir")
+	if err != nil {
+		return nil, invalidParam(ctx, "stop_frame_capture", err)
+	}
+	if dir != "" {
+		if dir, err = filepath.Abs(dir); err != nil {
+			return nil, invalidParam(ctx, "stop_frame_capture", err)
+		}
+	}
+
+	utils.LogToolCall(ctx, "stop_frame_capture", sess.ID)
+
+	result, err := sess.StopFrameCapture()
+	if err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create frame directory: %w", err)
+		}
+	}
+
+	frames := make([]CapturedFrame, 0, len(result.Frames))
+	for i, f := range result.Frames {
+		frame := CapturedFrame{
+			Index:     i,
+			Time:      f.Time.Format(time.RFC3339Nano),
+			ElapsedMs: f.Time.Sub(result.Started).Milliseconds(),
+			Version:   f.Version,
+		}
+		if dir != "" {
+			path := filepath.Join(dir, fmt.Sprintf("frame-%05d.txt", i))
+			if err := os.WriteFile(path, []byte(f.Content), 0o644); err != nil {
+				return nil, fmt.Errorf("failed to write frame %d: %w", i, err)
+			}
+			frame.Path = path
+		} else {
+			content := f.Content
+			frame.Content = &content
+		}
+		frames = append(frames, frame)
+	}
+
+	return jsonResult(StopFrameCaptureResponse{
+		SessionID:  sess.ID,
+		Format:     result.Format,
+		IntervalMs: result.Interval.Milliseconds(),
+		Frames:     frames,
+		Count:      len(frames),
+		Dropped:    result.Dropped,
+	})
+}
+
+// screenNotStableCode marks a wait that timed out with the screen still
+// changing
+const screenNotStableCode = "screen_not_stable"
+
+// WaitForStableScreen waits until the screen, or a band of rows, has not
+// changed for stable_ms and returns that frame with its version and hash
+func (h *Handlers) WaitForStableScreen(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "wait_for_stable_screen", args)
+	if err != nil {
+		return nil, err
+	}
+
+	stableMs, hasStable, err := GetInt(args, "stable_ms")
+	if err != nil {
+		return nil, invalidParam(ctx, "wait_for_stable_screen", err)
+	}
+	if !hasStable {
+		stableMs = defaultStableMs
+	}
+	if stableMs < 1 || stableMs > maxStableMs {
+		return nil, invalidParam(ctx, "wait_for_stable_screen", fmt.Errorf("stable_ms must be between 1 and %d", maxStableMs))
+	}
+	timeoutMs, hasTimeout, err := GetInt(args, "timeout_ms")
+	if err != nil {
+		return nil, invalidParam(ctx, "wait_for_stable_screen", err)
+	}
+	if !hasTimeout {
+		timeoutMs = defaultWaitMs
+	}
+	if timeoutMs < 1 || timeoutMs > maxWaitMs {
+		return nil, invalidParam(ctx, "wait_for_stable_screen", fmt.Errorf("timeout_ms must be between 1 and %d", maxWaitMs))
+	}
+
+	// Rows default to the whole screen; -1 tells WaitStable so
+	_, height := sess.GetScreenSize()
+	fromRow, hasFrom, err := GetInt(args, "from_row")
+	if err != nil {
+		return nil, invalidParam(ctx, "wait_for_stable_screen", err)
+	}
+	toRow, hasTo, err := GetInt(args, "to_row")
+	if err != nil {
+		return nil, invalidParam(ctx, "wait_for_stable_screen", err)
+	}
+	if !hasTo {
+		toRow = height - 1
+	}
+	if fromRow < 0 || fromRow > toRow || toRow >= height {
+		return nil, invalidParam(ctx, "wait_for_stable_screen", fmt.Errorf("rows must satisfy 0 <= from_row <= to_row < %d", height))
+	}
+	if !hasFrom && !hasTo {
+		toRow = -1
+	}
+
+	format, _, err := GetString(args, "format")
+	if err != nil {
+		return nil, invalidParam(ctx, "wait_for_stable_screen", err)
+	}
+	if format == "" {
+		format = h.effectiveFormat(sess)
+	}
+	if err := validateFormat(format); err != nil {
+		return nil, invalidParam(ctx, "wait_for_stable_screen", err)
+	}
+
+	utils.LogToolCall(ctx, "wait_for_stable_screen", sess.ID,
+		slog.Int("stable_ms", stableMs),
+		slog.Int("timeout_ms", timeoutMs),
+	)
+
+	start := h.now()
+	waitCtx, cancel := context.WithTimeout(ctx, time.Duration(timeoutMs)*time.Millisecond)
+	defer cancel()
+	_, err = sess.Buffer.WaitStable(waitCtx, time.Duration(stableMs)*time.Millisecond, fromRow, toRow)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	content, version, renderErr := sess.Buffer.RenderGeneration(format)
+	if renderErr != nil {
+		return nil, renderErr
+	}
+	sum := sha256.Sum256([]byte(content))
+	frame := StableScreenResponse{
+		SessionID: sess.ID,
+		Version:   version,
+		Hash:      hex.EncodeToString(sum[:]),
+		WaitedMs:  h.since(start).Milliseconds(),
+		Content:   content,
+	}
+	if err != nil {
+		// The frame is still moving, so only its version and hash are
+		// worth reporting
+		return toolErrorResult(fmt.Errorf("screen still changing after %d ms", timeoutMs), screenNotStableCode, map[string]interface{}{
+			"session_id": frame.SessionID,
+			"version":    frame.Version,
+			"hash":       frame.Hash,
+			"waited_ms":  frame.WaitedMs,
+		}), nil
+	}
+
+	return jsonResult(frame)
+}
+
+func (h *Handlers) GetScreenSize(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "get_screen_size", args)
+	if err != nil {
+		return nil, err
+	}
+	sessionID := sess.ID
+	
+	utils.LogToolCall(ctx, "get_screen_size", sessionID)
+
+
+	width, height := sess.GetScreenSize()
+
+	return jsonResult(ScreenSizeResponse{Width: width, Height: height})
+}
+
+func (h *Handlers) RestartApp(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	clearHistory, _, err := GetBool(args, "clear_history")
+	if err != nil {
+		return nil, invalidParam(ctx, "restart_app", err)
+	}
+	sess, err := h.resolveSession(ctx, "restart_app", args)
+	if err != nil {
+		return nil, err
+	}
+	sessionID := sess.ID
+	
+	utils.LogToolCall(ctx, "restart_app", sessionID)
+
+	_, done, err := beginOperation(ctx, "restart_app", sess, session.OpLifecycle, args)
+	if err != nil {
+		return operationError(ctx, "restart_app", err)
+	}
+	defer done()
+
+	if err := h.sessionManager.RestartSession(sessionID, clearHistory); err != nil {
+		return nil, fmt.Errorf("failed to restart app: %w", err)
+	}
+
+	return jsonResult(RestartAppResponse{Success: true, PID: sess.GetInfo().PID})
+}
+
+func (h *Handlers) StopApp(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	force, _, err := GetBool(args, "force")
+	if err != nil {
+		return nil, invalidParam(ctx, "stop_app", err)
+	}
+	ignoreMissing, _, err := GetBool(args, "ignore_missing")
+	if err != nil {
+		return nil, invalidParam(ctx, "stop_app", err)
+	}
+
+	var result session.StopResult
+	sess, err := h.resolveSession(ctx, "stop_app", args)
+	if err != nil {
+		if !ignoreMissing || !errors.Is(err, session.ErrSessionNotFound) {
+			return nil, err
+		}
+		// Nothing to stop; report it rather than failing teardown
+		ref, _, _ := GetString(args, "session_id")
+		result = session.StopResult{ID: ref, Result: "already_removed"}
+	} else {
+		utils.LogToolCall(ctx, "stop_app", sess.ID,
+			slog.Bool("force", force),
+			slog.Bool("ignore_missing", ignoreMissing),
+		)
+
+		_, done, err := beginOperation(ctx, "stop_app", sess, session.OpLifecycle, args)
+		if err != nil {
+			return operationError(ctx, "stop_app", err)
+		}
+		defer done()
+
+		result, err = h.sessionManager.StopSession(sess.ID, force, ignoreMissing)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return jsonResult(StopAppResponse{
+		Success:   true,
+		SessionID: result.ID,
+		Result:    result.Result,
+	})
+}
+
+func (h *Handlers) ListSessions(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	utils.LogToolCall(ctx, "list_sessions", "")
+
+	args := request.GetArguments()
+	group, _, err := GetString(args, "group")
+	if err != nil {
+		return nil, invalidParam(ctx, "list_sessions", err)
+	}
+	var sessions []*session.SessionInfo
+	if group != "" {
+		if err := validateGroup(group); err != nil {
+			slog.ErrorContext(ctx, "Invalid group",
+				slog.String("tool", "list_sessions"),
+				slog.String("group", group),
+				slog.String("error", err.Error()),
+			)
+			return nil, err
+		}
+		sessions = h.sessionManager.ListGroupSessions(group)
+	} else {
+		sessions = h.sessionManager.ListSessions()
+	}
+	
+	slog.DebugContext(ctx, "Sessions listed",
+		slog.String("tool", "list_sessions"),
+		slog.Int("count", len(sessions)),
+	)
+
+	summaries := make([]SessionSummary, 0, len(sessions))
+	for _, s := range sessions {
+		summaries = append(summaries, SessionSummary{
+			ID:           s.ID,
+			Command:      s.Command,
+			PID:          s.PID,
+			State:        s.State,
+			Created:      s.Created.Format("2006-01-02T15:04:05Z"),
+			Group:        s.Group,
+			Degraded:     s.Degraded,
+			Unresponsive: s.Unresponsive,
+		})
+	}
+
+	return jsonResult(ListSessionsResponse{Sessions: summaries})
+}
+
+func (h *Handlers) ResizeTerminal(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	
+	// Debug logging
+	slog.DebugContext(ctx, "ResizeTerminal called", 
+		slog.String("tool", "resize_terminal"),
+		slog.Any("args", args),
+	)
+	
+	sess, err := h.resolveSession(ctx, "resize_terminal", args)
+	if err != nil {
+		return nil, err
+	}
+	sessionID := sess.ID
+
+	width, hasWidth, err := GetInt(args, "width")
+	if err != nil {
+		return nil, invalidParam(ctx, "resize_terminal", err)
+	}
+	if !hasWidth {
+		return nil, invalidParam(ctx, "resize_terminal", fmt.Errorf("width parameter is required"))
+	}
+
+	height, hasHeight, err := GetInt(args, "height")
+	if err != nil {
+		return nil, invalidParam(ctx, "resize_terminal", err)
+	}
+	if !hasHeight {
+		return nil, invalidParam(ctx, "resize_terminal", fmt.Errorf("height parameter is required"))
+	}
+	
+	// Validate dimensions
+	if err := h.limits.Validate(width, height); err != nil {
+		slog.ErrorContext(ctx, "Invalid dimensions",
+			slog.String("tool", "resize_terminal"),
+			slog.Int("width", width),
+			slog.Int("height", height),
+			slog.String("error", err.Error()),
+		)
+		return nil, err
+	}
+
+	utils.LogToolCall(ctx, "resize_terminal", sessionID,
+		slog.Int("width", width),
+		slog.Int("height", height),
+	)
+
+
+	opCtx, done, err := beginOperation(ctx, "resize_terminal", sess, session.OpExclusive, args)
+	if err != nil {
+		return operationError(ctx, "resize_terminal", err)
+	}
+	defer done()
+
+	if err := sess.Resize(opCtx, width, height); err != nil {
+		utils.LogErrorContext(ctx, err, "Failed to resize terminal",
+			slog.String("tool", "resize_terminal"),
+			slog.String("session_id", sessionID),
+		)
+		return nil, err
+	}
+
+	return jsonResult(ResizeTerminalResponse{Success: true, Width: width, Height: height})
+}
+
+// GetProcessInfo reports the PID, state and resource usage of a session's process
+func (h *Handlers) GetProcessInfo(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "get_process_info", args)
+	if err != nil {
+		return nil, err
+	}
+	sessionID := sess.ID
+
+	utils.LogToolCall(ctx, "get_process_info", sessionID)
+
+	info, err := sess.GetProcessInfo()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get process info: %w", err)
+	}
+
+	return jsonResult(info)
+}
+
+// GetResourceHistory returns the session's resource samples. Sampling
+// that is off, or not supported here, returns none rather than failing.
+func (h *Handlers) GetResourceHistory(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	sess, err := h.resolveSession(ctx, "get_resource_history", request.GetArguments())
+	if err != nil {
+		return nil, err
+	}
+
+	utils.LogToolCall(ctx, "get_resource_history", sess.ID)
+
+	return jsonResult(ResourceHistoryResponse{
+		SessionID:       sess.ID,
+		ResourceHistory: sess.ResourceHistory(),
+	})
+}
+
+func (h *Handlers) GetSessionInfo(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "get_session_info", args)
+	if err != nil {
+		return nil, err
+	}
+
+	utils.LogToolCall(ctx, "get_session_info", sess.ID)
+
+	details := sess.GetDetails()
+	details.DefaultFormat = h.effectiveFormat(sess)
+
+	return jsonResult(details)
+}
+
+func (h *Handlers) SetSessionOption(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "set_session_option", args)
+	if err != nil {
+		return nil, err
+	}
+
+	name, _, err := GetString(args, "name")
+	if err != nil {
+		return nil, invalidParam(ctx, "set_session_option", err)
+	}
+	def, err := session.LookupOption(name)
+	if err != nil {
+		return nil, invalidParam(ctx, "set_session_option", err)
+	}
+	value, hasValue, err := optionValue(def, args, "value")
+	if err != nil {
+		return nil, invalidParam(ctx, "set_session_option", err)
+	}
+	if !hasValue {
+		return nil, invalidParam(ctx, "set_session_option", fmt.Errorf("value is required"))
+	}
+
+	utils.LogToolCall(ctx, "set_session_option", sess.ID)
+
+	if err := sess.SetOption(name, value, session.SourceRuntime); err != nil {
+		return nil, invalidParam(ctx, "set_session_option", err)
+	}
+
+	slog.InfoContext(ctx, "Session option set",
+		slog.String("session_id", sess.ID),
+		slog.String("option", name),
+		slog.Any("value", value),
+	)
+
+	return jsonResult(SetSessionOptionResponse{
+		Success:   true,
+		SessionID: sess.ID,
+		Name:      name,
+		Value:     value,
+	})
+}
+
+func (h *Handlers) GetSessionOptions(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "get_session_options", args)
+	if err != nil {
+		return nil, err
+	}
+
+	utils.LogToolCall(ctx, "get_session_options", sess.ID)
+
+	// An unset default_format means the server default applies
+	options := sess.Options()
+	if opt := options[session.OptionDefaultFormat]; opt.Value == "" {
+		opt.Value = h.defaultFormat
+		options[session.OptionDefaultFormat] = opt
+	}
+
+	return jsonResult(SessionOptionsResponse{
+		SessionID: sess.ID,
+		Options:   options,
+	})
+}
+
+func (h *Handlers) GetSessionLogs(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "get_session_logs", args)
+	if err != nil {
+		return nil, err
+	}
+
+	level := slog.LevelDebug
+	levelName, hasLevel, err := GetString(args, "level")
+	if err != nil {
+		return nil, invalidParam(ctx, "get_session_logs", err)
+	}
+	if hasLevel {
+		if level, err = utils.ParseLevel(levelName); err != nil {
+			return nil, invalidParam(ctx, "get_session_logs", err)
+		}
+	}
+	limit, hasLimit, err := GetInt(args, "limit")
+	if err != nil {
+		return nil, invalidParam(ctx, "get_session_logs", err)
+	}
+	if !hasLimit {
+		limit = defaultLogLimit
+	}
+	if limit < 1 || limit > maxLogLimit {
+		return nil, invalidParam(ctx, "get_session_logs", fmt.Errorf("limit must be between 1 and %d", maxLogLimit))
+	}
+
+	utils.LogToolCall(ctx, "get_session_logs", sess.ID)
+
+	logs := sess.Logs(level, limit)
+	if logs == nil {
+		logs = []session.LogEntry{}
+	}
+
+	return jsonResult(SessionLogsResponse{
+		SessionID: sess.ID,
+		Logs:      logs,
+		Count:     len(logs),
+	})
+}
+
+// ExportSession writes a bundle for bug reports: the session's record,
+// screens, scrollback, raw output, input history, parser diagnostics and
+// logs, with launch environment values and secrets redacted
+func (h *Handlers) ExportSession(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "export_session", args)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, _, err := GetString(args, "dir")
+	if err != nil {
+		return nil, invalidParam(ctx, "export_session", err)
+	}
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		return nil, invalidParam(ctx, "export_session", err)
+	}
+	format, _, err := GetString(args, "format")
+	if err != nil {
+		return nil, invalidParam(ctx, "export_session", err)
+	}
+	if format == "" {
+		format = "dir"
+	}
+	if format != "dir" && format != "tar.gz" {
+		return nil, invalidParam(ctx, "export_session", fmt.Errorf("format must be dir or tar.gz"))
+	}
+	maxBytes, hasMax, err := GetInt(args, "max_bytes")
+	if err != nil {
+		return nil, invalidParam(ctx, "export_session", err)
+	}
+	if !hasMax {
+		maxBytes = session.DefaultBundleBytes
+	}
+	if maxBytes < 1 || maxBytes > session.MaxBundleBytes {
+		return nil, invalidParam(ctx, "export_session", fmt.Errorf("max_bytes must be between 1 and %d", session.MaxBundleBytes))
+	}
+
+	utils.LogToolCall(ctx, "export_session", sess.ID,
+		slog.String("dir", dir),
+		slog.String("format", format),
+	)
+
+	bundle, err := sess.Bundle(maxBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build session bundle: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create export directory: %w", err)
+	}
+	var path string
+	if format == "tar.gz" {
+		path, err = bundle.WriteArchive(dir)
+	} else {
+		path, err = bundle.WriteDir(dir)
+	}
+	if err != nil {
+		utils.LogErrorContext(ctx, err, "Failed to export session",
+			slog.String("tool", "export_session"),
+			slog.String("session_id", sess.ID),
+		)
+		return nil, err
+	}
+
+	total := 0
+	for _, f := range bundle.Manifest.Files {
+		total += f.Bytes
+	}
+	return jsonResult(ExportSessionResponse{
+		SessionID: sess.ID,
+		Path:      path,
+		Format:    format,
+		Files:     bundle.Manifest.Files,
+		Omitted:   bundle.Manifest.Omitted,
+		Redacted:  bundle.Manifest.Redacted,
+		Bytes:     total,
+	})
+}
+
+func (h *Handlers) GetSessionEvents(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sessionID, _, err := GetString(args, "session_id")
+	if err != nil {
+		return nil, invalidParam(ctx, "get_session_events", err)
+	}
+	if err := validateSessionID(sessionID); err != nil {
+		return nil, invalidParam(ctx, "get_session_events", err)
+	}
+	sinceSeq, _, err := GetInt(args, "since_seq")
+	if err != nil {
+		return nil, invalidParam(ctx, "get_session_events", err)
+	}
+	if sinceSeq < 0 {
+		return nil, invalidParam(ctx, "get_session_events", fmt.Errorf("since_seq must not be negative"))
+	}
+	limit, hasLimit, err := GetInt(args, "limit")
+	if err != nil {
+		return nil, invalidParam(ctx, "get_session_events", err)
+	}
+	if !hasLimit {
+		limit = defaultEventLimit
+	}
+	if limit < 1 || limit > maxEventLimit {
+		return nil, invalidParam(ctx, "get_session_events", fmt.Errorf("limit must be between 1 and %d", maxEventLimit))
+	}
+
+	id, page, removed, err := h.sessionManager.SessionEvents(sessionID, uint64(sinceSeq), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	utils.LogToolCall(ctx, "get_session_events", id, slog.Int("since_seq", sinceSeq))
+
+	return jsonResult(SessionEventsResponse{
+		SessionID: id,
+		Removed:   removed,
+		Count:     len(page.Events),
+		EventPage: page,
+	})
+}
+
+// ListRecentActivity returns what happened across all sessions since a
+// point in time
+func (h *Handlers) ListRecentActivity(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sinceText, hasSince, err := GetString(args, "since")
+	if err != nil {
+		return nil, invalidParam(ctx, "list_recent_activity", err)
+	}
+	withinMs, hasWithin, err := GetInt(args, "within_ms")
+	if err != nil {
+		return nil, invalidParam(ctx, "list_recent_activity", err)
+	}
+	var since time.Time
+	switch {
+	case hasSince && hasWithin:
+		return nil, invalidParam(ctx, "list_recent_activity", fmt.Errorf("since and within_ms can't both be set"))
+	case hasSince:
+		if since, err = time.Parse(time.RFC3339Nano, sinceText); err != nil {
+			return nil, invalidParam(ctx, "list_recent_activity", fmt.Errorf("since must be an RFC 3339 timestamp, got %q", sinceText))
+		}
+	case hasWithin:
+		if withinMs < 1 {
+			return nil, invalidParam(ctx, "list_recent_activity", fmt.Errorf("within_ms must be positive"))
+		}
+		since = h.now().Add(-time.Duration(withinMs) * time.Millisecond)
+	}
+	sessionID, hasSession, err := GetString(args, "session_id")
+	if err != nil {
+		return nil, invalidParam(ctx, "list_recent_activity", err)
+	}
+	if hasSession {
+		if err := validateSessionID(sessionID); err != nil {
+			return nil, invalidParam(ctx, "list_recent_activity", err)
+		}
+	}
+	limit, hasLimit, err := GetInt(args, "limit")
+	if err != nil {
+		return nil, invalidParam(ctx, "list_recent_activity", err)
+	}
+	if !hasLimit {
+		limit = defaultActivityLimit
+	}
+	if limit < 1 || limit > maxActivityLimit {
+		return nil, invalidParam(ctx, "list_recent_activity", fmt.Errorf("limit must be between 1 and %d", maxActivityLimit))
+	}
+
+	utils.LogToolCall(ctx, "list_recent_activity", sessionID)
+
+	activity := h.sessionManager.RecentActivity(since, sessionID, limit)
+	return jsonResult(RecentActivityResponse{
+		Activity: activity,
+		Count:    len(activity),
+	})
+}
+
+func (h *Handlers) GetParserDiagnostics(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "get_parser_diagnostics", args)
+	if err != nil {
+		return nil, err
+	}
+	reset, _, err := GetBool(args, "reset")
+	if err != nil {
+		return nil, invalidParam(ctx, "get_parser_diagnostics", err)
+	}
+
+	utils.LogToolCall(ctx, "get_parser_diagnostics", sess.ID, slog.Bool("reset", reset))
+
+	// Counters are cleared after reading, so nothing is lost between calls
+	diag := sess.Buffer.ParserDiagnostics()
+	if reset {
+		sess.Buffer.ResetParserDiagnostics()
+	}
+
+	return jsonResult(ParserDiagnosticsResponse{
+		SessionID:         sess.ID,
+		ParserDiagnostics: diag,
+		Reset:             reset,
+	})
+}
+
+// launchOptions collects the session options given to launch_app: the
+// options object plus the default_format shorthand
+// appNotReadyCode marks a launch whose ready_when condition never appeared,
+// with the session stopped because abort_on_timeout was set
+const appNotReadyCode = "app_not_ready"
+
+// readyProbe is launch_app's ready_when: what must appear on the screen
+// before the call returns
+type readyProbe struct {
+	pattern *regexp.Regexp
+	wait    time.Duration
+	abort   bool // Stop the session if the pattern never appears
+}
+
+// parseReadyProbe validates launch_app's ready_when, which gives exactly one
+// of text and regex. It returns nil when there is none.
+func parseReadyProbe(args map[string]interface{}) (*readyProbe, error) {
+	raw, ok := args["ready_when"]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	given, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("ready_when must be an object, got %s", typeName(raw))
+	}
+	for name := range given {
+		switch name {
+		case "text", "regex", "timeout_ms", "abort_on_timeout":
+		default:
+			return nil, fmt.Errorf("ready_when: unknown field %q", name)
+		}
+	}
+
+	text, hasText, err := GetString(given, "text")
+	if err != nil {
+		return nil, fmt.Errorf("ready_when: %w", err)
+	}
+	pattern, hasRegex, err := GetString(given, "regex")
+	if err != nil {
+		return nil, fmt.Errorf("ready_when: %w", err)
+	}
+	if hasText == hasRegex {
+		return nil, fmt.Errorf("ready_when must have exactly one of text and regex")
+	}
+	if hasText {
+		pattern = regexp.QuoteMeta(text)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("ready_when: invalid regex: %w", err)
+	}
+
+	timeoutMs, hasTimeout, err := GetInt(given, "timeout_ms")
+	if err != nil {
+		return nil, fmt.Errorf("ready_when: %w", err)
+	}
+	if !hasTimeout {
+		timeoutMs = defaultWaitMs
+	}
+	if timeoutMs < 1 || timeoutMs > maxWaitMs {
+		return nil, fmt.Errorf("ready_when: timeout_ms must be between 1 and %d", maxWaitMs)
+	}
+	abort, _, err := GetBool(given, "abort_on_timeout")
+	if err != nil {
+		return nil, fmt.Errorf("ready_when: %w", err)
+	}
+	return &readyProbe{pattern: re, wait: time.Duration(timeoutMs) * time.Millisecond, abort: abort}, nil
+}
+
+func launchOptions(args map[string]interface{}) (map[string]interface{}, error) {
+	var given map[string]interface{}
+	if raw, ok := args["options"]; ok && raw != nil {
+		if given, ok = raw.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("options must be an object, got %s", typeName(raw))
+		}
+	}
+	if _, ok := args[session.OptionDefaultFormat]; ok {
+		if given == nil {
+			given = make(map[string]interface{})
+		}
+		given[session.OptionDefaultFormat] = args[session.OptionDefaultFormat]
+	}
+
+	options := make(map[string]interface{}, len(given))
+	for name := range given {
+		def, err := session.LookupOption(name)
+		if err != nil {
+			return nil, err
+		}
+		value, hasValue, err := optionValue(def, given, name)
+		if err != nil {
+			return nil, err
+		}
+		if hasValue {
+			options[name] = value
+		}
+	}
+	return options, session.ValidateOptions(options)
+}
+
+// optionValue reads args[key] as the type the option expects
+func optionValue(def *session.OptionDef, args map[string]interface{}, key string) (interface{}, bool, error) {
+	if def.Kind == session.OptionInteger {
+		return GetInt(args, key)
+	}
+	return GetString(args, key)
+}
+
+func (h *Handlers) StopGroup(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	group, _, err := GetString(args, "group")
+	if err != nil {
+		return nil, invalidParam(ctx, "stop_group", err)
+	}
+
+	// Validate group
+	if err := validateGroup(group); err != nil {
+		slog.ErrorContext(ctx, "Invalid group",
+			slog.String("tool", "stop_group"),
+			slog.String("group", group),
+			slog.String("error", err.Error()),
+		)
+		return nil, err
+	}
+
+	utils.LogToolCall(ctx, "stop_group", "", slog.String("group", group))
+
+	stopped, err := h.sessionManager.StopGroup(group)
+	if err != nil && stopped == nil {
+		return nil, err
+	}
+
+	response := StopGroupResponse{
+		Success: err == nil,
+		Group:   group,
+		Stopped: stopped,
+	}
+	if err != nil {
+		response.Error = err.Error()
+	}
+
+	return jsonResult(response)
+}
+
+func (h *Handlers) ListGroups(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	utils.LogToolCall(ctx, "list_groups", "")
+
+	groups := h.sessionManager.ListGroups()
+
+	slog.DebugContext(ctx, "Groups listed",
+		slog.String("tool", "list_groups"),
+		slog.Int("count", len(groups)),
+	)
+
+	return jsonResult(ListGroupsResponse{Groups: groups})
+}
+
+func (h *Handlers) DuplicateSession(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "duplicate_session", args)
+	if err != nil {
+		return nil, err
+	}
+	sessionID := sess.ID
+
+	utils.LogToolCall(ctx, "duplicate_session", sessionID)
+
+	// Extract env overrides if provided
+	env, hasEnv, err := GetStringMap(args, "env")
+	if err != nil {
+		return nil, invalidParam(ctx, "duplicate_session", err)
+	}
+	if hasEnv {
+		if err := validateEnvironment(env); err != nil {
+			slog.ErrorContext(ctx, "Invalid environment",
+				slog.String("tool", "duplicate_session"),
+				slog.Any("env", env),
+				slog.String("error", err.Error()),
+			)
+			return nil, err
+		}
+	}
+
+	// Optional size overrides; 0 keeps the source's size
+	width, hasWidth, err := GetInt(args, "width")
+	if err != nil {
+		return nil, invalidParam(ctx, "duplicate_session", err)
+	}
+	height, hasHeight, err := GetInt(args, "height")
+	if err != nil {
+		return nil, invalidParam(ctx, "duplicate_session", err)
+	}
+	if hasWidth || hasHeight {
+		checkWidth, checkHeight := width, height
+		if !hasWidth {
+			checkWidth = MinDimension
+		}
+		if !hasHeight {
+			checkHeight = MinDimension
+		}
+		if err := h.limits.Validate(checkWidth, checkHeight); err != nil {
+			slog.ErrorContext(ctx, "Invalid dimensions",
+				slog.String("tool", "duplicate_session"),
+				slog.Int("width", width),
+				slog.Int("height", height),
+				slog.String("error", err.Error()),
+			)
+			return nil, err
+		}
+	}
+
+	label, _, err := GetString(args, "label")
+	if err != nil {
+		return nil, invalidParam(ctx, "duplicate_session", err)
+	}
+	if err := validateLabel(label); err != nil {
+		return nil, invalidParam(ctx, "duplicate_session", err)
+	}
+
+	clone, err := h.sessionManager.DuplicateSession(sessionID, env, width, height, label)
+	if err != nil {
+		utils.LogErrorContext(ctx, err, "Failed to duplicate session",
+			slog.String("tool", "duplicate_session"),
+			slog.String("session_id", sessionID),
+		)
+		return nil, fmt.Errorf("failed to duplicate session: %w", err)
+	}
+
+	return jsonResult(DuplicateSessionResponse{
+		SessionID:       clone.ID,
+		SourceSessionID: sessionID,
+		Config:          clone.Config(),
+		Success:         true,
+	})
+}
+
+func (h *Handlers) StopAllSessions(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	utils.LogToolCall(ctx, "stop_all_sessions", "")
+
+	results := h.sessionManager.StopAllSessions()
+
+	success := true
+	for _, r := range results {
+		if r.Result == "error" {
+			success = false
+		}
+	}
+
+	return jsonResult(StopAllSessionsResponse{
+		Success: success,
+		Results: results,
+	})
+}
+
+func (h *Handlers) ListOrphans(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	utils.LogToolCall(ctx, "list_orphans", "")
+
+	orphans := h.sessionManager.ListOrphans()
+
+	return jsonResult(ListOrphansResponse{Orphans: orphans})
+}
+
+func (h *Handlers) ReapOrphans(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	utils.LogToolCall(ctx, "reap_orphans", "")
+
+	results := h.sessionManager.ReapOrphans()
+
+	success := true
+	for _, r := range results {
+		if r.Result == "error" {
+			success = false
+		}
+	}
+
+	return jsonResult(ReapOrphansResponse{
+		Success: success,
+		Results: results,
+	})
+}
+
+func (h *Handlers) PauseCleanup(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	paused, hasPaused, err := GetBool(args, "paused")
+	if err != nil {
+		return nil, invalidParam(ctx, "pause_cleanup", err)
+	}
+	if !hasPaused {
+		paused = true
+	}
+
+	utils.LogToolCall(ctx, "pause_cleanup", "", slog.Bool("paused", paused))
+
+	h.sessionManager.SetCleanupPaused(paused)
+
+	return jsonResult(PauseCleanupResponse{Success: true, CleanupPaused: paused})
+}
+
+func (h *Handlers) SetLogLevel(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	name, ok, err := GetString(args, "level")
+	if err != nil {
+		return nil, invalidParam(ctx, "set_log_level", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("level parameter is required")
+	}
+	level, err := utils.ParseLevel(name)
+	if err != nil {
+		return nil, invalidParam(ctx, "set_log_level", err)
+	}
+
+	utils.LogToolCall(ctx, "set_log_level", "", slog.String("log_level", name))
+
+	previous := utils.SetLogLevel(level)
+
+	// Logged at warn so the change is visible whatever the new level
+	slog.WarnContext(ctx, "Log level changed",
+		slog.String("log_level", strings.ToLower(level.String())),
+		slog.String("previous_level", strings.ToLower(previous.String())),
+	)
+
+	return jsonResult(SetLogLevelResponse{
+		Success:  true,
+		Level:    strings.ToLower(level.String()),
+		Previous: strings.ToLower(previous.String()),
+	})
+}
@@ -0,0 +1,57 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/session"
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// versionNotRetainedCode marks a view_screen version that has left the
+// session's screen history
+const versionNotRetainedCode = "version_not_retained"
+
+// viewScreenVersion answers view_screen for a given version: the current
+// screen, or an earlier one kept by the screen_history option
+func (h *Handlers) viewScreenVersion(ctx context.Context, sess *session.Session, format string, version uint64, opts terminal.RenderOptions, maxBytes int) (*mcp.CallToolResult, error) {
+	view, err := sess.GetScreenVersion(ctx, format, version, opts)
+	if errors.Is(err, terminal.ErrVersionNotRetained) {
+		oldest, _, frames := sess.Buffer.HistoryRange()
+		data := map[string]interface{}{
+			"version":         version,
+			"current_version": sess.Buffer.Generation(),
+		}
+		if frames > 0 {
+			data["oldest_version"] = oldest
+		}
+		if sess.IntOption(session.OptionScreenHistory) == 0 {
+			data["hint"] = "set the screen_history session option to keep earlier versions"
+		}
+		return toolErrorResult(err, versionNotRetainedCode, data), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cut := terminal.Truncate(view.Content, maxBytes)
+	response := ViewScreenResponse{
+		ScreenResponse: ScreenResponse{
+			Cursor:   CursorPosition{Row: view.CursorY, Col: view.CursorX},
+			Degraded: sess.Buffer.Degraded(),
+		},
+		Content:   cut.Content,
+		RawOffset: view.RawEnd,
+		Truncated: cut.Truncated,
+		Version:   &view.Version,
+	}
+	if !view.Current {
+		response.RecordedAt = view.Time.Format(time.RFC3339Nano)
+	}
+	if cut.Truncated {
+		response.Truncation = &Truncation{OmittedBytes: cut.OmittedBytes, OmittedLines: cut.OmittedLines}
+	}
+	return jsonResult(response)
+}
@@ -0,0 +1,150 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/session"
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// ImportDirFromEnv returns the directory import_capture may read captures
+// from, set with MCP_IMPORT_DIR, with symbolic links resolved. Empty, the
+// default, disables the tool; a directory that can't be resolved is logged
+// and disables it too.
+func ImportDirFromEnv() string {
+	dir := os.Getenv("MCP_IMPORT_DIR")
+	if dir == "" {
+		return ""
+	}
+	resolved, err := filepath.Abs(dir)
+	if err == nil {
+		resolved, err = filepath.EvalSymlinks(resolved)
+	}
+	if err != nil {
+		slog.Warn("Ignoring invalid import directory",
+			slog.String("variable", "MCP_IMPORT_DIR"),
+			slog.String("value", dir),
+			slog.String("error", err.Error()),
+		)
+		return ""
+	}
+	return resolved
+}
+
+// importPath resolves path, relative to the import directory unless it is
+// absolute, and checks that it names a regular file inside that directory
+// once symbolic links are followed
+func (h *Handlers) importPath(path string) (string, os.FileInfo, error) {
+	if h.importDir == "" {
+		return "", nil, fmt.Errorf("import_capture is disabled; set MCP_IMPORT_DIR to the directory captures may be read from")
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(h.importDir, path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot read capture: %w", err)
+	}
+	rel, err := filepath.Rel(h.importDir, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", nil, fmt.Errorf("capture %s is outside the import directory %s", path, h.importDir)
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot read capture: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", nil, fmt.Errorf("capture %s is not a regular file", path)
+	}
+	if info.Size() > session.MaxImportBytes {
+		return "", nil, fmt.Errorf("capture is %d bytes, over the limit of %d", info.Size(), session.MaxImportBytes)
+	}
+	return resolved, info, nil
+}
+
+// ImportCapture creates a frozen session from a file of raw terminal
+// output, such as a bundle's output.raw or a script(1) log, so the
+// inspection tools can read it
+func (h *Handlers) ImportCapture(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	path, _, err := GetString(args, "path")
+	if err != nil {
+		return nil, invalidParam(ctx, "import_capture", err)
+	}
+	if path == "" {
+		return nil, invalidParam(ctx, "import_capture", fmt.Errorf("path parameter is required"))
+	}
+	width, hasWidth, err := GetInt(args, "width")
+	if err != nil {
+		return nil, invalidParam(ctx, "import_capture", err)
+	}
+	if !hasWidth {
+		width = 80
+	}
+	height, hasHeight, err := GetInt(args, "height")
+	if err != nil {
+		return nil, invalidParam(ctx, "import_capture", err)
+	}
+	if !hasHeight {
+		height = 24
+	}
+	if err := h.limits.Validate(width, height); err != nil {
+		return nil, invalidParam(ctx, "import_capture", err)
+	}
+	label, _, err := GetString(args, "label")
+	if err != nil {
+		return nil, invalidParam(ctx, "import_capture", err)
+	}
+	if err := validateLabel(label); err != nil {
+		return nil, invalidParam(ctx, "import_capture", err)
+	}
+	options, err := launchOptions(args)
+	if err != nil {
+		return nil, invalidParam(ctx, "import_capture", err)
+	}
+
+	utils.LogToolCall(ctx, "import_capture", "", slog.String("path", path))
+
+	resolved, _, err := h.importPath(path)
+	if err != nil {
+		slog.WarnContext(ctx, "Capture import refused",
+			slog.String("tool", "import_capture"),
+			slog.String("path", path),
+			slog.String("error", err.Error()),
+		)
+		return nil, err
+	}
+	data, err := os.ReadFile(resolved)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read capture: %w", err)
+	}
+	if len(data) > session.MaxImportBytes {
+		// The file grew after it was checked
+		return nil, fmt.Errorf("capture is %d bytes, over the limit of %d", len(data), session.MaxImportBytes)
+	}
+
+	sess, err := h.sessionManager.ImportSession(resolved, data, session.SessionConfig{
+		Label:   label,
+		Options: options,
+		Width:   width,
+		Height:  height,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to import capture: %w", err)
+	}
+
+	return jsonResult(ImportCaptureResponse{
+		SessionID: sess.ID,
+		Success:   true,
+		Path:      resolved,
+		Bytes:     len(data),
+		Width:     width,
+		Height:    height,
+	})
+}
@@ -0,0 +1,264 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/session"
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// maxKeySequence bounds the bytes a keymap file may map one key name to
+const maxKeySequence = 64
+
+var (
+	// keyNamePattern is what a key name from a keymap file must look like:
+	// a capital letter, then letters and digits, with + between the parts
+	// of a chord. Names can't contain spaces, so text typed as a sentence
+	// never matches one.
+	keyNamePattern = regexp.MustCompile(`^[A-Z][A-Za-z0-9]*(\+[A-Za-z0-9]+)*$`)
+
+	// profileNamePattern is what a profile name from a keymap file must
+	// look like, as the built-in ones do
+	profileNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+)
+
+// keymap is the key tables send_keys maps with: the built-in ones, with a
+// keymap file's entries when one is loaded
+type keymap struct {
+	keys     map[string]string      // Key names every profile knows
+	profiles map[string]*KeyProfile // By name
+}
+
+// activeKeymap is replaced whole when a keymap file is loaded, so a
+// mapping in progress sees either the old tables or the new ones
+var activeKeymap atomic.Pointer[keymap]
+
+// keymapFile is the JSON of a keymap file (MCP_KEYMAP_FILE)
+type keymapFile struct {
+	// Keys adds key names, or replaces the sequences of built-in ones, in
+	// every profile
+	Keys map[string]string `json:"keys"`
+	// Profiles defines profiles, or adds to the built-in ones of the same
+	// name
+	Profiles map[string]keymapProfile `json:"profiles"`
+}
+
+// keymapProfile is one profile of a keymap file
+type keymapProfile struct {
+	Base                  string            `json:"base"` // Built-in profile a new profile starts from; xterm when empty
+	Keys                  map[string]string `json:"keys"`
+	ApplicationCursorKeys map[string]string `json:"application_cursor_keys"`
+}
+
+// KeymapSummary describes a loaded keymap file
+type KeymapSummary struct {
+	File       string   `json:"file"`
+	Added      []string `json:"added"`      // Key names the file adds
+	Overridden []string `json:"overridden"` // Built-in key names the file maps differently
+	Profiles   []string `json:"profiles"`   // Profiles the file defines or adds to
+}
+
+// KeymapFileFromEnv returns the keymap file set with MCP_KEYMAP_FILE, or
+// "" for none
+func KeymapFileFromEnv() string {
+	return os.Getenv("MCP_KEYMAP_FILE")
+}
+
+// LoadKeymap reads the keymap file and, if it is valid, maps keys with it
+// from then on. The file replaces any loaded before; on error the keymap
+// in use is kept.
+func LoadKeymap(path string) (*KeymapSummary, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keymap file: %w", err)
+	}
+	km, summary, err := parseKeymap(data)
+	if err != nil {
+		return nil, fmt.Errorf("keymap file %s: %w", path, err)
+	}
+	summary.File = path
+
+	activeKeymap.Store(km)
+	names := make([]string, 0, len(km.profiles))
+	for name := range km.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	session.SetKeyProfiles(names)
+
+	slog.Info("Keymap loaded",
+		slog.String("file", path),
+		slog.Any("added", summary.Added),
+		slog.Any("overridden", summary.Overridden),
+		slog.Any("profiles", summary.Profiles),
+	)
+	return summary, nil
+}
+
+// parseKeymap checks a keymap file and builds the key tables it makes
+// with the built-in ones. A profile's own entries take precedence over
+// the file's keys, which take precedence over the built-in key table.
+func parseKeymap(data []byte) (*keymap, *KeymapSummary, error) {
+	var file keymapFile
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			return nil, nil, fmt.Errorf("invalid JSON at byte %d: %w", syntax.Offset, err)
+		}
+		return nil, nil, fmt.Errorf("invalid keymap: %w", err)
+	}
+
+	summary := &KeymapSummary{Added: []string{}, Overridden: []string{}, Profiles: []string{}}
+	keys := make(map[string]string, len(specialKeys)+len(file.Keys))
+	for name, seq := range specialKeys {
+		keys[name] = seq
+	}
+	for _, name := range sortedKeys(file.Keys) {
+		seq := file.Keys[name]
+		if err := validateKeySequence(name, seq); err != nil {
+			return nil, nil, err
+		}
+		if _, ok := specialKeys[name]; ok {
+			summary.Overridden = append(summary.Overridden, name)
+		} else {
+			if err := validateKeyName(name, keys); err != nil {
+				return nil, nil, err
+			}
+			summary.Added = append(summary.Added, name)
+		}
+		keys[name] = seq
+	}
+
+	profiles := make(map[string]*KeyProfile, len(keyProfiles)+len(file.Profiles))
+	for name, p := range keyProfiles {
+		profiles[name] = &KeyProfile{Name: name, Keys: p.Keys, ApplicationCursorKeys: p.ApplicationCursorKeys, base: keys}
+	}
+	for _, name := range sortedKeys(file.Profiles) {
+		fp := file.Profiles[name]
+		if !profileNamePattern.MatchString(name) {
+			return nil, nil, fmt.Errorf("profile name %q must be lowercase letters, digits and dashes, at most 32", name)
+		}
+		base, builtin := keyProfiles[name]
+		switch {
+		case builtin && fp.Base != "" && fp.Base != name:
+			return nil, nil, fmt.Errorf("profile %s is built in and can't have a base", name)
+		case !builtin && fp.Base == "":
+			base = keyProfiles[session.KeyProfileXterm]
+		case !builtin:
+			if base = keyProfiles[fp.Base]; base == nil {
+				return nil, nil, fmt.Errorf("profile %s: base must be one of: %s", name, strings.Join(session.BuiltinKeyProfiles, ", "))
+			}
+		}
+		for key, seq := range fp.Keys {
+			if err := validateKeySequence(key, seq); err != nil {
+				return nil, nil, fmt.Errorf("profile %s: %w", name, err)
+			}
+		}
+		for key, seq := range fp.ApplicationCursorKeys {
+			if len(seq) > maxKeySequence {
+				return nil, nil, fmt.Errorf("profile %s: sequence for %s is over %d bytes", name, key, maxKeySequence)
+			}
+		}
+
+		p := &KeyProfile{
+			Name:                  name,
+			Keys:                  mergeKeys(base.Keys, fp.Keys),
+			ApplicationCursorKeys: mergeKeys(base.ApplicationCursorKeys, fp.ApplicationCursorKeys),
+			base:                  keys,
+		}
+		if err := p.validate(); err != nil {
+			return nil, nil, err
+		}
+		profiles[name] = p
+		summary.Profiles = append(summary.Profiles, name)
+	}
+
+	return &keymap{keys: keys, profiles: profiles}, summary, nil
+}
+
+// validateKeySequence checks what a keymap file maps a key name to
+func validateKeySequence(name, seq string) error {
+	if seq == "" {
+		return fmt.Errorf("empty sequence for %s", name)
+	}
+	if len(seq) > maxKeySequence {
+		return fmt.Errorf("sequence for %s is over %d bytes", name, maxKeySequence)
+	}
+	return nil
+}
+
+// validateKeyName checks a key name a keymap file adds. Key names are
+// matched ignoring case when no name matches exactly, and terminal
+// actions before any key name, so names that differ from another only in
+// case, or that are an action, would never be sent as given.
+func validateKeyName(name string, keys map[string]string) error {
+	if len(name) < 2 || !keyNamePattern.MatchString(name) {
+		return fmt.Errorf("key name %q must start with a capital letter and be letters and digits, with + between the parts of a chord", name)
+	}
+	if _, ok := terminalActions[name]; ok {
+		return fmt.Errorf("key name %q is a terminal action", name)
+	}
+	for existing := range keys {
+		if strings.EqualFold(existing, name) && existing != name {
+			return fmt.Errorf("key name %q differs from %q only in case", name, existing)
+		}
+	}
+	return nil
+}
+
+// mergeKeys returns base with the entries of over, without changing either
+func mergeKeys(base, over map[string]string) map[string]string {
+	if len(over) == 0 {
+		return base
+	}
+	merged := make(map[string]string, len(base)+len(over))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range over {
+		merged[k] = v
+	}
+	return merged
+}
+
+// sortedKeys returns the keys of m in order, so a file's errors and
+// summary don't depend on map order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ReloadKeymap re-reads the keymap file the server was started with, so
+// its changes apply without a restart. Sessions set to a profile the file
+// no longer defines map keys as xterm does.
+func (h *Handlers) ReloadKeymap(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	utils.LogToolCall(ctx, "reload_keymap", "", slog.String("file", h.keymapFile))
+
+	if h.keymapFile == "" {
+		return nil, fmt.Errorf("no keymap file to reload; set MCP_KEYMAP_FILE to one")
+	}
+	summary, err := LoadKeymap(h.keymapFile)
+	if err != nil {
+		utils.LogErrorContext(ctx, err, "Failed to reload keymap", slog.String("tool", "reload_keymap"))
+		return nil, fmt.Errorf("%w; the keymap in use is kept", err)
+	}
+
+	return jsonResult(ReloadKeymapResponse{Success: true, KeymapSummary: *summary})
+}
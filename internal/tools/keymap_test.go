@@ -0,0 +1,197 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/session"
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// useBuiltinKeymap puts the built-in key tables back when the test ends
+func useBuiltinKeymap(t *testing.T) {
+	t.Cleanup(func() {
+		activeKeymap.Store(&keymap{keys: specialKeys, profiles: keyProfiles})
+		session.SetKeyProfiles(session.BuiltinKeyProfiles)
+	})
+}
+
+func writeKeymap(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseKeymapErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		wantErr string
+	}{
+		{"syntax", `{"keys": {"Menu": }`, "invalid JSON at byte"},
+		{"unknown field", `{"key": {"Menu": "\u001b[29~"}}`, "unknown field"},
+		{"wrong type", `{"keys": {"Menu": 29}}`, "invalid keymap"},
+		{"empty sequence", `{"keys": {"Menu": ""}}`, "empty sequence for Menu"},
+		{"long sequence", `{"keys": {"Menu": "` + strings.Repeat("x", maxKeySequence+1) + `"}}`, "over 64 bytes"},
+		{"lowercase name", `{"keys": {"menu": "x"}}`, "must start with a capital letter"},
+		{"name with space", `{"keys": {"Open Menu": "x"}}`, "must start with a capital letter"},
+		{"single letter", `{"keys": {"M": "x"}}`, "must start with a capital letter"},
+		{"case of built-in", `{"keys": {"HOME": "x"}}`, `differs from "Home" only in case`},
+		{"case of added", `{"keys": {"Menu": "x", "MENU": "y"}}`, "only in case"},
+		{"action", `{"keys": {"Interrupt": "x"}}`, "is a terminal action"},
+		{"profile name", `{"profiles": {"My App": {}}}`, "profile name"},
+		{"unknown base", `{"profiles": {"app": {"base": "vt52"}}}`, "base must be one of"},
+		{"base of built-in", `{"profiles": {"vt100": {"base": "xterm"}}}`, "built in"},
+		{"profile unknown key", `{"profiles": {"app": {"keys": {"Menu": "x"}}}}`, `unknown key "Menu"`},
+		{"profile empty sequence", `{"profiles": {"app": {"keys": {"F1": ""}}}}`, "empty sequence for F1"},
+		{"not a cursor key", `{"profiles": {"app": {"application_cursor_keys": {"F1": "x"}}}}`, "isn't a cursor key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parseKeymap([]byte(tt.file))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestParseKeymapPrecedence(t *testing.T) {
+	km, summary, err := parseKeymap([]byte(`{
+		"keys": {"Menu": "\u001b[29~", "Home": "\u001b[7~", "Ctrl+Alt+X": "\u001b\u0018"},
+		"profiles": {
+			"legacy": {"base": "vt100", "keys": {"Menu": "\u001b[28~", "F1": "\u001b[11~"}, "application_cursor_keys": {"Up": ""}},
+			"linux-console": {"keys": {"F6": "\u001b[[F"}}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("parseKeymap failed: %v", err)
+	}
+	want := &KeymapSummary{
+		Added:      []string{"Ctrl+Alt+X", "Menu"},
+		Overridden: []string{"Home"},
+		Profiles:   []string{"legacy", "linux-console"},
+	}
+	if !reflect.DeepEqual(summary, want) {
+		t.Errorf("Expected summary %+v, got %+v", want, summary)
+	}
+
+	normal := terminal.InputModes{}
+	cursor := terminal.InputModes{ApplicationCursorKeys: true}
+	tests := []struct {
+		profile, key string
+		modes        terminal.InputModes
+		want         string
+	}{
+		// The file's keys apply to every profile and replace built-in keys
+		{session.KeyProfileXterm, "Menu", normal, "\x1b[29~"},
+		{session.KeyProfileXterm, "menu", normal, "\x1b[29~"},
+		{session.KeyProfileXterm, "Home", normal, "\x1b[7~"},
+		{session.KeyProfileXterm, "Ctrl+Alt+X", normal, "\x1b\x18"},
+		{session.KeyProfileXterm, "End", normal, "\x1b[F"},
+		// A built-in profile's own entries still win over them
+		{session.KeyProfileVT100, "Home", normal, "\x1b[1~"},
+		{session.KeyProfileVT100, "Menu", normal, "\x1b[29~"},
+		// A profile's entries win over everything, and a new profile starts
+		// from its base
+		{"legacy", "Menu", normal, "\x1b[28~"},
+		{"legacy", "F1", normal, "\x1b[11~"},
+		{"legacy", "Backspace", normal, "\x08"},
+		{"legacy", "Up", cursor, "\x1b[A"},
+		{"legacy", "Down", cursor, "\x1bOB"},
+		{session.KeyProfileLinuxConsole, "F6", normal, "\x1b[[F"},
+		{session.KeyProfileLinuxConsole, "F1", normal, "\x1b[[A"},
+	}
+	for _, tt := range tests {
+		p, ok := km.profiles[tt.profile]
+		if !ok {
+			t.Fatalf("No profile %s", tt.profile)
+		}
+		if got := p.MapKeys(tt.key, tt.modes); got != tt.want {
+			t.Errorf("%s under %s with %+v = %q, want %q", tt.key, tt.profile, tt.modes, got, tt.want)
+		}
+	}
+
+	// The built-in tables are left alone
+	if _, ok := specialKeys["Menu"]; ok || specialKeys["Home"] != "\x1b[H" {
+		t.Error("parseKeymap changed the built-in key table")
+	}
+	if _, ok := keyProfiles[session.KeyProfileLinuxConsole].Keys["F6"]; ok {
+		t.Error("parseKeymap changed a built-in profile")
+	}
+}
+
+func TestReloadKeymap(t *testing.T) {
+	utils.InitLogger()
+	useBuiltinKeymap(t)
+	path := filepath.Join(t.TempDir(), "keymap.json")
+	writeKeymap(t, path, `{"keys": {"Menu": "\u001b[29~"}}`)
+
+	h := &Handlers{keymapFile: path}
+	if err := h.LoadKeymapFile(); err != nil {
+		t.Fatalf("LoadKeymapFile failed: %v", err)
+	}
+	if got := MapKeys("Menu"); got != "\x1b[29~" {
+		t.Errorf("Expected Menu to be mapped after loading, got %q", got)
+	}
+
+	writeKeymap(t, path, `{"keys": {"Menu": "\u001b[28~"}, "profiles": {"app": {"keys": {"F1": "\u001b[11~"}}}}`)
+	result, err := h.ReloadKeymap(context.Background(), mcp.CallToolRequest{})
+	if err != nil {
+		t.Fatalf("reload_keymap failed: %v", err)
+	}
+	var response ReloadKeymapResponse
+	if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &response); err != nil {
+		t.Fatal(err)
+	}
+	if !response.Success || response.File != path || !reflect.DeepEqual(response.Added, []string{"Menu"}) || !reflect.DeepEqual(response.Profiles, []string{"app"}) {
+		t.Errorf("Unexpected response %+v", response)
+	}
+	if got := MapKeys("Menu"); got != "\x1b[28~" {
+		t.Errorf("Expected the reloaded sequence for Menu, got %q", got)
+	}
+	if got := LookupKeyProfile("app").MapKeys("F1", terminal.InputModes{}); got != "\x1b[11~" {
+		t.Errorf("Expected the new profile's F1, got %q", got)
+	}
+	if !slices.Contains(session.KeyProfiles(), "app") {
+		t.Errorf("Expected the key_profile option to accept app, got %v", session.KeyProfiles())
+	}
+
+	// A broken file is reported and the keymap in use kept
+	writeKeymap(t, path, `{"keys": {"Menu": ""}}`)
+	if _, err := h.ReloadKeymap(context.Background(), mcp.CallToolRequest{}); err == nil || !strings.Contains(err.Error(), "empty sequence") {
+		t.Errorf("Expected the reload to fail, got %v", err)
+	}
+	if got := MapKeys("Menu"); got != "\x1b[28~" {
+		t.Errorf("Expected the keymap to be kept after a failed reload, got %q", got)
+	}
+
+	// Dropping a profile makes sessions set to it map as xterm
+	writeKeymap(t, path, `{}`)
+	if _, err := h.ReloadKeymap(context.Background(), mcp.CallToolRequest{}); err != nil {
+		t.Fatalf("reload_keymap failed: %v", err)
+	}
+	if got := LookupKeyProfile("app").MapKeys("F1", terminal.InputModes{}); got != "\x1bOP" {
+		t.Errorf("Expected xterm's F1 for a dropped profile, got %q", got)
+	}
+	if got := MapKeys("Menu"); got != "Menu" {
+		t.Errorf("Expected Menu to be text again, got %q", got)
+	}
+	if !reflect.DeepEqual(session.KeyProfiles(), []string{"linux-console", "vt100", "xterm"}) {
+		t.Errorf("Expected only the built-in profiles, got %v", session.KeyProfiles())
+	}
+
+	if _, err := (&Handlers{}).ReloadKeymap(context.Background(), mcp.CallToolRequest{}); err == nil || !strings.Contains(err.Error(), "MCP_KEYMAP_FILE") {
+		t.Errorf("Expected an error without a keymap file, got %v", err)
+	}
+}
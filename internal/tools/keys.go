@@ -1,7 +1,11 @@
 package tools
 
 import (
+	"fmt"
 	"strings"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/session"
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
 )
 
 var specialKeys = map[string]string{
@@ -45,6 +49,9 @@ var specialKeys = map[string]string{
 	"Ctrl+X": "\x18",
 	"Ctrl+Y": "\x19",
 	"Ctrl+Z": "\x1a",
+
+	// The quit character
+	"Ctrl+\\": "\x1c",
 	
 	// Function keys
 	"F1":  "\x1bOP",
@@ -66,20 +73,316 @@ var specialKeys = map[string]string{
 	"PageUp":   "\x1b[5~",
 	"PageDown": "\x1b[6~",
 	"Insert":   "\x1b[2~",
+
+	// Numeric keypad, as sent in normal keypad mode
+	"Keypad0":        "0",
+	"Keypad1":        "1",
+	"Keypad2":        "2",
+	"Keypad3":        "3",
+	"Keypad4":        "4",
+	"Keypad5":        "5",
+	"Keypad6":        "6",
+	"Keypad7":        "7",
+	"Keypad8":        "8",
+	"Keypad9":        "9",
+	"KeypadEnter":    "\r",
+	"KeypadPlus":     "+",
+	"KeypadMinus":    "-",
+	"KeypadMultiply": "*",
+	"KeypadDivide":   "/",
+	"KeypadDecimal":  ".",
+}
+
+// terminalActions name what a control character asks of the terminal
+// rather than the key. Each types the character the tty's line discipline
+// acts on in canonical mode with signals enabled, the default (see
+// stty(1)): EOF ends a read, so a program reading its input sees end of
+// file if the line is empty; Interrupt, Quit and Suspend become SIGINT,
+// SIGQUIT and SIGTSTP for the foreground process group. An application
+// that put the terminal in raw mode reads them as plain bytes and decides
+// itself; send_signal delivers the signal whatever the mode. Unlike key
+// names, actions only match with this exact case, so typing the word
+// "quit" still sends the text.
+var terminalActions = map[string]string{
+	"EOF":       "\x04", // Ctrl+D
+	"Interrupt": "\x03", // Ctrl+C
+	"Quit":      "\x1c", // Ctrl+\
+	"Suspend":   "\x1a", // Ctrl+Z
+}
+
+// applicationCursorKeys replace the cursor keys while the application has
+// set DECCKM (CSI ?1h)
+var applicationCursorKeys = map[string]string{
+	"Up":    "\x1bOA",
+	"Down":  "\x1bOB",
+	"Right": "\x1bOC",
+	"Left":  "\x1bOD",
+	"Home":  "\x1bOH",
+	"End":   "\x1bOF",
+}
+
+// applicationKeypadKeys replace the keypad keys while the application has
+// set DECKPAM (ESC =)
+var applicationKeypadKeys = map[string]string{
+	"Keypad0":        "\x1bOp",
+	"Keypad1":        "\x1bOq",
+	"Keypad2":        "\x1bOr",
+	"Keypad3":        "\x1bOs",
+	"Keypad4":        "\x1bOt",
+	"Keypad5":        "\x1bOu",
+	"Keypad6":        "\x1bOv",
+	"Keypad7":        "\x1bOw",
+	"Keypad8":        "\x1bOx",
+	"Keypad9":        "\x1bOy",
+	"KeypadEnter":    "\x1bOM",
+	"KeypadPlus":     "\x1bOk",
+	"KeypadMinus":    "\x1bOm",
+	"KeypadMultiply": "\x1bOj",
+	"KeypadDivide":   "\x1bOo",
+	"KeypadDecimal":  "\x1bOn",
+}
+
+// Key modes reported in KeyMapping.Mode
+const (
+	KeyModeNormal            = "normal"
+	KeyModeApplicationCursor = "application_cursor"
+	KeyModeApplicationKeypad = "application_keypad"
+)
+
+// KeyProfile is how one kind of terminal sends keys, as the entries that
+// differ from xterm's. Applications reading the terminfo entry of another
+// terminal, or written for one, only recognise its sequences.
+type KeyProfile struct {
+	Name string
+	Keys map[string]string // Replace entries of the key table
+	// ApplicationCursorKeys replace applicationCursorKeys entries; an
+	// empty sequence means the key doesn't change in that mode
+	ApplicationCursorKeys map[string]string
+
+	base map[string]string // Key table Keys apply to; specialKeys when nil
+}
+
+// keyTable returns the key names the profile knows and their sequences
+// before its own entries apply
+func (p *KeyProfile) keyTable() map[string]string {
+	if p.base != nil {
+		return p.base
+	}
+	return specialKeys
+}
+
+// keyProfiles are the built-in profiles the key_profile option selects
+var keyProfiles = map[string]*KeyProfile{
+	session.KeyProfileXterm: {Name: session.KeyProfileXterm},
+	// A VT220, which most terminfo vt100 descendants follow for the keys
+	// the VT100 lacked: Backspace is BS and Delete is DEL, and Home and
+	// End are the editing keypad's Find and Select in either cursor mode
+	session.KeyProfileVT100: {
+		Name: session.KeyProfileVT100,
+		Keys: map[string]string{
+			"Backspace": "\x08",
+			"Delete":    "\x7f",
+			"Home":      "\x1b[1~",
+			"End":       "\x1b[4~",
+		},
+		ApplicationCursorKeys: map[string]string{
+			"Home": "",
+			"End":  "",
+		},
+	},
+	// The Linux virtual console, TERM=linux
+	session.KeyProfileLinuxConsole: {
+		Name: session.KeyProfileLinuxConsole,
+		Keys: map[string]string{
+			"Home": "\x1b[1~",
+			"End":  "\x1b[4~",
+			"F1":   "\x1b[[A",
+			"F2":   "\x1b[[B",
+			"F3":   "\x1b[[C",
+			"F4":   "\x1b[[D",
+			"F5":   "\x1b[[E",
+		},
+		ApplicationCursorKeys: map[string]string{
+			"Home": "",
+			"End":  "",
+		},
+	},
+}
+
+func init() {
+	if err := validateKeyProfiles(); err != nil {
+		panic(err)
+	}
+	activeKeymap.Store(&keymap{keys: specialKeys, profiles: keyProfiles})
+}
+
+// validateKeyProfiles checks that every built-in profile name has a
+// profile and that profiles only replace keys that exist
+func validateKeyProfiles() error {
+	if len(keyProfiles) != len(session.BuiltinKeyProfiles) {
+		return fmt.Errorf("%d key profiles for %d key_profile values", len(keyProfiles), len(session.BuiltinKeyProfiles))
+	}
+	for _, name := range session.BuiltinKeyProfiles {
+		p, ok := keyProfiles[name]
+		if !ok {
+			return fmt.Errorf("no key profile %q", name)
+		}
+		if p.Name != name {
+			return fmt.Errorf("key profile %q is named %q", name, p.Name)
+		}
+		if err := p.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate checks that the profile only replaces keys that exist, with
+// sequences that aren't empty
+func (p *KeyProfile) validate() error {
+	table := p.keyTable()
+	for key, seq := range p.Keys {
+		if _, ok := table[key]; !ok {
+			return fmt.Errorf("key profile %s: unknown key %q", p.Name, key)
+		}
+		if seq == "" {
+			return fmt.Errorf("key profile %s: empty sequence for %s", p.Name, key)
+		}
+	}
+	for key := range p.ApplicationCursorKeys {
+		if _, ok := applicationCursorKeys[key]; !ok {
+			return fmt.Errorf("key profile %s: %q isn't a cursor key", p.Name, key)
+		}
+	}
+	return nil
+}
+
+// LookupKeyProfile returns the named key profile, or xterm's for a name
+// the key_profile option doesn't accept
+func LookupKeyProfile(name string) *KeyProfile {
+	km := activeKeymap.Load()
+	if p, ok := km.profiles[name]; ok {
+		return p
+	}
+	return km.profiles[session.KeyProfileXterm]
+}
+
+// KeyMapping is one token of send_keys input and the bytes it maps to
+type KeyMapping struct {
+	Token string // The input as given
+	Bytes string // What is written to the terminal for it
+	Key   bool   // Whether Token named a special key rather than being literal text
+	Mode  string // Key mode whose table supplied Bytes; empty for text
 }
 
-// MapKeys converts special key names to their terminal sequences
+// MapKeys converts special key names to their terminal sequences, as
+// sent to an application that left the key modes at their defaults
 func MapKeys(input string) string {
-	// Check if the entire input is a special key
-	if seq, ok := specialKeys[input]; ok {
-		return seq
+	return MapKeysForModes(input, terminal.InputModes{})
+}
+
+// MapKeysForModes converts special key names to the sequences an
+// application expects in its current key modes
+func MapKeysForModes(input string, modes terminal.InputModes) string {
+	return LookupKeyProfile(session.KeyProfileXterm).MapKeys(input, modes)
+}
+
+// MapKeyTokens splits send_keys input into tokens and maps each one. In
+// application cursor mode arrows, Home and End are sent as SS3 (ESC O A)
+// rather than CSI (ESC [ A); in application keypad mode the Keypad keys
+// are.
+func MapKeyTokens(input string, modes terminal.InputModes) []KeyMapping {
+	return LookupKeyProfile(session.KeyProfileXterm).MapTokens(input, modes)
+}
+
+// MapSessionKeys converts special key names to the sequences the
+// session's application expects, under its key profile and current key
+// modes
+func MapSessionKeys(sess *session.Session, input string) string {
+	return LookupKeyProfile(sess.KeyProfile()).MapKeys(input, sess.InputModes())
+}
+
+// MapKeys converts special key names to the profile's sequences for modes
+func (p *KeyProfile) MapKeys(input string, modes terminal.InputModes) string {
+	var out strings.Builder
+	for _, m := range p.MapTokens(input, modes) {
+		out.WriteString(m.Bytes)
 	}
-	
-	// Check for lowercase versions
-	if seq, ok := specialKeys[strings.Title(strings.ToLower(input))]; ok {
-		return seq
+	return out.String()
+}
+
+// MapTokens splits send_keys input into tokens and maps each one with the
+// profile's sequences for modes
+func (p *KeyProfile) MapTokens(input string, modes terminal.InputModes) []KeyMapping {
+	if input == "" {
+		return nil
 	}
-	
-	// Return the input as-is if it's not a special key
-	return input
-}
\ No newline at end of file
+	return []KeyMapping{p.mapKey(input, modes)}
+}
+
+// mapKey maps a single token
+func (p *KeyProfile) mapKey(input string, modes terminal.InputModes) KeyMapping {
+	if seq, ok := terminalActions[input]; ok {
+		return KeyMapping{Token: input, Bytes: seq, Key: true, Mode: KeyModeNormal}
+	}
+
+	table := p.keyTable()
+	name := input
+	if _, ok := table[name]; !ok {
+		// Check for lowercase versions
+		name = strings.Title(strings.ToLower(input))
+	}
+
+	if modes.ApplicationCursorKeys {
+		seq, ok := p.ApplicationCursorKeys[name]
+		if !ok {
+			seq, ok = applicationCursorKeys[name]
+		}
+		if ok && seq != "" {
+			return KeyMapping{Token: input, Bytes: seq, Key: true, Mode: KeyModeApplicationCursor}
+		}
+	}
+	if modes.ApplicationKeypad {
+		if seq, ok := applicationKeypadKeys[name]; ok {
+			return KeyMapping{Token: input, Bytes: seq, Key: true, Mode: KeyModeApplicationKeypad}
+		}
+	}
+	if seq, ok := p.Keys[name]; ok {
+		return KeyMapping{Token: input, Bytes: seq, Key: true, Mode: KeyModeNormal}
+	}
+	if seq, ok := table[name]; ok {
+		return KeyMapping{Token: input, Bytes: seq, Key: true, Mode: KeyModeNormal}
+	}
+
+	// Send the input as-is if it's not a special key
+	return KeyMapping{Token: input, Bytes: input}
+}
+
+// EscapeBytes renders bytes readably: printable text as-is, ESC as \x1b,
+// \r, \n and \t as such, a backslash doubled and any other byte as \xNN
+func EscapeBytes(data string) string {
+	var out strings.Builder
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '\\':
+			out.WriteString(`\\`)
+		case c == '\r':
+			out.WriteString(`\r`)
+		case c == '\n':
+			out.WriteString(`\n`)
+		case c == '\t':
+			out.WriteString(`\t`)
+		case c >= 0x20 && c < 0x7f:
+			out.WriteByte(c)
+		default:
+			fmt.Fprintf(&out, `\x%02x`, c)
+		}
+	}
+	return out.String()
+}
+
+// HexBytes renders bytes as space-separated hex pairs, e.g. "1b 5b 41"
+func HexBytes(data string) string {
+	return fmt.Sprintf("% x", data)
+}
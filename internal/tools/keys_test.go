@@ -0,0 +1,153 @@
+package tools
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/session"
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+)
+
+func TestMapKeysForModes(t *testing.T) {
+	normal := terminal.InputModes{}
+	cursor := terminal.InputModes{ApplicationCursorKeys: true}
+	keypad := terminal.InputModes{ApplicationKeypad: true}
+
+	tests := []struct {
+		name  string
+		keys  string
+		modes terminal.InputModes
+		want  string
+	}{
+		{"arrow", "Up", normal, "\x1b[A"},
+		{"application arrow", "Up", cursor, "\x1bOA"},
+		{"lowercase application arrow", "left", cursor, "\x1bOD"},
+		{"application home", "Home", cursor, "\x1bOH"},
+		{"application end", "End", cursor, "\x1bOF"},
+		{"arrow with keypad mode", "Down", keypad, "\x1b[B"},
+		{"page keys unaffected", "PageUp", cursor, "\x1b[5~"},
+		{"keypad digit", "Keypad7", normal, "7"},
+		{"application keypad digit", "Keypad7", keypad, "\x1bOw"},
+		{"application keypad enter", "KeypadEnter", keypad, "\x1bOM"},
+		{"keypad with cursor mode", "KeypadMinus", cursor, "-"},
+		{"text", "Up and away", cursor, "Up and away"},
+		{"EOF action", "EOF", normal, "\x04"},
+		{"interrupt action", "Interrupt", cursor, "\x03"},
+		{"quit action", "Quit", normal, "\x1c"},
+		{"suspend action", "Suspend", keypad, "\x1a"},
+		{"ctrl backslash", "Ctrl+\\", normal, "\x1c"},
+		{"action word as text", "quit", normal, "quit"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MapKeysForModes(tt.keys, tt.modes); got != tt.want {
+				t.Errorf("MapKeysForModes(%q, %+v) = %q, want %q", tt.keys, tt.modes, got, tt.want)
+			}
+		})
+	}
+
+	if got := MapKeys("Up"); got != "\x1b[A" {
+		t.Errorf("MapKeys(Up) = %q, want the normal mode sequence", got)
+	}
+}
+
+func TestMapKeyTokens(t *testing.T) {
+	cursor := terminal.InputModes{ApplicationCursorKeys: true}
+	keypad := terminal.InputModes{ApplicationKeypad: true}
+
+	tests := []struct {
+		name  string
+		keys  string
+		modes terminal.InputModes
+		want  []KeyMapping
+	}{
+		{"empty", "", cursor, nil},
+		{"text", "hello", cursor, []KeyMapping{{Token: "hello", Bytes: "hello"}}},
+		{"normal key", "Enter", cursor, []KeyMapping{{Token: "Enter", Bytes: "\r", Key: true, Mode: KeyModeNormal}}},
+		{"normal arrow", "Up", terminal.InputModes{}, []KeyMapping{{Token: "Up", Bytes: "\x1b[A", Key: true, Mode: KeyModeNormal}}},
+		{"application arrow", "up", cursor, []KeyMapping{{Token: "up", Bytes: "\x1bOA", Key: true, Mode: KeyModeApplicationCursor}}},
+		{"application keypad", "KeypadEnter", keypad, []KeyMapping{{Token: "KeypadEnter", Bytes: "\x1bOM", Key: true, Mode: KeyModeApplicationKeypad}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := MapKeyTokens(tt.keys, tt.modes)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MapKeyTokens(%q, %+v) = %+v, want %+v", tt.keys, tt.modes, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEscapeBytes(t *testing.T) {
+	tests := []struct {
+		data    string
+		escaped string
+		hex     string
+	}{
+		{"abc", "abc", "61 62 63"},
+		{"\x1bOA", `\x1bOA`, "1b 4f 41"},
+		{"\r\n\t", `\r\n\t`, "0d 0a 09"},
+		{`a\b`, `a\\b`, "61 5c 62"},
+		{"\x03\x7f", `\x03\x7f`, "03 7f"},
+		{"é", `\xc3\xa9`, "c3 a9"},
+		{"", "", ""},
+	}
+
+	for _, tt := range tests {
+		if got := EscapeBytes(tt.data); got != tt.escaped {
+			t.Errorf("EscapeBytes(%q) = %q, want %q", tt.data, got, tt.escaped)
+		}
+		if got := HexBytes(tt.data); got != tt.hex {
+			t.Errorf("HexBytes(%q) = %q, want %q", tt.data, got, tt.hex)
+		}
+	}
+}
+
+func TestKeyProfiles(t *testing.T) {
+	if err := validateKeyProfiles(); err != nil {
+		t.Fatalf("Key profiles are invalid: %v", err)
+	}
+
+	normal := terminal.InputModes{}
+	cursor := terminal.InputModes{ApplicationCursorKeys: true}
+
+	tests := []struct {
+		key   string
+		modes terminal.InputModes
+		xterm string
+		linux string
+		vt100 string
+	}{
+		{"Home", normal, "\x1b[H", "\x1b[1~", "\x1b[1~"},
+		{"End", normal, "\x1b[F", "\x1b[4~", "\x1b[4~"},
+		{"F1", normal, "\x1bOP", "\x1b[[A", "\x1bOP"},
+		{"F5", normal, "\x1b[15~", "\x1b[[E", "\x1b[15~"},
+		{"F6", normal, "\x1b[17~", "\x1b[17~", "\x1b[17~"},
+		// Home and End only follow application cursor mode in xterm
+		{"Home", cursor, "\x1bOH", "\x1b[1~", "\x1b[1~"},
+		{"end", cursor, "\x1bOF", "\x1b[4~", "\x1b[4~"},
+		{"Up", cursor, "\x1bOA", "\x1bOA", "\x1bOA"},
+		{"Backspace", normal, "\x7f", "\x7f", "\x08"},
+		{"Delete", normal, "\x1b[3~", "\x1b[3~", "\x7f"},
+		{"Interrupt", normal, "\x03", "\x03", "\x03"},
+		{"text", normal, "text", "text", "text"},
+	}
+
+	for _, tt := range tests {
+		for profile, want := range map[string]string{
+			session.KeyProfileXterm:        tt.xterm,
+			session.KeyProfileLinuxConsole: tt.linux,
+			session.KeyProfileVT100:        tt.vt100,
+		} {
+			if got := LookupKeyProfile(profile).MapKeys(tt.key, tt.modes); got != want {
+				t.Errorf("%s under %s with %+v = %q, want %q", tt.key, profile, tt.modes, got, want)
+			}
+		}
+	}
+
+	if LookupKeyProfile("") != LookupKeyProfile(session.KeyProfileXterm) {
+		t.Error("Expected xterm's profile for an empty name")
+	}
+}
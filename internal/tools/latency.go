@@ -0,0 +1,183 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/session"
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// latencyTimeoutCode marks a measure_latency call whose keys didn't change
+// the screen in time
+const latencyTimeoutCode = "latency_timeout"
+
+// Limits for measure_latency
+const (
+	defaultLatencyRepetitions = 5
+	maxLatencyRepetitions     = 100
+	defaultLatencyTimeoutMs   = 5000
+
+	// latencySettle is how long the screen must hold still before each
+	// press, so output still arriving from the last one isn't timed
+	latencySettle = 50 * time.Millisecond
+)
+
+// MeasureLatency sends the same keys several times and times each press
+// from the write to the PTY until the screen next changes. The wait is on
+// the buffer's change notification, not a poll, so the times are as close
+// as the read loop gets.
+func (h *Handlers) MeasureLatency(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "measure_latency", args)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, _, err := GetString(args, "keys")
+	if err != nil {
+		return nil, invalidParam(ctx, "measure_latency", err)
+	}
+	if err := validateKeys(keys, h.maxInput); err != nil {
+		return nil, invalidParam(ctx, "measure_latency", err)
+	}
+	repetitions, hasRepetitions, err := GetInt(args, "repetitions")
+	if err != nil {
+		return nil, invalidParam(ctx, "measure_latency", err)
+	}
+	if !hasRepetitions {
+		repetitions = defaultLatencyRepetitions
+	}
+	if repetitions < 1 || repetitions > maxLatencyRepetitions {
+		return nil, invalidParam(ctx, "measure_latency", fmt.Errorf("repetitions must be between 1 and %d", maxLatencyRepetitions))
+	}
+	timeoutMs, hasTimeout, err := GetInt(args, "timeout_ms")
+	if err != nil {
+		return nil, invalidParam(ctx, "measure_latency", err)
+	}
+	if !hasTimeout {
+		timeoutMs = defaultLatencyTimeoutMs
+	}
+	if timeoutMs < 1 || timeoutMs > maxWaitMs {
+		return nil, invalidParam(ctx, "measure_latency", fmt.Errorf("timeout_ms must be between 1 and %d", maxWaitMs))
+	}
+	timeout := time.Duration(timeoutMs) * time.Millisecond
+
+	utils.LogToolCall(ctx, "measure_latency", sess.ID,
+		slog.Int("key_count", len(keys)),
+		slog.Int("repetitions", repetitions),
+		slog.Int("timeout_ms", timeoutMs),
+	)
+
+	mapped := MapSessionKeys(sess, keys)
+	samples := make([]float64, 0, repetitions)
+	for i := 0; i < repetitions; i++ {
+		// Each wait gets the timeout; the session closing or restarting
+		// ends it early
+		iterCtx, cancel := context.WithTimeout(ctx, timeout)
+		runCtx, unbind := sess.Bind(iterCtx)
+		ms, stage, err := h.timePress(runCtx, sess, mapped, args)
+		unbind()
+		cancel()
+
+		if err == nil {
+			samples = append(samples, ms)
+			continue
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		switch stage {
+		case "begin":
+			return operationError(ctx, "measure_latency", err)
+		case "send":
+			utils.LogErrorContext(ctx, err, "Failed to send keys",
+				slog.String("tool", "measure_latency"),
+				slog.String("session_id", sess.ID),
+			)
+			if errors.Is(err, terminal.ErrInputBlocked) {
+				return inputBlockedResult(err, 0), nil
+			}
+			return nil, err
+		}
+
+		// Out of time, or the session closed or restarted
+		switch {
+		case iterCtx.Err() == nil:
+			err = context.Cause(runCtx)
+		case stage == "settle":
+			err = fmt.Errorf("screen still changing after %d ms, before press %d", timeoutMs, i+1)
+		default:
+			err = fmt.Errorf("screen didn't change within %d ms of press %d", timeoutMs, i+1)
+		}
+		code := latencyTimeoutCode
+		if stage == "settle" {
+			code = screenNotStableCode
+		}
+		return toolErrorResult(err, code, map[string]interface{}{
+			"session_id": sess.ID,
+			"samples_ms": samples,
+		}), nil
+	}
+
+	return jsonResult(latencyResponse(sess.ID, samples))
+}
+
+// timePress waits for the screen to settle, sends keys and returns the
+// milliseconds until the screen changed. On failure it names the stage:
+// settle, begin, send or change.
+func (h *Handlers) timePress(ctx context.Context, sess *session.Session, keys string, args map[string]interface{}) (float64, string, error) {
+	if _, err := sess.Buffer.WaitStable(ctx, latencySettle, 0, -1); err != nil {
+		return 0, "settle", err
+	}
+
+	// Listen before reading the generation so a change made in between
+	// isn't missed
+	changed := sess.Buffer.Changed()
+	generation := sess.Buffer.Generation()
+
+	opCtx, done, err := beginOperation(ctx, "measure_latency", sess, session.OpShared, args)
+	if err != nil {
+		return 0, "begin", err
+	}
+	start := h.now()
+	_, err = sess.SendKeys(opCtx, keys)
+	done()
+	if err != nil {
+		return 0, "send", err
+	}
+
+	for sess.Buffer.Generation() == generation {
+		select {
+		case <-ctx.Done():
+			return 0, "change", ctx.Err()
+		case <-changed:
+			changed = sess.Buffer.Changed()
+		}
+	}
+	return float64(h.since(start).Microseconds()) / 1000, "", nil
+}
+
+// latencyResponse summarizes the times of every press
+func latencyResponse(sessionID string, samples []float64) MeasureLatencyResponse {
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	median := sorted[n/2]
+	if n%2 == 0 {
+		median = (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+	return MeasureLatencyResponse{
+		SessionID: sessionID,
+		SamplesMs: samples,
+		MinMs:     sorted[0],
+		MedianMs:  median,
+		MaxMs:     sorted[n-1],
+	}
+}
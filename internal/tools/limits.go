@@ -0,0 +1,82 @@
+package tools
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+)
+
+// Terminal size bounds. The maximums can be raised with MAX_TERMINAL_WIDTH
+// and MAX_TERMINAL_HEIGHT for testing very large virtual terminals.
+const (
+	MinDimension     = 1
+	DefaultMaxWidth  = 500
+	DefaultMaxHeight = 200
+)
+
+// DimensionLimits bounds the terminal sizes accepted by launch_app,
+// resize_terminal and duplicate_session. The same values are advertised in
+// the tool schemas so clients and the validator agree.
+type DimensionLimits struct {
+	MaxWidth  int
+	MaxHeight int
+}
+
+// DimensionLimitsFromEnv returns the default limits with any environment
+// overrides applied. Invalid overrides are logged and ignored.
+func DimensionLimitsFromEnv() DimensionLimits {
+	return DimensionLimits{
+		MaxWidth:  envLimit("MAX_TERMINAL_WIDTH", DefaultMaxWidth),
+		MaxHeight: envLimit("MAX_TERMINAL_HEIGHT", DefaultMaxHeight),
+	}
+}
+
+// DefaultMaxInputBytes bounds the input one send_keys or send_raw_bytes
+// call may deliver, unless MCP_MAX_INPUT_BYTES sets another limit. It is
+// large enough to paste a sizable file into an editor under test.
+const DefaultMaxInputBytes = 1 << 20
+
+// MaxInputBytesFromEnv returns the per-call input limit, applying any
+// MCP_MAX_INPUT_BYTES override. Invalid overrides are logged and ignored.
+func MaxInputBytesFromEnv() int {
+	return envLimit("MCP_MAX_INPUT_BYTES", DefaultMaxInputBytes)
+}
+
+// DefaultMaxOutputBytes bounds the content one view_screen call returns,
+// unless MCP_MAX_OUTPUT_BYTES or the call's max_bytes sets another limit.
+// It keeps a large scrollback render within typical MCP message limits.
+const DefaultMaxOutputBytes = 1 << 20
+
+// MaxOutputBytesFromEnv returns the view_screen content limit, applying any
+// MCP_MAX_OUTPUT_BYTES override. Invalid overrides are logged and ignored.
+func MaxOutputBytesFromEnv() int {
+	return envLimit("MCP_MAX_OUTPUT_BYTES", DefaultMaxOutputBytes)
+}
+
+func envLimit(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		slog.Warn("Ignoring invalid limit",
+			slog.String("variable", name),
+			slog.String("value", value),
+		)
+		return def
+	}
+	return n
+}
+
+// Validate checks that a terminal size is within the limits
+func (l DimensionLimits) Validate(width, height int) error {
+	if width < MinDimension || width > l.MaxWidth {
+		return fmt.Errorf("width must be between %d and %d", MinDimension, l.MaxWidth)
+	}
+	if height < MinDimension || height > l.MaxHeight {
+		return fmt.Errorf("height must be between %d and %d", MinDimension, l.MaxHeight)
+	}
+	return nil
+}
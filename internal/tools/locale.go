@@ -0,0 +1,71 @@
+package tools
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/session"
+)
+
+// DefaultLocales are the locales launch_app accepts unless
+// MCP_ALLOWED_LOCALES lists others: the C locale, UTF-8 and a single-byte
+// codeset, the usual cases for reproducing locale bugs
+var DefaultLocales = []string{"C", "POSIX", "C.UTF-8", "en_US.UTF-8", "en_US.ISO-8859-1"}
+
+// LocalesFromEnv returns the locales launch_app accepts, set with
+// MCP_ALLOWED_LOCALES as a comma-separated list. Whether a locale is
+// installed isn't checked; the C library falls back to C for one that isn't.
+func LocalesFromEnv() []string {
+	value := os.Getenv("MCP_ALLOWED_LOCALES")
+	if value == "" {
+		return DefaultLocales
+	}
+	var locales []string
+	for _, locale := range strings.Split(value, ",") {
+		if locale = strings.TrimSpace(locale); locale != "" {
+			locales = append(locales, locale)
+		}
+	}
+	if len(locales) == 0 {
+		return DefaultLocales
+	}
+	return locales
+}
+
+// validateLocale checks a launch_app locale against the allowed set. The
+// env parameter can't set the same variables, as the two would disagree.
+func (h *Handlers) validateLocale(locale string, env map[string]string) error {
+	allowed := false
+	for _, l := range h.locales {
+		if locale == l {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("locale %q is not allowed, allowed locales: %s", locale, strings.Join(h.locales, ", "))
+	}
+	for _, name := range session.LocaleVars {
+		if _, ok := env[name]; ok {
+			return fmt.Errorf("locale can't be combined with %s in env", name)
+		}
+	}
+	return nil
+}
+
+// validateTimezone checks a launch_app timezone: an IANA name the server
+// knows, such as UTC or Europe/Vienna
+func validateTimezone(timezone string, env map[string]string) error {
+	if timezone == "Local" || strings.ContainsAny(timezone, "\x00\n") || strings.HasPrefix(timezone, ":") {
+		return fmt.Errorf("invalid timezone %q", timezone)
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return fmt.Errorf("unknown timezone %q", timezone)
+	}
+	if _, ok := env["TZ"]; ok {
+		return fmt.Errorf("timezone can't be combined with TZ in env")
+	}
+	return nil
+}
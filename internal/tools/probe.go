@@ -0,0 +1,299 @@
+package tools
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/session"
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// maxProbeVars caps the environment variables one probe_shell call reads
+const maxProbeVars = 32
+
+// envVarName is what a shell accepts as a variable name
+var envVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// shellProbe is one probe's delimiters. The typed command holds their parts
+// apart, so only the shell's output has them whole and the echo can't be
+// mistaken for the result.
+type shellProbe struct {
+	token string
+}
+
+func newShellProbe() (shellProbe, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return shellProbe{}, fmt.Errorf("failed to generate probe token: %w", err)
+	}
+	return shellProbe{token: hex.EncodeToString(b)}, nil
+}
+
+func (p shellProbe) begin() string { return "<<probe-" + p.token + ">>" }
+func (p shellProbe) end() string   { return "<<end-" + p.token + ">>" }
+
+// command returns the line to type. It prints, on a line of its own, the
+// last exit status, the working directory and each variable, values hex
+// encoded so quotes, blanks and newlines survive the screen. A variable that
+// isn't set has no "=". The leading blank keeps it out of bash's history
+// with HISTCONTROL=ignorespace; the helpers are unset again and $? restored
+// at the end.
+func (p shellProbe) command(vars []string) string {
+	var b strings.Builder
+	b.WriteString(` __mcp_s=$?; __mcp_x() { printf %s "$1" | od -An -tx1 | tr -d ' \n'; };`)
+	fmt.Fprintf(&b, ` printf '\n<<%%s>>status=%%s;pwd=%%s' probe-%s "$__mcp_s" "$(__mcp_x "$PWD")";`, p.token)
+	for _, name := range vars {
+		fmt.Fprintf(&b, ` printf ';%[1]s%%s' "${%[1]s+=$(__mcp_x "$%[1]s")}";`, name)
+	}
+	fmt.Fprintf(&b, ` printf '<<%%s>>\n' end-%s;`, p.token)
+	b.WriteString(` eval "unset -f __mcp_x; unset __mcp_s; (exit $__mcp_s)"`)
+	return b.String()
+}
+
+// find looks for the probe's output in the transcript from line start on,
+// joining lines so output wrapped at the width reads whole. It returns what
+// is between the delimiters and the number of the line the end one finishes
+// on.
+func (p shellProbe) find(t terminal.Transcript, start int) (payload string, endLine int, ok bool) {
+	from := max(start, t.First) - t.First
+	if from >= len(t.Lines) {
+		return "", 0, false
+	}
+	var text strings.Builder
+	ends := make([]int, 0, len(t.Lines)-from) // Offset each line ends at
+	for _, line := range t.Lines[from:] {
+		text.WriteString(line)
+		ends = append(ends, text.Len())
+	}
+	s := text.String()
+	b := strings.Index(s, p.begin())
+	if b < 0 {
+		return "", 0, false
+	}
+	b += len(p.begin())
+	e := strings.Index(s[b:], p.end())
+	if e < 0 {
+		return "", 0, false
+	}
+	e += b
+	last := e + len(p.end())
+	for i, end := range ends {
+		if last <= end {
+			endLine = t.First + from + i
+			break
+		}
+	}
+	return s[b:e], endLine, true
+}
+
+// probeResult is a parsed probe
+type probeResult struct {
+	Status int
+	Cwd    string
+	Env    map[string]*string // nil, or null in JSON, for variables that aren't set
+}
+
+// parseProbe reads a probe's payload: ";"-separated fields, "status" in
+// decimal and the rest hex encoded
+func parseProbe(payload string) (probeResult, error) {
+	r := probeResult{Env: map[string]*string{}}
+	fields := strings.Split(payload, ";")
+	if len(fields) < 2 {
+		return r, fmt.Errorf("malformed probe output %q", payload)
+	}
+	status, ok := strings.CutPrefix(fields[0], "status=")
+	if !ok {
+		return r, fmt.Errorf("malformed probe output %q", payload)
+	}
+	var err error
+	if r.Status, err = strconv.Atoi(status); err != nil {
+		return r, fmt.Errorf("malformed exit status %q", status)
+	}
+	for _, field := range fields[1:] {
+		name, value, set := strings.Cut(field, "=")
+		var decoded []byte
+		if set {
+			if decoded, err = hex.DecodeString(value); err != nil {
+				return r, fmt.Errorf("malformed value for %s: %w", name, err)
+			}
+		}
+		if name == "pwd" {
+			r.Cwd = string(decoded)
+			continue
+		}
+		if !set {
+			r.Env[name] = nil
+			continue
+		}
+		v := string(decoded)
+		r.Env[name] = &v
+	}
+	return r, nil
+}
+
+// withoutLines returns the screen's lines, trailing spaces trimmed, leaving
+// out the ones numbered from to through
+func withoutLines(t terminal.Transcript, height, from, to int) string {
+	lines := make([]string, 0, height)
+	for i := max(len(t.Lines)-height, 0); i < len(t.Lines); i++ {
+		if n := t.First + i; n >= from && n <= to {
+			continue
+		}
+		lines = append(lines, t.Lines[i])
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), " \n")
+}
+
+// ProbeShell types a command into a shell session that prints its working
+// directory, last exit status and chosen environment variables between
+// unique delimiters, and returns them parsed
+func (h *Handlers) ProbeShell(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "probe_shell", args)
+	if err != nil {
+		return nil, err
+	}
+	if !sess.Shell {
+		return nil, fmt.Errorf("session %s was not launched with shell set; probe_shell only types into shells", sess.ID)
+	}
+
+	vars, _, err := GetStringSlice(args, "vars")
+	if err != nil {
+		return nil, invalidParam(ctx, "probe_shell", err)
+	}
+	if len(vars) > maxProbeVars {
+		return nil, invalidParam(ctx, "probe_shell", fmt.Errorf("at most %d vars may be probed", maxProbeVars))
+	}
+	for _, name := range vars {
+		if !envVarName.MatchString(name) || name == "pwd" || name == "status" {
+			return nil, invalidParam(ctx, "probe_shell", fmt.Errorf("invalid variable name %q", name))
+		}
+	}
+	prompt, err := promptPattern(sess, args)
+	if err != nil {
+		return nil, invalidParam(ctx, "probe_shell", err)
+	}
+	timeoutMs, hasTimeout, err := GetInt(args, "timeout_ms")
+	if err != nil {
+		return nil, invalidParam(ctx, "probe_shell", err)
+	}
+	if !hasTimeout {
+		timeoutMs = defaultWaitMs
+	}
+	if timeoutMs < 1 || timeoutMs > maxWaitMs {
+		return nil, invalidParam(ctx, "probe_shell", fmt.Errorf("timeout_ms must be between 1 and %d", maxWaitMs))
+	}
+	includeScreen, _, err := GetBool(args, "include_screen")
+	if err != nil {
+		return nil, invalidParam(ctx, "probe_shell", err)
+	}
+
+	probe, err := newShellProbe()
+	if err != nil {
+		return nil, err
+	}
+	command := probe.command(vars)
+	if len(command)+1 > h.maxInput {
+		return nil, invalidParam(ctx, "probe_shell", fmt.Errorf("probe command is %d bytes, over the input limit of %d bytes (MCP_MAX_INPUT_BYTES); probe fewer vars", len(command), h.maxInput))
+	}
+
+	utils.LogToolCall(ctx, "probe_shell", sess.ID,
+		slog.Int("vars", len(vars)),
+		slog.Int("timeout_ms", timeoutMs),
+	)
+
+	// The call ends when its time is up or the session closes or restarts
+	callCtx, cancel := context.WithTimeout(ctx, time.Duration(timeoutMs)*time.Millisecond)
+	defer cancel()
+	runCtx, unbind := sess.Bind(callCtx)
+	defer unbind()
+
+	start := h.now()
+	timedOut := func(stage string, t terminal.Transcript, err error) (*mcp.CallToolResult, error) {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		switch {
+		case callCtx.Err() != nil && stage == "before sending":
+			err = fmt.Errorf("no prompt matching %q %s within %d ms", prompt.String(), stage, timeoutMs)
+		case callCtx.Err() != nil:
+			err = fmt.Errorf("no probe output and prompt matching %q %s within %d ms", prompt.String(), stage, timeoutMs)
+		case runCtx.Err() != nil:
+			err = context.Cause(runCtx)
+		}
+		screen, _ := sess.Buffer.Render("plain")
+		return toolErrorResult(err, promptTimeoutCode, map[string]interface{}{
+			"session_id":  sess.ID,
+			"stage":       stage,
+			"prompt_text": promptText(t),
+			"screen":      screen,
+			"elapsed_ms":  h.since(start).Milliseconds(),
+		}), nil
+	}
+
+	// Only type at a prompt, not into a running command
+	before, err := sess.Buffer.WaitTranscript(runCtx, func(t terminal.Transcript) bool {
+		return atPrompt(t, prompt)
+	})
+	if err != nil {
+		return timedOut("before sending", before, err)
+	}
+
+	opCtx, done, err := beginOperation(runCtx, "probe_shell", sess, session.OpShared, args)
+	if err != nil {
+		return operationError(ctx, "probe_shell", err)
+	}
+	written, err := sess.SendKeys(opCtx, command+"\r")
+	done()
+	if err != nil {
+		utils.LogErrorContext(ctx, err, "Failed to send probe",
+			slog.String("tool", "probe_shell"),
+			slog.String("session_id", sess.ID),
+		)
+		if errors.Is(err, terminal.ErrInputBlocked) {
+			return inputBlockedResult(err, written), nil
+		}
+		return nil, err
+	}
+
+	// Done once the output is whole and the shell is back at a prompt
+	var payload string
+	var endLine int
+	after, err := sess.Buffer.WaitTranscript(runCtx, func(t terminal.Transcript) bool {
+		var ok bool
+		payload, endLine, ok = probe.find(t, before.CursorLine)
+		return ok && t.CursorLine > endLine && atPrompt(t, prompt)
+	})
+	if err != nil {
+		return timedOut("after sending", after, err)
+	}
+	result, err := parseProbe(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read probe output: %w", err)
+	}
+
+	response := ProbeShellResponse{
+		SessionID:  sess.ID,
+		Cwd:        result.Cwd,
+		ExitStatus: result.Status,
+		Env:        result.Env,
+		ElapsedMs:  h.since(start).Milliseconds(),
+	}
+	if includeScreen {
+		_, height := sess.GetScreenSize()
+		screen := withoutLines(after, height, before.CursorLine, endLine)
+		response.Screen = &screen
+	}
+
+	return jsonResult(response)
+}
@@ -0,0 +1,57 @@
+package tools
+
+import (
+	"testing"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+)
+
+func TestShellProbeFind(t *testing.T) {
+	p := shellProbe{token: "0123456789abcdef"}
+	sb := terminal.NewScreenBuffer(40, 10)
+	defer sb.Close()
+
+	// The echo has the delimiters' parts apart; the output, wrapped at the
+	// width, has them whole
+	sb.Write([]byte("$ " + p.command([]string{"FOO"}) + "\r\n"))
+	start := 0
+	if _, _, ok := p.find(sb.Transcript(), start); ok {
+		t.Fatal("Expected the echoed command not to count as output")
+	}
+	sb.Write([]byte("\r\n" + p.begin() + "status=2;pwd=2f746d70;FOO=6220612272;BAR" + p.end() + "\r\n$ "))
+
+	tr := sb.Transcript()
+	payload, endLine, ok := p.find(tr, start)
+	if !ok {
+		t.Fatalf("Expected the probe output to be found in %q", tr.Lines)
+	}
+	if endLine != tr.CursorLine-1 {
+		t.Errorf("Expected the output to end on line %d, got %d", tr.CursorLine-1, endLine)
+	}
+
+	r, err := parseProbe(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Status != 2 || r.Cwd != "/tmp" {
+		t.Errorf("Expected status 2 in /tmp, got %d in %q", r.Status, r.Cwd)
+	}
+	if foo := r.Env["FOO"]; foo == nil || *foo != `b a"r` {
+		t.Errorf("Expected FOO to be decoded, got %v", foo)
+	}
+	if bar, ok := r.Env["BAR"]; !ok || bar != nil {
+		t.Errorf("Expected BAR to be unset, got %v", bar)
+	}
+
+	if screen := withoutLines(tr, 10, start, endLine); screen != "$" {
+		t.Errorf("Expected only the new prompt to be left, got %q", screen)
+	}
+}
+
+func TestParseProbeMalformed(t *testing.T) {
+	for _, payload := range []string{"", "status=0", "status=x;pwd=", "status=0;pwd=zz"} {
+		if _, err := parseProbe(payload); err == nil {
+			t.Errorf("Expected %q to be rejected", payload)
+		}
+	}
+}
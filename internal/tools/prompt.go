@@ -0,0 +1,205 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/analyzer"
+	"github.com/bioharz/mcp-terminal-tester/internal/session"
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// promptTimeoutCode marks a run_at_prompt call that gave up waiting for a
+// prompt, before or after sending
+const promptTimeoutCode = "prompt_timeout"
+
+// atPrompt reports whether the cursor's line, up to the cursor, matches the
+// prompt pattern. The cut keeps the blanks a prompt ends with, which the
+// transcript trims.
+func atPrompt(t terminal.Transcript, prompt *regexp.Regexp) bool {
+	return prompt.MatchString(promptText(t))
+}
+
+// promptText returns the cursor's line up to the cursor
+func promptText(t terminal.Transcript) string {
+	i := t.CursorLine - t.First
+	if i < 0 || i >= len(t.Lines) {
+		return ""
+	}
+	line := []rune(t.Lines[i])
+	if len(line) >= t.CursorCol {
+		return string(line[:t.CursorCol])
+	}
+	return string(line) + strings.Repeat(" ", t.CursorCol-len(line))
+}
+
+// promptPattern returns the call's prompt_regex, else the session's
+// prompt_pattern option, else the default pattern
+func promptPattern(sess *session.Session, args map[string]interface{}) (*regexp.Regexp, error) {
+	pattern, _, err := GetString(args, "prompt_regex")
+	if err != nil {
+		return nil, err
+	}
+	if pattern == "" {
+		pattern = sess.StringOption(session.OptionPromptPattern)
+	}
+	if pattern == "" {
+		return analyzer.DefaultPromptPattern, nil
+	}
+	prompt, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prompt_regex: %w", err)
+	}
+	return prompt, nil
+}
+
+// commandOutput returns the lines between the prompt on line start and the
+// one the cursor is at now. The command's echo takes the first echoLines
+// of them. truncated is set when some of the lines are gone, scrolled out
+// of a full scrollback or cleared with the history.
+func commandOutput(t terminal.Transcript, start, echoLines int, includeEcho bool) (lines []string, truncated bool) {
+	from := start
+	if !includeEcho {
+		from += echoLines
+	}
+	if t.CursorLine <= start {
+		// The history was cleared, so its numbering started over
+		from, truncated = t.First, true
+	}
+	if from < t.First {
+		from, truncated = t.First, true
+	}
+	end := t.CursorLine
+	if from >= end {
+		return []string{}, truncated
+	}
+	return t.Lines[from-t.First : end-t.First], truncated
+}
+
+// RunAtPrompt waits for a prompt, types a command and Enter, waits for the
+// next prompt and returns what the command printed in between
+func (h *Handlers) RunAtPrompt(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "run_at_prompt", args)
+	if err != nil {
+		return nil, err
+	}
+
+	text, hasText, err := GetString(args, "text")
+	if err != nil {
+		return nil, invalidParam(ctx, "run_at_prompt", err)
+	}
+	if !hasText {
+		return nil, invalidParam(ctx, "run_at_prompt", fmt.Errorf("text parameter is required"))
+	}
+	if strings.ContainsAny(text, "\r\n") {
+		return nil, invalidParam(ctx, "run_at_prompt", fmt.Errorf("text must be a single line; Enter is sent after it"))
+	}
+	if len(text)+1 > h.maxInput {
+		return nil, invalidParam(ctx, "run_at_prompt", fmt.Errorf("text is %d bytes, over the input limit of %d bytes (MCP_MAX_INPUT_BYTES)", len(text), h.maxInput))
+	}
+	prompt, err := promptPattern(sess, args)
+	if err != nil {
+		return nil, invalidParam(ctx, "run_at_prompt", err)
+	}
+	timeoutMs, hasTimeout, err := GetInt(args, "timeout_ms")
+	if err != nil {
+		return nil, invalidParam(ctx, "run_at_prompt", err)
+	}
+	if !hasTimeout {
+		timeoutMs = defaultWaitMs
+	}
+	if timeoutMs < 1 || timeoutMs > maxWaitMs {
+		return nil, invalidParam(ctx, "run_at_prompt", fmt.Errorf("timeout_ms must be between 1 and %d", maxWaitMs))
+	}
+	includeEcho, _, err := GetBool(args, "include_echo")
+	if err != nil {
+		return nil, invalidParam(ctx, "run_at_prompt", err)
+	}
+
+	utils.LogToolCall(ctx, "run_at_prompt", sess.ID,
+		slog.Int("text_length", len(text)),
+		slog.Int("timeout_ms", timeoutMs),
+	)
+
+	// The call ends when its time is up or the session closes or restarts
+	callCtx, cancel := context.WithTimeout(ctx, time.Duration(timeoutMs)*time.Millisecond)
+	defer cancel()
+	runCtx, unbind := sess.Bind(callCtx)
+	defer unbind()
+
+	start := h.now()
+	timedOut := func(stage string, t terminal.Transcript, err error) (*mcp.CallToolResult, error) {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		switch {
+		case callCtx.Err() != nil:
+			err = fmt.Errorf("no prompt matching %q %s within %d ms", prompt.String(), stage, timeoutMs)
+		case runCtx.Err() != nil:
+			err = context.Cause(runCtx)
+		}
+		screen, _ := sess.Buffer.Render("plain")
+		return toolErrorResult(err, promptTimeoutCode, map[string]interface{}{
+			"session_id":  sess.ID,
+			"stage":       stage,
+			"prompt_text": promptText(t),
+			"screen":      screen,
+			"elapsed_ms":  h.since(start).Milliseconds(),
+		}), nil
+	}
+
+	before, err := sess.Buffer.WaitTranscript(runCtx, func(t terminal.Transcript) bool {
+		return atPrompt(t, prompt)
+	})
+	if err != nil {
+		return timedOut("before sending", before, err)
+	}
+
+	opCtx, done, err := beginOperation(runCtx, "run_at_prompt", sess, session.OpShared, args)
+	if err != nil {
+		return operationError(ctx, "run_at_prompt", err)
+	}
+	written, err := sess.SendKeys(opCtx, text+"\r")
+	done()
+	if err != nil {
+		utils.LogErrorContext(ctx, err, "Failed to send command",
+			slog.String("tool", "run_at_prompt"),
+			slog.String("session_id", sess.ID),
+		)
+		if errors.Is(err, terminal.ErrInputBlocked) {
+			return inputBlockedResult(err, written), nil
+		}
+		return nil, err
+	}
+
+	// The next prompt is on a later line: the echoed Enter moved the
+	// cursor off the first one
+	after, err := sess.Buffer.WaitTranscript(runCtx, func(t terminal.Transcript) bool {
+		return t.CursorLine != before.CursorLine && atPrompt(t, prompt)
+	})
+	if err != nil {
+		return timedOut("after sending", after, err)
+	}
+
+	// The echo is the prompt and the command, wrapped at the width
+	width, _ := sess.GetScreenSize()
+	echoLines := (before.CursorCol+len([]rune(text)))/width + 1
+	lines, truncated := commandOutput(after, before.CursorLine, echoLines, includeEcho)
+
+	return jsonResult(RunAtPromptResponse{
+		SessionID: sess.ID,
+		Output:    strings.Join(lines, "\n"),
+		Lines:     len(lines),
+		Truncated: truncated,
+		Prompt:    promptText(after),
+		ElapsedMs: h.since(start).Milliseconds(),
+	})
+}
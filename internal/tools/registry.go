@@ -0,0 +1,220 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// BatchTool is the name of the tool that runs other tools in one call
+const BatchTool = "batch"
+
+// maxBatchCalls bounds the calls one batch may make
+const maxBatchCalls = 50
+
+// HandlerFunc handles one tool call
+type HandlerFunc func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error)
+
+// Registry dispatches tool calls by name to the handlers of a set of
+// specs. It always has the batch tool, which runs its calls through the
+// same registry, so whatever wraps the handlers also wraps each call of a
+// batch.
+type Registry struct {
+	specs []ToolSpec
+	index map[string]int // Position of each tool in specs
+}
+
+// NewRegistry returns a registry of the given tools followed by batch. A
+// tool given twice keeps its first position and its last spec.
+func NewRegistry(specs ...ToolSpec) *Registry {
+	r := &Registry{index: make(map[string]int)}
+	for _, spec := range append(specs, r.batchSpec()) {
+		if i, ok := r.index[spec.Name]; ok {
+			r.specs[i] = spec
+			continue
+		}
+		r.index[spec.Name] = len(r.specs)
+		r.specs = append(r.specs, spec)
+	}
+	return r
+}
+
+// Registry returns a registry of every tool h serves, for calling them
+// in-process without the MCP server
+func (h *Handlers) Registry() *Registry {
+	return NewRegistry(h.Specs()...)
+}
+
+// Specs returns the registered tools in order
+func (r *Registry) Specs() []ToolSpec {
+	return append([]ToolSpec(nil), r.specs...)
+}
+
+// Names returns the names of the registered tools in order
+func (r *Registry) Names() []string {
+	names := make([]string, len(r.specs))
+	for i, spec := range r.specs {
+		names[i] = spec.Name
+	}
+	return names
+}
+
+// Wrap replaces each handler with wrap's result, e.g. to add auditing to
+// every tool
+func (r *Registry) Wrap(wrap func(name string, handler HandlerFunc) HandlerFunc) {
+	for i := range r.specs {
+		r.specs[i].Handler = wrap(r.specs[i].Name, r.specs[i].Handler)
+	}
+}
+
+// Call runs the named tool with args
+func (r *Registry) Call(ctx context.Context, name string, args map[string]interface{}) (*mcp.CallToolResult, error) {
+	i, ok := r.index[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown tool: %s", name)
+	}
+	request := mcp.CallToolRequest{Params: mcp.CallToolParams{Name: name, Arguments: args}}
+	return r.specs[i].Handler(ctx, request)
+}
+
+// batchSpec describes the batch tool, served by r
+func (r *Registry) batchSpec() ToolSpec {
+	return ToolSpec{
+		Name:        BatchTool,
+		Description: "Run several tool calls in order in one request and return each result or error",
+		Params: []mcp.ToolOption{
+			mcp.WithArray("calls",
+				mcp.Required(),
+				mcp.Description(fmt.Sprintf("Calls to make, each {tool, arguments}; at most %d, and batch can't be nested", maxBatchCalls)),
+				mcp.Items(map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"tool":      map[string]any{"type": "string"},
+						"arguments": map[string]any{"type": "object"},
+					},
+					"required": []string{"tool"},
+				}),
+			),
+			mcp.WithBoolean("stop_on_error",
+				mcp.Description("Skip the remaining calls after one fails (default false)"),
+			),
+		},
+		Handler: r.Batch,
+	}
+}
+
+// batchCall is one entry of a batch
+type batchCall struct {
+	tool string
+	args map[string]interface{}
+}
+
+// parseBatchCalls validates the calls of a batch. Each names a registered
+// tool other than batch itself.
+func (r *Registry) parseBatchCalls(raw []map[string]interface{}) ([]batchCall, error) {
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("calls must not be empty")
+	}
+	if len(raw) > maxBatchCalls {
+		return nil, fmt.Errorf("calls has %d calls, at most %d are allowed", len(raw), maxBatchCalls)
+	}
+
+	calls := make([]batchCall, 0, len(raw))
+	for i, item := range raw {
+		tool, _, err := GetString(item, "tool")
+		if err != nil {
+			return nil, fmt.Errorf("calls[%d]: %w", i, err)
+		}
+		if tool == BatchTool {
+			return nil, fmt.Errorf("calls[%d]: batch cannot be nested", i)
+		}
+		if _, ok := r.index[tool]; !ok {
+			return nil, fmt.Errorf("calls[%d]: unknown tool %q", i, tool)
+		}
+		var args map[string]interface{}
+		if raw, ok := item["arguments"]; ok && raw != nil {
+			if args, ok = raw.(map[string]interface{}); !ok {
+				return nil, fmt.Errorf("calls[%d]: arguments must be an object, got %s", i, typeName(raw))
+			}
+		}
+		calls = append(calls, batchCall{tool: tool, args: args})
+	}
+	return calls, nil
+}
+
+// Batch runs several tool calls in order and returns each one's result or
+// error, stopping at the first failure when stop_on_error is set
+func (r *Registry) Batch(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	raw, hasCalls, err := GetObjectSlice(args, "calls")
+	if err != nil {
+		return nil, invalidParam(ctx, BatchTool, err)
+	}
+	if !hasCalls {
+		return nil, invalidParam(ctx, BatchTool, fmt.Errorf("calls parameter is required"))
+	}
+	calls, err := r.parseBatchCalls(raw)
+	if err != nil {
+		return nil, invalidParam(ctx, BatchTool, err)
+	}
+	stopOnError, _, err := GetBool(args, "stop_on_error")
+	if err != nil {
+		return nil, invalidParam(ctx, BatchTool, err)
+	}
+
+	utils.LogToolCall(ctx, BatchTool, "", slog.Int("calls", len(calls)))
+
+	results := make([]BatchEntry, 0, len(calls))
+	failed := 0
+	for _, call := range calls {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		result, err := r.Call(ctx, call.tool, call.args)
+		entry := BatchEntry{Tool: call.tool, Success: true}
+		switch {
+		case err != nil:
+			entry.Success = false
+			entry.Error = err.Error()
+		case result != nil && result.IsError:
+			entry.Success = false
+			entry.Error = resultContent(result)
+		default:
+			entry.Result = resultContent(result)
+		}
+		results = append(results, entry)
+
+		if !entry.Success {
+			failed++
+			if stopOnError {
+				break
+			}
+		}
+	}
+
+	return jsonResult(BatchResponse{
+		Results: results,
+		Failed:  failed,
+		Skipped: len(calls) - len(results),
+	})
+}
+
+// resultContent returns a tool result's text, decoded when it is JSON
+func resultContent(result *mcp.CallToolResult) interface{} {
+	if result == nil || len(result.Content) == 0 {
+		return nil
+	}
+	text, ok := result.Content[0].(mcp.TextContent)
+	if !ok {
+		return nil
+	}
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(text.Text), &decoded); err != nil {
+		return text.Text
+	}
+	return decoded
+}
@@ -0,0 +1,537 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/analyzer"
+	"github.com/bioharz/mcp-terminal-tester/internal/session"
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Responses of the tools, marshaled into their results' text content. Tools
+// that return a record the session package already defines, such as
+// get_session_info, get_buffer_info and get_process_info, return that type
+// as it is.
+
+// jsonResult marshals a tool's response into the text content of its result
+func jsonResult(response interface{}) (*mcp.CallToolResult, error) {
+	respData, err := json.Marshal(response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal response: %w", err)
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: string(respData),
+			},
+		},
+	}, nil
+}
+
+// CursorPosition is a 0-based cursor position
+type CursorPosition struct {
+	Row    int `json:"row"`
+	Col    int `json:"col"`
+	Origin int `json:"origin"` // Always 0: rows and columns count from zero
+}
+
+// CursorState is a cursor position with whether the cursor is shown
+type CursorState struct {
+	CursorPosition
+	Visible bool `json:"visible"`
+}
+
+// LaunchAppResponse is returned by launch_app
+type LaunchAppResponse struct {
+	SessionID    string  `json:"session_id"`
+	PID          int     `json:"pid"`
+	Success      bool    `json:"success"`
+	Pooled       bool    `json:"pooled"`
+	Ready        *bool   `json:"ready,omitempty"`         // Only with ready_when: whether the pattern appeared
+	ReadyMs      *int64  `json:"ready_ms,omitempty"`      // Only with ready_when: how long the wait took
+	ReadyVersion *uint64 `json:"ready_version,omitempty"` // Only once ready: the screen version the pattern appeared in
+}
+
+// ScreenResponse is what view_screen returns in every format
+type ScreenResponse struct {
+	Cursor   CursorPosition `json:"cursor"`
+	Degraded bool           `json:"degraded"`
+}
+
+// ViewScreenResponse is returned by view_screen for the rendered formats
+type ViewScreenResponse struct {
+	ScreenResponse
+	Content   string `json:"content"`
+	RawOffset int64  `json:"raw_offset"` // Raw output offset the content corresponds to
+	Truncated bool   `json:"truncated"`
+	*Truncation
+	Version    *uint64 `json:"version,omitempty"`     // With version: the version shown
+	RecordedAt string  `json:"recorded_at,omitempty"` // With an earlier version: when it was on screen
+}
+
+// ViewFormatsResponse is returned by view_screen when given formats: one
+// frame in each format, keyed by format. The lines format is the rows as
+// structured data, the others a string.
+type ViewFormatsResponse struct {
+	ScreenResponse
+	Formats   map[string]json.RawMessage `json:"formats"`
+	Version   uint64                     `json:"version"`
+	Hash      string                     `json:"hash"` // SHA-256 of the frame's plain rendering, hex
+	RawOffset int64                      `json:"raw_offset"`
+	Truncated []string                   `json:"truncated,omitempty"` // Formats max_bytes cut
+}
+
+// Truncation says how much content max_bytes cut
+type Truncation struct {
+	OmittedBytes int `json:"omitted_bytes"`
+	OmittedLines int `json:"omitted_lines"`
+}
+
+// ViewLinesResponse is returned by view_screen for the lines format
+type ViewLinesResponse struct {
+	ScreenResponse
+	Lines      []terminal.Line `json:"lines"`
+	Generation uint64          `json:"generation"`
+}
+
+// SendKeysResponse is returned by send_keys and send_secret
+type SendKeysResponse struct {
+	Success      bool   `json:"success"`
+	BytesWritten int    `json:"bytes_written"`
+	Mapped       string `json:"mapped,omitempty"` // With verbose: the bytes written, escaped
+}
+
+// KeyMappingResponse is returned by send_keys for a dry run
+type KeyMappingResponse struct {
+	SessionID string     `json:"session_id"`
+	DryRun    bool       `json:"dry_run"`
+	Tokens    []KeyToken `json:"tokens"`
+	Escaped   string     `json:"escaped"`
+	Hex       string     `json:"hex"`
+	Bytes     int        `json:"bytes"`
+}
+
+// KeyToken is one token of a dry run and what it maps to
+type KeyToken struct {
+	Token   string `json:"token"`
+	Kind    string `json:"kind"` // "key" or "text"
+	Escaped string `json:"escaped"`
+	Hex     string `json:"hex"`
+	Mode    string `json:"mode,omitempty"` // For a key, the key mode whose table mapped it
+}
+
+// DescribeKeyResponse is returned by describe_key
+type DescribeKeyResponse struct {
+	SessionID  string              `json:"session_id"`
+	Key        string              `json:"key"`
+	Profile    string              `json:"profile"`     // Key profile the key was mapped with
+	InputModes terminal.InputModes `json:"input_modes"` // The session's current key modes
+	Known      bool                `json:"known"`       // A key name or terminal action; false for text, sent as given
+	Mode       string              `json:"mode,omitempty"`
+	Escaped    string              `json:"escaped"`
+	Hex        string              `json:"hex"`
+	Bytes      int                 `json:"bytes"`
+}
+
+// BroadcastKeysResponse is returned by broadcast_keys
+type BroadcastKeysResponse struct {
+	Success   bool              `json:"success"` // Every session got the keys
+	Results   []BroadcastResult `json:"results"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+}
+
+// BroadcastResult is one session's outcome in broadcast_keys
+type BroadcastResult struct {
+	SessionID    string `json:"session_id"` // As given, when it didn't name a session
+	Success      bool   `json:"success"`
+	BytesWritten int    `json:"bytes_written"`
+	Error        string `json:"error,omitempty"`
+	Code         string `json:"code,omitempty"` // session_busy or input_not_consumed, as send_keys reports them
+}
+
+// SendRawBytesResponse is returned by send_raw_bytes
+type SendRawBytesResponse struct {
+	Success bool `json:"success"`
+	Bytes   int  `json:"bytes"`
+}
+
+// SendSignalResponse is returned by send_signal
+type SendSignalResponse struct {
+	Success bool   `json:"success"`
+	Signal  string `json:"signal"`
+	Target  string `json:"target"`
+	PID     int    `json:"pid"` // Process or process group signalled
+}
+
+// ExportRawOutputResponse is returned by export_raw_output
+type ExportRawOutputResponse struct {
+	SessionID  string `json:"session_id"`
+	Data       string `json:"data"`
+	Encoding   string `json:"encoding"`
+	Offset     int64  `json:"offset"`
+	NextOffset int64  `json:"next_offset"`
+	EndOffset  int64  `json:"end_offset"`
+	Truncated  bool   `json:"truncated"`
+	State      string `json:"state"`
+}
+
+// GetStderrResponse is returned by get_stderr
+type GetStderrResponse struct {
+	SessionID  string `json:"session_id"`
+	Data       string `json:"data"` // Invalid UTF-8 is replaced
+	Offset     int64  `json:"offset"`
+	NextOffset int64  `json:"next_offset"`
+	EndOffset  int64  `json:"end_offset"`
+	Truncated  bool   `json:"truncated"`
+	State      string `json:"state"`
+}
+
+// ViewHistoryResponse is returned by view_history. Line numbers count from
+// the oldest scrollback line held; each line dropped from a full
+// scrollback shifts them down by one, which dropped_lines tracks.
+type ViewHistoryResponse struct {
+	SessionID    string   `json:"session_id"`
+	Lines        []string `json:"lines"`
+	StartLine    int      `json:"start_line"`
+	NextLine     int      `json:"next_line"`     // start_line for the following window
+	TotalLines   int      `json:"total_lines"`   // Scrollback and screen lines; grows while the process writes
+	DroppedLines int      `json:"dropped_lines"` // Lines that have left the full scrollback
+	Frozen       bool     `json:"frozen"`        // The process exited, so the history won't change
+}
+
+// AnalyzeScreenResponse is returned by analyze_screen
+type AnalyzeScreenResponse struct {
+	SessionID    string             `json:"session_id"`
+	Elements     []analyzer.Element `json:"elements"`
+	Cursor       CursorState        `json:"cursor"`
+	Experimental bool               `json:"experimental"`
+}
+
+// ReadyForInputResponse is returned by is_ready_for_input
+type ReadyForInputResponse struct {
+	SessionID string `json:"session_id"`
+	analyzer.Readiness
+	IdleMs int64       `json:"idle_ms"`
+	Cursor CursorState `json:"cursor"`
+}
+
+// TerminalModesResponse is returned by get_terminal_modes
+type TerminalModesResponse struct {
+	SessionID         string          `json:"session_id"`
+	Modes             map[string]bool `json:"modes"`
+	ApplicationKeypad bool            `json:"application_keypad"`
+	Screen            string          `json:"screen"`
+	Charset           string          `json:"charset"`
+}
+
+// StartFrameCaptureResponse is returned by start_frame_capture
+type StartFrameCaptureResponse struct {
+	SessionID  string `json:"session_id"`
+	Capturing  bool   `json:"capturing"`
+	Format     string `json:"format"`
+	IntervalMs int    `json:"interval_ms"`
+	MaxFrames  int    `json:"max_frames"`
+}
+
+// StopFrameCaptureResponse is returned by stop_frame_capture
+type StopFrameCaptureResponse struct {
+	SessionID  string          `json:"session_id"`
+	Format     string          `json:"format"`
+	IntervalMs int64           `json:"interval_ms"`
+	Frames     []CapturedFrame `json:"frames"`
+	Count      int             `json:"count"`
+	Dropped    int             `json:"dropped"`
+}
+
+// CapturedFrame is one frame of a capture, inline or written to a file
+type CapturedFrame struct {
+	Index     int     `json:"index"`
+	Time      string  `json:"time"`
+	ElapsedMs int64   `json:"elapsed_ms"`
+	Version   uint64  `json:"version"`
+	Path      string  `json:"path,omitempty"`    // With dir: the file holding the frame
+	Content   *string `json:"content,omitempty"` // Without dir: the frame itself, even if empty
+}
+
+// StableScreenResponse is returned by wait_for_stable_screen
+type StableScreenResponse struct {
+	SessionID string `json:"session_id"`
+	Version   uint64 `json:"version"`
+	Hash      string `json:"hash"` // SHA-256 of Content, hex
+	WaitedMs  int64  `json:"waited_ms"`
+	Content   string `json:"content"`
+}
+
+// WaitForCursorResponse is returned by wait_for_cursor
+type WaitForCursorResponse struct {
+	SessionID string      `json:"session_id"`
+	Cursor    CursorState `json:"cursor"`
+	WaitedMs  int64       `json:"waited_ms"`
+}
+
+// MeasureLatencyResponse is returned by measure_latency
+type MeasureLatencyResponse struct {
+	SessionID string    `json:"session_id"`
+	SamplesMs []float64 `json:"samples_ms"` // One per press, in order
+	MinMs     float64   `json:"min_ms"`
+	MedianMs  float64   `json:"median_ms"`
+	MaxMs     float64   `json:"max_ms"`
+}
+
+// ProbeSessionResponse is returned by probe_session
+type ProbeSessionResponse struct {
+	SessionID    string  `json:"session_id"`
+	Answered     bool    `json:"answered"`                // Output arrived within the timeout
+	AnswerMs     float64 `json:"answer_ms,omitempty"`     // From sending the keys to the first output
+	ProcessState string  `json:"process_state,omitempty"` // The process's scheduler state, such as S or T for stopped, where known
+	Unresponsive bool    `json:"unresponsive"`            // The session's mark after the probe, as list_sessions shows it
+}
+
+// ScreenSizeResponse is returned by get_screen_size
+type ScreenSizeResponse struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// RestartAppResponse is returned by restart_app
+type RestartAppResponse struct {
+	Success bool `json:"success"`
+	PID     int  `json:"pid"`
+}
+
+// StopAppResponse is returned by stop_app
+type StopAppResponse struct {
+	Success   bool   `json:"success"`
+	SessionID string `json:"session_id"`
+	Result    string `json:"result"` // As session.StopResult's
+}
+
+// ListSessionsResponse is returned by list_sessions
+type ListSessionsResponse struct {
+	Sessions []SessionSummary `json:"sessions"`
+}
+
+// SessionSummary is one session in list_sessions
+type SessionSummary struct {
+	ID           string `json:"id"`
+	Command      string `json:"command"`
+	PID          int    `json:"pid"`
+	State        string `json:"state"`
+	Created      string `json:"created"`
+	Group        string `json:"group"`
+	Degraded     bool   `json:"degraded"`
+	Unresponsive bool   `json:"unresponsive"` // Input went unanswered; see probe_session
+}
+
+// ResizeTerminalResponse is returned by resize_terminal
+type ResizeTerminalResponse struct {
+	Success bool `json:"success"`
+	Width   int  `json:"width"`
+	Height  int  `json:"height"`
+}
+
+// SetSessionOptionResponse is returned by set_session_option
+type SetSessionOptionResponse struct {
+	Success   bool        `json:"success"`
+	SessionID string      `json:"session_id"`
+	Name      string      `json:"name"`
+	Value     interface{} `json:"value"`
+}
+
+// SessionOptionsResponse is returned by get_session_options
+type SessionOptionsResponse struct {
+	SessionID string                         `json:"session_id"`
+	Options   map[string]session.OptionValue `json:"options"`
+}
+
+// SessionLogsResponse is returned by get_session_logs
+type SessionLogsResponse struct {
+	SessionID string             `json:"session_id"`
+	Logs      []session.LogEntry `json:"logs"`
+	Count     int                `json:"count"`
+}
+
+// ResourceHistoryResponse is returned by get_resource_history
+type ResourceHistoryResponse struct {
+	SessionID string `json:"session_id"`
+	session.ResourceHistory
+}
+
+// ExportSessionResponse is returned by export_session
+type ExportSessionResponse struct {
+	SessionID string               `json:"session_id"`
+	Path      string               `json:"path"`
+	Format    string               `json:"format"`
+	Files     []session.BundleFile `json:"files"`
+	Omitted   []string             `json:"omitted"`
+	Redacted  []string             `json:"redacted"`
+	Bytes     int                  `json:"bytes"`
+}
+
+// SessionEventsResponse is returned by get_session_events
+type SessionEventsResponse struct {
+	SessionID string `json:"session_id"`
+	Removed   bool   `json:"removed"`
+	Count     int    `json:"count"`
+	session.EventPage
+}
+
+// RecentActivityResponse is returned by list_recent_activity
+type RecentActivityResponse struct {
+	Activity []session.Activity `json:"activity"`
+	Count    int                `json:"count"`
+}
+
+// ParserDiagnosticsResponse is returned by get_parser_diagnostics
+type ParserDiagnosticsResponse struct {
+	SessionID string `json:"session_id"`
+	terminal.ParserDiagnostics
+	Reset bool `json:"reset"`
+}
+
+// StopGroupResponse is returned by stop_group
+type StopGroupResponse struct {
+	Success bool     `json:"success"`
+	Group   string   `json:"group"`
+	Stopped []string `json:"stopped"`
+	Error   string   `json:"error,omitempty"` // Why some sessions couldn't be stopped
+}
+
+// ListGroupsResponse is returned by list_groups
+type ListGroupsResponse struct {
+	Groups []*session.GroupInfo `json:"groups"`
+}
+
+// DuplicateSessionResponse is returned by duplicate_session
+type DuplicateSessionResponse struct {
+	SessionID       string                `json:"session_id"`
+	SourceSessionID string                `json:"source_session_id"`
+	Config          session.SessionConfig `json:"config"`
+	Success         bool                  `json:"success"`
+}
+
+// StopAllSessionsResponse is returned by stop_all_sessions
+type StopAllSessionsResponse struct {
+	Success bool                 `json:"success"`
+	Results []session.StopResult `json:"results"`
+}
+
+// ListOrphansResponse is returned by list_orphans
+type ListOrphansResponse struct {
+	Orphans []session.SessionRecord `json:"orphans"`
+}
+
+// ReapOrphansResponse is returned by reap_orphans
+type ReapOrphansResponse struct {
+	Success bool                 `json:"success"`
+	Results []session.ReapResult `json:"results"`
+}
+
+// PauseCleanupResponse is returned by pause_cleanup
+type PauseCleanupResponse struct {
+	Success       bool `json:"success"`
+	CleanupPaused bool `json:"cleanup_paused"`
+}
+
+// ReloadKeymapResponse is returned by reload_keymap
+type ReloadKeymapResponse struct {
+	Success bool `json:"success"`
+	KeymapSummary
+}
+
+// SetLogLevelResponse is returned by set_log_level
+type SetLogLevelResponse struct {
+	Success  bool   `json:"success"`
+	Level    string `json:"level"`
+	Previous string `json:"previous"`
+}
+
+// ExpectScriptResponse is returned by run_expect_script
+type ExpectScriptResponse struct {
+	SessionID string       `json:"session_id"`
+	Success   bool         `json:"success"`
+	Steps     []StepResult `json:"steps"`
+	ElapsedMs int64        `json:"elapsed_ms"`
+}
+
+// StepResult is what one step of an expect script did
+type StepResult struct {
+	Index        int      `json:"index"`
+	Kind         string   `json:"kind"`
+	Pattern      string   `json:"pattern,omitempty"`       // expect: the pattern waited for
+	Match        *string  `json:"match,omitempty"`         // expect: the text it matched
+	Groups       []string `json:"groups,omitempty"`        // expect: the pattern's groups
+	Version      *uint64  `json:"version,omitempty"`       // expect: the screen version matched, for view_screen
+	BytesWritten *int     `json:"bytes_written,omitempty"` // send: bytes delivered
+	ElapsedMs    int64    `json:"elapsed_ms"`
+}
+
+// RunAtPromptResponse is returned by run_at_prompt
+type RunAtPromptResponse struct {
+	SessionID string `json:"session_id"`
+	Output    string `json:"output"`
+	Lines     int    `json:"lines"`
+	Truncated bool   `json:"truncated"`
+	Prompt    string `json:"prompt"` // The prompt the command ended at
+	ElapsedMs int64  `json:"elapsed_ms"`
+}
+
+// ProbeShellResponse is returned by probe_shell
+type ProbeShellResponse struct {
+	SessionID  string             `json:"session_id"`
+	Cwd        string             `json:"cwd"`
+	ExitStatus int                `json:"exit_status"`
+	Env        map[string]*string `json:"env"` // nil for an unset variable
+	Screen     *string            `json:"screen,omitempty"`
+	ElapsedMs  int64              `json:"elapsed_ms"`
+}
+
+// ImportCaptureResponse is returned by import_capture
+type ImportCaptureResponse struct {
+	SessionID string `json:"session_id"`
+	Success   bool   `json:"success"`
+	Path      string `json:"path"`
+	Bytes     int    `json:"bytes"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+}
+
+// AddTriggerResponse is returned by add_trigger
+type AddTriggerResponse struct {
+	SessionID string `json:"session_id"`
+	session.TriggerInfo
+}
+
+// ListTriggersResponse is returned by list_triggers
+type ListTriggersResponse struct {
+	SessionID string                `json:"session_id"`
+	Triggers  []session.TriggerInfo `json:"triggers"`
+	Count     int                   `json:"count"`
+}
+
+// RemoveTriggerResponse is returned by remove_trigger
+type RemoveTriggerResponse struct {
+	SessionID string              `json:"session_id"`
+	Success   bool                `json:"success"`
+	Trigger   session.TriggerInfo `json:"trigger"`
+}
+
+// BatchResponse is returned by batch
+type BatchResponse struct {
+	Results []BatchEntry `json:"results"`
+	Failed  int          `json:"failed"`
+	Skipped int          `json:"skipped"` // Calls not run after a failure with stop_on_error
+}
+
+// BatchEntry is the outcome of one call in a batch
+type BatchEntry struct {
+	Tool    string      `json:"tool"`
+	Success bool        `json:"success"`
+	Error   interface{} `json:"error,omitempty"`  // The error message, or a tool error's decoded content
+	Result  interface{} `json:"result,omitempty"` // The call's decoded content
+}
@@ -0,0 +1,101 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/session"
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// defaultProbeTimeoutMs is how long probe_session waits for an answer
+const defaultProbeTimeoutMs = 2000
+
+// ProbeSession checks whether the application still answers input. It
+// sends keys, by default a query applications ignore, and waits for any
+// output; no answer in time is a result, not an error.
+func (h *Handlers) ProbeSession(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "probe_session", args)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, hasKeys, err := GetString(args, "keys")
+	if err != nil {
+		return nil, invalidParam(ctx, "probe_session", err)
+	}
+	if hasKeys {
+		if err := validateKeys(keys, h.maxInput); err != nil {
+			return nil, invalidParam(ctx, "probe_session", err)
+		}
+		keys = MapSessionKeys(sess, keys)
+	} else {
+		keys = session.DefaultProbeKeys
+	}
+	timeoutMs, hasTimeout, err := GetInt(args, "timeout_ms")
+	if err != nil {
+		return nil, invalidParam(ctx, "probe_session", err)
+	}
+	if !hasTimeout {
+		timeoutMs = defaultProbeTimeoutMs
+	}
+	if timeoutMs < 1 || timeoutMs > maxWaitMs {
+		return nil, invalidParam(ctx, "probe_session", fmt.Errorf("timeout_ms must be between 1 and %d", maxWaitMs))
+	}
+
+	utils.LogToolCall(ctx, "probe_session", sess.ID,
+		slog.Int("key_count", len(keys)),
+		slog.Int("timeout_ms", timeoutMs),
+	)
+
+	opCtx, done, err := beginOperation(ctx, "probe_session", sess, session.OpShared, args)
+	if err != nil {
+		return operationError(ctx, "probe_session", err)
+	}
+	defer done()
+
+	// The session closing or restarting ends the wait early
+	waitCtx, cancel := context.WithTimeout(opCtx, time.Duration(timeoutMs)*time.Millisecond)
+	defer cancel()
+	runCtx, unbind := sess.Bind(waitCtx)
+	defer unbind()
+
+	elapsed, answered, err := sess.Probe(runCtx, keys)
+	if err != nil {
+		utils.LogErrorContext(ctx, err, "Failed to send probe",
+			slog.String("tool", "probe_session"),
+			slog.String("session_id", sess.ID),
+		)
+		if errors.Is(err, terminal.ErrInputBlocked) {
+			return inputBlockedResult(err, 0), nil
+		}
+		return nil, err
+	}
+	if !answered {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if waitCtx.Err() == nil {
+			return nil, context.Cause(runCtx)
+		}
+	}
+
+	response := ProbeSessionResponse{
+		SessionID:    sess.ID,
+		Answered:     answered,
+		Unresponsive: sess.Unresponsive(),
+	}
+	if answered {
+		response.AnswerMs = float64(elapsed.Microseconds()) / 1000
+	}
+	if info, err := sess.GetProcessInfo(); err == nil {
+		response.ProcessState = info.State
+	}
+	return jsonResult(response)
+}
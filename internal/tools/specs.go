@@ -0,0 +1,1053 @@
+package tools
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/session"
+	"github.com/bioharz/mcp-terminal-tester/internal/terminal"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// ToolSpec describes a tool: how it is advertised over MCP and the handler
+// that serves it. The server registers every spec, batch and the
+// integration tests dispatch through them and server_info lists them, so a
+// new tool needs only its entry in Specs and its handler.
+type ToolSpec struct {
+	Name        string
+	Description string
+	Params      []mcp.ToolOption // Parameter schema, e.g. mcp.WithString(...)
+	Handler     HandlerFunc
+}
+
+// Tool returns the tool's MCP definition
+func (s ToolSpec) Tool() mcp.Tool {
+	return mcp.NewTool(s.Name, append([]mcp.ToolOption{mcp.WithDescription(s.Description)}, s.Params...)...)
+}
+
+// Specs returns the tools h serves, in the order they are advertised.
+// Limits in the schemas are the ones h enforces, so clients and the
+// validators agree.
+func (h *Handlers) Specs() []ToolSpec {
+	return []ToolSpec{
+		{
+			Name:        "launch_app",
+			Description: "Launch a new terminal application",
+			Params: []mcp.ToolOption{
+				mcp.WithString("command",
+					mcp.Required(),
+					mcp.Description("The command to execute"),
+				),
+				mcp.WithArray("args",
+					mcp.Description("Command arguments"),
+					mcp.Items(map[string]any{"type": "string"}),
+				),
+				mcp.WithObject("env",
+					mcp.Description("Environment variables. Values may use {{session_id}}, {{session_tmpdir}} (a directory removed when the session stops) and {{port_free}} (a free TCP port reserved for the session)"),
+				),
+				mcp.WithString("group",
+					mcp.Description("Optional group name for managing related sessions together"),
+				),
+				mcp.WithString("label",
+					mcp.Description("Optional human-friendly label; may be used in place of session_id"),
+				),
+				mcp.WithBoolean("shell",
+					mcp.Description("The command is an interactive POSIX shell such as sh or bash; allows probe_shell (default false)"),
+				),
+				mcp.WithString("locale",
+					mcp.Description("Locale to run under, such as C, en_US.UTF-8 or en_US.ISO-8859-1; sets LANG, LC_ALL and LC_CTYPE, and decodes output as latin1 unless the locale is UTF-8. Must be one of the server's allowed locales (MCP_ALLOWED_LOCALES)"),
+					mcp.Enum(h.locales...),
+				),
+				mcp.WithString("timezone",
+					mcp.Description("IANA timezone to run in, such as UTC or Europe/Vienna; sets TZ"),
+				),
+				mcp.WithBoolean("separate_stderr",
+					mcp.Description("Send the process's stderr to a pipe of its own, read with get_stderr, instead of the terminal; the process then finds stderr isn't a terminal (default false, not supported on Windows)"),
+				),
+				mcp.WithString("default_format",
+					mcp.Description("Format view_screen uses for this session when none is given"),
+					mcp.Enum(terminal.RenderFormats...),
+				),
+				mcp.WithObject("options",
+					mcp.Description("Session options to set at launch; see set_session_option"),
+				),
+				mcp.WithNumber("width",
+					mcp.Description("Terminal width in columns (default 80)"),
+					mcp.Min(MinDimension),
+					mcp.Max(float64(h.limits.MaxWidth)),
+				),
+				mcp.WithNumber("height",
+					mcp.Description("Terminal height in rows (default 24)"),
+					mcp.Min(MinDimension),
+					mcp.Max(float64(h.limits.MaxHeight)),
+				),
+				mcp.WithBoolean("pooled",
+					mcp.Description("Use a pre-warmed session if the command matches the configured pool"),
+				),
+				mcp.WithObject("ready_when",
+					mcp.Description("Wait before returning until the screen shows text or matches regex: {text | regex, timeout_ms, abort_on_timeout}"),
+					mcp.Properties(map[string]any{
+						"text":             map[string]any{"type": "string"},
+						"regex":            map[string]any{"type": "string"},
+						"timeout_ms":       map[string]any{"type": "number"},
+						"abort_on_timeout": map[string]any{"type": "boolean"},
+					}),
+				),
+			},
+			Handler: h.LaunchApp,
+		},
+		{
+			Name:        "view_screen",
+			Description: "Get the current terminal screen content",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithString("format",
+					mcp.Description("Output format (defaults to the session's default_format, then the server's)"),
+					mcp.Enum(terminal.RenderFormats...),
+				),
+				mcp.WithArray("formats",
+					mcp.Description(fmt.Sprintf("Render the same instant in several formats instead of format, at most %d, returned in formats keyed by format with one shared version and hash", maxViewFormats)),
+					mcp.Items(map[string]any{"type": "string", "enum": terminal.RenderFormats}),
+				),
+				mcp.WithNumber("max_bytes",
+					mcp.Description(fmt.Sprintf("Most content bytes to return; older lines are dropped first (default %d)", h.MaxOutputBytes())),
+				),
+				mcp.WithNumber("only_dirty_since",
+					mcp.Description("With the lines format, return only rows that changed after this generation, as returned by the previous call"),
+					mcp.Min(0),
+				),
+				mcp.WithNumber("version",
+					mcp.Description("Show the screen as it was at this version, as returned by wait_for_stable_screen, a run_expect_script expect step or launch_app's ready_when. Earlier versions need the screen_history session option; formats plain, raw and debug only"),
+					mcp.Min(0),
+				),
+				mcp.WithString("cursor_marker",
+					mcp.Description("With the debug format, the character drawn at the cursor (default ▮)"),
+				),
+				mcp.WithBoolean("dot_spaces",
+					mcp.Description("With the debug format, draw spaces as · (default true)"),
+				),
+				mcp.WithString("style_marker",
+					mcp.Description("With the debug format, a character drawn over every cell with a color or attribute, to show where styling is; empty or absent draws the cells as they are"),
+				),
+				mcp.WithBoolean("rulers",
+					mcp.Description("With the debug format, number the columns above and below the screen and the rows on both sides, from 0 like the cursor position (default false)"),
+				),
+				mcp.WithBoolean("wait",
+					mcp.Description("Queue behind conflicting operations on the session (default true); false fails at once with session_busy"),
+				),
+			},
+			Handler: h.ViewScreen,
+		},
+		{
+			Name:        "wait_for_stable_screen",
+			Description: "Wait until the screen stops changing, e.g. once spinners and progress bars finish, and return that frame",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithNumber("stable_ms",
+					mcp.Description("How long the screen must stay unchanged, in milliseconds (default 500)"),
+					mcp.Min(1),
+					mcp.Max(60000),
+				),
+				mcp.WithNumber("timeout_ms",
+					mcp.Description("How long to wait for the screen to settle, in milliseconds (default 10000)"),
+					mcp.Min(1),
+					mcp.Max(300000),
+				),
+				mcp.WithNumber("from_row",
+					mcp.Description("First row (0-based) that must settle; rows outside from_row-to_row may keep changing"),
+					mcp.Min(0),
+				),
+				mcp.WithNumber("to_row",
+					mcp.Description("Last row (0-based) that must settle (default the bottom row)"),
+					mcp.Min(0),
+				),
+				mcp.WithString("format",
+					mcp.Description("Format of the returned frame (defaults to the session's default_format, then the server's)"),
+					mcp.Enum(terminal.RenderFormats...),
+				),
+			},
+			Handler: h.WaitForStableScreen,
+		},
+		{
+			Name:        "wait_for_cursor",
+			Description: "Wait until the cursor rests at a row, column or both, e.g. once a form parks it in an input field, and return where it is",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithNumber("row",
+					mcp.Description("Row (0-based) the cursor must be on; absent or null for any row"),
+					mcp.Min(0),
+				),
+				mcp.WithNumber("col",
+					mcp.Description("Column (0-based) the cursor must be in; absent or null for any column"),
+					mcp.Min(0),
+				),
+				mcp.WithBoolean("visible",
+					mcp.Description("Also require the cursor to be shown (true) or hidden (false); absent for either"),
+				),
+				mcp.WithNumber("stable_ms",
+					mcp.Description("How long the cursor must stay there, so a position passed through while redrawing doesn't count, in milliseconds (default 100)"),
+					mcp.Min(0),
+					mcp.Max(60000),
+				),
+				mcp.WithNumber("timeout_ms",
+					mcp.Description("How long to wait, in milliseconds (default 10000)"),
+					mcp.Min(1),
+					mcp.Max(300000),
+				),
+			},
+			Handler: h.WaitForCursor,
+		},
+		{
+			Name:        "run_expect_script",
+			Description: "Run a scripted interaction in one call: wait for patterns, send keys and sleep, in order, stopping at the first step that fails",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithArray("steps",
+					mcp.Required(),
+					mcp.Description("Steps to run in order, each with exactly one of expect (a regular expression to wait for on the plain screen), send (keys, as for send_keys) or sleep_ms; expect steps take an optional timeout_ms (default 5000)"),
+					mcp.Items(map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"expect":     map[string]any{"type": "string"},
+							"timeout_ms": map[string]any{"type": "number"},
+							"send":       map[string]any{"type": "string"},
+							"sleep_ms":   map[string]any{"type": "number"},
+						},
+					}),
+				),
+				mcp.WithNumber("timeout_ms",
+					mcp.Description("Longest the whole script may run, in milliseconds (default 60000)"),
+					mcp.Min(1),
+					mcp.Max(300000),
+				),
+				mcp.WithBoolean("wait",
+					mcp.Description("Queue sends behind conflicting operations on the session (default true); false fails the step at once"),
+				),
+			},
+			Handler: h.RunExpectScript,
+		},
+		{
+			Name:        "run_at_prompt",
+			Description: "Wait for a prompt, type a command and Enter, wait for the next prompt and return only what the command printed in between, even if it scrolled",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithString("text",
+					mcp.Required(),
+					mcp.Description("Command to type, as literal text on one line; Enter is sent after it"),
+				),
+				mcp.WithString("prompt_regex",
+					mcp.Description("Regular expression matched against the cursor's line up to the cursor; defaults to the session's prompt_pattern option, then common shell and REPL prompts"),
+				),
+				mcp.WithNumber("timeout_ms",
+					mcp.Description(fmt.Sprintf("Total time for both prompts, in milliseconds (default %d, max %d)", defaultWaitMs, maxWaitMs)),
+				),
+				mcp.WithBoolean("include_echo",
+					mcp.Description("Include the prompt line with the echoed command at the start of the output (default false)"),
+				),
+				mcp.WithBoolean("wait",
+					mcp.Description("Queue behind conflicting operations on the session (default true); false fails at once with session_busy"),
+				),
+			},
+			Handler: h.RunAtPrompt,
+		},
+		{
+			Name:        "probe_shell",
+			Description: "Read a shell session's working directory, last exit status and environment variables. Types a command into the session, which stays in its output and scrollback; only for sessions launched with shell set",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithArray("vars",
+					mcp.Description(fmt.Sprintf("Names of environment or shell variables to read, at most %d", maxProbeVars)),
+					mcp.Items(map[string]any{"type": "string"}),
+				),
+				mcp.WithString("prompt_regex",
+					mcp.Description("Regular expression matched against the cursor's line up to the cursor; defaults to the session's prompt_pattern option, then common shell prompts"),
+				),
+				mcp.WithNumber("timeout_ms",
+					mcp.Description(fmt.Sprintf("Total time for the probe, in milliseconds (default %d, max %d)", defaultWaitMs, maxWaitMs)),
+				),
+				mcp.WithBoolean("include_screen",
+					mcp.Description("Also return the plain screen with the probe's lines left out (default false)"),
+				),
+				mcp.WithBoolean("wait",
+					mcp.Description("Queue behind conflicting operations on the session (default true); false fails at once with session_busy"),
+				),
+			},
+			Handler: h.ProbeShell,
+		},
+		{
+			Name:        "measure_latency",
+			Description: "Time how long the application takes to change the screen after a key press, to catch responsiveness regressions: sends the keys several times, waiting for the screen to settle before each press",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithString("keys",
+					mcp.Required(),
+					mcp.Description("Keys to press each time, as for send_keys"),
+				),
+				mcp.WithNumber("repetitions",
+					mcp.Description("Times to press the keys (default 5)"),
+					mcp.Min(1),
+					mcp.Max(100),
+				),
+				mcp.WithNumber("timeout_ms",
+					mcp.Description("How long to wait for the screen to settle before a press, and to change after it, in milliseconds (default 5000)"),
+					mcp.Min(1),
+					mcp.Max(300000),
+				),
+				mcp.WithBoolean("wait",
+					mcp.Description("Queue each press behind conflicting operations on the session (default true); false fails at once with session_busy"),
+				),
+			},
+			Handler: h.MeasureLatency,
+		},
+		{
+			Name:        "probe_session",
+			Description: "Check whether the application still answers input: send a harmless query and wait for any output. A wedged process that is alive but ignores its input doesn't answer; list_sessions marks such sessions unresponsive on its own",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithString("keys",
+					mcp.Description("Keys to send, as for send_keys (default a Device Attributes query, ESC [ c)"),
+				),
+				mcp.WithNumber("timeout_ms",
+					mcp.Description("How long to wait for output, in milliseconds (default 2000)"),
+					mcp.Min(1),
+					mcp.Max(300000),
+				),
+				mcp.WithBoolean("wait",
+					mcp.Description("Queue behind conflicting operations on the session (default true); false fails at once with session_busy"),
+				),
+			},
+			Handler: h.ProbeSession,
+		},
+		{
+			Name:        "start_frame_capture",
+			Description: "Start recording the screen every time it changes, to check animations frame by frame",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithNumber("interval_ms",
+					mcp.Description("Record at most one frame per interval, in milliseconds (default 100)"),
+					mcp.Min(float64(session.MinCaptureInterval/time.Millisecond)),
+					mcp.Max(60000),
+				),
+				mcp.WithNumber("max_frames",
+					mcp.Description("Frames to keep; later ones are counted as dropped (default 1000)"),
+					mcp.Min(1),
+					mcp.Max(session.MaxCaptureFrames),
+				),
+				mcp.WithString("format",
+					mcp.Description("Format of each frame (default plain)"),
+					mcp.Enum(terminal.RenderFormats...),
+				),
+			},
+			Handler: h.StartFrameCapture,
+		},
+		{
+			Name:        "stop_frame_capture",
+			Description: "Stop a frame capture and return the frames with timestamps",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithString("dir",
+					mcp.Description("Write each frame to a file in this directory and return the paths instead of the contents"),
+				),
+			},
+			Handler: h.StopFrameCapture,
+		},
+		{
+			Name:        "send_keys",
+			Description: "Send keyboard input to the terminal",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithString("keys",
+					mcp.Required(),
+					mcp.Description(fmt.Sprintf("The keys to send: text, or a key name such as Enter or Up (max %d bytes)", h.MaxInputBytes())),
+				),
+				mcp.WithBoolean("wait",
+					mcp.Description("Queue behind conflicting operations on the session (default true); false fails at once with session_busy"),
+				),
+				mcp.WithBoolean("dry_run",
+					mcp.Description("Return the bytes the keys map to, token by token, without sending them (default false)"),
+				),
+				mcp.WithBoolean("verbose",
+					mcp.Description("Include the bytes sent, escaped, as mapped in the response (default false)"),
+				),
+			},
+			Handler: h.SendKeys,
+		},
+		{
+			Name:        "describe_key",
+			Description: "Show the bytes send_keys would write for a key name under the session's key profile and current key modes, without sending anything",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithString("key",
+					mcp.Required(),
+					mcp.Description("Key name such as Home, F1 or Ctrl+C; anything else is described as text"),
+				),
+				mcp.WithString("profile",
+					mcp.Description("Key profile to map with instead of the session's key_profile option: xterm, vt100, linux-console or one from the keymap file"),
+				),
+			},
+			Handler: h.DescribeKey,
+		},
+		{
+			Name:        "broadcast_keys",
+			Description: "Send the same keyboard input to several sessions at once, such as replicas of one application; returns each session's result, and one session failing doesn't stop the others",
+			Params: []mcp.ToolOption{
+				mcp.WithArray("session_ids",
+					mcp.Description(fmt.Sprintf("Sessions to send to, by ID or label, at most %d; can't be combined with group", maxBroadcastSessions)),
+					mcp.Items(map[string]any{"type": "string"}),
+				),
+				mcp.WithString("group",
+					mcp.Description("Send to every session in this group instead"),
+				),
+				mcp.WithString("keys",
+					mcp.Required(),
+					mcp.Description(fmt.Sprintf("The keys to send, as for send_keys (max %d bytes)", h.MaxInputBytes())),
+				),
+				mcp.WithBoolean("wait",
+					mcp.Description("Queue behind conflicting operations on each session (default true); false fails that session at once with session_busy"),
+				),
+			},
+			Handler: h.BroadcastKeys,
+		},
+		{
+			Name:        "send_secret",
+			Description: "Send a password or other secret like send_keys, without it reaching the server log or audit log",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithString("secret",
+					mcp.Required(),
+					mcp.Description(fmt.Sprintf("The secret to type; key names are mapped as for send_keys (max %d bytes)", h.MaxInputBytes())),
+				),
+				mcp.WithBoolean("wait",
+					mcp.Description("Queue behind conflicting operations on the session (default true); false fails at once with session_busy"),
+				),
+			},
+			Handler: h.SendSecret,
+		},
+		{
+			Name:        "send_raw_bytes",
+			Description: "Send bytes to the terminal exactly as given, without key name mapping",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithString("data",
+					mcp.Required(),
+					mcp.Description(fmt.Sprintf("Base64-encoded bytes to send (max %d bytes once decoded)", h.MaxInputBytes())),
+				),
+				mcp.WithBoolean("wait",
+					mcp.Description("Queue behind conflicting operations on the session (default true); false fails at once with session_busy"),
+				),
+			},
+			Handler: h.SendRawBytes,
+		},
+		{
+			Name:        "send_signal",
+			Description: "Send a signal to the application without typing its control character, for applications in raw mode where Ctrl+C, Ctrl+Z and Ctrl+\\ arrive as plain bytes",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithString("signal",
+					mcp.Required(),
+					mcp.Description("Signal to send: interrupt (SIGINT), quit (SIGQUIT), suspend (SIGTSTP), continue (SIGCONT), terminate (SIGTERM) or kill (SIGKILL)"),
+					mcp.Enum(terminal.SignalNames()...),
+				),
+				mcp.WithString("target",
+					mcp.Description("foreground (default) signals the terminal's foreground process group, as the terminal does for Ctrl+C; process signals only the session's process"),
+					mcp.Enum("foreground", "process"),
+				),
+				mcp.WithBoolean("wait",
+					mcp.Description("Queue behind conflicting operations on the session (default true); false fails at once with session_busy"),
+				),
+			},
+			Handler: h.SendSignal,
+		},
+		{
+			Name:        "export_raw_output",
+			Description: "Read the raw output stream from a byte offset, for following a session incrementally",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithNumber("since",
+					mcp.Description("Stream offset to read from; use next_offset from the previous call, or raw_offset from view_screen (default 0)"),
+					mcp.Min(0),
+				),
+				mcp.WithNumber("max_bytes",
+					mcp.Description("Maximum bytes to return (default 65536)"),
+					mcp.Min(1),
+					mcp.Max(1024*1024),
+				),
+			},
+			Handler: h.ExportRawOutput,
+		},
+		{
+			Name:        "get_stderr",
+			Description: "Read the stderr of a session launched with separate_stderr from a byte offset, to tell what a command wrote to stderr from what it wrote to stdout",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithNumber("offset",
+					mcp.Description("Stream offset to read from; use next_offset from the previous call (default 0)"),
+					mcp.Min(0),
+				),
+				mcp.WithNumber("limit",
+					mcp.Description("Maximum bytes to return (default 65536)"),
+					mcp.Min(1),
+					mcp.Max(1024*1024),
+				),
+			},
+			Handler: h.GetStderr,
+		},
+		{
+			Name:        "view_history",
+			Description: "Page through the scrollback followed by the screen as one document of numbered lines, a window at a time, like a pager. Works on running sessions, whose history keeps growing, and on exited ones",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithNumber("start_line",
+					mcp.Description("First line to return; 0 is the oldest scrollback line held (default 0)"),
+					mcp.Min(0),
+				),
+				mcp.WithNumber("count",
+					mcp.Description(fmt.Sprintf("Lines to return (default %d)", defaultHistoryLines)),
+					mcp.Min(1),
+					mcp.Max(maxHistoryLines),
+				),
+				mcp.WithString("format",
+					mcp.Description("plain for text, raw for each line with its colors and attributes (default plain)"),
+					mcp.Enum(terminal.WindowFormats...),
+				),
+				mcp.WithBoolean("wait",
+					mcp.Description("Queue behind conflicting operations on the session (default true); false fails at once with session_busy"),
+				),
+			},
+			Handler: h.ViewHistory,
+		},
+		{
+			Name:        "get_cursor_position",
+			Description: "Get the current cursor position",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+			},
+			Handler: h.GetCursorPosition,
+		},
+		{
+			Name:        "get_terminal_modes",
+			Description: "Get the terminal modes the application has enabled: DEC private modes such as ?25 (cursor visible), ?1049 (alternate screen), ?1006 (SGR mouse) and ?2004 (bracketed paste), ANSI modes, the active screen and the character set",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+			},
+			Handler: h.GetTerminalModes,
+		},
+		{
+			Name:        "analyze_screen",
+			Description: "Experimental: find a shell prompt, highlighted selections, boxed dialogs and input fields on the screen, with their positions and text. Results are heuristic guesses",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithBoolean("wait",
+					mcp.Description("Queue behind conflicting operations on the session (default true); false fails at once with session_busy"),
+				),
+			},
+			Handler: h.AnalyzeScreen,
+		},
+		{
+			Name:        "is_ready_for_input",
+			Description: "Guess whether the application is waiting for input from how long it has been quiet, whether the cursor is visible and after a prompt, and whether its processes can run. Returns each signal that matched so the thresholds can be tuned",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithNumber("idle_ms",
+					mcp.Description("How long the application must have written nothing, in milliseconds (default 200)"),
+				),
+				mcp.WithString("prompt_pattern",
+					mcp.Description("Regular expression matched against the cursor's line up to the cursor; defaults to the session's prompt_pattern option, then common shell and REPL prompts"),
+				),
+				mcp.WithBoolean("wait",
+					mcp.Description("Queue behind conflicting operations on the session (default true); false fails at once with session_busy"),
+				),
+			},
+			Handler: h.IsReadyForInput,
+		},
+		{
+			Name:        "get_buffer_info",
+			Description: "Get how much scrollback and raw output the terminal holds and has dropped, its size, change version, whether the alternate screen is active, and whether the screen is frozen because the process exited",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+			},
+			Handler: h.GetBufferInfo,
+		},
+		{
+			Name:        "get_screen_size",
+			Description: "Get the terminal screen dimensions",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+			},
+			Handler: h.GetScreenSize,
+		},
+		{
+			Name:        "restart_app",
+			Description: "Restart a terminal session",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithBoolean("clear_history",
+					mcp.Description("Drop the scrollback and raw output as well (default false); otherwise the screen moves into the scrollback under a separator line"),
+				),
+				mcp.WithBoolean("wait",
+					mcp.Description("Queue behind conflicting operations on the session (default true); false fails at once with session_busy"),
+				),
+			},
+			Handler: h.RestartApp,
+		},
+		{
+			Name:        "stop_app",
+			Description: "Stop a terminal session",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithBoolean("force",
+					mcp.Description("Kill the process immediately instead of sending SIGTERM first"),
+				),
+				mcp.WithBoolean("ignore_missing",
+					mcp.Description("Report an unknown session as already removed instead of failing"),
+				),
+				mcp.WithBoolean("wait",
+					mcp.Description("Queue behind conflicting operations on the session (default true); false fails at once with session_busy"),
+				),
+			},
+			Handler: h.StopApp,
+		},
+		{
+			Name:        "list_sessions",
+			Description: "List all active terminal sessions",
+			Params: []mcp.ToolOption{
+				mcp.WithString("group",
+					mcp.Description("Only list sessions in this group"),
+				),
+			},
+			Handler: h.ListSessions,
+		},
+		{
+			Name:        "resize_terminal",
+			Description: "Resize the terminal window",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithNumber("width",
+					mcp.Required(),
+					mcp.Description("Terminal width in columns"),
+					mcp.Min(MinDimension),
+					mcp.Max(float64(h.limits.MaxWidth)),
+				),
+				mcp.WithNumber("height",
+					mcp.Required(),
+					mcp.Description("Terminal height in rows"),
+					mcp.Min(MinDimension),
+					mcp.Max(float64(h.limits.MaxHeight)),
+				),
+				mcp.WithBoolean("wait",
+					mcp.Description("Queue behind conflicting operations on the session (default true); false fails at once with session_busy"),
+				),
+			},
+			Handler: h.ResizeTerminal,
+		},
+		{
+			Name:        "get_process_info",
+			Description: "Get PID, state, CPU time, memory and descendant processes of a session's child",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+			},
+			Handler: h.GetProcessInfo,
+		},
+		{
+			Name:        "get_resource_history",
+			Description: "Get the CPU use and RSS of a session's process sampled over time, with the largest RSS and mean CPU use, to check for growth during an interaction. Sampling is off until the resource_sample_ms session option turns it on",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+			},
+			Handler: h.GetResourceHistory,
+		},
+		{
+			Name:        "get_session_info",
+			Description: "Get the full record of a session: command, env names, state, exit status, size, scrollback and more",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+			},
+			Handler: h.GetSessionInfo,
+		},
+		{
+			Name:        "set_session_option",
+			Description: "Change a per-session option such as default_format or scrollback_lines",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithString("name",
+					mcp.Required(),
+					mcp.Description("Option name"),
+					mcp.Enum(session.OptionNames()...),
+				),
+				mcp.WithString("value",
+					mcp.Required(),
+					mcp.Description("New value; integer options accept numbers or numeric strings"),
+				),
+			},
+			Handler: h.SetSessionOption,
+		},
+		{
+			Name:        "get_session_options",
+			Description: "Get the effective value of every session option and whether it comes from the default, launch or a runtime change",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+			},
+			Handler: h.GetSessionOptions,
+		},
+		{
+			Name:        "get_session_logs",
+			Description: "Get recent server log records about one session, newest last",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithString("level",
+					mcp.Description("Only return records at or above this level (default debug)"),
+					mcp.Enum("debug", "info", "warn", "error"),
+				),
+				mcp.WithNumber("limit",
+					mcp.Description("Maximum records to return (default 100)"),
+					mcp.Min(1),
+					mcp.Max(10000),
+				),
+			},
+			Handler: h.GetSessionLogs,
+		},
+		{
+			Name:        "export_session",
+			Description: "Write a bug report bundle for a session: metadata, final screens, scrollback, raw output, input history, parser diagnostics, logs and any resource samples, with environment values and secrets redacted. Returns the path",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithString("dir",
+					mcp.Description("Directory to create the bundle in, created if needed (default the system temporary directory)"),
+				),
+				mcp.WithString("format",
+					mcp.Description("A directory of files or a single .tar.gz archive (default dir)"),
+					mcp.Enum("dir", "tar.gz"),
+				),
+				mcp.WithNumber("max_bytes",
+					mcp.Description(fmt.Sprintf("Limit on the files' total size before compression (default %d)", session.DefaultBundleBytes)),
+					mcp.Min(1),
+					mcp.Max(session.MaxBundleBytes),
+				),
+			},
+			Handler: h.ExportSession,
+		},
+		{
+			Name:        "import_capture",
+			Description: "Create a frozen session from a capture of raw terminal output, such as an export bundle's output.raw or a script(1) log, so the screen and scrollback tools can inspect it. The file must be in the directory set with MCP_IMPORT_DIR",
+			Params: []mcp.ToolOption{
+				mcp.WithString("path",
+					mcp.Required(),
+					mcp.Description("The capture file, absolute or relative to the import directory"),
+				),
+				mcp.WithNumber("width",
+					mcp.Description("Terminal width to parse the capture at (default 80)"),
+					mcp.Min(MinDimension),
+					mcp.Max(float64(h.limits.MaxWidth)),
+				),
+				mcp.WithNumber("height",
+					mcp.Description("Terminal height to parse the capture at (default 24)"),
+					mcp.Min(MinDimension),
+					mcp.Max(float64(h.limits.MaxHeight)),
+				),
+				mcp.WithString("label",
+					mcp.Description("Optional human-friendly label; may be used in place of session_id"),
+				),
+				mcp.WithObject("options",
+					mcp.Description("Session options to parse the capture with, such as line_feed or scrollback_lines; see set_session_option"),
+				),
+			},
+			Handler: h.ImportCapture,
+		},
+		{
+			Name:        "add_trigger",
+			Description: "Act on a session when a pattern appears in its new output: snapshot the screen, export a bundle, send a signal, stop it or notify clients. Each firing is recorded as a trigger event; a trigger fires at most once a second",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithString("pattern",
+					mcp.Required(),
+					mcp.Description("Regular expression matched against each line of new output, with control sequences left out"),
+				),
+				mcp.WithArray("actions",
+					mcp.Required(),
+					mcp.Description("What to do, in order: snapshot (plain screen to a file), export (bundle as export_session writes it), signal:NAME (such as signal:SIGTERM, to the foreground process group), notify (activity log and a client notification) and stop, always done last"),
+					mcp.Items(map[string]any{"type": "string"}),
+				),
+				mcp.WithBoolean("one_shot",
+					mcp.Description("Remove the trigger after it fires once (default false)"),
+				),
+				mcp.WithString("dir",
+					mcp.Description("Directory snapshot and export write to, created if needed (default the system temporary directory)"),
+				),
+			},
+			Handler: h.AddTrigger,
+		},
+		{
+			Name:        "list_triggers",
+			Description: "List a session's triggers with how often each fired and how many matches the rate limit suppressed",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+			},
+			Handler: h.ListTriggers,
+		},
+		{
+			Name:        "remove_trigger",
+			Description: "Remove a trigger from a session",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithString("trigger_id",
+					mcp.Required(),
+					mcp.Description("The trigger ID from add_trigger or list_triggers"),
+				),
+			},
+			Handler: h.RemoveTrigger,
+		},
+		{
+			Name:        "get_session_events",
+			Description: "Poll a session's events (created, restarted, exited, cleaned_idle, closed, bell, title, resize, trigger) after a sequence number; removed sessions' events stay readable for a while",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID, or a live session's label"),
+				),
+				mcp.WithNumber("since_seq",
+					mcp.Description("Return events with a higher sequence number; pass the previous next_since_seq (default 0, all kept events)"),
+					mcp.Min(0),
+				),
+				mcp.WithNumber("limit",
+					mcp.Description("Maximum events to return (default 100)"),
+					mcp.Min(1),
+					mcp.Max(1000),
+				),
+			},
+			Handler: h.GetSessionEvents,
+		},
+		{
+			Name:        "list_recent_activity",
+			Description: "List what happened across all sessions, newest last: sessions created, restarted, exited, cleaned up idle or closed, idle cleanup runs and rate limiting",
+			Params: []mcp.ToolOption{
+				mcp.WithString("since",
+					mcp.Description("Only list activity after this RFC 3339 timestamp"),
+				),
+				mcp.WithNumber("within_ms",
+					mcp.Description("Only list activity from the last this many milliseconds, instead of since"),
+					mcp.Min(1),
+				),
+				mcp.WithString("session_id",
+					mcp.Description("Only list activity of this session, which may have been removed"),
+				),
+				mcp.WithNumber("limit",
+					mcp.Description("Maximum entries to return, newest kept (default 100)"),
+					mcp.Min(1),
+					mcp.Max(1000),
+				),
+			},
+			Handler: h.ListRecentActivity,
+		},
+		{
+			Name:        "get_parser_diagnostics",
+			Description: "Count the escape sequences a session's output used that the screen buffer ignores, with recent samples",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID"),
+				),
+				mcp.WithBoolean("reset",
+					mcp.Description("Clear the counters and samples after reading them"),
+				),
+			},
+			Handler: h.GetParserDiagnostics,
+		},
+		{
+			Name:        "stop_group",
+			Description: "Stop every session in a group",
+			Params: []mcp.ToolOption{
+				mcp.WithString("group",
+					mcp.Required(),
+					mcp.Description("The group name"),
+				),
+			},
+			Handler: h.StopGroup,
+		},
+		{
+			Name:        "list_groups",
+			Description: "List session groups and their members",
+			Handler:     h.ListGroups,
+		},
+		{
+			Name:        "duplicate_session",
+			Description: "Launch a new independent session with another session's command, args and env",
+			Params: []mcp.ToolOption{
+				mcp.WithString("session_id",
+					mcp.Required(),
+					mcp.Description("The session ID to copy"),
+				),
+				mcp.WithObject("env",
+					mcp.Description("Environment variables added to or overriding the source's"),
+				),
+				mcp.WithNumber("width",
+					mcp.Description("Terminal width in columns (defaults to the source's)"),
+					mcp.Min(MinDimension),
+					mcp.Max(float64(h.limits.MaxWidth)),
+				),
+				mcp.WithNumber("height",
+					mcp.Description("Terminal height in rows (defaults to the source's)"),
+					mcp.Min(MinDimension),
+					mcp.Max(float64(h.limits.MaxHeight)),
+				),
+				mcp.WithString("label",
+					mcp.Description("Optional label for the new session (the source's label is not copied)"),
+				),
+			},
+			Handler: h.DuplicateSession,
+		},
+		{
+			Name:        "stop_all_sessions",
+			Description: "Stop every session, terminating gracefully before killing",
+			Handler:     h.StopAllSessions,
+		},
+		{
+			Name:        "list_orphans",
+			Description: "List processes left running by a previous server run",
+			Handler:     h.ListOrphans,
+		},
+		{
+			Name:        "reap_orphans",
+			Description: "Kill processes left running by a previous server run",
+			Handler:     h.ReapOrphans,
+		},
+		{
+			Name:        "pause_cleanup",
+			Description: "Pause or resume automatic cleanup of idle sessions",
+			Params: []mcp.ToolOption{
+				mcp.WithBoolean("paused",
+					mcp.Description("true to pause cleanup, false to resume"),
+					mcp.DefaultBool(true),
+				),
+			},
+			Handler: h.PauseCleanup,
+		},
+		{
+			Name:        "set_log_level",
+			Description: "Change the server's log level without restarting",
+			Params: []mcp.ToolOption{
+				mcp.WithString("level",
+					mcp.Required(),
+					mcp.Description("New log level"),
+					mcp.Enum("debug", "info", "warn", "error"),
+				),
+			},
+			Handler: h.SetLogLevel,
+		},
+		{
+			Name:        "reload_keymap",
+			Description: "Re-read the keymap file (MCP_KEYMAP_FILE) so added key names and profiles apply without a restart; an invalid file is reported and the keymap in use kept",
+			Handler:     h.ReloadKeymap,
+		},
+	}
+}
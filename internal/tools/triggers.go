@@ -0,0 +1,136 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/session"
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// AddTrigger adds a trigger that acts on the session when a pattern shows
+// up in its output: snapshots, exports, signals, stopping it or notifying
+// clients. Firings are recorded as trigger events.
+func (h *Handlers) AddTrigger(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "add_trigger", args)
+	if err != nil {
+		return nil, err
+	}
+
+	pattern, _, err := GetString(args, "pattern")
+	if err != nil {
+		return nil, invalidParam(ctx, "add_trigger", err)
+	}
+	if pattern == "" {
+		return nil, invalidParam(ctx, "add_trigger", fmt.Errorf("pattern parameter is required"))
+	}
+	if _, err := regexp.Compile(pattern); err != nil {
+		return nil, invalidParam(ctx, "add_trigger", fmt.Errorf("invalid pattern: %w", err))
+	}
+	actions, _, err := GetStringSlice(args, "actions")
+	if err != nil {
+		return nil, invalidParam(ctx, "add_trigger", err)
+	}
+	if err := session.ValidateTriggerActions(actions); err != nil {
+		return nil, invalidParam(ctx, "add_trigger", err)
+	}
+	oneShot, _, err := GetBool(args, "one_shot")
+	if err != nil {
+		return nil, invalidParam(ctx, "add_trigger", err)
+	}
+	dir, _, err := GetString(args, "dir")
+	if err != nil {
+		return nil, invalidParam(ctx, "add_trigger", err)
+	}
+	if slices.Contains(actions, session.TriggerSnapshot) || slices.Contains(actions, session.TriggerExport) {
+		if dir == "" {
+			dir = os.TempDir()
+		}
+		if dir, err = filepath.Abs(dir); err != nil {
+			return nil, invalidParam(ctx, "add_trigger", err)
+		}
+	} else {
+		dir = ""
+	}
+
+	utils.LogToolCall(ctx, "add_trigger", sess.ID,
+		slog.String("pattern", pattern),
+		slog.Any("actions", actions),
+	)
+
+	id := sess.ID
+	info, err := sess.AddTrigger(session.TriggerConfig{
+		Pattern: pattern,
+		Actions: actions,
+		OneShot: oneShot,
+		Dir:     dir,
+		Stop: func() {
+			if _, err := h.sessionManager.StopSession(id, false, true); err != nil {
+				utils.LogError(err, "Trigger failed to stop session", slog.String("session_id", id))
+			}
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return jsonResult(AddTriggerResponse{
+		SessionID:   sess.ID,
+		TriggerInfo: info,
+	})
+}
+
+// ListTriggers lists a session's triggers with how often each fired
+func (h *Handlers) ListTriggers(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "list_triggers", args)
+	if err != nil {
+		return nil, err
+	}
+
+	utils.LogToolCall(ctx, "list_triggers", sess.ID)
+
+	triggers := sess.Triggers()
+	return jsonResult(ListTriggersResponse{
+		SessionID: sess.ID,
+		Triggers:  triggers,
+		Count:     len(triggers),
+	})
+}
+
+// RemoveTrigger removes one of a session's triggers
+func (h *Handlers) RemoveTrigger(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.GetArguments()
+	sess, err := h.resolveSession(ctx, "remove_trigger", args)
+	if err != nil {
+		return nil, err
+	}
+
+	triggerID, _, err := GetString(args, "trigger_id")
+	if err != nil {
+		return nil, invalidParam(ctx, "remove_trigger", err)
+	}
+	if triggerID == "" {
+		return nil, invalidParam(ctx, "remove_trigger", fmt.Errorf("trigger_id parameter is required"))
+	}
+
+	utils.LogToolCall(ctx, "remove_trigger", sess.ID, slog.String("trigger_id", triggerID))
+
+	info, err := sess.RemoveTrigger(triggerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return jsonResult(RemoveTriggerResponse{
+		SessionID: sess.ID,
+		Success:   true,
+		Trigger:   info,
+	})
+}
@@ -1,59 +1,149 @@
 package utils
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"strings"
+	"sync"
 )
 
 var Logger *slog.Logger
 
+var (
+	// logLevel is shared by every handler so the level can change at runtime
+	logLevel = new(slog.LevelVar)
+
+	// baseLevel is the level configured at startup, restored when debug
+	// logging is toggled off
+	baseLevel slog.Level
+
+	// logFormat is the configured LOG_FORMAT, kept for SetLogOutput
+	logFormat string
+
+	initOnce sync.Once
+)
+
+// InitLogger sets up the default logger from LOG_LEVEL and LOG_FORMAT. Only
+// the first call has any effect.
 func InitLogger() {
+	initOnce.Do(initLogger)
+}
+
+func initLogger() {
 	// Get log level from environment
 	levelStr := os.Getenv("LOG_LEVEL")
 	if levelStr == "" {
 		levelStr = "info"
 	}
-
-	var level slog.Level
-	switch strings.ToLower(levelStr) {
-	case "debug":
-		level = slog.LevelDebug
-	case "info":
-		level = slog.LevelInfo
-	case "warn", "warning":
-		level = slog.LevelWarn
-	case "error":
-		level = slog.LevelError
-	default:
+	level, err := ParseLevel(levelStr)
+	if err != nil {
 		level = slog.LevelInfo
 	}
+	baseLevel = level
+	logLevel.Set(level)
 
-	// Create handler with options
-	opts := &slog.HandlerOptions{
-		Level: level,
-		AddSource: level == slog.LevelDebug, // Add source info in debug mode
+	format := strings.ToLower(os.Getenv("LOG_FORMAT"))
+	if format == "" {
+		format = "json"
 	}
+	logFormat = format
 
-	// Use JSON handler for structured output
-	handler := slog.NewJSONHandler(os.Stderr, opts)
-	Logger = slog.New(handler)
+	// Records tagged with a session_id are also kept with their session
+	Logger = slog.New(newSessionHandler(newHandler(os.Stderr, format, level == slog.LevelDebug)))
 
 	// Set as default
 	slog.SetDefault(Logger)
 
-	Logger.Info("Logger initialized", 
+	if format != "json" && format != "text" {
+		Logger.Warn("Unknown LOG_FORMAT, using json", slog.String("format", format))
+	}
+	Logger.Info("Logger initialized",
 		slog.String("level", levelStr),
-		slog.Bool("source", opts.AddSource),
+		slog.String("format", format),
+		slog.Bool("source", level == slog.LevelDebug),
 	)
 }
 
+// SetLogOutput sends the default logger's output to w, keeping the
+// configured level and format. Tests use it to inspect log records.
+func SetLogOutput(w io.Writer) {
+	InitLogger()
+	Logger = slog.New(newSessionHandler(newHandler(w, logFormat, baseLevel == slog.LevelDebug)))
+	slog.SetDefault(Logger)
+}
+
+// newHandler returns a handler writing to w in the given format, "text" for
+// human-readable output and JSON otherwise, filtered by the shared level
+func newHandler(w io.Writer, format string, addSource bool) slog.Handler {
+	opts := &slog.HandlerOptions{
+		Level:     logLevel,
+		AddSource: addSource, // Add source info in debug mode
+	}
+	if format == "text" {
+		return slog.NewTextHandler(w, opts)
+	}
+	// Use JSON handler for structured output
+	return slog.NewJSONHandler(w, opts)
+}
+
+// ParseLevel converts a level name (debug, info, warn, warning or error) to
+// a slog level
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q: must be one of debug, info, warn, error", name)
+}
+
+// LogLevel returns the current log level
+func LogLevel() slog.Level {
+	return logLevel.Level()
+}
+
+// SetLogLevel changes the log level of every logger at runtime and returns
+// the previous level
+func SetLogLevel(level slog.Level) slog.Level {
+	previous := logLevel.Level()
+	logLevel.Set(level)
+	return previous
+}
+
+// ToggleDebug switches between debug logging and the startup level, or info
+// if the server was started at debug, and returns the new level
+func ToggleDebug() slog.Level {
+	level := slog.LevelDebug
+	if logLevel.Level() == slog.LevelDebug {
+		level = baseLevel
+		if level == slog.LevelDebug {
+			level = slog.LevelInfo
+		}
+	}
+	logLevel.Set(level)
+	return level
+}
+
 // Helper functions for common logging patterns
 
 func LogError(err error, msg string, args ...any) {
+	LogErrorContext(context.Background(), err, msg, args...)
+}
+
+// LogErrorContext is LogError for work done on behalf of a tool call, so the
+// record carries the call's request ID
+func LogErrorContext(ctx context.Context, err error, msg string, args ...any) {
 	if err != nil {
 		args = append(args, slog.String("error", err.Error()))
-		Logger.Error(msg, args...)
+		Logger.ErrorContext(ctx, msg, args...)
 	}
 }
 
@@ -65,7 +155,7 @@ func LogSessionEvent(sessionID string, event string, args ...any) {
 	Logger.Info("session event", args...)
 }
 
-func LogToolCall(tool string, sessionID string, args ...any) {
+func LogToolCall(ctx context.Context, tool string, sessionID string, args ...any) {
 	baseArgs := []any{
 		slog.String("tool", tool),
 	}
@@ -73,5 +163,5 @@ func LogToolCall(tool string, sessionID string, args ...any) {
 		baseArgs = append(baseArgs, slog.String("session_id", sessionID))
 	}
 	args = append(baseArgs, args...)
-	Logger.Debug("tool call", args...)
+	Logger.DebugContext(ctx, "tool call", args...)
 }
\ No newline at end of file
@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestInitLoggerIsIdempotent(t *testing.T) {
+	InitLogger()
+	first := Logger
+	InitLogger()
+	if Logger != first {
+		t.Error("Expected a second InitLogger call to keep the existing logger")
+	}
+}
+
+func TestRuntimeLevelChange(t *testing.T) {
+	InitLogger()
+	defer SetLogLevel(SetLogLevel(slog.LevelInfo))
+
+	var buf bytes.Buffer
+	logger := slog.New(newHandler(&buf, "json", false))
+
+	logger.Debug("hidden at info")
+	if buf.Len() != 0 {
+		t.Fatalf("Expected no debug output at info, got %s", buf.String())
+	}
+
+	if previous := SetLogLevel(slog.LevelDebug); previous != slog.LevelInfo {
+		t.Errorf("Expected previous level info, got %v", previous)
+	}
+	logger.Debug("shown at debug")
+	if !strings.Contains(buf.String(), "shown at debug") {
+		t.Fatalf("Expected debug output after raising the level, got %s", buf.String())
+	}
+
+	buf.Reset()
+	SetLogLevel(slog.LevelWarn)
+	logger.Debug("hidden again")
+	logger.Info("hidden too")
+	if buf.Len() != 0 {
+		t.Errorf("Expected no output below warn, got %s", buf.String())
+	}
+}
+
+func TestToggleDebug(t *testing.T) {
+	InitLogger()
+	defer SetLogLevel(SetLogLevel(baseLevel))
+
+	if level := ToggleDebug(); level != slog.LevelDebug {
+		t.Fatalf("Expected toggle to enable debug, got %v", level)
+	}
+	want := baseLevel
+	if want == slog.LevelDebug {
+		want = slog.LevelInfo
+	}
+	if level := ToggleDebug(); level != want {
+		t.Errorf("Expected toggle back to %v, got %v", want, level)
+	}
+}
+
+func TestTextFormat(t *testing.T) {
+	var buf bytes.Buffer
+	slog.New(newHandler(&buf, "text", false)).Warn("readable", slog.String("session_id", "s1"))
+	if !strings.Contains(buf.String(), "level=WARN") || !strings.Contains(buf.String(), "session_id=s1") {
+		t.Errorf("Expected key=value output, got %s", buf.String())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for name, want := range map[string]slog.Level{
+		"debug": slog.LevelDebug, "INFO": slog.LevelInfo, "warning": slog.LevelWarn, "error": slog.LevelError,
+	} {
+		if got, err := ParseLevel(name); err != nil || got != want {
+			t.Errorf("ParseLevel(%q) = %v, %v; want %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("Expected an unknown level to be rejected")
+	}
+}
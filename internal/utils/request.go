@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+type requestIDKey struct{}
+
+// NewRequestID returns a new ID for correlating one tool call's log records
+func NewRequestID() string {
+	return uuid.New().String()
+}
+
+// WithRequestID returns a context carrying a tool call's request ID. Records
+// logged with this context get a request_id attribute.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestID returns the request ID carried by ctx, or "" if there is none
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
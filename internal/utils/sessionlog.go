@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+)
+
+// SessionLogLevel is the lowest level captured for sessions when the
+// server's own level is higher, so a session's warnings and errors are kept
+// even when the server log is quiet
+const SessionLogLevel = slog.LevelInfo
+
+// SessionLogSink receives every record that carries a session_id attribute
+type SessionLogSink func(sessionID string, record slog.Record)
+
+var sessionSink atomic.Pointer[SessionLogSink]
+
+// SetSessionLogSink installs the function that receives session records.
+// Passing nil stops the fan-out.
+func SetSessionLogSink(sink SessionLogSink) {
+	if sink == nil {
+		sessionSink.Store(nil)
+		return
+	}
+	sessionSink.Store(&sink)
+}
+
+// sessionHandler passes records on to the next handler and also hands any
+// record with a session_id attribute to the session log sink. Records logged
+// with a context carrying a request ID get a request_id attribute.
+type sessionHandler struct {
+	next      slog.Handler
+	sessionID string      // From a session_id attribute added with With
+	attrs     []slog.Attr // Attributes added with With, passed on to the sink
+}
+
+func newSessionHandler(next slog.Handler) *sessionHandler {
+	return &sessionHandler{next: next}
+}
+
+func (h *sessionHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if h.next.Enabled(ctx, level) {
+		return true
+	}
+	return level >= SessionLogLevel && sessionSink.Load() != nil
+}
+
+func (h *sessionHandler) Handle(ctx context.Context, record slog.Record) error {
+	if requestID := RequestID(ctx); requestID != "" {
+		record = record.Clone()
+		record.AddAttrs(slog.String("request_id", requestID))
+	}
+
+	if sink := sessionSink.Load(); sink != nil {
+		sessionID := h.sessionID
+		record.Attrs(func(attr slog.Attr) bool {
+			if attr.Key == "session_id" {
+				sessionID = attr.Value.String()
+				return false
+			}
+			return true
+		})
+		if sessionID != "" {
+			captured := record.Clone()
+			captured.AddAttrs(h.attrs...)
+			(*sink)(sessionID, captured)
+		}
+	}
+
+	if !h.next.Enabled(ctx, record.Level) {
+		return nil
+	}
+	return h.next.Handle(ctx, record)
+}
+
+func (h *sessionHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := &sessionHandler{
+		next:      h.next.WithAttrs(attrs),
+		sessionID: h.sessionID,
+		attrs:     append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...),
+	}
+	for _, attr := range attrs {
+		if attr.Key == "session_id" {
+			clone.sessionID = attr.Value.String()
+		}
+	}
+	return clone
+}
+
+func (h *sessionHandler) WithGroup(name string) slog.Handler {
+	return &sessionHandler{
+		next:      h.next.WithGroup(name),
+		sessionID: h.sessionID,
+		attrs:     h.attrs,
+	}
+}
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+)
+
+func TestSessionHandlerFansOutSessionRecords(t *testing.T) {
+	defer SetLogLevel(SetLogLevel(slog.LevelWarn))
+
+	captured := make(map[string][]string)
+	SetSessionLogSink(func(sessionID string, record slog.Record) {
+		captured[sessionID] = append(captured[sessionID], record.Message)
+	})
+	defer SetSessionLogSink(nil)
+
+	var buf bytes.Buffer
+	logger := slog.New(newSessionHandler(newHandler(&buf, "json", false)))
+
+	logger.Info("started", slog.String("session_id", "a"))
+	logger.With(slog.String("session_id", "b")).Error("failed")
+	logger.Info("no session")
+	logger.Debug("too quiet", slog.String("session_id", "a"))
+
+	if got := captured["a"]; len(got) != 1 || got[0] != "started" {
+		t.Errorf("Session a: got %v", got)
+	}
+	if got := captured["b"]; len(got) != 1 || got[0] != "failed" {
+		t.Errorf("Session b: got %v", got)
+	}
+	if len(captured) != 2 {
+		t.Errorf("Expected records for 2 sessions, got %v", captured)
+	}
+
+	// Session info records are captured even though the server logs at warn
+	if bytes.Contains(buf.Bytes(), []byte("started")) || !bytes.Contains(buf.Bytes(), []byte("failed")) {
+		t.Errorf("Server log should only contain the warn+ record, got %s", buf.String())
+	}
+}
+
+func TestSessionHandlerEnabled(t *testing.T) {
+	defer SetLogLevel(SetLogLevel(slog.LevelError))
+	h := newSessionHandler(newHandler(&bytes.Buffer{}, "json", false))
+	ctx := context.Background()
+
+	SetSessionLogSink(nil)
+	if h.Enabled(ctx, slog.LevelInfo) {
+		t.Error("Info should be disabled at error level without a sink")
+	}
+
+	SetSessionLogSink(func(string, slog.Record) {})
+	defer SetSessionLogSink(nil)
+	if !h.Enabled(ctx, SessionLogLevel) {
+		t.Error("Session log level should be enabled while a sink is installed")
+	}
+	if h.Enabled(ctx, slog.LevelDebug) {
+		t.Error("Debug should stay disabled below the server level")
+	}
+}
@@ -0,0 +1,146 @@
+// Package bridge drives terminal applications from Go code: launch a
+// program in a pseudo-terminal, send it keys, and read its screen, without
+// speaking MCP by hand.
+//
+// New runs sessions in-process. Connect and DialStdio drive a terminal
+// bridge server over MCP instead, with the same API.
+//
+//	b := bridge.New()
+//	defer b.Close()
+//	sess, err := b.Launch(ctx, bridge.LaunchOpts{Command: "./echo"})
+//	...
+//	sess.SendKeys("hello")
+//	sess.SendKeys("Enter")
+//	err = sess.WaitForText(ctx, "You typed: hello")
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Format selects how a screen is rendered
+type Format string
+
+const (
+	Plain        Format = "plain"         // Text only
+	Raw          Format = "raw"           // The application's output, escape sequences included
+	RawCanonical Format = "raw_canonical" // Raw, written the same way for screens that look the same
+	Debug        Format = "debug"         // Text with a cursor marker and spaces drawn as dots
+	ANSI         Format = "ansi"          // Former name of Debug
+	Scrollback   Format = "scrollback"    // Text including lines scrolled off the top
+)
+
+// LaunchOpts describes a program to launch
+type LaunchOpts struct {
+	Command string
+	Args    []string
+	Env     map[string]string // Added to the bridge's own environment
+	Width   int               // Columns, 0 for the default 80
+	Height  int               // Rows, 0 for the default 24
+	Label   string            // Optional human-readable name
+	Group   string            // Optional group to stop sessions together
+}
+
+// SessionInfo describes a session. The JSON names match the list_sessions
+// and get_session_info tool responses.
+type SessionInfo struct {
+	ID         string    `json:"id"`
+	Command    string    `json:"command"`
+	Args       []string  `json:"args"`
+	PID        int       `json:"pid"`
+	State      string    `json:"state"` // "active", "stopped" or "error" once the process exited
+	Created    time.Time `json:"created"`
+	Label      string    `json:"label,omitempty"`
+	Group      string    `json:"group,omitempty"`
+	Width      int       `json:"width"`
+	Height     int       `json:"height"`
+	Exited     bool      `json:"exited"`
+	ExitCode   *int      `json:"exit_code"`             // nil while running or after a signal
+	ExitStatus string    `json:"exit_status,omitempty"` // e.g. "exit status 1" or "signal: killed"
+}
+
+// Cursor is a 0-based screen position
+type Cursor struct {
+	Row int `json:"row"`
+	Col int `json:"col"`
+}
+
+// backend carries out operations, either on a local session manager or
+// through MCP tool calls
+type backend interface {
+	launch(ctx context.Context, opts LaunchOpts) (string, error)
+	sendKeys(ctx context.Context, id, keys string) error
+	screen(ctx context.Context, id string, format Format) (string, error)
+	cursor(ctx context.Context, id string) (Cursor, error)
+	resize(ctx context.Context, id string, width, height int) error
+	restart(ctx context.Context, id string) error
+	stop(ctx context.Context, id string) error
+	info(ctx context.Context, id string) (SessionInfo, error)
+	list(ctx context.Context) ([]SessionInfo, error)
+	close() error
+}
+
+// Bridge launches and tracks terminal sessions
+type Bridge struct {
+	backend backend
+}
+
+// New returns a Bridge that runs sessions in this process. Close stops them.
+func New() *Bridge {
+	return &Bridge{backend: newLocalBackend()}
+}
+
+// Connect returns a Bridge that drives a terminal bridge server through an
+// MCP client, over whichever transport the client uses. The client must
+// already be started and initialized; Close closes it.
+func Connect(c *client.Client) *Bridge {
+	return &Bridge{backend: &remoteBackend{client: c}}
+}
+
+// DialStdio starts a terminal bridge server as a subprocess, e.g.
+// DialStdio(ctx, "terminalbridge"), and connects to it over stdio. env is
+// added to the server's environment as KEY=value entries.
+func DialStdio(ctx context.Context, command string, env []string, args ...string) (*Bridge, error) {
+	c, err := client.NewStdioMCPClient(command, env, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start bridge server: %w", err)
+	}
+	request := mcp.InitializeRequest{}
+	request.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+	request.Params.ClientInfo = mcp.Implementation{Name: "terminalbridge-go", Version: "1"}
+	if _, err := c.Initialize(ctx, request); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("failed to initialize bridge server: %w", err)
+	}
+	return Connect(c), nil
+}
+
+// Launch starts a program in a new session
+func (b *Bridge) Launch(ctx context.Context, opts LaunchOpts) (*Session, error) {
+	id, err := b.backend.launch(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{ID: id, bridge: b}, nil
+}
+
+// Session returns a handle for an existing session by ID
+func (b *Bridge) Session(id string) *Session {
+	return &Session{ID: id, bridge: b}
+}
+
+// Sessions lists the bridge's sessions
+func (b *Bridge) Sessions(ctx context.Context) ([]SessionInfo, error) {
+	return b.backend.list(ctx)
+}
+
+// Close stops every session of an in-process Bridge, or disconnects from a
+// remote one
+func (b *Bridge) Close() error {
+	return b.backend.close()
+}
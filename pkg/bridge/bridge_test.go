@@ -0,0 +1,257 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	bridgeserver "github.com/bioharz/mcp-terminal-tester/internal/mcp"
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// binDir holds the echo test app and the server, built once by TestMain
+var binDir string
+
+func TestMain(m *testing.M) {
+	dir, err := buildBinaries()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Skipping bridge tests: %v\n", err)
+		dir = ""
+	}
+	binDir = dir
+	code := m.Run()
+	if dir != "" {
+		os.RemoveAll(dir)
+	}
+	os.Exit(code)
+}
+
+func buildBinaries() (string, error) {
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp("", "terminalbridge-bridge")
+	if err != nil {
+		return "", err
+	}
+	for name, src := range map[string]string{
+		"echo":   filepath.Join("..", "..", "test", "apps", "echo.go"),
+		"server": filepath.Join("..", "..", "cmd", "server"),
+	} {
+		build := exec.Command(goBin, "build", "-o", binary(dir, name), src)
+		if out, err := build.CombinedOutput(); err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("failed to build %s: %v\n%s", name, err, out)
+		}
+	}
+	return dir, nil
+}
+
+func binary(dir, name string) string {
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return filepath.Join(dir, name)
+}
+
+func requireBinaries(t *testing.T) {
+	t.Helper()
+	if binDir == "" {
+		t.Skip("test binaries not built")
+	}
+}
+
+// backends opens a Bridge of each kind. The remote one talks MCP to a
+// server in this process.
+var backends = []struct {
+	name string
+	open func(t *testing.T) *Bridge
+}{
+	{"local", func(t *testing.T) *Bridge {
+		return New()
+	}},
+	{"remote", func(t *testing.T) *Bridge {
+		utils.InitLogger()
+		t.Setenv("STATE_DIR", t.TempDir())
+		srv, err := bridgeserver.NewServer()
+		if err != nil {
+			t.Fatalf("Failed to create server: %v", err)
+		}
+		t.Cleanup(srv.Shutdown)
+
+		c, err := client.NewInProcessClient(srv.MCPServer())
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		initialize(t, c)
+		return Connect(c)
+	}},
+}
+
+func initialize(t *testing.T, c *client.Client) {
+	t.Helper()
+	ctx := context.Background()
+	if err := c.Start(ctx); err != nil {
+		t.Fatalf("Failed to start client: %v", err)
+	}
+	request := mcp.InitializeRequest{}
+	request.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+	request.Params.ClientInfo = mcp.Implementation{Name: "bridge-test", Version: "1"}
+	if _, err := c.Initialize(ctx, request); err != nil {
+		t.Fatalf("Failed to initialize: %v", err)
+	}
+}
+
+func TestEcho(t *testing.T) {
+	requireBinaries(t)
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			b := backend.open(t)
+			defer b.Close()
+			testEcho(t, b)
+		})
+	}
+}
+
+func TestDialStdio(t *testing.T) {
+	requireBinaries(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	b, err := DialStdio(ctx, binary(binDir, "server"), []string{"STATE_DIR=" + t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to dial server: %v", err)
+	}
+	defer b.Close()
+	testEcho(t, b)
+}
+
+// testEcho drives the echo app through a full run
+func testEcho(t *testing.T, b *Bridge) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sess, err := b.Launch(ctx, LaunchOpts{Command: binary(binDir, "echo"), Label: "echo", Width: 60, Height: 20})
+	if err != nil {
+		t.Fatalf("Failed to launch: %v", err)
+	}
+	if err := sess.WaitForText(ctx, "Echo Test Application"); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := sess.Info()
+	if err != nil {
+		t.Fatalf("Failed to get info: %v", err)
+	}
+	if info.ID != sess.ID || info.PID <= 0 || info.State != "active" || info.Label != "echo" {
+		t.Errorf("Unexpected info: %+v", info)
+	}
+	if info.Width != 60 || info.Height != 20 {
+		t.Errorf("Expected a 60x20 terminal, got %dx%d", info.Width, info.Height)
+	}
+
+	sessions, err := b.Sessions(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list sessions: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != sess.ID {
+		t.Errorf("Expected only %s to be listed, got %+v", sess.ID, sessions)
+	}
+
+	if err := sess.SendKeys("hello"); err != nil {
+		t.Fatalf("Failed to send keys: %v", err)
+	}
+	if err := sess.SendKeys("Enter"); err != nil {
+		t.Fatalf("Failed to send Enter: %v", err)
+	}
+	if err := sess.WaitForText(ctx, "You typed: hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	screen, err := sess.Screen(Plain)
+	if err != nil {
+		t.Fatalf("Failed to view screen: %v", err)
+	}
+	lines := strings.Split(screen, "\n")
+	cursor, err := sess.Cursor()
+	if err != nil {
+		t.Fatalf("Failed to get cursor: %v", err)
+	}
+	// The cursor waits after the newest prompt
+	if cursor.Row >= len(lines) || strings.TrimSpace(lines[cursor.Row]) != ">" || cursor.Col != 2 {
+		t.Errorf("Expected the cursor after the prompt, got %+v on:\n%s", cursor, screen)
+	}
+
+	if err := sess.SendKeys("exit"); err != nil {
+		t.Fatalf("Failed to send keys: %v", err)
+	}
+	if err := sess.SendKeys("Enter"); err != nil {
+		t.Fatalf("Failed to send Enter: %v", err)
+	}
+	code, err := sess.WaitForExit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 0 {
+		t.Errorf("Expected exit code 0, got %d", code)
+	}
+	if err := sess.Stop(); err != nil {
+		t.Errorf("Failed to stop the exited session: %v", err)
+	}
+}
+
+func TestWaitForTextTimeout(t *testing.T) {
+	requireBinaries(t)
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			b := backend.open(t)
+			defer b.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			sess, err := b.Launch(ctx, LaunchOpts{Command: binary(binDir, "echo")})
+			if err != nil {
+				t.Fatalf("Failed to launch: %v", err)
+			}
+			if err := sess.WaitForText(ctx, "Echo Test Application"); err != nil {
+				t.Fatal(err)
+			}
+
+			short, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+			defer cancel()
+			err = sess.WaitForText(short, "never printed")
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("Expected a deadline error, got %v", err)
+			}
+			if !strings.Contains(err.Error(), "Echo Test Application") {
+				t.Errorf("Expected the error to include the screen, got %v", err)
+			}
+		})
+	}
+}
+
+func TestUnknownSession(t *testing.T) {
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			b := backend.open(t)
+			defer b.Close()
+
+			sess := b.Session("no-such-session")
+			if _, err := sess.Screen(Plain); err == nil {
+				t.Error("Expected an error viewing an unknown session")
+			}
+			if err := sess.Stop(); err == nil {
+				t.Error("Expected an error stopping an unknown session")
+			}
+		})
+	}
+}
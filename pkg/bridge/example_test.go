@@ -0,0 +1,62 @@
+package bridge_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/pkg/bridge"
+)
+
+// Drive the echo test app (make -C test/apps echo) in this process
+func Example() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	b := bridge.New()
+	defer b.Close()
+
+	sess, err := b.Launch(ctx, bridge.LaunchOpts{Command: "test/apps/echo"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := sess.WaitForText(ctx, "Echo Test Application"); err != nil {
+		log.Fatal(err)
+	}
+	sess.SendKeys("hello")
+	sess.SendKeys("Enter")
+	if err := sess.WaitForText(ctx, "You typed: hello"); err != nil {
+		log.Fatal(err)
+	}
+
+	screen, err := sess.Screen(bridge.Plain)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(screen)
+}
+
+// Drive the same app through a bridge server started as a subprocess
+func ExampleDialStdio() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	b, err := bridge.DialStdio(ctx, "terminalbridge", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer b.Close()
+
+	sess, err := b.Launch(ctx, bridge.LaunchOpts{Command: "test/apps/echo"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	sess.SendKeys("exit")
+	sess.SendKeys("Enter")
+	code, err := sess.WaitForExit(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("exit code", code)
+}
@@ -0,0 +1,154 @@
+package bridge
+
+import (
+	"context"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/session"
+	"github.com/bioharz/mcp-terminal-tester/internal/tools"
+	"github.com/bioharz/mcp-terminal-tester/internal/utils"
+)
+
+// localBackend runs sessions on a session manager in this process. Each
+// operation takes the session's gate like the matching tool does, waiting
+// rather than failing when the session is busy.
+type localBackend struct {
+	manager *session.Manager
+}
+
+func newLocalBackend() *localBackend {
+	// Sessions log through the bridge's logger, set by LOG_LEVEL and
+	// LOG_FORMAT
+	utils.InitLogger()
+	manager := session.NewManager()
+	manager.StartCleanupRoutine()
+	return &localBackend{manager: manager}
+}
+
+// begin looks up a session and starts an operation on it
+func (b *localBackend) begin(ctx context.Context, id, op string, kind session.OpKind) (*session.Session, context.Context, func(), error) {
+	sess, err := b.manager.GetSession(id)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	opCtx, done, err := sess.Begin(ctx, op, kind, true)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return sess, opCtx, done, nil
+}
+
+func (b *localBackend) launch(ctx context.Context, opts LaunchOpts) (string, error) {
+	sess, err := b.manager.CreateSessionWithConfig(session.SessionConfig{
+		Command: opts.Command,
+		Args:    opts.Args,
+		Env:     opts.Env,
+		Group:   opts.Group,
+		Label:   opts.Label,
+		Width:   opts.Width,
+		Height:  opts.Height,
+	})
+	if err != nil {
+		return "", err
+	}
+	return sess.ID, nil
+}
+
+func (b *localBackend) sendKeys(ctx context.Context, id, keys string) error {
+	sess, opCtx, done, err := b.begin(ctx, id, "send_keys", session.OpShared)
+	if err != nil {
+		return err
+	}
+	defer done()
+	_, err = sess.SendKeys(opCtx, tools.MapSessionKeys(sess, keys))
+	return err
+}
+
+func (b *localBackend) screen(ctx context.Context, id string, format Format) (string, error) {
+	sess, opCtx, done, err := b.begin(ctx, id, "view_screen", session.OpShared)
+	if err != nil {
+		return "", err
+	}
+	defer done()
+	return sess.GetScreen(opCtx, string(format))
+}
+
+func (b *localBackend) cursor(ctx context.Context, id string) (Cursor, error) {
+	sess, err := b.manager.GetSession(id)
+	if err != nil {
+		return Cursor{}, err
+	}
+	col, row := sess.GetCursorPosition()
+	return Cursor{Row: row, Col: col}, nil
+}
+
+func (b *localBackend) resize(ctx context.Context, id string, width, height int) error {
+	sess, opCtx, done, err := b.begin(ctx, id, "resize_terminal", session.OpExclusive)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return sess.Resize(opCtx, width, height)
+}
+
+func (b *localBackend) restart(ctx context.Context, id string) error {
+	_, _, done, err := b.begin(ctx, id, "restart_app", session.OpLifecycle)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return b.manager.RestartSession(id, false)
+}
+
+func (b *localBackend) stop(ctx context.Context, id string) error {
+	_, _, done, err := b.begin(ctx, id, "stop_app", session.OpLifecycle)
+	if err != nil {
+		return err
+	}
+	defer done()
+	_, err = b.manager.StopSession(id, false, false)
+	return err
+}
+
+func (b *localBackend) info(ctx context.Context, id string) (SessionInfo, error) {
+	sess, err := b.manager.GetSession(id)
+	if err != nil {
+		return SessionInfo{}, err
+	}
+	return sessionInfo(sess), nil
+}
+
+func (b *localBackend) list(ctx context.Context) ([]SessionInfo, error) {
+	sessions := b.manager.ListSessions()
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, listed := range sessions {
+		// Sessions stopped since the listing are left out
+		if sess, err := b.manager.GetSession(listed.ID); err == nil {
+			infos = append(infos, sessionInfo(sess))
+		}
+	}
+	return infos, nil
+}
+
+func (b *localBackend) close() error {
+	b.manager.Shutdown()
+	return nil
+}
+
+func sessionInfo(sess *session.Session) SessionInfo {
+	d := sess.GetDetails()
+	return SessionInfo{
+		ID:         d.ID,
+		Command:    d.Command,
+		Args:       d.Args,
+		PID:        d.PID,
+		State:      d.State,
+		Created:    d.Created,
+		Label:      d.Label,
+		Group:      d.Group,
+		Width:      d.Width,
+		Height:     d.Height,
+		Exited:     d.Exited,
+		ExitCode:   d.ExitCode,
+		ExitStatus: d.ExitStatus,
+	}
+}
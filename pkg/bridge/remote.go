@@ -0,0 +1,169 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// ToolError is a failure a remote bridge reported as a tool result, such as
+// a busy session or blocked input
+type ToolError struct {
+	Tool    string
+	Code    string // e.g. "session_busy" or "input_not_consumed"
+	Message string
+}
+
+func (e *ToolError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("%s: %s", e.Tool, e.Message)
+	}
+	return fmt.Sprintf("%s: %s (%s)", e.Tool, e.Message, e.Code)
+}
+
+// remoteBackend calls the bridge's tools through an MCP client. Session
+// operations wait when the session is busy, as they do in-process.
+type remoteBackend struct {
+	client *client.Client
+}
+
+// call invokes a tool and decodes its JSON response into out, if set
+func (b *remoteBackend) call(ctx context.Context, tool string, args map[string]interface{}, out interface{}) error {
+	request := mcp.CallToolRequest{}
+	request.Params.Name = tool
+	request.Params.Arguments = args
+	result, err := b.client.CallTool(ctx, request)
+	if err != nil {
+		return fmt.Errorf("%s: %w", tool, err)
+	}
+
+	var text string
+	if len(result.Content) > 0 {
+		if content, ok := result.Content[0].(mcp.TextContent); ok {
+			text = content.Text
+		}
+	}
+	if result.IsError {
+		var resp struct {
+			Error string `json:"error"`
+			Code  string `json:"code"`
+		}
+		if json.Unmarshal([]byte(text), &resp) != nil || resp.Error == "" {
+			resp.Error = text
+		}
+		return &ToolError{Tool: tool, Code: resp.Code, Message: resp.Error}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(text), out); err != nil {
+		return fmt.Errorf("%s: failed to parse response: %w", tool, err)
+	}
+	return nil
+}
+
+func (b *remoteBackend) launch(ctx context.Context, opts LaunchOpts) (string, error) {
+	args := map[string]interface{}{"command": opts.Command}
+	if len(opts.Args) > 0 {
+		args["args"] = opts.Args
+	}
+	if len(opts.Env) > 0 {
+		args["env"] = opts.Env
+	}
+	if opts.Width > 0 {
+		args["width"] = opts.Width
+	}
+	if opts.Height > 0 {
+		args["height"] = opts.Height
+	}
+	if opts.Label != "" {
+		args["label"] = opts.Label
+	}
+	if opts.Group != "" {
+		args["group"] = opts.Group
+	}
+
+	var resp struct {
+		SessionID string `json:"session_id"`
+	}
+	if err := b.call(ctx, "launch_app", args, &resp); err != nil {
+		return "", err
+	}
+	if resp.SessionID == "" {
+		return "", errors.New("launch_app: response has no session_id")
+	}
+	return resp.SessionID, nil
+}
+
+func (b *remoteBackend) sendKeys(ctx context.Context, id, keys string) error {
+	return b.call(ctx, "send_keys", map[string]interface{}{
+		"session_id": id,
+		"keys":       keys,
+		"wait":       true,
+	}, nil)
+}
+
+func (b *remoteBackend) screen(ctx context.Context, id string, format Format) (string, error) {
+	var resp struct {
+		Content string `json:"content"`
+	}
+	err := b.call(ctx, "view_screen", map[string]interface{}{
+		"session_id": id,
+		"format":     string(format),
+		"wait":       true,
+	}, &resp)
+	return resp.Content, err
+}
+
+func (b *remoteBackend) cursor(ctx context.Context, id string) (Cursor, error) {
+	var c Cursor
+	err := b.call(ctx, "get_cursor_position", map[string]interface{}{"session_id": id}, &c)
+	return c, err
+}
+
+func (b *remoteBackend) resize(ctx context.Context, id string, width, height int) error {
+	return b.call(ctx, "resize_terminal", map[string]interface{}{
+		"session_id": id,
+		"width":      width,
+		"height":     height,
+		"wait":       true,
+	}, nil)
+}
+
+func (b *remoteBackend) restart(ctx context.Context, id string) error {
+	return b.call(ctx, "restart_app", map[string]interface{}{
+		"session_id": id,
+		"wait":       true,
+	}, nil)
+}
+
+func (b *remoteBackend) stop(ctx context.Context, id string) error {
+	return b.call(ctx, "stop_app", map[string]interface{}{
+		"session_id": id,
+		"wait":       true,
+	}, nil)
+}
+
+func (b *remoteBackend) info(ctx context.Context, id string) (SessionInfo, error) {
+	var info SessionInfo
+	err := b.call(ctx, "get_session_info", map[string]interface{}{"session_id": id}, &info)
+	return info, err
+}
+
+func (b *remoteBackend) list(ctx context.Context) ([]SessionInfo, error) {
+	var resp struct {
+		Sessions []SessionInfo `json:"sessions"`
+	}
+	if err := b.call(ctx, "list_sessions", map[string]interface{}{}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Sessions, nil
+}
+
+func (b *remoteBackend) close() error {
+	return b.client.Close()
+}
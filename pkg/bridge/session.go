@@ -0,0 +1,97 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// pollInterval is how often the Wait methods look again
+const pollInterval = 50 * time.Millisecond
+
+// Session is a handle for one launched program. Methods without a context
+// use context.Background; set a deadline through the Wait methods.
+type Session struct {
+	ID     string
+	bridge *Bridge
+}
+
+// SendKeys types text or a named key such as "Enter", "Up" or "Ctrl+C"
+func (s *Session) SendKeys(keys string) error {
+	return s.bridge.backend.sendKeys(context.Background(), s.ID, keys)
+}
+
+// Screen renders the current screen
+func (s *Session) Screen(format Format) (string, error) {
+	return s.bridge.backend.screen(context.Background(), s.ID, format)
+}
+
+// Cursor returns the cursor position
+func (s *Session) Cursor() (Cursor, error) {
+	return s.bridge.backend.cursor(context.Background(), s.ID)
+}
+
+// Resize changes the terminal size
+func (s *Session) Resize(width, height int) error {
+	return s.bridge.backend.resize(context.Background(), s.ID, width, height)
+}
+
+// Restart starts the program again on a blank screen. The old output stays
+// in the scrollback under a separator line.
+func (s *Session) Restart() error {
+	return s.bridge.backend.restart(context.Background(), s.ID)
+}
+
+// Stop ends the program and removes the session
+func (s *Session) Stop() error {
+	return s.bridge.backend.stop(context.Background(), s.ID)
+}
+
+// Info describes the session
+func (s *Session) Info() (SessionInfo, error) {
+	return s.bridge.backend.info(context.Background(), s.ID)
+}
+
+// WaitForText waits until text appears on the plain screen. If ctx ends
+// first, the error includes the last screen.
+func (s *Session) WaitForText(ctx context.Context, text string) error {
+	var last string
+	for {
+		screen, err := s.bridge.backend.screen(ctx, s.ID, Plain)
+		if err != nil {
+			return err
+		}
+		if strings.Contains(screen, text) {
+			return nil
+		}
+		last = screen
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for %q: %w; screen:\n%s", text, ctx.Err(), last)
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// WaitForExit waits until the program has exited and returns its exit
+// code, or -1 if it was ended by a signal
+func (s *Session) WaitForExit(ctx context.Context) (int, error) {
+	for {
+		info, err := s.bridge.backend.info(ctx, s.ID)
+		if err != nil {
+			return -1, err
+		}
+		if info.Exited && info.State != "active" {
+			if info.ExitCode == nil {
+				return -1, nil
+			}
+			return *info.ExitCode, nil
+		}
+		select {
+		case <-ctx.Done():
+			return -1, fmt.Errorf("waiting for exit: %w", ctx.Err())
+		case <-time.After(pollInterval):
+		}
+	}
+}
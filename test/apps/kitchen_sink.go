@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// A non-interactive walk through the escape sequences full-screen
+// applications rely on. Each phase draws into its own labeled rows, so the
+// final frame shows what a terminal made of every phase. The program then
+// waits for Enter, leaving the frame on screen.
+
+const esc = "\x1b"
+
+// phase draws one feature's block of the frame
+type phase struct {
+	name string
+	draw func()
+}
+
+var phases = []phase{
+	{"cursor", cursorPhase},
+	{"scroll", scrollPhase},
+	{"altscreen", altScreenPhase},
+	{"paste", pastePhase},
+	{"mouse", mousePhase},
+	{"wide", widePhase},
+}
+
+func main() {
+	selected := flag.String("phases", "all", "Comma-separated phases to run: all, or any of "+phaseNames())
+	wait := flag.Bool("wait", true, "Wait for Enter after the final frame")
+	flag.Parse()
+
+	run, err := selectPhases(*selected)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	// Header on row 1, from a blank screen
+	fmt.Print(esc + "[2J" + esc + "[H")
+	fmt.Print("TUI kitchen sink: " + *selected)
+
+	for _, p := range run {
+		p.draw()
+	}
+
+	// Footer, with the cursor parked below it
+	moveTo(18, 1)
+	fmt.Print("kitchen sink done")
+	moveTo(19, 1)
+
+	if *wait {
+		bufio.NewReader(os.Stdin).ReadString('\n')
+	}
+}
+
+func phaseNames() string {
+	names := make([]string, len(phases))
+	for i, p := range phases {
+		names[i] = p.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// selectPhases returns the named phases in their fixed order
+func selectPhases(list string) ([]phase, error) {
+	if list == "all" {
+		return phases, nil
+	}
+	want := map[string]bool{}
+	for _, name := range strings.Split(list, ",") {
+		want[strings.TrimSpace(name)] = true
+	}
+	var run []phase
+	for _, p := range phases {
+		if want[p.name] {
+			run = append(run, p)
+			delete(want, p.name)
+		}
+	}
+	for name := range want {
+		return nil, fmt.Errorf("unknown phase %q, valid phases: %s", name, phaseNames())
+	}
+	return run, nil
+}
+
+func moveTo(row, col int) {
+	fmt.Printf(esc+"[%d;%dH", row, col)
+}
+
+// cursorPhase saves the cursor, draws elsewhere and restores it, with both
+// DECSC/DECRC and CSI s/u. Row 3 should read
+// "[cursor] restored-7  restored-s" with "saved-7" and "saved-s" from column 40.
+func cursorPhase() {
+	moveTo(3, 1)
+	fmt.Print("[cursor] ")
+	fmt.Print(esc + "7")
+	moveTo(3, 40)
+	fmt.Print("saved-7")
+	fmt.Print(esc + "8")
+	fmt.Print("restored-7  ")
+	fmt.Print(esc + "[s")
+	moveTo(3, 50)
+	fmt.Print("saved-s")
+	fmt.Print(esc + "[u")
+	fmt.Print("restored-s")
+}
+
+// scrollPhase sets a scroll region on rows 6-9 and prints six lines into
+// it. Rows 6-9 should end up holding "region 3" to "region 6", with row 5
+// and the "below region" line on row 10 untouched.
+func scrollPhase() {
+	moveTo(5, 1)
+	fmt.Print("[scroll] region rows 6-9")
+	moveTo(10, 1)
+	fmt.Print("[scroll] below region")
+
+	fmt.Print(esc + "[6;9r")
+	moveTo(6, 1)
+	for i := 1; i <= 6; i++ {
+		if i > 1 {
+			fmt.Print("\r\n")
+		}
+		fmt.Printf("region %d", i)
+	}
+	// Reset the region, which also homes the cursor
+	fmt.Print(esc + "[r")
+}
+
+// altScreenPhase draws on the alternate screen and leaves it again. Row 12
+// should read "[altscreen] primary kept" and nothing drawn on the alternate
+// screen should remain.
+func altScreenPhase() {
+	moveTo(12, 1)
+	fmt.Print("[altscreen] primary kept")
+
+	fmt.Print(esc + "[?1049h")
+	fmt.Print(esc + "[2J" + esc + "[H")
+	moveTo(12, 1)
+	fmt.Print("ALTERNATE SCREEN CONTENT")
+	moveTo(20, 1)
+	fmt.Print("ALTERNATE FOOTER")
+	fmt.Print(esc + "[?1049l")
+}
+
+// pastePhase turns bracketed paste on and off again; nothing should be
+// drawn besides the label on row 14
+func pastePhase() {
+	fmt.Print(esc + "[?2004h")
+	moveTo(14, 1)
+	fmt.Print("[paste] bracketed paste toggled")
+	fmt.Print(esc + "[?2004l")
+}
+
+// mousePhase turns on click, drag and SGR mouse reporting and off again;
+// nothing should be drawn besides the label on row 15
+func mousePhase() {
+	fmt.Print(esc + "[?1000h" + esc + "[?1002h" + esc + "[?1006h")
+	moveTo(15, 1)
+	fmt.Print("[mouse] mouse reporting toggled")
+	fmt.Print(esc + "[?1006l" + esc + "[?1002l" + esc + "[?1000l")
+}
+
+// widePhase prints double-width characters. On row 16 the "|" after them
+// should land in column 16, and the "^" on row 17 directly below it.
+func widePhase() {
+	moveTo(16, 1)
+	fmt.Print("[wide] 漢字🙂ab|")
+	moveTo(17, 16)
+	fmt.Print("^")
+}
@@ -4,9 +4,17 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
 	"runtime"
+	"strconv"
+	"sync"
+	"syscall"
 )
 
+// sigwinch is SIGWINCH, spelled out so the app still builds on Windows,
+// where it never arrives
+const sigwinch = syscall.Signal(0x1c)
+
 var menuItems = []string{
 	"Show System Info",
 	"Test Cursor Movement",
@@ -19,6 +27,14 @@ var menuItems = []string{
 
 var selectedIndex = 0
 
+// The terminal size, kept up to date on SIGWINCH, and whether the menu is
+// on screen to be redrawn at the new size. mu is held while drawing.
+var (
+	mu                 sync.Mutex
+	termCols, termRows int
+	showingMenu        bool
+)
+
 func main() {
 	// Enable raw mode to capture arrow keys
 	oldState, err := makeRaw()
@@ -32,14 +48,23 @@ func main() {
 	fmt.Print("\033[?25l")
 	defer fmt.Print("\033[?25h") // Show cursor on exit
 
+	termCols, termRows = terminalSize()
+	go watchResize()
+
 	clearScreen()
 	
 	for {
+		mu.Lock()
 		drawMenu()
+		showingMenu = true
+		mu.Unlock()
 		
 		// Read single character
 		var buf [3]byte
 		n, _ := os.Stdin.Read(buf[:])
+		mu.Lock()
+		showingMenu = false
+		mu.Unlock()
 		
 		if n == 1 {
 			switch buf[0] {
@@ -67,6 +92,44 @@ func main() {
 	}
 }
 
+// terminalSize asks the terminal driver for its size, falling back to
+// COLUMNS and LINES and then to 80x24, clamped to something drawable
+func terminalSize() (cols, rows int) {
+	cols, rows = 80, 24
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil {
+		cols = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("LINES")); err == nil {
+		rows = n
+	}
+	cmd := exec.Command("stty", "size")
+	cmd.Stdin = os.Stdin
+	if out, err := cmd.Output(); err == nil {
+		var r, c int
+		if _, err := fmt.Sscan(string(out), &r, &c); err == nil && r > 0 && c > 0 {
+			cols, rows = c, r
+		}
+	}
+	return min(max(cols, 20), 1000), min(max(rows, 3), 1000)
+}
+
+// watchResize records each new terminal size and redraws the menu if it is
+// showing
+func watchResize() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, sigwinch)
+	for range sigs {
+		cols, rows := terminalSize()
+		mu.Lock()
+		termCols, termRows = cols, rows
+		if showingMenu {
+			clearScreen()
+			drawMenu()
+		}
+		mu.Unlock()
+	}
+}
+
 func clearScreen() {
 	fmt.Print("\033[2J\033[H")
 }
@@ -143,7 +206,10 @@ func showSystemInfo() {
 	fmt.Printf("OS: %s\n", runtime.GOOS)
 	fmt.Printf("Architecture: %s\n", runtime.GOARCH)
 	fmt.Printf("Go Version: %s\n", runtime.Version())
-	fmt.Printf("Terminal Size: Run 'stty size' to check\n")
+	mu.Lock()
+	cols, rows := termCols, termRows
+	mu.Unlock()
+	fmt.Printf("Terminal Size: %dx%d\n", cols, rows)
 }
 
 func testCursorMovement() {
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Asks the terminal for its size with XTWINOPS (CSI 18 t, or CSI 14 t for
+// pixels) instead of TIOCGWINSZ, and prints the reply it reads. Every Enter
+// asks again, so a test can resize in between; EOF quits.
+
+var (
+	op      = flag.Int("op", 18, "XTWINOPS report to ask for: 18 for characters, 14 for pixels")
+	timeout = flag.Duration("timeout", 2*time.Second, "How long to wait for the reply")
+)
+
+// replyPattern matches a size report, CSI 8 ; rows ; cols t or
+// CSI 4 ; height ; width t
+var replyPattern = regexp.MustCompile(`\x1b\[(\d+);(\d+);(\d+)t`)
+
+func main() {
+	flag.Parse()
+	fmt.Println("Size Query Test Application")
+
+	input := bufio.NewReader(os.Stdin)
+	for {
+		reply, err := query(input)
+		switch {
+		case err != nil:
+			fmt.Printf("query failed: %v\n", err)
+		case replyPattern.MatchString(reply):
+			m := replyPattern.FindStringSubmatch(reply)
+			fmt.Printf("reply %q: report %s, %s x %s\n", reply, m[1], m[2], m[3])
+		default:
+			fmt.Printf("unexpected reply %q\n", reply)
+		}
+
+		fmt.Print("Press Enter to ask again> ")
+		if _, err := input.ReadString('\n'); err != nil {
+			return
+		}
+	}
+}
+
+// query sends the size request with the terminal in raw mode and reads the
+// reply up to its final t
+func query(input *bufio.Reader) (string, error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return "", err
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return "", err
+	}
+	defer stty(strings.TrimSpace(saved))
+
+	fmt.Printf("\x1b[%dt", *op)
+
+	reply := make(chan string, 1)
+	go func() {
+		s, _ := input.ReadString('t')
+		reply <- s
+	}()
+	select {
+	case s := <-reply:
+		return s, nil
+	case <-time.After(*timeout):
+		return "", fmt.Errorf("no reply within %s", *timeout)
+	}
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
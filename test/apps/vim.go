@@ -3,11 +3,22 @@ package main
 import (
 	"fmt"
 	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 )
 
 // Simple vim-like editor for testing terminal interactions
-// This is a simplified version to test MCP Terminal capabilities
+// This is a simplified version to test MCP Terminal capabilities.
+// It fills the terminal whatever its size and redraws on SIGWINCH, so it
+// shows resizes end to end.
+
+// sigwinch is SIGWINCH, spelled out so the app still builds on Windows,
+// where it never arrives
+const sigwinch = syscall.Signal(0x1c)
 
 type Mode int
 
@@ -18,30 +29,68 @@ const (
 )
 
 type Editor struct {
-	lines       []string
-	cursorX     int
-	cursorY     int
-	mode        Mode
-	filename    string
-	modified    bool
-	message     string
-	screenRows  int
-	screenCols  int
-	topLine     int // Top line displayed on screen
+	lines      []string
+	cursorX    int
+	cursorY    int
+	mode       Mode
+	filename   string
+	modified   bool
+	message    string
+	screenRows int
+	screenCols int
+	topLine    int        // Top line displayed on screen
+	mu         sync.Mutex // Held while handling a key or a resize
 }
 
 func NewEditor() *Editor {
+	cols, rows := terminalSize()
 	return &Editor{
 		lines:      []string{""},
 		cursorX:    0,
 		cursorY:    0,
 		mode:       NormalMode,
-		screenRows: 24,
-		screenCols: 80,
+		screenRows: rows,
+		screenCols: cols,
 		topLine:    0,
 	}
 }
 
+// terminalSize asks the terminal driver for its size, falling back to
+// COLUMNS and LINES and then to 80x24. The size is clamped to what the
+// editor can draw in: a text line, the status line and the message line.
+func terminalSize() (cols, rows int) {
+	cols, rows = 80, 24
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil {
+		cols = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("LINES")); err == nil {
+		rows = n
+	}
+	cmd := exec.Command("stty", "size")
+	cmd.Stdin = os.Stdin
+	if out, err := cmd.Output(); err == nil {
+		var r, c int
+		if _, err := fmt.Sscan(string(out), &r, &c); err == nil && r > 0 && c > 0 {
+			cols, rows = c, r
+		}
+	}
+	return min(max(cols, 20), 1000), min(max(rows, 3), 1000)
+}
+
+// watchResize redraws at the new size each time the terminal is resized
+func (e *Editor) watchResize() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, sigwinch)
+	for range sigs {
+		cols, rows := terminalSize()
+		e.mu.Lock()
+		e.screenCols, e.screenRows = cols, rows
+		e.adjustScroll()
+		e.draw()
+		e.mu.Unlock()
+	}
+}
+
 func (e *Editor) loadFile(filename string) error {
 	content, err := os.ReadFile(filename)
 	if err != nil {
@@ -94,8 +143,11 @@ func (e *Editor) moveCursor(x, y int) {
 func (e *Editor) draw() {
 	e.clearScreen()
 	
-	// Draw file content
+	// Draw file content. Each row is placed with the cursor rather than
+	// reached with a newline, so a line filling the width can't push the
+	// screen up.
 	for i := 0; i < e.screenRows-2; i++ {
+		e.moveCursor(0, i)
 		lineNum := e.topLine + i
 		if lineNum < len(e.lines) {
 			line := e.lines[lineNum]
@@ -107,13 +159,11 @@ func (e *Editor) draw() {
 			fmt.Print("~")
 		}
 		fmt.Print("\033[K") // Clear to end of line
-		if i < e.screenRows-3 {
-			fmt.Print("\n")
-		}
 	}
 	
 	// Status line
-	fmt.Print("\n\033[7m") // Reverse video
+	e.moveCursor(0, e.screenRows-2)
+	fmt.Print("\033[7m") // Reverse video
 	
 	var modeStr string
 	switch e.mode {
@@ -138,10 +188,12 @@ func (e *Editor) draw() {
 		status = fmt.Sprintf(" %s %s", modeStr, filename)
 	}
 	
-	// Pad status line
-	for len(status) < e.screenCols {
+	// Pad status line, with the ruler at the right edge
+	ruler := fmt.Sprintf("%d,%d", e.cursorY+1, e.cursorX+1)
+	for len(status)+len(ruler) < e.screenCols {
 		status += " "
 	}
+	status += ruler
 	if len(status) > e.screenCols {
 		status = status[:e.screenCols]
 	}
@@ -150,7 +202,7 @@ func (e *Editor) draw() {
 	fmt.Print("\033[0m") // Reset attributes
 	
 	// Message line
-	fmt.Print("\n")
+	e.moveCursor(0, e.screenRows-1)
 	if e.message != "" {
 		msg := e.message
 		if len(msg) > e.screenCols {
@@ -381,6 +433,7 @@ func main() {
 	defer fmt.Print("\033[?25h\033[0m\033[2J\033[H") // Cleanup on exit
 	
 	editor.draw()
+	go editor.watchResize()
 	
 	// Simple main loop
 	var buf [1]byte
@@ -389,6 +442,7 @@ func main() {
 		if n > 0 {
 			ch := buf[0]
 			
+			editor.mu.Lock()
 			switch editor.mode {
 			case NormalMode:
 				editor.processNormalMode(ch)
@@ -399,6 +453,7 @@ func main() {
 			}
 			
 			editor.draw()
+			editor.mu.Unlock()
 		}
 	}
 }
\ No newline at end of file
@@ -0,0 +1,15 @@
+# Starts up like htop: ncurses with keypad, mouse clicks and the scroll
+# wheel, and a hidden cursor. Recorded by TestRecordModeFixtures in
+# internal/terminal; htop itself isn't needed to reproduce its setup.
+import curses
+
+
+def main(screen):
+    curses.mousemask(curses.BUTTON1_RELEASED | curses.BUTTON4_PRESSED | curses.BUTTON5_PRESSED)
+    curses.curs_set(0)
+    screen.addstr(0, 0, "  0[|||     12.5%]   Tasks: 3, 1 running")
+    screen.refresh()
+    curses.napms(5000)
+
+
+curses.wrapper(main)
@@ -0,0 +1,83 @@
+package integration
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// testApps are the programs in test/apps, one main per file
+var testApps = []string{"echo", "menu", "progress", "vim", "kitchen_sink", "size_query"}
+
+// errNoToolchain reports that the test apps can't be built here
+var errNoToolchain = errors.New("go toolchain not available")
+
+var (
+	appsOnce sync.Once
+	appsDir  string
+	appsErr  error
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if appsDir != "" {
+		os.RemoveAll(appsDir)
+	}
+	os.Exit(code)
+}
+
+// buildTestApps builds every test app into a new temporary directory. Each
+// test binary gets its own directory, so packages tested in parallel don't
+// overwrite each other's apps.
+func buildTestApps() (string, error) {
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errNoToolchain, err)
+	}
+
+	dir, err := os.MkdirTemp("", "terminalbridge-apps")
+	if err != nil {
+		return "", err
+	}
+	for _, app := range testApps {
+		build := exec.Command(goBin, "build", "-o", appBinary(dir, app), filepath.Join("..", "apps", app+".go"))
+		if out, err := build.CombinedOutput(); err != nil {
+			return dir, fmt.Errorf("failed to build test app %s: %v\n%s", app, err, out)
+		}
+	}
+	return dir, nil
+}
+
+func appBinary(dir, app string) string {
+	if runtime.GOOS == "windows" {
+		app += ".exe"
+	}
+	return filepath.Join(dir, app)
+}
+
+// TestAppPath returns the path of a built test app, building them all on
+// first use. The test is skipped if there is no Go toolchain to build with.
+func (tf *TestFramework) TestAppPath(name string) string {
+	tf.t.Helper()
+	appsOnce.Do(func() {
+		appsDir, appsErr = buildTestApps()
+	})
+	if errors.Is(appsErr, errNoToolchain) {
+		tf.t.Skipf("Skipping: %v", appsErr)
+	}
+	if appsErr != nil {
+		tf.t.Fatal(appsErr)
+	}
+	return appBinary(appsDir, name)
+}
+
+// LaunchTestApp launches one of the apps in test/apps by name, e.g. "echo"
+func (tf *TestFramework) LaunchTestApp(name string, args ...string) string {
+	tf.t.Helper()
+	return tf.LaunchApp(tf.TestAppPath(name), args)
+}
@@ -18,6 +18,7 @@ import (
 type TestFramework struct {
 	manager  *session.Manager
 	handlers *tools.Handlers
+	registry *tools.Registry // Dispatches CallTool to the handlers
 	t        *testing.T
 }
 
@@ -30,111 +31,100 @@ func NewTestFramework(t *testing.T) *TestFramework {
 	return &TestFramework{
 		manager:  manager,
 		handlers: handlers,
+		registry: handlers.Registry(),
 		t:        t,
 	}
 }
 
 // CallTool simulates calling an MCP tool
 func (tf *TestFramework) CallTool(toolName string, args map[string]interface{}) (map[string]interface{}, error) {
-	ctx := context.Background()
-	
-	// Create proper CallToolRequest
-	request := mcp.CallToolRequest{
-		Request: mcp.Request{
-			// Jsonrpc and Id would normally be set by the protocol layer
-		},
-		Params: mcp.CallToolParams{
-			Name:      toolName,
-			Arguments: args,
-		},
+	_, text, err := tf.callToolText(toolName, args)
+	if err != nil {
+		return nil, err
 	}
 	
-	// Call the appropriate handler
-	var result *mcp.CallToolResult
-	var err error
-	switch toolName {
-	case "launch_app":
-		result, err = tf.handlers.LaunchApp(ctx, request)
-	case "view_screen":
-		result, err = tf.handlers.ViewScreen(ctx, request)
-	case "send_keys":
-		result, err = tf.handlers.SendKeys(ctx, request)
-	case "get_cursor_position":
-		result, err = tf.handlers.GetCursorPosition(ctx, request)
-	case "get_screen_size":
-		result, err = tf.handlers.GetScreenSize(ctx, request)
-	case "resize_terminal":
-		result, err = tf.handlers.ResizeTerminal(ctx, request)
-	case "restart_app":
-		result, err = tf.handlers.RestartApp(ctx, request)
-	case "stop_app":
-		result, err = tf.handlers.StopApp(ctx, request)
-	case "list_sessions":
-		result, err = tf.handlers.ListSessions(ctx, request)
-	default:
-		return nil, fmt.Errorf("unknown tool: %s", toolName)
+	var response map[string]interface{}
+	if err := json.Unmarshal([]byte(text), &response); err != nil {
+		// Some tools return plain text, not JSON
+		response = map[string]interface{}{
+			"content": text,
+		}
 	}
 	
+	return response, nil
+}
+
+// CallToolAs calls a tool and decodes its response into out, one of the
+// response types in the tools package. A tool error result is returned as
+// an error.
+func (tf *TestFramework) CallToolAs(toolName string, args map[string]interface{}, out interface{}) error {
+	result, text, err := tf.callToolText(toolName, args)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if result.IsError {
+		return fmt.Errorf("%s failed: %s", toolName, text)
+	}
+	if err := json.Unmarshal([]byte(text), out); err != nil {
+		return fmt.Errorf("cannot decode %s response %q: %w", toolName, text, err)
+	}
+	return nil
+}
+
+// callToolText calls a tool and returns its result with the result's text
+func (tf *TestFramework) callToolText(toolName string, args map[string]interface{}) (*mcp.CallToolResult, string, error) {
+	result, err := tf.CallToolResult(context.Background(), toolName, args)
+	if err != nil {
+		return nil, "", err
 	}
 	
 	// Extract response from result
 	if len(result.Content) == 0 {
-		return nil, fmt.Errorf("empty response")
+		return nil, "", fmt.Errorf("empty response")
 	}
 	
-	// Parse the JSON response
 	textContent, ok := result.Content[0].(mcp.TextContent)
 	if !ok {
-		return nil, fmt.Errorf("unexpected content type")
+		return nil, "", fmt.Errorf("unexpected content type")
 	}
-	
-	var response map[string]interface{}
-	if err := json.Unmarshal([]byte(textContent.Text), &response); err != nil {
-		// Some tools return plain text, not JSON
-		response = map[string]interface{}{
-			"content": textContent.Text,
-		}
-	}
-	
-	return response, nil
+	return result, textContent.Text, nil
+}
+
+// CallToolResult calls a tool's handler and returns its result unparsed
+func (tf *TestFramework) CallToolResult(ctx context.Context, toolName string, args map[string]interface{}) (*mcp.CallToolResult, error) {
+	return tf.registry.Call(ctx, toolName, args)
 }
 
 // LaunchApp is a helper to launch an app and return session ID
 func (tf *TestFramework) LaunchApp(command string, args []string) string {
-	result, err := tf.CallTool("launch_app", map[string]interface{}{
+	var result tools.LaunchAppResponse
+	err := tf.CallToolAs("launch_app", map[string]interface{}{
 		"command": command,
 		"args":    args,
-	})
+	}, &result)
 	if err != nil {
 		tf.t.Fatalf("Failed to launch app: %v", err)
 	}
 	
-	sessionID, ok := result["session_id"].(string)
-	if !ok {
+	if result.SessionID == "" {
 		tf.t.Fatalf("No session_id in response: %+v", result)
 	}
 	
-	return sessionID
+	return result.SessionID
 }
 
 // ViewScreen is a helper to view screen content
 func (tf *TestFramework) ViewScreen(sessionID string, format string) string {
-	result, err := tf.CallTool("view_screen", map[string]interface{}{
+	var result tools.ViewScreenResponse
+	err := tf.CallToolAs("view_screen", map[string]interface{}{
 		"session_id": sessionID,
 		"format":     format,
-	})
+	}, &result)
 	if err != nil {
 		tf.t.Fatalf("Failed to view screen: %v", err)
 	}
 	
-	content, ok := result["content"].(string)
-	if !ok {
-		tf.t.Fatalf("No content in response: %+v", result)
-	}
-	
-	return content
+	return result.Content
 }
 
 // SendKeys is a helper to send keys
@@ -157,7 +147,7 @@ func (tf *TestFramework) WaitForContent(sessionID string, expected string, timeo
 		if strings.Contains(content, expected) {
 			return true
 		}
-		time.Sleep(100 * time.Millisecond)
+		time.Sleep(waitPollInterval)
 	}
 	
 	return false
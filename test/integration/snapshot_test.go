@@ -0,0 +1,188 @@
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// snapshotDir holds the golden screens compared by AssertScreenSnapshot
+var snapshotDir = filepath.Join("..", "fixtures", "snapshots")
+
+// Scrubber replaces volatile screen content, such as PIDs or timestamps,
+// before a screen is compared with its snapshot
+type Scrubber struct {
+	Pattern *regexp.Regexp
+	Replace string // Replacement, may refer to submatches as in Regexp.ReplaceAllString
+}
+
+// Scrub returns a Scrubber replacing matches of pattern
+func Scrub(pattern, replace string) Scrubber {
+	return Scrubber{Pattern: regexp.MustCompile(pattern), Replace: replace}
+}
+
+// scrubScreen applies scrubbers in order and drops trailing spaces from each
+// line, which the screen pads with but editors tend to strip from fixtures
+func scrubScreen(screen string, scrubbers []Scrubber) string {
+	lines := strings.Split(screen, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	screen = strings.Join(lines, "\n")
+	for _, s := range scrubbers {
+		screen = s.Pattern.ReplaceAllString(screen, s.Replace)
+	}
+	return strings.TrimRight(screen, "\n") + "\n"
+}
+
+// diffLines formats a line diff of want and got: unchanged lines are
+// indented, missing lines start with "-" and unexpected ones with "+"
+func diffLines(want, got string) string {
+	a := strings.Split(strings.TrimSuffix(want, "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+
+	// lcs[i][j] is the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			fmt.Fprintf(&out, "  %s\n", a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(&out, "- %s\n", a[i])
+			i++
+		default:
+			fmt.Fprintf(&out, "+ %s\n", b[j])
+			j++
+		}
+	}
+	return out.String()
+}
+
+// AssertScreenSnapshot compares the session's plain screen with
+// test/fixtures/snapshots/<name>.txt after scrubbing. On a mismatch the
+// actual screen is written to <name>.actual.txt and the test fails with a
+// diff. Run with UPDATE_SNAPSHOTS=1 to rewrite the fixtures instead.
+func (tf *TestFramework) AssertScreenSnapshot(sessionID, name string, scrubbers ...Scrubber) {
+	tf.t.Helper()
+	screen := scrubScreen(tf.ViewScreen(sessionID, "plain"), scrubbers)
+	tf.assertSnapshot(name+".txt", screen)
+}
+
+// AssertScreenSnapshotJSON is AssertScreenSnapshot for the screen lines and
+// cursor position, for layouts where the cursor matters
+func (tf *TestFramework) AssertScreenSnapshotJSON(sessionID, name string, scrubbers ...Scrubber) {
+	tf.t.Helper()
+	result, err := tf.CallTool("view_screen", map[string]interface{}{
+		"session_id": sessionID,
+		"format":     "plain",
+	})
+	if err != nil {
+		tf.t.Fatalf("Failed to view screen: %v", err)
+	}
+	content, _ := result["content"].(string)
+	lines := strings.Split(strings.TrimSuffix(scrubScreen(content, scrubbers), "\n"), "\n")
+	data, err := json.MarshalIndent(map[string]interface{}{
+		"lines":  lines,
+		"cursor": result["cursor"],
+	}, "", "  ")
+	if err != nil {
+		tf.t.Fatalf("Failed to marshal snapshot: %v", err)
+	}
+	tf.assertSnapshot(name+".json", string(data)+"\n")
+}
+
+func (tf *TestFramework) assertSnapshot(file, got string) {
+	tf.t.Helper()
+	path := filepath.Join(snapshotDir, file)
+	ext := filepath.Ext(file)
+	actualPath := filepath.Join(snapshotDir, strings.TrimSuffix(file, ext)+".actual"+ext)
+
+	if os.Getenv("UPDATE_SNAPSHOTS") == "1" {
+		if err := os.MkdirAll(snapshotDir, 0o755); err != nil {
+			tf.t.Fatalf("Failed to create snapshot directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			tf.t.Fatalf("Failed to update snapshot: %v", err)
+		}
+		os.Remove(actualPath)
+		tf.t.Logf("Updated snapshot %s", path)
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		tf.t.Fatalf("Failed to read snapshot: %v", err)
+	}
+	if err == nil && string(want) == got {
+		os.Remove(actualPath)
+		return
+	}
+
+	if err := os.WriteFile(actualPath, []byte(got), 0o644); err != nil {
+		tf.t.Errorf("Failed to write actual screen: %v", err)
+	}
+	if want == nil {
+		tf.t.Fatalf("No snapshot %s; the screen was written to %s. Run with UPDATE_SNAPSHOTS=1 to accept it", path, actualPath)
+	}
+	tf.t.Fatalf("Screen does not match snapshot %s (actual in %s, UPDATE_SNAPSHOTS=1 accepts it):\n%s",
+		path, actualPath, diffLines(string(want), got))
+}
+
+func TestScrubScreen(t *testing.T) {
+	screen := "pid 4182 started   \nat 2025-01-11T10:30:00Z\n\n\n"
+	got := scrubScreen(screen, []Scrubber{
+		Scrub(`pid \d+`, "pid <PID>"),
+		Scrub(`\d{4}-\d\d-\d\dT[\d:]+Z`, "<TIME>"),
+	})
+	want := "pid <PID> started\nat <TIME>\n"
+	if got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	// Submatches can keep part of the line
+	got = scrubScreen("Go Version: go1.24.3", []Scrubber{Scrub(`(Go Version:) \S+`, "$1 <GO>")})
+	if got != "Go Version: <GO>\n" {
+		t.Errorf("Unexpected submatch scrub %q", got)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name      string
+		want, got string
+		diff      string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", "  a\n  b\n"},
+		{"changed", "a\nb\nc\n", "a\nB\nc\n", "  a\n- b\n+ B\n  c\n"},
+		{"added", "a\n", "a\nb\n", "  a\n+ b\n"},
+		{"removed", "a\nb\n", "b\n", "- a\n  b\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffLines(tt.want, tt.got); got != tt.diff {
+				t.Errorf("Expected diff:\n%s\ngot:\n%s", tt.diff, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+//go:build stress && !windows
+
+package integration
+
+import (
+	"context"
+	"flag"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/stress"
+)
+
+// Run with: go test -race -tags stress -run TestStress ./test/integration
+var (
+	stressWorkers  = flag.Int("stress.workers", 8, "Concurrent workers in TestStress")
+	stressDuration = flag.Duration("stress.duration", 10*time.Second, "How long TestStress runs")
+	stressSeed     = flag.Int64("stress.seed", 0, "Seed for TestStress; 0 picks one from the clock")
+)
+
+// TestStress churns sessions from many workers at once, then checks that
+// nothing leaked. Data races fail it too when run with -race.
+func TestStress(t *testing.T) {
+	seed := *stressSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	t.Cleanup(func() {
+		if t.Failed() {
+			t.Logf("Reproduce with: go test -race -tags stress -run TestStress ./test/integration -stress.seed=%d -stress.workers=%d -stress.duration=%s",
+				seed, *stressWorkers, *stressDuration)
+		}
+	})
+
+	tf := NewTestFramework(t)
+	defer tf.Cleanup()
+	baseline := runtime.NumGoroutine()
+
+	report := stress.Run(context.Background(), stress.Config{
+		Workers:  *stressWorkers,
+		Duration: *stressDuration,
+		Seed:     seed,
+	}, tf.CallToolResult)
+	t.Logf("Stress run: %s", report)
+	t.Logf("Calls: %v", report.Calls)
+	t.Logf("Errors: %v", report.Errors)
+
+	if report.Calls["launch_app"] == report.Errors["launch_app"] {
+		t.Fatal("No session was ever launched")
+	}
+
+	if err := stress.CheckSessions(context.Background(), tf.manager, tf.CallToolResult); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := tf.CallTool("stop_all_sessions", map[string]interface{}{}); err != nil {
+		t.Fatalf("Failed to stop sessions: %v", err)
+	}
+	if n := len(tf.manager.ListSessions()); n != 0 {
+		t.Errorf("Expected no sessions after stop_all_sessions, %d left", n)
+	}
+	if err := stress.CheckProcesses(report.PIDs, 5*time.Second); err != nil {
+		t.Error(err)
+	}
+	if err := stress.CheckGoroutines(baseline, 5*time.Second); err != nil {
+		t.Error(err)
+	}
+}
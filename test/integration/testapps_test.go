@@ -1,6 +1,12 @@
 package integration
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -11,7 +17,7 @@ func TestEchoApp(t *testing.T) {
 	defer tf.Cleanup()
 	
 	// Launch the echo test app
-	sessionID := tf.LaunchApp("/Users/bioharz/git/2025/mcp-terminal-tester/test/apps/echo", []string{})
+	sessionID := tf.LaunchTestApp("echo")
 	
 	// Wait for prompt
 	if !tf.WaitForContent(sessionID, "Echo Test Application", 5*time.Second) {
@@ -31,7 +37,7 @@ func TestEchoApp(t *testing.T) {
 	// Test clear command
 	tf.SendKeys(sessionID, "clear")
 	tf.SendKeys(sessionID, "Enter")
-	time.Sleep(200 * time.Millisecond)
+	tf.WaitForRegex(sessionID, "Screen cleared!", 2*time.Second)
 	
 	content := tf.ViewScreen(sessionID, "plain")
 	// After clear, screen should be mostly empty
@@ -45,7 +51,7 @@ func TestEchoApp(t *testing.T) {
 	tf.SendKeys(sessionID, "Enter")
 	
 	// App should terminate
-	time.Sleep(500 * time.Millisecond)
+	tf.WaitForExit(sessionID, 2*time.Second)
 	_, err := tf.CallTool("view_screen", map[string]interface{}{
 		"session_id": sessionID,
 		"format":     "plain",
@@ -55,43 +61,160 @@ func TestEchoApp(t *testing.T) {
 	}
 }
 
+func TestEchoAppExpectScript(t *testing.T) {
+	tf := NewTestFramework(t)
+	defer tf.Cleanup()
+
+	// The whole of TestEchoApp's flow in one call
+	sessionID := tf.LaunchTestApp("echo")
+	result, err := tf.CallTool("run_expect_script", map[string]interface{}{
+		"session_id": sessionID,
+		"steps": []interface{}{
+			map[string]interface{}{"expect": "Echo Test Application"},
+			map[string]interface{}{"send": "Hello World"},
+			map[string]interface{}{"send": "Enter"},
+			map[string]interface{}{"expect": `You typed: (\w+) (\w+)`, "timeout_ms": 2000},
+			map[string]interface{}{"send": "clear"},
+			map[string]interface{}{"send": "Enter"},
+			map[string]interface{}{"expect": "Screen cleared!"},
+			map[string]interface{}{"sleep_ms": 50},
+			map[string]interface{}{"send": "exit"},
+			map[string]interface{}{"send": "Enter"},
+			map[string]interface{}{"expect": "Goodbye!"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("run_expect_script failed: %v", err)
+	}
+	if result["success"] != true {
+		t.Fatalf("Expected the script to succeed, got %+v", result)
+	}
+
+	steps, _ := result["steps"].([]interface{})
+	if len(steps) != 11 {
+		t.Fatalf("Expected 11 step results, got %+v", result["steps"])
+	}
+	typed, _ := steps[3].(map[string]interface{})
+	if typed["match"] != "You typed: Hello World" {
+		t.Errorf("Expected the echo to be matched, got %+v", typed)
+	}
+	if groups, _ := typed["groups"].([]interface{}); len(groups) != 2 || groups[1] != "World" {
+		t.Errorf("Expected the submatches, got %+v", typed["groups"])
+	}
+	sleep, _ := steps[7].(map[string]interface{})
+	if elapsed, _ := sleep["elapsed_ms"].(float64); elapsed < 50 {
+		t.Errorf("Expected the sleep to take 50 ms, got %+v", sleep)
+	}
+
+	tf.WaitForExit(sessionID, 2*time.Second)
+}
+
+func TestEchoAppReadyForInput(t *testing.T) {
+	tf := NewTestFramework(t)
+	defer tf.Cleanup()
+
+	sessionID := tf.LaunchTestApp("echo")
+	if !tf.WaitForContent(sessionID, "------", 5*time.Second) {
+		t.Fatalf("Echo app didn't start properly: %s", tf.ViewScreen(sessionID, "plain"))
+	}
+
+	ready := func(args map[string]interface{}) map[string]interface{} {
+		t.Helper()
+		args["session_id"] = sessionID
+		result, err := tf.CallTool("is_ready_for_input", args)
+		if err != nil {
+			t.Fatalf("is_ready_for_input failed: %v", err)
+		}
+		return result
+	}
+	matched := func(result map[string]interface{}, signal string) interface{} {
+		signals, _ := result["signals"].(map[string]interface{})
+		s, _ := signals[signal].(map[string]interface{})
+		return s["matched"]
+	}
+
+	// Once the output settles the app waits at its "> " prompt
+	time.Sleep(150 * time.Millisecond)
+	result := ready(map[string]interface{}{"idle_ms": 100})
+	if result["ready"] != true || result["confidence"] != float64(1) {
+		t.Fatalf("Expected the echo app to be ready at its prompt, got %+v", result)
+	}
+
+	// Typing is echoed, so the app isn't idle for a second, and the cursor
+	// is no longer right after the prompt
+	tf.SendKeys(sessionID, "hello")
+	tf.WaitForContent(sessionID, "> hello", 2*time.Second)
+	result = ready(map[string]interface{}{"idle_ms": 1000})
+	if result["ready"] != false || matched(result, "idle") != false || matched(result, "prompt") != false {
+		t.Errorf("Expected not ready while echoing, got %+v", result)
+	}
+
+	// A session pattern recognises the prompt with text after it
+	if _, err := tf.CallTool("set_session_option", map[string]interface{}{
+		"session_id": sessionID,
+		"name":       "prompt_pattern",
+		"value":      "^> ",
+	}); err != nil {
+		t.Fatalf("Failed to set prompt_pattern: %v", err)
+	}
+	result = ready(map[string]interface{}{"idle_ms": 0})
+	if result["ready"] != true || matched(result, "prompt") != true {
+		t.Errorf("Expected the session pattern to match, got %+v", result)
+	}
+
+	if _, err := tf.CallTool("is_ready_for_input", map[string]interface{}{
+		"session_id":     sessionID,
+		"prompt_pattern": "(",
+	}); err == nil {
+		t.Error("Expected an invalid prompt_pattern to be rejected")
+	}
+}
+
 func TestMenuApp(t *testing.T) {
 	tf := NewTestFramework(t)
 	defer tf.Cleanup()
 	
 	// Launch the menu test app
-	sessionID := tf.LaunchApp("/Users/bioharz/git/2025/mcp-terminal-tester/test/apps/menu", []string{})
+	sessionID := tf.LaunchTestApp("menu")
 	
-	// Wait for menu to appear
-	if !tf.WaitForContent(sessionID, "Terminal Test Menu System", 5*time.Second) {
+	// Wait for menu to appear, down to the last item
+	if !tf.WaitForContent(sessionID, "Terminal Test Menu System", 5*time.Second) ||
+		!tf.WaitForContent(sessionID, "Exit", 2*time.Second) {
 		content := tf.ViewScreen(sessionID, "plain")
 		t.Fatalf("Menu app didn't start properly: %s", content)
 	}
+	tf.AssertScreenSnapshotJSON(sessionID, "menu_main")
 	
 	// Select first option (Show System Info) - no navigation needed, already at index 0
 	tf.SendKeys(sessionID, "Enter")
 	
 	// Should show system info
-	if !tf.WaitForContent(sessionID, "System Information:", 2*time.Second) {
+	if !tf.WaitForContent(sessionID, "Press any key to continue", 2*time.Second) {
 		content := tf.ViewScreen(sessionID, "plain")
-		t.Errorf("System info display didn't work: %s", content)
+		t.Fatalf("System info display didn't work: %s", content)
 	}
+	tf.AssertScreenSnapshot(sessionID, "menu_system_info",
+		Scrub(`(OS:) \S+`, "$1 <os>"),
+		Scrub(`(Architecture:) \S+`, "$1 <arch>"),
+		Scrub(`(Go Version:) \S+`, "$1 <version>"),
+	)
 	
-	// Press any key to continue
+	// Press any key to continue; keys sent before the menu is back would
+	// be swallowed by that prompt
 	tf.SendKeys(sessionID, "Enter")
-	time.Sleep(100 * time.Millisecond)
+	tf.WaitForRegex(sessionID, "Terminal Test Menu System", 2*time.Second)
 	
-	// Navigate to Exit (index 6 - need to go down 6 times from 0)
+	// Navigate to Exit (index 6 - need to go down 6 times from 0). The menu
+	// reads one key per read, so they can be sent back to back.
 	for i := 0; i < 6; i++ {
 		tf.SendKeys(sessionID, "Down")
-		time.Sleep(50 * time.Millisecond)
 	}
 	
 	// Exit
 	tf.SendKeys(sessionID, "Enter")
 	
 	// App should terminate
-	time.Sleep(500 * time.Millisecond)
+	tf.WaitForExit(sessionID, 2*time.Second)
 	_, err := tf.CallTool("view_screen", map[string]interface{}{
 		"session_id": sessionID,
 		"format":     "plain",
@@ -101,15 +224,72 @@ func TestMenuApp(t *testing.T) {
 	}
 }
 
+func TestLaunchReadyWhen(t *testing.T) {
+	tf := NewTestFramework(t)
+	defer tf.Cleanup()
+
+	// The launch returns once the menu is drawn, so keys can follow at once
+	result, err := tf.CallTool("launch_app", map[string]interface{}{
+		"command":    tf.TestAppPath("menu"),
+		"ready_when": map[string]interface{}{"regex": `Terminal Test Menu System(?s:.*)Exit`, "timeout_ms": 5000},
+	})
+	if err != nil {
+		t.Fatalf("Failed to launch: %v", err)
+	}
+	sessionID, _ := result["session_id"].(string)
+	if result["ready"] != true || result["ready_ms"] == nil {
+		t.Fatalf("Expected the menu ready, got %v", result)
+	}
+	if content := tf.ViewScreen(sessionID, "plain"); !strings.Contains(content, "Exit") {
+		t.Fatalf("Expected the menu on screen right after launch, got %s", content)
+	}
+	tf.SendKeys(sessionID, "Enter")
+	if !tf.WaitForContent(sessionID, "Press any key to continue", 2*time.Second) {
+		t.Errorf("Expected the first key to reach the menu: %s", tf.ViewScreen(sessionID, "plain"))
+	}
+
+	// A condition that never appears keeps the session unless told to abort
+	launch := func(abort bool) map[string]interface{} {
+		t.Helper()
+		result, err := tf.CallTool("launch_app", map[string]interface{}{
+			"command":    "sh",
+			"args":       []string{"-c", "echo started; sleep 10"},
+			"ready_when": map[string]interface{}{"text": "never shown", "timeout_ms": 200, "abort_on_timeout": abort},
+		})
+		if err != nil {
+			t.Fatalf("Failed to launch: %v", err)
+		}
+		return result
+	}
+	result = launch(false)
+	if result["ready"] != false || result["success"] != true {
+		t.Errorf("Expected a live session that isn't ready, got %v", result)
+	}
+	result = launch(true)
+	if result["code"] != "app_not_ready" || !strings.Contains(fmt.Sprint(result["screen"]), "started") {
+		t.Errorf("Expected app_not_ready with the last screen, got %v", result)
+	}
+	if _, err := tf.CallTool("view_screen", map[string]interface{}{"session_id": result["session_id"]}); err == nil {
+		t.Error("Expected the session stopped after aborting")
+	}
+
+	if _, err := tf.CallTool("launch_app", map[string]interface{}{
+		"command":    "sh",
+		"ready_when": map[string]interface{}{"text": "a", "regex": "b"},
+	}); err == nil {
+		t.Error("Expected text and regex together to be rejected")
+	}
+}
+
 func TestProgressApp(t *testing.T) {
 	tf := NewTestFramework(t)
 	defer tf.Cleanup()
 	
 	// Launch the progress test app
-	sessionID := tf.LaunchApp("/Users/bioharz/git/2025/mcp-terminal-tester/test/apps/progress", []string{})
+	sessionID := tf.LaunchTestApp("progress")
 	
-	// Wait a bit and then check what content we get
-	time.Sleep(2 * time.Second)
+	// Wait for a percentage, showing progress is running
+	tf.WaitForRegex(sessionID, `\d+%`, 5*time.Second)
 	content := tf.ViewScreen(sessionID, "plain")
 	t.Logf("Progress app content: %q", content)
 	
@@ -124,20 +304,146 @@ func TestProgressApp(t *testing.T) {
 		t.Error("Raw format should contain ANSI sequences for colors")
 	}
 	
+	// While the spinner runs, both bars are complete and only its frame
+	// and percentage change
+	if !tf.WaitForContent(sessionID, "Loading...", 10*time.Second) {
+		t.Fatalf("Spinner never started: %s", tf.ViewScreen(sessionID, "plain"))
+	}
+	tf.AssertScreenSnapshot(sessionID, "progress_spinner",
+		Scrub(`\S Loading\.\.\. \d+%`, "<frame> Loading... <n>%"),
+	)
+	
 	// Wait for the app to complete (it will exit on its own)
-	timeout := time.Now().Add(30 * time.Second)
-	for time.Now().Before(timeout) {
-		_, err := tf.CallTool("view_screen", map[string]interface{}{
-			"session_id": sessionID,
-			"format":     "plain",
-		})
-		if err != nil && strings.Contains(err.Error(), "session is not active") {
-			// App completed successfully
-			return
+	if code := tf.WaitForExit(sessionID, 30*time.Second); code != 0 {
+		t.Errorf("Progress app exited with code %d", code)
+	}
+	_, err := tf.CallTool("view_screen", map[string]interface{}{
+		"session_id": sessionID,
+		"format":     "plain",
+	})
+	if err == nil || !strings.Contains(err.Error(), "session is not active") {
+		t.Errorf("Expected the finished session to be inactive, got %v", err)
+	}
+}
+
+func TestProgressAppStableScreen(t *testing.T) {
+	tf := NewTestFramework(t)
+	defer tf.Cleanup()
+
+	sessionID := tf.LaunchTestApp("progress")
+	tf.WaitForRegex(sessionID, `\d+%`, 5*time.Second)
+
+	// The bars, spinner and wave each redraw well within a second, so the
+	// screen only holds still for that long once the app is done
+	result, err := tf.CallTool("wait_for_stable_screen", map[string]interface{}{
+		"session_id": sessionID,
+		"stable_ms":  1000,
+		"timeout_ms": 40000,
+		"format":     "plain",
+	})
+	if err != nil {
+		t.Fatalf("wait_for_stable_screen failed: %v", err)
+	}
+	if _, failed := result["code"]; failed {
+		t.Fatalf("Screen never settled: %+v", result)
+	}
+	content, _ := result["content"].(string)
+	if !strings.Contains(content, "All tests completed!") {
+		t.Errorf("Expected the final frame, got:\n%s", content)
+	}
+	if version, _ := result["version"].(float64); version == 0 {
+		t.Errorf("Expected a version, got %+v", result["version"])
+	}
+	sum := sha256.Sum256([]byte(content))
+	if result["hash"] != hex.EncodeToString(sum[:]) {
+		t.Errorf("Hash %v doesn't match the returned content", result["hash"])
+	}
+}
+
+func TestProgressAppFrameCapture(t *testing.T) {
+	tf := NewTestFramework(t)
+	defer tf.Cleanup()
+
+	sessionID := tf.LaunchTestApp("progress")
+	if _, err := tf.CallTool("start_frame_capture", map[string]interface{}{
+		"session_id":  sessionID,
+		"interval_ms": 50,
+	}); err != nil {
+		t.Fatalf("start_frame_capture failed: %v", err)
+	}
+	if _, err := tf.CallTool("start_frame_capture", map[string]interface{}{"session_id": sessionID}); err == nil {
+		t.Error("Expected a second capture on the session to be refused")
+	}
+
+	// The first bar steps by 1% every 20ms; stop once it is done
+	tf.WaitForRegex(sessionID, `2\. Colored Progress Bar`, 10*time.Second)
+	dir := t.TempDir()
+	result, err := tf.CallTool("stop_frame_capture", map[string]interface{}{"session_id": sessionID})
+	if err != nil {
+		t.Fatalf("stop_frame_capture failed: %v", err)
+	}
+	frames, _ := result["frames"].([]interface{})
+	if len(frames) == 0 || result["count"] != float64(len(frames)) {
+		t.Fatalf("Expected frames, got %+v", result)
+	}
+
+	// Every frame shows the bar further along than the one before, until
+	// it reaches 100%
+	bar := regexp.MustCompile(`\] +(\d+)%`)
+	var percents []int
+	var lastElapsed float64 = -1
+	for i, f := range frames {
+		frame := f.(map[string]interface{})
+		elapsed := frame["elapsed_ms"].(float64)
+		if lastElapsed >= 0 && elapsed-lastElapsed < 45 {
+			t.Errorf("Frame %d came %vms after the previous one, under the 50ms interval", i, elapsed-lastElapsed)
+		}
+		lastElapsed = elapsed
+		m := bar.FindStringSubmatch(frame["content"].(string))
+		if m == nil {
+			continue
+		}
+		percent, _ := strconv.Atoi(m[1])
+		percents = append(percents, percent)
+		if percent == 100 {
+			break
+		}
+	}
+	// Capture stops soon after the bar finishes, maybe before the 100% frame
+	if len(percents) < 10 || percents[len(percents)-1] < 90 {
+		t.Fatalf("Expected the bar captured nearly to the end, got %v", percents)
+	}
+	for i := 1; i < len(percents); i++ {
+		if percents[i] <= percents[i-1] {
+			t.Fatalf("Percentages not strictly increasing at frame %d: %v", i, percents)
+		}
+	}
+
+	// Frames can be written to files instead
+	if _, err := tf.CallTool("start_frame_capture", map[string]interface{}{
+		"session_id": sessionID,
+		"max_frames": 3,
+	}); err != nil {
+		t.Fatalf("start_frame_capture failed: %v", err)
+	}
+	tf.WaitForRegex(sessionID, `3\. Spinner Animation`, 10*time.Second)
+	result, err = tf.CallTool("stop_frame_capture", map[string]interface{}{
+		"session_id": sessionID,
+		"dir":        dir,
+	})
+	if err != nil {
+		t.Fatalf("stop_frame_capture failed: %v", err)
+	}
+	frames, _ = result["frames"].([]interface{})
+	if len(frames) != 3 || result["dropped"].(float64) == 0 {
+		t.Fatalf("Expected 3 frames kept and the rest dropped, got %+v", result)
+	}
+	for _, f := range frames {
+		path, _ := f.(map[string]interface{})["path"].(string)
+		if data, err := os.ReadFile(path); err != nil || !strings.Contains(string(data), "Progress") {
+			t.Errorf("Frame file %q: %v", path, err)
 		}
-		time.Sleep(1 * time.Second)
 	}
-	t.Error("Progress app didn't complete within timeout")
 }
 
 func TestAnsiFormatShowsCursor(t *testing.T) {
@@ -146,11 +452,10 @@ func TestAnsiFormatShowsCursor(t *testing.T) {
 	
 	// Launch cat for interactive input
 	sessionID := tf.LaunchApp("cat", []string{})
-	time.Sleep(100 * time.Millisecond)
 	
 	// Type some text without pressing enter
 	tf.SendKeys(sessionID, "Hello")
-	time.Sleep(100 * time.Millisecond)
+	tf.WaitForRegex(sessionID, "Hello", 2*time.Second)
 	
 	// ANSI format should show cursor marker
 	ansiContent := tf.ViewScreen(sessionID, "ansi")
@@ -170,7 +475,7 @@ func TestScrollbackFormat(t *testing.T) {
 	sessionID := tf.LaunchApp("sh", []string{"-c", "for i in $(seq 1 30); do echo Line$i; done; sleep 2"})
 	
 	// Wait for completion
-	time.Sleep(1 * time.Second)
+	tf.WaitForRegex(sessionID, "Line30", 2*time.Second)
 	
 	// Regular view might not show all lines if terminal is small
 	plainContent := tf.ViewScreen(sessionID, "plain")
@@ -190,4 +495,174 @@ func TestScrollbackFormat(t *testing.T) {
 	if !strings.Contains(scrollbackContent, "Line1") {
 		t.Error("Scrollback should contain Line1")
 	}
-}
\ No newline at end of file
+}
+
+// kitchenSinkPhases pairs each kitchen sink phase with the parser feature it
+// depends on. Phases with a pending feature are skipped until the parser
+// supports it; their fixtures already hold the correct final frame.
+var kitchenSinkPhases = []struct {
+	phase   string
+	pending string
+}{
+	{"cursor", ""},
+	{"scroll", "scroll regions"},
+	{"altscreen", "alternate screen"},
+	{"paste", ""},
+	{"mouse", ""},
+	{"wide", "wide characters"},
+}
+
+func TestKitchenSinkPhases(t *testing.T) {
+	for _, tt := range kitchenSinkPhases {
+		t.Run(tt.phase, func(t *testing.T) {
+			if tt.pending != "" {
+				t.Skipf("pending: %s", tt.pending)
+			}
+			tf := NewTestFramework(t)
+			defer tf.Cleanup()
+
+			sessionID := tf.LaunchTestApp("kitchen_sink", "-phases="+tt.phase)
+			if !tf.WaitForContent(sessionID, "kitchen sink done", 10*time.Second) {
+				t.Fatalf("Kitchen sink never finished: %s", tf.ViewScreen(sessionID, "plain"))
+			}
+			tf.AssertScreenSnapshotJSON(sessionID, "kitchen_sink_"+tt.phase)
+		})
+	}
+}
+
+func TestKitchenSinkAll(t *testing.T) {
+	for _, tt := range kitchenSinkPhases {
+		if tt.pending != "" {
+			t.Skipf("pending: %s", tt.pending)
+		}
+	}
+	tf := NewTestFramework(t)
+	defer tf.Cleanup()
+
+	sessionID := tf.LaunchTestApp("kitchen_sink")
+	if !tf.WaitForContent(sessionID, "kitchen sink done", 10*time.Second) {
+		t.Fatalf("Kitchen sink never finished: %s", tf.ViewScreen(sessionID, "plain"))
+	}
+	tf.AssertScreenSnapshotJSON(sessionID, "kitchen_sink_all")
+}
+
+func TestSizeQueryApp(t *testing.T) {
+	tf := NewTestFramework(t)
+	defer tf.Cleanup()
+
+	// The app asks with CSI 18 t and prints the reply it read
+	sessionID := tf.LaunchTestApp("size_query")
+	if !tf.WaitForContent(sessionID, "report 8, 24 x 80", 5*time.Second) {
+		t.Fatalf("Expected a 24x80 size report: %s", tf.ViewScreen(sessionID, "plain"))
+	}
+
+	// After a resize it gets the new size, not 80x24
+	if _, err := tf.CallTool("resize_terminal", map[string]interface{}{
+		"session_id": sessionID,
+		"width":      100,
+		"height":     30,
+	}); err != nil {
+		t.Fatalf("Failed to resize terminal: %v", err)
+	}
+	tf.SendKeys(sessionID, "Enter")
+	if !tf.WaitForContent(sessionID, "report 8, 30 x 100", 5*time.Second) {
+		t.Fatalf("Expected a 30x100 size report: %s", tf.ViewScreen(sessionID, "plain"))
+	}
+}
+
+func TestSizeQueryAppPixels(t *testing.T) {
+	tf := NewTestFramework(t)
+	defer tf.Cleanup()
+
+	sessionID := tf.LaunchTestApp("size_query", "-op=14")
+	if !tf.WaitForContent(sessionID, "report 4, 480 x 800", 5*time.Second) {
+		t.Fatalf("Expected a 480x800 pixel report: %s", tf.ViewScreen(sessionID, "plain"))
+	}
+}
+
+func TestVimAppResize(t *testing.T) {
+	tf := NewTestFramework(t)
+	defer tf.Cleanup()
+
+	// The status line spans the terminal, with the ruler at its right edge
+	sessionID := tf.LaunchTestApp("vim")
+	tf.WaitForRegex(sessionID, `(?m)^ \[No Name\] {67}1,1$`, 5*time.Second)
+
+	if _, err := tf.CallTool("resize_terminal", map[string]interface{}{
+		"session_id": sessionID,
+		"width":      100,
+		"height":     30,
+	}); err != nil {
+		t.Fatalf("Failed to resize terminal: %v", err)
+	}
+
+	// On SIGWINCH the app redraws to the new size: 28 text lines, then the
+	// status line across all 100 columns
+	tf.WaitForRegex(sessionID, `(?m)^ \[No Name\] {87}1,1$`, 5*time.Second)
+	lines := strings.Split(tf.ViewScreen(sessionID, "plain"), "\n")
+	if len(lines) < 29 || len(lines[28]) != 100 || !strings.HasSuffix(lines[28], "1,1") || strings.TrimRight(lines[27], " ") != "~" {
+		t.Errorf("Expected the status line on row 29 across 100 columns, got %q", lines)
+	}
+}
+
+func TestVimAppWaitForCursor(t *testing.T) {
+	tf := NewTestFramework(t)
+	defer tf.Cleanup()
+
+	sessionID := tf.LaunchTestApp("vim")
+	tf.WaitForRegex(sessionID, `\[No Name\] +1,1`, 5*time.Second)
+
+	// Two lines typed in insert mode leave the cursor at the start of the
+	// third
+	tf.SendKeys(sessionID, "i")
+	tf.SendKeys(sessionID, "one")
+	tf.SendKeys(sessionID, "Enter")
+	tf.SendKeys(sessionID, "two")
+	tf.SendKeys(sessionID, "Enter")
+
+	result, err := tf.CallTool("wait_for_cursor", map[string]interface{}{
+		"session_id": sessionID,
+		"row":        2,
+		"col":        0,
+		"visible":    true,
+		"timeout_ms": 5000,
+	})
+	if err != nil {
+		t.Fatalf("wait_for_cursor failed: %v", err)
+	}
+	cursor, _ := result["cursor"].(map[string]interface{})
+	if cursor["row"] != float64(2) || cursor["col"] != float64(0) || cursor["visible"] != true {
+		t.Fatalf("Expected the cursor at row 2, column 0, got %+v", result)
+	}
+	if !strings.Contains(tf.ViewScreen(sessionID, "plain"), "-- INSERT --") {
+		t.Error("Expected the cursor to be reported once insert mode was drawn")
+	}
+
+	// A row alone matches any column
+	result, err = tf.CallTool("wait_for_cursor", map[string]interface{}{
+		"session_id": sessionID,
+		"row":        2,
+		"col":        nil,
+	})
+	if err != nil || result["cursor"] == nil {
+		t.Fatalf("Expected any column on row 2 to match, got %+v (%v)", result, err)
+	}
+
+	// A position the cursor never reaches times out with where it is
+	result, err = tf.CallTool("wait_for_cursor", map[string]interface{}{
+		"session_id": sessionID,
+		"row":        10,
+		"col":        5,
+		"timeout_ms": 200,
+	})
+	if err != nil || result["code"] != "cursor_timeout" {
+		t.Fatalf("Expected cursor_timeout, got %+v (%v)", result, err)
+	}
+	if cursor, _ := result["cursor"].(map[string]interface{}); cursor["row"] != float64(2) {
+		t.Errorf("Expected the last position in the timeout, got %+v", result)
+	}
+
+	if _, err := tf.CallTool("wait_for_cursor", map[string]interface{}{"session_id": sessionID, "row": 24}); err == nil {
+		t.Error("Expected a row below the screen to be rejected")
+	}
+}
@@ -0,0 +1,117 @@
+package integration
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/bioharz/mcp-terminal-tester/internal/tools"
+)
+
+// waitPollInterval is how often the Wait helpers look again
+const waitPollInterval = 25 * time.Millisecond
+
+// waitFor polls check until it reports done, failing the test once timeout
+// passes. check also describes what it last saw, which goes into the failure
+// message together with the session's screen.
+func (tf *TestFramework) waitFor(sessionID string, timeout time.Duration, what string, check func() (bool, string)) {
+	tf.t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		done, seen := check()
+		if done {
+			return
+		}
+		if time.Now().After(deadline) {
+			tf.t.Fatalf("Timed out after %s waiting for %s; last saw %s. Screen:\n%s",
+				timeout, what, seen, tf.lastScreen(sessionID))
+		}
+		time.Sleep(waitPollInterval)
+	}
+}
+
+// lastScreen renders a session's screen straight from its buffer, so it
+// works for failure messages after the process has exited
+func (tf *TestFramework) lastScreen(sessionID string) string {
+	sess, err := tf.manager.GetSession(sessionID)
+	if err != nil {
+		return fmt.Sprintf("<unavailable: %v>", err)
+	}
+	screen, err := sess.Buffer.Render("plain")
+	if err != nil {
+		return fmt.Sprintf("<unavailable: %v>", err)
+	}
+	return screen
+}
+
+// sessionInfo returns get_session_info for a session, or nil and a
+// description of the failure
+func (tf *TestFramework) sessionInfo(sessionID string) (map[string]interface{}, string) {
+	info, err := tf.CallTool("get_session_info", map[string]interface{}{"session_id": sessionID})
+	if err != nil {
+		return nil, fmt.Sprintf("error %q", err)
+	}
+	return info, ""
+}
+
+// WaitForRegex waits for the plain screen to match pattern and returns the
+// match and its submatches
+func (tf *TestFramework) WaitForRegex(sessionID, pattern string, timeout time.Duration) []string {
+	tf.t.Helper()
+	re := regexp.MustCompile(pattern)
+	var match []string
+	tf.waitFor(sessionID, timeout, fmt.Sprintf("screen to match %q", pattern), func() (bool, string) {
+		match = re.FindStringSubmatch(tf.lastScreen(sessionID))
+		return match != nil, "no match"
+	})
+	return match
+}
+
+// WaitForCursor waits for the cursor to reach a 0-based row and column
+func (tf *TestFramework) WaitForCursor(sessionID string, row, col int, timeout time.Duration) {
+	tf.t.Helper()
+	tf.waitFor(sessionID, timeout, fmt.Sprintf("cursor at row %d, col %d", row, col), func() (bool, string) {
+		var pos tools.CursorPosition
+		if err := tf.CallToolAs("get_cursor_position", map[string]interface{}{"session_id": sessionID}, &pos); err != nil {
+			return false, fmt.Sprintf("error %q", err)
+		}
+		return pos.Row == row && pos.Col == col, fmt.Sprintf("row %d, col %d", pos.Row, pos.Col)
+	})
+}
+
+// WaitForSessionState waits for get_session_info to report a state such as
+// "active", "stopped" or "error"
+func (tf *TestFramework) WaitForSessionState(sessionID, state string, timeout time.Duration) {
+	tf.t.Helper()
+	tf.waitFor(sessionID, timeout, fmt.Sprintf("state %q", state), func() (bool, string) {
+		info, failure := tf.sessionInfo(sessionID)
+		if info == nil {
+			return false, failure
+		}
+		return info["state"] == state, fmt.Sprintf("state %q", info["state"])
+	})
+}
+
+// WaitForExit waits for the session's process to exit and for the session
+// to stop being active, and returns the exit code, or -1 if the process was
+// ended by a signal
+func (tf *TestFramework) WaitForExit(sessionID string, timeout time.Duration) int {
+	tf.t.Helper()
+	code := -1
+	tf.waitFor(sessionID, timeout, "process to exit", func() (bool, string) {
+		info, failure := tf.sessionInfo(sessionID)
+		if info == nil {
+			return false, failure
+		}
+		// The exit status arrives before the output reader notices the
+		// closed terminal and deactivates the session
+		if exited, _ := info["exited"].(bool); !exited || info["state"] == "active" {
+			return false, fmt.Sprintf("state %q, exited %v", info["state"], info["exited"])
+		}
+		if c, ok := info["exit_code"].(float64); ok {
+			code = int(c)
+		}
+		return true, ""
+	})
+	return code
+}